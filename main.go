@@ -2,38 +2,318 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"log"
+	"log/slog"
+	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"sort"
 	"strconv"
+	"strings"
 	"syscall"
+	"text/tabwriter"
+	"time"
 
 	"k8s.io/component-base/logs"
+	"k8s.io/klog/v2"
 
+	"sigs.k8s.io/cloud-provider-kind/pkg/config"
 	"sigs.k8s.io/cloud-provider-kind/pkg/controller"
+	"sigs.k8s.io/cloud-provider-kind/pkg/loadbalancer"
+	"sigs.k8s.io/cloud-provider-kind/pkg/manifests"
+	"sigs.k8s.io/cloud-provider-kind/pkg/provider"
 
 	kindcmd "sigs.k8s.io/kind/pkg/cmd"
+	kindlog "sigs.k8s.io/kind/pkg/log"
 )
 
 var (
-	flagV int
+	flagV                   int
+	flagLogFormat           string
+	flagProxyConfigTemplate string
+	flagNodeMetadata        string
+	flagConfigFile          string
+	// jsonLogLevel is the level of the json logs, reloaded with --v from the config file
+	jsonLogLevel slog.LevelVar
 )
 
 func init() {
+	defaults := config.Defaults()
 	flag.IntVar(&flagV, "v", 2, "Verbosity level")
+	flag.StringVar(&flagConfigFile, "config", "", "File with the flags, one NAME=VALUE per line, overridden by the command line. It is reloaded when it changes or on SIGHUP, --v, --loadbalancer-subnet, --capture-image and --concurrency are applied at runtime, the other flags require a restart")
+	flag.StringVar(&flagLogFormat, "log-format", "text", "Log format: text or json")
+	flag.StringVar(&config.DefaultConfig.DevDomain, "dev-domain", defaults.DevDomain, "Give every loadbalancer a name in this domain, ex. *.kind.local, served by the embedded DNS server and with a certificate signed by a generated CA")
+	flag.StringVar(&config.DefaultConfig.StatusHostname, "status-hostname", defaults.StatusHostname, "Report the --dev-domain name of the loadbalancers in the Service status: none, alongside (the IPs) or only")
+	flag.StringVar(&config.DefaultConfig.DNSAddress, "dns-address", defaults.DNSAddress, "Address of the embedded DNS server used by --dev-domain")
+	flag.StringVar(&config.DefaultConfig.ExternalDNSWebhookAddress, "external-dns-webhook-address", defaults.ExternalDNSWebhookAddress, "Serve an external-dns webhook provider on this address so external-dns can program the --dev-domain records, ex. 127.0.0.1:8888")
+	flag.StringVar(&config.DefaultConfig.CADir, "ca-dir", defaults.CADir, "Directory to store the CA used to sign the loadbalancer certificates")
+	flag.IntVar(&config.DefaultConfig.Concurrency, "concurrency", defaults.Concurrency, "Number of Services reconciled in parallel on each cluster")
+	flag.IntVar(&config.DefaultConfig.InitialSyncConcurrency, "initial-sync-concurrency", defaults.InitialSyncConcurrency, "Number of Services reconciled in parallel on each cluster until the Services existing on startup are synced")
+	flag.Float64Var(&config.DefaultConfig.ContainerRuntimeQPS, "container-runtime-qps", defaults.ContainerRuntimeQPS, "Maximum rate of the calls to the container runtime of all the clusters, the calls over the limit wait, 0 does not limit them")
+	flag.IntVar(&config.DefaultConfig.ContainerRuntimeBurst, "container-runtime-burst", defaults.ContainerRuntimeBurst, "Maximum burst of calls to the container runtime allowed over --container-runtime-qps")
+	flag.DurationVar(&config.DefaultConfig.ContainerInspectCacheTTL, "container-inspect-cache-ttl", defaults.ContainerInspectCacheTTL, "Time the inspections of the loadbalancer containers are cached and shared between the Service syncs, the cache is dropped when a container is modified, 0 disables the cache")
+	flag.StringVar(&config.DefaultConfig.WatchNamespaces, "watch-namespace", defaults.WatchNamespaces, "Only provision loadbalancers for the Services in these namespaces, a comma separated list, all the namespaces if empty")
+	flag.StringVar(&config.DefaultConfig.ServiceSelector, "service-selector", defaults.ServiceSelector, "Only provision loadbalancers for the Services matching this label selector, ex. env=dev, all the Services if empty")
+	flag.DurationVar(&config.DefaultConfig.NodeSyncWindow, "node-sync-window", defaults.NodeSyncWindow, "Batch the node updates received during this window and reconfigure the loadbalancers once, 0 disables the batching")
+	flag.StringVar(&config.DefaultConfig.PublishHostAddress, "publish-host-address", defaults.PublishHostAddress, "Publish the Service ports on the container runtime host and report this address, detected automatically for remote docker-in-docker environments")
+	flag.BoolVar(&config.DefaultConfig.PublishRandomPorts, "publish-random-ports", defaults.PublishRandomPorts, "Publish the Service ports on free ports of the container runtime host instead of using the loadbalancer IPs, and report them in the Service status ports, the address reported is --publish-host-address or 127.0.0.1")
+	flag.BoolVar(&config.DefaultConfig.PublishUnready, "publish-unready", defaults.PublishUnready, "Publish the loadbalancer addresses without waiting for at least one upstream node to pass the health checks")
+	flag.StringVar(&config.DefaultConfig.UDPChecksumWorkaround, "udp-checksum-workaround", defaults.UDPChecksumWorkaround, "Disable the tx checksum offload on the loadbalancers to avoid kernels mangling the proxied UDP checksums: auto (only for Services with UDP ports), always or never")
+	flag.StringVar(&config.DefaultConfig.Network, "network", defaults.Network, "Attach the loadbalancers to this container network, by default the networks of each cluster nodes are detected")
+	flag.StringVar(&config.DefaultConfig.LoadBalancerSubnets, "loadbalancer-subnet", defaults.LoadBalancerSubnets, "Allocate the loadbalancer addresses from these subnets of the network, a comma separated list with one subnet per IP family, ex. 172.18.200.0/24 to keep them disjoint from the node addresses")
+	flag.BoolVar(&config.DefaultConfig.AdvertiseAddresses, "advertise-addresses", defaults.AdvertiseAddresses, "Add the addresses allocated from --loadbalancer-subnet as aliases on the loadbalancers and announce them on the network with gratuitous ARP and unsolicited neighbor advertisements")
+	flag.BoolVar(&config.DefaultConfig.FirewallDenyByDefault, "firewall-deny-by-default", defaults.FirewallDenyByDefault, "Drop the traffic to the loadbalancers for ports not declared in the Service, like the cloud providers firewalls, instead of rejecting the connections")
+	flag.StringVar(&config.DefaultConfig.LoadBalancerZones, "loadbalancer-zones", defaults.LoadBalancerZones, "Provision one proxy per zone for each loadbalancer, a comma separated list of zones, ex. kind-region-a,kind-region-b, the Service status has the addresses of all of them and each one sends the traffic to the nodes of its zone")
+	flag.BoolVar(&config.DefaultConfig.TunnelNodePorts, "tunnel-node-ports", defaults.TunnelNodePorts, "On macOS and Windows, also expose the NodePorts of the LoadBalancer Services on localhost through the loadbalancer tunnels")
+	flag.IntVar(&config.DefaultConfig.MaxLoadBalancers, "max-load-balancers", defaults.MaxLoadBalancers, "Maximum number of loadbalancers of each cluster, the Services over the limit stay pending with a QuotaExceeded event and condition like with the cloud quotas, 0 is unlimited")
+	flag.DurationVar(&config.DefaultConfig.ProvisioningLatency, "provisioning-latency", defaults.ProvisioningLatency, "Delay the creation and deletion of the loadbalancers between half and one and a half times this duration, to simulate the slow cloud APIs")
+	flag.Float64Var(&config.DefaultConfig.ProvisioningFailureRate, "provisioning-failure-rate", defaults.ProvisioningFailureRate, "Probability, between 0 and 1, that the creation or deletion of a loadbalancer fails with a simulated cloud API error, to test the retries")
+	flag.IntVar(&config.DefaultConfig.ProxyConcurrency, "proxy-concurrency", defaults.ProxyConcurrency, "Number of worker threads of the envoy proxies, 0 for one per CPU of the host")
+	flag.StringVar(&flagProxyConfigTemplate, "proxy-config-template", "", "Go template file of the envoy bootstrap config, rendered with the same data as the default template printed by render --print-default-template, to add filters without forking the project")
+	flag.BoolVar(&config.DefaultConfig.ValidateProxyConfig, "validate-proxy-config", defaults.ValidateProxyConfig, "Validate the rendered envoy config in the loadbalancer container before applying it, an invalid config is refused and the proxy keeps the last good one")
+	flag.BoolVar(&config.DefaultConfig.Strict, "strict", defaults.Strict, "Refuse to provision the Services with features the loadbalancers can not honor, ex. SCTP ports, reporting them in the LoadBalancerReady condition, instead of provisioning them without the features")
+	flag.BoolVar(&config.DefaultConfig.Prepull, "prepull", defaults.Prepull, "Pull the proxy image on startup for the architecture of the container runtime, retrying on failures, so the first loadbalancer does not wait for the download")
+	flag.StringVar(&config.DefaultConfig.ProxyImageArchive, "proxy-image-archive", defaults.ProxyImageArchive, "Load the proxy image from this tarball, created with docker save, when it is missing instead of pulling it, to work without registry access")
+	flag.BoolVar(&config.DefaultConfig.ProxyImageFromNode, "proxy-image-from-node", defaults.ProxyImageFromNode, "Export the proxy image from the containerd image store of the kind nodes when it is missing instead of pulling it, to work without registry access")
+	flag.StringVar(&config.DefaultConfig.ProxyMemory, "proxy-memory", defaults.ProxyMemory, "Memory limit of the proxy containers, ex. 128m, not limited if empty")
+	flag.StringVar(&config.DefaultConfig.ProxyCPUs, "proxy-cpus", defaults.ProxyCPUs, "CPU limit of the proxy containers, ex. 0.5, not limited if empty")
+	flag.StringVar(&config.DefaultConfig.ProxyMaxHeap, "proxy-max-heap", defaults.ProxyMaxHeap, "Heap size of the envoy overload manager of the proxies, they stop accepting connections close to it, ex. 96m, 80% of --proxy-memory by default")
+	flag.StringVar(&config.DefaultConfig.ProxyBufferLimit, "proxy-buffer-limit", defaults.ProxyBufferLimit, "Limit of the buffers of each connection of the proxies, ex. 32k, the envoy default of 1m if empty")
+	flag.BoolVar(&config.DefaultConfig.IncludeUnreadyNodes, "include-unready-nodes", defaults.IncludeUnreadyNodes, "Send the loadbalancer traffic to the nodes that are not Ready or are unschedulable too")
+	flag.StringVar(&config.DefaultConfig.NodeAddressTypes, "node-address-type", defaults.NodeAddressTypes, "Node address types used as loadbalancer backends in order of preference, a comma separated list of InternalIP, ExternalIP and Hostname, the hostnames are resolved by the proxies")
+	flag.StringVar(&flagNodeMetadata, "node-metadata", "", "YAML file of synthetic instance types, regions, zones and labels assigned to the nodes by name pattern, to test schedulers, autoscaler simulators and cost tools")
+	flag.BoolVar(&config.DefaultConfig.NodeExternalIP, "node-external-ip", defaults.NodeExternalIP, "Publish the container network addresses of the nodes as ExternalIP too, the externalIPs of --node-metadata take precedence")
+	flag.StringVar(&config.DefaultConfig.HealthAddress, "health-address", defaults.HealthAddress, "Address to serve the /healthz and /readyz probes, ex. 127.0.0.1:10298, disabled if empty")
+	flag.StringVar(&config.DefaultConfig.AdminAddress, "admin-address", defaults.AdminAddress, "Address to serve the admin API to inspect, resync and recreate the loadbalancers, ex. 127.0.0.1:10297, disabled if empty, it is not authenticated")
+	flag.StringVar(&config.DefaultConfig.ExternalKubeconfig, "external-kubeconfig", defaults.ExternalKubeconfig, "Also manage the LoadBalancer Services of the cluster of the current context of this kubeconfig, not created by kind but with its nodes in containers, ex. k3d or minikube with the docker driver")
+	flag.StringVar(&config.DefaultConfig.ExternalNodeLabel, "external-node-label", defaults.ExternalNodeLabel, "Label of the node containers of the --external-kubeconfig cluster, KEY=VALUE, the containers are named after the nodes, detected for k3d and minikube if empty")
+	flag.BoolVar(&config.DefaultConfig.K3d, "k3d", defaults.K3d, "Also manage the LoadBalancer Services of the k3d clusters, created with the servicelb of k3s disabled, ex. --k3s-arg \"--disable=servicelb@server:*\"")
+	flag.BoolVar(&config.DefaultConfig.Minikube, "minikube", defaults.Minikube, "Also manage the LoadBalancer Services of the minikube clusters created with the docker or podman driver")
+	flag.StringVar(&config.DefaultConfig.DNSResolvers, "dns-resolvers", defaults.DNSResolvers, "Comma separated list of DNS servers, IP[:PORT], the proxies use to resolve the Hostname backends instead of the container runtime DNS, ex. the address of a LoadBalancer Service of the cluster CoreDNS")
+	flag.StringVar(&config.DefaultConfig.CaptureDir, "capture-dir", defaults.CaptureDir, "Absolute path of the directory of the container runtime host where the packet captures requested with the kind.x-k8s.io/capture annotation are stored, the annotation is ignored if empty")
+	flag.StringVar(&config.DefaultConfig.CaptureImage, "capture-image", defaults.CaptureImage, "Image with tcpdump of the packet capture sidecars of the loadbalancers")
+	flag.StringVar(&config.DefaultConfig.XDSAddress, "xds-address", defaults.XDSAddress, "Address to serve the config of the loadbalancers with the envoy aggregated discovery service, so envoys on other machines proxy the same Services using the loadbalancer container name as node id, ex. 0.0.0.0:18000, disabled if empty, it is not authenticated")
+	flag.DurationVar(&config.DefaultConfig.LoadBalancerStatusInterval, "loadbalancer-status-interval", defaults.LoadBalancerStatusInterval, "Mirror the connection stats of each loadbalancer in a LoadBalancerStatus object, kind.x-k8s.io/v1alpha1, refreshed at this interval, disabled if 0")
+	flag.StringVar(&config.DefaultConfig.ShutdownPolicy, "shutdown-policy", defaults.ShutdownPolicy, "What to do with the loadbalancers on exit: preserve, to leave them running for the next controller instance, or delete")
+	flag.DurationVar(&config.DefaultConfig.ShutdownTimeout, "shutdown-timeout", defaults.ShutdownTimeout, "Time to wait on exit for the Service syncs in flight to finish and publish their status")
+	flag.BoolVar(&config.DefaultConfig.ObserveOnly, "observe-only", defaults.ObserveOnly, "Watch the clusters and report what would be done without mutating the clusters or the containers")
+	flag.StringVar(&config.DefaultConfig.ObserveAddress, "observe-address", defaults.ObserveAddress, "Address to serve the observe-only status and metrics")
 
 	flag.Usage = func() {
-		fmt.Fprint(os.Stderr, "Usage: cloud-provider-kind [options]\n\n")
+		fmt.Fprint(os.Stderr, "Usage: cloud-provider-kind [options]\n")
+		fmt.Fprint(os.Stderr, "       cloud-provider-kind manifests [options]\n")
+		fmt.Fprint(os.Stderr, "       cloud-provider-kind status [options]\n")
+		fmt.Fprint(os.Stderr, "       cloud-provider-kind render [options]\n")
+		fmt.Fprint(os.Stderr, "       cloud-provider-kind load-test [options]\n")
+		fmt.Fprint(os.Stderr, "       cloud-provider-kind list [options]\n")
+		fmt.Fprint(os.Stderr, "       cloud-provider-kind describe [options] <namespace>/<service>\n\n")
 		flag.PrintDefaults()
 	}
 }
 
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "manifests":
+			os.Exit(runManifests(os.Args[2:]))
+		case "status":
+			os.Exit(runStatus(os.Args[2:]))
+		case "render":
+			os.Exit(runRender(os.Args[2:]))
+		case "load-test":
+			os.Exit(runLoadTest(os.Args[2:]))
+		case "list":
+			os.Exit(runList(os.Args[2:]))
+		case "describe":
+			os.Exit(runDescribe(os.Args[2:]))
+		}
+	}
+
 	// Parse command line flags and arguments
 	flag.Parse()
 
+	// the flags of the command line take precedence over the config file
+	commandLine := map[string]bool{}
+	flag.Visit(func(f *flag.Flag) { commandLine[f.Name] = true })
+	var fileValues map[string]string
+	if flagConfigFile != "" {
+		values, err := config.ReadFile(flagConfigFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "invalid --config: %v\n", err)
+			os.Exit(1)
+		}
+		if _, ok := values["config"]; ok {
+			fmt.Fprint(os.Stderr, "invalid --config: the config file can not set --config\n")
+			os.Exit(1)
+		}
+		changes, err := config.Changes(flag.CommandLine, commandLine, nil, values)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "invalid --config: %v\n", err)
+			os.Exit(1)
+		}
+		for _, change := range changes {
+			if err := flag.Set(change.Name, change.Value); err != nil {
+				fmt.Fprintf(os.Stderr, "invalid value %q for --%s in --config: %v\n", change.Value, change.Name, err)
+				os.Exit(1)
+			}
+		}
+		fileValues = values
+	}
+
+	if flagLogFormat != "text" && flagLogFormat != "json" {
+		fmt.Fprintf(os.Stderr, "invalid value %q for --log-format\n", flagLogFormat)
+		os.Exit(1)
+	}
+
+	switch config.DefaultConfig.UDPChecksumWorkaround {
+	case loadbalancer.UDPChecksumWorkaroundAuto, loadbalancer.UDPChecksumWorkaroundAlways, loadbalancer.UDPChecksumWorkaroundNever:
+	default:
+		fmt.Fprintf(os.Stderr, "invalid value %q for --udp-checksum-workaround\n", config.DefaultConfig.UDPChecksumWorkaround)
+		os.Exit(1)
+	}
+
+	switch config.DefaultConfig.StatusHostname {
+	case loadbalancer.StatusHostnameNone:
+	case loadbalancer.StatusHostnameAlongside, loadbalancer.StatusHostnameOnly:
+		if config.DefaultConfig.DevDomain == "" {
+			fmt.Fprintf(os.Stderr, "--status-hostname=%s requires --dev-domain\n", config.DefaultConfig.StatusHostname)
+			os.Exit(1)
+		}
+	default:
+		fmt.Fprintf(os.Stderr, "invalid value %q for --status-hostname\n", config.DefaultConfig.StatusHostname)
+		os.Exit(1)
+	}
+	switch config.DefaultConfig.ShutdownPolicy {
+	case controller.ShutdownPolicyPreserve, controller.ShutdownPolicyDelete:
+	default:
+		fmt.Fprintf(os.Stderr, "invalid value %q for --shutdown-policy\n", config.DefaultConfig.ShutdownPolicy)
+		os.Exit(1)
+	}
+	if _, err := loadbalancer.ParseSubnets(config.DefaultConfig.LoadBalancerSubnets); err != nil {
+		fmt.Fprintf(os.Stderr, "invalid value %q for --loadbalancer-subnet: %v\n", config.DefaultConfig.LoadBalancerSubnets, err)
+		os.Exit(1)
+	}
+	if _, err := loadbalancer.ParseLoadBalancerZones(config.DefaultConfig.LoadBalancerZones); err != nil {
+		fmt.Fprintf(os.Stderr, "invalid value %q for --loadbalancer-zones: %v\n", config.DefaultConfig.LoadBalancerZones, err)
+		os.Exit(1)
+	}
+	if _, err := controller.ParseWatchNamespaces(config.DefaultConfig.WatchNamespaces); err != nil {
+		fmt.Fprintf(os.Stderr, "invalid --watch-namespace: %v\n", err)
+		os.Exit(1)
+	}
+	if _, err := controller.ParseServiceSelector(config.DefaultConfig.ServiceSelector); err != nil {
+		fmt.Fprintf(os.Stderr, "invalid --service-selector: %v\n", err)
+		os.Exit(1)
+	}
+	if config.DefaultConfig.ProvisioningLatency < 0 {
+		fmt.Fprintf(os.Stderr, "invalid value %v for --provisioning-latency\n", config.DefaultConfig.ProvisioningLatency)
+		os.Exit(1)
+	}
+	if rate := config.DefaultConfig.ProvisioningFailureRate; rate < 0 || rate > 1 {
+		fmt.Fprintf(os.Stderr, "invalid value %g for --provisioning-failure-rate, it must be between 0 and 1\n", rate)
+		os.Exit(1)
+	}
+	if config.DefaultConfig.LoadBalancerStatusInterval < 0 {
+		fmt.Fprintf(os.Stderr, "invalid value %v for --loadbalancer-status-interval\n", config.DefaultConfig.LoadBalancerStatusInterval)
+		os.Exit(1)
+	}
+	if config.DefaultConfig.ContainerRuntimeQPS < 0 {
+		fmt.Fprintf(os.Stderr, "invalid value %g for --container-runtime-qps\n", config.DefaultConfig.ContainerRuntimeQPS)
+		os.Exit(1)
+	}
+	if config.DefaultConfig.ContainerRuntimeQPS > 0 && config.DefaultConfig.ContainerRuntimeBurst < 1 {
+		fmt.Fprintf(os.Stderr, "invalid value %d for --container-runtime-burst, it must be at least 1\n", config.DefaultConfig.ContainerRuntimeBurst)
+		os.Exit(1)
+	}
+	if config.DefaultConfig.ContainerInspectCacheTTL < 0 {
+		fmt.Fprintf(os.Stderr, "invalid value %v for --container-inspect-cache-ttl\n", config.DefaultConfig.ContainerInspectCacheTTL)
+		os.Exit(1)
+	}
+	if config.DefaultConfig.MaxLoadBalancers < 0 {
+		fmt.Fprintf(os.Stderr, "invalid value %d for --max-load-balancers\n", config.DefaultConfig.MaxLoadBalancers)
+		os.Exit(1)
+	}
+	if err := validateConcurrency(config.DefaultConfig.Concurrency); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if config.DefaultConfig.InitialSyncConcurrency < 0 {
+		fmt.Fprintf(os.Stderr, "invalid value %d for --initial-sync-concurrency\n", config.DefaultConfig.InitialSyncConcurrency)
+		os.Exit(1)
+	}
+	if config.DefaultConfig.ProxyConcurrency < 0 {
+		fmt.Fprintf(os.Stderr, "invalid value %d for --proxy-concurrency\n", config.DefaultConfig.ProxyConcurrency)
+		os.Exit(1)
+	}
+	if err := loadbalancer.ValidateProxyLimits(config.DefaultConfig.ProxyMemory, config.DefaultConfig.ProxyCPUs); err != nil {
+		fmt.Fprintf(os.Stderr, "invalid proxy limits: %v\n", err)
+		os.Exit(1)
+	}
+	if err := loadbalancer.ValidateProxyMemoryLimits(config.DefaultConfig.ProxyMaxHeap, config.DefaultConfig.ProxyBufferLimit); err != nil {
+		fmt.Fprintf(os.Stderr, "invalid proxy memory limits: %v\n", err)
+		os.Exit(1)
+	}
+	if path := config.DefaultConfig.ProxyImageArchive; path != "" {
+		if _, err := os.Stat(path); err != nil {
+			fmt.Fprintf(os.Stderr, "invalid --proxy-image-archive: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	if flagProxyConfigTemplate != "" {
+		text, err := loadbalancer.LoadProxyConfigTemplate(flagProxyConfigTemplate)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "invalid --proxy-config-template: %v\n", err)
+			os.Exit(1)
+		}
+		config.DefaultConfig.ProxyConfigTemplate = text
+	}
+	if flagNodeMetadata != "" {
+		text, err := provider.LoadNodeMetadata(flagNodeMetadata)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "invalid --node-metadata: %v\n", err)
+			os.Exit(1)
+		}
+		config.DefaultConfig.NodeMetadata = text
+	}
+	if _, err := loadbalancer.ParseNodeAddressTypes(config.DefaultConfig.NodeAddressTypes); err != nil {
+		fmt.Fprintf(os.Stderr, "invalid --node-address-type: %v\n", err)
+		os.Exit(1)
+	}
+	if _, err := loadbalancer.ParseDNSResolvers(config.DefaultConfig.DNSResolvers); err != nil {
+		fmt.Fprintf(os.Stderr, "invalid --dns-resolvers: %v\n", err)
+		os.Exit(1)
+	}
+	if config.DefaultConfig.AdvertiseAddresses && config.DefaultConfig.LoadBalancerSubnets == "" {
+		fmt.Fprint(os.Stderr, "--advertise-addresses requires --loadbalancer-subnet\n")
+		os.Exit(1)
+	}
+	if config.DefaultConfig.ExternalNodeLabel != "" && config.DefaultConfig.ExternalKubeconfig == "" {
+		fmt.Fprint(os.Stderr, "--external-node-label requires --external-kubeconfig\n")
+		os.Exit(1)
+	}
+	if label := config.DefaultConfig.ExternalNodeLabel; label != "" && !strings.Contains(label, "=") {
+		fmt.Fprintf(os.Stderr, "invalid --external-node-label %q: expected KEY=VALUE\n", label)
+		os.Exit(1)
+	}
+	if dir := config.DefaultConfig.CaptureDir; dir != "" && !filepath.IsAbs(dir) {
+		fmt.Fprintf(os.Stderr, "invalid --capture-dir %q: expected an absolute path\n", dir)
+		os.Exit(1)
+	}
+	if config.DefaultConfig.ExternalDNSWebhookAddress != "" && config.DefaultConfig.DevDomain == "" {
+		fmt.Fprint(os.Stderr, "--external-dns-webhook-address requires --dev-domain\n")
+		os.Exit(1)
+	}
+
+	// running as a Pod inside the kind cluster
+	if name := os.Getenv(manifests.ClusterNameEnv); name != "" && os.Getenv("KUBERNETES_SERVICE_HOST") != "" {
+		config.DefaultConfig.InCluster = true
+		config.DefaultConfig.InClusterName = name
+	}
+
 	// trap Ctrl+C and call cancel on the context
 	ctx := context.Background()
 	ctx, cancel := context.WithCancel(ctx)
@@ -57,17 +337,466 @@ func main() {
 	}()
 
 	logger := kindcmd.NewLogger()
+	setVerbosity(logger, flagV)
+	if flagLogFormat == "json" {
+		klog.SetSlogLogger(slog.New(slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{Level: &jsonLogLevel})))
+	}
+	if flagConfigFile != "" {
+		go watchConfigFile(ctx, logger, commandLine, fileValues)
+	}
+	controller.New(logger).Run(ctx)
+}
+
+// setVerbosity sets the verbosity of the kind, klog and json loggers
+func setVerbosity(logger kindlog.Logger, verbosity int) {
 	type verboser interface {
 		SetVerbosity(int)
 	}
 	v, ok := logger.(verboser)
 	if ok {
-		v.SetVerbosity(flagV)
+		v.SetVerbosity(verbosity)
 	}
 
-	_, err := logs.GlogSetter(strconv.Itoa(flagV))
+	_, err := logs.GlogSetter(strconv.Itoa(verbosity))
 	if err != nil {
-		logger.Errorf("error setting klog verbosity to %d : %v", flagV, err)
+		logger.Errorf("error setting klog verbosity to %d : %v", verbosity, err)
 	}
-	controller.New(logger).Run(ctx)
+	// klog filters by verbosity before calling the handler, the V levels are negative slog levels
+	jsonLogLevel.Set(slog.Level(-verbosity))
+}
+
+// watchConfigFile reloads the config file when it changes or on SIGHUP until the context
+// is cancelled, values are the ones of the file on startup
+func watchConfigFile(ctx context.Context, logger kindlog.Logger, commandLine map[string]bool, values map[string]string) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+	modified := make(chan struct{}, 1)
+	go config.WatchFile(ctx, flagConfigFile, 2*time.Second, func() {
+		select {
+		case modified <- struct{}{}:
+		default:
+		}
+	})
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			klog.InfoS("Reloading the config file", "file", flagConfigFile, "reason", "SIGHUP")
+		case <-modified:
+			klog.InfoS("Reloading the config file", "file", flagConfigFile, "reason", "modified")
+		}
+		values = reloadConfigFile(logger, commandLine, values)
+	}
+}
+
+// reloadConfigFile applies the changes of the reloadable flags of the config file and
+// returns its values. The changes of the other flags are logged, they require a restart,
+// and nothing is applied if the file is invalid.
+func reloadConfigFile(logger kindlog.Logger, commandLine map[string]bool, previous map[string]string) map[string]string {
+	values, err := config.ReadFile(flagConfigFile)
+	if err == nil {
+		if _, ok := values["config"]; ok {
+			err = fmt.Errorf("the config file can not set --config")
+		}
+	}
+	var changes []config.FileChange
+	if err == nil {
+		changes, err = config.Changes(flag.CommandLine, commandLine, previous, values)
+	}
+	if err != nil {
+		klog.ErrorS(err, "Invalid config file, keeping the current config", "file", flagConfigFile)
+		return previous
+	}
+	reloaded := []config.FileChange{}
+	for _, change := range changes {
+		if !config.Reloadable[change.Name] {
+			klog.InfoS("Ignoring the change of the config file, the flag requires a restart", "flag", change.Name, "value", change.Value)
+			continue
+		}
+		if err := validateReloadable(change.Name, change.Value); err != nil {
+			klog.ErrorS(err, "Invalid config file, keeping the current config", "file", flagConfigFile)
+			return previous
+		}
+		reloaded = append(reloaded, change)
+	}
+	if len(reloaded) == 0 {
+		return values
+	}
+	config.Update(func(*config.Config) {
+		for _, change := range reloaded {
+			flag.Set(change.Name, change.Value) // nolint:errcheck
+		}
+	})
+	setVerbosity(logger, flagV)
+	for _, change := range reloaded {
+		klog.InfoS("Reloaded flag from the config file", "flag", change.Name, "value", change.Value)
+	}
+	return values
+}
+
+// validateReloadable validates the value of a reloadable flag before applying it, like the
+// validation of the flags on startup
+func validateReloadable(name, value string) error {
+	switch name {
+	case "v":
+		if v, err := strconv.Atoi(value); err != nil || v < 0 {
+			return fmt.Errorf("invalid value %q for --v", value)
+		}
+	case "concurrency":
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("invalid value %q for --concurrency", value)
+		}
+		return validateConcurrency(n)
+	case "loadbalancer-subnet":
+		if _, err := loadbalancer.ParseSubnets(value); err != nil {
+			return fmt.Errorf("invalid value %q for --loadbalancer-subnet: %w", value, err)
+		}
+		if config.DefaultConfig.AdvertiseAddresses && strings.TrimSpace(value) == "" {
+			return fmt.Errorf("--advertise-addresses requires --loadbalancer-subnet")
+		}
+	case "capture-image":
+		if strings.TrimSpace(value) == "" {
+			return fmt.Errorf("--capture-image can not be empty")
+		}
+	}
+	return nil
+}
+
+// validateConcurrency validates --concurrency on startup and when it is reloaded, the
+// Services are not reconciled without workers
+func validateConcurrency(n int) error {
+	if n < 1 {
+		return fmt.Errorf("invalid value %d for --concurrency, it must be at least 1", n)
+	}
+	return nil
+}
+
+// runManifests prints the manifests to run cloud-provider-kind inside a kind cluster
+func runManifests(args []string) int {
+	opts := manifests.Options{}
+	fs := flag.NewFlagSet("manifests", flag.ExitOnError)
+	fs.StringVar(&opts.ClusterName, "name", "kind", "Name of the kind cluster")
+	fs.StringVar(&opts.Namespace, "namespace", manifests.DefaultNamespace, "Namespace to deploy cloud-provider-kind")
+	fs.StringVar(&opts.Image, "image", manifests.DefaultImage, "cloud-provider-kind image")
+	fs.StringVar(&opts.SocketPath, "socket", manifests.DefaultSocketPath, "Path of the container runtime socket on the nodes")
+	fs.IntVar(&opts.Verbosity, "v", 2, "Verbosity level of the controller")
+	fs.Usage = func() {
+		fmt.Fprint(os.Stderr, "Usage: cloud-provider-kind manifests [options]\n\n")
+		fmt.Fprint(os.Stderr, "Print the manifests to run cloud-provider-kind as a Pod inside the kind cluster.\n")
+		fmt.Fprint(os.Stderr, "The kind nodes must mount the container runtime socket of the host.\n\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args) // nolint:errcheck
+
+	if err := manifests.Generate(os.Stdout, opts); err != nil {
+		fmt.Fprintf(os.Stderr, "error generating manifests: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// runStatus prints what a controller running with --observe-only would do
+func runStatus(args []string) int {
+	var address string
+	fs := flag.NewFlagSet("status", flag.ExitOnError)
+	fs.StringVar(&address, "address", config.DefaultObserveAddress, "Address of the controller running with --observe-only")
+	fs.Parse(args) // nolint:errcheck
+
+	client := &http.Client{Timeout: 5 * time.Second}
+	resp, err := client.Get("http://" + address + "/status")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error getting status: %v\n", err)
+		return 1
+	}
+	defer resp.Body.Close()
+	plans := []loadbalancer.Plan{}
+	if err := json.NewDecoder(resp.Body).Decode(&plans); err != nil {
+		fmt.Fprintf(os.Stderr, "error decoding status: %v\n", err)
+		return 1
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "CLUSTER\tNAMESPACE\tSERVICE\tLOADBALANCER\tACTION\tREASON")
+	for _, p := range plans {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", p.Cluster, p.Namespace, p.Service, p.LoadBalancer, p.Action, p.Reason)
+	}
+	w.Flush()
+	return 0
+}
+
+// runRender prints the envoy config of the loadbalancers without creating or modifying them
+func runRender(args []string) int {
+	var clusterName, namespace, templatePath string
+	var printDefaultTemplate bool
+	fs := flag.NewFlagSet("render", flag.ExitOnError)
+	fs.StringVar(&clusterName, "name", "", "Name of the kind cluster, all the clusters if empty")
+	fs.StringVar(&namespace, "namespace", "", "Namespace of the Services, all the namespaces if empty")
+	fs.StringVar(&templatePath, "proxy-config-template", "", "Go template file of the envoy bootstrap config to render instead of the default")
+	fs.BoolVar(&printDefaultTemplate, "print-default-template", false, "Print the default template of the envoy bootstrap config and exit")
+	fs.Usage = func() {
+		fmt.Fprint(os.Stderr, "Usage: cloud-provider-kind render [options]\n\n")
+		fmt.Fprint(os.Stderr, "Print the envoy config of the loadbalancer of each LoadBalancer Service, without\n")
+		fmt.Fprint(os.Stderr, "creating or modifying the loadbalancer containers.\n\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args) // nolint:errcheck
+	if printDefaultTemplate {
+		fmt.Print(loadbalancer.DefaultProxyConfigTemplate())
+		return 0
+	}
+	if templatePath != "" {
+		text, err := loadbalancer.LoadProxyConfigTemplate(templatePath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "invalid --proxy-config-template: %v\n", err)
+			return 1
+		}
+		config.DefaultConfig.ProxyConfigTemplate = text
+	}
+
+	if err := controller.Render(context.Background(), os.Stdout, kindcmd.NewLogger(), clusterName, namespace); err != nil {
+		fmt.Fprintf(os.Stderr, "error rendering the loadbalancers: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// runLoadTest creates synthetic LoadBalancer Services and reports the provisioning latency
+// and the container runtime calls
+func runLoadTest(args []string) int {
+	opts := controller.LoadTestOptions{}
+	fs := flag.NewFlagSet("load-test", flag.ExitOnError)
+	fs.StringVar(&opts.ClusterName, "name", "kind", "Name of the kind cluster")
+	fs.StringVar(&opts.Namespace, "namespace", "cloud-provider-kind-load-test", "Namespace to create the Services")
+	fs.IntVar(&opts.Services, "services", 100, "Number of LoadBalancer Services to create")
+	fs.DurationVar(&opts.Timeout, "timeout", 10*time.Minute, "Time to wait for the loadbalancers to be provisioned and removed")
+	fs.BoolVar(&opts.Cleanup, "cleanup", true, "Delete the namespace and wait for the loadbalancers to be removed")
+	fs.IntVar(&config.DefaultConfig.Concurrency, "concurrency", config.Defaults().Concurrency, "Number of Services reconciled in parallel")
+	fs.Usage = func() {
+		fmt.Fprint(os.Stderr, "Usage: cloud-provider-kind load-test [options]\n\n")
+		fmt.Fprint(os.Stderr, "Run the controller for the cluster, create LoadBalancer Services without backends and\n")
+		fmt.Fprint(os.Stderr, "report the provisioning latency and the container runtime calls. Another instance of\n")
+		fmt.Fprint(os.Stderr, "cloud-provider-kind must not be running for the cluster.\n\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args) // nolint:errcheck
+	if opts.Services <= 0 {
+		fmt.Fprintf(os.Stderr, "invalid value %d for --services\n", opts.Services)
+		return 1
+	}
+	if err := validateConcurrency(config.DefaultConfig.Concurrency); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	// the Services do not have backends, the other options keep their defaults
+	config.DefaultConfig.PublishUnready = true
+
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
+	defer cancel()
+	result, err := controller.LoadTest(ctx, kindcmd.NewLogger(), opts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error running the load test: %v\n", err)
+		return 1
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(w, "Services\t%d\n", result.Services)
+	fmt.Fprintf(w, "Provisioned\t%d\n", len(result.Latencies))
+	fmt.Fprintf(w, "Duration\t%s\n", result.Duration.Round(time.Millisecond))
+	for _, p := range []float64{50, 90, 99, 100} {
+		fmt.Fprintf(w, "Latency p%g\t%s\n", p, result.Percentile(p).Round(time.Millisecond))
+	}
+	if opts.Cleanup {
+		fmt.Fprintf(w, "Cleanup\t%s\n", result.CleanupDuration.Round(time.Millisecond))
+	}
+	fmt.Fprintf(w, "Runtime calls\t%d (%.1f per Service)\n", result.TotalCalls(), float64(result.TotalCalls())/float64(result.Services))
+	methods := []string{}
+	for method := range result.Calls {
+		methods = append(methods, method)
+	}
+	sort.Strings(methods)
+	for _, method := range methods {
+		fmt.Fprintf(w, "  %s\t%d\n", method, result.Calls[method])
+	}
+	w.Flush()
+	if len(result.Latencies) != result.Services {
+		return 1
+	}
+	return 0
+}
+
+// runList prints the loadbalancers with their containers, addresses, ports and health
+func runList(args []string) int {
+	var address, clusterName, output string
+	fs := flag.NewFlagSet("list", flag.ExitOnError)
+	fs.StringVar(&address, "address", "", "Address of the admin API of the controller, the loadbalancer containers are inspected directly if empty")
+	fs.StringVar(&clusterName, "name", "", "Name of the kind cluster, all the clusters if empty")
+	fs.StringVar(&output, "output", "text", "Output format: text or json")
+	fs.Usage = func() {
+		fmt.Fprint(os.Stderr, "Usage: cloud-provider-kind list [options]\n\n")
+		fmt.Fprint(os.Stderr, "List the loadbalancers, from the admin API of the controller running with --admin-address\n")
+		fmt.Fprint(os.Stderr, "or from the labels of the loadbalancer containers.\n\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args) // nolint:errcheck
+	if output != "text" && output != "json" {
+		fmt.Fprintf(os.Stderr, "invalid value %q for --output, must be text or json\n", output)
+		return 1
+	}
+
+	loadBalancers, err := controller.ListLoadBalancers(address, clusterName)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error listing the loadbalancers: %v\n", err)
+		return 1
+	}
+	if output == "json" {
+		return printJSON(loadBalancers)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "CLUSTER\tNAMESPACE\tSERVICE\tCONTAINER\tSTATUS\tIPS\tPORTS\tHEALTHY")
+	for _, lb := range loadBalancers {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n", lb.Cluster, lb.Namespace, lb.Service, lb.Container, lb.Status(),
+			orNone(ips(lb.Description)), orNone(ports(lb.Ports)), healthy(lb.Upstreams))
+	}
+	w.Flush()
+	return 0
+}
+
+// runDescribe prints the loadbalancer of a Service with its proxy config
+func runDescribe(args []string) int {
+	var address, clusterName, output string
+	fs := flag.NewFlagSet("describe", flag.ExitOnError)
+	fs.StringVar(&address, "address", "", "Address of the admin API of the controller, the loadbalancer container is inspected directly if empty")
+	fs.StringVar(&clusterName, "name", "kind", "Name of the kind cluster")
+	fs.StringVar(&output, "output", "text", "Output format: text or json")
+	fs.Usage = func() {
+		fmt.Fprint(os.Stderr, "Usage: cloud-provider-kind describe [options] <namespace>/<service>\n\n")
+		fmt.Fprint(os.Stderr, "Describe the loadbalancer of the Service, from the admin API of the controller running\n")
+		fmt.Fprint(os.Stderr, "with --admin-address or from the loadbalancer container.\n\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args) // nolint:errcheck
+	if output != "text" && output != "json" {
+		fmt.Fprintf(os.Stderr, "invalid value %q for --output, must be text or json\n", output)
+		return 1
+	}
+	namespace, name, ok := strings.Cut(fs.Arg(0), "/")
+	if fs.NArg() != 1 || !ok || namespace == "" || name == "" {
+		fs.Usage()
+		return 1
+	}
+
+	lb, err := controller.DescribeLoadBalancer(address, clusterName, namespace, name)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error describing the loadbalancer: %v\n", err)
+		return 1
+	}
+	if output == "json" {
+		return printJSON(lb)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(w, "Cluster:\t%s\n", lb.Cluster)
+	fmt.Fprintf(w, "Service:\t%s/%s\n", lb.Namespace, lb.Service)
+	fmt.Fprintf(w, "Container:\t%s\n", lb.Container)
+	fmt.Fprintf(w, "Status:\t%s\n", lb.Status())
+	fmt.Fprintf(w, "IPs:\t%s\n", orNone(ips(lb.Description)))
+	fmt.Fprintf(w, "Networks:\t%s\n", orNone(strings.Join(lb.Networks, ",")))
+	fmt.Fprintf(w, "Ports:\t%s\n", orNone(ports(lb.Ports)))
+	if address != "" {
+		fmt.Fprintf(w, "Ingress:\t%s\n", orNone(strings.Join(lb.Ingress, ",")))
+	}
+	fmt.Fprintf(w, "Upstreams:\t%s\n", healthy(lb.Upstreams))
+	upstreams := []string{}
+	for upstream := range lb.Upstreams {
+		upstreams = append(upstreams, upstream)
+	}
+	sort.Strings(upstreams)
+	for _, upstream := range upstreams {
+		health := "unhealthy"
+		if lb.Upstreams[upstream] {
+			health = "healthy"
+		}
+		fmt.Fprintf(w, "  %s\t%s\n", upstream, health)
+	}
+	if lb.LastError != nil {
+		fmt.Fprintf(w, "Last error:\t%s (%s)\n", lb.LastError.Message, lb.LastError.Time.Format(time.RFC3339))
+	}
+	if len(lb.History) > 0 {
+		fmt.Fprintf(w, "History:\n")
+		for _, attempt := range lb.History {
+			result := "succeeded"
+			if attempt.Error != "" {
+				result = "failed: " + attempt.Error
+			}
+			fmt.Fprintf(w, "  %s\t%s\t%s\t%s\t%s\n", attempt.Time.Format(time.RFC3339), attempt.Action, attempt.Duration.Round(time.Millisecond),
+				orNone(strings.Join(attempt.Changes, ",")), result)
+		}
+	}
+	w.Flush()
+	for _, file := range []struct{ name, content string }{
+		{"Bootstrap", lb.Bootstrap},
+		{"Clusters", lb.Clusters},
+		{"Faults", lb.Faults},
+	} {
+		if file.content != "" {
+			fmt.Printf("\n%s:\n%s\n", file.name, strings.TrimRight(file.content, "\n"))
+		}
+	}
+	return 0
+}
+
+func printJSON(v interface{}) int {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		fmt.Fprintf(os.Stderr, "error encoding the output: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+func ips(d loadbalancer.Description) string {
+	addresses := []string{}
+	for _, ip := range []string{d.IPv4, d.IPv6} {
+		if ip != "" {
+			addresses = append(addresses, ip)
+		}
+	}
+	return strings.Join(addresses, ",")
+}
+
+// ports returns the published ports sorted, ex. 80/tcp->32768
+func ports(mappings map[string]string) string {
+	list := []string{}
+	for port, hostPort := range mappings {
+		list = append(list, port+"->"+hostPort)
+	}
+	sort.Strings(list)
+	return strings.Join(list, ",")
+}
+
+// healthy returns the number of upstreams with healthy backends, unknown if the proxy
+// did not report them
+func healthy(upstreams map[string]bool) string {
+	if upstreams == nil {
+		return "unknown"
+	}
+	n := 0
+	for _, ok := range upstreams {
+		if ok {
+			n++
+		}
+	}
+	return fmt.Sprintf("%d/%d", n, len(upstreams))
+}
+
+func orNone(s string) string {
+	if s == "" {
+		return "<none>"
+	}
+	return s
 }
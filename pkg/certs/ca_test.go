@@ -0,0 +1,47 @@
+package certs
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"net"
+	"testing"
+)
+
+func TestCAIssue(t *testing.T) {
+	dir := t.TempDir()
+	ca, err := LoadOrCreateCA(dir)
+	if err != nil {
+		t.Fatalf("LoadOrCreateCA() error = %v", err)
+	}
+	// loading again must return the same CA
+	ca2, err := LoadOrCreateCA(dir)
+	if err != nil {
+		t.Fatalf("LoadOrCreateCA() error = %v", err)
+	}
+	if string(ca.CertificatePEM()) != string(ca2.CertificatePEM()) {
+		t.Fatalf("expected the stored CA to be reused")
+	}
+
+	certPEM, _, err := ca2.Issue([]string{"svc.ns.kind.local"}, []net.IP{net.ParseIP("192.168.8.5")})
+	if err != nil {
+		t.Fatalf("Issue() error = %v", err)
+	}
+	block, _ := pem.Decode(certPEM)
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatalf("invalid certificate: %v", err)
+	}
+
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM(ca.CertificatePEM())
+	for _, name := range []string{"svc.ns.kind.local", "192.168.8.5"} {
+		_, err = cert.Verify(x509.VerifyOptions{DNSName: name, Roots: roots})
+		if err != nil {
+			t.Errorf("certificate not valid for %s: %v", name, err)
+		}
+	}
+	_, err = cert.Verify(x509.VerifyOptions{DNSName: "other.ns.kind.local", Roots: roots})
+	if err == nil {
+		t.Errorf("certificate must not be valid for other names")
+	}
+}
@@ -0,0 +1,222 @@
+// Package config contains the configuration options of cloud-provider-kind,
+// they are populated from the command line flags at startup.
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// DefaultConfig is initialized at startup with the command line flags
+// and it must not be modified after that, except the fields of the
+// Reloadable flags with Update.
+var DefaultConfig = &Config{}
+
+// DefaultObserveAddress is the default address of the observe-only status and metrics
+const DefaultObserveAddress = "127.0.0.1:10299"
+
+// Defaults returns the configuration of the binary run without flags, the defaults of the
+// command line flags. The enum values are the constants of the packages that use them,
+// loadbalancer.StatusHostnameNone, loadbalancer.UDPChecksumWorkaroundAuto and
+// controller.ShutdownPolicyPreserve, they can not be imported here.
+func Defaults() Config {
+	return Config{
+		StatusHostname:           "none",
+		DNSAddress:               "127.0.0.1:5353",
+		CADir:                    defaultCADir(),
+		ObserveAddress:           DefaultObserveAddress,
+		CaptureImage:             "docker.io/nicolaka/netshoot:v0.13",
+		ContainerRuntimeQPS:      20,
+		ContainerRuntimeBurst:    50,
+		ContainerInspectCacheTTL: time.Second,
+		Concurrency:              5,
+		InitialSyncConcurrency:   20,
+		NodeSyncWindow:           2 * time.Second,
+		UDPChecksumWorkaround:    "auto",
+		ValidateProxyConfig:      true,
+		Prepull:                  true,
+		NodeAddressTypes:         "InternalIP",
+		ShutdownPolicy:           "preserve",
+		ShutdownTimeout:          30 * time.Second,
+	}
+}
+
+// defaultCADir returns the directory to store the CA in the user config directory
+func defaultCADir() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		dir = os.TempDir()
+	}
+	return filepath.Join(dir, "cloud-provider-kind")
+}
+
+// Config contains the options that modify the behavior of the controller.
+type Config struct {
+	// InCluster is true when cloud-provider-kind runs as a Pod inside the
+	// cluster it manages, talking to the host container runtime over a
+	// mounted socket.
+	InCluster bool
+	// InClusterName is the name of the kind cluster when running in-cluster.
+	InClusterName string
+	// DevDomain is the domain used to give every loadbalancer a name, ex. kind.local
+	// resolvable by the embedded DNS server and signed by the generated CA.
+	DevDomain string
+	// StatusHostname reports the DevDomain name of the loadbalancers in the Service
+	// status, the values are none, alongside (the IPs) and only
+	StatusHostname string
+	// DNSAddress is the address the embedded DNS server listens on
+	DNSAddress string
+	// ExternalDNSWebhookAddress is the address to serve the external-dns webhook
+	// provider for the embedded DNS server, empty disables it
+	ExternalDNSWebhookAddress string
+	// CADir is the directory where the generated CA is stored
+	CADir string
+	// ObserveOnly watches the clusters and reports what would be done without
+	// mutating the clusters or the containers.
+	ObserveOnly bool
+	// ObserveAddress is the address to serve the observer status and metrics
+	ObserveAddress string
+	// HealthAddress is the address to serve the liveness and readiness probes,
+	// empty disables them
+	HealthAddress string
+	// AdminAddress is the address to serve the admin API to inspect, resync and
+	// recreate the loadbalancers, empty disables it
+	AdminAddress string
+	// ExternalKubeconfig is the kubeconfig of a cluster not created by kind whose nodes
+	// are containers, ex. k3d or minikube with the docker driver, managed like the kind
+	// clusters, empty for none
+	ExternalKubeconfig string
+	// ExternalNodeLabel selects the node containers of the ExternalKubeconfig cluster,
+	// KEY=VALUE, detected for k3d and minikube if empty
+	ExternalNodeLabel string
+	// K3d also manages the k3d clusters, created with the servicelb of k3s disabled
+	K3d bool
+	// Minikube also manages the minikube clusters of the docker and podman drivers
+	Minikube bool
+	// DNSResolvers are the DNS servers the proxies use to resolve the hostname backends, a
+	// comma separated list of IP[:PORT], the resolvers of the proxy containers if empty
+	DNSResolvers string
+	// CaptureDir is the directory of the container runtime host where the packet captures of
+	// the loadbalancers are stored, empty disables the capture annotation
+	CaptureDir string
+	// CaptureImage is the image with tcpdump of the packet capture sidecars
+	CaptureImage string
+	// XDSAddress is the address to serve the config of the loadbalancers to external
+	// envoys with the aggregated discovery service, empty disables it
+	XDSAddress string
+	// LoadBalancerStatusInterval is the interval to refresh the LoadBalancerStatus objects
+	// with the stats of the loadbalancers, 0 disables them
+	LoadBalancerStatusInterval time.Duration
+	// ContainerRuntimeQPS and ContainerRuntimeBurst limit the calls to the container runtime
+	// of all the clusters with a token bucket, 0 QPS does not limit them
+	ContainerRuntimeQPS   float64
+	ContainerRuntimeBurst int
+	// ContainerInspectCacheTTL is the time the inspections of the containers are cached and
+	// shared between the callers, 0 disables the cache
+	ContainerInspectCacheTTL time.Duration
+	// Concurrency is the number of Services reconciled in parallel on each cluster
+	Concurrency int
+	// InitialSyncConcurrency is the number of Services reconciled in parallel on each cluster
+	// until the Services existing on startup are synced once, the cold start creates or
+	// adopts all their loadbalancers at once. Concurrency is used if it is lower.
+	InitialSyncConcurrency int
+	// WatchNamespaces is a comma separated list of the namespaces of the Services that get
+	// a loadbalancer, empty for all the namespaces
+	WatchNamespaces string
+	// ServiceSelector is a label selector of the Services that get a loadbalancer, empty
+	// for all the Services
+	ServiceSelector string
+	// NodeSyncWindow is the window used to batch the node updates, the loadbalancers
+	// are reconfigured once per window instead of once per node event
+	NodeSyncWindow time.Duration
+	// PublishHostAddress is set when the loadbalancers IPs are not reachable from the
+	// controller, the Service ports are published on the container runtime host and
+	// this address is reported in the Service status.
+	PublishHostAddress string
+	// PublishRandomPorts publishes the Service ports on free ports of the container runtime
+	// host, reported in the Service status, instead of the same ports. The PublishHostAddress
+	// is 127.0.0.1 if not set.
+	PublishRandomPorts bool
+	// PublishUnready publishes the loadbalancer status without waiting for the
+	// upstream NodePorts to pass the health checks
+	PublishUnready bool
+	// UDPChecksumWorkaround disables the tx checksum offload on the loadbalancers,
+	// the values are auto, always and never
+	UDPChecksumWorkaround string
+	// Network forces the container network of the loadbalancers, by default they are
+	// attached to the networks of the cluster nodes
+	Network string
+	// FirewallDenyByDefault drops the traffic to the loadbalancers that is not for a
+	// port declared in the Service, like the cloud providers firewalls
+	FirewallDenyByDefault bool
+	// LoadBalancerSubnets is a comma separated list of subnets, one per IP family, the
+	// loadbalancer addresses are allocated from, they must be part of the network subnets
+	LoadBalancerSubnets string
+	// AdvertiseAddresses adds the addresses allocated from the LoadBalancerSubnets as aliases
+	// on the loadbalancers and announces them with gratuitous ARP and neighbor advertisements
+	AdvertiseAddresses bool
+	// LoadBalancerZones is a comma separated list of fake zones, each loadbalancer has a proxy
+	// per zone, with its own addresses in the Service status, that sends the traffic to the
+	// nodes of its zone, empty for a single proxy
+	LoadBalancerZones string
+	// TunnelNodePorts exposes the NodePorts of the LoadBalancer Services on localhost through
+	// the loadbalancer tunnels, on the platforms that run the containers in a VM
+	TunnelNodePorts bool
+	// MaxLoadBalancers limits the loadbalancers of each cluster like the cloud quotas, the
+	// Services over the limit stay pending with the QuotaExceeded reason, 0 is unlimited
+	MaxLoadBalancers int
+	// ProvisioningLatency and ProvisioningFailureRate simulate the slow and flaky cloud
+	// APIs, the loadbalancer operations are delayed around the latency and fail with
+	// the probability, between 0 and 1, to test the retries of the controllers
+	ProvisioningLatency     time.Duration
+	ProvisioningFailureRate float64
+	// ProxyConcurrency is the number of worker threads of the proxies, 0 for the envoy
+	// default of one per CPU
+	ProxyConcurrency int
+	// ProxyMemory and ProxyCPUs limit the resources of the proxy containers, in the format
+	// of the container runtime, ex. 128m and 0.5, empty values do not set a limit
+	ProxyMemory string
+	ProxyCPUs   string
+	// ProxyMaxHeap is the heap size of the overload manager of the proxies, they stop
+	// accepting connections close to it, by default 80% of ProxyMemory if it is set
+	ProxyMaxHeap string
+	// ProxyBufferLimit limits the buffers of each connection of the proxies, empty for the
+	// envoy default of 1m
+	ProxyBufferLimit string
+	// IncludeUnreadyNodes uses the nodes that are not Ready or are unschedulable as
+	// loadbalancer backends
+	IncludeUnreadyNodes bool
+	// NodeAddressTypes is a comma separated list of the node address types used as
+	// loadbalancer backends in order of preference, ex. InternalIP,ExternalIP,Hostname
+	NodeAddressTypes string
+	// ProxyConfigTemplate is the text of a user template of the proxy bootstrap config,
+	// rendered with the same data as the default template, empty for the default
+	ProxyConfigTemplate string
+	// ValidateProxyConfig checks the rendered proxy config with envoy before applying it,
+	// an invalid config is refused and the proxy keeps the last good one
+	ValidateProxyConfig bool
+	// Strict refuses to provision the Services with features the loadbalancers can not
+	// honor, ex. SCTP ports, instead of provisioning them without the features
+	Strict bool
+	// Prepull pulls the proxy image on startup, in the background, for the architecture of
+	// the container runtime host
+	Prepull bool
+	// ProxyImageArchive is the tarball, in the docker save format, the proxy image is
+	// loaded from when it is missing, for the environments without registry access
+	ProxyImageArchive string
+	// ProxyImageFromNode exports the missing proxy image from the containerd image store of
+	// the kind nodes, ex. after it was loaded with kind load docker-image
+	ProxyImageFromNode bool
+	// NodeMetadata is the text of the file of synthetic instance types, regions, zones and
+	// labels assigned to the nodes, empty for the kind-node instance type without topology
+	NodeMetadata string
+	// NodeExternalIP publishes the container network addresses of the nodes as ExternalIP
+	// too, the external IPs of the node metadata take precedence
+	NodeExternalIP bool
+	// ShutdownPolicy is what happens to the loadbalancers when the controller exits, the
+	// values are preserve, to leave them running for the next controller, and delete
+	ShutdownPolicy string
+	// ShutdownTimeout bounds the time waiting for the Service syncs in flight on exit
+	ShutdownTimeout time.Duration
+}
@@ -0,0 +1,147 @@
+package config
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The config file has the flags of the binary, one NAME=VALUE per line without the dashes,
+// the blank lines and the ones starting with # are skipped. The flags of the command line
+// take precedence. The file is reloaded when it changes or on SIGHUP, the Reloadable flags
+// are applied at runtime with Update and the changes of the others are only logged, they
+// require a restart.
+
+// Reloadable are the flags applied at runtime when the config file changes
+var Reloadable = map[string]bool{
+	"v":                   true,
+	"loadbalancer-subnet": true,
+	"capture-image":       true,
+	"concurrency":         true,
+}
+
+var (
+	// reloadLock protects the Reloadable fields of DefaultConfig
+	reloadLock sync.RWMutex
+	// changed is closed and replaced every time DefaultConfig is updated
+	changed = make(chan struct{})
+)
+
+// Get returns a copy of DefaultConfig, the fields of the Reloadable flags must be read with
+// it once the controller is running
+func Get() Config {
+	reloadLock.RLock()
+	defer reloadLock.RUnlock()
+	return *DefaultConfig
+}
+
+// Update modifies DefaultConfig at runtime with the values of the Reloadable flags and
+// notifies the Changed watchers
+func Update(fn func(*Config)) {
+	reloadLock.Lock()
+	defer reloadLock.Unlock()
+	fn(DefaultConfig)
+	close(changed)
+	changed = make(chan struct{})
+}
+
+// Changed returns a channel closed on the next Update
+func Changed() <-chan struct{} {
+	reloadLock.RLock()
+	defer reloadLock.RUnlock()
+	return changed
+}
+
+// ReadFile parses the config file into the values of the flags by name
+func ReadFile(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	values := map[string]string{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		name, value, ok := strings.Cut(line, "=")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" {
+			return nil, fmt.Errorf("%s:%d: expected NAME=VALUE", path, n)
+		}
+		if _, ok := values[name]; ok {
+			return nil, fmt.Errorf("%s:%d: flag %s is set twice", path, n, name)
+		}
+		values[name] = strings.TrimSpace(value)
+	}
+	return values, scanner.Err()
+}
+
+// FileChange is a flag whose value changes with a new config file
+type FileChange struct {
+	Name  string
+	Value string
+}
+
+// Changes returns the flags of the flag set whose values differ from the ones of the config
+// file, sorted by name, the flags removed from the previous file get their default values
+// back. The flags set on the command line are skipped.
+func Changes(fs *flag.FlagSet, commandLine map[string]bool, previous, current map[string]string) ([]FileChange, error) {
+	values := map[string]string{}
+	for name := range previous {
+		if f := fs.Lookup(name); f != nil {
+			values[name] = f.DefValue
+		}
+	}
+	for name, value := range current {
+		if fs.Lookup(name) == nil {
+			return nil, fmt.Errorf("unknown flag %s", name)
+		}
+		values[name] = value
+	}
+	changes := []FileChange{}
+	for name, value := range values {
+		if commandLine[name] || fs.Lookup(name).Value.String() == value {
+			continue
+		}
+		changes = append(changes, FileChange{Name: name, Value: value})
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Name < changes[j].Name })
+	return changes, nil
+}
+
+// WatchFile calls reload every time the modification time or the size of the file change,
+// checking it every interval until the context is cancelled
+func WatchFile(ctx context.Context, path string, interval time.Duration, reload func()) {
+	stat := func() (time.Time, int64) {
+		info, err := os.Stat(path)
+		if err != nil {
+			return time.Time{}, -1
+		}
+		return info.ModTime(), info.Size()
+	}
+	modTime, size := stat()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		t, s := stat()
+		if t.Equal(modTime) && s == size {
+			continue
+		}
+		modTime, size = t, s
+		reload()
+	}
+}
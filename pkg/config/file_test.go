@@ -0,0 +1,133 @@
+package config
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestReadFile(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    map[string]string
+		wantErr bool
+	}{
+		{
+			name:    "flags",
+			content: "# loadbalancers\nloadbalancer-subnet = 172.18.200.0/24\n\nconcurrency=10\ncapture-image=\n",
+			want:    map[string]string{"loadbalancer-subnet": "172.18.200.0/24", "concurrency": "10", "capture-image": ""},
+		},
+		{
+			name:    "value with equal sign",
+			content: "external-node-label=k3d.cluster=dev\n",
+			want:    map[string]string{"external-node-label": "k3d.cluster=dev"},
+		},
+		{
+			name:    "missing value",
+			content: "concurrency\n",
+			wantErr: true,
+		},
+		{
+			name:    "duplicated flag",
+			content: "concurrency=1\nconcurrency=2\n",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "config")
+			if err := os.WriteFile(path, []byte(tt.content), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			got, err := ReadFile(path)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ReadFile() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if diff := cmp.Diff(tt.want, got); !tt.wantErr && diff != "" {
+				t.Errorf("ReadFile() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestChanges(t *testing.T) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.Int("concurrency", 5, "")
+	fs.String("loadbalancer-subnet", "", "")
+	fs.String("capture-image", "netshoot", "")
+	fs.Bool("strict", false, "")
+	if err := fs.Parse([]string{"--strict", "--loadbalancer-subnet=172.18.200.0/24"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Set("capture-image", "tcpdump"); err != nil {
+		t.Fatal(err)
+	}
+	commandLine := map[string]bool{"strict": true}
+
+	tests := []struct {
+		name     string
+		previous map[string]string
+		current  map[string]string
+		want     []FileChange
+		wantErr  bool
+	}{
+		{
+			name:    "changed and unchanged flags",
+			current: map[string]string{"concurrency": "10", "loadbalancer-subnet": "172.18.200.0/24"},
+			want:    []FileChange{{Name: "concurrency", Value: "10"}},
+		},
+		{
+			name:    "command line takes precedence",
+			current: map[string]string{"strict": "false"},
+			want:    []FileChange{},
+		},
+		{
+			name:     "removed flag gets its default",
+			previous: map[string]string{"capture-image": "tcpdump"},
+			current:  map[string]string{},
+			want:     []FileChange{{Name: "capture-image", Value: "netshoot"}},
+		},
+		{
+			name:    "unknown flag",
+			current: map[string]string{"concurency": "10"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Changes(fs, commandLine, tt.previous, tt.current)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Changes() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if diff := cmp.Diff(tt.want, got); !tt.wantErr && diff != "" {
+				t.Errorf("Changes() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	previous := *DefaultConfig
+	t.Cleanup(func() { *DefaultConfig = previous })
+
+	changed := Changed()
+	Update(func(c *Config) { c.Concurrency = 42 })
+	select {
+	case <-changed:
+	case <-time.After(time.Second):
+		t.Fatal("Changed() was not notified of the update")
+	}
+	if got := Get().Concurrency; got != 42 {
+		t.Errorf("Get().Concurrency = %d, want 42", got)
+	}
+	select {
+	case <-Changed():
+		t.Error("Changed() is closed before the next update")
+	default:
+	}
+}
@@ -6,8 +6,144 @@ const (
 	ContainerPrefix = "kindccm"
 	// KIND constants
 	FixedNetworkName = "kind"
+	// KindClusterLabelKey is set by kind on the node containers with the cluster name
+	KindClusterLabelKey = "io.x-k8s.kind.cluster"
 	// NodeCCMLabelKey
 	NodeCCMLabelKey = "io.x-k8s.cloud-provider-kind.cluster"
 	// LoadBalancerNameLabelKey clustername/serviceNamespace/serviceName
 	LoadBalancerNameLabelKey = "io.x-k8s.cloud-provider-kind.loadbalancer.name"
+	// LoadBalancerTransactionLabelKey is set on the loadbalancer containers provisioned as a
+	// transaction, the ones created before do not have the commit marker
+	LoadBalancerTransactionLabelKey = "io.x-k8s.cloud-provider-kind.loadbalancer.transaction"
+	// LoadBalancerStateVersionLabelKey is the version of the state the loadbalancer container
+	// was created with, the ones created before do not have it and are version 0
+	LoadBalancerStateVersionLabelKey = "io.x-k8s.cloud-provider-kind.loadbalancer.state-version"
+	// LoadBalancerAddressesLabelKey is the comma separated list of addresses, with the prefix
+	// length, added as aliases and advertised on the network by the loadbalancer
+	LoadBalancerAddressesLabelKey = "io.x-k8s.cloud-provider-kind.loadbalancer.addresses"
+	// LoadBalancerAllocatedLabelKey is the IPv4 and the IPv6 address, comma separated, allocated
+	// to the loadbalancer by the address allocator, released when the container is deleted
+	LoadBalancerAllocatedLabelKey = "io.x-k8s.cloud-provider-kind.loadbalancer.allocated"
+	// LoadBalancerZoneLabelKey is the zone of the proxy when the loadbalancers have one proxy per
+	// zone, the proxies of the other zones are named after the loadbalancer with the zone suffix
+	LoadBalancerZoneLabelKey = "io.x-k8s.cloud-provider-kind.loadbalancer.zone"
+	// LoadBalancerCaptureLabelKey is the capture annotations of the Service, duration and max
+	// size, set on the packet capture sidecar of the loadbalancer to know if it has to be replaced
+	LoadBalancerCaptureLabelKey = "io.x-k8s.cloud-provider-kind.loadbalancer.capture"
+	// IPFamiliesConditionType is the Service status condition reporting if the
+	// container network supports the IP families of the Service
+	IPFamiliesConditionType = "kind.x-k8s.io/IPFamiliesSupported"
+	// LoadBalancerReadyConditionType is the Service status condition reporting if the
+	// loadbalancer is provisioned, or the reason it is pending
+	LoadBalancerReadyConditionType = "kind.x-k8s.io/LoadBalancerReady"
+	// ManagedByLabelKey is set on the Kubernetes objects created by cloud-provider-kind
+	ManagedByLabelKey   = "app.kubernetes.io/managed-by"
+	ManagedByLabelValue = "cloud-provider-kind"
+	// ListenAddressesAnnotation restricts the addresses the loadbalancer listens on, the value
+	// is a comma separated list of [PORT=]ADDRESS, ex. "0.0.0.0" or "80=127.0.0.1,443=::1"
+	ListenAddressesAnnotation = "kind.x-k8s.io/listen-addresses"
+	// AllowSharedIPAnnotation shares the loadbalancer, and its IP, between the Services of the
+	// same namespace annotated with the same key, as long as their ports do not overlap
+	AllowSharedIPAnnotation = "kind.x-k8s.io/allow-shared-ip"
+	// LoadBalancerCleanupFinalizer is set on the Services with a loadbalancer, it is removed
+	// once the loadbalancer container and its addresses are deleted
+	LoadBalancerCleanupFinalizer = "kind.x-k8s.io/loadbalancer-cleanup"
+	// FaultDelayAnnotation delays the traffic of the loadbalancer to the clients, the value
+	// is a duration, ex. "200ms"
+	FaultDelayAnnotation = "kind.x-k8s.io/fault-delay"
+	// FaultPacketLossAnnotation is the percentage of packets of the loadbalancer to the clients
+	// that are dropped, ex. "5"
+	FaultPacketLossAnnotation = "kind.x-k8s.io/fault-packet-loss"
+	// FaultAbortAnnotation is the percentage of new connections rejected by the loadbalancer,
+	// or of datagrams dropped for UDP, ex. "10"
+	FaultAbortAnnotation = "kind.x-k8s.io/fault-abort"
+	// BandwidthAnnotation caps the throughput of the loadbalancer of the Service in each
+	// direction, the value is a quantity in bits per second, ex. "10M"
+	BandwidthAnnotation = "kind.x-k8s.io/bandwidth"
+	// HealthCheckProtocolAnnotation is the protocol of the health checks of the loadbalancer
+	// backends: http (default) checks the kube-proxy health port of the nodes, grpc uses the
+	// gRPC health checking protocol and tcp a connection on the NodePorts of the TCP ports,
+	// and none disables the health checks
+	HealthCheckProtocolAnnotation = "kind.x-k8s.io/health-check-protocol"
+	// HealthCheckPortAnnotation overrides the port of the HTTP health checks of the backends,
+	// the value is a comma separated list of [SELECTOR=]PORT where the selector is an IP
+	// family, a Service port number or name, or both, ex. "10256,IPv6=10257,IPv4/80=8080"
+	HealthCheckPortAnnotation = "kind.x-k8s.io/health-check-port"
+	// NodeAddressTypeAnnotation overrides the --node-address-type preference order of the node
+	// addresses used as loadbalancer backends, ex. "ExternalIP,InternalIP"
+	NodeAddressTypeAnnotation = "kind.x-k8s.io/node-address-type"
+	// HostNetworkBackendsAnnotation sends the loadbalancer traffic directly to the targetPort of
+	// the nodes, for the Services of hostNetwork Pods, skipping the NodePort, ex. "true"
+	HostNetworkBackendsAnnotation = "kind.x-k8s.io/host-network-backends"
+	// ConnectTimeoutAnnotation is the timeout of the loadbalancer connections to the backends,
+	// the value is a duration, 5s by default
+	ConnectTimeoutAnnotation = "kind.x-k8s.io/connect-timeout"
+	// IdleTimeoutAnnotation closes the loadbalancer connections, or UDP sessions, without
+	// traffic for the duration, "0s" disables the timeout of the TCP connections
+	IdleTimeoutAnnotation = "kind.x-k8s.io/idle-timeout"
+	// MaxConnectionsAnnotation limits the connections of the loadbalancer to the backends of
+	// each Service port, the new connections over the limit are closed
+	MaxConnectionsAnnotation = "kind.x-k8s.io/max-connections"
+	// MaxPendingRequestsAnnotation limits the connections of each Service port waiting for
+	// a connection to the backends
+	MaxPendingRequestsAnnotation = "kind.x-k8s.io/max-pending-requests"
+	// HealthyPanicThresholdAnnotation is the percentage of healthy backends under which the
+	// loadbalancer sends the traffic to all the backends, ignoring the health checks, 50 by
+	// default like envoy, "0" only sends the traffic to the healthy backends
+	HealthyPanicThresholdAnnotation = "kind.x-k8s.io/healthy-panic-threshold"
+	// SlowStartWindowAnnotation ramps up the traffic of the new backends of the loadbalancer
+	// during the duration, ex. "30s", the backends are balanced round robin with it
+	SlowStartWindowAnnotation = "kind.x-k8s.io/slow-start-window"
+	// ListenerFiltersAnnotation is a YAML list of envoy network filters added before the TCP
+	// proxy of the listeners of the TCP ports, ex. a local rate limit filter
+	ListenerFiltersAnnotation = "kind.x-k8s.io/listener-filters"
+	// ClusterOptionsAnnotation is a YAML map of envoy cluster fields added to the clusters of
+	// the Service ports, the fields generated by cloud-provider-kind can not be overridden
+	ClusterOptionsAnnotation = "kind.x-k8s.io/cluster-options"
+	// LoadBalancerContainerAnnotation, LoadBalancerContainerIDAnnotation, LoadBalancerIPAnnotation
+	// and LoadBalancerHostPortsAnnotation are written by cloud-provider-kind on the Services with
+	// the name and ID of the loadbalancer container, its IPs and the host ports it publishes,
+	// ex. "80/tcp=32768,443/tcp=32769", for the tools that need the container resources
+	LoadBalancerContainerAnnotation   = "kind.x-k8s.io/lb-container"
+	LoadBalancerContainerIDAnnotation = "kind.x-k8s.io/lb-container-id"
+	LoadBalancerIPAnnotation          = "kind.x-k8s.io/lb-ip"
+	LoadBalancerHostPortsAnnotation   = "kind.x-k8s.io/lb-host-ports"
+	// AdoptContainerAnnotation is the name of an existing container used as the loadbalancer
+	// of the Service instead of creating one, the container must have the NodeCCMLabelKey
+	// label with the cluster name
+	AdoptContainerAnnotation = "kind.x-k8s.io/adopt-container"
+	// HTTP3Annotation enables HTTP/3 on the loadbalancer: "passthrough" forwards the QUIC
+	// traffic of the UDP ports keeping each client on the same backend, "terminate" terminates
+	// TLS and HTTP on the loadbalancer for the TCP ports, adding an HTTP/3 listener on the same
+	// UDP ports, and sends plain HTTP requests to the backends
+	HTTP3Annotation = "kind.x-k8s.io/http3"
+	// HTTP3TLSSecretAnnotation is the name of the kubernetes.io/tls Secret, in the Service
+	// namespace, with the certificate of the loadbalancer when HTTP/3 is terminated
+	HTTP3TLSSecretAnnotation = "kind.x-k8s.io/http3-tls-secret"
+	// AllowedSourcesAnnotation restricts the clients of the TCP ports of the loadbalancer, like
+	// the security groups of the cloud loadbalancers, the value is a comma separated list of
+	// [PORT=]CIDR where the port is a Service port number or name, ex.
+	// "10.0.0.0/8,443=0.0.0.0/0". The ports without entries do not accept any client.
+	AllowedSourcesAnnotation = "kind.x-k8s.io/allowed-sources"
+	// MirrorAnnotation copies the requests of the ports terminating HTTP to a NodePort, ex.
+	// of a canary Service, the responses of the copies are discarded. The value is a comma
+	// separated list of [PORT=]NODEPORT where the port is a Service port number or name.
+	MirrorAnnotation = "kind.x-k8s.io/mirror"
+	// MirrorPercentAnnotation is the percentage of the requests mirrored, all by default
+	MirrorPercentAnnotation = "kind.x-k8s.io/mirror-percent"
+	// DNSResolversAnnotation are the DNS servers resolving the hostname backends of the
+	// Service, ex. the cluster CoreDNS, the value is a comma separated list of IP[:PORT]
+	DNSResolversAnnotation = "kind.x-k8s.io/dns-resolvers"
+	// CaptureAnnotation captures the traffic of the loadbalancer in a pcap file of the
+	// --capture-dir for this duration, ex. "2m", a new value starts a new capture
+	CaptureAnnotation = "kind.x-k8s.io/capture"
+	// CaptureMaxSizeAnnotation bounds the size of the pcap file, the capture keeps the last
+	// packets when it is full, ex. "50M", 10M by default
+	CaptureMaxSizeAnnotation = "kind.x-k8s.io/capture-max-size"
+	// ExternalBackendsAnnotation sends the traffic of the ports to hosts outside the cluster
+	// instead of the nodes, the value is a comma separated list of [PORT=]HOST:PORT where the
+	// port is a Service port number or name, ex. "db.example.com:5432,metrics=10.0.0.1:9090"
+	ExternalBackendsAnnotation = "kind.x-k8s.io/external-backends"
+	// DevDomainSecretSuffix is appended to the Service name to name the Secret with its certificate
+	DevDomainSecretSuffix = "-kind-tls"
 )
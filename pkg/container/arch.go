@@ -0,0 +1,44 @@
+package container
+
+import (
+	"strings"
+	"sync"
+
+	kindexec "sigs.k8s.io/kind/pkg/exec"
+)
+
+var (
+	architectureOnce sync.Once
+	architecture     string
+)
+
+func (cliRuntime) Architecture() string {
+	architectureOnce.Do(func() {
+		format := "{{.Architecture}}"
+		if containerRuntime == "podman" {
+			format = "{{.Host.Arch}}"
+		}
+		cmd := kindexec.Command(containerRuntime, "info", "--format", format)
+		lines, err := kindexec.OutputLines(cmd)
+		if err != nil || len(lines) == 0 {
+			return
+		}
+		architecture = normalizeArchitecture(lines[0])
+	})
+	return architecture
+}
+
+// normalizeArchitecture returns the GOARCH name of the architecture reported by the info
+// command, docker reports the kernel names and podman the GOARCH names
+func normalizeArchitecture(arch string) string {
+	arch = strings.TrimSpace(arch)
+	switch arch {
+	case "x86_64":
+		return "amd64"
+	case "aarch64", "armv8", "arm64/v8":
+		return "arm64"
+	case "armv7l", "armhf":
+		return "arm"
+	}
+	return arch
+}
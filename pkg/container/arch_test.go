@@ -0,0 +1,25 @@
+package container
+
+import "testing"
+
+func Test_normalizeArchitecture(t *testing.T) {
+	tests := []struct {
+		arch string
+		want string
+	}{
+		{arch: "x86_64", want: "amd64"},
+		{arch: "aarch64\n", want: "arm64"},
+		{arch: "amd64", want: "amd64"},
+		{arch: "arm64", want: "arm64"},
+		{arch: "armv7l", want: "arm"},
+		{arch: "s390x", want: "s390x"},
+		{arch: "", want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.arch, func(t *testing.T) {
+			if got := normalizeArchitecture(tt.arch); got != tt.want {
+				t.Errorf("normalizeArchitecture() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
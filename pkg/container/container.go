@@ -1,10 +1,14 @@
 package container
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
+	"net"
 	"os/exec"
+	"strconv"
 	"strings"
 
 	"k8s.io/klog/v2"
@@ -43,28 +47,32 @@ func init() {
 	}
 }
 
-func Create(name string, args []string) error {
-	if err := exec.Command(containerRuntime, append([]string{"run", "--name", name}, args...)...).Run(); err != nil {
+func (cliRuntime) Create(name string, args []string, command ...string) error {
+	args = append([]string{"run", "--name", name}, args...)
+	if err := exec.Command(containerRuntime, append(args, command...)...).Run(); err != nil {
 		return err
 	}
 	return nil
 }
 
-func Restart(name string) error {
+func (cliRuntime) Restart(name string) error {
 	if err := exec.Command(containerRuntime, []string{"restart", name}...).Run(); err != nil {
 		return err
 	}
 	return nil
 }
 
-func Delete(name string) error {
+func (cliRuntime) Delete(name string) error {
 	if err := exec.Command(containerRuntime, []string{"rm", "-f", name}...).Run(); err != nil {
+		if !Exist(name) {
+			return nil
+		}
 		return err
 	}
 	return nil
 }
 
-func IsRunning(name string) bool {
+func (cliRuntime) IsRunning(name string) bool {
 	cmd := exec.Command(containerRuntime, []string{"ps", "-q", "-f", "name=" + name}...)
 	output, err := cmd.Output()
 	if err != nil || len(output) == 0 {
@@ -73,18 +81,21 @@ func IsRunning(name string) bool {
 	return true
 }
 
-func Exist(name string) bool {
+func (cliRuntime) Exist(name string) bool {
 	err := exec.Command(containerRuntime, []string{"inspect", name}...).Run()
 	return err == nil
 }
 
-func Signal(name string, signal string) error {
+func (cliRuntime) Signal(name string, signal string) error {
 	err := exec.Command(containerRuntime, []string{"kill", "-s", signal, name}...).Run()
 	return err
 }
 
-func Exec(name string, command []string, stdin io.Reader, stdout io.Writer, stderr io.Writer) error {
-	args := []string{"exec", "--privileged"}
+func (cliRuntime) Exec(name string, command []string, stdin io.Reader, stdout io.Writer, stderr io.Writer) error {
+	args := []string{"exec"}
+	if !Rootless() {
+		args = append(args, "--privileged")
+	}
 	if stdin != nil {
 		args = append(args, "-i")
 	}
@@ -103,10 +114,10 @@ func Exec(name string, command []string, stdin io.Reader, stdout io.Writer, stde
 	return cmd.Run()
 }
 
-func IPs(name string) (ipv4 string, ipv6 string, err error) {
+func (cliRuntime) IPs(name string) (ipv4 string, ipv6 string, err error) {
 	// retrieve the IP address of the node using docker inspect
 	cmd := kindexec.Command(containerRuntime, "inspect",
-		"-f", "{{range .NetworkSettings.Networks}}{{.IPAddress}},{{.GlobalIPv6Address}}{{end}}",
+		"-f", "{{.HostConfig.NetworkMode}}{{range $k, $v := .NetworkSettings.Networks}} {{$k}},{{$v.IPAddress}},{{$v.GlobalIPv6Address}}{{end}}",
 		name, // ... against the "node" container
 	)
 	lines, err := kindexec.OutputLines(cmd)
@@ -116,15 +127,88 @@ func IPs(name string) (ipv4 string, ipv6 string, err error) {
 	if len(lines) != 1 {
 		return "", "", fmt.Errorf("file should only be one line, got %d lines: %w", len(lines), err)
 	}
-	ips := strings.Split(lines[0], ",")
-	if len(ips) != 2 {
-		return "", "", fmt.Errorf("container addresses should have 2 values, got %d values", len(ips))
+	return parseIPs(lines[0])
+}
+
+// parseIPs parses the output of the inspect template used by IPs, the network mode
+// followed by the network name and addresses of each network.
+func parseIPs(line string) (ipv4 string, ipv6 string, err error) {
+	fields := strings.Fields(line)
+	if len(fields) < 2 {
+		return "", "", fmt.Errorf("container is not attached to any network")
+	}
+	mode, networks := fields[0], fields[1:]
+	selected := networks[0]
+	for _, network := range networks {
+		if strings.HasPrefix(network, mode+",") {
+			selected = network
+			break
+		}
+	}
+	ips := strings.Split(selected, ",")
+	if len(ips) != 3 {
+		return "", "", fmt.Errorf("container addresses should have 2 values, got %d values", len(ips)-1)
+	}
+	return ips[1], ips[2], nil
+}
+
+func (cliRuntime) Networks(name string) ([]string, error) {
+	cmd := kindexec.Command(containerRuntime, "inspect",
+		"--format", `{{range $k, $v := .NetworkSettings.Networks}}{{$k}} {{end}}`,
+		name,
+	)
+	lines, err := kindexec.OutputLines(cmd)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get container details: %w", err)
+	}
+	if len(lines) != 1 {
+		return nil, fmt.Errorf("file should only be one line, got %d lines", len(lines))
 	}
-	return ips[0], ips[1], nil
+	return strings.Fields(lines[0]), nil
 }
 
 // return a list with the map of the internal port to the external port
 func PortMaps(name string) (map[string]string, error) {
+	portMappings, err := PortMappings(name)
+	if err != nil {
+		return nil, err
+	}
+
+	result := map[string]string{}
+	for k, hostPort := range portMappings {
+		protocol := "tcp"
+		parts := strings.Split(k, "/")
+		if len(parts) == 2 {
+			protocol = strings.ToLower(parts[1])
+		}
+		if protocol != "tcp" {
+			klog.Infof("skipping protocol %s not supported, only TCP", protocol)
+			continue
+		}
+		result[parts[0]] = hostPort
+	}
+	return result, nil
+}
+
+// HostPorts returns the host ports the container ports are published on, the key is the
+// container port and the protocol, ex. 80/tcp
+func HostPorts(name string) (map[string]int, error) {
+	portMappings, err := PortMappings(name)
+	if err != nil {
+		return nil, err
+	}
+	result := map[string]int{}
+	for k, hostPort := range portMappings {
+		port, err := strconv.Atoi(hostPort)
+		if err != nil {
+			return nil, fmt.Errorf("invalid host port %q for %s: %w", hostPort, k, err)
+		}
+		result[strings.ToLower(k)] = port
+	}
+	return result, nil
+}
+
+func (cliRuntime) PortMappings(name string) (map[string]string, error) {
 	// retrieve the IP address of the node using docker inspect
 	cmd := kindexec.Command(containerRuntime, "inspect",
 		"-f", "{{ json .NetworkSettings.Ports }}",
@@ -138,34 +222,28 @@ func PortMaps(name string) (map[string]string, error) {
 	if len(lines) != 1 {
 		return nil, fmt.Errorf("file should only be one line, got %d lines: %w", len(lines), err)
 	}
+	return parsePortMappings(lines[0])
+}
 
+// parsePortMappings returns the first host port of each published container port
+func parsePortMappings(line string) (map[string]string, error) {
 	type portMapping struct {
 		HostPort string `json:"HostPort"`
 		HostIP   string `json:"HostIp"`
 	}
 
 	portMappings := make(map[string][]portMapping)
-	err = json.Unmarshal([]byte(lines[0]), &portMappings)
+	err := json.Unmarshal([]byte(line), &portMappings)
 	if err != nil {
 		return nil, err
 	}
 
 	result := map[string]string{}
 	for k, v := range portMappings {
-		protocol := "tcp"
-		parts := strings.Split(k, "/")
-		if len(parts) == 2 {
-			protocol = strings.ToLower(parts[1])
-		}
-		if protocol != "tcp" {
-			klog.Infof("skipping protocol %s not supported, only TCP", protocol)
-			continue
-		}
-
 		// TODO we just can get the first entry or look for ip families
 		for _, pm := range v {
 			if pm.HostPort != "" {
-				result[parts[0]] = pm.HostPort
+				result[k] = pm.HostPort
 				break
 			}
 		}
@@ -173,7 +251,7 @@ func PortMaps(name string) (map[string]string, error) {
 	return result, nil
 }
 
-func ListByLabel(label string) ([]string, error) {
+func (cliRuntime) ListByLabel(label string) ([]string, error) {
 	cmd := kindexec.Command(containerRuntime,
 		"ps",
 		"-a", // show stopped nodes
@@ -186,9 +264,7 @@ func ListByLabel(label string) ([]string, error) {
 	return lines, err
 }
 
-// GetLabelValue return the value of the associated label
-// It returns an error if the label value does not exist
-func GetLabelValue(name string, label string) (string, error) {
+func (cliRuntime) GetLabelValue(name string, label string) (string, error) {
 	cmd := kindexec.Command(containerRuntime,
 		"inspect",
 		"--format", fmt.Sprintf(`{{ index .Config.Labels "%s"}}`, label),
@@ -203,3 +279,229 @@ func GetLabelValue(name string, label string) (string, error) {
 	}
 	return lines[0], nil
 }
+
+func (cliRuntime) ListLabelValues(label string, key string) (map[string]string, error) {
+	cmd := kindexec.Command(containerRuntime,
+		"ps",
+		"-a",
+		"--filter", "label="+label,
+		"--format", fmt.Sprintf(`{{.ID}}\t{{.Label "%s"}}`, key),
+	)
+	lines, err := kindexec.OutputLines(cmd)
+	if err != nil {
+		return nil, err
+	}
+	return parseLabelValues(lines), nil
+}
+
+// parseLabelValues parses the ps output with the container ID and the label value
+// separated by a tab
+func parseLabelValues(lines []string) map[string]string {
+	values := map[string]string{}
+	for _, line := range lines {
+		id, value, _ := strings.Cut(line, "\t")
+		if id == "" {
+			continue
+		}
+		values[id] = value
+	}
+	return values
+}
+
+func (cliRuntime) Image(name string) (string, error) {
+	cmd := kindexec.Command(containerRuntime, "inspect", "--format", "{{.Config.Image}}", name)
+	lines, err := kindexec.OutputLines(cmd)
+	if err != nil {
+		return "", err
+	}
+	if len(lines) != 1 {
+		return "", fmt.Errorf("expected 1 line, got %d", len(lines))
+	}
+	return lines[0], nil
+}
+
+func (cliRuntime) ID(name string) (string, error) {
+	cmd := kindexec.Command(containerRuntime, "inspect", "--format", "{{.Id}}", name)
+	lines, err := kindexec.OutputLines(cmd)
+	if err != nil {
+		return "", err
+	}
+	if len(lines) != 1 {
+		return "", fmt.Errorf("expected 1 line, got %d", len(lines))
+	}
+	return lines[0], nil
+}
+
+func (cliRuntime) RunInNetNS(name string, image string, command []string) ([]string, error) {
+	args := append([]string{"run", "--rm"}, privilegeArgs()...)
+	args = append(args, "--net", "container:"+name, "--entrypoint", command[0], image)
+	args = append(args, command[1:]...)
+	cmd := kindexec.Command(containerRuntime, args...)
+	return kindexec.OutputLines(cmd)
+}
+
+// NetworkIPFamilies returns the IP families with a subnet configured on the network
+func NetworkIPFamilies(network string) (ipv4 bool, ipv6 bool, err error) {
+	subnets, err := NetworkSubnets(network)
+	if err != nil {
+		return false, false, err
+	}
+	for _, subnet := range subnets {
+		ip, _, err := net.ParseCIDR(subnet)
+		if err != nil {
+			continue
+		}
+		if ip.To4() != nil {
+			ipv4 = true
+		} else {
+			ipv6 = true
+		}
+	}
+	return ipv4, ipv6, nil
+}
+
+func (cliRuntime) NetworkSubnets(network string) ([]string, error) {
+	out, err := exec.Command(containerRuntime, "network", "inspect", network).Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to inspect network %s: %w", network, err)
+	}
+	subnets, _, err := networkSubnets(out)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse network %s: %w", network, err)
+	}
+	return subnets, nil
+}
+
+func (cliRuntime) NetworkAddresses(network string) ([]string, error) {
+	out, err := exec.Command(containerRuntime, "network", "inspect", network).Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to inspect network %s: %w", network, err)
+	}
+	_, addresses, err := networkSubnets(out)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse network %s: %w", network, err)
+	}
+	containers, err := kindexec.OutputLines(kindexec.Command(containerRuntime, "ps", "--all", "--quiet", "--filter", "network="+network))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list the containers of network %s: %w", network, err)
+	}
+	if len(containers) == 0 {
+		return addresses, nil
+	}
+	args := []string{"inspect", "-f", "{{range $k, $v := .NetworkSettings.Networks}} {{$k}},{{$v.IPAddress}},{{$v.GlobalIPv6Address}}{{end}}"}
+	lines, err := kindexec.OutputLines(kindexec.Command(containerRuntime, append(args, containers...)...))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the addresses of the containers of network %s: %w", network, err)
+	}
+	for _, line := range lines {
+		addresses = append(addresses, containerNetworkAddresses(line, network)...)
+	}
+	return addresses, nil
+}
+
+// containerNetworkAddresses returns the addresses on the network of the output of the
+// inspect template used by NetworkAddresses, the network name and addresses of each network.
+func containerNetworkAddresses(line string, network string) []string {
+	addresses := []string{}
+	for _, field := range strings.Fields(line) {
+		values := strings.Split(field, ",")
+		if len(values) != 3 || values[0] != network {
+			continue
+		}
+		for _, address := range values[1:] {
+			if address != "" {
+				addresses = append(addresses, address)
+			}
+		}
+	}
+	return addresses
+}
+
+// networkSubnets returns the subnets and the gateways of the network inspect output,
+// docker and podman use different formats.
+func networkSubnets(data []byte) (subnets []string, gateways []string, err error) {
+	var networks []struct {
+		// docker
+		IPAM struct {
+			Config []struct {
+				Subnet  string `json:"Subnet"`
+				Gateway string `json:"Gateway"`
+			} `json:"Config"`
+		} `json:"IPAM"`
+		// podman
+		Subnets []struct {
+			Subnet  string `json:"subnet"`
+			Gateway string `json:"gateway"`
+		} `json:"subnets"`
+	}
+	if err := json.Unmarshal(data, &networks); err != nil {
+		return nil, nil, err
+	}
+	if len(networks) != 1 {
+		return nil, nil, fmt.Errorf("expected 1 network, got %d", len(networks))
+	}
+	subnets = []string{}
+	gateways = []string{}
+	for _, c := range networks[0].IPAM.Config {
+		subnets = append(subnets, c.Subnet)
+		if c.Gateway != "" {
+			gateways = append(gateways, c.Gateway)
+		}
+	}
+	for _, s := range networks[0].Subnets {
+		subnets = append(subnets, s.Subnet)
+		if s.Gateway != "" {
+			gateways = append(gateways, s.Gateway)
+		}
+	}
+	return subnets, gateways, nil
+}
+
+func (c cliRuntime) EnsureImage(image string) error {
+	if c.ImageExists(image) {
+		return nil
+	}
+	out, err := exec.Command(containerRuntime, "pull", image).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("failed to pull image %s: %w: %s", image, err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+func (cliRuntime) ImageExists(image string) bool {
+	return exec.Command(containerRuntime, "image", "inspect", image).Run() == nil
+}
+
+func (cliRuntime) LoadImage(archive io.Reader) error {
+	cmd := exec.Command(containerRuntime, "load")
+	cmd.Stdin = archive
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("failed to load image archive: %w: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+func (cliRuntime) PullImage(ctx context.Context, image string, platform string, progress io.Writer) error {
+	args := []string{"pull"}
+	if platform != "" {
+		args = append(args, "--platform", platform)
+	}
+	args = append(args, image)
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, containerRuntime, args...)
+	cmd.Stdout = progress
+	cmd.Stderr = io.MultiWriter(progress, &stderr)
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("failed to pull image %s: %w: %s", image, err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+func (cliRuntime) Ping(ctx context.Context) error {
+	out, err := exec.CommandContext(ctx, containerRuntime, "version").CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s is not reachable: %w: %s", containerRuntime, err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
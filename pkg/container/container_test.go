@@ -0,0 +1,102 @@
+package container
+
+import (
+	"reflect"
+	"testing"
+)
+
+func Test_networkSubnets(t *testing.T) {
+	tests := []struct {
+		name         string
+		data         string
+		want         []string
+		wantGateways []string
+	}{
+		{
+			name:         "docker dual stack",
+			data:         `[{"Name":"kind","IPAM":{"Driver":"default","Config":[{"Subnet":"fc00:f853:ccd:e793::/64"},{"Subnet":"172.18.0.0/16","Gateway":"172.18.0.1"}]}}]`,
+			want:         []string{"fc00:f853:ccd:e793::/64", "172.18.0.0/16"},
+			wantGateways: []string{"172.18.0.1"},
+		},
+		{
+			name:         "podman ipv4",
+			data:         `[{"name":"kind","driver":"bridge","subnets":[{"subnet":"10.89.0.0/24","gateway":"10.89.0.1"}]}]`,
+			want:         []string{"10.89.0.0/24"},
+			wantGateways: []string{"10.89.0.1"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, gateways, err := networkSubnets([]byte(tt.data))
+			if err != nil {
+				t.Fatalf("networkSubnets() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("networkSubnets() = %v, want %v", got, tt.want)
+			}
+			if !reflect.DeepEqual(gateways, tt.wantGateways) {
+				t.Errorf("networkSubnets() gateways = %v, want %v", gateways, tt.wantGateways)
+			}
+		})
+	}
+}
+
+func Test_containerNetworkAddresses(t *testing.T) {
+	line := " kind,172.18.0.5,fc00:f853:ccd:e793::5 other,172.19.0.2,"
+	if got, want := containerNetworkAddresses(line, "kind"), []string{"172.18.0.5", "fc00:f853:ccd:e793::5"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("containerNetworkAddresses() = %v, want %v", got, want)
+	}
+	if got, want := containerNetworkAddresses(line, "other"), []string{"172.19.0.2"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("containerNetworkAddresses() = %v, want %v", got, want)
+	}
+}
+
+func Test_parseIPs(t *testing.T) {
+	tests := []struct {
+		name     string
+		line     string
+		wantIPv4 string
+		wantIPv6 string
+		wantErr  bool
+	}{
+		{name: "single network", line: "kind kind,172.18.0.5,fc00:f853:ccd:e793::5", wantIPv4: "172.18.0.5", wantIPv6: "fc00:f853:ccd:e793::5"},
+		{name: "ipv6 only", line: "kind kind,,fc00:f853:ccd:e793::5", wantIPv6: "fc00:f853:ccd:e793::5"},
+		{name: "multiple networks", line: "kind2 kind,172.18.0.5, kind2,172.19.0.3,", wantIPv4: "172.19.0.3"},
+		{name: "unknown network mode", line: "bridge kind,172.18.0.5, kind2,172.19.0.3,", wantIPv4: "172.18.0.5"},
+		{name: "no networks", line: "none", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ipv4, ipv6, err := parseIPs(tt.line)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseIPs() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if ipv4 != tt.wantIPv4 || ipv6 != tt.wantIPv6 {
+				t.Errorf("parseIPs() = %q, %q, want %q, %q", ipv4, ipv6, tt.wantIPv4, tt.wantIPv6)
+			}
+		})
+	}
+}
+
+func Test_parsePortMappings(t *testing.T) {
+	line := `{"53/udp":[{"HostIp":"0.0.0.0","HostPort":"32769"},{"HostIp":"::","HostPort":"32769"}],"80/tcp":[{"HostIp":"0.0.0.0","HostPort":"32768"}],"443/tcp":null}`
+	got, err := parsePortMappings(line)
+	if err != nil {
+		t.Fatalf("parsePortMappings() unexpected error: %v", err)
+	}
+	want := map[string]string{"53/udp": "32769", "80/tcp": "32768"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parsePortMappings() = %v, want %v", got, want)
+	}
+	if _, err := parsePortMappings("invalid"); err == nil {
+		t.Errorf("parsePortMappings() expected error")
+	}
+}
+
+func Test_parseLabelValues(t *testing.T) {
+	got := parseLabelValues([]string{"3f4e8a1b2c3d\tkind/default/web", "9a8b7c6d5e4f\t", ""})
+	want := map[string]string{"3f4e8a1b2c3d": "kind/default/web", "9a8b7c6d5e4f": ""}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseLabelValues() = %v, want %v", got, want)
+	}
+}
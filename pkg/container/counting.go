@@ -0,0 +1,185 @@
+package container
+
+import (
+	"context"
+	"io"
+	"sync"
+)
+
+// CallCounter is a Runtime that counts the calls to the runtime it wraps, by method
+type CallCounter struct {
+	runtime Runtime
+	mu      sync.Mutex
+	calls   map[string]int
+}
+
+var _ Runtime = &CallCounter{}
+
+// CountCalls replaces the current runtime with a CallCounter wrapping it and returns a
+// function that restores it, it must not be called while the controllers are running.
+func CountCalls() (*CallCounter, func()) {
+	counter := &CallCounter{runtime: current, calls: map[string]int{}}
+	return counter, SetRuntime(counter)
+}
+
+// Calls returns the number of calls of each method
+func (c *CallCounter) Calls() map[string]int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	calls := make(map[string]int, len(c.calls))
+	for method, n := range c.calls {
+		calls[method] = n
+	}
+	return calls
+}
+
+// Total returns the number of calls of all the methods
+func (c *CallCounter) Total() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	total := 0
+	for _, n := range c.calls {
+		total += n
+	}
+	return total
+}
+
+func (c *CallCounter) count(method string) {
+	c.mu.Lock()
+	c.calls[method]++
+	c.mu.Unlock()
+}
+
+func (c *CallCounter) Create(name string, args []string, command ...string) error {
+	c.count("Create")
+	return c.runtime.Create(name, args, command...)
+}
+
+func (c *CallCounter) Restart(name string) error {
+	c.count("Restart")
+	return c.runtime.Restart(name)
+}
+
+func (c *CallCounter) Delete(name string) error {
+	c.count("Delete")
+	return c.runtime.Delete(name)
+}
+
+func (c *CallCounter) IsRunning(name string) bool {
+	c.count("IsRunning")
+	return c.runtime.IsRunning(name)
+}
+
+func (c *CallCounter) Exist(name string) bool {
+	c.count("Exist")
+	return c.runtime.Exist(name)
+}
+
+func (c *CallCounter) Signal(name string, signal string) error {
+	c.count("Signal")
+	return c.runtime.Signal(name, signal)
+}
+
+func (c *CallCounter) Exec(name string, command []string, stdin io.Reader, stdout io.Writer, stderr io.Writer) error {
+	c.count("Exec")
+	return c.runtime.Exec(name, command, stdin, stdout, stderr)
+}
+
+func (c *CallCounter) IPs(name string) (ipv4 string, ipv6 string, err error) {
+	c.count("IPs")
+	return c.runtime.IPs(name)
+}
+
+func (c *CallCounter) Networks(name string) ([]string, error) {
+	c.count("Networks")
+	return c.runtime.Networks(name)
+}
+
+func (c *CallCounter) ConnectNetwork(name string, network string) error {
+	c.count("ConnectNetwork")
+	return c.runtime.ConnectNetwork(name, network)
+}
+
+func (c *CallCounter) PortMappings(name string) (map[string]string, error) {
+	c.count("PortMappings")
+	return c.runtime.PortMappings(name)
+}
+
+func (c *CallCounter) ListByLabel(label string) ([]string, error) {
+	c.count("ListByLabel")
+	return c.runtime.ListByLabel(label)
+}
+
+func (c *CallCounter) GetLabelValue(name string, label string) (string, error) {
+	c.count("GetLabelValue")
+	return c.runtime.GetLabelValue(name, label)
+}
+
+func (c *CallCounter) ListLabelValues(label string, key string) (map[string]string, error) {
+	c.count("ListLabelValues")
+	return c.runtime.ListLabelValues(label, key)
+}
+
+func (c *CallCounter) Image(name string) (string, error) {
+	c.count("Image")
+	return c.runtime.Image(name)
+}
+
+func (c *CallCounter) ID(name string) (string, error) {
+	c.count("ID")
+	return c.runtime.ID(name)
+}
+
+func (c *CallCounter) RunInNetNS(name string, image string, command []string) ([]string, error) {
+	c.count("RunInNetNS")
+	return c.runtime.RunInNetNS(name, image, command)
+}
+
+func (c *CallCounter) NetworkSubnets(network string) ([]string, error) {
+	c.count("NetworkSubnets")
+	return c.runtime.NetworkSubnets(network)
+}
+
+func (c *CallCounter) NetworkAddresses(network string) ([]string, error) {
+	c.count("NetworkAddresses")
+	return c.runtime.NetworkAddresses(network)
+}
+
+func (c *CallCounter) EnsureImage(image string) error {
+	c.count("EnsureImage")
+	return c.runtime.EnsureImage(image)
+}
+
+func (c *CallCounter) ImageExists(image string) bool {
+	c.count("ImageExists")
+	return c.runtime.ImageExists(image)
+}
+
+func (c *CallCounter) LoadImage(archive io.Reader) error {
+	c.count("LoadImage")
+	return c.runtime.LoadImage(archive)
+}
+
+func (c *CallCounter) PullImage(ctx context.Context, image string, platform string, progress io.Writer) error {
+	c.count("PullImage")
+	return c.runtime.PullImage(ctx, image, platform, progress)
+}
+
+func (c *CallCounter) Ping(ctx context.Context) error {
+	c.count("Ping")
+	return c.runtime.Ping(ctx)
+}
+
+func (c *CallCounter) ContainerEvents(ctx context.Context, label string) (<-chan ContainerEvent, error) {
+	c.count("ContainerEvents")
+	return c.runtime.ContainerEvents(ctx, label)
+}
+
+// Rootless is not counted, the result is cached after the first call
+func (c *CallCounter) Rootless() bool {
+	return c.runtime.Rootless()
+}
+
+func (c *CallCounter) Architecture() string {
+	return c.runtime.Architecture()
+}
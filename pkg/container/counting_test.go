@@ -0,0 +1,33 @@
+package container
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCountCalls(t *testing.T) {
+	fake := NewFake()
+	defer SetRuntime(fake)()
+	fake.AddContainer(&FakeContainer{Name: "lb", Labels: map[string]string{"owner": "kind/default/web"}})
+
+	counter, restore := CountCalls()
+	Exist("lb")
+	Exist("missing")
+	values, err := ListLabelValues("owner", "owner")
+	if err != nil {
+		t.Fatalf("ListLabelValues() unexpected error: %v", err)
+	}
+	if want := map[string]string{"lb": "kind/default/web"}; !reflect.DeepEqual(values, want) {
+		t.Errorf("ListLabelValues() = %v, want %v", values, want)
+	}
+	restore()
+	Exist("lb")
+
+	want := map[string]int{"Exist": 2, "ListLabelValues": 1}
+	if got := counter.Calls(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Calls() = %v, want %v", got, want)
+	}
+	if got := counter.Total(); got != 3 {
+		t.Errorf("Total() = %d, want 3", got)
+	}
+}
@@ -0,0 +1,139 @@
+package container
+
+import (
+	"net"
+	"net/url"
+	"os"
+	"os/exec"
+	"slices"
+
+	"k8s.io/klog/v2"
+	kindexec "sigs.k8s.io/kind/pkg/exec"
+)
+
+// DinDMode describes how the controller reaches the container runtime in
+// docker-in-docker environments, typically CI jobs.
+type DinDMode string
+
+const (
+	// DinDNone the controller runs on the same host as the container runtime
+	DinDNone DinDMode = "none"
+	// DinDSibling the controller runs in a container managed by the same container
+	// runtime that runs the kind nodes, ex. GitHub Actions job containers with the
+	// docker socket mounted. The container networks are isolated from each other.
+	DinDSibling DinDMode = "sibling"
+	// DinDRemote the container runtime runs in another container or host reachable
+	// over TCP or SSH, ex. GitLab CI docker:dind services or a remote VM, the container
+	// IPs are not reachable from the controller.
+	DinDRemote DinDMode = "remote"
+)
+
+// DinDEnvironment is the result of the docker-in-docker detection
+type DinDEnvironment struct {
+	Mode DinDMode
+	// SelfID is the ID of the container the controller runs in, only for DinDSibling
+	SelfID string
+	// HostAddress is the address of the container runtime host, only for DinDRemote
+	HostAddress string
+}
+
+// DetectDinD detects if the controller runs in a docker-in-docker environment
+func DetectDinD() DinDEnvironment {
+	if host := remoteRuntimeHost(runtimeEndpoint()); host != "" {
+		address := resolveHost(host)
+		if address != "" {
+			return DinDEnvironment{Mode: DinDRemote, HostAddress: address}
+		}
+		klog.Infof("could not resolve the container runtime host %s", host)
+	}
+	if id := selfContainerID(); id != "" {
+		return DinDEnvironment{Mode: DinDSibling, SelfID: id}
+	}
+	return DinDEnvironment{Mode: DinDNone}
+}
+
+// runtimeEndpoint returns the endpoint the container runtime CLI connects to, the CLI also
+// reads the TLS settings, ex. DOCKER_TLS_VERIFY and DOCKER_CERT_PATH, from the environment.
+func runtimeEndpoint() string {
+	if containerRuntime == "podman" {
+		return os.Getenv("CONTAINER_HOST")
+	}
+	if host := os.Getenv("DOCKER_HOST"); host != "" {
+		return host
+	}
+	// the current docker context, that can also be set with DOCKER_CONTEXT
+	cmd := kindexec.Command(containerRuntime, "context", "inspect", "--format", "{{.Endpoints.docker.Host}}")
+	lines, err := kindexec.OutputLines(cmd)
+	if err != nil || len(lines) != 1 {
+		return ""
+	}
+	return lines[0]
+}
+
+// remoteRuntimeHost returns the host of the runtime endpoint if it is reached over TCP or SSH
+func remoteRuntimeHost(dockerHost string) string {
+	if dockerHost == "" {
+		return ""
+	}
+	u, err := url.Parse(dockerHost)
+	if err != nil || (u.Scheme != "tcp" && u.Scheme != "ssh") {
+		return ""
+	}
+	host := u.Hostname()
+	// a local daemon exposed over TCP does not need special handling
+	if ip := net.ParseIP(host); (ip != nil && ip.IsLoopback()) || host == "localhost" {
+		return ""
+	}
+	return host
+}
+
+func resolveHost(host string) string {
+	if ip := net.ParseIP(host); ip != nil {
+		return ip.String()
+	}
+	ips, err := net.LookupIP(host)
+	if err != nil || len(ips) == 0 {
+		return ""
+	}
+	// prefer IPv4
+	for _, ip := range ips {
+		if ip.To4() != nil {
+			return ip.String()
+		}
+	}
+	return ips[0].String()
+}
+
+// selfContainerID returns the ID of the container the controller runs in if it is
+// managed by the same container runtime, empty otherwise.
+func selfContainerID() string {
+	if _, err := os.Stat("/.dockerenv"); err != nil {
+		if _, err := os.Stat("/run/.containerenv"); err != nil {
+			return ""
+		}
+	}
+	// the container runtimes set the hostname to the container ID by default
+	hostname, err := os.Hostname()
+	if err != nil || hostname == "" {
+		return ""
+	}
+	cmd := kindexec.Command(containerRuntime, "inspect", "--format", "{{.Id}}", hostname)
+	lines, err := kindexec.OutputLines(cmd)
+	if err != nil || len(lines) != 1 {
+		return ""
+	}
+	return lines[0]
+}
+
+// IsConnected returns true if the container is attached to the network
+func IsConnected(name string, network string) bool {
+	networks, err := Networks(name)
+	if err != nil {
+		return false
+	}
+	return slices.Contains(networks, network)
+}
+
+func (cliRuntime) ConnectNetwork(name string, network string) error {
+	return exec.Command(containerRuntime, "network", "connect", network, name).Run()
+}
@@ -0,0 +1,28 @@
+package container
+
+import "testing"
+
+func Test_remoteRuntimeHost(t *testing.T) {
+	tests := []struct {
+		dockerHost string
+		want       string
+	}{
+		{dockerHost: "", want: ""},
+		{dockerHost: "unix:///var/run/docker.sock", want: ""},
+		{dockerHost: "tcp://docker:2375", want: "docker"},
+		{dockerHost: "tcp://10.0.0.5:2376", want: "10.0.0.5"},
+		{dockerHost: "tcp://[fd00::5]:2376", want: "fd00::5"},
+		{dockerHost: "tcp://127.0.0.1:2375", want: ""},
+		{dockerHost: "tcp://localhost:2375", want: ""},
+		{dockerHost: "ssh://user@remote-vm", want: "remote-vm"},
+		{dockerHost: "ssh://user@192.168.1.10:2222", want: "192.168.1.10"},
+		{dockerHost: "ssh://user@localhost", want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.dockerHost, func(t *testing.T) {
+			if got := remoteRuntimeHost(tt.dockerHost); got != tt.want {
+				t.Errorf("remoteRuntimeHost() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,127 @@
+package container
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"os/exec"
+	"strconv"
+
+	"k8s.io/klog/v2"
+)
+
+// the actions of the events of the removed containers, docker reports destroy and podman remove
+const (
+	EventDestroy = "destroy"
+	EventRemove  = "remove"
+)
+
+// EventStart is the action of the events of the containers started, or restarted
+const EventStart = "start"
+
+// the actions of the events of the containers that exited, docker reports die and podman died,
+// and of the containers killed because they were out of memory
+const (
+	EventDie  = "die"
+	EventDied = "died"
+	EventOOM  = "oom"
+)
+
+// ContainerEvent is an event of the container runtime about a container
+type ContainerEvent struct {
+	// Name of the container
+	Name string
+	// Action is the event, ex. start, die or destroy
+	Action string
+	// Labels of the container
+	Labels map[string]string
+	// ExitCode of the container for the die events
+	ExitCode int
+}
+
+// Removed returns true if the event reports the container was removed
+func (e ContainerEvent) Removed() bool {
+	return e.Action == EventDestroy || e.Action == EventRemove
+}
+
+// Started returns true if the event reports the container was started
+func (e ContainerEvent) Started() bool {
+	return e.Action == EventStart
+}
+
+// Exited returns true if the event reports the process of the container exited
+func (e ContainerEvent) Exited() bool {
+	return e.Action == EventDie || e.Action == EventDied
+}
+
+func (cliRuntime) ContainerEvents(ctx context.Context, label string) (<-chan ContainerEvent, error) {
+	cmd := exec.CommandContext(ctx, containerRuntime, "events",
+		"--filter", "type=container",
+		"--filter", "label="+label,
+		"--format", "{{json .}}",
+	)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	events := make(chan ContainerEvent)
+	go func() {
+		defer close(events)
+		defer cmd.Wait() // nolint:errcheck
+		scanner := bufio.NewScanner(stdout)
+		for scanner.Scan() {
+			event, err := parseContainerEvent(scanner.Bytes())
+			if err != nil {
+				klog.V(2).InfoS("Ignoring invalid container event", "event", scanner.Text(), "err", err)
+				continue
+			}
+			select {
+			case events <- event:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return events, nil
+}
+
+// parseContainerEvent parses a container event in the json format of docker, with the name,
+// the labels and the exit code in the actor attributes, or podman
+func parseContainerEvent(data []byte) (ContainerEvent, error) {
+	var raw struct {
+		// docker
+		Action string
+		Actor  struct {
+			Attributes map[string]string
+		}
+		// podman
+		Name              string
+		Status            string
+		Attributes        map[string]string
+		ContainerExitCode int
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return ContainerEvent{}, err
+	}
+	if raw.Action != "" {
+		// the attributes are the labels, the name and the image of the container
+		labels := map[string]string{}
+		for key, value := range raw.Actor.Attributes {
+			labels[key] = value
+		}
+		name := labels["name"]
+		exitCode, _ := strconv.Atoi(labels["exitCode"])
+		delete(labels, "name")
+		delete(labels, "image")
+		delete(labels, "exitCode")
+		return ContainerEvent{Name: name, Action: raw.Action, Labels: labels, ExitCode: exitCode}, nil
+	}
+	labels := raw.Attributes
+	if labels == nil {
+		labels = map[string]string{}
+	}
+	return ContainerEvent{Name: raw.Name, Action: raw.Status, Labels: labels, ExitCode: raw.ContainerExitCode}, nil
+}
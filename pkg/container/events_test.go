@@ -0,0 +1,101 @@
+package container
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func Test_parseContainerEvent(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want ContainerEvent
+	}{
+		{
+			name: "docker",
+			data: `{"status":"destroy","id":"3f4e","from":"kindest/node:v1.30.0","Type":"container","Action":"destroy","Actor":{"ID":"3f4e","Attributes":{"image":"kindest/node:v1.30.0","io.x-k8s.kind.cluster":"kind","io.x-k8s.kind.role":"worker","name":"kind-worker"}},"scope":"local","time":1700000000}`,
+			want: ContainerEvent{Name: "kind-worker", Action: EventDestroy, Labels: map[string]string{"io.x-k8s.kind.cluster": "kind", "io.x-k8s.kind.role": "worker"}},
+		},
+		{
+			name: "podman",
+			data: `{"ID":"3f4e","Image":"kindest/node:v1.30.0","Name":"kind-worker","Status":"remove","Type":"container","Attributes":{"io.x-k8s.kind.cluster":"kind"}}`,
+			want: ContainerEvent{Name: "kind-worker", Action: EventRemove, Labels: map[string]string{"io.x-k8s.kind.cluster": "kind"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseContainerEvent([]byte(tt.data))
+			if err != nil {
+				t.Fatalf("parseContainerEvent() unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseContainerEvent() = %+v, want %+v", got, tt.want)
+			}
+			if !got.Removed() {
+				t.Errorf("Removed() = false, want true")
+			}
+		})
+	}
+	if _, err := parseContainerEvent([]byte("invalid")); err == nil {
+		t.Errorf("parseContainerEvent() expected error")
+	}
+}
+
+func Test_parseContainerEventExitCode(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want ContainerEvent
+	}{
+		{
+			name: "docker",
+			data: `{"status":"die","id":"3f4e","Type":"container","Action":"die","Actor":{"ID":"3f4e","Attributes":{"exitCode":"137","image":"envoyproxy/envoy:v1.30.1","io.x-k8s.cloud-provider-kind.cluster":"kind","name":"kindccm-lb"}}}`,
+			want: ContainerEvent{Name: "kindccm-lb", Action: EventDie, Labels: map[string]string{"io.x-k8s.cloud-provider-kind.cluster": "kind"}, ExitCode: 137},
+		},
+		{
+			name: "podman",
+			data: `{"ID":"3f4e","Image":"envoyproxy/envoy:v1.30.1","Name":"kindccm-lb","Status":"died","Type":"container","ContainerExitCode":1,"Attributes":{"io.x-k8s.cloud-provider-kind.cluster":"kind"}}`,
+			want: ContainerEvent{Name: "kindccm-lb", Action: EventDied, Labels: map[string]string{"io.x-k8s.cloud-provider-kind.cluster": "kind"}, ExitCode: 1},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseContainerEvent([]byte(tt.data))
+			if err != nil {
+				t.Fatalf("parseContainerEvent() unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseContainerEvent() = %+v, want %+v", got, tt.want)
+			}
+			if !got.Exited() {
+				t.Errorf("Exited() = false, want true")
+			}
+		})
+	}
+}
+
+func TestFakeContainerEvents(t *testing.T) {
+	fake := NewFake()
+	fake.AddContainer(&FakeContainer{Name: "kind-worker", Labels: map[string]string{"io.x-k8s.kind.cluster": "kind"}})
+	fake.AddContainer(&FakeContainer{Name: "other", Labels: map[string]string{"app": "other"}})
+	ctx, cancel := context.WithCancel(context.Background())
+	events, err := fake.ContainerEvents(ctx, "io.x-k8s.kind.cluster=kind")
+	if err != nil {
+		t.Fatalf("ContainerEvents() unexpected error: %v", err)
+	}
+	fake.Delete("other")       // nolint:errcheck
+	fake.Delete("kind-worker") // nolint:errcheck
+	select {
+	case event := <-events:
+		if event.Name != "kind-worker" || !event.Removed() {
+			t.Errorf("unexpected event %+v", event)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("no event received")
+	}
+	cancel()
+	for range events {
+	}
+}
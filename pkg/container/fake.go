@@ -0,0 +1,663 @@
+package container
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// FakeNetwork is a network of the Fake runtime
+type FakeNetwork struct {
+	// Subnets are the subnets of the network, the containers get the next free address
+	// of each subnet after the gateway, the first address
+	Subnets []string
+}
+
+// FakeContainer is a container of the Fake runtime
+type FakeContainer struct {
+	Name     string
+	Image    string
+	Args     []string
+	Command  []string
+	Labels   map[string]string
+	Networks []string
+	IPv4     string
+	IPv6     string
+	Running  bool
+	Restarts int
+	// Ports are the host ports of the published container ports, ex. 80/tcp
+	Ports map[string]string
+	// Files are the files written and read by Exec
+	Files map[string]string
+}
+
+// Fake is an in-memory container runtime. Exec supports the commands used to manage the
+// files of the loadbalancers, the ExecHook handles the rest, ex. to report the listening
+// sockets or the proxy admin interface.
+type Fake struct {
+	mu                sync.Mutex
+	Containers        map[string]*FakeContainer
+	ContainerNetworks map[string]*FakeNetwork
+	// Images are the images present, EnsureImage pulls the missing ones unless PullError is set
+	Images    map[string]bool
+	PullError error
+	// Platforms are the platforms of the images pulled with PullImage
+	Platforms map[string]string
+	// Arch is the architecture of the runtime host
+	Arch string
+	// ExecHook is called for the commands Exec does not support, it has to return true if
+	// the command was handled
+	ExecHook func(name string, command []string, stdout io.Writer) (bool, error)
+	// RunInNetNSHook is called by RunInNetNS, the commands succeed with no output if nil
+	RunInNetNSHook func(name string, image string, command []string) ([]string, error)
+	nextPort       int
+	// watchers receive the events of the removed and crashed containers
+	watchers []*fakeWatcher
+}
+
+var _ Runtime = &Fake{}
+
+// NewFake returns a Fake runtime with the kind network
+func NewFake() *Fake {
+	return &Fake{
+		Containers: map[string]*FakeContainer{},
+		ContainerNetworks: map[string]*FakeNetwork{
+			"kind": {Subnets: []string{"172.18.0.0/16", "fc00:f853:ccd:e793::/64"}},
+		},
+		Images:    map[string]bool{},
+		Platforms: map[string]string{},
+		Arch:      "amd64",
+		nextPort:  32768,
+	}
+}
+
+// AddContainer adds a running container, ex. the nodes of the clusters
+func (f *Fake) AddContainer(c *FakeContainer) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if c.Labels == nil {
+		c.Labels = map[string]string{}
+	}
+	if c.Files == nil {
+		c.Files = map[string]string{}
+	}
+	if c.Ports == nil {
+		c.Ports = map[string]string{}
+	}
+	f.Containers[c.Name] = c
+}
+
+// Container returns a copy of the container, or nil if it does not exist
+func (f *Fake) Container(name string) *FakeContainer {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	c, ok := f.Containers[name]
+	if !ok {
+		return nil
+	}
+	copied := *c
+	copied.Files = map[string]string{}
+	for path, content := range c.Files {
+		copied.Files[path] = content
+	}
+	return &copied
+}
+
+func (f *Fake) get(name string) (*FakeContainer, error) {
+	c, ok := f.Containers[name]
+	if !ok {
+		return nil, fmt.Errorf("failed to get container details: no such container %s", name)
+	}
+	return c, nil
+}
+
+func (f *Fake) Create(name string, args []string, command ...string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.Containers[name]; ok {
+		return fmt.Errorf("container %s already exists", name)
+	}
+	if len(args) == 0 {
+		return fmt.Errorf("missing image")
+	}
+	c := &FakeContainer{
+		Name:    name,
+		Image:   args[len(args)-1],
+		Args:    args,
+		Command: command,
+		Labels:  map[string]string{},
+		Ports:   map[string]string{},
+		Files:   map[string]string{},
+		Running: true,
+	}
+	var ipv4, ipv6 string
+	for i := 0; i < len(args)-1; i++ {
+		flag, value, ok := strings.Cut(args[i], "=")
+		if !ok && i+1 < len(args)-1 && !strings.HasPrefix(args[i+1], "-") {
+			value = args[i+1]
+		}
+		switch flag {
+		case "--label", "-l":
+			key, labelValue, _ := strings.Cut(value, "=")
+			c.Labels[key] = labelValue
+		case "--net", "--network":
+			// the containers sharing the network namespace of another one have no network
+			if shared, ok := strings.CutPrefix(value, "container:"); ok {
+				if _, err := f.get(shared); err != nil {
+					return err
+				}
+				continue
+			}
+			c.Networks = append(c.Networks, value)
+		case "--ip":
+			ipv4 = value
+		case "--ip6":
+			ipv6 = value
+		case "--publish", "-p":
+			// only the container port, the host port is always allocated
+			port := value
+			if idx := strings.LastIndex(port, ":"); idx >= 0 {
+				port = port[idx+1:]
+			}
+			if !strings.Contains(port, "/") {
+				port += "/tcp"
+			}
+			c.Ports[strings.ToLower(port)] = fmt.Sprint(f.nextPort)
+			f.nextPort++
+		}
+	}
+	if len(c.Networks) > 0 {
+		network, ok := f.ContainerNetworks[c.Networks[0]]
+		if !ok {
+			return fmt.Errorf("network %s not found", c.Networks[0])
+		}
+		for _, subnet := range network.Subnets {
+			_, cidr, err := net.ParseCIDR(subnet)
+			if err != nil {
+				return err
+			}
+			if cidr.IP.To4() != nil && ipv4 == "" {
+				ipv4 = f.freeAddress(cidr)
+			} else if cidr.IP.To4() == nil && ipv6 == "" {
+				ipv6 = f.freeAddress(cidr)
+			}
+		}
+	}
+	c.IPv4, c.IPv6 = ipv4, ipv6
+	f.Containers[name] = c
+	return nil
+}
+
+// freeAddress returns the first address of the subnet after the gateway not used by a container
+func (f *Fake) freeAddress(cidr *net.IPNet) string {
+	used := map[string]bool{}
+	for _, c := range f.Containers {
+		used[c.IPv4] = true
+		used[c.IPv6] = true
+	}
+	ip := make(net.IP, len(cidr.IP))
+	copy(ip, cidr.IP)
+	for i := 0; i < 1<<16; i++ {
+		for j := len(ip) - 1; j >= 0; j-- {
+			ip[j]++
+			if ip[j] != 0 {
+				break
+			}
+		}
+		if i == 0 {
+			// the gateway
+			continue
+		}
+		if !cidr.Contains(ip) {
+			break
+		}
+		if !used[ip.String()] {
+			return ip.String()
+		}
+	}
+	return ""
+}
+
+func (f *Fake) Restart(name string) error {
+	f.mu.Lock()
+	c, err := f.get(name)
+	if err != nil {
+		f.mu.Unlock()
+		return err
+	}
+	c.Running = true
+	c.Restarts++
+	watchers := append([]*fakeWatcher{}, f.watchers...)
+	f.mu.Unlock()
+	f.emit(watchers, ContainerEvent{Name: name, Action: EventStart, Labels: c.Labels})
+	return nil
+}
+
+func (f *Fake) Delete(name string) error {
+	f.mu.Lock()
+	c, ok := f.Containers[name]
+	delete(f.Containers, name)
+	watchers := append([]*fakeWatcher{}, f.watchers...)
+	f.mu.Unlock()
+	if !ok {
+		return nil
+	}
+	f.emit(watchers, ContainerEvent{Name: name, Action: EventDestroy, Labels: c.Labels})
+	return nil
+}
+
+// Crash stops the container as if its process exited with the exit code, or was killed
+// because it was out of memory, and reports it to the watchers
+func (f *Fake) Crash(name string, exitCode int, oom bool) error {
+	f.mu.Lock()
+	c, err := f.get(name)
+	if err != nil {
+		f.mu.Unlock()
+		return err
+	}
+	c.Running = false
+	watchers := append([]*fakeWatcher{}, f.watchers...)
+	f.mu.Unlock()
+	if oom {
+		f.emit(watchers, ContainerEvent{Name: name, Action: EventOOM, Labels: c.Labels})
+	}
+	f.emit(watchers, ContainerEvent{Name: name, Action: EventDie, Labels: c.Labels, ExitCode: exitCode})
+	return nil
+}
+
+func (f *Fake) emit(watchers []*fakeWatcher, event ContainerEvent) {
+	for _, w := range watchers {
+		if w.matches(event.Labels) {
+			w.send(event)
+		}
+	}
+}
+
+func (f *Fake) IsRunning(name string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	c, err := f.get(name)
+	return err == nil && c.Running
+}
+
+func (f *Fake) Exist(name string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	_, err := f.get(name)
+	return err == nil
+}
+
+func (f *Fake) Signal(name string, signal string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	_, err := f.get(name)
+	return err
+}
+
+// Exec supports cat, cp /dev/stdin, mv, rm -f, test -f and touch on the container files
+func (f *Fake) Exec(name string, command []string, stdin io.Reader, stdout io.Writer, stderr io.Writer) error {
+	f.mu.Lock()
+	c, err := f.get(name)
+	if err != nil {
+		f.mu.Unlock()
+		return err
+	}
+	if !c.Running {
+		f.mu.Unlock()
+		return fmt.Errorf("container %s is not running", name)
+	}
+	handled, err := f.execFile(c, command, stdin, stdout, stderr)
+	hook := f.ExecHook
+	f.mu.Unlock()
+	if handled {
+		return err
+	}
+	if hook != nil {
+		if handled, err := hook(name, command, stdout); handled {
+			return err
+		}
+	}
+	return fmt.Errorf("command %v not supported by the fake runtime", command)
+}
+
+func (f *Fake) execFile(c *FakeContainer, command []string, stdin io.Reader, stdout io.Writer, stderr io.Writer) (bool, error) {
+	switch {
+	case len(command) == 2 && command[0] == "cat":
+		content, ok := c.Files[command[1]]
+		if !ok {
+			// the files of the host kernel are provided by the hook
+			if strings.HasPrefix(command[1], "/proc/") {
+				return false, nil
+			}
+			if stderr != nil {
+				fmt.Fprintf(stderr, "cat: %s: No such file or directory\n", command[1])
+			}
+			return true, fmt.Errorf("cat: %s: No such file or directory", command[1])
+		}
+		if stdout != nil {
+			_, err := io.WriteString(stdout, content)
+			return true, err
+		}
+		return true, nil
+	case len(command) == 3 && command[0] == "cp" && command[1] == "/dev/stdin":
+		var content []byte
+		if stdin != nil {
+			var err error
+			content, err = io.ReadAll(stdin)
+			if err != nil {
+				return true, err
+			}
+		}
+		c.Files[command[2]] = string(content)
+		return true, nil
+	case len(command) == 3 && command[0] == "mv":
+		content, ok := c.Files[command[1]]
+		if !ok {
+			return true, fmt.Errorf("mv: cannot stat %s", command[1])
+		}
+		delete(c.Files, command[1])
+		c.Files[command[2]] = content
+		return true, nil
+	case len(command) >= 3 && command[0] == "rm" && command[1] == "-f":
+		for _, path := range command[2:] {
+			delete(c.Files, path)
+		}
+		return true, nil
+	case len(command) == 3 && command[0] == "test" && command[1] == "-f":
+		if _, ok := c.Files[command[2]]; !ok {
+			return true, fmt.Errorf("file %s does not exist", command[2])
+		}
+		return true, nil
+	case len(command) == 2 && command[0] == "touch":
+		if _, ok := c.Files[command[1]]; !ok {
+			c.Files[command[1]] = ""
+		}
+		return true, nil
+	}
+	return false, nil
+}
+
+func (f *Fake) IPs(name string) (string, string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	c, err := f.get(name)
+	if err != nil {
+		return "", "", err
+	}
+	return c.IPv4, c.IPv6, nil
+}
+
+func (f *Fake) Networks(name string) ([]string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	c, err := f.get(name)
+	if err != nil {
+		return nil, err
+	}
+	return append([]string{}, c.Networks...), nil
+}
+
+func (f *Fake) ConnectNetwork(name string, network string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	c, err := f.get(name)
+	if err != nil {
+		return err
+	}
+	if _, ok := f.ContainerNetworks[network]; !ok {
+		return fmt.Errorf("network %s not found", network)
+	}
+	c.Networks = append(c.Networks, network)
+	return nil
+}
+
+func (f *Fake) PortMappings(name string) (map[string]string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	c, err := f.get(name)
+	if err != nil {
+		return nil, err
+	}
+	ports := map[string]string{}
+	for port, hostPort := range c.Ports {
+		ports[port] = hostPort
+	}
+	return ports, nil
+}
+
+func (f *Fake) ListByLabel(label string) ([]string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	key, value, hasValue := strings.Cut(label, "=")
+	names := []string{}
+	for name, c := range f.Containers {
+		v, ok := c.Labels[key]
+		if ok && (!hasValue || v == value) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func (f *Fake) GetLabelValue(name string, label string) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	c, err := f.get(name)
+	if err != nil {
+		return "", err
+	}
+	return c.Labels[label], nil
+}
+
+func (f *Fake) ListLabelValues(label string, key string) (map[string]string, error) {
+	names, err := f.ListByLabel(label)
+	if err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	values := map[string]string{}
+	for _, name := range names {
+		values[name] = f.Containers[name].Labels[key]
+	}
+	return values, nil
+}
+
+func (f *Fake) Image(name string) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	c, err := f.get(name)
+	if err != nil {
+		return "", err
+	}
+	return c.Image, nil
+}
+
+// ID returns a fake ID derived from the container name
+func (f *Fake) ID(name string) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, err := f.get(name); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(name))), nil
+}
+
+func (f *Fake) RunInNetNS(name string, image string, command []string) ([]string, error) {
+	f.mu.Lock()
+	_, err := f.get(name)
+	hook := f.RunInNetNSHook
+	f.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	if hook != nil {
+		return hook(name, image, command)
+	}
+	return nil, nil
+}
+
+func (f *Fake) NetworkSubnets(network string) ([]string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	n, ok := f.ContainerNetworks[network]
+	if !ok {
+		return nil, fmt.Errorf("failed to inspect network %s", network)
+	}
+	return append([]string{}, n.Subnets...), nil
+}
+
+func (f *Fake) NetworkAddresses(network string) ([]string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	n, ok := f.ContainerNetworks[network]
+	if !ok {
+		return nil, fmt.Errorf("failed to inspect network %s", network)
+	}
+	addresses := []string{}
+	for _, subnet := range n.Subnets {
+		_, cidr, err := net.ParseCIDR(subnet)
+		if err != nil {
+			continue
+		}
+		gateway := make(net.IP, len(cidr.IP))
+		copy(gateway, cidr.IP)
+		gateway[len(gateway)-1]++
+		addresses = append(addresses, gateway.String())
+	}
+	for _, c := range f.Containers {
+		for _, name := range c.Networks {
+			if name != network {
+				continue
+			}
+			for _, address := range []string{c.IPv4, c.IPv6} {
+				if address != "" {
+					addresses = append(addresses, address)
+				}
+			}
+		}
+	}
+	return addresses, nil
+}
+
+func (f *Fake) EnsureImage(image string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.Images[image] {
+		return nil
+	}
+	if f.PullError != nil {
+		return fmt.Errorf("failed to pull image %s: %w", image, f.PullError)
+	}
+	f.Images[image] = true
+	return nil
+}
+
+func (f *Fake) ImageExists(image string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.Images[image]
+}
+
+// LoadImage adds the images of the archive, the fake archives list an image per line
+func (f *Fake) LoadImage(archive io.Reader) error {
+	data, err := io.ReadAll(archive)
+	if err != nil {
+		return fmt.Errorf("failed to load image archive: %w", err)
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, image := range strings.Fields(string(data)) {
+		f.Images[image] = true
+	}
+	return nil
+}
+
+func (f *Fake) PullImage(ctx context.Context, image string, platform string, progress io.Writer) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.PullError != nil {
+		return fmt.Errorf("failed to pull image %s: %w", image, f.PullError)
+	}
+	fmt.Fprintf(progress, "%s: Pulling from %s\n", image, platform)
+	f.Images[image] = true
+	f.Platforms[image] = platform
+	return nil
+}
+
+func (f *Fake) Ping(ctx context.Context) error {
+	return nil
+}
+
+// ContainerEvents reports the containers started with Restart, removed with Delete and
+// stopped with Crash
+func (f *Fake) ContainerEvents(ctx context.Context, label string) (<-chan ContainerEvent, error) {
+	key, value, hasValue := strings.Cut(label, "=")
+	w := &fakeWatcher{ctx: ctx, key: key, value: value, hasValue: hasValue, events: make(chan ContainerEvent, 16)}
+	f.mu.Lock()
+	f.watchers = append(f.watchers, w)
+	f.mu.Unlock()
+	go func() {
+		<-ctx.Done()
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		for i, watcher := range f.watchers {
+			if watcher == w {
+				f.watchers = append(f.watchers[:i], f.watchers[i+1:]...)
+				break
+			}
+		}
+		w.close()
+	}()
+	return w.events, nil
+}
+
+type fakeWatcher struct {
+	ctx      context.Context
+	key      string
+	value    string
+	hasValue bool
+	mu       sync.Mutex
+	closed   bool
+	events   chan ContainerEvent
+}
+
+func (w *fakeWatcher) matches(labels map[string]string) bool {
+	v, ok := labels[w.key]
+	return ok && (!w.hasValue || v == w.value)
+}
+
+func (w *fakeWatcher) send(event ContainerEvent) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return
+	}
+	select {
+	case w.events <- event:
+	case <-w.ctx.Done():
+	}
+}
+
+func (w *fakeWatcher) close() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.closed = true
+	close(w.events)
+}
+
+func (f *Fake) Rootless() bool {
+	return false
+}
+
+func (f *Fake) Architecture() string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.Arch
+}
@@ -0,0 +1,83 @@
+package container
+
+import (
+	"sort"
+	"strings"
+)
+
+// k3d labels its containers with the cluster name and their role, the servers and agents
+// are the nodes of the cluster, the loadbalancer of the apiserver and the registries are not
+const (
+	k3dClusterLabelKey = "k3d.cluster"
+	k3dRoleLabelKey    = "k3d.role"
+)
+
+// K3dClusterPrefix is prepended to the names of the k3d clusters, like the contexts of their
+// kubeconfigs, so they do not clash with the kind clusters
+const K3dClusterPrefix = "k3d-"
+
+// K3dNodeResolver resolves the node containers of the k3d clusters, the server and agent
+// containers of the cluster, named after the nodes
+type K3dNodeResolver struct{}
+
+// NodeContainers returns the server and agent containers of the cluster
+func (K3dNodeResolver) NodeContainers(cluster string) ([]string, error) {
+	containers, err := ListByLabel(k3dClusterLabelKey + "=" + strings.TrimPrefix(cluster, K3dClusterPrefix))
+	if err != nil {
+		return nil, err
+	}
+	nodes := []string{}
+	for _, name := range containers {
+		role, err := GetLabelValue(name, k3dRoleLabelKey)
+		if err != nil {
+			return nil, err
+		}
+		if isK3dNode(role) {
+			nodes = append(nodes, name)
+		}
+	}
+	return nodes, nil
+}
+
+// NodeContainer returns the container named after the node if it is a server or an agent
+// of the cluster
+func (K3dNodeResolver) NodeContainer(cluster string, node string) (string, error) {
+	if !Exist(node) {
+		return "", nil
+	}
+	name, err := GetLabelValue(node, k3dClusterLabelKey)
+	if err != nil {
+		return "", err
+	}
+	role, err := GetLabelValue(node, k3dRoleLabelKey)
+	if err != nil {
+		return "", err
+	}
+	if name != strings.TrimPrefix(cluster, K3dClusterPrefix) || !isK3dNode(role) {
+		return "", nil
+	}
+	return node, nil
+}
+
+func isK3dNode(role string) bool {
+	return role == "server" || role == "agent"
+}
+
+// K3dClusters returns the names of the k3d clusters with servers, with the K3dClusterPrefix
+func K3dClusters() ([]string, error) {
+	values, err := ListLabelValues(k3dRoleLabelKey+"=server", k3dClusterLabelKey)
+	if err != nil {
+		return nil, err
+	}
+	seen := map[string]bool{}
+	clusters := []string{}
+	for _, name := range values {
+		if name == "" || seen[name] {
+			continue
+		}
+		seen[name] = true
+		clusters = append(clusters, K3dClusterPrefix+name)
+	}
+	sort.Strings(clusters)
+	return clusters, nil
+}
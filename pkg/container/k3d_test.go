@@ -0,0 +1,57 @@
+package container
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestK3dNodeResolver(t *testing.T) {
+	fake := NewFake()
+	defer SetRuntime(fake)()
+	fake.AddContainer(&FakeContainer{Name: "k3d-dev-server-0", Labels: map[string]string{"k3d.cluster": "dev", "k3d.role": "server"}})
+	fake.AddContainer(&FakeContainer{Name: "k3d-dev-agent-0", Labels: map[string]string{"k3d.cluster": "dev", "k3d.role": "agent"}})
+	fake.AddContainer(&FakeContainer{Name: "k3d-dev-serverlb", Labels: map[string]string{"k3d.cluster": "dev", "k3d.role": "loadbalancer"}})
+	fake.AddContainer(&FakeContainer{Name: "k3d-test-server-0", Labels: map[string]string{"k3d.cluster": "test", "k3d.role": "server"}})
+	fake.AddContainer(&FakeContainer{Name: "k3d-registry", Labels: map[string]string{"k3d.role": "registry"}})
+
+	clusters, err := K3dClusters()
+	if err != nil {
+		t.Fatalf("K3dClusters() error = %v", err)
+	}
+	if diff := cmp.Diff([]string{"k3d-dev", "k3d-test"}, clusters); diff != "" {
+		t.Errorf("K3dClusters() mismatch (-want +got):\n%s", diff)
+	}
+
+	defer SetNodeResolver("k3d-dev", K3dNodeResolver{})()
+	nodes, err := NodeContainers("k3d-dev")
+	if err != nil {
+		t.Fatalf("NodeContainers() error = %v", err)
+	}
+	if diff := cmp.Diff([]string{"k3d-dev-agent-0", "k3d-dev-server-0"}, nodes); diff != "" {
+		t.Errorf("NodeContainers() mismatch (-want +got):\n%s", diff)
+	}
+
+	tests := []struct {
+		node string
+		want string
+	}{
+		{node: "k3d-dev-server-0", want: "k3d-dev-server-0"},
+		{node: "k3d-dev-agent-0", want: "k3d-dev-agent-0"},
+		// the loadbalancer of the apiserver is not a node
+		{node: "k3d-dev-serverlb"},
+		{node: "k3d-test-server-0"},
+		{node: "missing"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.node, func(t *testing.T) {
+			got, err := NodeContainer("k3d-dev", tt.node)
+			if err != nil {
+				t.Fatalf("NodeContainer() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("NodeContainer() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
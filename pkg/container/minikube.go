@@ -0,0 +1,50 @@
+package container
+
+import (
+	"sort"
+)
+
+// minikube labels the containers of the docker and podman drivers with the profile name,
+// that is the name of the cluster and of its kubeconfig context, the nodes are named
+// after their containers, PROFILE for the first control plane and PROFILE-mNN for the others
+const (
+	minikubeProfileLabelKey   = "name.minikube.sigs.k8s.io"
+	minikubeCreatedByLabelKey = "created_by.minikube.sigs.k8s.io"
+)
+
+// MinikubeNodeResolver resolves the node containers of the minikube clusters, all the
+// containers of the profile
+type MinikubeNodeResolver struct{}
+
+// NodeContainers returns the containers of the profile
+func (MinikubeNodeResolver) NodeContainers(cluster string) ([]string, error) {
+	return minikubeNodeResolver(cluster).NodeContainers(cluster)
+}
+
+// NodeContainer returns the container named after the node if it belongs to the profile
+func (MinikubeNodeResolver) NodeContainer(cluster string, node string) (string, error) {
+	return minikubeNodeResolver(cluster).NodeContainer(cluster, node)
+}
+
+func minikubeNodeResolver(cluster string) LabelNodeResolver {
+	return LabelNodeResolver{Label: minikubeProfileLabelKey + "=" + cluster}
+}
+
+// MinikubeClusters returns the names of the minikube profiles with containers
+func MinikubeClusters() ([]string, error) {
+	values, err := ListLabelValues(minikubeCreatedByLabelKey+"=true", minikubeProfileLabelKey)
+	if err != nil {
+		return nil, err
+	}
+	seen := map[string]bool{}
+	clusters := []string{}
+	for _, name := range values {
+		if name == "" || seen[name] {
+			continue
+		}
+		seen[name] = true
+		clusters = append(clusters, name)
+	}
+	sort.Strings(clusters)
+	return clusters, nil
+}
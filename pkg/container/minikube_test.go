@@ -0,0 +1,39 @@
+package container
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestMinikubeNodeResolver(t *testing.T) {
+	fake := NewFake()
+	defer SetRuntime(fake)()
+	fake.AddContainer(&FakeContainer{Name: "minikube", Labels: map[string]string{"created_by.minikube.sigs.k8s.io": "true", "name.minikube.sigs.k8s.io": "minikube"}})
+	fake.AddContainer(&FakeContainer{Name: "minikube-m02", Labels: map[string]string{"created_by.minikube.sigs.k8s.io": "true", "name.minikube.sigs.k8s.io": "minikube"}})
+	fake.AddContainer(&FakeContainer{Name: "dev", Labels: map[string]string{"created_by.minikube.sigs.k8s.io": "true", "name.minikube.sigs.k8s.io": "dev"}})
+	fake.AddContainer(&FakeContainer{Name: "kind-control-plane", Labels: map[string]string{"io.x-k8s.kind.cluster": "kind"}})
+
+	clusters, err := MinikubeClusters()
+	if err != nil {
+		t.Fatalf("MinikubeClusters() error = %v", err)
+	}
+	if diff := cmp.Diff([]string{"dev", "minikube"}, clusters); diff != "" {
+		t.Errorf("MinikubeClusters() mismatch (-want +got):\n%s", diff)
+	}
+
+	defer SetNodeResolver("minikube", MinikubeNodeResolver{})()
+	nodes, err := NodeContainers("minikube")
+	if err != nil {
+		t.Fatalf("NodeContainers() error = %v", err)
+	}
+	if diff := cmp.Diff([]string{"minikube", "minikube-m02"}, nodes); diff != "" {
+		t.Errorf("NodeContainers() mismatch (-want +got):\n%s", diff)
+	}
+	if name, err := NodeContainer("minikube", "minikube-m02"); err != nil || name != "minikube-m02" {
+		t.Errorf("NodeContainer() = %q, %v, want minikube-m02", name, err)
+	}
+	if name, err := NodeContainer("minikube", "dev"); err != nil || name != "" {
+		t.Errorf("NodeContainer() of the node of another profile = %q, %v", name, err)
+	}
+}
@@ -0,0 +1,109 @@
+package container
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"sigs.k8s.io/cloud-provider-kind/pkg/constants"
+)
+
+// NodeResolver finds the containers of the nodes of a cluster. The kind clusters label their
+// node containers with the cluster name and name them after the nodes, the clusters of the
+// other tools running the nodes in containers, ex. k3d or minikube with the docker driver,
+// are resolved with their own labels or a custom strategy.
+type NodeResolver interface {
+	// NodeContainers returns the node containers of the cluster, by name or ID
+	NodeContainers(cluster string) ([]string, error)
+	// NodeContainer returns the name of the container of the node, or empty if the node
+	// does not run in a container of the cluster
+	NodeContainer(cluster string, node string) (string, error)
+}
+
+// LabelNodeResolver resolves the node containers with a label, the nodes have the names
+// of their containers, that is the case of kind, k3d and minikube.
+type LabelNodeResolver struct {
+	// Label selects the node containers, KEY=VALUE, ex. k3d.cluster=mycluster
+	Label string
+}
+
+// NodeContainers returns the containers with the label
+func (r LabelNodeResolver) NodeContainers(cluster string) ([]string, error) {
+	return ListByLabel(r.Label)
+}
+
+// NodeContainer returns the container named after the node if it has the label
+func (r LabelNodeResolver) NodeContainer(cluster string, node string) (string, error) {
+	if !Exist(node) {
+		return "", nil
+	}
+	key, value, _ := strings.Cut(r.Label, "=")
+	v, err := GetLabelValue(node, key)
+	if err != nil {
+		return "", err
+	}
+	if v != value {
+		return "", nil
+	}
+	return node, nil
+}
+
+var (
+	nodeResolversLock sync.Mutex
+	// nodeResolvers are the resolvers of the clusters not created by kind
+	nodeResolvers = map[string]NodeResolver{}
+)
+
+// SetNodeResolver sets the resolver of the node containers of the cluster, the kind clusters
+// do not need one. It returns a function to restore the previous resolver.
+func SetNodeResolver(cluster string, resolver NodeResolver) (restore func()) {
+	nodeResolversLock.Lock()
+	defer nodeResolversLock.Unlock()
+	previous, ok := nodeResolvers[cluster]
+	nodeResolvers[cluster] = resolver
+	return func() {
+		nodeResolversLock.Lock()
+		defer nodeResolversLock.Unlock()
+		if ok {
+			nodeResolvers[cluster] = previous
+		} else {
+			delete(nodeResolvers, cluster)
+		}
+	}
+}
+
+func nodeResolver(cluster string) NodeResolver {
+	nodeResolversLock.Lock()
+	defer nodeResolversLock.Unlock()
+	if resolver, ok := nodeResolvers[cluster]; ok {
+		return resolver
+	}
+	// the kind node containers are labeled with the cluster name
+	return LabelNodeResolver{Label: fmt.Sprintf("%s=%s", constants.KindClusterLabelKey, cluster)}
+}
+
+// NodeContainers returns the node containers of the cluster
+func NodeContainers(cluster string) ([]string, error) {
+	return nodeResolver(cluster).NodeContainers(cluster)
+}
+
+// NodeContainer returns the container of the node of the cluster, or empty if the node
+// does not run in a container
+func NodeContainer(cluster string, node string) (string, error) {
+	return nodeResolver(cluster).NodeContainer(cluster, node)
+}
+
+// DetectNodeLabel returns the label of the node containers of the minikube clusters from
+// the name of the kubeconfig context, the profile name, that is checked to have containers.
+// The k3d clusters are resolved by the K3dNodeResolver.
+func DetectNodeLabel(context string) (string, error) {
+	label := minikubeNodeResolver(context).Label
+	containers, err := ListByLabel(label)
+	if err != nil {
+		return "", err
+	}
+	if len(containers) == 0 {
+		return "", fmt.Errorf("unable to detect the node containers of %s, only k3d and minikube clusters are detected", context)
+	}
+	return label, nil
+}
@@ -0,0 +1,74 @@
+package container
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestNodeResolver(t *testing.T) {
+	fake := NewFake()
+	defer SetRuntime(fake)()
+	fake.AddContainer(&FakeContainer{Name: "kind-control-plane", Labels: map[string]string{"io.x-k8s.kind.cluster": "kind"}})
+	fake.AddContainer(&FakeContainer{Name: "other-control-plane", Labels: map[string]string{"io.x-k8s.kind.cluster": "other"}})
+	fake.AddContainer(&FakeContainer{Name: "k3d-dev-server-0", Labels: map[string]string{"k3d.cluster": "dev"}})
+	fake.AddContainer(&FakeContainer{Name: "k3d-dev-agent-0", Labels: map[string]string{"k3d.cluster": "dev"}})
+
+	// the kind clusters do not need a resolver
+	nodes, err := NodeContainers("kind")
+	if err != nil {
+		t.Fatalf("NodeContainers() error = %v", err)
+	}
+	if diff := cmp.Diff([]string{"kind-control-plane"}, nodes); diff != "" {
+		t.Errorf("NodeContainers() mismatch (-want +got):\n%s", diff)
+	}
+	if name, _ := NodeContainer("kind", "other-control-plane"); name != "" {
+		t.Errorf("NodeContainer() of the node of another cluster = %q", name)
+	}
+
+	restore := SetNodeResolver("k3d-dev", LabelNodeResolver{Label: "k3d.cluster=dev"})
+	nodes, err = NodeContainers("k3d-dev")
+	if err != nil {
+		t.Fatalf("NodeContainers() error = %v", err)
+	}
+	if diff := cmp.Diff([]string{"k3d-dev-agent-0", "k3d-dev-server-0"}, nodes); diff != "" {
+		t.Errorf("NodeContainers() mismatch (-want +got):\n%s", diff)
+	}
+	if name, err := NodeContainer("k3d-dev", "k3d-dev-agent-0"); err != nil || name != "k3d-dev-agent-0" {
+		t.Errorf("NodeContainer() = %q, %v, want k3d-dev-agent-0", name, err)
+	}
+	if name, err := NodeContainer("k3d-dev", "missing"); err != nil || name != "" {
+		t.Errorf("NodeContainer() of a missing node = %q, %v", name, err)
+	}
+
+	restore()
+	if nodes, _ := NodeContainers("k3d-dev"); len(nodes) != 0 {
+		t.Errorf("NodeContainers() after restoring the resolver = %v, want the kind nodes of the cluster", nodes)
+	}
+}
+
+func TestDetectNodeLabel(t *testing.T) {
+	fake := NewFake()
+	defer SetRuntime(fake)()
+	fake.AddContainer(&FakeContainer{Name: "minikube", Labels: map[string]string{"name.minikube.sigs.k8s.io": "minikube"}})
+
+	tests := []struct {
+		context string
+		want    string
+		wantErr bool
+	}{
+		{context: "minikube", want: "name.minikube.sigs.k8s.io=minikube"},
+		{context: "docker-desktop", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.context, func(t *testing.T) {
+			got, err := DetectNodeLabel(tt.context)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("DetectNodeLabel() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("DetectNodeLabel() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,311 @@
+package container
+
+import (
+	"context"
+	"io"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+
+	"k8s.io/client-go/util/flowcontrol"
+	"k8s.io/component-base/metrics"
+	"k8s.io/component-base/metrics/legacyregistry"
+)
+
+var (
+	throttledCalls = metrics.NewCounterVec(
+		&metrics.CounterOpts{
+			Namespace:      "cloud_provider_kind",
+			Subsystem:      "container_runtime",
+			Name:           "throttled_calls_total",
+			Help:           "Number of calls to the container runtime delayed by the rate limiter, by method.",
+			StabilityLevel: metrics.ALPHA,
+		},
+		[]string{"method"},
+	)
+	throttleWait = metrics.NewHistogram(
+		&metrics.HistogramOpts{
+			Namespace:      "cloud_provider_kind",
+			Subsystem:      "container_runtime",
+			Name:           "throttle_wait_seconds",
+			Help:           "Time the throttled calls to the container runtime waited for the rate limiter.",
+			Buckets:        metrics.ExponentialBuckets(0.01, 2, 12),
+			StabilityLevel: metrics.ALPHA,
+		},
+	)
+	inspectCacheHits = metrics.NewCounterVec(
+		&metrics.CounterOpts{
+			Namespace:      "cloud_provider_kind",
+			Subsystem:      "container_runtime",
+			Name:           "inspect_cache_hits_total",
+			Help:           "Number of container inspections served from the cache instead of the container runtime, by method.",
+			StabilityLevel: metrics.ALPHA,
+		},
+		[]string{"method"},
+	)
+)
+
+func init() {
+	legacyregistry.MustRegister(throttledCalls, throttleWait, inspectCacheHits)
+}
+
+// RateLimiter is a Runtime that limits the calls to the runtime it wraps with a token bucket
+// and caches the inspections of the containers for a short time, so the bursts of Service
+// changes of all the clusters do not overload the container runtime daemon. The cache is
+// shared by all the callers and it is dropped by any call that modifies a container.
+type RateLimiter struct {
+	runtime Runtime
+	// limiter is nil if the calls are not limited
+	limiter flowcontrol.RateLimiter
+	ttl     time.Duration
+	now     func() time.Time
+
+	mu    sync.Mutex
+	cache map[inspectKey]inspection
+	// generation is incremented by the invalidations, the inspections started before are
+	// not cached
+	generation int
+}
+
+var _ Runtime = &RateLimiter{}
+
+type inspectKey struct {
+	method string
+	name   string
+	arg    string
+}
+
+type inspection struct {
+	value   interface{}
+	expires time.Time
+}
+
+// LimitCalls replaces the current runtime with a RateLimiter wrapping it and returns a function
+// that restores it, it must not be called while the controllers are running. qps and burst
+// configure the token bucket, 0 qps does not limit the calls, and ttl is the time the container
+// inspections are cached, 0 disables the cache.
+func LimitCalls(qps float32, burst int, ttl time.Duration) (*RateLimiter, func()) {
+	limiter := &RateLimiter{runtime: current, ttl: ttl, now: time.Now, cache: map[inspectKey]inspection{}}
+	if qps > 0 {
+		limiter.limiter = flowcontrol.NewTokenBucketRateLimiter(qps, burst)
+	}
+	return limiter, SetRuntime(limiter)
+}
+
+// wait blocks until the call is allowed by the token bucket, or the context is done
+func (r *RateLimiter) wait(ctx context.Context, method string) {
+	if r.limiter == nil || r.limiter.TryAccept() {
+		return
+	}
+	throttledCalls.WithLabelValues(method).Inc()
+	start := time.Now()
+	// the runtime fails the call if the context is done
+	_ = r.limiter.Wait(ctx)
+	throttleWait.Observe(time.Since(start).Seconds())
+}
+
+// invalidate drops the cached inspections, the containers were modified. The inspections of
+// all the containers are dropped since they may be cached by name or by ID.
+func (r *RateLimiter) invalidate() {
+	r.mu.Lock()
+	clear(r.cache)
+	r.generation++
+	r.mu.Unlock()
+}
+
+// inspect returns the cached result of the inspection, or calls inspect and caches its result
+// if it succeeded
+func inspect[T any](r *RateLimiter, key inspectKey, inspect func() (T, error)) (T, error) {
+	r.mu.Lock()
+	cached, ok := r.cache[key]
+	generation := r.generation
+	r.mu.Unlock()
+	if ok && r.now().Before(cached.expires) {
+		inspectCacheHits.WithLabelValues(key.method).Inc()
+		return cached.value.(T), nil
+	}
+	r.wait(context.Background(), key.method)
+	value, err := inspect()
+	if err == nil && r.ttl > 0 {
+		r.mu.Lock()
+		if generation == r.generation {
+			r.cache[key] = inspection{value: value, expires: r.now().Add(r.ttl)}
+		}
+		r.mu.Unlock()
+	}
+	return value, err
+}
+
+func (r *RateLimiter) Create(name string, args []string, command ...string) error {
+	r.wait(context.Background(), "Create")
+	defer r.invalidate()
+	return r.runtime.Create(name, args, command...)
+}
+
+func (r *RateLimiter) Restart(name string) error {
+	r.wait(context.Background(), "Restart")
+	defer r.invalidate()
+	return r.runtime.Restart(name)
+}
+
+func (r *RateLimiter) Delete(name string) error {
+	r.wait(context.Background(), "Delete")
+	defer r.invalidate()
+	return r.runtime.Delete(name)
+}
+
+func (r *RateLimiter) IsRunning(name string) bool {
+	running, _ := inspect(r, inspectKey{method: "IsRunning", name: name}, func() (bool, error) {
+		return r.runtime.IsRunning(name), nil
+	})
+	return running
+}
+
+func (r *RateLimiter) Exist(name string) bool {
+	exist, _ := inspect(r, inspectKey{method: "Exist", name: name}, func() (bool, error) {
+		return r.runtime.Exist(name), nil
+	})
+	return exist
+}
+
+func (r *RateLimiter) Signal(name string, signal string) error {
+	r.wait(context.Background(), "Signal")
+	defer r.invalidate()
+	return r.runtime.Signal(name, signal)
+}
+
+func (r *RateLimiter) Exec(name string, command []string, stdin io.Reader, stdout io.Writer, stderr io.Writer) error {
+	r.wait(context.Background(), "Exec")
+	return r.runtime.Exec(name, command, stdin, stdout, stderr)
+}
+
+func (r *RateLimiter) IPs(name string) (string, string, error) {
+	ips, err := inspect(r, inspectKey{method: "IPs", name: name}, func() ([2]string, error) {
+		ipv4, ipv6, err := r.runtime.IPs(name)
+		return [2]string{ipv4, ipv6}, err
+	})
+	return ips[0], ips[1], err
+}
+
+func (r *RateLimiter) Networks(name string) ([]string, error) {
+	networks, err := inspect(r, inspectKey{method: "Networks", name: name}, func() ([]string, error) {
+		return r.runtime.Networks(name)
+	})
+	// the callers must not modify the cached result
+	return slices.Clone(networks), err
+}
+
+func (r *RateLimiter) ConnectNetwork(name string, network string) error {
+	r.wait(context.Background(), "ConnectNetwork")
+	defer r.invalidate()
+	return r.runtime.ConnectNetwork(name, network)
+}
+
+func (r *RateLimiter) PortMappings(name string) (map[string]string, error) {
+	ports, err := inspect(r, inspectKey{method: "PortMappings", name: name}, func() (map[string]string, error) {
+		return r.runtime.PortMappings(name)
+	})
+	return maps.Clone(ports), err
+}
+
+func (r *RateLimiter) ListByLabel(label string) ([]string, error) {
+	r.wait(context.Background(), "ListByLabel")
+	return r.runtime.ListByLabel(label)
+}
+
+func (r *RateLimiter) GetLabelValue(name string, label string) (string, error) {
+	return inspect(r, inspectKey{method: "GetLabelValue", name: name, arg: label}, func() (string, error) {
+		return r.runtime.GetLabelValue(name, label)
+	})
+}
+
+func (r *RateLimiter) ListLabelValues(label string, key string) (map[string]string, error) {
+	r.wait(context.Background(), "ListLabelValues")
+	return r.runtime.ListLabelValues(label, key)
+}
+
+func (r *RateLimiter) Image(name string) (string, error) {
+	return inspect(r, inspectKey{method: "Image", name: name}, func() (string, error) {
+		return r.runtime.Image(name)
+	})
+}
+
+func (r *RateLimiter) ID(name string) (string, error) {
+	return inspect(r, inspectKey{method: "ID", name: name}, func() (string, error) {
+		return r.runtime.ID(name)
+	})
+}
+
+func (r *RateLimiter) RunInNetNS(name string, image string, command []string) ([]string, error) {
+	r.wait(context.Background(), "RunInNetNS")
+	return r.runtime.RunInNetNS(name, image, command)
+}
+
+func (r *RateLimiter) NetworkSubnets(network string) ([]string, error) {
+	r.wait(context.Background(), "NetworkSubnets")
+	return r.runtime.NetworkSubnets(network)
+}
+
+func (r *RateLimiter) NetworkAddresses(network string) ([]string, error) {
+	r.wait(context.Background(), "NetworkAddresses")
+	return r.runtime.NetworkAddresses(network)
+}
+
+func (r *RateLimiter) EnsureImage(image string) error {
+	r.wait(context.Background(), "EnsureImage")
+	return r.runtime.EnsureImage(image)
+}
+
+func (r *RateLimiter) ImageExists(image string) bool {
+	r.wait(context.Background(), "ImageExists")
+	return r.runtime.ImageExists(image)
+}
+
+func (r *RateLimiter) LoadImage(archive io.Reader) error {
+	r.wait(context.Background(), "LoadImage")
+	return r.runtime.LoadImage(archive)
+}
+
+func (r *RateLimiter) PullImage(ctx context.Context, image string, platform string, progress io.Writer) error {
+	r.wait(ctx, "PullImage")
+	return r.runtime.PullImage(ctx, image, platform, progress)
+}
+
+func (r *RateLimiter) Ping(ctx context.Context) error {
+	r.wait(ctx, "Ping")
+	return r.runtime.Ping(ctx)
+}
+
+// ContainerEvents drops the cached inspections on every event, the containers may have been
+// modified outside of the controller, ex. a kind node restarted with new IPs
+func (r *RateLimiter) ContainerEvents(ctx context.Context, label string) (<-chan ContainerEvent, error) {
+	r.wait(ctx, "ContainerEvents")
+	events, err := r.runtime.ContainerEvents(ctx, label)
+	if err != nil {
+		return nil, err
+	}
+	forwarded := make(chan ContainerEvent)
+	go func() {
+		defer close(forwarded)
+		for event := range events {
+			r.invalidate()
+			select {
+			case forwarded <- event:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return forwarded, nil
+}
+
+// Rootless and Architecture are detected once by the runtime
+
+func (r *RateLimiter) Rootless() bool {
+	return r.runtime.Rootless()
+}
+
+func (r *RateLimiter) Architecture() string {
+	return r.runtime.Architecture()
+}
@@ -0,0 +1,109 @@
+package container
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestLimitCallsCache(t *testing.T) {
+	fake := NewFake()
+	defer SetRuntime(fake)()
+	fake.AddContainer(&FakeContainer{Name: "lb", IPv4: "172.18.0.5", Running: true})
+
+	counter, restoreCounter := CountCalls()
+	defer restoreCounter()
+	limiter, restore := LimitCalls(0, 0, time.Minute)
+	defer restore()
+	now := time.Now()
+	limiter.now = func() time.Time { return now }
+
+	for i := 0; i < 3; i++ {
+		if !Exist("lb") || !IsRunning("lb") {
+			t.Fatalf("container lb not found")
+		}
+		if ipv4, _, err := IPs("lb"); err != nil || ipv4 != "172.18.0.5" {
+			t.Fatalf("IPs() = %s, %v", ipv4, err)
+		}
+	}
+	want := map[string]int{"Exist": 1, "IsRunning": 1, "IPs": 1}
+	if got := counter.Calls(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Calls() with the cache = %v, want %v", got, want)
+	}
+
+	// the errors are not cached
+	for i := 0; i < 2; i++ {
+		if _, err := Image("missing"); err == nil {
+			t.Errorf("Image() of a missing container did not fail")
+		}
+	}
+	if got := counter.Calls()["Image"]; got != 2 {
+		t.Errorf("Image() calls = %d, want 2", got)
+	}
+
+	// the inspections expire
+	now = now.Add(2 * time.Minute)
+	Exist("lb")
+	if got := counter.Calls()["Exist"]; got != 2 {
+		t.Errorf("Exist() calls after the expiration = %d, want 2", got)
+	}
+
+	// the modifications drop the cache
+	err := Delete("lb")
+	if err != nil {
+		t.Fatalf("Delete() unexpected error: %v", err)
+	}
+	if Exist("lb") {
+		t.Errorf("deleted container lb still exists")
+	}
+	if got := counter.Calls()["Exist"]; got != 3 {
+		t.Errorf("Exist() calls after the deletion = %d, want 3", got)
+	}
+}
+
+func TestLimitCallsThrottling(t *testing.T) {
+	fake := NewFake()
+	defer SetRuntime(fake)()
+	fake.AddContainer(&FakeContainer{Name: "lb", Running: true})
+
+	_, restore := LimitCalls(20, 1, 0)
+	defer restore()
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		Exist("lb")
+	}
+	// the burst allows the first call, the next ones wait 50ms each
+	if elapsed := time.Since(start); elapsed < 80*time.Millisecond {
+		t.Errorf("3 calls with 20 QPS and burst 1 took %v, want at least 100ms", elapsed)
+	}
+}
+
+func TestLimitCallsEvents(t *testing.T) {
+	fake := NewFake()
+	defer SetRuntime(fake)()
+	node := &FakeContainer{Name: "kind-worker", IPv4: "172.18.0.3", Labels: map[string]string{"cluster": "kind"}, Running: true}
+	fake.AddContainer(node)
+
+	_, restore := LimitCalls(0, 0, time.Minute)
+	defer restore()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events, err := ContainerEvents(ctx, "cluster")
+	if err != nil {
+		t.Fatalf("ContainerEvents() error = %v", err)
+	}
+	if ipv4, _, _ := IPs("kind-worker"); ipv4 != "172.18.0.3" {
+		t.Fatalf("IPs() = %s, want 172.18.0.3", ipv4)
+	}
+
+	// the node restarted outside of the controller gets a new IP
+	node.IPv4 = "172.18.0.7"
+	fake.Restart("kind-worker") // nolint:errcheck
+	if event := <-events; !event.Started() {
+		t.Fatalf("event %+v, want the container started", event)
+	}
+	if ipv4, _, _ := IPs("kind-worker"); ipv4 != "172.18.0.7" {
+		t.Errorf("IPs() after the event = %s, want 172.18.0.7", ipv4)
+	}
+}
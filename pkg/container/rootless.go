@@ -0,0 +1,45 @@
+package container
+
+import (
+	"strings"
+	"sync"
+
+	kindexec "sigs.k8s.io/kind/pkg/exec"
+)
+
+var (
+	rootlessOnce sync.Once
+	rootless     bool
+)
+
+func (cliRuntime) Rootless() bool {
+	rootlessOnce.Do(func() {
+		format := "{{json .SecurityOptions}}"
+		if containerRuntime == "podman" {
+			format = "{{.Host.Security.Rootless}}"
+		}
+		cmd := kindexec.Command(containerRuntime, "info", "--format", format)
+		lines, err := kindexec.OutputLines(cmd)
+		if err != nil {
+			return
+		}
+		rootless = isRootless(strings.Join(lines, "\n"))
+	})
+	return rootless
+}
+
+// isRootless parses the output of the info command, the docker security options
+// or the podman rootless field
+func isRootless(info string) bool {
+	info = strings.TrimSpace(info)
+	return info == "true" || strings.Contains(info, "name=rootless")
+}
+
+// privilegeArgs returns the arguments to run privileged containers and commands, in
+// rootless mode only the capabilities required to configure the network are added.
+func privilegeArgs() []string {
+	if Rootless() {
+		return []string{"--cap-add=NET_ADMIN"}
+	}
+	return []string{"--privileged"}
+}
@@ -0,0 +1,23 @@
+package container
+
+import "testing"
+
+func Test_isRootless(t *testing.T) {
+	tests := []struct {
+		info string
+		want bool
+	}{
+		{info: `["name=seccomp,profile=builtin","name=cgroupns"]`, want: false},
+		{info: `["name=seccomp,profile=builtin","name=rootless","name=cgroupns"]`, want: true},
+		{info: "true", want: true},
+		{info: "false\n", want: false},
+		{info: "", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.info, func(t *testing.T) {
+			if got := isRootless(tt.info); got != tt.want {
+				t.Errorf("isRootless() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,165 @@
+package container
+
+import (
+	"context"
+	"io"
+)
+
+// Runtime is the container runtime used by the functions of the package, the docker or
+// podman CLI by default. The tests replace it with a Fake to run the loadbalancer flows
+// without a container runtime.
+type Runtime interface {
+	// Create creates and starts the container, args are the arguments of the run command
+	// with the image as the last one, command overrides the command of the image
+	Create(name string, args []string, command ...string) error
+	Restart(name string) error
+	Delete(name string) error
+	IsRunning(name string) bool
+	Exist(name string) bool
+	Signal(name string, signal string) error
+	Exec(name string, command []string, stdin io.Reader, stdout io.Writer, stderr io.Writer) error
+	IPs(name string) (ipv4 string, ipv6 string, err error)
+	Networks(name string) ([]string, error)
+	ConnectNetwork(name string, network string) error
+	// PortMappings returns the first host port of each published container port, the key
+	// is the container port and the protocol as reported by the runtime, ex. 80/tcp
+	PortMappings(name string) (map[string]string, error)
+	// ListByLabel returns the containers with the label, in the format key or key=value
+	ListByLabel(label string) ([]string, error)
+	// GetLabelValue returns the value of the label of the container, empty if not set
+	GetLabelValue(name string, label string) (string, error)
+	// ListLabelValues returns the value of the label key of the containers with the label,
+	// in the format key or key=value, indexed by the container
+	ListLabelValues(label string, key string) (map[string]string, error)
+	Image(name string) (string, error)
+	// ID returns the full ID of the container
+	ID(name string) (string, error)
+	RunInNetNS(name string, image string, command []string) ([]string, error)
+	NetworkSubnets(network string) ([]string, error)
+	NetworkAddresses(network string) ([]string, error)
+	EnsureImage(image string) error
+	// ImageExists returns true if the image is present
+	ImageExists(image string) bool
+	// LoadImage loads the images of the archive, in the docker save format
+	LoadImage(archive io.Reader) error
+	// PullImage pulls the image for the platform, ex. linux/arm64, or for the platform of
+	// the runtime if empty, the progress reported by the runtime is written to progress
+	PullImage(ctx context.Context, image string, platform string, progress io.Writer) error
+	Ping(ctx context.Context) error
+	// ContainerEvents streams the events of the containers with the label, in the format
+	// key or key=value, the channel is closed when the context is done or the stream fails
+	ContainerEvents(ctx context.Context, label string) (<-chan ContainerEvent, error)
+	Rootless() bool
+	// Architecture returns the architecture of the container runtime host with the GOARCH
+	// names, ex. arm64, empty if it can not be detected
+	Architecture() string
+}
+
+// cliRuntime runs the docker or podman CLI
+type cliRuntime struct{}
+
+var current Runtime = cliRuntime{}
+
+// SetRuntime replaces the container runtime and returns a function that restores the
+// previous one, it must not be called while the controllers are running.
+func SetRuntime(runtime Runtime) (restore func()) {
+	previous := current
+	current = runtime
+	return func() { current = previous }
+}
+
+func Create(name string, args []string, command ...string) error {
+	return current.Create(name, args, command...)
+}
+
+func Restart(name string) error { return current.Restart(name) }
+
+// Delete removes the container, it does not fail if the container does not exist
+func Delete(name string) error { return current.Delete(name) }
+
+func IsRunning(name string) bool { return current.IsRunning(name) }
+
+func Exist(name string) bool { return current.Exist(name) }
+
+func Signal(name string, signal string) error { return current.Signal(name, signal) }
+
+func Exec(name string, command []string, stdin io.Reader, stdout io.Writer, stderr io.Writer) error {
+	return current.Exec(name, command, stdin, stdout, stderr)
+}
+
+// IPs returns the addresses of the container, if it is attached to multiple networks the
+// addresses of the network it was created on.
+func IPs(name string) (ipv4 string, ipv6 string, err error) { return current.IPs(name) }
+
+// Networks returns the networks the container is attached to
+func Networks(name string) ([]string, error) { return current.Networks(name) }
+
+// ConnectNetwork attaches the container to the network
+func ConnectNetwork(name string, network string) error { return current.ConnectNetwork(name, network) }
+
+// PortMappings returns the first host port of each published container port
+func PortMappings(name string) (map[string]string, error) { return current.PortMappings(name) }
+
+func ListByLabel(label string) ([]string, error) { return current.ListByLabel(label) }
+
+// GetLabelValue return the value of the associated label
+func GetLabelValue(name string, label string) (string, error) {
+	return current.GetLabelValue(name, label)
+}
+
+// ListLabelValues returns the value of the label key of each container with the label, it
+// replaces ListByLabel and a GetLabelValue per container with a single call.
+func ListLabelValues(label string, key string) (map[string]string, error) {
+	return current.ListLabelValues(label, key)
+}
+
+// Image returns the image of the container
+func Image(name string) (string, error) { return current.Image(name) }
+
+// ID returns the full ID of the container
+func ID(name string) (string, error) { return current.ID(name) }
+
+// RunInNetNS runs the command in a new ephemeral privileged container using the image
+// that shares the network namespace of the container name, returning its output.
+func RunInNetNS(name string, image string, command []string) ([]string, error) {
+	return current.RunInNetNS(name, image, command)
+}
+
+// NetworkSubnets returns the subnets configured on the network
+func NetworkSubnets(network string) ([]string, error) { return current.NetworkSubnets(network) }
+
+// NetworkAddresses returns the addresses in use on the network, the gateways and the
+// addresses of the containers attached to it.
+func NetworkAddresses(network string) ([]string, error) { return current.NetworkAddresses(network) }
+
+// EnsureImage pulls the image if it is not present
+func EnsureImage(image string) error { return current.EnsureImage(image) }
+
+// ImageExists returns true if the image is present
+func ImageExists(image string) bool { return current.ImageExists(image) }
+
+// LoadImage loads the images of the archive, in the docker save format, to use the images
+// without registry access
+func LoadImage(archive io.Reader) error { return current.LoadImage(archive) }
+
+// Ping returns an error if the container runtime is not reachable
+func Ping(ctx context.Context) error { return current.Ping(ctx) }
+
+// ContainerEvents streams the events of the containers with the label until the context is done
+func ContainerEvents(ctx context.Context, label string) (<-chan ContainerEvent, error) {
+	return current.ContainerEvents(ctx, label)
+}
+
+// Rootless returns true if the container runtime runs without root privileges. The
+// containers run in a user namespace with the networks behind slirp4netns or pasta, so
+// their IPs are not reachable from the host and privileged containers are not allowed.
+func Rootless() bool { return current.Rootless() }
+
+// PullImage pulls the image for the platform, ex. linux/arm64, writing the progress to progress
+func PullImage(ctx context.Context, image string, platform string, progress io.Writer) error {
+	return current.PullImage(ctx, image, platform, progress)
+}
+
+// Architecture returns the architecture of the container runtime host, that may not be the
+// one of the controller, ex. with a remote docker daemon
+func Architecture() string { return current.Architecture() }
@@ -0,0 +1,82 @@
+package container
+
+import (
+	"net"
+	"os"
+	"strings"
+
+	kindexec "sigs.k8s.io/kind/pkg/exec"
+)
+
+const (
+	// WSLNetworkingNAT is the default WSL2 networking, the VM is behind a NAT and the ports
+	// listening on it are forwarded to the Windows localhost
+	WSLNetworkingNAT = "nat"
+	// WSLNetworkingMirrored the VM shares the network interfaces of the Windows host
+	WSLNetworkingMirrored = "mirrored"
+)
+
+// WSLEnvironment is the result of the WSL2 detection
+type WSLEnvironment struct {
+	// Enabled is true if the controller runs on a WSL2 VM
+	Enabled bool
+	// NetworkingMode is the WSL2 networking mode, nat or mirrored
+	NetworkingMode string
+	// DockerDesktop is true if the container runtime is Docker Desktop, that runs in its own
+	// VM and publishes the ports on the Windows host
+	DockerDesktop bool
+	// Address is the address of the VM reachable from the Windows host
+	Address string
+}
+
+// DetectWSL detects if the controller runs on WSL2, where the container IPs are not
+// reachable from the Windows host.
+func DetectWSL() WSLEnvironment {
+	procVersion, err := os.ReadFile("/proc/version")
+	if err != nil || !isWSL2(string(procVersion)) {
+		return WSLEnvironment{}
+	}
+	env := WSLEnvironment{Enabled: true, NetworkingMode: WSLNetworkingNAT}
+	// wslinfo is only available on recent versions, that are the ones supporting mirrored mode
+	if lines, err := kindexec.OutputLines(kindexec.Command("wslinfo", "--networking-mode")); err == nil && len(lines) == 1 {
+		env.NetworkingMode = strings.TrimSpace(lines[0])
+	}
+	if lines, err := kindexec.OutputLines(kindexec.Command(containerRuntime, "info", "--format", "{{.OperatingSystem}}")); err == nil && len(lines) == 1 {
+		env.DockerDesktop = lines[0] == "Docker Desktop"
+	}
+	env.Address = wslHostAddress(env, interfaceIPv4("eth0"))
+	return env
+}
+
+// isWSL2 returns true if the kernel version is the one of the WSL2 VMs
+func isWSL2(procVersion string) bool {
+	procVersion = strings.ToLower(procVersion)
+	return strings.Contains(procVersion, "microsoft") && strings.Contains(procVersion, "wsl2")
+}
+
+// wslHostAddress returns the address to report for the ports published on the host: the
+// Windows localhost for Docker Desktop and mirrored networking, or the VM address with NAT,
+// that is reachable from both the Windows host and the VM.
+func wslHostAddress(env WSLEnvironment, vmAddress string) string {
+	if env.DockerDesktop || env.NetworkingMode == WSLNetworkingMirrored || vmAddress == "" {
+		return "127.0.0.1"
+	}
+	return vmAddress
+}
+
+func interfaceIPv4(name string) string {
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return ""
+	}
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return ""
+	}
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok && ipNet.IP.To4() != nil {
+			return ipNet.IP.String()
+		}
+	}
+	return ""
+}
@@ -0,0 +1,42 @@
+package container
+
+import "testing"
+
+func Test_isWSL2(t *testing.T) {
+	tests := []struct {
+		procVersion string
+		want        bool
+	}{
+		{procVersion: "Linux version 5.15.153.1-microsoft-standard-WSL2 (root@941d701f84f1) (gcc (GCC) 11.2.0)", want: true},
+		{procVersion: "Linux version 4.4.0-19041-Microsoft (Microsoft@Microsoft.com) (gcc version 5.4.0)", want: false},
+		{procVersion: "Linux version 6.5.0-35-generic (buildd@lcy02-amd64-079) (x86_64-linux-gnu-gcc-12)", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.procVersion, func(t *testing.T) {
+			if got := isWSL2(tt.procVersion); got != tt.want {
+				t.Errorf("isWSL2() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_wslHostAddress(t *testing.T) {
+	tests := []struct {
+		name      string
+		env       WSLEnvironment
+		vmAddress string
+		want      string
+	}{
+		{name: "nat", env: WSLEnvironment{NetworkingMode: WSLNetworkingNAT}, vmAddress: "172.28.16.5", want: "172.28.16.5"},
+		{name: "mirrored", env: WSLEnvironment{NetworkingMode: WSLNetworkingMirrored}, vmAddress: "192.168.1.20", want: "127.0.0.1"},
+		{name: "docker desktop", env: WSLEnvironment{NetworkingMode: WSLNetworkingNAT, DockerDesktop: true}, vmAddress: "172.28.16.5", want: "127.0.0.1"},
+		{name: "no vm address", env: WSLEnvironment{NetworkingMode: WSLNetworkingNAT}, want: "127.0.0.1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := wslHostAddress(tt.env, tt.vmAddress); got != tt.want {
+				t.Errorf("wslHostAddress() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
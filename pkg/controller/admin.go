@@ -0,0 +1,227 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+
+	"sigs.k8s.io/cloud-provider-kind/pkg/config"
+	"sigs.k8s.io/cloud-provider-kind/pkg/loadbalancer"
+)
+
+// LoadBalancerInfo is a loadbalancer reported by the admin API and the list and describe
+// commands
+type LoadBalancerInfo struct {
+	Cluster   string `json:"cluster"`
+	Namespace string `json:"namespace"`
+	Service   string `json:"service"`
+	loadbalancer.Description
+	// Ingress are the addresses published in the Service status
+	Ingress   []string   `json:"ingress,omitempty"`
+	LastError *SyncError `json:"lastError,omitempty"`
+	// History are the last syncs of the loadbalancer, the oldest first, only reported with
+	// the config
+	History []SyncAttempt `json:"history,omitempty"`
+}
+
+// admin serves a local API to debug the loadbalancers: it lists them with their
+// containers, addresses and last sync errors, shows the proxy config and the sync history
+// of each one, and resyncs or recreates them on demand.
+type admin struct {
+	mu       sync.Mutex
+	clusters map[string]*serviceController
+}
+
+func newAdmin() *admin {
+	return &admin{clusters: map[string]*serviceController{}}
+}
+
+func (a *admin) addCluster(name string, c *serviceController) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.clusters[name] = c
+}
+
+func (a *admin) removeCluster(name string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	delete(a.clusters, name)
+}
+
+func (a *admin) cluster(name string) (*serviceController, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	c, ok := a.clusters[name]
+	return c, ok
+}
+
+func (a *admin) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /loadbalancers", a.list)
+	mux.HandleFunc("GET /loadbalancers/{cluster}/{namespace}/{name}", a.get)
+	mux.HandleFunc("POST /loadbalancers/{cluster}/{namespace}/{name}/resync", a.resync)
+	mux.HandleFunc("POST /loadbalancers/{cluster}/{namespace}/{name}/recreate", a.recreate)
+	return mux
+}
+
+// start serves the admin API until the context is cancelled
+func (a *admin) start(ctx context.Context) {
+	server := &http.Server{Addr: config.DefaultConfig.AdminAddress, Handler: a.handler()}
+	go func() {
+		<-ctx.Done()
+		server.Close() // nolint:errcheck
+	}()
+	go func() {
+		klog.InfoS("Serving the admin API", "url", "http://"+config.DefaultConfig.AdminAddress+"/loadbalancers")
+		err := server.ListenAndServe()
+		if err != nil && err != http.ErrServerClosed {
+			klog.ErrorS(err, "Admin server failed")
+		}
+	}()
+}
+
+// list returns the loadbalancers of all the clusters, without their config
+func (a *admin) list(w http.ResponseWriter, r *http.Request) {
+	a.mu.Lock()
+	names := []string{}
+	for name := range a.clusters {
+		names = append(names, name)
+	}
+	a.mu.Unlock()
+	sort.Strings(names)
+
+	loadBalancers := []LoadBalancerInfo{}
+	for _, name := range names {
+		c, ok := a.cluster(name)
+		if !ok {
+			continue
+		}
+		services, err := c.serviceLister.List(labels.Everything())
+		if err != nil {
+			http.Error(w, fmt.Sprintf("failed to list the Services of cluster %s: %v", name, err), http.StatusInternalServerError)
+			return
+		}
+		sort.Slice(services, func(i, j int) bool {
+			if services[i].Namespace != services[j].Namespace {
+				return services[i].Namespace < services[j].Namespace
+			}
+			return services[i].Name < services[j].Name
+		})
+		for _, service := range services {
+			if wantsLoadBalancer(service) && inScope(service) {
+				loadBalancers = append(loadBalancers, a.describe(r.Context(), c, service, false))
+			}
+		}
+	}
+	writeJSON(w, loadBalancers)
+}
+
+// get returns the loadbalancer of the Service with its config
+func (a *admin) get(w http.ResponseWriter, r *http.Request) {
+	c, service, ok := a.service(w, r)
+	if !ok {
+		return
+	}
+	writeJSON(w, a.describe(r.Context(), c, service, true))
+}
+
+// resync syncs the loadbalancer of the Service again
+func (a *admin) resync(w http.ResponseWriter, r *http.Request) {
+	c, service, ok := a.service(w, r)
+	if !ok {
+		return
+	}
+	c.enqueueService(service)
+	klog.InfoS("Resyncing loadbalancer from the admin API", "cluster", c.clusterName, "service", klog.KObj(service))
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// recreate deletes the loadbalancer container of the Service and syncs the Services
+// using it, so it is created again from scratch
+func (a *admin) recreate(w http.ResponseWriter, r *http.Request) {
+	c, service, ok := a.service(w, r)
+	if !ok {
+		return
+	}
+	recreator, ok := c.lbController.(loadbalancer.Recreator)
+	if !ok {
+		http.Error(w, "the loadbalancers of the cluster can not be recreated", http.StatusNotImplemented)
+		return
+	}
+	ctx := r.Context()
+	name := c.lbController.GetLoadBalancerName(ctx, c.clusterName, service)
+	klog.InfoS("Recreating loadbalancer from the admin API", "cluster", c.clusterName, "service", klog.KObj(service), "container", name)
+	if err := recreator.RecreateLoadBalancer(ctx, c.clusterName, service); err != nil {
+		http.Error(w, fmt.Sprintf("failed to delete loadbalancer %s: %v", name, err), http.StatusInternalServerError)
+		return
+	}
+	services, err := c.loadBalancerServices(ctx, name)
+	if err != nil {
+		services = []*v1.Service{service}
+	}
+	for _, service := range services {
+		c.enqueueService(service)
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// service returns the service controller and the Service of the request path, it replies
+// with an error if any of them does not exist
+func (a *admin) service(w http.ResponseWriter, r *http.Request) (*serviceController, *v1.Service, bool) {
+	cluster := r.PathValue("cluster")
+	c, ok := a.cluster(cluster)
+	if !ok {
+		http.Error(w, fmt.Sprintf("cluster %s not found", cluster), http.StatusNotFound)
+		return nil, nil, false
+	}
+	service, err := c.serviceLister.Services(r.PathValue("namespace")).Get(r.PathValue("name"))
+	if apierrors.IsNotFound(err) || (err == nil && (!wantsLoadBalancer(service) || !inScope(service))) {
+		http.Error(w, fmt.Sprintf("Service %s/%s of type LoadBalancer not found on cluster %s", r.PathValue("namespace"), r.PathValue("name"), cluster), http.StatusNotFound)
+		return nil, nil, false
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return nil, nil, false
+	}
+	return c, service, true
+}
+
+func (a *admin) describe(ctx context.Context, c *serviceController, service *v1.Service, withConfig bool) LoadBalancerInfo {
+	lb := LoadBalancerInfo{
+		Cluster:     c.clusterName,
+		Namespace:   service.Namespace,
+		Service:     service.Name,
+		Description: loadbalancer.DescribeLoadBalancer(c.lbController.GetLoadBalancerName(ctx, c.clusterName, service), withConfig),
+	}
+	for _, ingress := range service.Status.LoadBalancer.Ingress {
+		if ingress.IP != "" {
+			lb.Ingress = append(lb.Ingress, ingress.IP)
+		}
+		if ingress.Hostname != "" {
+			lb.Ingress = append(lb.Ingress, ingress.Hostname)
+		}
+	}
+	if key, err := cache.MetaNamespaceKeyFunc(service); err == nil {
+		if err, ok := c.lastError(key); ok {
+			lb.LastError = &err
+		}
+		if withConfig {
+			lb.History = c.syncs(key)
+		}
+	}
+	return lb
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v) // nolint:errcheck
+}
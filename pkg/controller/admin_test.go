@@ -0,0 +1,104 @@
+package controller
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+
+	"sigs.k8s.io/cloud-provider-kind/pkg/container"
+)
+
+func TestAdmin(t *testing.T) {
+	fake := container.NewFake()
+	defer container.SetRuntime(fake)()
+	fake.AddContainer(&container.FakeContainer{Name: "lb-web", Running: true, IPv4: "172.18.0.10", Networks: []string{"kind"}})
+
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	for _, svc := range []*v1.Service{
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "ns"},
+			Spec:       v1.ServiceSpec{Type: v1.ServiceTypeLoadBalancer},
+			Status:     v1.ServiceStatus{LoadBalancer: v1.LoadBalancerStatus{Ingress: []v1.LoadBalancerIngress{{IP: "172.18.0.10"}}}},
+		},
+		{ObjectMeta: metav1.ObjectMeta{Name: "pending", Namespace: "ns"}, Spec: v1.ServiceSpec{Type: v1.ServiceTypeLoadBalancer}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "cluster-ip", Namespace: "ns"}, Spec: v1.ServiceSpec{Type: v1.ServiceTypeClusterIP}},
+	} {
+		if err := indexer.Add(svc); err != nil {
+			t.Fatal(err)
+		}
+	}
+	healer := &fakeHealer{}
+	c := &serviceController{
+		clusterName:   "kind",
+		lbController:  healer,
+		serviceLister: corelisters.NewServiceLister(indexer),
+		queue:         workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter()),
+		lastErrors:    map[string]SyncError{},
+	}
+	defer c.queue.ShutDown()
+	c.lastErrors["ns/pending"] = SyncError{Message: "no healthy backends", Time: time.Now()}
+	a := newAdmin()
+	a.addCluster("kind", c)
+	server := httptest.NewServer(a.handler())
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/loadbalancers")
+	if err != nil {
+		t.Fatalf("GET /loadbalancers unexpected error: %v", err)
+	}
+	var list []LoadBalancerInfo
+	err = json.NewDecoder(resp.Body).Decode(&list)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatalf("invalid /loadbalancers response: %v", err)
+	}
+	if len(list) != 2 || list[0].Service != "pending" || list[1].Service != "web" {
+		t.Fatalf("GET /loadbalancers = %+v, want the pending and web Services", list)
+	}
+	if list[0].Exists || list[0].LastError == nil || list[0].LastError.Message != "no healthy backends" {
+		t.Errorf("pending loadbalancer = %+v, want no container and the last error", list[0])
+	}
+	if !list[1].Running || list[1].IPv4 != "172.18.0.10" || !reflect.DeepEqual(list[1].Ingress, []string{"172.18.0.10"}) || list[1].LastError != nil {
+		t.Errorf("web loadbalancer = %+v, want the running container and the ingress", list[1])
+	}
+
+	tests := []struct {
+		method string
+		path   string
+		want   int
+	}{
+		{method: http.MethodGet, path: "/loadbalancers/kind/ns/web", want: http.StatusOK},
+		{method: http.MethodGet, path: "/loadbalancers/kind/ns/cluster-ip", want: http.StatusNotFound},
+		{method: http.MethodGet, path: "/loadbalancers/other/ns/web", want: http.StatusNotFound},
+		{method: http.MethodPost, path: "/loadbalancers/kind/ns/web/resync", want: http.StatusAccepted},
+		{method: http.MethodPost, path: "/loadbalancers/kind/ns/web/recreate", want: http.StatusAccepted},
+		{method: http.MethodGet, path: "/loadbalancers/kind/ns/web/recreate", want: http.StatusMethodNotAllowed},
+	}
+	for _, tt := range tests {
+		req, _ := http.NewRequest(tt.method, server.URL+tt.path, nil)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("%s %s unexpected error: %v", tt.method, tt.path, err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tt.want {
+			t.Errorf("%s %s = %d, want %d", tt.method, tt.path, resp.StatusCode, tt.want)
+		}
+	}
+	if !reflect.DeepEqual(healer.recreated, []string{"web"}) {
+		t.Errorf("recreated %v, want web", healer.recreated)
+	}
+	// the resync and the recreate enqueue the same Service
+	if c.queue.Len() != 1 {
+		t.Errorf("%d Services enqueued, want 1", c.queue.Len())
+	}
+}
@@ -5,39 +5,80 @@ import (
 	"crypto/tls"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
+	"net/url"
+	"os"
+	"strings"
 	"time"
 
 	"k8s.io/apimachinery/pkg/util/sets"
 	"k8s.io/apimachinery/pkg/util/wait"
-	utilfeature "k8s.io/apiserver/pkg/util/feature"
 	"k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
 	cloudprovider "k8s.io/cloud-provider"
 	nodecontroller "k8s.io/cloud-provider/controllers/node"
-	servicecontroller "k8s.io/cloud-provider/controllers/service"
+	"k8s.io/component-base/metrics/legacyregistry"
 	controllersmetrics "k8s.io/component-base/metrics/prometheus/controllers"
-	ccmfeatures "k8s.io/controller-manager/pkg/features"
 	"k8s.io/klog/v2"
+	"sigs.k8s.io/cloud-provider-kind/pkg/certs"
+	"sigs.k8s.io/cloud-provider-kind/pkg/config"
 	"sigs.k8s.io/cloud-provider-kind/pkg/constants"
 	"sigs.k8s.io/cloud-provider-kind/pkg/container"
+	"sigs.k8s.io/cloud-provider-kind/pkg/dns"
 	"sigs.k8s.io/cloud-provider-kind/pkg/loadbalancer"
 	"sigs.k8s.io/cloud-provider-kind/pkg/provider"
 	"sigs.k8s.io/kind/pkg/cluster"
 	"sigs.k8s.io/kind/pkg/log"
 )
 
+// rootlessHostAddress is the address reported for the loadbalancers of rootless runtimes,
+// the ports are published on all the host addresses.
+const rootlessHostAddress = "127.0.0.1"
+
+const (
+	// ShutdownPolicyPreserve leaves the loadbalancers running on exit, the next controller
+	// instance adopts them without interrupting the traffic
+	ShutdownPolicyPreserve = "preserve"
+	// ShutdownPolicyDelete deletes the loadbalancers of all the clusters on exit
+	ShutdownPolicyDelete = "delete"
+)
+
 type Controller struct {
-	kind     *cluster.Provider
-	clusters map[string]*ccm
+	kind      *cluster.Provider
+	clusters  map[string]*ccm
+	devDomain *loadbalancer.DevDomain
+	observer  *observer
+	health    *health
+	admin     *admin
+	dind      container.DinDEnvironment
+	// onlyCluster restricts the controller to one cluster, all the clusters if empty
+	onlyCluster string
+	// external is the name of the cluster of --external-kubeconfig, empty if not set
+	external string
+	// tools are the clusters of the other tools managed with --k3d and --minikube, and
+	// the functions returning their kubeconfigs
+	tools map[string]kubeConfigFunc
+	// resync wakes up the clusters loop before the next poll
+	resync chan struct{}
+	// nodeStarts receives the kind node containers started, their IPs may have changed
+	nodeStarts chan container.ContainerEvent
+	// hooks are passed to the cloud providers of the clusters
+	hooks provider.Hooks
 }
 
 type ccm struct {
 	factory           informers.SharedInformerFactory
-	serviceController *servicecontroller.Controller
+	serviceController *serviceController
 	nodeController    *nodecontroller.CloudNodeController
-	cancelFn          context.CancelFunc
+	// stopFn stops the controllers of the cluster once the syncs in flight are done
+	stopFn func()
+	// cancelFn stops the controllers and deletes the loadbalancers of the cluster
+	cancelFn context.CancelFunc
+	// credentials are the ones of the client of the controllers
+	credentials credentials
 }
 
 func New(logger log.Logger) *Controller {
@@ -46,22 +87,95 @@ func New(logger log.Logger) *Controller {
 		kind: cluster.NewProvider(
 			cluster.ProviderWithLogger(logger),
 		),
-		clusters: make(map[string]*ccm),
+		clusters:   make(map[string]*ccm),
+		health:     newHealth(),
+		admin:      newAdmin(),
+		resync:     make(chan struct{}, 1),
+		nodeStarts: make(chan container.ContainerEvent, 16),
 	}
 }
 
+// SetHooks sets the hooks notified of the lifecycle of the loadbalancers of all the clusters,
+// for the programs embedding the controller. It must be called before Run.
+func (c *Controller) SetHooks(hooks provider.Hooks) {
+	c.hooks = hooks
+}
+
+// SetCluster restricts the controller to the cluster, for the programs embedding the
+// controller next to other clusters it must not manage. It must be called before Run.
+func (c *Controller) SetCluster(cluster string) {
+	c.onlyCluster = cluster
+}
+
+// KubeClient returns a client of the cluster with the same kubeconfig the controllers of the
+// cluster use, ex. to create the Services of the programs embedding the controller.
+func (c *Controller) KubeClient(ctx context.Context, cluster string) (kubernetes.Interface, error) {
+	kubeClient, _, err := c.getKubeClient(ctx, cluster)
+	return kubeClient, err
+}
+
 func (c *Controller) Run(ctx context.Context) {
+	// the clusters share the container runtime, and so its limits, the cleanup on exit too
+	_, restore := container.LimitCalls(float32(config.DefaultConfig.ContainerRuntimeQPS), config.DefaultConfig.ContainerRuntimeBurst, config.DefaultConfig.ContainerInspectCacheTTL)
+	defer restore()
 	defer c.cleanup()
+	c.dind = setupDinD()
+	setupWSL()
+	setupRootless()
+	if config.DefaultConfig.HealthAddress != "" {
+		c.health.start(ctx)
+	}
+	if config.DefaultConfig.AdminAddress != "" && !config.DefaultConfig.ObserveOnly {
+		c.admin.start(ctx)
+	}
+	if config.DefaultConfig.XDSAddress != "" && !config.DefaultConfig.ObserveOnly {
+		go func() {
+			err := loadbalancer.ServeXDS(ctx, config.DefaultConfig.XDSAddress)
+			if err != nil {
+				klog.ErrorS(err, "xDS server failed", "address", config.DefaultConfig.XDSAddress)
+			}
+		}()
+	}
+	if config.DefaultConfig.ObserveOnly {
+		c.observer = newObserver()
+		c.startObserverServer(ctx)
+	}
+	if err := c.setupExternalCluster(); err != nil {
+		klog.ErrorS(err, "Failed to set up the external cluster")
+		klog.FlushAndExit(klog.ExitFlushTimeout, 1)
+	}
+	if config.DefaultConfig.DevDomain != "" {
+		err := c.startDevDomain()
+		if err != nil {
+			klog.ErrorS(err, "Failed to start dev domain", "domain", config.DefaultConfig.DevDomain)
+			klog.FlushAndExit(klog.ExitFlushTimeout, 1)
+		}
+	}
+	if config.DefaultConfig.Prepull && !config.DefaultConfig.ObserveOnly {
+		go func() {
+			err := loadbalancer.PrepullProxyImage(ctx)
+			if err != nil {
+				klog.ErrorS(err, "Failed to pull the proxy image, it is pulled again when the first loadbalancer is created")
+			}
+		}()
+	}
+	go c.watchNodeContainers(ctx)
 	for {
 		select {
 		case <-ctx.Done():
 			return
 		default:
 		}
-		// get existing kind clusters
-		clusters, err := c.kind.List()
+		// get existing kind clusters and the external one
+		clusters, err := c.clusterNames()
 		if err != nil {
-			klog.Infof("error listing clusters, retrying ...: %v", err)
+			klog.ErrorS(err, "Failed to list clusters, retrying")
+		}
+		// running in-cluster only manages the cluster it runs on
+		if config.DefaultConfig.InCluster {
+			clusters = filterInCluster(clusters, config.DefaultConfig.InClusterName)
+		} else if c.onlyCluster != "" {
+			clusters = filterInCluster(clusters, c.onlyCluster)
 		}
 
 		// add new ones
@@ -72,66 +186,320 @@ func (c *Controller) Run(ctx context.Context) {
 			default:
 			}
 
-			klog.V(3).Infof("processing cluster %s", cluster)
-			_, ok := c.clusters[cluster]
-			if ok {
-				klog.V(3).Infof("cluster %s already exist", cluster)
-				continue
+			klog.V(3).InfoS("Processing cluster", "cluster", cluster)
+			if ccm, ok := c.clusters[cluster]; ok {
+				if !c.credentialsChanged(cluster, &ccm.credentials) {
+					klog.V(3).InfoS("Cluster already exists", "cluster", cluster)
+					continue
+				}
+				// the loadbalancers are adopted by the new controllers
+				klog.InfoS("Cluster credentials changed, restarting the controllers with a new client", "cluster", cluster)
+				c.stopCluster(cluster, ccm)
 			}
 
-			kubeClient, err := c.getKubeClient(ctx, cluster)
+			kubeClient, credentials, err := c.getKubeClient(ctx, cluster)
 			if err != nil {
-				klog.Errorf("Failed to create kubeClient for cluster %s: %v", cluster, err)
+				klog.ErrorS(err, "Failed to create kubeClient", "cluster", cluster)
 				continue
 			}
 
-			klog.V(2).Infof("Creating new cloud provider for cluster %s", cluster)
-			cloud := provider.New(cluster, c.kind)
-			ccm, err := startCloudControllerManager(ctx, cluster, kubeClient, cloud)
+			if c.observer != nil {
+				klog.InfoS("Observing cluster in observe-only mode", "cluster", cluster)
+				ccm, err := c.observer.start(ctx, cluster, kubeClient)
+				if err != nil {
+					klog.ErrorS(err, "Failed to observe cluster", "cluster", cluster)
+					continue
+				}
+				ccm.credentials = credentials
+				c.clusters[cluster] = ccm
+				c.addClusterHealth(cluster, kubeClient, ccm)
+				continue
+			}
+
+			c.connectDinD(cluster)
+			klog.V(2).InfoS("Creating new cloud provider", "cluster", cluster)
+			sharedInformers := informers.NewSharedInformerFactory(kubeClient, 60*time.Second)
+			cloud := provider.New(provider.Config{
+				ClusterName:         cluster,
+				KindClient:          c.kind,
+				KubeClient:          kubeClient,
+				EndpointSliceLister: sharedInformers.Discovery().V1().EndpointSlices().Lister(),
+				DevDomain:           c.devDomain,
+				Hooks:               c.hooks,
+			})
+			ccm, err := startCloudControllerManager(ctx, cluster, kubeClient, sharedInformers, cloud)
 			if err != nil {
-				klog.Errorf("Failed to start cloud controller for cluster %s: %v", cluster, err)
+				klog.ErrorS(err, "Failed to start cloud controller", "cluster", cluster)
 				continue
 			}
-			klog.Infof("Starting cloud controller for cluster %s", cluster)
+			klog.InfoS("Starting cloud controller", "cluster", cluster)
+			ccm.credentials = credentials
 			c.clusters[cluster] = ccm
+			c.addClusterHealth(cluster, kubeClient, ccm)
+			c.admin.addCluster(cluster, ccm.serviceController)
 		}
 		// remove expired ones
 		clusterSet := sets.New(clusters...)
 		for cluster, ccm := range c.clusters {
 			_, ok := clusterSet[cluster]
 			if !ok {
-				klog.Infof("Deleting resources for cluster %s", cluster)
+				klog.InfoS("Deleting resources", "cluster", cluster)
 				ccm.cancelFn()
 				delete(c.clusters, cluster)
+				c.health.removeCluster(cluster)
+				c.admin.removeCluster(cluster)
+				loadbalancer.ForgetCluster(cluster)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-c.resync:
+		case event := <-c.nodeStarts:
+			c.refreshNodeAddresses(ctx, event)
+		case <-time.After(30 * time.Second):
+		}
+	}
+}
+
+// refreshNodeAddresses updates the addresses of the node of the container started, in the
+// background so the clusters loop is not blocked by the apiserver. The clusters in
+// observe-only mode are not modified.
+func (c *Controller) refreshNodeAddresses(ctx context.Context, event container.ContainerEvent) {
+	cluster := event.Labels[constants.KindClusterLabelKey]
+	ccm, ok := c.clusters[cluster]
+	if !ok || ccm.serviceController == nil {
+		return
+	}
+	go func() {
+		err := ccm.serviceController.refreshNodeAddresses(ctx, event.Name)
+		if err != nil {
+			klog.ErrorS(err, "Failed to update the node addresses, they are updated by the node controller", "cluster", cluster, "node", event.Name)
+		}
+	}()
+}
+
+// stopCluster stops the controllers of the cluster leaving the loadbalancers running, to
+// restart them with a new client
+func (c *Controller) stopCluster(cluster string, ccm *ccm) {
+	if ccm.stopFn != nil {
+		ccm.stopFn()
+	} else {
+		ccm.cancelFn()
+	}
+	delete(c.clusters, cluster)
+	c.health.removeCluster(cluster)
+	c.admin.removeCluster(cluster)
+}
+
+// watchNodeContainers resyncs the clusters when a kind node container is removed, so the
+// loadbalancers of a deleted cluster are cleaned up immediately instead of on the next
+// poll, and refreshes the addresses of the nodes whose container is started, a restarted
+// node may get new IPs. The watch is restarted if the event stream of the container
+// runtime fails.
+func (c *Controller) watchNodeContainers(ctx context.Context) {
+	for {
+		events, err := container.ContainerEvents(ctx, constants.KindClusterLabelKey)
+		if err != nil {
+			klog.InfoS("Unable to watch the container events, the clusters are only polled", "err", err)
+		} else {
+			for event := range events {
+				switch {
+				case event.Removed():
+					klog.V(2).InfoS("Kind node removed", "node", event.Name, "cluster", event.Labels[constants.KindClusterLabelKey])
+					select {
+					case c.resync <- struct{}{}:
+					default:
+					}
+				case event.Started():
+					klog.V(2).InfoS("Kind node started", "node", event.Name, "cluster", event.Labels[constants.KindClusterLabelKey])
+					select {
+					case c.nodeStarts <- event:
+					case <-ctx.Done():
+						return
+					}
+				}
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(10 * time.Second):
+		}
+	}
+}
+
+// addClusterHealth adds the cluster apiserver and informers to the readiness checks
+func (c *Controller) addClusterHealth(cluster string, kubeClient kubernetes.Interface, ccm *ccm) {
+	c.health.addCluster(cluster, kubeClient,
+		ccm.factory.Core().V1().Services().Informer().HasSynced,
+		ccm.factory.Core().V1().Nodes().Informer().HasSynced,
+		ccm.factory.Discovery().V1().EndpointSlices().Informer().HasSynced,
+	)
+}
+
+// setupDinD detects if the controller runs in a docker-in-docker environment, typically
+// CI jobs, and publishes the loadbalancers on the runtime host if it is remote.
+func setupDinD() container.DinDEnvironment {
+	env := container.DetectDinD()
+	if env.Mode == container.DinDRemote && config.DefaultConfig.PublishHostAddress == "" {
+		klog.InfoS("Container runtime is remote, publishing the loadbalancers on the runtime host", "address", env.HostAddress)
+		config.DefaultConfig.PublishHostAddress = env.HostAddress
+	}
+	return env
+}
+
+// setupRootless publishes the loadbalancers on the host ports if the container runtime is
+// rootless, the container IPs are only reachable inside the runtime network namespace.
+func setupRootless() {
+	if !container.Rootless() || config.DefaultConfig.PublishHostAddress != "" {
+		return
+	}
+	klog.InfoS("Container runtime is rootless, publishing the loadbalancers on the host ports", "address", rootlessHostAddress)
+	config.DefaultConfig.PublishHostAddress = rootlessHostAddress
+	if data, err := os.ReadFile("/proc/sys/net/ipv4/ip_unprivileged_port_start"); err == nil {
+		if start := strings.TrimSpace(string(data)); start != "0" {
+			klog.InfoS("The Service ports lower than the first unprivileged port can not be published, lower it with sysctl net.ipv4.ip_unprivileged_port_start", "port", start)
+		}
+	}
+}
+
+// setupWSL publishes the loadbalancers on the host ports on WSL2, the container IPs are
+// only reachable inside the WSL2 VM so the address reported is the one reachable from the
+// Windows host.
+func setupWSL() {
+	if config.DefaultConfig.PublishHostAddress != "" {
+		return
+	}
+	env := container.DetectWSL()
+	if !env.Enabled {
+		return
+	}
+	klog.InfoS("Running on WSL2, publishing the loadbalancers on the host ports", "networkingMode", env.NetworkingMode, "dockerDesktop", env.DockerDesktop, "address", env.Address)
+	config.DefaultConfig.PublishHostAddress = env.Address
+}
+
+// connectDinD makes the loadbalancers of the cluster reachable from the controller when it
+// runs in a container of the same runtime, connecting it to the network of the cluster.
+func (c *Controller) connectDinD(cluster string) {
+	if c.dind.Mode != container.DinDSibling {
+		return
+	}
+	network := loadbalancer.ClusterNetworks(cluster)[0]
+	if container.IsConnected(c.dind.SelfID, network) {
+		return
+	}
+	klog.InfoS("Running in a container, connecting it to the network to reach the loadbalancers", "container", c.dind.SelfID, "cluster", cluster, "network", network)
+	if err := container.ConnectNetwork(c.dind.SelfID, network); err != nil {
+		klog.ErrorS(err, "Failed to connect to the network, the loadbalancers may not be reachable", "container", c.dind.SelfID, "network", network)
+	}
+}
+
+// startDevDomain starts the embedded DNS server for the dev domain and loads the CA
+// used to sign the loadbalancers certificates.
+func (c *Controller) startDevDomain() error {
+	domain := strings.TrimPrefix(config.DefaultConfig.DevDomain, "*.")
+	ca, err := certs.LoadOrCreateCA(config.DefaultConfig.CADir)
+	if err != nil {
+		return err
+	}
+	klog.InfoS("Loadbalancer certificates are signed by the generated CA, add it to your trust store", "domain", domain, "ca", certs.CertificatePath(config.DefaultConfig.CADir))
+
+	dnsServer := dns.NewServer(domain)
+	go func() {
+		err := dnsServer.ListenAndServe(config.DefaultConfig.DNSAddress)
+		if err != nil {
+			klog.ErrorS(err, "DNS server failed", "domain", domain)
+		}
+	}()
+	if address := config.DefaultConfig.ExternalDNSWebhookAddress; address != "" {
+		klog.InfoS("Serving the external-dns webhook provider", "domain", domain, "address", address)
+		go func() {
+			err := http.ListenAndServe(address, dns.NewWebhook(dnsServer))
+			if err != nil {
+				klog.ErrorS(err, "external-dns webhook provider failed", "domain", domain)
 			}
+		}()
+	}
+	c.devDomain = &loadbalancer.DevDomain{DNS: dnsServer, CA: ca}
+	return nil
+}
+
+// startObserverServer serves the observer status and the metrics
+func (c *Controller) startObserverServer(ctx context.Context) {
+	mux := http.NewServeMux()
+	mux.Handle("/status", c.observer)
+	mux.Handle("/metrics", legacyregistry.Handler())
+	server := &http.Server{Addr: config.DefaultConfig.ObserveAddress, Handler: mux}
+	go func() {
+		<-ctx.Done()
+		server.Close() // nolint:errcheck
+	}()
+	go func() {
+		klog.InfoS("Serving the observer status", "url", "http://"+config.DefaultConfig.ObserveAddress+"/status")
+		err := server.ListenAndServe()
+		if err != nil && err != http.ErrServerClosed {
+			klog.ErrorS(err, "Observer server failed")
+		}
+	}()
+}
+
+// filterInCluster returns the cluster the controller runs in if it exists.
+func filterInCluster(clusters []string, name string) []string {
+	for _, cluster := range clusters {
+		if cluster == name {
+			return []string{cluster}
 		}
-		time.Sleep(30 * time.Second)
 	}
+	return []string{}
 }
 
 // getKubeClient returns a kubeclient depending if the ccm runs inside a container
 // inside the same docker network that the kind cluster or run externally in the host
 // It tries first to connect to the external endpoint
-func (c *Controller) getKubeClient(ctx context.Context, cluster string) (kubernetes.Interface, error) {
+// If the controller runs as a Pod inside the cluster it uses the in-cluster config.
+// The credentials of the kubeconfig are returned to detect when they change.
+func (c *Controller) getKubeClient(ctx context.Context, cluster string) (kubernetes.Interface, credentials, error) {
+	if config.DefaultConfig.InCluster {
+		config, err := rest.InClusterConfig()
+		if err != nil {
+			return nil, credentials{}, fmt.Errorf("failed to get in-cluster config for cluster %s: %w", cluster, err)
+		}
+		creds, err := c.clusterCredentials(cluster, false)
+		if err != nil {
+			return nil, credentials{}, err
+		}
+		kubeClient, err := kubernetes.NewForConfig(config)
+		return kubeClient, creds, err
+	}
 	httpClient := &http.Client{
 		Timeout: 5 * time.Second,
 		Transport: &http.Transport{
 			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
 		},
 	}
+	// the kubeconfig file is watched for changes from before reading the kubeconfig, if it
+	// can not be read the kubeconfig is read again on every check
+	file, err := readFileState(c.credentialsFile(cluster))
+	if err != nil {
+		klog.V(2).InfoS("Failed to read the kubeconfig file", "cluster", cluster, "err", err)
+	}
 	// try internal first
 	for _, internal := range []bool{false, true} {
-		kconfig, err := c.kind.KubeConfig(cluster, internal)
+		kconfig, err := c.kubeConfig(cluster, internal)
 		if err != nil {
-			klog.Errorf("Failed to get kubeconfig for cluster %s: %v", cluster, err)
+			klog.ErrorS(err, "Failed to get kubeconfig", "cluster", cluster)
 			continue
 		}
 
 		config, err := clientcmd.RESTConfigFromKubeConfig([]byte(kconfig))
 		if err != nil {
-			klog.Errorf("Failed to convert kubeconfig for cluster %s: %v", cluster, err)
+			klog.ErrorS(err, "Failed to convert kubeconfig", "cluster", cluster)
 			continue
 		}
+		if c.dind.Mode == container.DinDRemote && !internal {
+			remoteAPIServer(config, c.dind.HostAddress)
+		}
 
 		// check that the apiserver is reachable before continue
 		// to fail fast and avoid waiting until the client operations timeout
@@ -139,7 +507,7 @@ func (c *Controller) getKubeClient(ctx context.Context, cluster string) (kuberne
 		for i := 0; i < 5; i++ {
 			select {
 			case <-ctx.Done():
-				return nil, ctx.Err()
+				return nil, credentials{}, ctx.Err()
 			default:
 			}
 			if probeHTTP(httpClient, config.Host) {
@@ -149,25 +517,45 @@ func (c *Controller) getKubeClient(ctx context.Context, cluster string) (kuberne
 			time.Sleep(time.Second * time.Duration(i))
 		}
 		if !ok {
-			klog.Errorf("Failed to connect to apiserver %s: %v", cluster, err)
+			klog.ErrorS(err, "Failed to connect to apiserver", "cluster", cluster)
 			continue
 		}
 
 		kubeClient, err := kubernetes.NewForConfig(config)
 		if err != nil {
-			klog.Errorf("Failed to create kubeClient for cluster %s: %v", cluster, err)
+			klog.ErrorS(err, "Failed to create kubeClient", "cluster", cluster)
 			continue
 		}
-		return kubeClient, err
+		return kubeClient, newCredentials(internal, []byte(kconfig), file), nil
+	}
+	return nil, credentials{}, fmt.Errorf("can not find a working kubernetes clientset")
+}
+
+// remoteAPIServer points the config to the container runtime host if the apiserver is
+// published on the loopback or the unspecified address, that are the ones of the remote
+// host. The certificate is still verified against the original address, that is one of
+// its names.
+func remoteAPIServer(config *rest.Config, hostAddress string) {
+	u, err := url.Parse(config.Host)
+	if err != nil {
+		return
+	}
+	host := u.Hostname()
+	if ip := net.ParseIP(host); host != "localhost" && (ip == nil || (!ip.IsLoopback() && !ip.IsUnspecified())) {
+		return
+	}
+	if config.TLSClientConfig.ServerName == "" {
+		config.TLSClientConfig.ServerName = host
 	}
-	return nil, fmt.Errorf("can not find a working kubernetes clientset")
+	u.Host = net.JoinHostPort(hostAddress, u.Port())
+	config.Host = u.String()
 }
 
 func probeHTTP(client *http.Client, address string) bool {
-	klog.Infof("probe HTTP address %s", address)
+	klog.V(2).InfoS("Probing HTTP address", "address", address)
 	resp, err := client.Get(address)
 	if err != nil {
-		klog.Infof("Failed to connect to HTTP address %s: %v", address, err)
+		klog.InfoS("Failed to connect to HTTP address", "address", address, "err", err)
 		return false
 	}
 	defer resp.Body.Close()
@@ -180,18 +568,10 @@ func probeHTTP(client *http.Client, address string) bool {
 
 // TODO: implement leader election to not have problems with  multiple providers
 // ref: https://github.com/kubernetes/kubernetes/blob/d97ea0f705847f90740cac3bc3dd8f6a4026d0b5/cmd/kube-scheduler/app/server.go#L211
-func startCloudControllerManager(ctx context.Context, clusterName string, kubeClient kubernetes.Interface, cloud cloudprovider.Interface) (*ccm, error) {
-	// TODO: we need to set up the ccm specific feature gates
-	// but try to avoid to expose this to users
-	featureGates := utilfeature.DefaultMutableFeatureGate
-	err := ccmfeatures.SetupCurrentKubernetesSpecificFeatureGates(featureGates)
-	if err != nil {
-		return nil, err
-	}
-
+func startCloudControllerManager(ctx context.Context, clusterName string, kubeClient kubernetes.Interface, sharedInformers informers.SharedInformerFactory, cloud cloudprovider.Interface) (*ccm, error) {
 	client := kubeClient.Discovery().RESTClient()
 	// wait for health
-	err = wait.PollUntilContextTimeout(ctx, 1*time.Second, 30*time.Second, true, func(ctx context.Context) (bool, error) {
+	err := wait.PollUntilContextTimeout(ctx, 1*time.Second, 30*time.Second, true, func(ctx context.Context) (bool, error) {
 		healthStatus := 0
 		client.Get().AbsPath("/healthz").Do(ctx).StatusCode(&healthStatus)
 		if healthStatus != http.StatusOK {
@@ -201,30 +581,37 @@ func startCloudControllerManager(ctx context.Context, clusterName string, kubeCl
 		return true, nil
 	})
 	if err != nil {
-		klog.Errorf("Failed waiting for apiserver to be ready: %v", err)
+		klog.ErrorS(err, "Failed waiting for apiserver to be ready")
 		return nil, err
 	}
 
-	sharedInformers := informers.NewSharedInformerFactory(kubeClient, 60*time.Second)
-
 	ccmMetrics := controllersmetrics.NewControllerManagerMetrics(clusterName)
+	lbController, ok := cloud.LoadBalancer()
+	// this can not happen
+	if !ok {
+		return nil, fmt.Errorf("cloud provider for cluster %s does not implement loadbalancers", clusterName)
+	}
 	// Start the service controller
-	serviceController, err := servicecontroller.New(
-		cloud,
+	serviceController, err := newServiceController(
+		clusterName,
 		kubeClient,
+		lbController,
 		sharedInformers.Core().V1().Services(),
 		sharedInformers.Core().V1().Nodes(),
-		clusterName,
-		featureGates,
+		sharedInformers.Discovery().V1().EndpointSlices(),
+		config.DefaultConfig.NodeSyncWindow,
 	)
 	if err != nil {
-		// This error shouldn't fail. It lives like this as a legacy.
-		klog.Errorf("Failed to start service controller: %v", err)
+		klog.ErrorS(err, "Failed to start service controller", "cluster", clusterName)
 		return nil, err
 	}
 
 	ctx, cancel := context.WithCancel(ctx)
-	go serviceController.Run(ctx, 5, ccmMetrics)
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		serviceController.Run(ctx, config.Get().Concurrency)
+	}()
 
 	// Start the node controller
 	nodeController, err := nodecontroller.NewCloudNodeController(
@@ -236,7 +623,7 @@ func startCloudControllerManager(ctx context.Context, clusterName string, kubeCl
 	)
 	if err != nil {
 		// This error shouldn't fail. It lives like this as a legacy.
-		klog.Errorf("Failed to start node controller: %v", err)
+		klog.ErrorS(err, "Failed to start node controller", "cluster", clusterName)
 		cancel()
 		return nil, err
 	}
@@ -249,36 +636,29 @@ func startCloudControllerManager(ctx context.Context, clusterName string, kubeCl
 	// - in windows and darwin ip addresses on the loopback interface
 	// Find all the containers associated to the cluster and then use the cloud provider methods to delete
 	// the loadbalancer, we can extract the service name from the container labels.
-	cancelFn := func() {
+	stopFn := func() {
 		cancel()
+		<-stopped
+	}
+	cancelFn := func() {
+		stopFn()
 
-		containers, err := container.ListByLabel(fmt.Sprintf("%s=%s", constants.NodeCCMLabelKey, clusterName))
+		containers, err := container.ListLabelValues(fmt.Sprintf("%s=%s", constants.NodeCCMLabelKey, clusterName), constants.LoadBalancerNameLabelKey)
 		if err != nil {
-			klog.Errorf("can't list containers: %v", err)
+			klog.ErrorS(err, "Failed to list containers", "cluster", clusterName)
 			return
 		}
 
-		lbController, ok := cloud.LoadBalancer()
-		// this can not happen
-		if !ok {
-			return
-		}
-
-		for _, name := range containers {
+		for _, v := range containers {
 			// create fake service to pass to the cloud provider method
-			v, err := container.GetLabelValue(name, constants.LoadBalancerNameLabelKey)
-			if err != nil {
-				klog.Infof("could not get the label for the loadbalancer on container %s on cluster %s : %v", name, clusterName, err)
-				continue
-			}
 			clusterName, service := loadbalancer.ServiceFromLoadBalancerSimpleName(v)
 			if service == nil {
-				klog.Infof("invalid format for loadbalancer on cluster %s: %s", clusterName, v)
+				klog.InfoS("Invalid loadbalancer label format", "cluster", clusterName, "label", v)
 				continue
 			}
 			err = lbController.EnsureLoadBalancerDeleted(context.Background(), clusterName, service)
 			if err != nil {
-				klog.Infof("error deleting loadbalancer %s/%s on cluster %s : %v", service.Namespace, service.Name, clusterName, err)
+				klog.ErrorS(err, "Failed to delete loadbalancer", "cluster", clusterName, "service", klog.KObj(service))
 				continue
 			}
 		}
@@ -288,14 +668,25 @@ func startCloudControllerManager(ctx context.Context, clusterName string, kubeCl
 		factory:           sharedInformers,
 		serviceController: serviceController,
 		nodeController:    nodeController,
+		stopFn:            stopFn,
 		cancelFn:          cancelFn}, nil
 }
 
+// cleanup stops the controllers of all the clusters on exit, deleting the loadbalancers
+// depending on the shutdown policy
 // TODO cleanup alias ip on mac
 func (c *Controller) cleanup() {
 	for cluster, ccm := range c.clusters {
-		klog.Infof("Cleaning resources for cluster %s", cluster)
-		ccm.cancelFn()
+		if config.DefaultConfig.ShutdownPolicy == ShutdownPolicyDelete || ccm.stopFn == nil {
+			klog.InfoS("Cleaning resources", "cluster", cluster)
+			ccm.cancelFn()
+		} else {
+			klog.InfoS("Leaving the loadbalancers running for the next controller", "cluster", cluster)
+			ccm.stopFn()
+		}
 		delete(c.clusters, cluster)
 	}
+	if c.devDomain != nil {
+		c.devDomain.DNS.Close() // nolint:errcheck
+	}
 }
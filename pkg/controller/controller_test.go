@@ -0,0 +1,72 @@
+package controller
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"k8s.io/client-go/rest"
+
+	"sigs.k8s.io/cloud-provider-kind/pkg/constants"
+	"sigs.k8s.io/cloud-provider-kind/pkg/container"
+)
+
+func Test_remoteAPIServer(t *testing.T) {
+	tests := []struct {
+		host           string
+		wantHost       string
+		wantServerName string
+	}{
+		{host: "https://127.0.0.1:39000", wantHost: "https://192.168.1.10:39000", wantServerName: "127.0.0.1"},
+		{host: "https://localhost:6443", wantHost: "https://192.168.1.10:6443", wantServerName: "localhost"},
+		{host: "https://[::1]:6443", wantHost: "https://192.168.1.10:6443", wantServerName: "::1"},
+		{host: "https://0.0.0.0:39000", wantHost: "https://192.168.1.10:39000", wantServerName: "0.0.0.0"},
+		{host: "https://10.0.0.5:6443", wantHost: "https://10.0.0.5:6443"},
+		{host: "https://kind-control-plane:6443", wantHost: "https://kind-control-plane:6443"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.host, func(t *testing.T) {
+			config := &rest.Config{Host: tt.host}
+			remoteAPIServer(config, "192.168.1.10")
+			if config.Host != tt.wantHost || config.TLSClientConfig.ServerName != tt.wantServerName {
+				t.Errorf("remoteAPIServer() = %s %q, want %s %q", config.Host, config.TLSClientConfig.ServerName, tt.wantHost, tt.wantServerName)
+			}
+		})
+	}
+}
+
+func TestWatchNodeContainers(t *testing.T) {
+	fake := container.NewFake()
+	defer container.SetRuntime(fake)()
+	c := &Controller{resync: make(chan struct{}, 1), nodeStarts: make(chan container.ContainerEvent, 16)}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go c.watchNodeContainers(ctx)
+
+	// the watch may not be started yet, remove nodes until the resync is triggered
+	deadline := time.After(5 * time.Second)
+	for {
+		fake.AddContainer(&container.FakeContainer{Name: "kind-worker", Labels: map[string]string{constants.KindClusterLabelKey: "kind"}})
+		fake.Delete("kind-worker") // nolint:errcheck
+		select {
+		case <-c.resync:
+		case <-time.After(50 * time.Millisecond):
+			continue
+		case <-deadline:
+			t.Fatalf("the removal of a node did not trigger a resync")
+		}
+		break
+	}
+
+	// the restarted nodes are refreshed
+	fake.AddContainer(&container.FakeContainer{Name: "kind-worker", Labels: map[string]string{constants.KindClusterLabelKey: "kind"}})
+	fake.Restart("kind-worker") // nolint:errcheck
+	select {
+	case event := <-c.nodeStarts:
+		if event.Name != "kind-worker" {
+			t.Errorf("started node = %s, want kind-worker", event.Name)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("the restart of a node was not reported")
+	}
+}
@@ -0,0 +1,144 @@
+package controller
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"time"
+
+	"k8s.io/client-go/tools/clientcmd"
+
+	"sigs.k8s.io/cloud-provider-kind/pkg/config"
+)
+
+// inClusterCAFile is the CA of the apiserver mounted in the Pods, the service account
+// token next to it is reloaded by client-go when it is rotated
+var inClusterCAFile = "/var/run/secrets/kubernetes.io/serviceaccount/ca.crt"
+
+// credentialsResync is the period the kubeconfig of the clusters is read again even if its
+// file did not change, the client certificate can be renewed inside the nodes
+const credentialsResync = 10 * time.Minute
+
+// credentials identify the kubeconfig a cluster client was built with, the client is
+// rebuilt when the kubeconfig changes, ex. the client certificate of the kind cluster is
+// renewed or the cluster is recreated with the same name between two polls
+type credentials struct {
+	// internal is true for the kubeconfig of the apiserver address in the container network
+	internal bool
+	// fingerprint is the hash of the kubeconfig, or of the CA in-cluster
+	fingerprint string
+	// file is the kubeconfig file when the credentials were read, they are only read again
+	// once it changes or after the credentialsResync
+	file    fileState
+	checked time.Time
+}
+
+// fileState identifies the content of a file, the zero value is a file that does not exist
+type fileState struct {
+	modTime time.Time
+	size    int64
+	hash    string
+}
+
+// readFileState returns the state of the file, the missing files are not an error
+func readFileState(path string) (fileState, error) {
+	info, err := os.Stat(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return fileState{}, nil
+	}
+	if err != nil {
+		return fileState{}, err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fileState{}, err
+	}
+	return fileState{modTime: info.ModTime(), size: info.Size(), hash: fingerprint(data)}, nil
+}
+
+// unchanged returns true if the file was not modified since the state was read, the
+// content is only compared if its modification time or size changed
+func (f fileState) unchanged(path string) (fileState, bool) {
+	info, err := os.Stat(path)
+	if err == nil && info.ModTime().Equal(f.modTime) && info.Size() == f.size {
+		return f, true
+	}
+	if errors.Is(err, fs.ErrNotExist) {
+		return fileState{}, f == fileState{}
+	}
+	latest, err := readFileState(path)
+	if err != nil {
+		return f, false
+	}
+	return latest, latest.hash == f.hash
+}
+
+// credentialsFile returns the file polled to detect the kubeconfig changes of the cluster:
+// the in-cluster CA, the kubeconfig of the external cluster, or the kubeconfig kind, k3d and
+// minikube write the clusters to when they are created
+func (c *Controller) credentialsFile(cluster string) string {
+	if config.DefaultConfig.InCluster {
+		return inClusterCAFile
+	}
+	if cluster == c.external && c.external != "" {
+		return config.DefaultConfig.ExternalKubeconfig
+	}
+	return clientcmd.NewDefaultPathOptions().GetDefaultFilename()
+}
+
+// newCredentials returns the credentials of the kubeconfig, file is the state of the
+// credentialsFile before the kubeconfig was read
+func newCredentials(internal bool, kubeconfig []byte, file fileState) credentials {
+	return credentials{internal: internal, fingerprint: fingerprint(kubeconfig), file: file, checked: time.Now()}
+}
+
+// clusterCredentials returns the current credentials of the cluster, for the same apiserver
+// address as the client
+func (c *Controller) clusterCredentials(cluster string, internal bool) (credentials, error) {
+	// if the file can not be read the kubeconfig is read again on every check
+	file, _ := readFileState(c.credentialsFile(cluster))
+	if config.DefaultConfig.InCluster {
+		ca, err := os.ReadFile(inClusterCAFile)
+		if err != nil {
+			return credentials{}, fmt.Errorf("failed to read the in-cluster CA: %w", err)
+		}
+		return newCredentials(false, ca, file), nil
+	}
+	kconfig, err := c.kubeConfig(cluster, internal)
+	if err != nil {
+		return credentials{}, fmt.Errorf("failed to get kubeconfig: %w", err)
+	}
+	return newCredentials(internal, []byte(kconfig), file), nil
+}
+
+// credentialsChanged returns true if the kubeconfig of the cluster is not the one its
+// client was built with, it is called on every poll of the clusters. The kubeconfig is
+// only read again, running kind, if its file changed or after the credentialsResync, the
+// current credentials are updated when it did not change. The errors getting the kubeconfig are not considered a change, the cluster is
+// removed if it does not exist anymore.
+func (c *Controller) credentialsChanged(cluster string, current *credentials) bool {
+	if time.Since(current.checked) < credentialsResync {
+		file, unchanged := current.file.unchanged(c.credentialsFile(cluster))
+		if unchanged {
+			current.file = file
+			return false
+		}
+	}
+	latest, err := c.clusterCredentials(cluster, current.internal)
+	if err != nil {
+		return false
+	}
+	if latest.fingerprint != current.fingerprint {
+		return true
+	}
+	current.file, current.checked = latest.file, latest.checked
+	return false
+}
+
+func fingerprint(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,94 @@
+package controller
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"sigs.k8s.io/cloud-provider-kind/pkg/config"
+)
+
+func Test_credentialsChanged(t *testing.T) {
+	defer func(inCluster bool, caFile string) {
+		config.DefaultConfig.InCluster = inCluster
+		inClusterCAFile = caFile
+	}(config.DefaultConfig.InCluster, inClusterCAFile)
+	config.DefaultConfig.InCluster = true
+	inClusterCAFile = filepath.Join(t.TempDir(), "ca.crt")
+
+	c := &Controller{}
+	if _, err := c.clusterCredentials("kind", false); err == nil {
+		t.Fatalf("clusterCredentials() without CA expected error")
+	}
+	if err := os.WriteFile(inClusterCAFile, []byte("ca1"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	current, err := c.clusterCredentials("kind", false)
+	if err != nil {
+		t.Fatalf("clusterCredentials() error = %v", err)
+	}
+	if c.credentialsChanged("kind", &current) {
+		t.Errorf("credentialsChanged() = true for the same CA")
+	}
+	// touching the file does not change the credentials
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(inClusterCAFile, later, later); err != nil {
+		t.Fatal(err)
+	}
+	if c.credentialsChanged("kind", &current) {
+		t.Errorf("credentialsChanged() = true for the same CA modified")
+	}
+	if err := os.WriteFile(inClusterCAFile, []byte("ca2"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(inClusterCAFile, later.Add(time.Minute), later.Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if !c.credentialsChanged("kind", &current) {
+		t.Errorf("credentialsChanged() = false after the CA rotation")
+	}
+	if err := os.Remove(inClusterCAFile); err != nil {
+		t.Fatal(err)
+	}
+	if c.credentialsChanged("kind", &current) {
+		t.Errorf("credentialsChanged() = true when the CA can not be read")
+	}
+}
+
+func Test_credentialsChangedUnmodifiedFile(t *testing.T) {
+	defer func(inCluster bool, caFile string) {
+		config.DefaultConfig.InCluster = inCluster
+		inClusterCAFile = caFile
+	}(config.DefaultConfig.InCluster, inClusterCAFile)
+	config.DefaultConfig.InCluster = true
+	inClusterCAFile = filepath.Join(t.TempDir(), "ca.crt")
+	if err := os.WriteFile(inClusterCAFile, []byte("ca1"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	modTime := time.Now().Add(-time.Hour)
+	if err := os.Chtimes(inClusterCAFile, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+
+	c := &Controller{}
+	current, err := c.clusterCredentials("kind", false)
+	if err != nil {
+		t.Fatalf("clusterCredentials() error = %v", err)
+	}
+	// the credentials are not read again while the file is not modified
+	if err := os.WriteFile(inClusterCAFile, []byte("ca2"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(inClusterCAFile, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+	if c.credentialsChanged("kind", &current) {
+		t.Errorf("credentialsChanged() = true without reading the credentials again")
+	}
+	// until the resync
+	current.checked = time.Now().Add(-credentialsResync)
+	if !c.credentialsChanged("kind", &current) {
+		t.Errorf("credentialsChanged() = false after the resync")
+	}
+}
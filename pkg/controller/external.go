@@ -0,0 +1,111 @@
+package controller
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/klog/v2"
+
+	"sigs.k8s.io/cloud-provider-kind/pkg/config"
+	"sigs.k8s.io/cloud-provider-kind/pkg/container"
+)
+
+// setupExternalCluster registers the cluster of --external-kubeconfig, a cluster not created
+// by kind whose nodes are containers, ex. k3d or minikube with the docker driver. Its name
+// is the current context of the kubeconfig and its node containers are resolved with
+// --external-node-label, or the label of the tool that created it.
+func (c *Controller) setupExternalCluster() error {
+	path := config.DefaultConfig.ExternalKubeconfig
+	if path == "" {
+		return nil
+	}
+	kconfig, err := clientcmd.LoadFromFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to load the external kubeconfig: %w", err)
+	}
+	name := kconfig.CurrentContext
+	if name == "" {
+		return fmt.Errorf("the external kubeconfig %s has no current context", path)
+	}
+	var resolver container.NodeResolver
+	label := config.DefaultConfig.ExternalNodeLabel
+	switch {
+	case label != "":
+		resolver = container.LabelNodeResolver{Label: label}
+	case strings.HasPrefix(name, container.K3dClusterPrefix):
+		resolver = container.K3dNodeResolver{}
+	default:
+		label, err = container.DetectNodeLabel(name)
+		if err != nil {
+			return fmt.Errorf("%w, set --external-node-label", err)
+		}
+		resolver = container.LabelNodeResolver{Label: label}
+	}
+	klog.InfoS("Managing the external cluster", "cluster", name, "kubeconfig", path, "nodeLabel", label)
+	container.SetNodeResolver(name, resolver)
+	c.external = name
+	return nil
+}
+
+// clusterNames returns the kind clusters, the k3d clusters with --k3d, the minikube clusters
+// with --minikube and the external cluster
+func (c *Controller) clusterNames() ([]string, error) {
+	clusters, err := c.kind.List()
+	if err != nil {
+		return nil, err
+	}
+	tools := map[string]kubeConfigFunc{}
+	if config.DefaultConfig.K3d {
+		k3d, err := k3dClusters()
+		if err != nil {
+			return nil, err
+		}
+		for _, cluster := range k3d {
+			tools[cluster] = k3dKubeConfig
+		}
+	}
+	if config.DefaultConfig.Minikube {
+		minikube, err := minikubeClusters()
+		if err != nil {
+			return nil, err
+		}
+		for _, cluster := range minikube {
+			tools[cluster] = minikubeKubeConfig
+		}
+	}
+	// the external cluster is managed with its own kubeconfig
+	delete(tools, c.external)
+	clusters = append(clusters, sets.List(sets.KeySet(tools))...)
+	c.tools = tools
+	if c.external != "" {
+		clusters = append(clusters, c.external)
+	}
+	return clusters, nil
+}
+
+// kubeConfigFunc returns the kubeconfig of a cluster for the apiserver address of the host
+// or, if internal, of the container network
+type kubeConfigFunc func(cluster string, internal bool) (string, error)
+
+// kubeConfig returns the kubeconfig of the cluster for the apiserver address of the host
+// or, if internal, of the container network. The external cluster only has the one of its
+// file, the k3d and minikube clusters the one of their nodes.
+func (c *Controller) kubeConfig(cluster string, internal bool) (string, error) {
+	if kubeConfig, ok := c.tools[cluster]; ok {
+		return kubeConfig(cluster, internal)
+	}
+	if cluster != c.external || c.external == "" {
+		return c.kind.KubeConfig(cluster, internal)
+	}
+	if internal {
+		return "", fmt.Errorf("the external cluster %s has no internal kubeconfig", cluster)
+	}
+	data, err := os.ReadFile(config.DefaultConfig.ExternalKubeconfig)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
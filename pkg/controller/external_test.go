@@ -0,0 +1,65 @@
+package controller
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"sigs.k8s.io/cloud-provider-kind/pkg/config"
+	"sigs.k8s.io/cloud-provider-kind/pkg/container"
+)
+
+const externalKubeconfig = `apiVersion: v1
+kind: Config
+clusters:
+- name: k3d-dev
+  cluster: {server: "https://127.0.0.1:6550"}
+contexts:
+- name: k3d-dev
+  context: {cluster: k3d-dev, user: admin@k3d-dev}
+current-context: k3d-dev
+users:
+- name: admin@k3d-dev
+  user: {token: secret}
+`
+
+func TestSetupExternalCluster(t *testing.T) {
+	defer func(path, label string) {
+		config.DefaultConfig.ExternalKubeconfig, config.DefaultConfig.ExternalNodeLabel = path, label
+	}(config.DefaultConfig.ExternalKubeconfig, config.DefaultConfig.ExternalNodeLabel)
+	fake := container.NewFake()
+	defer container.SetRuntime(fake)()
+	fake.AddContainer(&container.FakeContainer{Name: "k3d-dev-server-0", Labels: map[string]string{"k3d.cluster": "dev", "k3d.role": "server"}})
+
+	path := filepath.Join(t.TempDir(), "kubeconfig")
+	if err := os.WriteFile(path, []byte(externalKubeconfig), 0600); err != nil {
+		t.Fatal(err)
+	}
+	config.DefaultConfig.ExternalKubeconfig = path
+	config.DefaultConfig.ExternalNodeLabel = ""
+	// removes the resolver registered by the test
+	defer container.SetNodeResolver("k3d-dev", container.LabelNodeResolver{})()
+	c := &Controller{}
+	if err := c.setupExternalCluster(); err != nil {
+		t.Fatalf("setupExternalCluster() error = %v", err)
+	}
+	if c.external != "k3d-dev" {
+		t.Errorf("external cluster = %q, want the current context k3d-dev", c.external)
+	}
+	if name, err := container.NodeContainer("k3d-dev", "k3d-dev-server-0"); err != nil || name != "k3d-dev-server-0" {
+		t.Errorf("NodeContainer() = %q, %v, want the k3d node container", name, err)
+	}
+
+	kconfig, err := c.kubeConfig("k3d-dev", false)
+	if err != nil || kconfig != externalKubeconfig {
+		t.Errorf("kubeConfig() = %q, %v, want the content of the kubeconfig", kconfig, err)
+	}
+	if _, err := c.kubeConfig("k3d-dev", true); err == nil {
+		t.Errorf("kubeConfig() of the internal address of the external cluster expected an error")
+	}
+
+	config.DefaultConfig.ExternalKubeconfig = filepath.Join(t.TempDir(), "missing")
+	if err := (&Controller{}).setupExternalCluster(); err == nil {
+		t.Errorf("setupExternalCluster() of a missing kubeconfig expected an error")
+	}
+}
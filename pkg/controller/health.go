@@ -0,0 +1,147 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/component-base/metrics/legacyregistry"
+	"k8s.io/klog/v2"
+
+	"sigs.k8s.io/cloud-provider-kind/pkg/config"
+	"sigs.k8s.io/cloud-provider-kind/pkg/container"
+)
+
+const healthCheckTimeout = 5 * time.Second
+
+// healthCheck is a named check of the health endpoints
+type healthCheck struct {
+	name string
+	fn   func(ctx context.Context) error
+}
+
+// clusterHealth has what is needed to check the health of a managed cluster
+type clusterHealth struct {
+	kubeClient kubernetes.Interface
+	synced     []cache.InformerSynced
+}
+
+// health serves the liveness and readiness probes of the controller:
+// /healthz checks the container runtime is reachable and /readyz in addition
+// checks the apiserver of every managed cluster and its informers are synced.
+type health struct {
+	mu       sync.Mutex
+	clusters map[string]clusterHealth
+}
+
+func newHealth() *health {
+	return &health{clusters: map[string]clusterHealth{}}
+}
+
+func (h *health) addCluster(name string, kubeClient kubernetes.Interface, synced ...cache.InformerSynced) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.clusters[name] = clusterHealth{kubeClient: kubeClient, synced: synced}
+}
+
+func (h *health) removeCluster(name string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.clusters, name)
+}
+
+func (h *health) livenessChecks() []healthCheck {
+	return []healthCheck{{name: "container-runtime", fn: func(ctx context.Context) error {
+		return container.Ping(ctx)
+	}}}
+}
+
+func (h *health) readinessChecks() []healthCheck {
+	checks := h.livenessChecks()
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	names := []string{}
+	for name := range h.clusters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		cluster := h.clusters[name]
+		checks = append(checks,
+			healthCheck{name: "cluster-" + name + "-apiserver", fn: func(ctx context.Context) error {
+				return cluster.kubeClient.Discovery().RESTClient().Get().AbsPath("/readyz").Do(ctx).Error()
+			}},
+			healthCheck{name: "cluster-" + name + "-informers", fn: func(ctx context.Context) error {
+				for _, synced := range cluster.synced {
+					if !synced() {
+						return fmt.Errorf("informers not synced")
+					}
+				}
+				return nil
+			}},
+		)
+	}
+	return checks
+}
+
+// start serves the health endpoints and the metrics until the context is cancelled
+func (h *health) start(ctx context.Context) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		serveChecks(w, r, h.livenessChecks())
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		serveChecks(w, r, h.readinessChecks())
+	})
+	mux.Handle("/metrics", legacyregistry.Handler())
+	server := &http.Server{Addr: config.DefaultConfig.HealthAddress, Handler: mux}
+	go func() {
+		<-ctx.Done()
+		server.Close() // nolint:errcheck
+	}()
+	go func() {
+		klog.InfoS("Serving the health probes", "address", config.DefaultConfig.HealthAddress)
+		err := server.ListenAndServe()
+		if err != nil && err != http.ErrServerClosed {
+			klog.ErrorS(err, "Health server failed")
+		}
+	}()
+}
+
+// serveChecks runs the checks and replies with the same format as the Kubernetes
+// components, the result of every check is listed on failure or with ?verbose.
+func serveChecks(w http.ResponseWriter, r *http.Request, checks []healthCheck) {
+	ctx, cancel := context.WithTimeout(r.Context(), healthCheckTimeout)
+	defer cancel()
+
+	var out strings.Builder
+	failed := false
+	for _, check := range checks {
+		if err := check.fn(ctx); err != nil {
+			failed = true
+			fmt.Fprintf(&out, "[-]%s failed: %v\n", check.name, err)
+			klog.V(2).InfoS("Health check failed", "check", check.name, "err", err)
+			continue
+		}
+		fmt.Fprintf(&out, "[+]%s ok\n", check.name)
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	if failed {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintf(w, "%s%s check failed\n", out.String(), strings.TrimPrefix(r.URL.Path, "/")) // nolint:errcheck
+		return
+	}
+	if _, verbose := r.URL.Query()["verbose"]; verbose {
+		fmt.Fprintf(w, "%s%s check passed\n", out.String(), strings.TrimPrefix(r.URL.Path, "/")) // nolint:errcheck
+		return
+	}
+	fmt.Fprint(w, "ok") // nolint:errcheck
+}
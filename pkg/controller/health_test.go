@@ -0,0 +1,55 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func Test_serveChecks(t *testing.T) {
+	ok := healthCheck{name: "ok", fn: func(context.Context) error { return nil }}
+	fail := healthCheck{name: "fail", fn: func(context.Context) error { return errors.New("boom") }}
+	tests := []struct {
+		name     string
+		url      string
+		checks   []healthCheck
+		wantCode int
+		wantBody string
+	}{
+		{
+			name:     "healthy",
+			url:      "/readyz",
+			checks:   []healthCheck{ok},
+			wantCode: http.StatusOK,
+			wantBody: "ok",
+		},
+		{
+			name:     "healthy verbose",
+			url:      "/readyz?verbose",
+			checks:   []healthCheck{ok},
+			wantCode: http.StatusOK,
+			wantBody: "[+]ok ok\nreadyz check passed\n",
+		},
+		{
+			name:     "unhealthy",
+			url:      "/healthz",
+			checks:   []healthCheck{ok, fail},
+			wantCode: http.StatusInternalServerError,
+			wantBody: "[+]ok ok\n[-]fail failed: boom\nhealthz check failed\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			serveChecks(rec, httptest.NewRequest(http.MethodGet, tt.url, nil), tt.checks)
+			if rec.Code != tt.wantCode {
+				t.Errorf("serveChecks() code = %d, want %d", rec.Code, tt.wantCode)
+			}
+			if rec.Body.String() != tt.wantBody {
+				t.Errorf("serveChecks() body = %q, want %q", rec.Body.String(), tt.wantBody)
+			}
+		})
+	}
+}
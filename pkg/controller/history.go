@@ -0,0 +1,180 @@
+package controller
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+
+	"sigs.k8s.io/cloud-provider-kind/pkg/loadbalancer"
+)
+
+// syncHistorySize is the number of syncs kept per Service
+const syncHistorySize = 20
+
+// The actions of the syncs
+const (
+	syncActionEnsure = "Ensure"
+	syncActionDelete = "Delete"
+)
+
+// SyncAttempt is a sync of the loadbalancer of a Service, reported by the admin API so the
+// changes of a loadbalancer can be correlated with the changes of the Service and the nodes
+type SyncAttempt struct {
+	Time     time.Time       `json:"time"`
+	Duration metav1.Duration `json:"duration"`
+	// Action is Ensure or Delete
+	Action string `json:"action"`
+	// Error is the error of the sync, empty if it succeeded
+	Error string `json:"error,omitempty"`
+	// Changes are the changes of the Service and of the nodes since the previous sync,
+	// ex. "spec.ports" or "node kind-worker NotReady"
+	Changes []string `json:"changes,omitempty"`
+}
+
+// syncHistory is the bounded history of the syncs of a Service, with the Service and the
+// state of the nodes of the last sync to compute the changes of the next one
+type syncHistory struct {
+	attempts []SyncAttempt
+	service  *v1.Service
+	nodes    map[string]string
+}
+
+// recordSync adds the sync of the Service to its history
+func (c *serviceController) recordSync(key string, action string, service *v1.Service, start time.Time, err error) {
+	var nodes map[string]string
+	if action == syncActionEnsure && c.nodeLister != nil {
+		if list, lerr := c.nodeLister.List(labels.Everything()); lerr == nil {
+			nodes = nodeStates(loadbalancer.EligibleNodes(list))
+		}
+	}
+	attempt := SyncAttempt{
+		Time:     start,
+		Duration: metav1.Duration{Duration: time.Since(start)},
+		Action:   action,
+	}
+	if err != nil {
+		attempt.Error = err.Error()
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.histories == nil {
+		c.histories = map[string]*syncHistory{}
+	}
+	history, ok := c.histories[key]
+	if !ok {
+		history = &syncHistory{}
+		c.histories[key] = history
+	}
+	attempt.Changes = append(serviceChanges(history.service, service), nodeChanges(history.nodes, nodes)...)
+	history.attempts = append(history.attempts, attempt)
+	if len(history.attempts) > syncHistorySize {
+		history.attempts = history.attempts[len(history.attempts)-syncHistorySize:]
+	}
+	history.service = service
+	if nodes != nil {
+		history.nodes = nodes
+	}
+}
+
+// forgetSyncs deletes the history of the Service, once it is deleted
+func (c *serviceController) forgetSyncs(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.histories, key)
+}
+
+// syncs returns the history of the syncs of the Service, the oldest first
+func (c *serviceController) syncs(key string) []SyncAttempt {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	history, ok := c.histories[key]
+	if !ok {
+		return nil
+	}
+	return append([]SyncAttempt{}, history.attempts...)
+}
+
+// serviceChanges returns the fields of the spec and the annotations changed between the
+// Services, and if the Service is being deleted. There are no changes for the first sync.
+func serviceChanges(old, cur *v1.Service) []string {
+	if old == nil || cur == nil {
+		return nil
+	}
+	changes := []string{}
+	oldSpec, curSpec := reflect.ValueOf(old.Spec), reflect.ValueOf(cur.Spec)
+	for i := 0; i < oldSpec.NumField(); i++ {
+		if reflect.DeepEqual(oldSpec.Field(i).Interface(), curSpec.Field(i).Interface()) {
+			continue
+		}
+		name, _, _ := strings.Cut(oldSpec.Type().Field(i).Tag.Get("json"), ",")
+		changes = append(changes, "spec."+name)
+	}
+	oldAnnotations, curAnnotations := userAnnotations(old), userAnnotations(cur)
+	keys := []string{}
+	for key, value := range curAnnotations {
+		if previous, ok := oldAnnotations[key]; !ok || previous != value {
+			keys = append(keys, key)
+		}
+	}
+	for key := range oldAnnotations {
+		if _, ok := curAnnotations[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		changes = append(changes, "annotation "+key)
+	}
+	if old.DeletionTimestamp == nil && cur.DeletionTimestamp != nil {
+		changes = append(changes, "deletion")
+	}
+	return changes
+}
+
+// nodeStates returns the state of the nodes that can be used as backends, by name
+func nodeStates(nodes []*v1.Node) map[string]string {
+	states := map[string]string{}
+	for _, node := range nodes {
+		switch {
+		case !loadbalancer.NodeReady(node):
+			states[node.Name] = "NotReady"
+		case node.Spec.Unschedulable:
+			states[node.Name] = "Unschedulable"
+		default:
+			states[node.Name] = "Ready"
+		}
+	}
+	return states
+}
+
+// nodeChanges returns the nodes added, removed or whose state changed, nil if the nodes
+// of one of the syncs are unknown
+func nodeChanges(old, cur map[string]string) []string {
+	if old == nil || cur == nil {
+		return nil
+	}
+	changes := []string{}
+	for name, state := range cur {
+		previous, ok := old[name]
+		switch {
+		case !ok:
+			changes = append(changes, fmt.Sprintf("node %s added", name))
+		case previous != state:
+			changes = append(changes, fmt.Sprintf("node %s %s", name, state))
+		}
+	}
+	for name := range old {
+		if _, ok := cur[name]; !ok {
+			changes = append(changes, fmt.Sprintf("node %s removed", name))
+		}
+	}
+	sort.Strings(changes)
+	return changes
+}
@@ -0,0 +1,86 @@
+package controller
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+func Test_serviceChanges(t *testing.T) {
+	service := &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "ns", Annotations: map[string]string{"kind.x-k8s.io/idle-timeout": "30s", "removed": "x"}},
+		Spec:       v1.ServiceSpec{Type: v1.ServiceTypeLoadBalancer, Ports: []v1.ServicePort{{Port: 80}}},
+	}
+	updated := service.DeepCopy()
+	updated.Spec.Ports[0].Port = 8080
+	updated.Spec.ExternalTrafficPolicy = v1.ServiceExternalTrafficPolicyLocal
+	updated.Annotations = map[string]string{"kind.x-k8s.io/idle-timeout": "60s", "added": "y"}
+	updated.DeletionTimestamp = &metav1.Time{Time: time.Now()}
+
+	if got := serviceChanges(nil, service); got != nil {
+		t.Errorf("serviceChanges() of the first sync = %v, want none", got)
+	}
+	if got := serviceChanges(service, service.DeepCopy()); len(got) != 0 {
+		t.Errorf("serviceChanges() of the same Service = %v, want none", got)
+	}
+	want := []string{"spec.ports", "spec.externalTrafficPolicy", "annotation added", "annotation kind.x-k8s.io/idle-timeout", "annotation removed", "deletion"}
+	if diff := cmp.Diff(want, serviceChanges(service, updated)); diff != "" {
+		t.Errorf("serviceChanges() mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func Test_nodeChanges(t *testing.T) {
+	old := map[string]string{"a": "Ready", "b": "Ready", "c": "Ready"}
+	cur := map[string]string{"a": "Ready", "b": "NotReady", "d": "Ready"}
+	want := []string{"node b NotReady", "node c removed", "node d added"}
+	if diff := cmp.Diff(want, nodeChanges(old, cur)); diff != "" {
+		t.Errorf("nodeChanges() mismatch (-want +got):\n%s", diff)
+	}
+	if got := nodeChanges(nil, cur); got != nil {
+		t.Errorf("nodeChanges() without previous nodes = %v, want none", got)
+	}
+}
+
+func Test_recordSync(t *testing.T) {
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	ready := makeNode("a", nil)
+	ready.Status.Conditions = []v1.NodeCondition{{Type: v1.NodeReady, Status: v1.ConditionTrue}}
+	if err := indexer.Add(ready); err != nil {
+		t.Fatal(err)
+	}
+	c := &serviceController{nodeLister: corelisters.NewNodeLister(indexer)}
+	service := &v1.Service{ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "ns"}, Spec: v1.ServiceSpec{Type: v1.ServiceTypeLoadBalancer}}
+
+	c.recordSync("ns/web", syncActionEnsure, service, time.Now(), nil)
+	notReady := ready.DeepCopy()
+	notReady.Status.Conditions[0].Status = v1.ConditionFalse
+	if err := indexer.Update(notReady); err != nil {
+		t.Fatal(err)
+	}
+	c.recordSync("ns/web", syncActionEnsure, service, time.Now(), errors.New("no healthy backends"))
+	got := c.syncs("ns/web")
+	if len(got) != 2 || got[0].Error != "" || len(got[0].Changes) != 0 {
+		t.Fatalf("syncs() = %+v, want the first sync without changes", got)
+	}
+	if got[1].Error != "no healthy backends" || !cmp.Equal(got[1].Changes, []string{"node a NotReady"}) {
+		t.Errorf("syncs() = %+v, want the failed sync after the node change", got[1])
+	}
+
+	// the history is bounded
+	for i := 0; i < syncHistorySize; i++ {
+		c.recordSync("ns/web", syncActionEnsure, service, time.Now(), nil)
+	}
+	if got := c.syncs("ns/web"); len(got) != syncHistorySize || got[0].Error != "" {
+		t.Errorf("syncs() has %d syncs, want the last %d", len(got), syncHistorySize)
+	}
+	c.forgetSyncs("ns/web")
+	if got := c.syncs("ns/web"); got != nil {
+		t.Errorf("syncs() of a deleted Service = %+v, want none", got)
+	}
+}
@@ -0,0 +1,115 @@
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"sigs.k8s.io/cloud-provider-kind/pkg/constants"
+	"sigs.k8s.io/cloud-provider-kind/pkg/container"
+	"sigs.k8s.io/cloud-provider-kind/pkg/loadbalancer"
+)
+
+const inspectTimeout = 10 * time.Second
+
+// ListLoadBalancers returns the loadbalancers of the cluster, or of all the clusters if
+// empty. They are queried from the admin API of the controller at adminAddress, or from
+// the labels of the loadbalancer containers if empty, without the Service status and
+// errors and with the shared loadbalancers named after their shared IP key.
+func ListLoadBalancers(adminAddress string, clusterName string) ([]LoadBalancerInfo, error) {
+	loadBalancers := []LoadBalancerInfo{}
+	if adminAddress != "" {
+		err := getAdmin(adminAddress, "/loadbalancers", &loadBalancers)
+		if err != nil {
+			return nil, err
+		}
+		filtered := []LoadBalancerInfo{}
+		for _, lb := range loadBalancers {
+			if clusterName == "" || lb.Cluster == clusterName {
+				filtered = append(filtered, lb)
+			}
+		}
+		return filtered, nil
+	}
+
+	label := constants.NodeCCMLabelKey
+	if clusterName != "" {
+		label = fmt.Sprintf("%s=%s", constants.NodeCCMLabelKey, clusterName)
+	}
+	containers, err := container.ListLabelValues(label, constants.LoadBalancerNameLabelKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list the loadbalancer containers: %w", err)
+	}
+	for id, value := range containers {
+		cluster, service := loadbalancer.ServiceFromLoadBalancerSimpleName(value)
+		if service == nil {
+			continue
+		}
+		loadBalancers = append(loadBalancers, LoadBalancerInfo{
+			Cluster:     cluster,
+			Namespace:   service.Namespace,
+			Service:     service.Name,
+			Description: loadbalancer.DescribeLoadBalancer(id, false),
+		})
+	}
+	sort.Slice(loadBalancers, func(i, j int) bool {
+		a, b := loadBalancers[i], loadBalancers[j]
+		if a.Cluster != b.Cluster {
+			return a.Cluster < b.Cluster
+		}
+		if a.Namespace != b.Namespace {
+			return a.Namespace < b.Namespace
+		}
+		return a.Service < b.Service
+	})
+	return loadBalancers, nil
+}
+
+// DescribeLoadBalancer returns the loadbalancer of the Service with its config, from the
+// admin API at adminAddress or from the labels of the loadbalancer containers if empty
+func DescribeLoadBalancer(adminAddress string, clusterName string, namespace string, name string) (*LoadBalancerInfo, error) {
+	if adminAddress != "" {
+		lb := &LoadBalancerInfo{}
+		err := getAdmin(adminAddress, "/loadbalancers/"+clusterName+"/"+namespace+"/"+name, lb)
+		if err != nil {
+			return nil, err
+		}
+		return lb, nil
+	}
+	label := fmt.Sprintf("%s=%s/%s/%s", constants.LoadBalancerNameLabelKey, clusterName, namespace, name)
+	ids, err := container.ListByLabel(label)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list the loadbalancer containers: %w", err)
+	}
+	if len(ids) == 0 {
+		return nil, fmt.Errorf("no loadbalancer for Service %s/%s on cluster %s, the shared loadbalancers are named after their shared IP key", namespace, name, clusterName)
+	}
+	return &LoadBalancerInfo{
+		Cluster:     clusterName,
+		Namespace:   namespace,
+		Service:     name,
+		Description: loadbalancer.DescribeLoadBalancer(ids[0], true),
+	}, nil
+}
+
+// getAdmin decodes the JSON reply of the admin API to the path
+func getAdmin(adminAddress string, path string, v interface{}) error {
+	client := &http.Client{Timeout: inspectTimeout}
+	resp, err := client.Get("http://" + adminAddress + path)
+	if err != nil {
+		return fmt.Errorf("failed to query the admin API: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("admin API replied %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("invalid admin API reply: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+package controller
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"sigs.k8s.io/cloud-provider-kind/pkg/constants"
+	"sigs.k8s.io/cloud-provider-kind/pkg/container"
+)
+
+func TestListLoadBalancersLabels(t *testing.T) {
+	fake := container.NewFake()
+	defer container.SetRuntime(fake)()
+	for _, c := range []*container.FakeContainer{
+		{Name: "lb-b", Labels: map[string]string{constants.NodeCCMLabelKey: "kind", constants.LoadBalancerNameLabelKey: "kind/ns/web"}, IPv4: "172.18.0.10", Ports: map[string]string{"80/tcp": "32768"}},
+		{Name: "lb-a", Labels: map[string]string{constants.NodeCCMLabelKey: "kind", constants.LoadBalancerNameLabelKey: "kind/default/api"}},
+		{Name: "lb-other", Labels: map[string]string{constants.NodeCCMLabelKey: "other", constants.LoadBalancerNameLabelKey: "other/ns/web"}},
+		{Name: "kind-control-plane", Labels: map[string]string{"io.x-k8s.kind.cluster": "kind"}},
+	} {
+		fake.AddContainer(c)
+	}
+
+	got, err := ListLoadBalancers("", "kind")
+	if err != nil {
+		t.Fatalf("ListLoadBalancers() error = %v", err)
+	}
+	names := []string{}
+	for _, lb := range got {
+		names = append(names, lb.Cluster+"/"+lb.Namespace+"/"+lb.Service+"="+lb.Container)
+	}
+	if want := []string{"kind/default/api=lb-a", "kind/ns/web=lb-b"}; !reflect.DeepEqual(names, want) {
+		t.Fatalf("ListLoadBalancers() = %v, want %v", names, want)
+	}
+	if got[1].IPv4 != "172.18.0.10" || got[1].Status() != "stopped" {
+		t.Errorf("loadbalancer %+v, want the stopped container with its IP", got[1])
+	}
+
+	all, err := ListLoadBalancers("", "")
+	if err != nil {
+		t.Fatalf("ListLoadBalancers() error = %v", err)
+	}
+	if len(all) != 3 {
+		t.Errorf("ListLoadBalancers() returned %d loadbalancers of all the clusters, want 3", len(all))
+	}
+
+	lb, err := DescribeLoadBalancer("", "kind", "ns", "web")
+	if err != nil {
+		t.Fatalf("DescribeLoadBalancer() error = %v", err)
+	}
+	if lb.Container != "lb-b" {
+		t.Errorf("DescribeLoadBalancer() container = %s, want lb-b", lb.Container)
+	}
+	if _, err := DescribeLoadBalancer("", "kind", "ns", "missing"); err == nil {
+		t.Errorf("DescribeLoadBalancer() of a Service without loadbalancer expected an error")
+	}
+}
+
+func TestListLoadBalancersAdmin(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/loadbalancers":
+			writeJSON(w, []LoadBalancerInfo{
+				{Cluster: "kind", Namespace: "ns", Service: "web", Ingress: []string{"172.18.0.10"}},
+				{Cluster: "other", Namespace: "ns", Service: "web"},
+			})
+		case "/loadbalancers/kind/ns/web":
+			writeJSON(w, LoadBalancerInfo{Cluster: "kind", Namespace: "ns", Service: "web", LastError: &SyncError{Message: "failed"}})
+		default:
+			http.Error(w, "Service not found", http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+	address := strings.TrimPrefix(server.URL, "http://")
+
+	got, err := ListLoadBalancers(address, "kind")
+	if err != nil {
+		t.Fatalf("ListLoadBalancers() error = %v", err)
+	}
+	if len(got) != 1 || got[0].Cluster != "kind" || !reflect.DeepEqual(got[0].Ingress, []string{"172.18.0.10"}) {
+		t.Errorf("ListLoadBalancers() = %+v, want the web Service of cluster kind", got)
+	}
+
+	lb, err := DescribeLoadBalancer(address, "kind", "ns", "web")
+	if err != nil {
+		t.Fatalf("DescribeLoadBalancer() error = %v", err)
+	}
+	if lb.LastError == nil || lb.LastError.Message != "failed" {
+		t.Errorf("DescribeLoadBalancer() = %+v, want the last error", lb)
+	}
+	_, err = DescribeLoadBalancer(address, "kind", "ns", "missing")
+	if err == nil || !strings.Contains(err.Error(), "Service not found") {
+		t.Errorf("DescribeLoadBalancer() error = %v, want the admin API error", err)
+	}
+}
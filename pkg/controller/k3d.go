@@ -0,0 +1,71 @@
+package controller
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+
+	"k8s.io/client-go/tools/clientcmd"
+
+	"sigs.k8s.io/cloud-provider-kind/pkg/container"
+)
+
+// k3sKubeconfigPath is the kubeconfig written by k3s in the server containers, with the
+// apiserver address of the loopback
+const k3sKubeconfigPath = "/etc/rancher/k3s/k3s.yaml"
+
+// k3dClusters returns the k3d clusters, with --k3d, and registers the resolver of their
+// node containers
+func k3dClusters() ([]string, error) {
+	clusters, err := container.K3dClusters()
+	if err != nil {
+		return nil, err
+	}
+	for _, cluster := range clusters {
+		container.SetNodeResolver(cluster, container.K3dNodeResolver{})
+	}
+	return clusters, nil
+}
+
+// k3dKubeConfig returns the kubeconfig of the k3d cluster read from its first server, with
+// the apiserver address published on the host by the loadbalancer of the servers or the
+// server itself, or with the address of the server in the k3d network if internal
+func k3dKubeConfig(cluster string, internal bool) (string, error) {
+	server := cluster + "-server-0"
+	var stdout bytes.Buffer
+	if err := container.Exec(server, []string{"cat", k3sKubeconfigPath}, nil, &stdout, nil); err != nil {
+		return "", fmt.Errorf("failed to read the kubeconfig of the k3d cluster %s: %w", cluster, err)
+	}
+	kconfig, err := clientcmd.Load(stdout.Bytes())
+	if err != nil {
+		return "", err
+	}
+	address := "https://" + net.JoinHostPort(server, "6443")
+	if !internal {
+		address = ""
+		for _, name := range []string{cluster + "-serverlb", server} {
+			if !container.Exist(name) {
+				continue
+			}
+			ports, err := container.PortMappings(name)
+			if err != nil {
+				return "", err
+			}
+			if port, ok := ports["6443/tcp"]; ok {
+				address = "https://" + net.JoinHostPort("127.0.0.1", port)
+				break
+			}
+		}
+		if address == "" {
+			return "", fmt.Errorf("the apiserver of the k3d cluster %s is not published on the host", cluster)
+		}
+	}
+	for _, c := range kconfig.Clusters {
+		c.Server = address
+	}
+	out, err := clientcmd.Write(*kconfig)
+	if err != nil {
+		return "", err
+	}
+	return string(out), nil
+}
@@ -0,0 +1,72 @@
+package controller
+
+import (
+	"testing"
+
+	"k8s.io/client-go/tools/clientcmd"
+
+	"sigs.k8s.io/cloud-provider-kind/pkg/container"
+)
+
+const k3sKubeconfig = `apiVersion: v1
+kind: Config
+clusters:
+- name: default
+  cluster: {server: "https://127.0.0.1:6443"}
+contexts:
+- name: default
+  context: {cluster: default, user: default}
+current-context: default
+users:
+- name: default
+  user: {token: secret}
+`
+
+func TestK3dClusters(t *testing.T) {
+	fake := container.NewFake()
+	defer container.SetRuntime(fake)()
+	fake.AddContainer(&container.FakeContainer{
+		Name:    "k3d-dev-server-0",
+		Labels:  map[string]string{"k3d.cluster": "dev", "k3d.role": "server"},
+		Running: true,
+		Files:   map[string]string{k3sKubeconfigPath: k3sKubeconfig},
+	})
+	fake.AddContainer(&container.FakeContainer{
+		Name:    "k3d-dev-serverlb",
+		Labels:  map[string]string{"k3d.cluster": "dev", "k3d.role": "loadbalancer"},
+		Running: true,
+		Ports:   map[string]string{"6443/tcp": "6550"},
+	})
+	// removes the resolver registered by the test
+	defer container.SetNodeResolver("k3d-dev", container.LabelNodeResolver{})()
+
+	clusters, err := k3dClusters()
+	if err != nil || len(clusters) != 1 || clusters[0] != "k3d-dev" {
+		t.Fatalf("k3dClusters() = %v, %v, want k3d-dev", clusters, err)
+	}
+	if name, err := container.NodeContainer("k3d-dev", "k3d-dev-serverlb"); err != nil || name != "" {
+		t.Errorf("NodeContainer() of the loadbalancer = %q, %v, want no node", name, err)
+	}
+
+	c := &Controller{tools: map[string]kubeConfigFunc{"k3d-dev": k3dKubeConfig}}
+	tests := []struct {
+		internal bool
+		want     string
+	}{
+		{internal: false, want: "https://127.0.0.1:6550"},
+		{internal: true, want: "https://k3d-dev-server-0:6443"},
+	}
+	for _, tt := range tests {
+		data, err := c.kubeConfig("k3d-dev", tt.internal)
+		if err != nil {
+			t.Fatalf("kubeConfig() error = %v", err)
+		}
+		kconfig, err := clientcmd.Load([]byte(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := kconfig.Clusters["default"].Server; got != tt.want {
+			t.Errorf("kubeConfig(internal=%v) server = %q, want %q", tt.internal, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,225 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/yaml"
+
+	"sigs.k8s.io/cloud-provider-kind/pkg/constants"
+	"sigs.k8s.io/cloud-provider-kind/pkg/loadbalancer"
+)
+
+// The LoadBalancerStatus objects mirror the stats of the loadbalancer of each Service, for the
+// users that want to see them in the cluster without scraping the proxies. They are named
+// after the Service and owned by it, so they are garbage collected with the Service, and
+// written with server-side apply through the discovery REST client, like the apiserver
+// health checks, since the typed clients do not know the resource.
+const (
+	lbStatusAPIVersion   = "kind.x-k8s.io/v1alpha1"
+	lbStatusKind         = "LoadBalancerStatus"
+	lbStatusResource     = "loadbalancerstatuses"
+	lbStatusFieldManager = "cloud-provider-kind"
+)
+
+const lbStatusCRD = `
+apiVersion: apiextensions.k8s.io/v1
+kind: CustomResourceDefinition
+metadata:
+  name: loadbalancerstatuses.kind.x-k8s.io
+  labels:
+    app.kubernetes.io/managed-by: cloud-provider-kind
+spec:
+  group: kind.x-k8s.io
+  scope: Namespaced
+  names:
+    kind: LoadBalancerStatus
+    listKind: LoadBalancerStatusList
+    plural: loadbalancerstatuses
+    singular: loadbalancerstatus
+    shortNames: [lbstatus]
+  versions:
+  - name: v1alpha1
+    served: true
+    storage: true
+    additionalPrinterColumns:
+    - {name: Active, type: integer, jsonPath: .status.activeConnections}
+    - {name: Total, type: integer, jsonPath: .status.totalConnections}
+    - {name: Healthy, type: integer, jsonPath: .status.healthyBackends}
+    - {name: Backends, type: integer, jsonPath: .status.totalBackends}
+    - {name: Updated, type: date, jsonPath: .status.lastUpdateTime}
+    schema:
+      openAPIV3Schema:
+        type: object
+        properties:
+          status:
+            type: object
+            properties:
+              container: {type: string}
+              lastUpdateTime: {type: string, format: date-time}
+              activeConnections: {type: integer, format: int64}
+              totalConnections: {type: integer, format: int64}
+              healthyBackends: {type: integer, format: int64}
+              totalBackends: {type: integer, format: int64}
+              ports:
+                type: array
+                items:
+                  type: object
+                  properties:
+                    name: {type: string}
+                    activeConnections: {type: integer, format: int64}
+                    totalConnections: {type: integer, format: int64}
+                    healthyBackends: {type: integer, format: int64}
+                    totalBackends: {type: integer, format: int64}
+`
+
+// loadBalancerStatusObject is a LoadBalancerStatus object, the stats of the loadbalancer of
+// the Service with the same name
+type loadBalancerStatusObject struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	Status            loadBalancerStatusStatus `json:"status"`
+}
+
+type loadBalancerStatusStatus struct {
+	Container          string      `json:"container"`
+	LastUpdateTime     metav1.Time `json:"lastUpdateTime"`
+	loadbalancer.Stats `json:",inline"`
+	Ports              []loadbalancer.PortStats `json:"ports,omitempty"`
+}
+
+// newLoadBalancerStatusObject returns the LoadBalancerStatus object of the Service
+func newLoadBalancerStatusObject(service *v1.Service, name string, total loadbalancer.Stats, ports []loadbalancer.PortStats, now time.Time) *loadBalancerStatusObject {
+	return &loadBalancerStatusObject{
+		TypeMeta: metav1.TypeMeta{APIVersion: lbStatusAPIVersion, Kind: lbStatusKind},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      service.Name,
+			Namespace: service.Namespace,
+			Labels:    map[string]string{constants.ManagedByLabelKey: constants.ManagedByLabelValue},
+			OwnerReferences: []metav1.OwnerReference{
+				*metav1.NewControllerRef(service, v1.SchemeGroupVersion.WithKind("Service")),
+			},
+		},
+		Status: loadBalancerStatusStatus{
+			Container:      name,
+			LastUpdateTime: metav1.NewTime(now),
+			Stats:          total,
+			Ports:          ports,
+		},
+	}
+}
+
+// reportLoadBalancerStatuses refreshes the LoadBalancerStatus objects of the Services with
+// a loadbalancer every interval until the context is cancelled
+func (c *serviceController) reportLoadBalancerStatuses(ctx context.Context, interval time.Duration) {
+	logger := klog.FromContext(ctx).WithValues("cluster", c.clusterName)
+	ctx = klog.NewContext(ctx, logger)
+	crdCreated := false
+	wait.UntilWithContext(ctx, func(ctx context.Context) {
+		if !crdCreated {
+			err := c.ensureLoadBalancerStatusCRD(ctx)
+			if err != nil {
+				logger.Error(err, "Failed to create the LoadBalancerStatus CRD")
+				return
+			}
+			crdCreated = true
+		}
+		err := c.syncLoadBalancerStatuses(ctx)
+		if err != nil {
+			logger.Error(err, "Failed to update the LoadBalancerStatus objects")
+		}
+	}, interval)
+}
+
+// ensureLoadBalancerStatusCRD creates the LoadBalancerStatus CRD if it does not exist
+func (c *serviceController) ensureLoadBalancerStatusCRD(ctx context.Context) error {
+	body, err := yaml.YAMLToJSON([]byte(lbStatusCRD))
+	if err != nil {
+		return err
+	}
+	err = c.kubeClient.Discovery().RESTClient().Post().
+		AbsPath("/apis/apiextensions.k8s.io/v1/customresourcedefinitions").
+		SetHeader("Content-Type", "application/json").
+		Body(body).
+		Do(ctx).
+		Error()
+	if apierrors.IsAlreadyExists(err) {
+		return nil
+	}
+	return err
+}
+
+// syncLoadBalancerStatuses applies the current stats of the loadbalancers and deletes the
+// objects of the Services that no longer have a loadbalancer
+func (c *serviceController) syncLoadBalancerStatuses(ctx context.Context) error {
+	logger := klog.FromContext(ctx)
+	c.mu.Lock()
+	services := make(map[string]*v1.Service, len(c.lastSynced))
+	for key, service := range c.lastSynced {
+		services[key] = service
+	}
+	c.mu.Unlock()
+
+	client := c.kubeClient.Discovery().RESTClient()
+	now := time.Now()
+	for _, service := range services {
+		name := c.lbController.GetLoadBalancerName(ctx, c.clusterName, service)
+		total, ports, err := loadbalancer.LoadBalancerStats(name)
+		if err != nil {
+			logger.V(2).Info("Failed to get the loadbalancer stats", "service", klog.KObj(service), "err", err)
+			continue
+		}
+		body, err := json.Marshal(newLoadBalancerStatusObject(service, name, total, ports, now))
+		if err != nil {
+			return err
+		}
+		err = client.Patch(types.ApplyPatchType).
+			AbsPath(lbStatusPath(service.Namespace, service.Name)).
+			Param("fieldManager", lbStatusFieldManager).
+			Param("force", "true").
+			Body(body).
+			Do(ctx).
+			Error()
+		if err != nil {
+			return fmt.Errorf("failed to apply the LoadBalancerStatus of Service %s/%s: %w", service.Namespace, service.Name, err)
+		}
+	}
+
+	var list struct {
+		Items []loadBalancerStatusObject `json:"items"`
+	}
+	raw, err := client.Get().
+		AbsPath("/apis", lbStatusAPIVersion, lbStatusResource).
+		Param("labelSelector", constants.ManagedByLabelKey+"="+constants.ManagedByLabelValue).
+		DoRaw(ctx)
+	if err != nil {
+		return err
+	}
+	err = json.Unmarshal(raw, &list)
+	if err != nil {
+		return err
+	}
+	for _, obj := range list.Items {
+		if _, ok := services[obj.Namespace+"/"+obj.Name]; ok {
+			continue
+		}
+		err := client.Delete().AbsPath(lbStatusPath(obj.Namespace, obj.Name)).Do(ctx).Error()
+		if err != nil && !apierrors.IsNotFound(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+func lbStatusPath(namespace, name string) string {
+	return path.Join("/apis", lbStatusAPIVersion, "namespaces", namespace, lbStatusResource, name)
+}
@@ -0,0 +1,117 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"sigs.k8s.io/yaml"
+
+	"sigs.k8s.io/cloud-provider-kind/pkg/container"
+)
+
+func Test_lbStatusCRD(t *testing.T) {
+	crd := struct {
+		Metadata metav1.ObjectMeta `json:"metadata"`
+		Spec     struct {
+			Group string `json:"group"`
+			Names struct {
+				Kind   string `json:"kind"`
+				Plural string `json:"plural"`
+			} `json:"names"`
+			Versions []struct {
+				Name string `json:"name"`
+			} `json:"versions"`
+		} `json:"spec"`
+	}{}
+	if err := yaml.Unmarshal([]byte(lbStatusCRD), &crd); err != nil {
+		t.Fatalf("invalid CRD: %v", err)
+	}
+	apiVersion := crd.Spec.Group + "/" + crd.Spec.Versions[0].Name
+	if crd.Metadata.Name != lbStatusResource+"."+crd.Spec.Group || crd.Spec.Names.Plural != lbStatusResource ||
+		crd.Spec.Names.Kind != lbStatusKind || apiVersion != lbStatusAPIVersion {
+		t.Errorf("CRD %s %s/%s does not match the LoadBalancerStatus objects", crd.Metadata.Name, apiVersion, crd.Spec.Names.Kind)
+	}
+}
+
+func TestSyncLoadBalancerStatuses(t *testing.T) {
+	fake := container.NewFake()
+	defer container.SetRuntime(fake)()
+	fake.AddContainer(&container.FakeContainer{Name: "lb-web", Running: true})
+	fake.ExecHook = func(name string, command []string, stdout io.Writer) (bool, error) {
+		if len(command) != 3 || command[0] != "bash" || !strings.Contains(command[2], "/stats") {
+			return true, fmt.Errorf("unsupported command %v", command)
+		}
+		fmt.Fprintln(stdout, "cluster.cluster_IPv4_80_TCP.upstream_cx_active: 3")
+		fmt.Fprintln(stdout, "cluster.cluster_IPv4_80_TCP.membership_healthy: 1")
+		return true, nil
+	}
+
+	var mu sync.Mutex
+	requests := []string{}
+	applied := map[string]interface{}{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		requests = append(requests, r.Method+" "+r.URL.Path)
+		w.Header().Set("Content-Type", "application/json")
+		switch r.Method {
+		case http.MethodPatch:
+			body, _ := io.ReadAll(r.Body)
+			json.Unmarshal(body, &applied) // nolint:errcheck
+			w.Write(body)                  // nolint:errcheck
+		case http.MethodGet:
+			fmt.Fprint(w, `{"items": [{"metadata": {"name": "web", "namespace": "ns"}}, {"metadata": {"name": "old", "namespace": "ns"}}]}`)
+		default:
+			fmt.Fprint(w, `{}`)
+		}
+	}))
+	defer server.Close()
+	kubeClient, err := kubernetes.NewForConfig(&rest.Config{Host: server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	web := &v1.Service{ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "ns", UID: "1234"}}
+	missing := &v1.Service{ObjectMeta: metav1.ObjectMeta{Name: "missing", Namespace: "ns"}}
+	c := &serviceController{
+		clusterName:  "kind",
+		kubeClient:   kubeClient,
+		lbController: &fakeHealer{},
+		lastSynced:   map[string]*v1.Service{"ns/web": web, "ns/missing": missing},
+	}
+	if err := c.syncLoadBalancerStatuses(context.Background()); err != nil {
+		t.Fatalf("syncLoadBalancerStatuses() error = %v", err)
+	}
+
+	// the loadbalancers without stats are skipped and the objects of the Services without a
+	// loadbalancer are deleted
+	want := []string{
+		"PATCH /apis/kind.x-k8s.io/v1alpha1/namespaces/ns/loadbalancerstatuses/web",
+		"GET /apis/kind.x-k8s.io/v1alpha1/loadbalancerstatuses",
+		"DELETE /apis/kind.x-k8s.io/v1alpha1/namespaces/ns/loadbalancerstatuses/old",
+	}
+	if diff := cmp.Diff(want, requests); diff != "" {
+		t.Errorf("requests mismatch (-want +got):\n%s", diff)
+	}
+	status, _ := applied["status"].(map[string]interface{})
+	if status["container"] != "lb-web" || status["activeConnections"] != float64(3) || status["healthyBackends"] != float64(1) {
+		t.Errorf("unexpected status %v", status)
+	}
+	metadata, _ := applied["metadata"].(map[string]interface{})
+	owners, _ := metadata["ownerReferences"].([]interface{})
+	if len(owners) != 1 || owners[0].(map[string]interface{})["uid"] != "1234" {
+		t.Errorf("LoadBalancerStatus is not owned by the Service: %v", metadata)
+	}
+}
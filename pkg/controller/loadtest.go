@@ -0,0 +1,189 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/kind/pkg/log"
+
+	"sigs.k8s.io/cloud-provider-kind/pkg/constants"
+	"sigs.k8s.io/cloud-provider-kind/pkg/container"
+	"sigs.k8s.io/cloud-provider-kind/pkg/loadbalancer"
+)
+
+// LoadTestOptions configures the Services created by LoadTest
+type LoadTestOptions struct {
+	// ClusterName is the kind cluster the Services are created on
+	ClusterName string
+	// Namespace is created for the Services and deleted at the end if Cleanup is set
+	Namespace string
+	// Services is the number of LoadBalancer Services to create
+	Services int
+	// Timeout is the time to wait for all the loadbalancers to be provisioned
+	Timeout time.Duration
+	// Cleanup deletes the Services and waits for their loadbalancers to be removed
+	Cleanup bool
+}
+
+// LoadTestResult contains the measures of a LoadTest
+type LoadTestResult struct {
+	// Services is the number of Services created
+	Services int
+	// Latencies are the times from the creation of each Service to the publication of its
+	// loadbalancer status, sorted, only for the Services provisioned before the timeout
+	Latencies []time.Duration
+	// Duration is the time to provision all the loadbalancers
+	Duration time.Duration
+	// Calls is the number of container runtime calls of each method during the provisioning
+	Calls map[string]int
+	// CleanupDuration is the time to remove the loadbalancers, zero if there was no cleanup
+	CleanupDuration time.Duration
+}
+
+// Percentile returns the latency of the percentile p, between 0 and 100
+func (r *LoadTestResult) Percentile(p float64) time.Duration {
+	if len(r.Latencies) == 0 {
+		return 0
+	}
+	i := int(float64(len(r.Latencies))*p/100+0.5) - 1
+	if i < 0 {
+		i = 0
+	}
+	if i >= len(r.Latencies) {
+		i = len(r.Latencies) - 1
+	}
+	return r.Latencies[i]
+}
+
+// TotalCalls returns the number of container runtime calls during the provisioning
+func (r *LoadTestResult) TotalCalls() int {
+	total := 0
+	for _, n := range r.Calls {
+		total += n
+	}
+	return total
+}
+
+// LoadTest runs the controller for the cluster, creates synthetic LoadBalancer Services and
+// measures the time to provision their loadbalancers and the container runtime calls. The
+// Services do not select any Pod, so the loadbalancers must be published unready.
+func LoadTest(ctx context.Context, logger log.Logger, opts LoadTestOptions) (*LoadTestResult, error) {
+	counter, restore := container.CountCalls()
+	defer restore()
+
+	c := New(logger)
+	c.onlyCluster = opts.ClusterName
+	kubeClient, _, err := c.getKubeClient(ctx, opts.ClusterName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create kubeClient for cluster %s: %w", opts.ClusterName, err)
+	}
+	_, err = kubeClient.CoreV1().Namespaces().Create(ctx, &v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: opts.Namespace}}, metav1.CreateOptions{})
+	if err != nil && !apierrors.IsAlreadyExists(err) {
+		return nil, fmt.Errorf("failed to create namespace %s: %w", opts.Namespace, err)
+	}
+
+	runCtx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		c.Run(runCtx)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	start := time.Now()
+	created := map[string]time.Time{}
+	for i := 0; i < opts.Services; i++ {
+		service := loadTestService(i)
+		_, err := kubeClient.CoreV1().Services(opts.Namespace).Create(ctx, service, metav1.CreateOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("failed to create Service %s/%s: %w", opts.Namespace, service.Name, err)
+		}
+		created[service.Name] = time.Now()
+	}
+	klog.InfoS("Created the load test Services", "cluster", opts.ClusterName, "namespace", opts.Namespace, "services", opts.Services)
+
+	result := &LoadTestResult{Services: opts.Services}
+	ready := map[string]bool{}
+	err = wait.PollUntilContextTimeout(ctx, 500*time.Millisecond, opts.Timeout, true, func(ctx context.Context) (bool, error) {
+		list, err := kubeClient.CoreV1().Services(opts.Namespace).List(ctx, metav1.ListOptions{})
+		if err != nil {
+			klog.InfoS("Failed to list the load test Services", "err", err)
+			return false, nil
+		}
+		for _, service := range list.Items {
+			createdAt, ok := created[service.Name]
+			if !ok || ready[service.Name] || len(service.Status.LoadBalancer.Ingress) == 0 {
+				continue
+			}
+			ready[service.Name] = true
+			result.Latencies = append(result.Latencies, time.Since(createdAt))
+		}
+		klog.V(2).InfoS("Waiting for the load test loadbalancers", "ready", len(ready), "services", opts.Services)
+		return len(ready) == opts.Services, nil
+	})
+	result.Duration = time.Since(start)
+	result.Calls = counter.Calls()
+	sort.Slice(result.Latencies, func(i, j int) bool { return result.Latencies[i] < result.Latencies[j] })
+	if err != nil {
+		klog.InfoS("Not all the load test loadbalancers were provisioned", "ready", len(ready), "services", opts.Services, "err", err)
+	}
+
+	if opts.Cleanup {
+		start := time.Now()
+		err := cleanupLoadTest(ctx, kubeClient, opts)
+		if err != nil {
+			return result, err
+		}
+		result.CleanupDuration = time.Since(start)
+	}
+	return result, nil
+}
+
+// loadTestService returns the i-th synthetic Service, it does not select any Pod
+func loadTestService(i int) *v1.Service {
+	return &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: fmt.Sprintf("load-test-%04d", i)},
+		Spec: v1.ServiceSpec{
+			Type:     v1.ServiceTypeLoadBalancer,
+			Selector: map[string]string{"app": "cloud-provider-kind-load-test"},
+			Ports:    []v1.ServicePort{{Name: "http", Protocol: v1.ProtocolTCP, Port: 80}},
+		},
+	}
+}
+
+// cleanupLoadTest deletes the namespace of the load test and waits for the controller to
+// remove the loadbalancers of its Services
+func cleanupLoadTest(ctx context.Context, kubeClient kubernetes.Interface, opts LoadTestOptions) error {
+	err := kubeClient.CoreV1().Namespaces().Delete(ctx, opts.Namespace, metav1.DeleteOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("failed to delete namespace %s: %w", opts.Namespace, err)
+	}
+	err = wait.PollUntilContextTimeout(ctx, time.Second, opts.Timeout, true, func(ctx context.Context) (bool, error) {
+		containers, err := container.ListLabelValues(fmt.Sprintf("%s=%s", constants.NodeCCMLabelKey, opts.ClusterName), constants.LoadBalancerNameLabelKey)
+		if err != nil {
+			return false, nil
+		}
+		for _, v := range containers {
+			_, service := loadbalancer.ServiceFromLoadBalancerSimpleName(v)
+			if service != nil && service.Namespace == opts.Namespace {
+				return false, nil
+			}
+		}
+		return true, nil
+	})
+	if err != nil {
+		return fmt.Errorf("the loadbalancers of namespace %s were not removed: %w", opts.Namespace, err)
+	}
+	return nil
+}
@@ -0,0 +1,31 @@
+package controller
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLoadTestResultPercentile(t *testing.T) {
+	result := &LoadTestResult{}
+	for i := 1; i <= 10; i++ {
+		result.Latencies = append(result.Latencies, time.Duration(i)*time.Second)
+	}
+	tests := []struct {
+		p    float64
+		want time.Duration
+	}{
+		{p: 0, want: time.Second},
+		{p: 50, want: 5 * time.Second},
+		{p: 90, want: 9 * time.Second},
+		{p: 99, want: 10 * time.Second},
+		{p: 100, want: 10 * time.Second},
+	}
+	for _, tt := range tests {
+		if got := result.Percentile(tt.p); got != tt.want {
+			t.Errorf("Percentile(%g) = %s, want %s", tt.p, got, tt.want)
+		}
+	}
+	if got := (&LoadTestResult{}).Percentile(50); got != 0 {
+		t.Errorf("Percentile() without latencies = %s, want 0", got)
+	}
+}
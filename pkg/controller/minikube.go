@@ -0,0 +1,75 @@
+package controller
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+
+	"k8s.io/client-go/tools/clientcmd"
+
+	"sigs.k8s.io/cloud-provider-kind/pkg/container"
+)
+
+// minikubeKubeconfigPath is the admin kubeconfig written by kubeadm in the control plane
+// containers of minikube, with the certificates embedded
+const minikubeKubeconfigPath = "/etc/kubernetes/admin.conf"
+
+// minikubeAPIServerPort is the port of the apiserver in the minikube containers
+const minikubeAPIServerPort = "8443"
+
+// minikubeClusters returns the minikube clusters, with --minikube, and registers the
+// resolver of their node containers
+func minikubeClusters() ([]string, error) {
+	clusters, err := container.MinikubeClusters()
+	if err != nil {
+		return nil, err
+	}
+	for _, cluster := range clusters {
+		container.SetNodeResolver(cluster, container.MinikubeNodeResolver{})
+	}
+	return clusters, nil
+}
+
+// minikubeKubeConfig returns the kubeconfig of the minikube cluster read from its first
+// control plane, named after the profile, with the apiserver address published on the host
+// or, if internal, its address in the network of the profile
+func minikubeKubeConfig(cluster string, internal bool) (string, error) {
+	var stdout bytes.Buffer
+	if err := container.Exec(cluster, []string{"cat", minikubeKubeconfigPath}, nil, &stdout, nil); err != nil {
+		return "", fmt.Errorf("failed to read the kubeconfig of the minikube cluster %s: %w", cluster, err)
+	}
+	kconfig, err := clientcmd.Load(stdout.Bytes())
+	if err != nil {
+		return "", err
+	}
+	var address string
+	if internal {
+		ipv4, ipv6, err := container.IPs(cluster)
+		if err != nil {
+			return "", err
+		}
+		ip := ipv4
+		if ip == "" {
+			ip = ipv6
+		}
+		address = "https://" + net.JoinHostPort(ip, minikubeAPIServerPort)
+	} else {
+		ports, err := container.PortMappings(cluster)
+		if err != nil {
+			return "", err
+		}
+		port, ok := ports[minikubeAPIServerPort+"/tcp"]
+		if !ok {
+			return "", fmt.Errorf("the apiserver of the minikube cluster %s is not published on the host", cluster)
+		}
+		address = "https://" + net.JoinHostPort("127.0.0.1", port)
+	}
+	for _, c := range kconfig.Clusters {
+		c.Server = address
+	}
+	out, err := clientcmd.Write(*kconfig)
+	if err != nil {
+		return "", err
+	}
+	return string(out), nil
+}
@@ -0,0 +1,63 @@
+package controller
+
+import (
+	"testing"
+
+	"k8s.io/client-go/tools/clientcmd"
+
+	"sigs.k8s.io/cloud-provider-kind/pkg/container"
+)
+
+func TestMinikubeKubeConfig(t *testing.T) {
+	fake := container.NewFake()
+	defer container.SetRuntime(fake)()
+	fake.AddContainer(&container.FakeContainer{
+		Name:    "minikube",
+		Labels:  map[string]string{"created_by.minikube.sigs.k8s.io": "true", "name.minikube.sigs.k8s.io": "minikube"},
+		Running: true,
+		IPv4:    "192.168.49.2",
+		Ports:   map[string]string{"8443/tcp": "32771"},
+		Files: map[string]string{minikubeKubeconfigPath: `apiVersion: v1
+kind: Config
+clusters:
+- name: mk
+  cluster: {server: "https://control-plane.minikube.internal:8443"}
+contexts:
+- name: kubernetes-admin@mk
+  context: {cluster: mk, user: kubernetes-admin}
+current-context: kubernetes-admin@mk
+users:
+- name: kubernetes-admin
+  user: {token: secret}
+`},
+	})
+	// removes the resolver registered by the test
+	defer container.SetNodeResolver("minikube", container.LabelNodeResolver{})()
+
+	clusters, err := minikubeClusters()
+	if err != nil || len(clusters) != 1 || clusters[0] != "minikube" {
+		t.Fatalf("minikubeClusters() = %v, %v, want minikube", clusters, err)
+	}
+
+	c := &Controller{tools: map[string]kubeConfigFunc{"minikube": minikubeKubeConfig}}
+	tests := []struct {
+		internal bool
+		want     string
+	}{
+		{internal: false, want: "https://127.0.0.1:32771"},
+		{internal: true, want: "https://192.168.49.2:8443"},
+	}
+	for _, tt := range tests {
+		data, err := c.kubeConfig("minikube", tt.internal)
+		if err != nil {
+			t.Fatalf("kubeConfig() error = %v", err)
+		}
+		kconfig, err := clientcmd.Load([]byte(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := kconfig.Clusters["mk"].Server; got != tt.want {
+			t.Errorf("kubeConfig(internal=%v) server = %q, want %q", tt.internal, got, tt.want)
+		}
+	}
+}
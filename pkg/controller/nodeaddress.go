@@ -0,0 +1,73 @@
+package controller
+
+import (
+	"context"
+	"slices"
+
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/util/retry"
+	"k8s.io/klog/v2"
+	netutils "k8s.io/utils/net"
+
+	"sigs.k8s.io/cloud-provider-kind/pkg/container"
+)
+
+// refreshNodeAddresses replaces the IPs of the node with the current IPs of its container. A
+// restarted kind node may get new IPs and the node controller only updates the node
+// addresses periodically, meanwhile the loadbalancers send the traffic to the old IPs. The
+// node update reconfigures the loadbalancers with the node sync.
+func (c *serviceController) refreshNodeAddresses(ctx context.Context, name string) error {
+	ipv4, ipv6, err := container.IPs(name)
+	if err != nil {
+		return err
+	}
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		node, err := c.kubeClient.CoreV1().Nodes().Get(ctx, name, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			// not registered yet
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		addresses, changed := replaceNodeIPs(node.Status.Addresses, ipv4, ipv6)
+		if !changed {
+			return nil
+		}
+		klog.InfoS("Node container IPs changed, updating the node addresses", "cluster", c.clusterName, "node", name, "ipv4", ipv4, "ipv6", ipv6)
+		node.Status.Addresses = addresses
+		_, err = c.kubeClient.CoreV1().Nodes().UpdateStatus(ctx, node, metav1.UpdateOptions{})
+		return err
+	})
+}
+
+// replaceNodeIPs returns the node addresses with the InternalIPs replaced by the container IPs
+// of the same family, and the ExternalIPs that were the old InternalIPs, published with
+// --node-external-ip. The addresses of the families without container IP are kept.
+func replaceNodeIPs(addresses []v1.NodeAddress, ipv4, ipv6 string) ([]v1.NodeAddress, bool) {
+	replaced := map[string]string{}
+	for _, addr := range addresses {
+		if addr.Type != v1.NodeInternalIP {
+			continue
+		}
+		switch {
+		case netutils.IsIPv4String(addr.Address) && ipv4 != "" && addr.Address != ipv4:
+			replaced[addr.Address] = ipv4
+		case netutils.IsIPv6String(addr.Address) && ipv6 != "" && addr.Address != ipv6:
+			replaced[addr.Address] = ipv6
+		}
+	}
+	if len(replaced) == 0 {
+		return addresses, false
+	}
+	result := slices.Clone(addresses)
+	for i, addr := range result {
+		ip, ok := replaced[addr.Address]
+		if ok && (addr.Type == v1.NodeInternalIP || addr.Type == v1.NodeExternalIP) {
+			result[i].Address = ip
+		}
+	}
+	return result, true
+}
@@ -0,0 +1,116 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+
+	"sigs.k8s.io/cloud-provider-kind/pkg/container"
+)
+
+func Test_replaceNodeIPs(t *testing.T) {
+	addresses := []v1.NodeAddress{
+		{Type: v1.NodeHostName, Address: "kind-worker"},
+		{Type: v1.NodeInternalIP, Address: "172.18.0.3"},
+		{Type: v1.NodeInternalIP, Address: "fc00:f853:ccd:e793::3"},
+		{Type: v1.NodeExternalIP, Address: "172.18.0.3"},
+	}
+	tests := []struct {
+		name        string
+		ipv4        string
+		ipv6        string
+		want        []v1.NodeAddress
+		wantChanged bool
+	}{
+		{name: "unchanged", ipv4: "172.18.0.3", ipv6: "fc00:f853:ccd:e793::3", want: addresses},
+		{
+			name: "new IPv4",
+			ipv4: "172.18.0.7",
+			ipv6: "fc00:f853:ccd:e793::3",
+			want: []v1.NodeAddress{
+				{Type: v1.NodeHostName, Address: "kind-worker"},
+				{Type: v1.NodeInternalIP, Address: "172.18.0.7"},
+				{Type: v1.NodeInternalIP, Address: "fc00:f853:ccd:e793::3"},
+				{Type: v1.NodeExternalIP, Address: "172.18.0.7"},
+			},
+			wantChanged: true,
+		},
+		{
+			name: "new IPv6 without IPv4",
+			ipv6: "fc00:f853:ccd:e793::7",
+			want: []v1.NodeAddress{
+				{Type: v1.NodeHostName, Address: "kind-worker"},
+				{Type: v1.NodeInternalIP, Address: "172.18.0.3"},
+				{Type: v1.NodeInternalIP, Address: "fc00:f853:ccd:e793::7"},
+				{Type: v1.NodeExternalIP, Address: "172.18.0.3"},
+			},
+			wantChanged: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, changed := replaceNodeIPs(addresses, tt.ipv4, tt.ipv6)
+			if changed != tt.wantChanged {
+				t.Errorf("replaceNodeIPs() changed = %v, want %v", changed, tt.wantChanged)
+			}
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("replaceNodeIPs() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestRefreshNodeAddresses(t *testing.T) {
+	fake := container.NewFake()
+	defer container.SetRuntime(fake)()
+	fake.AddContainer(&container.FakeContainer{Name: "kind-worker", IPv4: "172.18.0.7", Running: true})
+
+	var updated *v1.Node
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/api/v1/nodes/kind-worker":
+			json.NewEncoder(w).Encode(&v1.Node{ // nolint:errcheck
+				ObjectMeta: metav1.ObjectMeta{Name: "kind-worker"},
+				Status:     v1.NodeStatus{Addresses: []v1.NodeAddress{{Type: v1.NodeInternalIP, Address: "172.18.0.3"}}},
+			})
+		case r.Method == http.MethodPut && r.URL.Path == "/api/v1/nodes/kind-worker/status":
+			updated = &v1.Node{}
+			json.NewDecoder(r.Body).Decode(updated) // nolint:errcheck
+			json.NewEncoder(w).Encode(updated)      // nolint:errcheck
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+	kubeClient, err := kubernetes.NewForConfig(&rest.Config{Host: server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &serviceController{clusterName: "kind", kubeClient: kubeClient}
+
+	if err := c.refreshNodeAddresses(context.Background(), "kind-worker"); err != nil {
+		t.Fatalf("refreshNodeAddresses() error = %v", err)
+	}
+	if updated == nil {
+		t.Fatalf("the node status was not updated")
+	}
+	want := []v1.NodeAddress{{Type: v1.NodeInternalIP, Address: "172.18.0.7"}}
+	if diff := cmp.Diff(want, updated.Status.Addresses); diff != "" {
+		t.Errorf("node addresses mismatch (-want +got):\n%s", diff)
+	}
+
+	// the nodes not registered are skipped
+	fake.AddContainer(&container.FakeContainer{Name: "kind-worker2", IPv4: "172.18.0.8", Running: true})
+	if err := c.refreshNodeAddresses(context.Background(), "kind-worker2"); err != nil {
+		t.Errorf("refreshNodeAddresses() of a missing node error = %v", err)
+	}
+}
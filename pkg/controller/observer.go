@@ -0,0 +1,165 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/component-base/metrics"
+	"k8s.io/component-base/metrics/legacyregistry"
+	"k8s.io/klog/v2"
+
+	"sigs.k8s.io/cloud-provider-kind/pkg/loadbalancer"
+)
+
+const observeInterval = 10 * time.Second
+
+var observedLoadBalancers = metrics.NewGaugeVec(
+	&metrics.GaugeOpts{
+		Namespace:      "cloud_provider_kind",
+		Subsystem:      "observer",
+		Name:           "loadbalancers",
+		Help:           "Number of loadbalancers by the action that would be taken to reconcile them.",
+		StabilityLevel: metrics.ALPHA,
+	},
+	[]string{"cluster", "action"},
+)
+
+func init() {
+	legacyregistry.MustRegister(observedLoadBalancers)
+}
+
+// observer watches the Services and Nodes of the clusters and reports what would be
+// done to reconcile the loadbalancers without mutating anything, neither the cluster
+// nor the containers.
+type observer struct {
+	mu    sync.Mutex
+	plans map[string][]loadbalancer.Plan // key is the cluster name
+}
+
+func newObserver() *observer {
+	return &observer{
+		plans: map[string][]loadbalancer.Plan{},
+	}
+}
+
+// start observes the cluster until the returned cancel function is called
+func (o *observer) start(ctx context.Context, clusterName string, kubeClient kubernetes.Interface) (*ccm, error) {
+	sharedInformers := informers.NewSharedInformerFactory(kubeClient, 60*time.Second)
+	serviceLister := sharedInformers.Core().V1().Services().Lister()
+	nodeLister := sharedInformers.Core().V1().Nodes().Lister()
+
+	ctx, cancel := context.WithCancel(ctx)
+	sharedInformers.Start(ctx.Done())
+	go func() {
+		if !cache.WaitForCacheSync(ctx.Done(), sharedInformers.Core().V1().Services().Informer().HasSynced, sharedInformers.Core().V1().Nodes().Informer().HasSynced) {
+			return
+		}
+		wait.UntilWithContext(ctx, func(ctx context.Context) {
+			services, err := serviceLister.List(labels.Everything())
+			if err != nil {
+				klog.ErrorS(err, "Observer failed to list services", "cluster", clusterName)
+				return
+			}
+			nodes, err := nodeLister.List(labels.Everything())
+			if err != nil {
+				klog.ErrorS(err, "Observer failed to list nodes", "cluster", clusterName)
+				return
+			}
+			o.observe(clusterName, services, nodes)
+		}, observeInterval)
+	}()
+
+	cancelFn := func() {
+		cancel()
+		o.mu.Lock()
+		defer o.mu.Unlock()
+		delete(o.plans, clusterName)
+		for _, action := range []loadbalancer.Action{loadbalancer.ActionNone, loadbalancer.ActionCreate, loadbalancer.ActionUpdate, loadbalancer.ActionDelete} {
+			observedLoadBalancers.Delete(map[string]string{"cluster": clusterName, "action": string(action)})
+		}
+	}
+	return &ccm{factory: sharedInformers, cancelFn: cancelFn}, nil
+}
+
+// observe computes the plans for the cluster and logs the ones that changed, the Services
+// and nodes are selected like the service controller does
+func (o *observer) observe(clusterName string, services []*v1.Service, nodes []*v1.Node) {
+	lbServices := []*v1.Service{}
+	// the loadbalancers of the Services out of scope are not orphans, like in cleanupOrphans
+	ownedServices := []*v1.Service{}
+	for _, service := range services {
+		if wantsLoadBalancer(service) && inScope(service) {
+			lbServices = append(lbServices, service)
+		}
+		if wantsLoadBalancer(service) || hasFinalizer(service) {
+			ownedServices = append(ownedServices, service)
+		}
+	}
+	lbNodes := loadbalancer.EligibleNodes(nodes)
+
+	plans := []loadbalancer.Plan{}
+	for _, service := range lbServices {
+		plans = append(plans, loadbalancer.PlanLoadBalancer(clusterName, service, lbServices, lbNodes))
+	}
+	orphans, err := loadbalancer.PlanOrphans(clusterName, ownedServices)
+	if err != nil {
+		klog.ErrorS(err, "Observer failed to list loadbalancers", "cluster", clusterName)
+	}
+	plans = append(plans, orphans...)
+	sort.Slice(plans, func(i, j int) bool {
+		if plans[i].Namespace != plans[j].Namespace {
+			return plans[i].Namespace < plans[j].Namespace
+		}
+		return plans[i].Service < plans[j].Service
+	})
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	previous := map[string]loadbalancer.Action{}
+	for _, plan := range o.plans[clusterName] {
+		previous[plan.LoadBalancer] = plan.Action
+	}
+	counts := map[loadbalancer.Action]int{
+		loadbalancer.ActionNone:   0,
+		loadbalancer.ActionCreate: 0,
+		loadbalancer.ActionUpdate: 0,
+		loadbalancer.ActionDelete: 0,
+	}
+	for _, plan := range plans {
+		counts[plan.Action]++
+		if plan.Action == loadbalancer.ActionNone || previous[plan.LoadBalancer] == plan.Action {
+			continue
+		}
+		klog.InfoS("Observe-only: loadbalancer would be reconciled", "cluster", clusterName, "service", klog.KRef(plan.Namespace, plan.Service),
+			"container", plan.LoadBalancer, "action", plan.Action, "reason", plan.Reason)
+	}
+	for action, count := range counts {
+		observedLoadBalancers.WithLabelValues(clusterName, string(action)).Set(float64(count))
+	}
+	o.plans[clusterName] = plans
+}
+
+// ServeHTTP returns the plans of all the clusters in JSON
+func (o *observer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	o.mu.Lock()
+	plans := []loadbalancer.Plan{}
+	for _, p := range o.plans {
+		plans = append(plans, p...)
+	}
+	o.mu.Unlock()
+	sort.SliceStable(plans, func(i, j int) bool {
+		return plans[i].Cluster < plans[j].Cluster
+	})
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(plans) // nolint:errcheck
+}
@@ -0,0 +1,46 @@
+package controller
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/ptr"
+
+	"sigs.k8s.io/cloud-provider-kind/pkg/config"
+	"sigs.k8s.io/cloud-provider-kind/pkg/container"
+	"sigs.k8s.io/cloud-provider-kind/pkg/loadbalancer"
+)
+
+func Test_observe(t *testing.T) {
+	defer func(namespaces string) {
+		config.DefaultConfig.WatchNamespaces = namespaces
+	}(config.DefaultConfig.WatchNamespaces)
+	config.DefaultConfig.WatchNamespaces = "dev"
+	defer container.SetRuntime(container.NewFake())()
+
+	makeService := func(namespace, name string, class *string) *v1.Service {
+		return &v1.Service{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+			Spec:       v1.ServiceSpec{Type: v1.ServiceTypeLoadBalancer, LoadBalancerClass: class},
+		}
+	}
+	services := []*v1.Service{
+		makeService("dev", "web", nil),
+		makeService("dev", "other-class", ptr.To("example.com/lb")),
+		makeService("default", "out-of-scope", nil),
+	}
+	o := newObserver()
+	o.observe("kind", services, []*v1.Node{makeNode("a", nil)})
+
+	// only the Services reconciled by the service controller are planned
+	got := []string{}
+	for _, plan := range o.plans["kind"] {
+		got = append(got, plan.Namespace+"/"+plan.Service+"="+string(plan.Action))
+	}
+	want := []string{"dev/web=" + string(loadbalancer.ActionCreate)}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("observe() plans mismatch (-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,62 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/kind/pkg/log"
+
+	"sigs.k8s.io/cloud-provider-kind/pkg/loadbalancer"
+)
+
+// Render writes the envoy config of the loadbalancers of the kind clusters without
+// modifying the clusters or the containers. An empty clusterName renders all the
+// clusters and an empty namespace all the namespaces.
+func Render(ctx context.Context, w io.Writer, logger log.Logger, clusterName string, namespace string) error {
+	c := New(logger)
+	if err := c.setupExternalCluster(); err != nil {
+		return err
+	}
+	clusters := []string{clusterName}
+	if clusterName == "" {
+		var err error
+		clusters, err = c.clusterNames()
+		if err != nil {
+			return fmt.Errorf("failed to list clusters: %w", err)
+		}
+		sort.Strings(clusters)
+	}
+	for _, cluster := range clusters {
+		kubeClient, _, err := c.getKubeClient(ctx, cluster)
+		if err != nil {
+			return fmt.Errorf("failed to create kubeClient for cluster %s: %w", cluster, err)
+		}
+		serviceList, err := kubeClient.CoreV1().Services(namespace).List(ctx, metav1.ListOptions{})
+		if err != nil {
+			return fmt.Errorf("failed to list the Services of cluster %s: %w", cluster, err)
+		}
+		nodeList, err := kubeClient.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
+		if err != nil {
+			return fmt.Errorf("failed to list the nodes of cluster %s: %w", cluster, err)
+		}
+		services := []*v1.Service{}
+		for i := range serviceList.Items {
+			if wantsLoadBalancer(&serviceList.Items[i]) {
+				services = append(services, &serviceList.Items[i])
+			}
+		}
+		nodes := []*v1.Node{}
+		for i := range nodeList.Items {
+			nodes = append(nodes, &nodeList.Items[i])
+		}
+		err = loadbalancer.Render(w, cluster, services, loadbalancer.EligibleNodes(nodes))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+package controller
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/validation"
+
+	"sigs.k8s.io/cloud-provider-kind/pkg/config"
+)
+
+// ParseWatchNamespaces parses a comma separated list of namespaces, empty for all of them
+func ParseWatchNamespaces(value string) ([]string, error) {
+	namespaces := []string{}
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		if errs := validation.IsDNS1123Label(item); len(errs) > 0 {
+			return nil, fmt.Errorf("invalid namespace %q: %s", item, strings.Join(errs, ", "))
+		}
+		namespaces = append(namespaces, item)
+	}
+	return namespaces, nil
+}
+
+// ParseServiceSelector parses a label selector of the Services, ex. env=dev,!skip-lb, empty
+// selects all of them
+func ParseServiceSelector(value string) (labels.Selector, error) {
+	return labels.Parse(value)
+}
+
+// inScope returns true if the Service is in one of the namespaces of --watch-namespace and
+// matches the --service-selector. The loadbalancers of the Services out of scope are not
+// created nor deleted, they can be managed by another controller instance, but the ones of
+// the Services that leave the scope while they are managed by this controller are deleted.
+func inScope(service *v1.Service) bool {
+	if config.DefaultConfig.WatchNamespaces != "" {
+		namespaces, err := ParseWatchNamespaces(config.DefaultConfig.WatchNamespaces)
+		if err == nil && len(namespaces) > 0 && !slices.Contains(namespaces, service.Namespace) {
+			return false
+		}
+	}
+	if config.DefaultConfig.ServiceSelector != "" {
+		selector, err := ParseServiceSelector(config.DefaultConfig.ServiceSelector)
+		if err == nil && !selector.Matches(labels.Set(service.Labels)) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,95 @@
+package controller
+
+import (
+	"context"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+
+	"sigs.k8s.io/cloud-provider-kind/pkg/config"
+)
+
+func Test_ParseWatchNamespaces(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    int
+		wantErr bool
+	}{
+		{value: "", want: 0},
+		{value: "dev", want: 1},
+		{value: "dev, ci", want: 2},
+		{value: "Dev", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := ParseWatchNamespaces(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseWatchNamespaces() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if len(got) != tt.want {
+				t.Errorf("ParseWatchNamespaces() = %v, want %d namespaces", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_inScope(t *testing.T) {
+	defer func(namespaces, selector string) {
+		config.DefaultConfig.WatchNamespaces = namespaces
+		config.DefaultConfig.ServiceSelector = selector
+	}(config.DefaultConfig.WatchNamespaces, config.DefaultConfig.ServiceSelector)
+
+	makeService := func(namespace string, labels map[string]string) *v1.Service {
+		return &v1.Service{ObjectMeta: metav1.ObjectMeta{Name: "lb", Namespace: namespace, Labels: labels}}
+	}
+	tests := []struct {
+		name       string
+		namespaces string
+		selector   string
+		service    *v1.Service
+		want       bool
+	}{
+		{name: "no filters", service: makeService("default", nil), want: true},
+		{name: "watched namespace", namespaces: "dev,ci", service: makeService("ci", nil), want: true},
+		{name: "other namespace", namespaces: "dev,ci", service: makeService("default", nil), want: false},
+		{name: "matching selector", selector: "env=dev", service: makeService("default", map[string]string{"env": "dev"}), want: true},
+		{name: "not matching selector", selector: "env=dev", service: makeService("default", map[string]string{"env": "prod"}), want: false},
+		{name: "namespace and selector", namespaces: "dev", selector: "!skip-lb", service: makeService("dev", map[string]string{"skip-lb": ""}), want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config.DefaultConfig.WatchNamespaces = tt.namespaces
+			config.DefaultConfig.ServiceSelector = tt.selector
+			if got := inScope(tt.service); got != tt.want {
+				t.Errorf("inScope() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	// the Services leaving the scope are reconciled to delete their loadbalancers
+	config.DefaultConfig.WatchNamespaces = ""
+	config.DefaultConfig.ServiceSelector = "env=dev"
+	lb := makeService("default", map[string]string{"env": "dev"})
+	lb.Spec.Type = v1.ServiceTypeLoadBalancer
+	unlabeled := lb.DeepCopy()
+	unlabeled.Labels = nil
+	if !needsUpdate(lb, unlabeled) {
+		t.Errorf("needsUpdate() = false for a Service leaving the selector")
+	}
+
+	// the Services out of scope that were not managed by the controller are left alone
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	if err := indexer.Add(unlabeled); err != nil {
+		t.Fatal(err)
+	}
+	c := &serviceController{
+		serviceLister: corelisters.NewServiceLister(indexer),
+		lastSynced:    map[string]*v1.Service{},
+	}
+	if err := c.syncService(context.Background(), "default/lb"); err != nil {
+		t.Errorf("syncService() of a Service out of scope error = %v", err)
+	}
+}
@@ -0,0 +1,880 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"reflect"
+	"slices"
+	"sync"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/wait"
+	coreinformers "k8s.io/client-go/informers/core/v1"
+	discoveryinformers "k8s.io/client-go/informers/discovery/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	v1core "k8s.io/client-go/kubernetes/typed/core/v1"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/workqueue"
+	cloudprovider "k8s.io/cloud-provider"
+	servicehelper "k8s.io/cloud-provider/service/helpers"
+	"k8s.io/klog/v2"
+
+	"sigs.k8s.io/cloud-provider-kind/pkg/config"
+	"sigs.k8s.io/cloud-provider-kind/pkg/constants"
+	"sigs.k8s.io/cloud-provider-kind/pkg/loadbalancer"
+)
+
+const (
+	// minRetryDelay and maxRetryDelay bound the exponential backoff of the Services
+	// that failed to reconcile, typically because of container runtime errors
+	minRetryDelay = 5 * time.Second
+	maxRetryDelay = 300 * time.Second
+)
+
+// serviceController reconciles the Services of type LoadBalancer of a cluster.
+// Services are processed from a rate limited workqueue, the queue guarantees that
+// a Service is never processed by more than one worker at the same time and the
+// failures are retried with exponential backoff.
+type serviceController struct {
+	clusterName  string
+	kubeClient   kubernetes.Interface
+	lbController cloudprovider.LoadBalancer
+
+	serviceLister  corelisters.ServiceLister
+	servicesSynced cache.InformerSynced
+	nodeLister     corelisters.NodeLister
+	nodesSynced    cache.InformerSynced
+	// the Services are synced again when their endpoints change, the loadbalancers are
+	// not ready without endpoints
+	endpointSlicesSynced cache.InformerSynced
+
+	queue            workqueue.RateLimitingInterface
+	recorder         record.EventRecorder
+	eventBroadcaster record.EventBroadcaster
+
+	// node events received during the nodeSyncWindow are batched so the
+	// loadbalancers are reconfigured once instead of once per event
+	nodeSyncWindow time.Duration
+	nodeSyncMu     sync.Mutex
+	nodeSyncTimer  *time.Timer
+
+	// lastSynced keeps the last Service object successfully reconciled, used to
+	// delete the loadbalancer when the Service object is gone
+	mu         sync.Mutex
+	lastSynced map[string]*v1.Service
+	// lastErrors keeps the last error of the Services failing to sync, reported by
+	// the admin API
+	lastErrors map[string]SyncError
+	// histories keeps the last syncs of each Service, reported by the admin API
+	histories map[string]*syncHistory
+	// initialPending are the Services existing on startup that were not synced yet, the
+	// extra workers of the initial sync stop once it is empty
+	initialPending sets.Set[string]
+}
+
+// SyncError is the last error syncing a Service
+type SyncError struct {
+	Message string    `json:"message"`
+	Time    time.Time `json:"time"`
+}
+
+func newServiceController(
+	clusterName string,
+	kubeClient kubernetes.Interface,
+	lbController cloudprovider.LoadBalancer,
+	serviceInformer coreinformers.ServiceInformer,
+	nodeInformer coreinformers.NodeInformer,
+	endpointSliceInformer discoveryinformers.EndpointSliceInformer,
+	nodeSyncWindow time.Duration,
+) (*serviceController, error) {
+	broadcaster := record.NewBroadcaster()
+	recorder := broadcaster.NewRecorder(scheme.Scheme, v1.EventSource{Component: "cloud-provider-kind"})
+
+	c := &serviceController{
+		clusterName:          clusterName,
+		kubeClient:           kubeClient,
+		lbController:         lbController,
+		serviceLister:        serviceInformer.Lister(),
+		servicesSynced:       serviceInformer.Informer().HasSynced,
+		nodeLister:           nodeInformer.Lister(),
+		nodesSynced:          nodeInformer.Informer().HasSynced,
+		endpointSlicesSynced: endpointSliceInformer.Informer().HasSynced,
+		queue:                workqueue.NewRateLimitingQueueWithConfig(workqueue.NewItemExponentialFailureRateLimiter(minRetryDelay, maxRetryDelay), workqueue.RateLimitingQueueConfig{Name: "service"}),
+		recorder:             recorder,
+		eventBroadcaster:     broadcaster,
+		nodeSyncWindow:       nodeSyncWindow,
+		lastSynced:           map[string]*v1.Service{},
+		lastErrors:           map[string]SyncError{},
+		histories:            map[string]*syncHistory{},
+	}
+
+	_, err := serviceInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: c.enqueueService,
+		UpdateFunc: func(old, cur interface{}) {
+			oldSvc, ok1 := old.(*v1.Service)
+			curSvc, ok2 := cur.(*v1.Service)
+			if ok1 && ok2 && !needsUpdate(oldSvc, curSvc) {
+				return
+			}
+			c.enqueueService(cur)
+		},
+		DeleteFunc: c.enqueueService,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = nodeInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) { c.nodeSync() },
+		UpdateFunc: func(old, cur interface{}) {
+			oldNode, ok1 := old.(*v1.Node)
+			curNode, ok2 := cur.(*v1.Node)
+			if ok1 && ok2 && !nodeChanged(oldNode, curNode) {
+				return
+			}
+			c.nodeSync()
+		},
+		DeleteFunc: func(obj interface{}) { c.nodeSync() },
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = endpointSliceInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: c.enqueueEndpointSliceService,
+		UpdateFunc: func(old, cur interface{}) {
+			oldSlice, ok1 := old.(*discoveryv1.EndpointSlice)
+			curSlice, ok2 := cur.(*discoveryv1.EndpointSlice)
+			if ok1 && ok2 && equality.Semantic.DeepEqual(oldSlice.Endpoints, curSlice.Endpoints) {
+				return
+			}
+			c.enqueueEndpointSliceService(cur)
+		},
+		DeleteFunc: c.enqueueEndpointSliceService,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Run processes the Services with the number of workers until the context is cancelled
+func (c *serviceController) Run(ctx context.Context, workers int) {
+	defer utilruntime.HandleCrash()
+	defer c.queue.ShutDown()
+
+	c.eventBroadcaster.StartStructuredLogging(0)
+	c.eventBroadcaster.StartRecordingToSink(&v1core.EventSinkImpl{Interface: c.kubeClient.CoreV1().Events("")})
+	defer c.eventBroadcaster.Shutdown()
+
+	logger := klog.FromContext(ctx).WithValues("cluster", c.clusterName)
+	logger.Info("Starting service controller", "workers", workers)
+	defer logger.Info("Shutting down service controller")
+
+	if !cache.WaitForNamedCacheSync("service", ctx.Done(), c.servicesSynced, c.nodesSynced, c.endpointSlicesSynced) {
+		return
+	}
+	c.cleanupOrphans(ctx)
+	go c.superviseLoadBalancers(ctx)
+	if interval := config.DefaultConfig.LoadBalancerStatusInterval; interval > 0 {
+		go c.reportLoadBalancerStatuses(ctx, interval)
+	}
+
+	// the syncs use a context that outlives the controller for the shutdown timeout, so
+	// the ones in flight on exit finish and publish the Service status instead of failing
+	syncCtx, cancelSyncs := context.WithCancel(context.WithoutCancel(ctx))
+	defer cancelSyncs()
+	var wg sync.WaitGroup
+	// stops stop the workers, the number of workers follows the concurrency reloaded from
+	// the config file, there is always at least one
+	var stops []context.CancelFunc
+	resize := func(n int) {
+		if n < 1 {
+			return
+		}
+		for len(stops) < n {
+			workerCtx, stop := context.WithCancel(ctx)
+			stops = append(stops, stop)
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				wait.UntilWithContext(workerCtx, func(ctx context.Context) { c.worker(ctx, syncCtx) }, time.Second)
+			}()
+		}
+		// the stopped workers finish the sync in flight, or the next one if they are waiting
+		for len(stops) > n {
+			stops[len(stops)-1]()
+			stops = stops[:len(stops)-1]
+		}
+	}
+	resize(workers)
+	// the cold start creates or adopts the loadbalancers of all the existing Services, they
+	// are synced with more workers that stop once each one was synced
+	if extra := config.DefaultConfig.InitialSyncConcurrency - workers; extra > 0 && c.startInitialSync() > 0 {
+		logger.Info("Starting initial sync", "services", c.initialPendingCount(), "workers", workers+extra)
+		for i := 0; i < extra; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				c.initialSyncWorker(ctx, syncCtx)
+			}()
+		}
+	}
+	for ctx.Err() == nil {
+		changed := config.Changed()
+		select {
+		case <-ctx.Done():
+		case <-changed:
+			if n := config.Get().Concurrency; n != len(stops) {
+				logger.Info("Changing the number of workers", "workers", n)
+				resize(n)
+			}
+		}
+	}
+	c.queue.ShutDown()
+	c.waitForSyncs(logger, &wg, config.DefaultConfig.ShutdownTimeout)
+
+	c.nodeSyncMu.Lock()
+	defer c.nodeSyncMu.Unlock()
+	if c.nodeSyncTimer != nil {
+		c.nodeSyncTimer.Stop()
+		c.nodeSyncTimer = nil
+	}
+}
+
+// cleanupOrphans deletes the loadbalancers of the Services that are gone, the finalizer
+// prevents this unless it was removed by someone else while the controller was not running
+func (c *serviceController) cleanupOrphans(ctx context.Context) {
+	services, err := c.serviceLister.List(labels.Everything())
+	if err != nil {
+		utilruntime.HandleError(fmt.Errorf("error listing services on cluster %s: %w", c.clusterName, err))
+		return
+	}
+	lbServices := []*v1.Service{}
+	for _, service := range services {
+		if wantsLoadBalancer(service) || hasFinalizer(service) {
+			lbServices = append(lbServices, service)
+		}
+	}
+	orphans, err := loadbalancer.PlanOrphans(c.clusterName, lbServices)
+	if err != nil {
+		utilruntime.HandleError(fmt.Errorf("error listing loadbalancers on cluster %s: %w", c.clusterName, err))
+		return
+	}
+	for _, orphan := range orphans {
+		service := &v1.Service{ObjectMeta: metav1.ObjectMeta{Namespace: orphan.Namespace, Name: orphan.Service}}
+		klog.FromContext(ctx).Info("Deleting orphan loadbalancer", "cluster", c.clusterName, "service", klog.KObj(service), "container", orphan.LoadBalancer)
+		if err := c.lbController.EnsureLoadBalancerDeleted(ctx, c.clusterName, service); err != nil {
+			utilruntime.HandleError(fmt.Errorf("error deleting orphan loadbalancer %s on cluster %s: %w", orphan.LoadBalancer, c.clusterName, err))
+		}
+	}
+}
+
+// worker processes Services with syncCtx until ctx is cancelled
+func (c *serviceController) worker(ctx context.Context, syncCtx context.Context) {
+	for ctx.Err() == nil && c.processNextWorkItem(syncCtx) {
+	}
+}
+
+// initialSyncWorker processes Services with syncCtx until the Services existing on startup
+// were synced once or ctx is cancelled
+func (c *serviceController) initialSyncWorker(ctx context.Context, syncCtx context.Context) {
+	for ctx.Err() == nil && c.initialPendingCount() > 0 && c.processNextWorkItem(syncCtx) {
+	}
+}
+
+// startInitialSync records the Services of type LoadBalancer existing on startup and
+// returns their number
+func (c *serviceController) startInitialSync() int {
+	services, err := c.serviceLister.List(labels.Everything())
+	if err != nil {
+		utilruntime.HandleError(fmt.Errorf("error listing services on cluster %s: %w", c.clusterName, err))
+		return 0
+	}
+	pending := sets.New[string]()
+	for _, service := range services {
+		if !wantsLoadBalancer(service) || !inScope(service) {
+			continue
+		}
+		if key, err := cache.MetaNamespaceKeyFunc(service); err == nil {
+			pending.Insert(key)
+		}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.initialPending = pending
+	return pending.Len()
+}
+
+// initialPendingCount returns the number of Services existing on startup not synced yet
+func (c *serviceController) initialPendingCount() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.initialPending.Len()
+}
+
+// initialSynced records the first sync of a Service existing on startup, successful or not
+func (c *serviceController) initialSynced(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.initialPending.Has(key) {
+		c.initialPending.Delete(key)
+		if c.initialPending.Len() == 0 {
+			klog.InfoS("Finished initial sync", "cluster", c.clusterName)
+		}
+	}
+}
+
+// waitForSyncs waits for the workers to finish the Services syncs in flight, up to the
+// timeout
+func (c *serviceController) waitForSyncs(logger klog.Logger, wg *sync.WaitGroup, timeout time.Duration) {
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(timeout):
+		logger.Info("Timed out waiting for the Service syncs in flight", "timeout", timeout)
+	}
+}
+
+func (c *serviceController) processNextWorkItem(ctx context.Context) bool {
+	key, quit := c.queue.Get()
+	if quit {
+		return false
+	}
+	defer c.queue.Done(key)
+
+	err := c.syncService(ctx, key.(string))
+	c.setLastError(key.(string), err)
+	c.initialSynced(key.(string))
+	if err == nil {
+		c.queue.Forget(key)
+		return true
+	}
+
+	var provisioningErr *loadbalancer.ProvisioningError
+	if errors.As(err, &provisioningErr) && provisioningErr.RequeueAfter > 0 {
+		// waiting for the loadbalancer, not a failure to back off from
+		klog.V(2).InfoS("Waiting for the loadbalancer", "cluster", c.clusterName, "service", key, "reason", provisioningErr.Reason, "err", err)
+		c.queue.AddAfter(key, provisioningErr.RequeueAfter)
+		return true
+	}
+	utilruntime.HandleError(fmt.Errorf("error processing service %v on cluster %s (will retry): %w", key, c.clusterName, err))
+	c.queue.AddRateLimited(key)
+	return true
+}
+
+// setLastError keeps the error of the last sync of the Service, nil clears it
+func (c *serviceController) setLastError(key string, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err == nil {
+		delete(c.lastErrors, key)
+		return
+	}
+	c.lastErrors[key] = SyncError{Message: err.Error(), Time: time.Now()}
+}
+
+// lastError returns the error of the last sync of the Service, if it failed
+func (c *serviceController) lastError(key string) (SyncError, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	err, ok := c.lastErrors[key]
+	return err, ok
+}
+
+// loadBalancerServices returns the Services of type LoadBalancer using the loadbalancer
+// container, several Services share it with the shared IP annotation
+func (c *serviceController) loadBalancerServices(ctx context.Context, name string) ([]*v1.Service, error) {
+	services, err := c.serviceLister.List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+	matched := []*v1.Service{}
+	for _, service := range services {
+		if wantsLoadBalancer(service) && inScope(service) && c.lbController.GetLoadBalancerName(ctx, c.clusterName, service) == name {
+			matched = append(matched, service)
+		}
+	}
+	return matched, nil
+}
+
+func (c *serviceController) enqueueService(obj interface{}) {
+	key, err := cache.DeletionHandlingMetaNamespaceKeyFunc(obj)
+	if err != nil {
+		utilruntime.HandleError(fmt.Errorf("couldn't get key for object %#v: %w", obj, err))
+		return
+	}
+	c.queue.Add(key)
+}
+
+// enqueueEndpointSliceService enqueues the Service of type LoadBalancer owning the
+// EndpointSlice, so the loadbalancers waiting for endpoints are synced once they have them
+func (c *serviceController) enqueueEndpointSliceService(obj interface{}) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	slice, ok := obj.(*discoveryv1.EndpointSlice)
+	if !ok {
+		utilruntime.HandleError(fmt.Errorf("unexpected object %#v", obj))
+		return
+	}
+	name := slice.Labels[discoveryv1.LabelServiceName]
+	if name == "" {
+		return
+	}
+	service, err := c.serviceLister.Services(slice.Namespace).Get(name)
+	if err != nil {
+		return
+	}
+	if wantsLoadBalancer(service) && inScope(service) {
+		c.enqueueService(service)
+	}
+}
+
+// nodeSync schedules the resync of all the loadbalancers at the end of the node sync
+// window, the node events received in the meantime are coalesced in the same resync.
+func (c *serviceController) nodeSync() {
+	if c.nodeSyncWindow <= 0 {
+		c.enqueueAllServices()
+		return
+	}
+	c.nodeSyncMu.Lock()
+	defer c.nodeSyncMu.Unlock()
+	if c.nodeSyncTimer != nil {
+		// already scheduled
+		return
+	}
+	klog.V(4).InfoS("Node changed, resyncing loadbalancers", "cluster", c.clusterName, "window", c.nodeSyncWindow)
+	c.nodeSyncTimer = time.AfterFunc(c.nodeSyncWindow, func() {
+		c.nodeSyncMu.Lock()
+		c.nodeSyncTimer = nil
+		c.nodeSyncMu.Unlock()
+		c.enqueueAllServices()
+	})
+}
+
+// enqueueAllServices enqueues the Services of type LoadBalancer, used when the set
+// of nodes changes and the loadbalancers backends have to be updated
+func (c *serviceController) enqueueAllServices() {
+	services, err := c.serviceLister.List(labels.Everything())
+	if err != nil {
+		utilruntime.HandleError(fmt.Errorf("error listing services on cluster %s: %w", c.clusterName, err))
+		return
+	}
+	for _, service := range services {
+		if wantsLoadBalancer(service) && inScope(service) {
+			c.enqueueService(service)
+		}
+	}
+}
+
+// enqueueQuotaExceeded enqueues the Services pending because of --max-load-balancers after
+// a loadbalancer is deleted, instead of waiting for their retry backoff
+func (c *serviceController) enqueueQuotaExceeded() {
+	if config.DefaultConfig.MaxLoadBalancers <= 0 {
+		return
+	}
+	services, err := c.serviceLister.List(labels.Everything())
+	if err != nil {
+		utilruntime.HandleError(fmt.Errorf("error listing services on cluster %s: %w", c.clusterName, err))
+		return
+	}
+	for _, service := range services {
+		condition := meta.FindStatusCondition(service.Status.Conditions, constants.LoadBalancerReadyConditionType)
+		if condition != nil && condition.Reason == loadbalancer.ReasonQuotaExceeded && wantsLoadBalancer(service) && inScope(service) {
+			c.enqueueService(service)
+		}
+	}
+}
+
+// syncService reconciles the loadbalancer of the Service with the key
+func (c *serviceController) syncService(ctx context.Context, key string) error {
+	namespace, name, err := cache.SplitMetaNamespaceKey(key)
+	if err != nil {
+		return err
+	}
+
+	// the loadbalancer controller logs with the same fields through the context
+	logger := klog.FromContext(ctx).WithValues("cluster", c.clusterName, "service", klog.KRef(namespace, name))
+	ctx = klog.NewContext(ctx, logger)
+	startTime := time.Now()
+	defer func() {
+		logger.V(4).Info("Finished syncing service", "duration", time.Since(startTime))
+	}()
+
+	service, err := c.serviceLister.Services(namespace).Get(name)
+	if apierrors.IsNotFound(err) {
+		// the Service is gone, the finalizer guarantees the loadbalancer was already
+		// deleted unless it was removed by someone else, clean up anyway
+		c.mu.Lock()
+		cached, ok := c.lastSynced[key]
+		delete(c.lastSynced, key)
+		c.mu.Unlock()
+		c.forgetSyncs(key)
+		if !ok {
+			return nil
+		}
+		return c.lbController.EnsureLoadBalancerDeleted(ctx, c.clusterName, cached)
+	}
+	if err != nil {
+		return err
+	}
+
+	if !inScope(service) {
+		// only the loadbalancers of the Services that left the scope are deleted, the
+		// others can be managed by another controller
+		c.mu.Lock()
+		_, managed := c.lastSynced[key]
+		c.mu.Unlock()
+		if !managed {
+			logger.V(4).Info("Ignoring Service out of the watched namespaces or selector")
+			return nil
+		}
+		return c.syncDelete(ctx, key, service)
+	}
+	if service.DeletionTimestamp != nil || !wantsLoadBalancer(service) {
+		return c.syncDelete(ctx, key, service)
+	}
+	err = c.ensureLoadBalancer(ctx, key, service)
+	c.recordSync(key, syncActionEnsure, service, startTime, err)
+	return err
+}
+
+// syncDelete deletes the loadbalancer of the Service, the deletion is only recorded in the
+// history of the Services that had a loadbalancer
+func (c *serviceController) syncDelete(ctx context.Context, key string, service *v1.Service) error {
+	startTime := time.Now()
+	provisioned := hasFinalizer(service) || len(service.Status.LoadBalancer.Ingress) > 0
+	err := c.deleteLoadBalancer(ctx, key, service)
+	if provisioned {
+		c.recordSync(key, syncActionDelete, service, startTime, err)
+	}
+	return err
+}
+
+func (c *serviceController) ensureLoadBalancer(ctx context.Context, key string, service *v1.Service) error {
+	// the finalizer must be added before creating any resource to guarantee
+	// the loadbalancer is cleaned up if the Service is deleted
+	service, err := c.addFinalizer(ctx, service)
+	if err != nil {
+		return fmt.Errorf("failed to add load balancer cleanup finalizer: %w", err)
+	}
+
+	nodes, err := c.nodeLister.List(labels.Everything())
+	if err != nil {
+		return err
+	}
+
+	// the Service joined or left a shared loadbalancer, release the previous one
+	c.mu.Lock()
+	previous, ok := c.lastSynced[key]
+	c.mu.Unlock()
+	if ok && c.lbController.GetLoadBalancerName(ctx, c.clusterName, previous) != c.lbController.GetLoadBalancerName(ctx, c.clusterName, service) {
+		err = c.lbController.EnsureLoadBalancerDeleted(ctx, c.clusterName, previous)
+		if err != nil {
+			return fmt.Errorf("failed to release the previous loadbalancer: %w", err)
+		}
+	}
+
+	c.recorder.Event(service, v1.EventTypeNormal, "EnsuringLoadBalancer", "Ensuring load balancer")
+	status, err := c.lbController.EnsureLoadBalancer(ctx, c.clusterName, service, loadbalancer.EligibleNodes(nodes))
+	var familyErr *loadbalancer.IPFamilyError
+	if errors.As(err, &familyErr) {
+		c.recorder.Event(service, v1.EventTypeWarning, "IPFamilyNotSupported", familyErr.Error())
+		if updated, perr := c.patchIPFamiliesCondition(service, familyErr); perr != nil {
+			klog.FromContext(ctx).Error(perr, "Failed to update the IP families condition")
+		} else {
+			service = updated
+		}
+		if _, perr := c.patchReadyCondition(service, err); perr != nil {
+			klog.FromContext(ctx).Error(perr, "Failed to update the loadbalancer ready condition")
+		}
+		return err
+	}
+	var provisioningErr *loadbalancer.ProvisioningError
+	if errors.As(err, &provisioningErr) && (provisioningErr.Reason == loadbalancer.ReasonPortConflict || provisioningErr.Reason == loadbalancer.ReasonQuotaExceeded || provisioningErr.Reason == loadbalancer.ReasonUnsupportedFeature) {
+		// the Service is not provisioned until the other Service releases the port,
+		// another loadbalancer is deleted to make room in the quota, or the unsupported
+		// features are removed from the Service
+		c.recorder.Event(service, v1.EventTypeWarning, provisioningErr.Reason, err.Error())
+	} else if err != nil {
+		c.recorder.Eventf(service, v1.EventTypeWarning, "SyncLoadBalancerFailed", "Error syncing load balancer: %v", err)
+	}
+	if err != nil {
+		if _, perr := c.patchReadyCondition(service, err); perr != nil {
+			klog.FromContext(ctx).Error(perr, "Failed to update the loadbalancer ready condition")
+		}
+		return err
+	}
+	c.recorder.Event(service, v1.EventTypeNormal, "EnsuredLoadBalancer", "Ensured load balancer")
+
+	service, err = c.patchIPFamiliesCondition(service, nil)
+	if err != nil {
+		return err
+	}
+	service, err = c.patchReadyCondition(service, nil)
+	if err != nil {
+		return err
+	}
+
+	// the resources are only informative for the tools, the loadbalancer is provisioned
+	// even if they can not be recorded
+	if updated, perr := c.patchResourceAnnotations(ctx, service); perr != nil {
+		klog.FromContext(ctx).Error(perr, "Failed to record the loadbalancer resources")
+	} else {
+		service = updated
+	}
+
+	c.mu.Lock()
+	c.lastSynced[key] = service
+	c.mu.Unlock()
+
+	return c.patchStatus(service, status)
+}
+
+func (c *serviceController) deleteLoadBalancer(ctx context.Context, key string, service *v1.Service) error {
+	// nothing to do if the loadbalancer was never created or already cleaned up
+	if !hasFinalizer(service) && len(service.Status.LoadBalancer.Ingress) == 0 {
+		return nil
+	}
+
+	c.recorder.Event(service, v1.EventTypeNormal, "DeletingLoadBalancer", "Deleting load balancer")
+	err := c.lbController.EnsureLoadBalancerDeleted(ctx, c.clusterName, service)
+	if err != nil {
+		c.recorder.Eventf(service, v1.EventTypeWarning, "DeleteLoadBalancerFailed", "Error deleting load balancer: %v", err)
+		return err
+	}
+	c.recorder.Event(service, v1.EventTypeNormal, "DeletedLoadBalancer", "Deleted load balancer")
+
+	c.mu.Lock()
+	delete(c.lastSynced, key)
+	c.mu.Unlock()
+	c.enqueueQuotaExceeded()
+
+	// a Service that is not being deleted but changed its type keeps existing, clear its
+	// status and the annotations of the resources that do not exist anymore
+	if service.DeletionTimestamp == nil {
+		updated, err := c.removeResourceAnnotations(service)
+		if err != nil {
+			return err
+		}
+		service = updated
+		if err := c.patchStatus(service, &v1.LoadBalancerStatus{}); err != nil {
+			return err
+		}
+	}
+	return c.removeFinalizer(ctx, service)
+}
+
+// hasFinalizer returns true if the Service has the cleanup finalizer, or the finalizer of
+// the upstream service controller used by previous versions
+func hasFinalizer(service *v1.Service) bool {
+	for _, f := range service.ObjectMeta.Finalizers {
+		if f == constants.LoadBalancerCleanupFinalizer || f == servicehelper.LoadBalancerCleanupFinalizer {
+			return true
+		}
+	}
+	return false
+}
+
+// withoutFinalizers returns the finalizers of the Service without the cleanup finalizers
+func withoutFinalizers(service *v1.Service) []string {
+	finalizers := []string{}
+	for _, f := range service.ObjectMeta.Finalizers {
+		if f != constants.LoadBalancerCleanupFinalizer && f != servicehelper.LoadBalancerCleanupFinalizer {
+			finalizers = append(finalizers, f)
+		}
+	}
+	return finalizers
+}
+
+// addFinalizer adds the cleanup finalizer, replacing the one used by previous versions
+func (c *serviceController) addFinalizer(ctx context.Context, service *v1.Service) (*v1.Service, error) {
+	if slices.Contains(service.ObjectMeta.Finalizers, constants.LoadBalancerCleanupFinalizer) && !servicehelper.HasLBFinalizer(service) {
+		return service, nil
+	}
+	updated := service.DeepCopy()
+	updated.ObjectMeta.Finalizers = append(withoutFinalizers(service), constants.LoadBalancerCleanupFinalizer)
+	klog.FromContext(ctx).V(2).Info("Adding finalizer", "finalizer", constants.LoadBalancerCleanupFinalizer)
+	return servicehelper.PatchService(c.kubeClient.CoreV1(), service, updated)
+}
+
+// removeFinalizer removes the cleanup finalizer, it must be called only once the
+// loadbalancer and all its resources are gone.
+func (c *serviceController) removeFinalizer(ctx context.Context, service *v1.Service) error {
+	if !hasFinalizer(service) {
+		return nil
+	}
+	updated := service.DeepCopy()
+	updated.ObjectMeta.Finalizers = withoutFinalizers(service)
+	klog.FromContext(ctx).V(2).Info("Removing finalizer", "finalizer", constants.LoadBalancerCleanupFinalizer)
+	_, err := servicehelper.PatchService(c.kubeClient.CoreV1(), service, updated)
+	return err
+}
+
+func (c *serviceController) patchStatus(service *v1.Service, status *v1.LoadBalancerStatus) error {
+	if status == nil {
+		status = &v1.LoadBalancerStatus{}
+	}
+	if loadBalancerStatusEqual(&service.Status.LoadBalancer, status) {
+		return nil
+	}
+	updated := service.DeepCopy()
+	updated.Status.LoadBalancer = *status
+	_, err := servicehelper.PatchService(c.kubeClient.CoreV1(), service, updated)
+	return err
+}
+
+// loadBalancerStatusEqual compares the whole status, the cloud-provider helper ignores the
+// ports and compares the IP modes by pointer
+func loadBalancerStatusEqual(l, r *v1.LoadBalancerStatus) bool {
+	return equality.Semantic.DeepEqual(l.Ingress, r.Ingress)
+}
+
+// patchResourceAnnotations records on the Service the container, IPs and host ports of
+// its loadbalancer, for the tools that need to reach the container directly
+func (c *serviceController) patchResourceAnnotations(ctx context.Context, service *v1.Service) (*v1.Service, error) {
+	name := c.lbController.GetLoadBalancerName(ctx, c.clusterName, service)
+	annotations, err := loadbalancer.ResourceAnnotations(name)
+	if err != nil {
+		return service, err
+	}
+	updated := service.DeepCopy()
+	updated.Annotations = userAnnotations(service)
+	if updated.Annotations == nil {
+		updated.Annotations = map[string]string{}
+	}
+	maps.Copy(updated.Annotations, annotations)
+	if maps.Equal(service.Annotations, updated.Annotations) {
+		return service, nil
+	}
+	return servicehelper.PatchService(c.kubeClient.CoreV1(), service, updated)
+}
+
+// removeResourceAnnotations removes the annotations recorded by patchResourceAnnotations
+func (c *serviceController) removeResourceAnnotations(service *v1.Service) (*v1.Service, error) {
+	annotations := userAnnotations(service)
+	if len(annotations) == len(service.Annotations) {
+		return service, nil
+	}
+	updated := service.DeepCopy()
+	updated.Annotations = annotations
+	return servicehelper.PatchService(c.kubeClient.CoreV1(), service, updated)
+}
+
+// patchIPFamiliesCondition reports in the Service status if its IP families are
+// supported, the condition is only added once a Service failed the check.
+func (c *serviceController) patchIPFamiliesCondition(service *v1.Service, familyErr *loadbalancer.IPFamilyError) (*v1.Service, error) {
+	if familyErr == nil && meta.FindStatusCondition(service.Status.Conditions, constants.IPFamiliesConditionType) == nil {
+		return service, nil
+	}
+	condition := metav1.Condition{
+		Type:               constants.IPFamiliesConditionType,
+		Status:             metav1.ConditionTrue,
+		ObservedGeneration: service.Generation,
+		Reason:             "IPFamiliesSupported",
+		Message:            "The IP families of the Service are supported by the loadbalancer network",
+	}
+	if familyErr != nil {
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = "IPFamilyNotSupported"
+		condition.Message = familyErr.Error()
+	}
+	updated := service.DeepCopy()
+	if !meta.SetStatusCondition(&updated.Status.Conditions, condition) {
+		return service, nil
+	}
+	return servicehelper.PatchService(c.kubeClient.CoreV1(), service, updated)
+}
+
+// patchReadyCondition reports in the Service status if the loadbalancer is provisioned or
+// the reason it failed.
+func (c *serviceController) patchReadyCondition(service *v1.Service, err error) (*v1.Service, error) {
+	updated := service.DeepCopy()
+	if !meta.SetStatusCondition(&updated.Status.Conditions, readyCondition(service, err)) {
+		return service, nil
+	}
+	return servicehelper.PatchService(c.kubeClient.CoreV1(), service, updated)
+}
+
+// readyCondition returns the LoadBalancerReady condition for the result of the provisioning
+func readyCondition(service *v1.Service, err error) metav1.Condition {
+	condition := metav1.Condition{
+		Type:               constants.LoadBalancerReadyConditionType,
+		Status:             metav1.ConditionTrue,
+		ObservedGeneration: service.Generation,
+		Reason:             "Provisioned",
+		Message:            "The loadbalancer is provisioned",
+	}
+	if err == nil {
+		return condition
+	}
+	condition.Status = metav1.ConditionFalse
+	condition.Reason = "SyncFailed"
+	condition.Message = err.Error()
+	var familyErr *loadbalancer.IPFamilyError
+	var provisioningErr *loadbalancer.ProvisioningError
+	if errors.As(err, &familyErr) {
+		condition.Reason = "IPFamilyNotSupported"
+	} else if errors.As(err, &provisioningErr) {
+		condition.Reason = provisioningErr.Reason
+	}
+	return condition
+}
+
+// wantsLoadBalancer returns true if the Service requires a loadbalancer from this provider
+func wantsLoadBalancer(service *v1.Service) bool {
+	// Services with a LoadBalancerClass are implemented by other controllers
+	return service.Spec.Type == v1.ServiceTypeLoadBalancer && service.Spec.LoadBalancerClass == nil
+}
+
+// needsUpdate returns true if the loadbalancer has to be reconciled after the Service update
+func needsUpdate(oldService, newService *v1.Service) bool {
+	if wantsLoadBalancer(oldService) != wantsLoadBalancer(newService) || inScope(oldService) != inScope(newService) {
+		return true
+	}
+	if !wantsLoadBalancer(newService) {
+		return false
+	}
+	return !reflect.DeepEqual(oldService.Spec, newService.Spec) ||
+		!maps.Equal(userAnnotations(oldService), userAnnotations(newService)) ||
+		!reflect.DeepEqual(oldService.DeletionTimestamp, newService.DeletionTimestamp) ||
+		oldService.UID != newService.UID
+}
+
+// userAnnotations returns the annotations of the Service without the ones recorded by the
+// controller, so recording the loadbalancer resources does not reconcile it again
+func userAnnotations(service *v1.Service) map[string]string {
+	annotations := maps.Clone(service.Annotations)
+	maps.DeleteFunc(annotations, func(key, _ string) bool {
+		return loadbalancer.IsResourceAnnotation(key)
+	})
+	return annotations
+}
+
+// nodeChanged returns true if the node update affects the loadbalancers backends
+func nodeChanged(oldNode, newNode *v1.Node) bool {
+	return !reflect.DeepEqual(oldNode.Status.Addresses, newNode.Status.Addresses) ||
+		!reflect.DeepEqual(oldNode.Labels, newNode.Labels) ||
+		!reflect.DeepEqual(oldNode.Spec.Taints, newNode.Spec.Taints) ||
+		oldNode.Spec.Unschedulable != newNode.Spec.Unschedulable ||
+		loadbalancer.NodeReady(oldNode) != loadbalancer.NodeReady(newNode)
+}
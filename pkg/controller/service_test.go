@@ -0,0 +1,303 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+	servicehelper "k8s.io/cloud-provider/service/helpers"
+	"k8s.io/klog/v2"
+	"k8s.io/utils/ptr"
+
+	"sigs.k8s.io/cloud-provider-kind/pkg/constants"
+	"sigs.k8s.io/cloud-provider-kind/pkg/loadbalancer"
+)
+
+func makeNode(name string, labels map[string]string, taints ...v1.Taint) *v1.Node {
+	return &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Labels: labels},
+		Spec:       v1.NodeSpec{Taints: taints},
+	}
+}
+
+func Test_nodeChanged(t *testing.T) {
+	ready := makeNode("a", nil)
+	ready.Status.Conditions = []v1.NodeCondition{{Type: v1.NodeReady, Status: v1.ConditionTrue}}
+	notReady := ready.DeepCopy()
+	notReady.Status.Conditions[0].Status = v1.ConditionFalse
+	unschedulable := ready.DeepCopy()
+	unschedulable.Spec.Unschedulable = true
+	heartbeat := ready.DeepCopy()
+	heartbeat.Status.Conditions[0].LastHeartbeatTime = metav1.Now()
+
+	if !nodeChanged(ready, notReady) {
+		t.Errorf("the Ready condition change must resync the loadbalancers")
+	}
+	if !nodeChanged(ready, unschedulable) {
+		t.Errorf("the unschedulable change must resync the loadbalancers")
+	}
+	if nodeChanged(ready, heartbeat) {
+		t.Errorf("the heartbeats must not resync the loadbalancers")
+	}
+}
+
+func Test_readyCondition(t *testing.T) {
+	service := &v1.Service{ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "ns", Generation: 3}}
+	tests := []struct {
+		name       string
+		err        error
+		wantStatus metav1.ConditionStatus
+		wantReason string
+	}{
+		{name: "provisioned", wantStatus: metav1.ConditionTrue, wantReason: "Provisioned"},
+		{name: "unknown error", err: fmt.Errorf("boom"), wantStatus: metav1.ConditionFalse, wantReason: "SyncFailed"},
+		{
+			name:       "provisioning error",
+			err:        errors.Join(&loadbalancer.ProvisioningError{Reason: loadbalancer.ReasonImagePullFailed, Err: fmt.Errorf("pull failed")}, fmt.Errorf("rollback failed")),
+			wantStatus: metav1.ConditionFalse,
+			wantReason: loadbalancer.ReasonImagePullFailed,
+		},
+		{
+			name:       "ip families",
+			err:        &loadbalancer.IPFamilyError{Network: "kind", Families: []v1.IPFamily{v1.IPv6Protocol}},
+			wantStatus: metav1.ConditionFalse,
+			wantReason: "IPFamilyNotSupported",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := readyCondition(service, tt.err)
+			if got.Type != constants.LoadBalancerReadyConditionType || got.Status != tt.wantStatus || got.Reason != tt.wantReason || got.ObservedGeneration != 3 {
+				t.Errorf("readyCondition() = %+v, want status %s reason %s", got, tt.wantStatus, tt.wantReason)
+			}
+		})
+	}
+}
+
+func Test_needsUpdate(t *testing.T) {
+	lb := &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "ns"},
+		Spec: v1.ServiceSpec{
+			Type:  v1.ServiceTypeLoadBalancer,
+			Ports: []v1.ServicePort{{Port: 80}},
+		},
+	}
+	withPort := lb.DeepCopy()
+	withPort.Spec.Ports = append(withPort.Spec.Ports, v1.ServicePort{Port: 443})
+	withStatus := lb.DeepCopy()
+	withStatus.Status.LoadBalancer.Ingress = []v1.LoadBalancerIngress{{IP: "192.168.8.5"}}
+	clusterIP := lb.DeepCopy()
+	clusterIP.Spec.Type = v1.ServiceTypeClusterIP
+	withClass := lb.DeepCopy()
+	withClass.Spec.LoadBalancerClass = ptr.To("other")
+	clusterIPWithPort := clusterIP.DeepCopy()
+	clusterIPWithPort.Spec.Ports = withPort.Spec.Ports
+	withResources := lb.DeepCopy()
+	withResources.Annotations = map[string]string{constants.LoadBalancerIPAnnotation: "172.18.0.10"}
+	withAnnotation := lb.DeepCopy()
+	withAnnotation.Annotations = map[string]string{constants.ClusterOptionsAnnotation: "foo"}
+
+	tests := []struct {
+		name     string
+		old, new *v1.Service
+		want     bool
+	}{
+		{name: "no changes", old: lb, new: lb.DeepCopy(), want: false},
+		{name: "ports changed", old: lb, new: withPort, want: true},
+		{name: "only status changed", old: lb, new: withStatus, want: false},
+		{name: "type changed", old: lb, new: clusterIP, want: true},
+		{name: "class added", old: lb, new: withClass, want: true},
+		{name: "not a loadbalancer", old: clusterIP, new: clusterIPWithPort, want: false},
+		{name: "resources recorded", old: lb, new: withResources, want: false},
+		{name: "annotation added", old: lb, new: withAnnotation, want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := needsUpdate(tt.old, tt.new); got != tt.want {
+				t.Errorf("needsUpdate() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_loadBalancerStatusEqual(t *testing.T) {
+	status := func(mode v1.LoadBalancerIPMode, portError *string) *v1.LoadBalancerStatus {
+		return &v1.LoadBalancerStatus{Ingress: []v1.LoadBalancerIngress{{
+			IP:     "172.18.0.5",
+			IPMode: ptr.To(mode),
+			Ports:  []v1.PortStatus{{Port: 80, Protocol: v1.ProtocolTCP, Error: portError}},
+		}}}
+	}
+	tests := []struct {
+		name string
+		l, r *v1.LoadBalancerStatus
+		want bool
+	}{
+		{name: "same status", l: status(v1.LoadBalancerIPModeProxy, nil), r: status(v1.LoadBalancerIPModeProxy, nil), want: true},
+		{name: "IP mode", l: status(v1.LoadBalancerIPModeVIP, nil), r: status(v1.LoadBalancerIPModeProxy, nil)},
+		{name: "port error", l: status(v1.LoadBalancerIPModeProxy, nil), r: status(v1.LoadBalancerIPModeProxy, ptr.To(loadbalancer.PortErrorNotListening))},
+		{name: "empty", l: &v1.LoadBalancerStatus{}, r: &v1.LoadBalancerStatus{Ingress: []v1.LoadBalancerIngress{}}, want: true},
+	}
+	for _, tt := range tests {
+		if got := loadBalancerStatusEqual(tt.l, tt.r); got != tt.want {
+			t.Errorf("%s: loadBalancerStatusEqual() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func Test_nodeSync(t *testing.T) {
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	for _, svc := range []*v1.Service{
+		{ObjectMeta: metav1.ObjectMeta{Name: "lb", Namespace: "ns"}, Spec: v1.ServiceSpec{Type: v1.ServiceTypeLoadBalancer}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "cluster-ip", Namespace: "ns"}, Spec: v1.ServiceSpec{Type: v1.ServiceTypeClusterIP}},
+	} {
+		if err := indexer.Add(svc); err != nil {
+			t.Fatal(err)
+		}
+	}
+	c := &serviceController{
+		serviceLister:  corelisters.NewServiceLister(indexer),
+		queue:          workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter()),
+		nodeSyncWindow: 100 * time.Millisecond,
+	}
+	defer c.queue.ShutDown()
+
+	for i := 0; i < 10; i++ {
+		c.nodeSync()
+	}
+	if c.queue.Len() != 0 {
+		t.Fatalf("expected services to be enqueued after the sync window, got %d", c.queue.Len())
+	}
+	err := wait.PollUntilContextTimeout(context.Background(), 10*time.Millisecond, 2*time.Second, true, func(ctx context.Context) (bool, error) {
+		return c.queue.Len() == 1, nil
+	})
+	if err != nil {
+		t.Fatalf("expected 1 service enqueued, got %d", c.queue.Len())
+	}
+	key, _ := c.queue.Get()
+	if key.(string) != "ns/lb" {
+		t.Errorf("unexpected key %v", key)
+	}
+}
+
+func Test_initialSync(t *testing.T) {
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	for _, svc := range []*v1.Service{
+		{ObjectMeta: metav1.ObjectMeta{Name: "a", Namespace: "ns"}, Spec: v1.ServiceSpec{Type: v1.ServiceTypeLoadBalancer}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "b", Namespace: "ns"}, Spec: v1.ServiceSpec{Type: v1.ServiceTypeLoadBalancer}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "cluster-ip", Namespace: "ns"}, Spec: v1.ServiceSpec{Type: v1.ServiceTypeClusterIP}},
+	} {
+		if err := indexer.Add(svc); err != nil {
+			t.Fatal(err)
+		}
+	}
+	c := &serviceController{serviceLister: corelisters.NewServiceLister(indexer)}
+
+	if n := c.startInitialSync(); n != 2 {
+		t.Fatalf("startInitialSync() = %d, want the 2 LoadBalancer Services", n)
+	}
+	c.initialSynced("ns/a")
+	c.initialSynced("ns/cluster-ip")
+	c.initialSynced("ns/a")
+	if n := c.initialPendingCount(); n != 1 {
+		t.Errorf("initialPendingCount() = %d, want 1", n)
+	}
+	c.initialSynced("ns/b")
+	if n := c.initialPendingCount(); n != 0 {
+		t.Errorf("initialPendingCount() = %d, want the initial sync finished", n)
+	}
+}
+
+func Test_enqueueEndpointSliceService(t *testing.T) {
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	for _, svc := range []*v1.Service{
+		{ObjectMeta: metav1.ObjectMeta{Name: "lb", Namespace: "ns"}, Spec: v1.ServiceSpec{Type: v1.ServiceTypeLoadBalancer}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "cluster-ip", Namespace: "ns"}, Spec: v1.ServiceSpec{Type: v1.ServiceTypeClusterIP}},
+	} {
+		if err := indexer.Add(svc); err != nil {
+			t.Fatal(err)
+		}
+	}
+	c := &serviceController{
+		serviceLister: corelisters.NewServiceLister(indexer),
+		queue:         workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter()),
+	}
+	defer c.queue.ShutDown()
+
+	slice := func(service string) *discoveryv1.EndpointSlice {
+		return &discoveryv1.EndpointSlice{ObjectMeta: metav1.ObjectMeta{Name: service + "-abcde", Namespace: "ns", Labels: map[string]string{discoveryv1.LabelServiceName: service}}}
+	}
+	c.enqueueEndpointSliceService(slice("cluster-ip"))
+	c.enqueueEndpointSliceService(slice("missing"))
+	c.enqueueEndpointSliceService(&discoveryv1.EndpointSlice{ObjectMeta: metav1.ObjectMeta{Name: "unowned", Namespace: "ns"}})
+	c.enqueueEndpointSliceService(cache.DeletedFinalStateUnknown{Key: "ns/lb-abcde", Obj: slice("lb")})
+	if c.queue.Len() != 1 {
+		t.Fatalf("enqueueEndpointSliceService() queued %d Services, want only the LoadBalancer one", c.queue.Len())
+	}
+	if key, _ := c.queue.Get(); key != "ns/lb" {
+		t.Errorf("enqueueEndpointSliceService() queued %v, want ns/lb", key)
+	}
+}
+
+func Test_finalizers(t *testing.T) {
+	tests := []struct {
+		name       string
+		finalizers []string
+		want       bool
+		wantOthers []string
+	}{
+		{name: "no finalizers", finalizers: nil, want: false, wantOthers: []string{}},
+		{name: "cleanup finalizer", finalizers: []string{constants.LoadBalancerCleanupFinalizer, "other"}, want: true, wantOthers: []string{"other"}},
+		{name: "legacy finalizer", finalizers: []string{"other", servicehelper.LoadBalancerCleanupFinalizer}, want: true, wantOthers: []string{"other"}},
+		{name: "both finalizers", finalizers: []string{servicehelper.LoadBalancerCleanupFinalizer, constants.LoadBalancerCleanupFinalizer}, want: true, wantOthers: []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service := &v1.Service{ObjectMeta: metav1.ObjectMeta{Name: "lb", Namespace: "ns", Finalizers: tt.finalizers}}
+			if got := hasFinalizer(service); got != tt.want {
+				t.Errorf("hasFinalizer() = %v, want %v", got, tt.want)
+			}
+			if got := withoutFinalizers(service); !reflect.DeepEqual(got, tt.wantOthers) {
+				t.Errorf("withoutFinalizers() = %v, want %v", got, tt.wantOthers)
+			}
+		})
+	}
+}
+
+func Test_workerShutdown(t *testing.T) {
+	c := &serviceController{
+		queue: workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter()),
+	}
+	defer c.queue.ShutDown()
+	c.queue.Add("ns/lb")
+
+	// a stopping controller does not start new syncs
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	c.worker(ctx, context.Background())
+	if c.queue.Len() != 1 {
+		t.Errorf("worker processed Services after the controller stopped, %d queued", c.queue.Len())
+	}
+
+	// the syncs in flight are only waited for up to the timeout
+	var wg sync.WaitGroup
+	wg.Add(1)
+	start := time.Now()
+	c.waitForSyncs(klog.Background(), &wg, 50*time.Millisecond)
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("waitForSyncs() did not time out, waited %v", elapsed)
+	}
+	wg.Done()
+	c.waitForSyncs(klog.Background(), &wg, time.Minute)
+}
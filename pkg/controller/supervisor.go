@@ -0,0 +1,96 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+
+	"sigs.k8s.io/cloud-provider-kind/pkg/constants"
+	"sigs.k8s.io/cloud-provider-kind/pkg/container"
+	"sigs.k8s.io/cloud-provider-kind/pkg/loadbalancer"
+)
+
+// superviseLoadBalancers watches the loadbalancer containers of the cluster and heals the
+// ones whose proxy crashed or was killed because it was out of memory, the Services are
+// synced again to apply the settings lost with the container and an Event reports it.
+// The proxy exits with code 0 when it is restarted to change its config, and the deleted
+// containers are not healed. The watch is restarted if the event stream fails.
+func (c *serviceController) superviseLoadBalancers(ctx context.Context) {
+	healer, ok := c.lbController.(loadbalancer.Healer)
+	if !ok {
+		return
+	}
+	logger := klog.FromContext(ctx).WithValues("cluster", c.clusterName)
+	for {
+		events, err := container.ContainerEvents(ctx, fmt.Sprintf("%s=%s", constants.NodeCCMLabelKey, c.clusterName))
+		if err != nil {
+			logger.Info("Unable to watch the loadbalancer containers, the crashed loadbalancers are not healed", "err", err)
+		} else {
+			// the oom event comes before the die event of the container killed
+			oomKilled := map[string]bool{}
+			for event := range events {
+				switch {
+				case event.Action == container.EventOOM:
+					oomKilled[event.Name] = true
+				case event.Exited():
+					oom := oomKilled[event.Name]
+					delete(oomKilled, event.Name)
+					if event.ExitCode != 0 || oom {
+						c.healLoadBalancer(ctx, healer, event.Name, event.ExitCode, oom)
+					}
+				}
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(10 * time.Second):
+		}
+	}
+}
+
+// healLoadBalancer heals the loadbalancer container and syncs the Services using it
+func (c *serviceController) healLoadBalancer(ctx context.Context, healer loadbalancer.Healer, name string, exitCode int, oom bool) {
+	logger := klog.FromContext(ctx).WithValues("cluster", c.clusterName, "container", name)
+	matched, err := c.loadBalancerServices(ctx, name)
+	if err != nil {
+		logger.Error(err, "Failed to list the Services of the crashed loadbalancer")
+		return
+	}
+	if len(matched) == 0 {
+		return
+	}
+	reason := fmt.Sprintf("exited with code %d", exitCode)
+	if oom {
+		reason = "was killed because it was out of memory"
+	}
+	// the Services sharing the loadbalancer are healed once
+	action, err := healer.HealLoadBalancer(ctx, c.clusterName, matched[0])
+	if err != nil {
+		logger.Error(err, "Failed to heal the crashed loadbalancer")
+	} else if action != loadbalancer.HealNone {
+		logger.Info("Healed the crashed loadbalancer", "action", action, "exitCode", exitCode, "oomKilled", oom)
+	}
+	for _, service := range matched {
+		if err != nil {
+			c.recorder.Eventf(service, v1.EventTypeWarning, "HealLoadBalancerFailed", "Load balancer proxy %s, error healing it: %v", reason, err)
+		} else if action != loadbalancer.HealNone {
+			c.recorder.Eventf(service, v1.EventTypeWarning, "LoadBalancer"+string(action), "Load balancer proxy %s, it was %s", reason, actionMessage(action))
+		}
+		if key, err := cache.MetaNamespaceKeyFunc(service); err == nil {
+			c.queue.Add(key)
+		}
+	}
+}
+
+// actionMessage returns the description of the heal action for the Events
+func actionMessage(action loadbalancer.HealAction) string {
+	if action == loadbalancer.HealRecreated {
+		return "deleted to be created again"
+	}
+	return "restarted"
+}
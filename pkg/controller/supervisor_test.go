@@ -0,0 +1,103 @@
+package controller
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/workqueue"
+	cloudprovider "k8s.io/cloud-provider"
+
+	"sigs.k8s.io/cloud-provider-kind/pkg/constants"
+	"sigs.k8s.io/cloud-provider-kind/pkg/container"
+	"sigs.k8s.io/cloud-provider-kind/pkg/loadbalancer"
+)
+
+// fakeHealer is a loadbalancer controller with a loadbalancer named after each Service
+type fakeHealer struct {
+	cloudprovider.LoadBalancer
+	mu        sync.Mutex
+	healed    []string
+	recreated []string
+}
+
+func (f *fakeHealer) GetLoadBalancerName(ctx context.Context, clusterName string, service *v1.Service) string {
+	return "lb-" + service.Name
+}
+
+func (f *fakeHealer) HealLoadBalancer(ctx context.Context, clusterName string, service *v1.Service) (loadbalancer.HealAction, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.healed = append(f.healed, service.Name)
+	return loadbalancer.HealRestarted, nil
+}
+
+func (f *fakeHealer) RecreateLoadBalancer(ctx context.Context, clusterName string, service *v1.Service) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.recreated = append(f.recreated, service.Name)
+	return nil
+}
+
+func TestSuperviseLoadBalancers(t *testing.T) {
+	fake := container.NewFake()
+	defer container.SetRuntime(fake)()
+	fake.AddContainer(&container.FakeContainer{Name: "lb-web", Labels: map[string]string{constants.NodeCCMLabelKey: "kind"}, Running: true})
+
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	for _, svc := range []*v1.Service{
+		{ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "ns"}, Spec: v1.ServiceSpec{Type: v1.ServiceTypeLoadBalancer}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "other", Namespace: "ns"}, Spec: v1.ServiceSpec{Type: v1.ServiceTypeLoadBalancer}},
+	} {
+		if err := indexer.Add(svc); err != nil {
+			t.Fatal(err)
+		}
+	}
+	healer := &fakeHealer{}
+	recorder := record.NewFakeRecorder(10)
+	c := &serviceController{
+		clusterName:   "kind",
+		lbController:  healer,
+		serviceLister: corelisters.NewServiceLister(indexer),
+		queue:         workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter()),
+		recorder:      recorder,
+	}
+	defer c.queue.ShutDown()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go c.superviseLoadBalancers(ctx)
+
+	// the watch may not be started yet, crash the proxy until it is healed
+	deadline := time.After(5 * time.Second)
+	for {
+		fake.Crash("lb-web", 1, true) // nolint:errcheck
+		var event string
+		select {
+		case event = <-recorder.Events:
+		case <-time.After(50 * time.Millisecond):
+			continue
+		case <-deadline:
+			t.Fatalf("the crashed loadbalancer was not healed")
+		}
+		if !strings.Contains(event, "LoadBalancerRestarted") || !strings.Contains(event, "out of memory") {
+			t.Errorf("unexpected event %q", event)
+		}
+		break
+	}
+	healer.mu.Lock()
+	if len(healer.healed) == 0 || healer.healed[0] != "web" {
+		t.Errorf("healed Services %v, want web", healer.healed)
+	}
+	healer.mu.Unlock()
+	key, _ := c.queue.Get()
+	if key.(string) != "ns/web" {
+		t.Errorf("unexpected key %v", key)
+	}
+}
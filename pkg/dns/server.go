@@ -0,0 +1,238 @@
+// Package dns implements a minimal authoritative DNS server that resolves the
+// names of the loadbalancers to their allocated addresses.
+package dns
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+
+	"k8s.io/klog/v2"
+)
+
+const (
+	typeA    = 1
+	typeAAAA = 28
+	classIN  = 1
+
+	rcodeSuccess  = 0
+	rcodeFormErr  = 1
+	rcodeNXDomain = 3
+	rcodeRefused  = 5
+
+	headerLen = 12
+	// ttl of the records in seconds, loadbalancers are short lived so avoid caching them for long
+	ttl = 30
+)
+
+// Server answers A and AAAA queries for the names of a domain
+type Server struct {
+	domain string
+
+	mu      sync.RWMutex
+	records map[string][]net.IP // key is the fully qualified name in lowercase without the trailing dot
+
+	conn net.PacketConn
+}
+
+// NewServer returns a DNS server authoritative for the domain
+func NewServer(domain string) *Server {
+	return &Server{
+		domain:  normalize(domain),
+		records: map[string][]net.IP{},
+	}
+}
+
+// Domain returns the domain the server is authoritative for
+func (s *Server) Domain() string {
+	return s.domain
+}
+
+// SetRecord sets the addresses for the name, replacing the existing ones
+func (s *Server) SetRecord(name string, ips []net.IP) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.records[normalize(name)] = ips
+}
+
+// DeleteRecord removes the name from the server
+func (s *Server) DeleteRecord(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.records, normalize(name))
+}
+
+// setFamily replaces the addresses of one family of the name, keeping the other one
+func (s *Server) setFamily(name string, ipv6 bool, ips []net.IP) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	name = normalize(name)
+	result := []net.IP{}
+	for _, ip := range s.records[name] {
+		if (ip.To4() == nil) != ipv6 {
+			result = append(result, ip)
+		}
+	}
+	for _, ip := range ips {
+		if (ip.To4() == nil) == ipv6 {
+			result = append(result, ip)
+		}
+	}
+	if len(result) == 0 {
+		delete(s.records, name)
+		return
+	}
+	s.records[name] = result
+}
+
+// Records returns a copy of the names served and their addresses
+func (s *Server) Records() map[string][]net.IP {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	records := make(map[string][]net.IP, len(s.records))
+	for k, v := range s.records {
+		records[k] = append([]net.IP{}, v...)
+	}
+	return records
+}
+
+// ListenAndServe serves DNS over UDP on the address until Close is called
+func (s *Server) ListenAndServe(address string) error {
+	conn, err := net.ListenPacket("udp", address)
+	if err != nil {
+		return err
+	}
+	s.conn = conn
+	klog.Infof("DNS server for domain %s listening on %s", s.domain, conn.LocalAddr())
+	buf := make([]byte, 512)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			klog.Infof("DNS server error reading request: %v", err)
+			continue
+		}
+		resp := s.handle(buf[:n])
+		if resp == nil {
+			continue
+		}
+		if _, err := conn.WriteTo(resp, addr); err != nil {
+			klog.Infof("DNS server error writing response to %s: %v", addr, err)
+		}
+	}
+}
+
+// Close stops the server
+func (s *Server) Close() error {
+	if s.conn != nil {
+		return s.conn.Close()
+	}
+	return nil
+}
+
+// handle processes a DNS query and returns the response, it returns nil if the
+// message is not a valid query and must be dropped.
+func (s *Server) handle(req []byte) []byte {
+	if len(req) < headerLen {
+		return nil
+	}
+	// ignore responses
+	if req[2]&0x80 != 0 {
+		return nil
+	}
+	qdcount := binary.BigEndian.Uint16(req[4:6])
+	if qdcount != 1 {
+		return response(req, headerLen, rcodeFormErr, nil)
+	}
+	name, offset, ok := parseName(req, headerLen)
+	if !ok || len(req) < offset+4 {
+		return response(req, headerLen, rcodeFormErr, nil)
+	}
+	qtype := binary.BigEndian.Uint16(req[offset : offset+2])
+	qclass := binary.BigEndian.Uint16(req[offset+2 : offset+4])
+	questionEnd := offset + 4
+
+	if name != s.domain && !strings.HasSuffix(name, "."+s.domain) {
+		return response(req, questionEnd, rcodeRefused, nil)
+	}
+
+	s.mu.RLock()
+	ips, found := s.records[name]
+	s.mu.RUnlock()
+	if !found {
+		return response(req, questionEnd, rcodeNXDomain, nil)
+	}
+
+	answers := []net.IP{}
+	if qclass == classIN {
+		for _, ip := range ips {
+			if ip.To4() != nil && qtype == typeA {
+				answers = append(answers, ip.To4())
+			} else if ip.To4() == nil && qtype == typeAAAA {
+				answers = append(answers, ip.To16())
+			}
+		}
+	}
+	// the name exists, return the empty answer if there are no records of the requested type
+	return response(req, questionEnd, rcodeSuccess, answers)
+}
+
+// response builds the response to the request copying the question section
+func response(req []byte, questionEnd int, rcode int, answers []net.IP) []byte {
+	resp := make([]byte, questionEnd, questionEnd+len(answers)*28)
+	copy(resp, req[:questionEnd])
+	// QR, opcode copied, AA and preserve RD
+	resp[2] = 0x80 | (req[2] & 0x78) | 0x04 | (req[2] & 0x01)
+	resp[3] = byte(rcode & 0x0f)
+	if questionEnd == headerLen {
+		binary.BigEndian.PutUint16(resp[4:6], 0)
+	}
+	binary.BigEndian.PutUint16(resp[6:8], uint16(len(answers)))
+	binary.BigEndian.PutUint16(resp[8:10], 0)
+	binary.BigEndian.PutUint16(resp[10:12], 0)
+	for _, ip := range answers {
+		rtype := uint16(typeAAAA)
+		if len(ip) == net.IPv4len {
+			rtype = typeA
+		}
+		// pointer to the name in the question
+		resp = append(resp, 0xc0, headerLen)
+		resp = binary.BigEndian.AppendUint16(resp, rtype)
+		resp = binary.BigEndian.AppendUint16(resp, classIN)
+		resp = binary.BigEndian.AppendUint32(resp, ttl)
+		resp = binary.BigEndian.AppendUint16(resp, uint16(len(ip)))
+		resp = append(resp, ip...)
+	}
+	return resp
+}
+
+// parseName parses an uncompressed name starting at offset and returns it
+// normalized and the offset after the name.
+func parseName(msg []byte, offset int) (string, int, bool) {
+	labels := []string{}
+	for {
+		if offset >= len(msg) {
+			return "", 0, false
+		}
+		l := int(msg[offset])
+		offset++
+		if l == 0 {
+			break
+		}
+		// compression is not expected in questions
+		if l&0xc0 != 0 || offset+l > len(msg) {
+			return "", 0, false
+		}
+		labels = append(labels, string(msg[offset:offset+l]))
+		offset += l
+	}
+	return normalize(strings.Join(labels, ".")), offset, true
+}
+
+func normalize(name string) string {
+	return strings.TrimSuffix(strings.ToLower(name), ".")
+}
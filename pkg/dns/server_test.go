@@ -0,0 +1,100 @@
+package dns
+
+import (
+	"encoding/binary"
+	"net"
+	"strings"
+	"testing"
+)
+
+func makeQuery(name string, qtype uint16) []byte {
+	msg := []byte{0x12, 0x34, 0x01, 0x00, 0, 1, 0, 0, 0, 0, 0, 0}
+	for _, label := range strings.Split(name, ".") {
+		msg = append(msg, byte(len(label)))
+		msg = append(msg, label...)
+	}
+	msg = append(msg, 0)
+	msg = binary.BigEndian.AppendUint16(msg, qtype)
+	msg = binary.BigEndian.AppendUint16(msg, classIN)
+	return msg
+}
+
+func TestServerHandle(t *testing.T) {
+	s := NewServer("kind.local.")
+	s.SetRecord("svc.ns.kind.local", []net.IP{net.ParseIP("192.168.8.5"), net.ParseIP("fc00:f853:ccd:e793::5")})
+
+	tests := []struct {
+		name       string
+		query      string
+		qtype      uint16
+		wantRcode  byte
+		wantAnswer []net.IP
+	}{
+		{
+			name:       "A record",
+			query:      "svc.ns.kind.local",
+			qtype:      typeA,
+			wantRcode:  rcodeSuccess,
+			wantAnswer: []net.IP{net.ParseIP("192.168.8.5").To4()},
+		},
+		{
+			name:       "AAAA record case insensitive",
+			query:      "SVC.ns.Kind.local",
+			qtype:      typeAAAA,
+			wantRcode:  rcodeSuccess,
+			wantAnswer: []net.IP{net.ParseIP("fc00:f853:ccd:e793::5")},
+		},
+		{
+			name:      "unknown name",
+			query:     "other.ns.kind.local",
+			qtype:     typeA,
+			wantRcode: rcodeNXDomain,
+		},
+		{
+			name:      "other domain",
+			query:     "www.example.com",
+			qtype:     typeA,
+			wantRcode: rcodeRefused,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := makeQuery(tt.query, tt.qtype)
+			resp := s.handle(req)
+			if len(resp) < headerLen {
+				t.Fatalf("invalid response %v", resp)
+			}
+			if resp[0] != 0x12 || resp[1] != 0x34 {
+				t.Errorf("response id does not match the request")
+			}
+			if resp[3]&0x0f != tt.wantRcode {
+				t.Errorf("expected rcode %d, got %d", tt.wantRcode, resp[3]&0x0f)
+			}
+			ancount := int(binary.BigEndian.Uint16(resp[6:8]))
+			if ancount != len(tt.wantAnswer) {
+				t.Fatalf("expected %d answers, got %d", len(tt.wantAnswer), ancount)
+			}
+			offset := len(req)
+			for _, want := range tt.wantAnswer {
+				rdlength := int(binary.BigEndian.Uint16(resp[offset+10 : offset+12]))
+				got := net.IP(resp[offset+12 : offset+12+rdlength])
+				if !got.Equal(want) {
+					t.Errorf("expected answer %s, got %s", want, got)
+				}
+				offset += 12 + rdlength
+			}
+		})
+	}
+}
+
+func TestServerHandleInvalid(t *testing.T) {
+	s := NewServer("kind.local")
+	if resp := s.handle([]byte{0x00, 0x01}); resp != nil {
+		t.Errorf("expected short message to be dropped")
+	}
+	req := makeQuery("svc.kind.local", typeA)
+	req[2] |= 0x80
+	if resp := s.handle(req); resp != nil {
+		t.Errorf("expected responses to be dropped")
+	}
+}
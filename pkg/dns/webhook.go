@@ -0,0 +1,162 @@
+package dns
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+
+	"k8s.io/klog/v2"
+)
+
+// webhookMediaType is the media type of the external-dns webhook provider protocol
+const webhookMediaType = "application/external.dns.webhook+json;version=1"
+
+const (
+	recordTypeA    = "A"
+	recordTypeAAAA = "AAAA"
+)
+
+// endpoint is the external-dns representation of a DNS record
+type endpoint struct {
+	DNSName          string            `json:"dnsName,omitempty"`
+	Targets          []string          `json:"targets,omitempty"`
+	RecordType       string            `json:"recordType,omitempty"`
+	SetIdentifier    string            `json:"setIdentifier,omitempty"`
+	RecordTTL        int64             `json:"recordTTL,omitempty"`
+	Labels           map[string]string `json:"labels,omitempty"`
+	ProviderSpecific json.RawMessage   `json:"providerSpecific,omitempty"`
+}
+
+// changes is the set of records external-dns wants to modify
+type changes struct {
+	Create    []*endpoint `json:"Create"`
+	UpdateOld []*endpoint `json:"UpdateOld"`
+	UpdateNew []*endpoint `json:"UpdateNew"`
+	Delete    []*endpoint `json:"Delete"`
+}
+
+// domainFilter tells external-dns the domains managed by the provider
+type domainFilter struct {
+	Include []string `json:"include,omitempty"`
+}
+
+// Webhook implements the external-dns webhook provider protocol so external-dns can
+// program the records of the embedded DNS server. Only A and AAAA records are supported.
+// https://kubernetes-sigs.github.io/external-dns/latest/docs/tutorials/webhook-provider/
+type Webhook struct {
+	server *Server
+	mux    *http.ServeMux
+}
+
+// NewWebhook returns an external-dns webhook provider for the server records
+func NewWebhook(server *Server) *Webhook {
+	w := &Webhook{server: server, mux: http.NewServeMux()}
+	w.mux.HandleFunc("GET /{$}", w.negotiate)
+	w.mux.HandleFunc("GET /records", w.getRecords)
+	w.mux.HandleFunc("POST /records", w.applyChanges)
+	w.mux.HandleFunc("POST /adjustendpoints", w.adjustEndpoints)
+	w.mux.HandleFunc("GET /healthz", func(rw http.ResponseWriter, r *http.Request) {
+		rw.Write([]byte("ok")) // nolint:errcheck
+	})
+	return w
+}
+
+func (w *Webhook) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	w.mux.ServeHTTP(rw, r)
+}
+
+func (w *Webhook) negotiate(rw http.ResponseWriter, r *http.Request) {
+	writeJSON(rw, http.StatusOK, domainFilter{Include: []string{w.server.Domain()}})
+}
+
+func (w *Webhook) getRecords(rw http.ResponseWriter, r *http.Request) {
+	endpoints := []*endpoint{}
+	for name, ips := range w.server.Records() {
+		for _, recordType := range []string{recordTypeA, recordTypeAAAA} {
+			targets := []string{}
+			for _, ip := range ips {
+				if (ip.To4() != nil) == (recordType == recordTypeA) {
+					targets = append(targets, ip.String())
+				}
+			}
+			if len(targets) > 0 {
+				endpoints = append(endpoints, &endpoint{DNSName: name, Targets: targets, RecordType: recordType, RecordTTL: ttl})
+			}
+		}
+	}
+	sort.Slice(endpoints, func(i, j int) bool {
+		if endpoints[i].DNSName != endpoints[j].DNSName {
+			return endpoints[i].DNSName < endpoints[j].DNSName
+		}
+		return endpoints[i].RecordType < endpoints[j].RecordType
+	})
+	writeJSON(rw, http.StatusOK, endpoints)
+}
+
+func (w *Webhook) applyChanges(rw http.ResponseWriter, r *http.Request) {
+	c := changes{}
+	if err := json.NewDecoder(r.Body).Decode(&c); err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	for _, e := range append(c.Delete, c.UpdateOld...) {
+		if w.supported(e) {
+			klog.V(2).Infof("external-dns deleting %s record %s", e.RecordType, e.DNSName)
+			w.server.setFamily(e.DNSName, e.RecordType == recordTypeAAAA, nil)
+		}
+	}
+	for _, e := range append(c.Create, c.UpdateNew...) {
+		if w.supported(e) {
+			klog.V(2).Infof("external-dns setting %s record %s to %v", e.RecordType, e.DNSName, e.Targets)
+			w.server.setFamily(e.DNSName, e.RecordType == recordTypeAAAA, parseTargets(e.Targets))
+		}
+	}
+	rw.WriteHeader(http.StatusNoContent)
+}
+
+// adjustEndpoints drops the records that can not be served, external-dns does not
+// try to create them and keeps planning the same changes forever otherwise.
+func (w *Webhook) adjustEndpoints(rw http.ResponseWriter, r *http.Request) {
+	endpoints := []*endpoint{}
+	if err := json.NewDecoder(r.Body).Decode(&endpoints); err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	adjusted := []*endpoint{}
+	for _, e := range endpoints {
+		if !w.supported(e) {
+			klog.V(4).Infof("external-dns %s record %s not supported, ignoring", e.RecordType, e.DNSName)
+			continue
+		}
+		e.RecordTTL = ttl
+		adjusted = append(adjusted, e)
+	}
+	writeJSON(rw, http.StatusOK, adjusted)
+}
+
+// supported returns true if the record can be served by the server
+func (w *Webhook) supported(e *endpoint) bool {
+	if e.RecordType != recordTypeA && e.RecordType != recordTypeAAAA {
+		return false
+	}
+	name := normalize(e.DNSName)
+	return name == w.server.domain || strings.HasSuffix(name, "."+w.server.domain)
+}
+
+func parseTargets(targets []string) []net.IP {
+	ips := []net.IP{}
+	for _, target := range targets {
+		if ip := net.ParseIP(target); ip != nil {
+			ips = append(ips, ip)
+		}
+	}
+	return ips
+}
+
+func writeJSON(rw http.ResponseWriter, code int, v interface{}) {
+	rw.Header().Set("Content-Type", webhookMediaType)
+	rw.WriteHeader(code)
+	json.NewEncoder(rw).Encode(v) // nolint:errcheck
+}
@@ -0,0 +1,70 @@
+package dns
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestWebhook(t *testing.T) {
+	s := NewServer("kind.local")
+	s.SetRecord("svc.ns.kind.local", []net.IP{net.ParseIP("192.168.8.5"), net.ParseIP("fc00:f853:ccd:e793::5")})
+	w := NewWebhook(s)
+
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		w.ServeHTTP(rec, httptest.NewRequest(method, path, strings.NewReader(body)))
+		return rec
+	}
+
+	rec := do(http.MethodGet, "/", "")
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != webhookMediaType {
+		t.Fatalf("negotiation failed: %d %s", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	if got := strings.TrimSpace(rec.Body.String()); got != `{"include":["kind.local"]}` {
+		t.Errorf("negotiation returned %s", got)
+	}
+
+	// replace the IPv4 address, add a new name and try to add a record out of the domain
+	rec = do(http.MethodPost, "/records", `{
+		"UpdateOld": [{"dnsName": "svc.ns.kind.local", "targets": ["192.168.8.5"], "recordType": "A"}],
+		"UpdateNew": [{"dnsName": "svc.ns.kind.local", "targets": ["192.168.8.6"], "recordType": "A"}],
+		"Create": [
+			{"dnsName": "app.kind.local", "targets": ["192.168.8.7"], "recordType": "A"},
+			{"dnsName": "app.example.com", "targets": ["192.168.8.8"], "recordType": "A"}
+		]
+	}`)
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("apply changes failed: %d %s", rec.Code, rec.Body.String())
+	}
+
+	rec = do(http.MethodGet, "/records", "")
+	got := []*endpoint{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatalf("invalid records response: %v", err)
+	}
+	want := []*endpoint{
+		{DNSName: "app.kind.local", Targets: []string{"192.168.8.7"}, RecordType: "A", RecordTTL: ttl},
+		{DNSName: "svc.ns.kind.local", Targets: []string{"192.168.8.6"}, RecordType: "A", RecordTTL: ttl},
+		{DNSName: "svc.ns.kind.local", Targets: []string{"fc00:f853:ccd:e793::5"}, RecordType: "AAAA", RecordTTL: ttl},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("records = %s", rec.Body.String())
+	}
+
+	rec = do(http.MethodPost, "/adjustendpoints", `[
+		{"dnsName": "app.kind.local", "targets": ["192.168.8.7"], "recordType": "A"},
+		{"dnsName": "app.kind.local", "targets": ["heritage=external-dns"], "recordType": "TXT"}
+	]`)
+	got = []*endpoint{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatalf("invalid adjustendpoints response: %v", err)
+	}
+	if len(got) != 1 || got[0].RecordType != recordTypeA || got[0].RecordTTL != ttl {
+		t.Errorf("adjustendpoints = %s", rec.Body.String())
+	}
+}
@@ -4,11 +4,16 @@ package loadbalancer
 
 import (
 	"os/exec"
+
+	netutils "k8s.io/utils/net"
 )
 
 func AddIPToInterface(ifaceName string, ip string) error {
-	// TODO: IPv6
-	err := exec.Command("ifconfig", ifaceName, "alias", ip, "netmask", "255.255.255.255").Run()
+	args := []string{ifaceName, "alias", ip, "netmask", "255.255.255.255"}
+	if netutils.IsIPv6String(ip) {
+		args = []string{ifaceName, "inet6", ip, "prefixlen", "128", "alias"}
+	}
+	err := exec.Command("ifconfig", args...).Run()
 	if err != nil {
 		return err
 	}
@@ -17,7 +22,11 @@ func AddIPToInterface(ifaceName string, ip string) error {
 
 func RemoveIPToInterface(ifaceName string, ip string) error {
 	// delete the IP address
-	err := exec.Command("ifconfig", ifaceName, "-alias", ip).Run()
+	args := []string{ifaceName, "-alias", ip}
+	if netutils.IsIPv6String(ip) {
+		args = []string{ifaceName, "inet6", ip, "-alias"}
+	}
+	err := exec.Command("ifconfig", args...).Run()
 	if err != nil {
 		return err
 	}
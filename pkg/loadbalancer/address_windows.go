@@ -4,10 +4,16 @@ package loadbalancer
 
 import (
 	"os/exec"
+
+	netutils "k8s.io/utils/net"
 )
 
 func AddIPToInterface(ifaceName string, ip string) error {
-	err := exec.Command("netsh", "interface", "ip", "add", "address", "loopback", ip, "255.255.255.255").Run()
+	args := []string{"interface", "ip", "add", "address", "loopback", ip, "255.255.255.255"}
+	if netutils.IsIPv6String(ip) {
+		args = []string{"interface", "ipv6", "add", "address", "loopback", ip}
+	}
+	err := exec.Command("netsh", args...).Run()
 	if err != nil {
 		return err
 	}
@@ -15,7 +21,11 @@ func AddIPToInterface(ifaceName string, ip string) error {
 }
 
 func RemoveIPToInterface(ifaceName string, ip string) error {
-	err := exec.Command("netsh", "interface", "ip", "delete", "address", "loopback", ip, "255.255.255.255").Run()
+	args := []string{"interface", "ip", "delete", "address", "loopback", ip, "255.255.255.255"}
+	if netutils.IsIPv6String(ip) {
+		args = []string{"interface", "ipv6", "delete", "address", "loopback", ip}
+	}
+	err := exec.Command("netsh", args...).Run()
 	if err != nil {
 		return err
 	}
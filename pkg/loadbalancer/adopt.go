@@ -0,0 +1,129 @@
+package loadbalancer
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+
+	"sigs.k8s.io/cloud-provider-kind/pkg/constants"
+	"sigs.k8s.io/cloud-provider-kind/pkg/container"
+)
+
+// The Services annotated with the name of an existing container use it as loadbalancer, so
+// the users can customize the proxy container, ex. with extra networks or volumes. The
+// container runtimes can not add labels to an existing container, the user creates it with
+// the cluster label, that also prevents adopting by mistake a container not meant to be a
+// loadbalancer, and the Service that adopted it is recorded in the container instead.
+// The adopted containers are never deleted, they are released without listeners.
+
+// proxyOwnerPath is the file in the adopted container with the loadbalancer simple name of
+// the Service that adopted it
+const proxyOwnerPath = "/etc/envoy/owner"
+
+// containerNameRegexp matches the container names accepted by docker and podman
+var containerNameRegexp = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*$`)
+
+// adoptedContainer returns the name of the container adopted by the Service, or empty if
+// the loadbalancer is created by cloud-provider-kind
+func adoptedContainer(service *v1.Service) string {
+	return strings.TrimSpace(service.Annotations[constants.AdoptContainerAnnotation])
+}
+
+// validateAdoptedContainer returns an error if the container can not be adopted by the Service
+func validateAdoptedContainer(service *v1.Service) error {
+	name := adoptedContainer(service)
+	if name == "" {
+		return nil
+	}
+	if !containerNameRegexp.MatchString(name) {
+		return fmt.Errorf("invalid %s annotation %q: not a valid container name", constants.AdoptContainerAnnotation, name)
+	}
+	if sharedIPKey(service) != "" {
+		return fmt.Errorf("the %s and %s annotations can not be used together", constants.AdoptContainerAnnotation, constants.AllowSharedIPAnnotation)
+	}
+	return nil
+}
+
+// adoptLoadBalancer checks the container can be the loadbalancer of the Service and records
+// the Service as its owner, the container is started if it is stopped. The caller must hold
+// the container lock.
+func adoptLoadBalancer(ctx context.Context, clusterName string, service *v1.Service) error {
+	name := adoptedContainer(service)
+	if !container.Exist(name) {
+		return provisioningError(ReasonAdoptionFailed, fmt.Errorf("adopted container %s does not exist", name))
+	}
+	cluster, err := container.GetLabelValue(name, constants.NodeCCMLabelKey)
+	if err != nil {
+		return provisioningError(ReasonAdoptionFailed, err)
+	}
+	if cluster != clusterName {
+		return provisioningError(ReasonAdoptionFailed, fmt.Errorf("adopted container %s must have the label %s=%s", name, constants.NodeCCMLabelKey, clusterName))
+	}
+	owner := loadBalancerSimpleName(clusterName, service)
+	label, err := container.GetLabelValue(name, constants.LoadBalancerNameLabelKey)
+	if err != nil {
+		return provisioningError(ReasonAdoptionFailed, err)
+	}
+	if label != "" && label != owner {
+		return provisioningError(ReasonAdoptionFailed, fmt.Errorf("adopted container %s is the loadbalancer of %s", name, label))
+	}
+	if !container.IsRunning(name) {
+		klog.FromContext(ctx).V(2).Info("Starting adopted loadbalancer container")
+		err := container.Restart(name)
+		if err != nil {
+			return provisioningError(ReasonAdoptionFailed, fmt.Errorf("failed to start adopted container %s: %w", name, err))
+		}
+	}
+	current, err := readOwner(name)
+	if err != nil {
+		return provisioningError(ReasonAdoptionFailed, err)
+	}
+	if current == owner {
+		return nil
+	}
+	if current != "" {
+		return provisioningError(ReasonAdoptionFailed, fmt.Errorf("adopted container %s is already the loadbalancer of %s", name, current))
+	}
+	klog.FromContext(ctx).Info("Adopting loadbalancer container")
+	err = container.Exec(name, []string{"cp", "/dev/stdin", proxyOwnerPath}, strings.NewReader(owner), nil, nil)
+	if err != nil {
+		return provisioningError(ReasonAdoptionFailed, fmt.Errorf("failed to adopt container %s: %w", name, err))
+	}
+	return nil
+}
+
+// readOwner returns the Service that adopted the container, or empty if it was not adopted
+func readOwner(name string) (string, error) {
+	if err := container.Exec(name, []string{"test", "-f", proxyOwnerPath}, nil, nil, nil); err != nil {
+		return "", nil
+	}
+	var stdout bytes.Buffer
+	err := container.Exec(name, []string{"cat", proxyOwnerPath}, nil, &stdout, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to read the owner of container %s: %w", name, err)
+	}
+	return strings.TrimSpace(stdout.String()), nil
+}
+
+// releaseLoadBalancer removes the config of the Service from the adopted container, so it
+// stops forwarding the traffic, and its owner, it can be adopted again by other Service.
+func releaseLoadBalancer(ctx context.Context, name string) error {
+	if !container.Exist(name) || !container.IsRunning(name) {
+		return nil
+	}
+	klog.FromContext(ctx).Info("Releasing adopted loadbalancer container")
+	files, err := proxyConfig(&proxyConfigData{})
+	if err != nil {
+		return err
+	}
+	err = applyProxyConfig(ctx, name, files)
+	if err != nil {
+		return fmt.Errorf("failed to release adopted container %s: %w", name, err)
+	}
+	return container.Exec(name, []string{"rm", "-f", proxyCommitPath, proxyOwnerPath}, nil, nil, nil)
+}
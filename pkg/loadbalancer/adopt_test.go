@@ -0,0 +1,120 @@
+package loadbalancer
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"sigs.k8s.io/cloud-provider-kind/pkg/constants"
+	"sigs.k8s.io/cloud-provider-kind/pkg/container"
+)
+
+func Test_validateAdoptedContainer(t *testing.T) {
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		wantErr     bool
+	}{
+		{name: "not adopted"},
+		{name: "valid", annotations: map[string]string{constants.AdoptContainerAnnotation: "my-proxy"}},
+		{name: "invalid name", annotations: map[string]string{constants.AdoptContainerAnnotation: "my proxy"}, wantErr: true},
+		{name: "shared IP", annotations: map[string]string{constants.AdoptContainerAnnotation: "my-proxy", constants.AllowSharedIPAnnotation: "dns"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service := &v1.Service{ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "ns", Annotations: tt.annotations}}
+			if err := validateAdoptedContainer(service); (err != nil) != tt.wantErr {
+				t.Errorf("validateAdoptedContainer() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestEnsureLoadBalancerFakeAdopted(t *testing.T) {
+	fake := container.NewFake()
+	defer container.SetRuntime(fake)()
+	fake.AddContainer(&container.FakeContainer{
+		Name:     "kind-control-plane",
+		Image:    "kindest/node:v1.30.0",
+		Labels:   map[string]string{constants.KindClusterLabelKey: "kind"},
+		Networks: []string{"kind"},
+		IPv4:     "172.18.0.2",
+		Running:  true,
+	})
+	fake.AddContainer(&container.FakeContainer{
+		Name:     "my-proxy",
+		Image:    proxyImage,
+		Labels:   map[string]string{constants.NodeCCMLabelKey: "kind"},
+		Networks: []string{"kind", "storage"},
+		IPv4:     "172.18.0.100",
+	})
+	fake.ExecHook = fakeListeners(80)
+
+	s := NewServer(nil, nil, nil).(*Server)
+	s.tunnelManager = nil
+	s.hostAddress = ""
+	s.publishUnready = true
+	makeLBService := func(name string, adopted string) *v1.Service {
+		return &v1.Service{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", Annotations: map[string]string{constants.AdoptContainerAnnotation: adopted}},
+			Spec: v1.ServiceSpec{
+				Type:       v1.ServiceTypeLoadBalancer,
+				IPFamilies: []v1.IPFamily{v1.IPv4Protocol},
+				Ports:      []v1.ServicePort{{Port: 80, NodePort: 30080, Protocol: v1.ProtocolTCP}},
+			},
+		}
+	}
+	nodes := []*v1.Node{makeNode("kind-control-plane", "172.18.0.2")}
+	web, other := makeLBService("web", "my-proxy"), makeLBService("other", "my-proxy")
+
+	status, err := s.EnsureLoadBalancer(context.Background(), "kind", web, nodes)
+	if err != nil {
+		t.Fatalf("EnsureLoadBalancer() unexpected error: %v", err)
+	}
+	if len(status.Ingress) != 1 || status.Ingress[0].IP != "172.18.0.100" {
+		t.Errorf("EnsureLoadBalancer() status = %v, want the IP of the adopted container", status)
+	}
+	adopted := fake.Container("my-proxy")
+	if !adopted.Running || adopted.Files[proxyOwnerPath] != "kind/default/web" || !strings.Contains(adopted.Files[proxyClustersPath], "30080") {
+		t.Errorf("container not adopted: running %v, files %v", adopted.Running, adopted.Files)
+	}
+	if len(fake.Containers) != 2 {
+		t.Errorf("loadbalancer container created instead of adopting it: %v", fake.Containers)
+	}
+
+	// a container is adopted only by one Service
+	_, err = s.EnsureLoadBalancer(context.Background(), "kind", other, nodes)
+	var provisioningErr *ProvisioningError
+	if !errors.As(err, &provisioningErr) || provisioningErr.Reason != ReasonAdoptionFailed {
+		t.Fatalf("EnsureLoadBalancer() error = %v, want reason %s", err, ReasonAdoptionFailed)
+	}
+
+	// the adopted containers are released instead of deleted
+	if err := s.EnsureLoadBalancerDeleted(context.Background(), "kind", web); err != nil {
+		t.Fatalf("EnsureLoadBalancerDeleted() unexpected error: %v", err)
+	}
+	adopted = fake.Container("my-proxy")
+	if adopted == nil {
+		t.Fatalf("adopted container deleted")
+	}
+	if _, ok := adopted.Files[proxyOwnerPath]; ok || strings.Contains(adopted.Files[proxyClustersPath], "30080") {
+		t.Errorf("adopted container not released: %v", adopted.Files)
+	}
+	if _, err := s.EnsureLoadBalancer(context.Background(), "kind", other, nodes); err != nil {
+		t.Fatalf("EnsureLoadBalancer() of the released container unexpected error: %v", err)
+	}
+
+	// the containers without the cluster label can not be adopted
+	node := makeLBService("node", "kind-control-plane")
+	_, err = s.EnsureLoadBalancer(context.Background(), "kind", node, nodes)
+	if !errors.As(err, &provisioningErr) || provisioningErr.Reason != ReasonAdoptionFailed {
+		t.Fatalf("EnsureLoadBalancer() error = %v, want reason %s", err, ReasonAdoptionFailed)
+	}
+	if _, ok := fake.Container("kind-control-plane").Files[proxyConfigPath]; ok {
+		t.Errorf("loadbalancer config written in a container that was not adopted")
+	}
+}
@@ -0,0 +1,159 @@
+package loadbalancer
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+
+	"k8s.io/klog/v2"
+
+	"sigs.k8s.io/cloud-provider-kind/pkg/constants"
+	"sigs.k8s.io/cloud-provider-kind/pkg/container"
+)
+
+// The loadbalancer addresses are usually the primary addresses of the containers, assigned
+// by the container runtime. With --advertise-addresses the addresses allocated from the
+// --loadbalancer-subnet are added as aliases on the loadbalancer interface instead and
+// announced with gratuitous ARP and unsolicited neighbor advertisements, like kube-vip does,
+// so the hosts and the containers on the network resolve them and update their neighbor
+// caches. The container runtime is not aware of the aliases, they are stored in a container
+// label, and the envoy image does not ship iproute2 so they are configured from an ephemeral
+// container with the kind node image.
+
+// advertiseSysctls make the kernel announce all the addresses of an interface when its
+// hardware address is set, they are only effective on the loadbalancer network namespace.
+var advertiseSysctls = []string{
+	"--sysctl=net.ipv4.conf.all.arp_notify=1",
+	"--sysctl=net.ipv6.conf.all.ndisc_notify=1",
+}
+
+// aliasAddresses returns the addresses with the prefix length of the network subnet that
+// contains each of them, so the aliases are on-link on the same interface as the primary
+// addresses.
+func aliasAddresses(networkSubnets []string, addresses ...string) ([]string, error) {
+	aliases := []string{}
+	for _, address := range addresses {
+		if address == "" {
+			continue
+		}
+		ip := net.ParseIP(address)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid address %q", address)
+		}
+		found := false
+		for _, cidr := range networkSubnets {
+			_, subnet, err := net.ParseCIDR(cidr)
+			if err != nil || !subnet.Contains(ip) {
+				continue
+			}
+			ones, _ := subnet.Mask.Size()
+			aliases = append(aliases, fmt.Sprintf("%s/%d", ip, ones))
+			found = true
+			break
+		}
+		if !found {
+			return nil, fmt.Errorf("address %s is not part of the network subnets %v", address, networkSubnets)
+		}
+	}
+	return aliases, nil
+}
+
+// parseAliases parses the value of the label with the aliases of a loadbalancer, the
+// invalid entries are ignored.
+func parseAliases(value string) []string {
+	aliases := []string{}
+	for _, alias := range strings.Split(value, ",") {
+		alias = strings.TrimSpace(alias)
+		if _, _, err := net.ParseCIDR(alias); err == nil {
+			aliases = append(aliases, alias)
+		}
+	}
+	return aliases
+}
+
+// advertisedAddresses returns the addresses of the loadbalancer, empty if they are assigned
+// by the container runtime.
+func advertisedAddresses(name string) (ipv4 string, ipv6 string, err error) {
+	value, err := container.GetLabelValue(name, constants.LoadBalancerAddressesLabelKey)
+	if err != nil {
+		return "", "", err
+	}
+	for _, alias := range parseAliases(value) {
+		ip, _, _ := net.ParseCIDR(alias)
+		if ip.To4() != nil {
+			ipv4 = ip.String()
+		} else {
+			ipv6 = ip.String()
+		}
+	}
+	return ipv4, ipv6, nil
+}
+
+// advertisedAddressesInUse returns the aliases of all the loadbalancers, the container
+// runtime can not take them into account when allocating the addresses.
+func advertisedAddressesInUse() ([]string, error) {
+	values, err := container.ListLabelValues(constants.LoadBalancerAddressesLabelKey, constants.LoadBalancerAddressesLabelKey)
+	if err != nil {
+		return nil, err
+	}
+	addresses := []string{}
+	for _, value := range values {
+		for _, alias := range parseAliases(value) {
+			ip, _, _ := net.ParseCIDR(alias)
+			addresses = append(addresses, ip.String())
+		}
+	}
+	return addresses, nil
+}
+
+// advertiseScript returns the script that adds the aliases to the interfaces with an address
+// on the same subnet and announces them. Setting the hardware address of the interface to
+// its current value notifies the neighbors with the advertiseSysctls, the IPv6 addresses are
+// announced again when the duplicate address detection completes.
+func advertiseScript(aliases []string) string {
+	var script strings.Builder
+	script.WriteString("set -e\ndevs=\n")
+	for _, alias := range aliases {
+		ip, subnet, err := net.ParseCIDR(alias)
+		if err != nil {
+			continue
+		}
+		family := "-4"
+		if ip.To4() == nil {
+			family = "-6"
+		}
+		fmt.Fprintf(&script, "dev=$(ip -o %s addr show to %s | cut -d' ' -f2 | head -n1)\n", family, subnet)
+		fmt.Fprintf(&script, "[ -n \"$dev\" ] || { echo \"no interface on subnet %s\" >&2; exit 1; }\n", subnet)
+		fmt.Fprintf(&script, "ip %s addr replace %s dev $dev\n", family, alias)
+		script.WriteString("devs=\"$devs $dev\"\n")
+	}
+	script.WriteString("for dev in $(echo $devs | tr ' ' '\\n' | sort -u); do\n")
+	script.WriteString("  ip link set dev $dev address $(cat /sys/class/net/$dev/address)\n")
+	script.WriteString("done\n")
+	return script.String()
+}
+
+// ensureAdvertisement configures and announces the aliases of the loadbalancer, if it has
+// any. The aliases do not survive container restarts, so they have to be applied again
+// every time the loadbalancer is restarted.
+func ensureAdvertisement(ctx context.Context, clusterName string, name string) error {
+	value, err := container.GetLabelValue(name, constants.LoadBalancerAddressesLabelKey)
+	if err != nil {
+		return err
+	}
+	aliases := parseAliases(value)
+	if len(aliases) == 0 {
+		return nil
+	}
+	image, err := nodeImage(clusterName)
+	if err != nil {
+		return err
+	}
+	_, err = container.RunInNetNS(name, image, []string{"sh", "-c", advertiseScript(aliases)})
+	if err != nil {
+		return fmt.Errorf("failed to advertise the addresses %v of loadbalancer %s: %w", aliases, name, err)
+	}
+	klog.FromContext(ctx).V(2).Info("Advertised loadbalancer addresses", "addresses", aliases)
+	return nil
+}
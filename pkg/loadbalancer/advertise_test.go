@@ -0,0 +1,75 @@
+package loadbalancer
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func Test_aliasAddresses(t *testing.T) {
+	networkSubnets := []string{"172.18.0.0/16", "fc00:f853:ccd:e793::/64"}
+	tests := []struct {
+		name      string
+		addresses []string
+		want      []string
+		wantErr   bool
+	}{
+		{
+			name:      "ipv4",
+			addresses: []string{"172.18.200.1", ""},
+			want:      []string{"172.18.200.1/16"},
+		},
+		{
+			name:      "dual stack",
+			addresses: []string{"172.18.200.1", "fc00:f853:ccd:e793::c8:1"},
+			want:      []string{"172.18.200.1/16", "fc00:f853:ccd:e793::c8:1/64"},
+		},
+		{
+			name:      "outside the network",
+			addresses: []string{"10.0.0.1"},
+			wantErr:   true,
+		},
+		{
+			name:      "invalid address",
+			addresses: []string{"bad"},
+			wantErr:   true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := aliasAddresses(networkSubnets, tt.addresses...)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("aliasAddresses() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("aliasAddresses() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_parseAliases(t *testing.T) {
+	got := parseAliases("172.18.200.1/16, bad,fc00::1/64,")
+	want := []string{"172.18.200.1/16", "fc00::1/64"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseAliases() = %v, want %v", got, want)
+	}
+	if got := parseAliases(""); len(got) != 0 {
+		t.Errorf("parseAliases() = %v, want none", got)
+	}
+}
+
+func Test_advertiseScript(t *testing.T) {
+	script := advertiseScript([]string{"172.18.200.1/16", "fc00:f853:ccd:e793::c8:1/64"})
+	for _, want := range []string{
+		"ip -o -4 addr show to 172.18.0.0/16",
+		"ip -4 addr replace 172.18.200.1/16 dev $dev",
+		"ip -o -6 addr show to fc00:f853:ccd:e793::/64",
+		"ip -6 addr replace fc00:f853:ccd:e793::c8:1/64 dev $dev",
+		"ip link set dev $dev address $(cat /sys/class/net/$dev/address)",
+	} {
+		if !strings.Contains(script, want) {
+			t.Errorf("advertiseScript() does not contain %q:\n%s", want, script)
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package loadbalancer
+
+import (
+	"fmt"
+	"net/netip"
+	"strconv"
+	"strings"
+
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	listenerv3 "github.com/envoyproxy/go-control-plane/envoy/config/listener/v3"
+	rbacconfigv3 "github.com/envoyproxy/go-control-plane/envoy/config/rbac/v3"
+	rbacv3 "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/network/rbac/v3"
+	"google.golang.org/protobuf/types/known/anypb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+
+	"sigs.k8s.io/cloud-provider-kind/pkg/constants"
+)
+
+// parseAllowedSources returns the source ranges of the annotation by port, the empty
+// selector are the ranges of all the Service ports, or nil if the Service is not annotated.
+// The annotation restricts the clients, so if any entry is invalid no client is allowed
+// instead of ignoring it.
+func parseAllowedSources(service *v1.Service) map[string][]netip.Prefix {
+	value, ok := service.Annotations[constants.AllowedSourcesAnnotation]
+	if !ok {
+		return nil
+	}
+	sources, err := allowedSources(value)
+	if err != nil {
+		klog.InfoS("Invalid allowed sources, denying all the clients", "service", klog.KObj(service), "annotation", constants.AllowedSourcesAnnotation, "value", value, "err", err)
+		return map[string][]netip.Prefix{}
+	}
+	return sources
+}
+
+func allowedSources(value string) (map[string][]netip.Prefix, error) {
+	sources := map[string][]netip.Prefix{}
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		selector, cidr, found := strings.Cut(entry, "=")
+		if !found {
+			selector, cidr = "", entry
+		}
+		selector = strings.TrimSpace(selector)
+		prefix, err := parsePrefix(strings.TrimSpace(cidr))
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR in %q: %w", entry, err)
+		}
+		if strings.Contains(selector, "/") {
+			return nil, fmt.Errorf("invalid port in %q", entry)
+		}
+		sources[selector] = append(sources[selector], prefix)
+	}
+	return sources, nil
+}
+
+// parsePrefix parses a CIDR, or an IP address that is the range of a single address
+func parsePrefix(value string) (netip.Prefix, error) {
+	if ip, err := netip.ParseAddr(value); err == nil {
+		ip = ip.Unmap()
+		return netip.PrefixFrom(ip, ip.BitLen()), nil
+	}
+	prefix, err := netip.ParsePrefix(value)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	return netip.PrefixFrom(prefix.Addr().Unmap(), prefix.Bits()).Masked(), nil
+}
+
+// portAllowedSources returns the source ranges of the IP family allowed to connect to the
+// Service port, by number or name, and the ranges of all the ports. It returns nil if all
+// the clients are allowed and an empty list if none is.
+func portAllowedSources(sources map[string][]netip.Prefix, port v1.ServicePort, ipFamily v1.IPFamily) []netip.Prefix {
+	if sources == nil {
+		return nil
+	}
+	selectors := []string{"", strconv.Itoa(int(port.Port))}
+	if port.Name != "" {
+		selectors = append(selectors, port.Name)
+	}
+	allowed := []netip.Prefix{}
+	for _, selector := range selectors {
+		for _, prefix := range sources[selector] {
+			if prefix.Addr().Is4() == (ipFamily == v1.IPv4Protocol) {
+				allowed = append(allowed, prefix)
+			}
+		}
+	}
+	return allowed
+}
+
+// RestrictSources returns true if only the AllowedSources can connect to the listener,
+// kept for the user templates
+func (s servicePort) RestrictSources() bool {
+	return s.AllowedSources != nil
+}
+
+// allowedSourcesFilter returns the RBAC network filter closing the connections of the
+// clients out of the allowed source ranges. The direct remote address is matched, the
+// source address of the connection and not the one of the PROXY protocol header.
+func allowedSourcesFilter(clusterName string, sources []netip.Prefix) (*listenerv3.Filter, error) {
+	rules := &rbacconfigv3.RBAC{Action: rbacconfigv3.RBAC_ALLOW}
+	// without policies no connection is allowed
+	if len(sources) > 0 {
+		principals := []*rbacconfigv3.Principal{}
+		for _, prefix := range sources {
+			principals = append(principals, &rbacconfigv3.Principal{
+				Identifier: &rbacconfigv3.Principal_DirectRemoteIp{DirectRemoteIp: &corev3.CidrRange{
+					AddressPrefix: prefix.Addr().String(),
+					PrefixLen:     wrapperspb.UInt32(uint32(prefix.Bits())),
+				}},
+			})
+		}
+		rules.Policies = map[string]*rbacconfigv3.Policy{
+			"allowed-sources": {
+				Permissions: []*rbacconfigv3.Permission{{Rule: &rbacconfigv3.Permission_Any{Any: true}}},
+				Principals:  principals,
+			},
+		}
+	}
+	typedConfig, err := anypb.New(&rbacv3.RBAC{StatPrefix: clusterName, Rules: rules})
+	if err != nil {
+		return nil, err
+	}
+	return &listenerv3.Filter{
+		Name:       "envoy.filters.network.rbac",
+		ConfigType: &listenerv3.Filter_TypedConfig{TypedConfig: typedConfig},
+	}, nil
+}
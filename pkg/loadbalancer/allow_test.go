@@ -0,0 +1,124 @@
+package loadbalancer
+
+import (
+	"net/netip"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	v1 "k8s.io/api/core/v1"
+
+	"sigs.k8s.io/cloud-provider-kind/pkg/constants"
+)
+
+func Test_allowedSources(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		want    map[string][]netip.Prefix
+		wantErr bool
+	}{
+		{name: "empty", value: "", want: map[string][]netip.Prefix{}},
+		{
+			name:  "all the ports",
+			value: "10.0.0.0/8, fd00::/64",
+			want:  map[string][]netip.Prefix{"": {netip.MustParsePrefix("10.0.0.0/8"), netip.MustParsePrefix("fd00::/64")}},
+		},
+		{
+			name:  "by port",
+			value: "192.168.1.0/24,443=0.0.0.0/0,https=::/0",
+			want: map[string][]netip.Prefix{
+				"":      {netip.MustParsePrefix("192.168.1.0/24")},
+				"443":   {netip.MustParsePrefix("0.0.0.0/0")},
+				"https": {netip.MustParsePrefix("::/0")},
+			},
+		},
+		{
+			name:  "addresses and host bits",
+			value: "80=172.18.0.1,80=10.1.2.3/8,::ffff:192.168.1.1",
+			want: map[string][]netip.Prefix{
+				"":   {netip.MustParsePrefix("192.168.1.1/32")},
+				"80": {netip.MustParsePrefix("172.18.0.1/32"), netip.MustParsePrefix("10.0.0.0/8")},
+			},
+		},
+		{name: "invalid CIDR", value: "10.0.0.0/33", wantErr: true},
+		{name: "hostname", value: "80=example.com", wantErr: true},
+		{name: "invalid port", value: "IPv4/80=10.0.0.0/8", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := allowedSources(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("allowedSources() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if diff := cmp.Diff(tt.want, got, cmp.Comparer(func(a, b netip.Prefix) bool { return a == b })); diff != "" {
+				t.Errorf("allowedSources() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func Test_portAllowedSources(t *testing.T) {
+	sources := map[string][]netip.Prefix{
+		"":      {netip.MustParsePrefix("10.0.0.0/8"), netip.MustParsePrefix("fd00::/64")},
+		"443":   {netip.MustParsePrefix("0.0.0.0/0")},
+		"admin": {netip.MustParsePrefix("127.0.0.1/32")},
+	}
+	tests := []struct {
+		name     string
+		sources  map[string][]netip.Prefix
+		port     v1.ServicePort
+		ipFamily v1.IPFamily
+		want     []string
+	}{
+		{name: "not annotated", port: v1.ServicePort{Port: 80}, ipFamily: v1.IPv4Protocol, want: nil},
+		{name: "denied", sources: map[string][]netip.Prefix{}, port: v1.ServicePort{Port: 80}, ipFamily: v1.IPv4Protocol, want: []string{}},
+		{name: "all the ports", sources: sources, port: v1.ServicePort{Port: 80}, ipFamily: v1.IPv4Protocol, want: []string{"10.0.0.0/8"}},
+		{name: "IPv6", sources: sources, port: v1.ServicePort{Port: 80}, ipFamily: v1.IPv6Protocol, want: []string{"fd00::/64"}},
+		{name: "by number", sources: sources, port: v1.ServicePort{Port: 443}, ipFamily: v1.IPv4Protocol, want: []string{"10.0.0.0/8", "0.0.0.0/0"}},
+		{name: "by name", sources: sources, port: v1.ServicePort{Name: "admin", Port: 8080}, ipFamily: v1.IPv4Protocol, want: []string{"10.0.0.0/8", "127.0.0.1/32"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			if prefixes := portAllowedSources(tt.sources, tt.port, tt.ipFamily); prefixes != nil {
+				got = []string{}
+				for _, prefix := range prefixes {
+					got = append(got, prefix.String())
+				}
+			}
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("portAllowedSources() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func Test_generateConfigAllowedSources(t *testing.T) {
+	service := makeService("test")
+	service.Spec.Type = v1.ServiceTypeLoadBalancer
+	service.Spec.IPFamilies = []v1.IPFamily{v1.IPv4Protocol}
+	service.Spec.Ports = []v1.ServicePort{
+		{Name: "http", Port: 80, NodePort: 30000, Protocol: v1.ProtocolTCP},
+		{Name: "dns", Port: 53, NodePort: 30001, Protocol: v1.ProtocolUDP},
+	}
+	service.Annotations = map[string]string{constants.AllowedSourcesAnnotation: "http=192.168.0.0/16"}
+	nodes := []*v1.Node{makeNode("a", "10.0.0.1")}
+
+	data := generateConfig(service, nodes)
+	if got := data.ServicePorts["IPv4_80_TCP"].AllowedSources; len(got) != 1 || got[0] != netip.MustParsePrefix("192.168.0.0/16") {
+		t.Errorf("TCP port allowed sources = %v, want 192.168.0.0/16", got)
+	}
+	if data.ServicePorts["IPv4_53_UDP"].RestrictSources() {
+		t.Errorf("UDP port restricts the sources, the UDP listeners have no network filters")
+	}
+	if _, err := proxyConfig(data); err != nil {
+		t.Errorf("proxyConfig() error = %v", err)
+	}
+
+	// an invalid annotation does not allow any client
+	service.Annotations[constants.AllowedSourcesAnnotation] = "http=192.168.0.0/64"
+	data = generateConfig(service, nodes)
+	if got := data.ServicePorts["IPv4_80_TCP"]; !got.RestrictSources() || len(got.AllowedSources) != 0 {
+		t.Errorf("TCP port allowed sources = %v, want none", got.AllowedSources)
+	}
+}
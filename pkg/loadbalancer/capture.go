@@ -0,0 +1,145 @@
+package loadbalancer
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/klog/v2"
+
+	"sigs.k8s.io/cloud-provider-kind/pkg/config"
+	"sigs.k8s.io/cloud-provider-kind/pkg/constants"
+	"sigs.k8s.io/cloud-provider-kind/pkg/container"
+)
+
+// The packet captures debug the traffic of a loadbalancer without installing anything in
+// the proxy: tcpdump runs in a sidecar container sharing the loadbalancer network namespace
+// and writes the pcap file to the --capture-dir of the container runtime host. The sidecar
+// exits when the capture ends and is kept, labeled with the capture requested, so the same
+// capture is not started again on every sync. It is deleted when the annotation is removed
+// or the loadbalancer is deleted.
+
+// the bounds of the captures, the annotations can not exceed them
+const (
+	defaultCaptureMaxSize = 10_000_000
+	maxCaptureMaxSize     = 1_000_000_000
+	maxCaptureDuration    = time.Hour
+)
+
+// captureMountPath is the path of the --capture-dir in the sidecar
+const captureMountPath = "/captures"
+
+// capture is a bounded packet capture of the traffic of a loadbalancer
+type capture struct {
+	// Duration is how long the traffic is captured
+	Duration time.Duration
+	// MaxSize is the maximum size of the pcap file in bytes, the oldest packets are
+	// overwritten when it is full
+	MaxSize int64
+}
+
+// parseCapture returns the capture requested by the annotations of the Service, or nil if
+// there is none or it is invalid
+func parseCapture(service *v1.Service) *capture {
+	value, ok := service.Annotations[constants.CaptureAnnotation]
+	if !ok {
+		return nil
+	}
+	duration, err := time.ParseDuration(strings.TrimSpace(value))
+	if err != nil || duration <= 0 || duration > maxCaptureDuration {
+		klog.InfoS("Ignoring invalid capture duration", "service", klog.KObj(service), "annotation", constants.CaptureAnnotation, "value", value, "max", maxCaptureDuration)
+		return nil
+	}
+	c := &capture{Duration: duration, MaxSize: defaultCaptureMaxSize}
+	if value, ok := service.Annotations[constants.CaptureMaxSizeAnnotation]; ok {
+		size, err := resource.ParseQuantity(strings.TrimSpace(value))
+		if err != nil || size.Value() <= 0 || size.Value() > maxCaptureMaxSize {
+			klog.InfoS("Ignoring invalid capture max size", "service", klog.KObj(service), "annotation", constants.CaptureMaxSizeAnnotation, "value", value)
+		} else {
+			c.MaxSize = size.Value()
+		}
+	}
+	return c
+}
+
+// String returns the capture as the value of the sidecar label
+func (c *capture) String() string {
+	return fmt.Sprintf("%s,%d", c.Duration, c.MaxSize)
+}
+
+// captureContainerName returns the name of the packet capture sidecar of the loadbalancer
+func captureContainerName(name string) string {
+	return name + "-capture"
+}
+
+// captureCommand returns the command of the sidecar capturing the traffic of all the
+// interfaces to the file. The tcpdump file size is in millions of bytes, a single file
+// is rewritten from the start when it is full and renamed once the capture ends.
+func captureCommand(c *capture, file string) []string {
+	megabytes := (c.MaxSize + 999_999) / 1_000_000
+	pcap := path.Join(captureMountPath, file)
+	return []string{"sh", "-c", fmt.Sprintf("timeout %d tcpdump -i any -n -U -w %s -C %d -W 1; mv -f %s0 %s",
+		int(c.Duration.Seconds()), pcap, megabytes, pcap, pcap)}
+}
+
+// ensureCapture starts the packet capture requested by the first Service of the
+// loadbalancer with the annotation, replacing the sidecar of a different capture, or
+// deletes the sidecar if no capture is requested
+func ensureCapture(ctx context.Context, name string, services []*v1.Service) error {
+	if config.DefaultConfig.CaptureDir == "" {
+		return nil
+	}
+	var c *capture
+	for _, service := range services {
+		if c = parseCapture(service); c != nil {
+			break
+		}
+	}
+	sidecar := captureContainerName(name)
+	if container.Exist(sidecar) {
+		current, err := container.GetLabelValue(sidecar, constants.LoadBalancerCaptureLabelKey)
+		if err != nil {
+			return err
+		}
+		if c != nil && current == c.String() {
+			return nil
+		}
+		if err := container.Delete(sidecar); err != nil {
+			return err
+		}
+	}
+	if c == nil {
+		return nil
+	}
+	image := config.Get().CaptureImage
+	if err := container.EnsureImage(image); err != nil {
+		return fmt.Errorf("failed to pull the capture image %s: %w", image, err)
+	}
+	file := fmt.Sprintf("%s-%s.pcap", name, time.Now().UTC().Format("20060102T150405Z"))
+	args := []string{
+		"--detach",
+		"--label", fmt.Sprintf("%s=%s", constants.LoadBalancerCaptureLabelKey, c),
+		"--net", "container:" + name,
+		"--cap-add=NET_RAW", "--cap-add=NET_ADMIN",
+		"--volume", fmt.Sprintf("%s:%s", config.DefaultConfig.CaptureDir, captureMountPath),
+		image,
+	}
+	if err := container.Create(sidecar, args, captureCommand(c, file)...); err != nil {
+		return fmt.Errorf("failed to start the packet capture of loadbalancer %s: %w", name, err)
+	}
+	klog.FromContext(ctx).Info("Started packet capture", "file", path.Join(config.DefaultConfig.CaptureDir, file), "duration", c.Duration, "maxSize", c.MaxSize)
+	return nil
+}
+
+// deleteCapture deletes the packet capture sidecar of the loadbalancer, the pcap files
+// are kept
+func deleteCapture(name string) error {
+	if config.DefaultConfig.CaptureDir == "" {
+		return nil
+	}
+	return container.Delete(captureContainerName(name))
+}
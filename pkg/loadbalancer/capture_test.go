@@ -0,0 +1,127 @@
+package loadbalancer
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"sigs.k8s.io/cloud-provider-kind/pkg/config"
+	"sigs.k8s.io/cloud-provider-kind/pkg/constants"
+	"sigs.k8s.io/cloud-provider-kind/pkg/container"
+)
+
+func Test_parseCapture(t *testing.T) {
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		want        *capture
+	}{
+		{name: "not annotated"},
+		{
+			name:        "default size",
+			annotations: map[string]string{constants.CaptureAnnotation: "2m"},
+			want:        &capture{Duration: 2 * time.Minute, MaxSize: defaultCaptureMaxSize},
+		},
+		{
+			name:        "max size",
+			annotations: map[string]string{constants.CaptureAnnotation: "30s", constants.CaptureMaxSizeAnnotation: "50M"},
+			want:        &capture{Duration: 30 * time.Second, MaxSize: 50_000_000},
+		},
+		{
+			name:        "invalid max size",
+			annotations: map[string]string{constants.CaptureAnnotation: "30s", constants.CaptureMaxSizeAnnotation: "10Gi"},
+			want:        &capture{Duration: 30 * time.Second, MaxSize: defaultCaptureMaxSize},
+		},
+		{name: "invalid duration", annotations: map[string]string{constants.CaptureAnnotation: "forever"}},
+		{name: "unbounded duration", annotations: map[string]string{constants.CaptureAnnotation: "2h"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service := &v1.Service{ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default", Annotations: tt.annotations}}
+			if diff := cmp.Diff(tt.want, parseCapture(service)); diff != "" {
+				t.Errorf("parseCapture() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func Test_captureCommand(t *testing.T) {
+	got := captureCommand(&capture{Duration: 90 * time.Second, MaxSize: 1_500_000}, "kindccm-web.pcap")
+	want := []string{"sh", "-c", "timeout 90 tcpdump -i any -n -U -w /captures/kindccm-web.pcap -C 2 -W 1; mv -f /captures/kindccm-web.pcap0 /captures/kindccm-web.pcap"}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("captureCommand() mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func Test_ensureCapture(t *testing.T) {
+	defer func(dir, image string) {
+		config.DefaultConfig.CaptureDir, config.DefaultConfig.CaptureImage = dir, image
+	}(config.DefaultConfig.CaptureDir, config.DefaultConfig.CaptureImage)
+	fake := container.NewFake()
+	defer container.SetRuntime(fake)()
+	fake.AddContainer(&container.FakeContainer{Name: "kindccm-web", Running: true})
+	ctx := context.Background()
+	service := &v1.Service{ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default", Annotations: map[string]string{constants.CaptureAnnotation: "1m"}}}
+	services := []*v1.Service{service}
+
+	config.DefaultConfig.CaptureDir = ""
+	if err := ensureCapture(ctx, "kindccm-web", services); err != nil || fake.Exist("kindccm-web-capture") {
+		t.Fatalf("ensureCapture() without --capture-dir error = %v, want no sidecar", err)
+	}
+
+	config.DefaultConfig.CaptureDir, config.DefaultConfig.CaptureImage = "/tmp/captures", "netshoot"
+	if err := ensureCapture(ctx, "kindccm-web", services); err != nil {
+		t.Fatalf("ensureCapture() error = %v", err)
+	}
+	sidecar := fake.Container("kindccm-web-capture")
+	if sidecar == nil {
+		t.Fatalf("ensureCapture() did not start the sidecar")
+	}
+	args := strings.Join(sidecar.Args, " ")
+	for _, want := range []string{"--net container:kindccm-web", "--volume /tmp/captures:/captures", "netshoot"} {
+		if !strings.Contains(args, want) {
+			t.Errorf("sidecar args %q do not contain %q", args, want)
+		}
+	}
+	if got := sidecar.Labels[constants.LoadBalancerCaptureLabelKey]; got != "1m0s,10000000" {
+		t.Errorf("sidecar capture label = %q", got)
+	}
+
+	// the finished capture is not started again
+	if err := fake.Crash("kindccm-web-capture", 0, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := ensureCapture(ctx, "kindccm-web", services); err != nil {
+		t.Fatalf("ensureCapture() error = %v", err)
+	}
+	if sidecar := fake.Container("kindccm-web-capture"); sidecar == nil || sidecar.Running {
+		t.Errorf("ensureCapture() started the same capture again")
+	}
+
+	// a new value starts a new capture
+	service.Annotations[constants.CaptureAnnotation] = "2m"
+	if err := ensureCapture(ctx, "kindccm-web", services); err != nil {
+		t.Fatalf("ensureCapture() error = %v", err)
+	}
+	if sidecar := fake.Container("kindccm-web-capture"); sidecar == nil || !sidecar.Running || sidecar.Labels[constants.LoadBalancerCaptureLabelKey] != "2m0s,10000000" {
+		t.Errorf("ensureCapture() did not replace the capture: %+v", sidecar)
+	}
+
+	// the sidecar is deleted with the annotation and with the loadbalancer
+	delete(service.Annotations, constants.CaptureAnnotation)
+	if err := ensureCapture(ctx, "kindccm-web", services); err != nil || fake.Exist("kindccm-web-capture") {
+		t.Errorf("ensureCapture() without annotation error = %v, want the sidecar deleted", err)
+	}
+	service.Annotations[constants.CaptureAnnotation] = "1m"
+	if err := ensureCapture(ctx, "kindccm-web", services); err != nil {
+		t.Fatalf("ensureCapture() error = %v", err)
+	}
+	if err := deleteContainer("kindccm-web"); err != nil || fake.Exist("kindccm-web-capture") {
+		t.Errorf("deleteContainer() error = %v, want the sidecar deleted", err)
+	}
+}
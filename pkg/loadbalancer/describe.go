@@ -0,0 +1,174 @@
+package loadbalancer
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+
+	"sigs.k8s.io/cloud-provider-kind/pkg/constants"
+	"sigs.k8s.io/cloud-provider-kind/pkg/container"
+)
+
+// Description is the state of a loadbalancer container reported by the admin API and
+// the list and describe commands
+type Description struct {
+	Container string   `json:"container"`
+	Exists    bool     `json:"exists"`
+	Running   bool     `json:"running"`
+	Committed bool     `json:"committed"`
+	IPv4      string   `json:"ipv4,omitempty"`
+	IPv6      string   `json:"ipv6,omitempty"`
+	Networks  []string `json:"networks,omitempty"`
+	// Ports are the host ports of the published container ports, ex. 80/tcp
+	Ports map[string]string `json:"ports,omitempty"`
+	// Upstreams reports if each port of the proxy, ex. IPv4_80_TCP, has healthy backends
+	Upstreams map[string]bool `json:"upstreams,omitempty"`
+	// the proxy config applied to the container, only if requested
+	Bootstrap string `json:"bootstrap,omitempty"`
+	Clusters  string `json:"clusters,omitempty"`
+	Faults    string `json:"faults,omitempty"`
+}
+
+// DescribeLoadBalancer returns the state of the loadbalancer container, withConfig also
+// reads the proxy config applied to it
+func DescribeLoadBalancer(name string, withConfig bool) Description {
+	d := Description{Container: name, Exists: container.Exist(name)}
+	if !d.Exists {
+		return d
+	}
+	d.Running = container.IsRunning(name)
+	d.IPv4, d.IPv6, _ = container.IPs(name)
+	d.Networks, _ = container.Networks(name)
+	if !d.Running {
+		return d
+	}
+	d.Committed = isCommitted(name)
+	d.Ports, _ = container.PortMappings(name)
+	d.Upstreams = upstreamsHealth(name)
+	if withConfig {
+		files, err := readProxyConfig(name)
+		if err == nil {
+			d.Bootstrap, d.Clusters, d.Faults = files.Bootstrap, files.Clusters, files.Faults
+		}
+	}
+	return d
+}
+
+// Status returns a summary of the state of the container: missing, stopped, provisioning
+// until it is committed, or running
+func (d Description) Status() string {
+	switch {
+	case !d.Exists:
+		return "missing"
+	case !d.Running:
+		return "stopped"
+	case !d.Committed:
+		return "provisioning"
+	default:
+		return "running"
+	}
+}
+
+// resourceAnnotations are the annotations written on the Services with the resources of
+// their loadbalancer container
+var resourceAnnotations = []string{
+	constants.LoadBalancerContainerAnnotation,
+	constants.LoadBalancerContainerIDAnnotation,
+	constants.LoadBalancerIPAnnotation,
+	constants.LoadBalancerHostPortsAnnotation,
+}
+
+// IsResourceAnnotation returns true if the annotation is one of the annotations with the
+// resources of the loadbalancer written by cloud-provider-kind
+func IsResourceAnnotation(key string) bool {
+	return slices.Contains(resourceAnnotations, key)
+}
+
+// ResourceAnnotations returns the annotations with the name and ID of the loadbalancer
+// container, its IPs and its published host ports, the empty values are not included
+func ResourceAnnotations(name string) (map[string]string, error) {
+	id, err := container.ID(name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the ID of the loadbalancer container %s: %w", name, err)
+	}
+	annotations := map[string]string{
+		constants.LoadBalancerContainerAnnotation:   name,
+		constants.LoadBalancerContainerIDAnnotation: id,
+	}
+	ipv4, ipv6, err := container.IPs(name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the IPs of the loadbalancer container %s: %w", name, err)
+	}
+	ips := []string{}
+	for _, ip := range []string{ipv4, ipv6} {
+		if ip != "" {
+			ips = append(ips, ip)
+		}
+	}
+	if len(ips) > 0 {
+		annotations[constants.LoadBalancerIPAnnotation] = strings.Join(ips, ",")
+	}
+	ports, err := container.PortMappings(name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the ports of the loadbalancer container %s: %w", name, err)
+	}
+	mappings := []string{}
+	for port, hostPort := range ports {
+		mappings = append(mappings, port+"="+hostPort)
+	}
+	if len(mappings) > 0 {
+		sort.Strings(mappings)
+		annotations[constants.LoadBalancerHostPortsAnnotation] = strings.Join(mappings, ",")
+	}
+	return annotations, nil
+}
+
+// upstreamsHealth returns if each port of the proxy has healthy backends, nil if the proxy
+// admin interface does not answer
+func upstreamsHealth(name string) map[string]bool {
+	var stdout bytes.Buffer
+	err := container.Exec(name, []string{"bash", "-c", proxyAdminClustersScript}, nil, &stdout, nil)
+	if err != nil {
+		return nil
+	}
+	upstreams := map[string]bool{}
+	for _, line := range strings.Split(stdout.String(), "\n") {
+		// the hosts lines start with the cluster name
+		cluster, _, ok := strings.Cut(strings.TrimSpace(line), "::")
+		if ok && strings.HasPrefix(cluster, "cluster_") {
+			upstreams[strings.TrimPrefix(cluster, "cluster_")] = false
+		}
+	}
+	for cluster := range parseHealthyClusters(&stdout) {
+		// the mirror clusters are not the upstreams of the ports
+		if strings.HasPrefix(cluster, "cluster_") {
+			upstreams[strings.TrimPrefix(cluster, "cluster_")] = true
+		}
+	}
+	return upstreams
+}
+
+// Recreator is implemented by the loadbalancer controllers that can delete a loadbalancer
+// to create it again from scratch
+type Recreator interface {
+	// RecreateLoadBalancer deletes the loadbalancer of the Service, it is created again on
+	// the next sync of the Services using it
+	RecreateLoadBalancer(ctx context.Context, clusterName string, service *v1.Service) error
+}
+
+var _ Recreator = &Server{}
+
+func (s *Server) RecreateLoadBalancer(ctx context.Context, clusterName string, service *v1.Service) error {
+	name := loadBalancerName(clusterName, service)
+	_, logger := withContainer(ctx, name)
+	unlock := s.locks.lock(name)
+	defer unlock()
+	logger.Info("Deleting loadbalancer to recreate it")
+	s.crashes.forget(name)
+	return s.deleteLoadBalancer(ctx, name, service)
+}
@@ -0,0 +1,54 @@
+package loadbalancer
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"sigs.k8s.io/cloud-provider-kind/pkg/constants"
+	"sigs.k8s.io/cloud-provider-kind/pkg/container"
+)
+
+func TestResourceAnnotations(t *testing.T) {
+	fake := container.NewFake()
+	defer container.SetRuntime(fake)()
+	fake.AddContainer(&container.FakeContainer{
+		Name:    "kindccm-web",
+		IPv4:    "172.18.0.10",
+		IPv6:    "fc00:f853:ccd:e793::10",
+		Running: true,
+		Ports:   map[string]string{"443/tcp": "32769", "80/tcp": "32768"},
+	})
+	fake.AddContainer(&container.FakeContainer{Name: "kindccm-internal", IPv4: "172.18.0.11", Running: true})
+
+	id, err := container.ID("kindccm-web")
+	if err != nil {
+		t.Fatalf("ID() error = %v", err)
+	}
+	got, err := ResourceAnnotations("kindccm-web")
+	if err != nil {
+		t.Fatalf("ResourceAnnotations() error = %v", err)
+	}
+	want := map[string]string{
+		constants.LoadBalancerContainerAnnotation:   "kindccm-web",
+		constants.LoadBalancerContainerIDAnnotation: id,
+		constants.LoadBalancerIPAnnotation:          "172.18.0.10,fc00:f853:ccd:e793::10",
+		constants.LoadBalancerHostPortsAnnotation:   "443/tcp=32769,80/tcp=32768",
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("ResourceAnnotations() mismatch (-want +got):\n%s", diff)
+	}
+
+	// the containers without published ports do not have the host ports annotation
+	got, err = ResourceAnnotations("kindccm-internal")
+	if err != nil {
+		t.Fatalf("ResourceAnnotations() error = %v", err)
+	}
+	if _, ok := got[constants.LoadBalancerHostPortsAnnotation]; ok || got[constants.LoadBalancerIPAnnotation] != "172.18.0.11" {
+		t.Errorf("ResourceAnnotations() without ports = %v", got)
+	}
+
+	if _, err := ResourceAnnotations("missing"); err == nil {
+		t.Errorf("ResourceAnnotations() of a missing container did not fail")
+	}
+}
@@ -0,0 +1,163 @@
+package loadbalancer
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"net"
+	"slices"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/klog/v2"
+
+	"sigs.k8s.io/cloud-provider-kind/pkg/certs"
+	"sigs.k8s.io/cloud-provider-kind/pkg/constants"
+	"sigs.k8s.io/cloud-provider-kind/pkg/dns"
+)
+
+// DevDomain gives every loadbalancer a name resolvable by the embedded DNS server
+// and a serving certificate for that name signed by the generated CA.
+// The certificate is stored in a Secret of type kubernetes.io/tls in the Service
+// namespace so the workloads can use it to serve TLS behind the loadbalancer.
+type DevDomain struct {
+	DNS *dns.Server
+	CA  *certs.CA
+}
+
+const (
+	// StatusHostnameNone only the loadbalancer IPs are reported in the Service status
+	StatusHostnameNone = "none"
+	// StatusHostnameAlongside the loadbalancer name is reported in addition to the IPs
+	StatusHostnameAlongside = "alongside"
+	// StatusHostnameOnly only the loadbalancer name is reported, like the cloud providers
+	// that expose the loadbalancers by hostname, ex. AWS ELB.
+	StatusHostnameOnly = "only"
+)
+
+// hostnameStatus returns the status with the loadbalancer hostname according to the mode
+func hostnameStatus(status *v1.LoadBalancerStatus, hostname string, mode string) *v1.LoadBalancerStatus {
+	if mode != StatusHostnameAlongside && mode != StatusHostnameOnly {
+		return status
+	}
+	result := &v1.LoadBalancerStatus{}
+	if mode == StatusHostnameAlongside {
+		result.Ingress = append(result.Ingress, status.Ingress...)
+	}
+	ingress := v1.LoadBalancerIngress{Hostname: hostname}
+	// all the ingresses have the same ports
+	if len(status.Ingress) > 0 {
+		ingress.Ports = status.Ingress[0].Ports
+	}
+	result.Ingress = append(result.Ingress, ingress)
+	return result
+}
+
+// hostname returns the name of the loadbalancer on the dev domain
+func (d *DevDomain) hostname(service *v1.Service) string {
+	return service.Name + "." + service.Namespace + "." + d.DNS.Domain()
+}
+
+// tlsSecretName returns the name of the Secret with the loadbalancer certificate
+func tlsSecretName(service *v1.Service) string {
+	return service.Name + constants.DevDomainSecretSuffix
+}
+
+// publish registers the loadbalancer addresses on the DNS server and ensures a valid
+// certificate exists for its name and addresses.
+func (d *DevDomain) publish(ctx context.Context, kubeClient kubernetes.Interface, service *v1.Service, status *v1.LoadBalancerStatus) error {
+	ips := []net.IP{}
+	for _, ingress := range status.Ingress {
+		if ip := net.ParseIP(ingress.IP); ip != nil {
+			ips = append(ips, ip)
+		}
+	}
+	name := d.hostname(service)
+	klog.FromContext(ctx).V(2).Info("Publishing loadbalancer on the dev domain", "hostname", name, "addresses", ips)
+	d.DNS.SetRecord(name, ips)
+	if d.CA == nil || kubeClient == nil {
+		return nil
+	}
+
+	secretName := tlsSecretName(service)
+	secret, err := kubeClient.CoreV1().Secrets(service.Namespace).Get(ctx, secretName, metav1.GetOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return err
+	}
+	found := err == nil
+	if found {
+		if secret.Labels[constants.ManagedByLabelKey] != constants.ManagedByLabelValue {
+			return fmt.Errorf("secret %s/%s already exists and is not managed by %s", service.Namespace, secretName, constants.ManagedByLabelValue)
+		}
+		if certificateValid(secret.Data[v1.TLSCertKey], name, ips) {
+			return nil
+		}
+	}
+
+	certPEM, keyPEM, err := d.CA.Issue([]string{name}, ips)
+	if err != nil {
+		return err
+	}
+	newSecret := &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      secretName,
+			Namespace: service.Namespace,
+			Labels:    map[string]string{constants.ManagedByLabelKey: constants.ManagedByLabelValue},
+			// the Secret is garbage collected when the Service is deleted
+			OwnerReferences: []metav1.OwnerReference{{
+				APIVersion: "v1",
+				Kind:       "Service",
+				Name:       service.Name,
+				UID:        service.UID,
+			}},
+		},
+		Type: v1.SecretTypeTLS,
+		Data: map[string][]byte{
+			v1.TLSCertKey:       certPEM,
+			v1.TLSPrivateKeyKey: keyPEM,
+			"ca.crt":            d.CA.CertificatePEM(),
+		},
+	}
+	if found {
+		newSecret.ResourceVersion = secret.ResourceVersion
+		_, err = kubeClient.CoreV1().Secrets(service.Namespace).Update(ctx, newSecret, metav1.UpdateOptions{})
+	} else {
+		_, err = kubeClient.CoreV1().Secrets(service.Namespace).Create(ctx, newSecret, metav1.CreateOptions{})
+	}
+	return err
+}
+
+// unpublish removes the loadbalancer from the DNS server, the Secret is garbage collected
+// with the Service.
+func (d *DevDomain) unpublish(service *v1.Service) {
+	d.DNS.DeleteRecord(d.hostname(service))
+}
+
+// certificateValid returns true if the certificate is valid for the name and the addresses
+// and it is not going to expire soon.
+func certificateValid(certPEM []byte, name string, ips []net.IP) bool {
+	block, _ := pem.Decode(certPEM)
+	if block == nil {
+		return false
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return false
+	}
+	if time.Now().Add(30 * 24 * time.Hour).After(cert.NotAfter) {
+		return false
+	}
+	if !slices.Equal(cert.DNSNames, []string{name}) || len(cert.IPAddresses) != len(ips) {
+		return false
+	}
+	for i := range ips {
+		if !cert.IPAddresses[i].Equal(ips[i]) {
+			return false
+		}
+	}
+	return true
+}
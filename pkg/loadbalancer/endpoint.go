@@ -0,0 +1,109 @@
+package loadbalancer
+
+import (
+	"fmt"
+	"net/netip"
+	"strconv"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+type endpoint struct {
+	Address  address
+	Port     int
+	Protocol string
+}
+
+// YAMLAddress returns the address as a YAML scalar, kept for the user templates
+func (e endpoint) YAMLAddress() string {
+	return e.Address.YAML()
+}
+
+// address is the address of an endpoint, an IP address or the hostname of a node that is
+// resolved by the proxy. The zero value is not a valid address.
+type address struct {
+	ip       netip.Addr
+	hostname string
+}
+
+// ipAddress returns the address of the IP, the IPv4-mapped IPv6 addresses are unmapped
+// so they are rendered as IPv4
+func ipAddress(ip netip.Addr) address {
+	return address{ip: ip.Unmap()}
+}
+
+// parseAddress parses an IP address or a hostname
+func parseAddress(value string) (address, error) {
+	if ip, err := netip.ParseAddr(value); err == nil {
+		return ipAddress(ip), nil
+	}
+	if errs := validation.IsDNS1123Subdomain(value); len(errs) > 0 {
+		return address{}, fmt.Errorf("invalid address %q: %s", value, strings.Join(errs, ", "))
+	}
+	return address{hostname: value}, nil
+}
+
+// mustParseAddress is like parseAddress but panics if the address is not valid, for the
+// addresses known in advance
+func mustParseAddress(value string) address {
+	a, err := parseAddress(value)
+	if err != nil {
+		panic(err)
+	}
+	return a
+}
+
+// IsValid returns true if the address is an IP address or a hostname
+func (a address) IsValid() bool {
+	return a.ip.IsValid() || a.hostname != ""
+}
+
+// IP returns the IP address, not valid if the address is a hostname
+func (a address) IP() netip.Addr {
+	return a.ip
+}
+
+func (a address) String() string {
+	if a.ip.IsValid() {
+		return a.ip.String()
+	}
+	return a.hostname
+}
+
+// Equal returns true if the addresses are the same, it is also used by go-cmp
+func (a address) Equal(b address) bool {
+	return a == b
+}
+
+// YAML returns the address as a YAML scalar, the IPv6 addresses are quoted because the
+// YAML parser fails with the addresses starting with colons, ex. "::"
+func (a address) YAML() string {
+	if a.ip.Is6() {
+		return strconv.Quote(a.ip.String())
+	}
+	return a.String()
+}
+
+// MarshalText implements encoding.TextMarshaler, the addresses are encoded as strings in
+// JSON and YAML
+func (a address) MarshalText() ([]byte, error) {
+	if !a.IsValid() {
+		return []byte{}, nil
+	}
+	return []byte(a.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler
+func (a *address) UnmarshalText(text []byte) error {
+	if len(text) == 0 {
+		*a = address{}
+		return nil
+	}
+	parsed, err := parseAddress(string(text))
+	if err != nil {
+		return err
+	}
+	*a = parsed
+	return nil
+}
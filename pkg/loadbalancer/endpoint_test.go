@@ -0,0 +1,66 @@
+package loadbalancer
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func Test_parseAddress(t *testing.T) {
+	tests := []struct {
+		value    string
+		want     string
+		wantYAML string
+		wantErr  bool
+	}{
+		{value: "0.0.0.0", want: "0.0.0.0", wantYAML: "0.0.0.0"},
+		{value: "192.168.8.2", want: "192.168.8.2", wantYAML: "192.168.8.2"},
+		{value: "::", want: "::", wantYAML: `"::"`},
+		{value: "fd00:10:244::2", want: "fd00:10:244::2", wantYAML: `"fd00:10:244::2"`},
+		{value: "::ffff:192.168.8.2", want: "192.168.8.2", wantYAML: "192.168.8.2"},
+		{value: "kind-worker", want: "kind-worker", wantYAML: "kind-worker"},
+		{value: "", wantErr: true},
+		{value: "bad address", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := parseAddress(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseAddress() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if got.String() != tt.want {
+				t.Errorf("parseAddress() = %v, want %v", got, tt.want)
+			}
+			if got.YAML() != tt.wantYAML {
+				t.Errorf("YAML() = %v, want %v", got.YAML(), tt.wantYAML)
+			}
+		})
+	}
+}
+
+func Test_addressJSON(t *testing.T) {
+	in := []endpoint{
+		{Address: mustParseAddress("::"), Port: 80, Protocol: "TCP"},
+		{Address: mustParseAddress("kind-worker"), Port: 30000, Protocol: "TCP"},
+	}
+	data, err := json.Marshal(in)
+	if err != nil {
+		t.Fatalf("json.Marshal() error = %v", err)
+	}
+	want := `[{"Address":"::","Port":80,"Protocol":"TCP"},{"Address":"kind-worker","Port":30000,"Protocol":"TCP"}]`
+	if string(data) != want {
+		t.Errorf("json.Marshal() = %s, want %s", data, want)
+	}
+	var out []endpoint
+	if err := json.Unmarshal(data, &out); err != nil {
+		t.Fatalf("json.Unmarshal() error = %v", err)
+	}
+	if len(out) != len(in) || out[0] != in[0] || out[1] != in[1] {
+		t.Errorf("json.Unmarshal() = %+v, want %+v", out, in)
+	}
+	if err := json.Unmarshal([]byte(`[{"Address":"bad address"}]`), &out); err == nil {
+		t.Errorf("json.Unmarshal() accepted an invalid address")
+	}
+}
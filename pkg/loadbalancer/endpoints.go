@@ -0,0 +1,86 @@
+package loadbalancer
+
+import (
+	"context"
+	"fmt"
+
+	v1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/klog/v2"
+
+	"sigs.k8s.io/cloud-provider-kind/pkg/constants"
+)
+
+// The loadbalancers send the traffic to the NodePorts, that is external traffic for the
+// nodes: the externalTrafficPolicy decides which endpoints a node forwards it to, and the
+// internalTrafficPolicy, that only applies to the traffic of the cluster IP, does not
+// change the backends. With externalTrafficPolicy Cluster every node forwards to all the
+// endpoints of the Service, with Local a node only forwards to its own endpoints and fails
+// the health checks of the healthCheckNodePort without them.
+
+// endpointNodes returns the names of the nodes with usable endpoints of the Service, and if
+// the Service has usable endpoints at all, some endpoints may not report their node. Like
+// kube-proxy the endpoints are usable if they are ready, or serving while terminating. The
+// endpoints of the Services with publishNotReadyAddresses are always reported ready.
+func endpointNodes(slices []*discoveryv1.EndpointSlice) (sets.Set[string], bool) {
+	nodes := sets.New[string]()
+	found := false
+	for _, slice := range slices {
+		for _, e := range slice.Endpoints {
+			if !usableEndpoint(e) {
+				continue
+			}
+			found = true
+			if e.NodeName != nil {
+				nodes.Insert(*e.NodeName)
+			}
+		}
+	}
+	return nodes, found
+}
+
+// usableEndpoint returns true if the endpoint receives the traffic of the nodes, the ready
+// endpoints or the serving ones that are terminating, the conditions not reported are true
+func usableEndpoint(e discoveryv1.Endpoint) bool {
+	conditions := e.Conditions
+	if conditions.Ready == nil || *conditions.Ready {
+		return true
+	}
+	return conditions.Serving != nil && *conditions.Serving && conditions.Terminating != nil && *conditions.Terminating
+}
+
+// checkEndpoints returns a NoEndpoints error if the traffic of the loadbalancer can not
+// reach any endpoint of the Service: the Service has no usable endpoints or, with
+// externalTrafficPolicy Local, none of the backend nodes has one. The Services without
+// selector, that manage their own endpoints, and the ones with external backends are not
+// checked, neither are the Services if the EndpointSlices can not be listed. The
+// EndpointSlices are read from the informer cache, the service controller syncs the
+// Service again when they change.
+func (s *Server) checkEndpoints(ctx context.Context, service *v1.Service, nodes []*v1.Node) error {
+	if s.endpointSliceLister == nil || len(service.Spec.Selector) == 0 {
+		return nil
+	}
+	if _, ok := service.Annotations[constants.ExternalBackendsAnnotation]; ok {
+		return nil
+	}
+	slices, err := s.endpointSliceLister.EndpointSlices(service.Namespace).List(labels.SelectorFromSet(labels.Set{discoveryv1.LabelServiceName: service.Name}))
+	if err != nil {
+		klog.FromContext(ctx).V(2).Info("Can not list the endpoints of the Service", "err", err)
+		return nil
+	}
+	withEndpoints, found := endpointNodes(slices)
+	if !found {
+		return provisioningError(ReasonNoEndpoints, fmt.Errorf("the Service has no ready endpoints"))
+	}
+	if service.Spec.ExternalTrafficPolicy != v1.ServiceExternalTrafficPolicyTypeLocal {
+		return nil
+	}
+	for _, node := range backendNodes(nodes) {
+		if withEndpoints.Has(node.Name) {
+			return nil
+		}
+	}
+	return provisioningError(ReasonNoEndpoints, fmt.Errorf("none of the loadbalancer backend nodes has a ready endpoint of the Service, with externalTrafficPolicy Local the nodes only forward the traffic to their own endpoints"))
+}
@@ -0,0 +1,107 @@
+package loadbalancer
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	v1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	discoverylisters "k8s.io/client-go/listers/discovery/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/utils/ptr"
+)
+
+func makeEndpoint(node string, ready, serving, terminating bool) discoveryv1.Endpoint {
+	return discoveryv1.Endpoint{
+		Addresses:  []string{"10.244.0.1"},
+		NodeName:   ptr.To(node),
+		Conditions: discoveryv1.EndpointConditions{Ready: ptr.To(ready), Serving: ptr.To(serving), Terminating: ptr.To(terminating)},
+	}
+}
+
+func Test_endpointNodes(t *testing.T) {
+	tests := []struct {
+		name      string
+		endpoints []discoveryv1.Endpoint
+		wantNodes []string
+		wantFound bool
+	}{
+		{name: "no endpoints", wantNodes: []string{}},
+		{
+			name:      "ready",
+			endpoints: []discoveryv1.Endpoint{makeEndpoint("a", true, true, false), makeEndpoint("b", false, false, false)},
+			wantNodes: []string{"a"},
+			wantFound: true,
+		},
+		{
+			name:      "serving while terminating",
+			endpoints: []discoveryv1.Endpoint{makeEndpoint("a", false, true, true), makeEndpoint("b", false, true, false)},
+			wantNodes: []string{"a"},
+			wantFound: true,
+		},
+		{
+			name:      "conditions not reported",
+			endpoints: []discoveryv1.Endpoint{{Addresses: []string{"10.244.0.1"}}},
+			wantNodes: []string{},
+			wantFound: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			nodes, found := endpointNodes([]*discoveryv1.EndpointSlice{{Endpoints: tt.endpoints}})
+			if diff := cmp.Diff(tt.wantNodes, sets.List(nodes)); diff != "" {
+				t.Errorf("endpointNodes() mismatch (-want +got):\n%s", diff)
+			}
+			if found != tt.wantFound {
+				t.Errorf("endpointNodes() found = %v, want %v", found, tt.wantFound)
+			}
+		})
+	}
+}
+
+func Test_checkEndpoints(t *testing.T) {
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	s := &Server{endpointSliceLister: discoverylisters.NewEndpointSliceLister(indexer)}
+	// the EndpointSlice of another Service with ready endpoints is ignored
+	indexer.Add(&discoveryv1.EndpointSlice{ // nolint:errcheck
+		ObjectMeta: metav1.ObjectMeta{Name: "other-abcde", Namespace: "default", Labels: map[string]string{discoveryv1.LabelServiceName: "other"}},
+		Endpoints:  []discoveryv1.Endpoint{makeEndpoint("a", true, true, false)},
+	})
+	nodes := []*v1.Node{makeNode("a", "10.0.0.1"), makeNode("b", "10.0.0.2")}
+
+	tests := []struct {
+		name      string
+		policy    v1.ServiceExternalTrafficPolicy
+		selector  map[string]string
+		endpoints []discoveryv1.Endpoint
+		wantErr   bool
+	}{
+		{name: "without selector"},
+		{name: "no endpoints", selector: map[string]string{"app": "web"}, wantErr: true},
+		{name: "not ready endpoints", selector: map[string]string{"app": "web"}, endpoints: []discoveryv1.Endpoint{makeEndpoint("a", false, false, false)}, wantErr: true},
+		{name: "endpoints on other nodes", selector: map[string]string{"app": "web"}, endpoints: []discoveryv1.Endpoint{makeEndpoint("c", true, true, false)}},
+		{name: "local endpoints", policy: v1.ServiceExternalTrafficPolicyLocal, selector: map[string]string{"app": "web"}, endpoints: []discoveryv1.Endpoint{makeEndpoint("b", true, true, false)}},
+		{name: "no local endpoints", policy: v1.ServiceExternalTrafficPolicyLocal, selector: map[string]string{"app": "web"}, endpoints: []discoveryv1.Endpoint{makeEndpoint("c", true, true, false)}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			indexer.Update(&discoveryv1.EndpointSlice{ // nolint:errcheck
+				ObjectMeta: metav1.ObjectMeta{Name: "web-abcde", Namespace: "default", Labels: map[string]string{discoveryv1.LabelServiceName: "web"}},
+				Endpoints:  tt.endpoints,
+			})
+			service := &v1.Service{
+				ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default"},
+				Spec:       v1.ServiceSpec{Selector: tt.selector, ExternalTrafficPolicy: tt.policy},
+			}
+			err := s.checkEndpoints(context.Background(), service, nodes)
+			var provisioningErr *ProvisioningError
+			if tt.wantErr != (errors.As(err, &provisioningErr) && provisioningErr.Reason == ReasonNoEndpoints) {
+				t.Errorf("checkEndpoints() error = %v, want NoEndpoints %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,668 @@
+package loadbalancer
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"sort"
+	"time"
+
+	xdscorev3 "github.com/cncf/xds/go/xds/core/v3"
+	xdsmatcherv3 "github.com/cncf/xds/go/xds/type/matcher/v3"
+	bootstrapv3 "github.com/envoyproxy/go-control-plane/envoy/config/bootstrap/v3"
+	clusterv3 "github.com/envoyproxy/go-control-plane/envoy/config/cluster/v3"
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	endpointv3 "github.com/envoyproxy/go-control-plane/envoy/config/endpoint/v3"
+	listenerv3 "github.com/envoyproxy/go-control-plane/envoy/config/listener/v3"
+	overloadv3 "github.com/envoyproxy/go-control-plane/envoy/config/overload/v3"
+	routev3 "github.com/envoyproxy/go-control-plane/envoy/config/route/v3"
+	routerv3 "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/http/router/v3"
+	hcmv3 "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/network/http_connection_manager/v3"
+	tcpproxyv3 "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/network/tcp_proxy/v3"
+	udpproxyv3 "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/udp/udp_proxy/v3"
+	fixedheapv3 "github.com/envoyproxy/go-control-plane/envoy/extensions/resource_monitors/fixed_heap/v3"
+	quicv3 "github.com/envoyproxy/go-control-plane/envoy/extensions/transport_sockets/quic/v3"
+	tlsv3 "github.com/envoyproxy/go-control-plane/envoy/extensions/transport_sockets/tls/v3"
+	httpv3 "github.com/envoyproxy/go-control-plane/envoy/extensions/upstreams/http/v3"
+	typev3 "github.com/envoyproxy/go-control-plane/envoy/type/v3"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/anypb"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+	"sigs.k8s.io/yaml"
+)
+
+// proxyAdminPort is the port of the envoy admin API, only bound on the loopback address
+const proxyAdminPort = 9901
+
+// maxUDPDatagramSize is the size of the UDP datagrams received by the proxy, the default
+// of envoy is smaller than the jumbo frames
+const maxUDPDatagramSize = 9000
+
+// the timings of the backends health checks
+const (
+	healthCheckTimeout  = 5 * time.Second
+	healthCheckInterval = 3 * time.Second
+)
+
+// defaultConnectTimeout is the timeout of the connections to the backends without the
+// connect timeout annotation
+const defaultConnectTimeout = "5s"
+
+// the overload manager shrinks the heap and then stops accepting connections when the
+// heap usage reaches these fractions of the max heap size
+const (
+	overloadRefreshInterval          = 250 * time.Millisecond
+	overloadShrinkHeapThreshold      = 0.9
+	overloadStopConnectionsThreshold = 0.95
+	fixedHeapResourceMonitor         = "envoy.resource_monitors.fixed_heap"
+)
+
+// bootstrapConfig returns the envoy bootstrap config with the listeners of the Service
+// ports, the clusters are loaded from their own file.
+func bootstrapConfig(data *proxyConfigData) (string, error) {
+	out, err := bootstrapMap(data)
+	if err != nil {
+		return "", err
+	}
+	return marshalYAML(out)
+}
+
+// bootstrapMap returns the envoy bootstrap config encoded, with the extra listener filters
+// that are not typed
+func bootstrapMap(data *proxyConfigData) (map[string]interface{}, error) {
+	keys := servicePortKeys(data)
+	bootstrap := &bootstrapv3.Bootstrap{
+		Admin: &bootstrapv3.Admin{
+			Address: socketAddress("127.0.0.1", proxyAdminPort, corev3.SocketAddress_TCP),
+		},
+		Node: &corev3.Node{
+			Cluster: "cloud-provider-kind",
+			Id:      "cloud-provider-kind",
+		},
+		DynamicResources: &bootstrapv3.Bootstrap_DynamicResources{
+			CdsConfig: &corev3.ConfigSource{
+				ResourceApiVersion: corev3.ApiVersion_V3,
+				ConfigSourceSpecifier: &corev3.ConfigSource_PathConfigSource{
+					PathConfigSource: &corev3.PathConfigSource{Path: proxyClustersPath},
+				},
+			},
+		},
+		StaticResources: &bootstrapv3.Bootstrap_StaticResources{},
+	}
+	if len(data.DNSResolvers) > 0 {
+		resolver, err := dnsResolverConfig(data.DNSResolvers)
+		if err != nil {
+			return nil, err
+		}
+		bootstrap.TypedDnsResolverConfig = resolver
+	}
+	if data.MaxHeapSize > 0 {
+		overloadManager, err := overloadManagerConfig(data.MaxHeapSize)
+		if err != nil {
+			return nil, err
+		}
+		bootstrap.OverloadManager = overloadManager
+	}
+	for _, key := range keys {
+		listener, err := listenerConfig(key, data.ServicePorts[key], data)
+		if err != nil {
+			return nil, err
+		}
+		bootstrap.StaticResources.Listeners = append(bootstrap.StaticResources.Listeners, listener)
+	}
+	if err := bootstrap.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid bootstrap config: %w", err)
+	}
+
+	out, err := protoMap(bootstrap)
+	if err != nil {
+		return nil, err
+	}
+	// the extra listener filters are not typed, they are added to the encoded config
+	// before the TCP proxy filter
+	if listeners, ok := out["static_resources"].(map[string]interface{})["listeners"].([]interface{}); ok {
+		for i, key := range keys {
+			if err := addListenerFilters(listeners[i].(map[string]interface{}), data.ServicePorts[key].ListenerFilters); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return out, nil
+}
+
+// clustersConfig returns the clusters of the Service ports, they are loaded dynamically
+// so changing the backends or the health checks, ex. when the ExternalTrafficPolicy or the
+// HealthCheckNodePort of the Service change, does not require to restart the proxy.
+func clustersConfig(data *proxyConfigData) (string, error) {
+	resources := []interface{}{}
+	for _, key := range servicePortKeys(data) {
+		servicePort := data.ServicePorts[key]
+		cluster, err := clusterConfig(key, servicePort, data)
+		if err != nil {
+			return "", err
+		}
+		if err := cluster.Validate(); err != nil {
+			return "", fmt.Errorf("invalid cluster %s: %w", cluster.Name, err)
+		}
+		resource, err := anypb.New(cluster)
+		if err != nil {
+			return "", err
+		}
+		out, err := protoMap(resource)
+		if err != nil {
+			return "", err
+		}
+		// the extra cluster fields are not typed, they are added to the encoded cluster
+		for name, value := range servicePort.ClusterOptions {
+			var v interface{}
+			if err := json.Unmarshal([]byte(value), &v); err != nil {
+				return "", fmt.Errorf("invalid value of cluster field %s: %w", name, err)
+			}
+			out[name] = v
+		}
+		resources = append(resources, out)
+		if servicePort.Mirror != nil {
+			mirrorCluster, err := mirrorClusterConfig(key, servicePort)
+			if err != nil {
+				return "", err
+			}
+			resource, err := anypb.New(mirrorCluster)
+			if err != nil {
+				return "", err
+			}
+			out, err := protoMap(resource)
+			if err != nil {
+				return "", err
+			}
+			resources = append(resources, out)
+		}
+	}
+	return marshalYAML(map[string]interface{}{"resources": resources})
+}
+
+// overloadManagerConfig returns the overload manager of the proxy with the heap size, so
+// a misbehaving client can not make the proxy exceed the memory limit of the container
+func overloadManagerConfig(maxHeapSize uint64) (*overloadv3.OverloadManager, error) {
+	monitor, err := anypb.New(&fixedheapv3.FixedHeapConfig{MaxHeapSizeBytes: maxHeapSize})
+	if err != nil {
+		return nil, err
+	}
+	trigger := func(threshold float64) []*overloadv3.Trigger {
+		return []*overloadv3.Trigger{{
+			Name:         fixedHeapResourceMonitor,
+			TriggerOneof: &overloadv3.Trigger_Threshold{Threshold: &overloadv3.ThresholdTrigger{Value: threshold}},
+		}}
+	}
+	return &overloadv3.OverloadManager{
+		RefreshInterval: durationpb.New(overloadRefreshInterval),
+		ResourceMonitors: []*overloadv3.ResourceMonitor{{
+			Name:       fixedHeapResourceMonitor,
+			ConfigType: &overloadv3.ResourceMonitor_TypedConfig{TypedConfig: monitor},
+		}},
+		Actions: []*overloadv3.OverloadAction{
+			{Name: "envoy.overload_actions.shrink_heap", Triggers: trigger(overloadShrinkHeapThreshold)},
+			{Name: "envoy.overload_actions.stop_accepting_connections", Triggers: trigger(overloadStopConnectionsThreshold)},
+		},
+	}, nil
+}
+
+// http3AltSvcMaxAge is how long the clients remember the HTTP/3 listener advertised by the
+// alt-svc header of the TCP listeners terminating TLS
+const http3AltSvcMaxAge = 24 * time.Hour
+
+// listenerConfig returns the listener of the Service port, with the UDP proxy listener
+// filter, the TCP proxy network filter or the HTTP connection manager terminating TLS
+func listenerConfig(key string, servicePort servicePort, data *proxyConfigData) (*listenerv3.Listener, error) {
+	sessionAffinity := data.SessionAffinity
+	clusterName := "cluster_" + key
+	idleTimeout, err := duration(servicePort.IdleTimeout)
+	if err != nil {
+		return nil, err
+	}
+	listener := &listenerv3.Listener{
+		Name:    "listener_" + key,
+		Address: socketAddress(servicePort.Listener.Address.String(), servicePort.Listener.Port, socketProtocol(servicePort.Listener.Protocol)),
+		// each worker binds its own socket, the TCP and UDP listeners of the same port
+		// are separate listeners and do not conflict
+		EnableReusePort: wrapperspb.Bool(true),
+	}
+
+	if servicePort.HTTP3 == HTTP3Terminate {
+		err := httpListenerConfig(listener, key, servicePort, data)
+		if err != nil {
+			return nil, err
+		}
+		return listener, nil
+	}
+
+	if servicePort.Listener.Protocol == "UDP" {
+		route, err := anypb.New(&udpproxyv3.Route{Cluster: clusterName})
+		if err != nil {
+			return nil, err
+		}
+		udpProxy := &udpproxyv3.UdpProxyConfig{
+			StatPrefix: clusterName,
+			RouteSpecifier: &udpproxyv3.UdpProxyConfig_Matcher{
+				Matcher: &xdsmatcherv3.Matcher{
+					OnNoMatch: &xdsmatcherv3.Matcher_OnMatch{
+						OnMatch: &xdsmatcherv3.Matcher_OnMatch_Action{
+							Action: &xdscorev3.TypedExtensionConfig{Name: "route", TypedConfig: route},
+						},
+					},
+				},
+			},
+			IdleTimeout:          idleTimeout,
+			UpstreamSocketConfig: udpSocketConfig(),
+		}
+		// the QUIC connections survive the changes of the client port, ex. a NAT rebinding
+		if sessionAffinity == "ClientIP" || servicePort.HTTP3 == HTTP3Passthrough {
+			udpProxy.HashPolicies = []*udpproxyv3.UdpProxyConfig_HashPolicy{{
+				PolicySpecifier: &udpproxyv3.UdpProxyConfig_HashPolicy_SourceIp{SourceIp: true},
+			}}
+		}
+		typedConfig, err := anypb.New(udpProxy)
+		if err != nil {
+			return nil, err
+		}
+		listener.UdpListenerConfig = &listenerv3.UdpListenerConfig{DownstreamSocketConfig: udpSocketConfig()}
+		listener.ListenerFilters = []*listenerv3.ListenerFilter{{
+			Name:       "envoy.filters.udp_listener.udp_proxy",
+			ConfigType: &listenerv3.ListenerFilter_TypedConfig{TypedConfig: typedConfig},
+		}}
+		return listener, nil
+	}
+
+	tcpProxy := &tcpproxyv3.TcpProxy{
+		StatPrefix:       "destination",
+		ClusterSpecifier: &tcpproxyv3.TcpProxy_Cluster{Cluster: clusterName},
+		IdleTimeout:      idleTimeout,
+	}
+	if sessionAffinity == "ClientIP" {
+		tcpProxy.HashPolicy = []*typev3.HashPolicy{{
+			PolicySpecifier: &typev3.HashPolicy_SourceIp_{SourceIp: &typev3.HashPolicy_SourceIp{}},
+		}}
+	}
+	typedConfig, err := anypb.New(tcpProxy)
+	if err != nil {
+		return nil, err
+	}
+	if data.BufferLimit > 0 {
+		listener.PerConnectionBufferLimitBytes = wrapperspb.UInt32(data.BufferLimit)
+	}
+	filters, err := sourceFilters(clusterName, servicePort)
+	if err != nil {
+		return nil, err
+	}
+	listener.FilterChains = []*listenerv3.FilterChain{{
+		Filters: append(filters, &listenerv3.Filter{
+			Name:       "envoy.filters.network.tcp_proxy",
+			ConfigType: &listenerv3.Filter_TypedConfig{TypedConfig: typedConfig},
+		}),
+	}}
+	return listener, nil
+}
+
+// sourceFilters returns the filters of the listener before the proxy restricting its
+// clients, if the listener has allowed sources
+func sourceFilters(clusterName string, servicePort servicePort) ([]*listenerv3.Filter, error) {
+	if !servicePort.RestrictSources() {
+		return nil, nil
+	}
+	filter, err := allowedSourcesFilter(clusterName, servicePort.AllowedSources)
+	if err != nil {
+		return nil, err
+	}
+	return []*listenerv3.Filter{filter}, nil
+}
+
+// httpListenerConfig sets the filter chain terminating TLS and HTTP on the listener, HTTP/3
+// on the UDP listeners and HTTP/1.1 and HTTP/2 on the TCP listeners, that advertise the
+// HTTP/3 listener of the same port with the alt-svc header. The requests are copied to the
+// mirror cluster if the port is mirrored.
+func httpListenerConfig(listener *listenerv3.Listener, key string, servicePort servicePort, data *proxyConfigData) error {
+	clusterName := "cluster_" + key
+	idleTimeout, err := duration(servicePort.IdleTimeout)
+	if err != nil {
+		return err
+	}
+	udp := servicePort.Listener.Protocol == "UDP"
+
+	action := &routev3.RouteAction{
+		ClusterSpecifier: &routev3.RouteAction_Cluster{Cluster: clusterName},
+		// the requests are not limited in time, like the proxied connections
+		Timeout: durationpb.New(0),
+	}
+	if servicePort.Mirror != nil {
+		action.RequestMirrorPolicies = requestMirrorPolicies("mirror_"+key, servicePort.Mirror)
+	}
+	if data.SessionAffinity == "ClientIP" {
+		action.HashPolicy = []*routev3.RouteAction_HashPolicy{{
+			PolicySpecifier: &routev3.RouteAction_HashPolicy_ConnectionProperties_{
+				ConnectionProperties: &routev3.RouteAction_HashPolicy_ConnectionProperties{SourceIp: true},
+			},
+		}}
+	}
+	virtualHost := &routev3.VirtualHost{
+		Name:    "backend",
+		Domains: []string{"*"},
+		Routes: []*routev3.Route{{
+			Match:  &routev3.RouteMatch{PathSpecifier: &routev3.RouteMatch_Prefix{Prefix: "/"}},
+			Action: &routev3.Route_Route{Route: action},
+		}},
+	}
+	if !udp {
+		virtualHost.ResponseHeadersToAdd = []*corev3.HeaderValueOption{{
+			Header: &corev3.HeaderValue{
+				Key:   "alt-svc",
+				Value: fmt.Sprintf(`h3=":%d"; ma=%d`, servicePort.Listener.Port, int(http3AltSvcMaxAge.Seconds())),
+			},
+		}}
+	}
+	router, err := anypb.New(&routerv3.Router{})
+	if err != nil {
+		return err
+	}
+	httpConnectionManager := &hcmv3.HttpConnectionManager{
+		StatPrefix: clusterName,
+		RouteSpecifier: &hcmv3.HttpConnectionManager_RouteConfig{
+			RouteConfig: &routev3.RouteConfiguration{Name: clusterName, VirtualHosts: []*routev3.VirtualHost{virtualHost}},
+		},
+		HttpFilters: []*hcmv3.HttpFilter{{
+			Name:       "envoy.filters.http.router",
+			ConfigType: &hcmv3.HttpFilter_TypedConfig{TypedConfig: router},
+		}},
+	}
+	if idleTimeout != nil {
+		httpConnectionManager.CommonHttpProtocolOptions = &corev3.HttpProtocolOptions{IdleTimeout: idleTimeout}
+	}
+	if udp {
+		httpConnectionManager.CodecType = hcmv3.HttpConnectionManager_HTTP3
+		httpConnectionManager.Http3ProtocolOptions = &corev3.Http3ProtocolOptions{}
+	}
+	typedConfig, err := anypb.New(httpConnectionManager)
+	if err != nil {
+		return err
+	}
+
+	// the certificate files are reloaded when they are moved into the config directory
+	tlsContext := &tlsv3.DownstreamTlsContext{
+		CommonTlsContext: &tlsv3.CommonTlsContext{
+			TlsCertificates: []*tlsv3.TlsCertificate{{
+				CertificateChain: &corev3.DataSource{Specifier: &corev3.DataSource_Filename{Filename: servicePort.TLSCertificatePath()}},
+				PrivateKey:       &corev3.DataSource{Specifier: &corev3.DataSource_Filename{Filename: servicePort.TLSPrivateKeyPath()}},
+				WatchedDirectory: &corev3.WatchedDirectory{Path: path.Dir(proxyConfigPath)},
+			}},
+			AlpnProtocols: []string{"h2", "http/1.1"},
+		},
+	}
+	transportSocket := &corev3.TransportSocket{Name: "envoy.transport_sockets.tls"}
+	var transport proto.Message = tlsContext
+	if udp {
+		tlsContext.CommonTlsContext.AlpnProtocols = []string{"h3"}
+		transportSocket.Name = "envoy.transport_sockets.quic"
+		transport = &quicv3.QuicDownstreamTransport{DownstreamTlsContext: tlsContext}
+		listener.UdpListenerConfig = &listenerv3.UdpListenerConfig{
+			DownstreamSocketConfig: udpSocketConfig(),
+			QuicOptions:            &listenerv3.QuicProtocolOptions{},
+		}
+	} else if data.BufferLimit > 0 {
+		listener.PerConnectionBufferLimitBytes = wrapperspb.UInt32(data.BufferLimit)
+	}
+	transportConfig, err := anypb.New(transport)
+	if err != nil {
+		return err
+	}
+	transportSocket.ConfigType = &corev3.TransportSocket_TypedConfig{TypedConfig: transportConfig}
+
+	filters, err := sourceFilters(clusterName, servicePort)
+	if err != nil {
+		return err
+	}
+	listener.FilterChains = []*listenerv3.FilterChain{{
+		Filters: append(filters, &listenerv3.Filter{
+			Name:       "envoy.filters.network.http_connection_manager",
+			ConfigType: &listenerv3.Filter_TypedConfig{TypedConfig: typedConfig},
+		}),
+		TransportSocket: transportSocket,
+	}}
+	return nil
+}
+
+// clusterConfig returns the cluster with the backends of the Service port
+func clusterConfig(key string, servicePort servicePort, data *proxyConfigData) (*clusterv3.Cluster, error) {
+	clusterName := "cluster_" + key
+	connectTimeout := servicePort.ConnectTimeout
+	if connectTimeout == "" {
+		connectTimeout = defaultConnectTimeout
+	}
+	timeout, err := duration(connectTimeout)
+	if err != nil {
+		return nil, err
+	}
+	cluster := &clusterv3.Cluster{
+		Name:                 clusterName,
+		ConnectTimeout:       timeout,
+		ClusterDiscoveryType: &clusterv3.Cluster_Type{Type: clusterv3.Cluster_STATIC},
+		LbPolicy:             clusterv3.Cluster_RANDOM,
+	}
+	if data.BufferLimit > 0 && servicePort.Listener.Protocol != "UDP" {
+		cluster.PerConnectionBufferLimitBytes = wrapperspb.UInt32(data.BufferLimit)
+	}
+	if servicePort.MaxConnections > 0 || servicePort.MaxPendingRequests > 0 {
+		thresholds := &clusterv3.CircuitBreakers_Thresholds{Priority: corev3.RoutingPriority_DEFAULT}
+		if servicePort.MaxConnections > 0 {
+			thresholds.MaxConnections = wrapperspb.UInt32(uint32(servicePort.MaxConnections))
+		}
+		if servicePort.MaxPendingRequests > 0 {
+			thresholds.MaxPendingRequests = wrapperspb.UInt32(uint32(servicePort.MaxPendingRequests))
+		}
+		cluster.CircuitBreakers = &clusterv3.CircuitBreakers{Thresholds: []*clusterv3.CircuitBreakers_Thresholds{thresholds}}
+	}
+	if servicePort.DNSLookupFamily != "" {
+		cluster.ClusterDiscoveryType = &clusterv3.Cluster_Type{Type: clusterv3.Cluster_STRICT_DNS}
+		family, ok := clusterv3.Cluster_DnsLookupFamily_value[servicePort.DNSLookupFamily]
+		if !ok {
+			return nil, fmt.Errorf("invalid DNS lookup family %q", servicePort.DNSLookupFamily)
+		}
+		cluster.DnsLookupFamily = clusterv3.Cluster_DnsLookupFamily(family)
+		if len(servicePort.DNSResolvers) > 0 {
+			cluster.TypedDnsResolverConfig, err = dnsResolverConfig(servicePort.DNSResolvers)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	if servicePort.HealthyPanicThreshold != nil {
+		cluster.CommonLbConfig = &clusterv3.Cluster_CommonLbConfig{
+			HealthyPanicThreshold: &typev3.Percent{Value: *servicePort.HealthyPanicThreshold},
+		}
+	}
+	// the hash policies of the listeners only apply to the ring hash clusters
+	if data.SessionAffinity == "ClientIP" || servicePort.HTTP3 == HTTP3Passthrough {
+		cluster.LbPolicy = clusterv3.Cluster_RING_HASH
+	}
+	// the slow start is only supported by the round robin and least request policies
+	if servicePort.SlowStartWindow != "" && cluster.LbPolicy == clusterv3.Cluster_RANDOM {
+		window, err := duration(servicePort.SlowStartWindow)
+		if err != nil {
+			return nil, err
+		}
+		cluster.LbPolicy = clusterv3.Cluster_ROUND_ROBIN
+		cluster.LbConfig = &clusterv3.Cluster_RoundRobinLbConfig_{
+			RoundRobinLbConfig: &clusterv3.Cluster_RoundRobinLbConfig{
+				SlowStartConfig: &clusterv3.Cluster_SlowStartConfig{SlowStartWindow: window},
+			},
+		}
+	}
+	if servicePort.HealthCheck != healthCheckNone {
+		cluster.HealthChecks = []*corev3.HealthCheck{healthCheckConfig(servicePort.HealthCheck)}
+	}
+	if servicePort.HealthCheck == healthCheckGRPC {
+		// the gRPC health checks require HTTP/2 to the backends
+		options, err := anypb.New(&httpv3.HttpProtocolOptions{
+			UpstreamProtocolOptions: &httpv3.HttpProtocolOptions_ExplicitHttpConfig_{
+				ExplicitHttpConfig: &httpv3.HttpProtocolOptions_ExplicitHttpConfig{
+					ProtocolConfig: &httpv3.HttpProtocolOptions_ExplicitHttpConfig_Http2ProtocolOptions{
+						Http2ProtocolOptions: &corev3.Http2ProtocolOptions{},
+					},
+				},
+			},
+		})
+		if err != nil {
+			return nil, err
+		}
+		cluster.TypedExtensionProtocolOptions = map[string]*anypb.Any{
+			"envoy.extensions.upstreams.http.v3.HttpProtocolOptions": options,
+		}
+	}
+
+	endpoints := []*endpointv3.LocalityLbEndpoints{}
+	for _, backend := range servicePort.Cluster {
+		ep := &endpointv3.Endpoint{
+			Address: socketAddress(backend.Address.String(), backend.Port, socketProtocol(backend.Protocol)),
+		}
+		switch servicePort.HealthCheck {
+		case healthCheckNone:
+		case healthCheckGRPC, healthCheckTCP:
+			// the application port of the backend
+			ep.HealthCheckConfig = &endpointv3.Endpoint_HealthCheckConfig{PortValue: uint32(backend.Port)}
+		default:
+			port := servicePort.HealthCheckPort
+			if port == 0 {
+				port = data.HealthCheckPort
+			}
+			ep.HealthCheckConfig = &endpointv3.Endpoint_HealthCheckConfig{PortValue: uint32(port)}
+		}
+		endpoints = append(endpoints, &endpointv3.LocalityLbEndpoints{
+			LbEndpoints: []*endpointv3.LbEndpoint{{
+				HostIdentifier: &endpointv3.LbEndpoint_Endpoint{Endpoint: ep},
+			}},
+		})
+	}
+	cluster.LoadAssignment = &endpointv3.ClusterLoadAssignment{
+		ClusterName: clusterName,
+		Endpoints:   endpoints,
+	}
+	return cluster, nil
+}
+
+// healthCheckConfig returns the health check of the protocol, the HTTP check of the node
+// health port by default
+func healthCheckConfig(protocol string) *corev3.HealthCheck {
+	healthCheck := &corev3.HealthCheck{
+		Timeout:                      durationpb.New(healthCheckTimeout),
+		Interval:                     durationpb.New(healthCheckInterval),
+		UnhealthyThreshold:           wrapperspb.UInt32(3),
+		HealthyThreshold:             wrapperspb.UInt32(1),
+		AlwaysLogHealthCheckFailures: true,
+		AlwaysLogHealthCheckSuccess:  true,
+	}
+	switch protocol {
+	case healthCheckGRPC:
+		healthCheck.HealthChecker = &corev3.HealthCheck_GrpcHealthCheck_{GrpcHealthCheck: &corev3.HealthCheck_GrpcHealthCheck{}}
+	case healthCheckTCP:
+		healthCheck.HealthChecker = &corev3.HealthCheck_TcpHealthCheck_{TcpHealthCheck: &corev3.HealthCheck_TcpHealthCheck{}}
+	default:
+		healthCheck.HealthChecker = &corev3.HealthCheck_HttpHealthCheck_{HttpHealthCheck: &corev3.HealthCheck_HttpHealthCheck{Path: "/healthz"}}
+	}
+	return healthCheck
+}
+
+func socketAddress(address string, port int, protocol corev3.SocketAddress_Protocol) *corev3.Address {
+	return &corev3.Address{
+		Address: &corev3.Address_SocketAddress{
+			SocketAddress: &corev3.SocketAddress{
+				Address:       address,
+				PortSpecifier: &corev3.SocketAddress_PortValue{PortValue: uint32(port)},
+				Protocol:      protocol,
+			},
+		},
+	}
+}
+
+func socketProtocol(protocol string) corev3.SocketAddress_Protocol {
+	if protocol == "UDP" {
+		return corev3.SocketAddress_UDP
+	}
+	return corev3.SocketAddress_TCP
+}
+
+func udpSocketConfig() *corev3.UdpSocketConfig {
+	return &corev3.UdpSocketConfig{MaxRxDatagramSize: wrapperspb.UInt64(maxUDPDatagramSize)}
+}
+
+// duration parses the timeouts of the Service annotations, nil if it is empty
+func duration(value string) (*durationpb.Duration, error) {
+	if value == "" {
+		return nil, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid timeout %q: %w", value, err)
+	}
+	return durationpb.New(d), nil
+}
+
+// addListenerFilters adds the filters encoded as JSON to the first filter chain of the
+// encoded listener, before the generated proxy filter and after the ones restricting the
+// clients
+func addListenerFilters(listener map[string]interface{}, filters []string) error {
+	if len(filters) == 0 {
+		return nil
+	}
+	chains, ok := listener["filter_chains"].([]interface{})
+	if !ok || len(chains) == 0 {
+		return nil
+	}
+	chain := chains[0].(map[string]interface{})
+	generated, _ := chain["filters"].([]interface{})
+	all := []interface{}{}
+	for _, filter := range filters {
+		var f interface{}
+		if err := json.Unmarshal([]byte(filter), &f); err != nil {
+			return fmt.Errorf("invalid listener filter: %w", err)
+		}
+		all = append(all, f)
+	}
+	if len(generated) == 0 {
+		chain["filters"] = all
+		return nil
+	}
+	last := len(generated) - 1
+	chain["filters"] = append(append(generated[:last:last], all...), generated[last])
+	return nil
+}
+
+// protoMap returns the message encoded as JSON with the field names of the proto files,
+// that are the ones of the envoy YAML config
+func protoMap(m proto.Message) (map[string]interface{}, error) {
+	data, err := protojson.MarshalOptions{UseProtoNames: true}.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+	out := map[string]interface{}{}
+	if err := json.Unmarshal(data, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func marshalYAML(v interface{}) (string, error) {
+	out, err := yaml.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	return string(out), nil
+}
+
+// servicePortKeys returns the keys of the Service ports sorted, so the config is stable
+func servicePortKeys(data *proxyConfigData) []string {
+	keys := []string{}
+	for key := range data.ServicePorts {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
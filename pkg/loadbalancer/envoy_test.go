@@ -0,0 +1,209 @@
+package loadbalancer
+
+import (
+	"encoding/json"
+	"net/netip"
+	"strings"
+	"testing"
+
+	bootstrapv3 "github.com/envoyproxy/go-control-plane/envoy/config/bootstrap/v3"
+	clusterv3 "github.com/envoyproxy/go-control-plane/envoy/config/cluster/v3"
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/testing/protocmp"
+	v1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/yaml"
+)
+
+func sampleProxyConfigData(sessionAffinity string) *proxyConfigData {
+	return &proxyConfigData{
+		HealthCheckPort: 10256,
+		SessionAffinity: sessionAffinity,
+		ServicePorts: map[string]servicePort{
+			"IPv4_80_TCP": {
+				Listener:    endpoint{Address: mustParseAddress("0.0.0.0"), Port: 80, Protocol: string(v1.ProtocolTCP)},
+				Cluster:     []endpoint{{mustParseAddress("10.0.0.1"), 30000, string(v1.ProtocolTCP)}},
+				IdleTimeout: "30s",
+			},
+			"IPv6_53_UDP": {
+				Listener:    endpoint{Address: mustParseAddress("::"), Port: 53, Protocol: string(v1.ProtocolUDP)},
+				Cluster:     []endpoint{{mustParseAddress("fd00::1"), 30001, string(v1.ProtocolUDP)}},
+				IdleTimeout: "0s",
+			},
+		},
+	}
+}
+
+// sampleHTTP3ProxyConfigData terminates HTTP/3 on the TCP port and forwards the QUIC traffic
+// of the UDP port of the sample config
+func sampleHTTP3ProxyConfigData(sessionAffinity string) *proxyConfigData {
+	data := sampleProxyConfigData(sessionAffinity)
+	tcp := data.ServicePorts["IPv4_80_TCP"]
+	tcp.HTTP3, tcp.TLSSecret = HTTP3Terminate, "default/web-tls"
+	data.ServicePorts["IPv4_80_TCP"] = tcp
+	udp := data.ServicePorts["IPv6_53_UDP"]
+	udp.HTTP3 = HTTP3Passthrough
+	data.ServicePorts["IPv6_53_UDP"] = udp
+	withHTTP3Listeners(data.ServicePorts)
+	return data
+}
+
+// parseBootstrap parses the YAML bootstrap config with the envoy API types, so the
+// configs are compared regardless of the formatting and the default values
+func parseBootstrap(t *testing.T, config string) *bootstrapv3.Bootstrap {
+	t.Helper()
+	data, err := yaml.YAMLToJSON([]byte(config))
+	if err != nil {
+		t.Fatalf("invalid bootstrap config: %v\n%s", err, config)
+	}
+	bootstrap := &bootstrapv3.Bootstrap{}
+	if err := protojson.Unmarshal(data, bootstrap); err != nil {
+		t.Fatalf("invalid bootstrap config: %v\n%s", err, config)
+	}
+	return bootstrap
+}
+
+// Test_bootstrapConfigDefaultTemplate checks the default template, the starting point of
+// the user templates, renders the generated config
+func Test_bootstrapConfigDefaultTemplate(t *testing.T) {
+	limited := sampleProxyConfigData("")
+	limited.MaxHeapSize = 96 << 20
+	limited.BufferLimit = 32 << 10
+	http3Limited := sampleHTTP3ProxyConfigData("ClientIP")
+	http3Limited.BufferLimit = 32 << 10
+	allowed := sampleHTTP3ProxyConfigData("")
+	tcp := allowed.ServicePorts["IPv4_80_TCP"]
+	tcp.AllowedSources = []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8"), netip.MustParsePrefix("192.168.1.10/32")}
+	allowed.ServicePorts["IPv4_80_TCP"] = tcp
+	denied := sampleProxyConfigData("")
+	tcp = denied.ServicePorts["IPv4_80_TCP"]
+	tcp.AllowedSources = []netip.Prefix{}
+	denied.ServicePorts["IPv4_80_TCP"] = tcp
+	mirrored := sampleHTTP3ProxyConfigData("")
+	for key, servicePort := range mirrored.ServicePorts {
+		if servicePort.HTTP3 == HTTP3Terminate {
+			servicePort.Mirror = &mirror{NodePort: 30080, Percent: 12.5}
+			mirrored.ServicePorts[key] = servicePort
+		}
+	}
+	resolvers := sampleProxyConfigData("")
+	resolvers.DNSResolvers = []netip.AddrPort{netip.MustParseAddrPort("172.18.0.10:53"), netip.MustParseAddrPort("[fd00::10]:5353")}
+	tests := map[string]*proxyConfigData{
+		"default":              sampleProxyConfigData(""),
+		"ClientIP":             sampleProxyConfigData("ClientIP"),
+		"memory limits":        limited,
+		"HTTP/3":               sampleHTTP3ProxyConfigData(""),
+		"HTTP/3 with ClientIP": http3Limited,
+		"allowed sources":      allowed,
+		"no allowed sources":   denied,
+		"mirror":               mirrored,
+		"DNS resolvers":        resolvers,
+	}
+	for name, data := range tests {
+		t.Run(name, func(t *testing.T) {
+			generated, err := bootstrapConfig(data)
+			if err != nil {
+				t.Fatalf("bootstrapConfig() error = %v", err)
+			}
+			rendered, err := renderTemplate(proxyDefaultConfigTemplate, data)
+			if err != nil {
+				t.Fatalf("renderTemplate() error = %v", err)
+			}
+			got, want := parseBootstrap(t, generated), parseBootstrap(t, rendered)
+			if !proto.Equal(got, want) {
+				t.Errorf("generated config differs from the default template\n%s", cmp.Diff(want, got, protocmp.Transform()))
+			}
+		})
+	}
+}
+
+func Test_clustersConfig(t *testing.T) {
+	data := sampleProxyConfigData("ClientIP")
+	out, err := clustersConfig(data)
+	if err != nil {
+		t.Fatalf("clustersConfig() error = %v", err)
+	}
+	jsonData, err := yaml.YAMLToJSON([]byte(out))
+	if err != nil {
+		t.Fatalf("invalid clusters config: %v\n%s", err, out)
+	}
+	var resources struct {
+		Resources []map[string]interface{} `json:"resources"`
+	}
+	if err := json.Unmarshal(jsonData, &resources); err != nil || len(resources.Resources) != 2 {
+		t.Fatalf("clusters config has %d clusters, want 2: %v\n%s", len(resources.Resources), err, out)
+	}
+	for _, resource := range resources.Resources {
+		delete(resource, "@type")
+		encoded, _ := json.Marshal(resource)
+		cluster := &clusterv3.Cluster{}
+		if err := protojson.Unmarshal(encoded, cluster); err != nil {
+			t.Fatalf("invalid cluster: %v\n%s", err, out)
+		}
+		if err := cluster.Validate(); err != nil {
+			t.Errorf("invalid cluster %s: %v", cluster.Name, err)
+		}
+		if cluster.LbPolicy != clusterv3.Cluster_RING_HASH {
+			t.Errorf("cluster %s lb_policy = %v, want RING_HASH with ClientIP affinity", cluster.Name, cluster.LbPolicy)
+		}
+	}
+
+	empty, err := clustersConfig(&proxyConfigData{})
+	if err != nil {
+		t.Fatalf("clustersConfig() error = %v", err)
+	}
+	if empty != "resources: []\n" {
+		t.Errorf("clustersConfig() without ports = %q", empty)
+	}
+
+	data.BufferLimit = 32 << 10
+	limited, err := clustersConfig(data)
+	if err != nil {
+		t.Fatalf("clustersConfig() error = %v", err)
+	}
+	// only the TCP clusters have connection buffers
+	if n := strings.Count(limited, "per_connection_buffer_limit_bytes: 32768"); n != 1 {
+		t.Errorf("clustersConfig() with a buffer limit has %d limited clusters, want 1\n%s", n, limited)
+	}
+}
+
+// Test_bootstrapConfigSamePort checks the TCP and UDP listeners of the same port are
+// separate listeners with their own sockets, ex. for DNS
+func Test_bootstrapConfigSamePort(t *testing.T) {
+	data := &proxyConfigData{
+		ServicePorts: map[string]servicePort{
+			"IPv4_53_TCP": {
+				Listener: endpoint{Address: mustParseAddress("0.0.0.0"), Port: 53, Protocol: string(v1.ProtocolTCP)},
+				Cluster:  []endpoint{{mustParseAddress("10.0.0.1"), 30053, string(v1.ProtocolTCP)}},
+			},
+			"IPv4_53_UDP": {
+				Listener: endpoint{Address: mustParseAddress("0.0.0.0"), Port: 53, Protocol: string(v1.ProtocolUDP)},
+				Cluster:  []endpoint{{mustParseAddress("10.0.0.1"), 30053, string(v1.ProtocolUDP)}},
+			},
+		},
+	}
+	config, err := bootstrapConfig(data)
+	if err != nil {
+		t.Fatalf("bootstrapConfig() error = %v", err)
+	}
+	listeners := parseBootstrap(t, config).GetStaticResources().GetListeners()
+	if len(listeners) != 2 {
+		t.Fatalf("bootstrapConfig() has %d listeners, want 2\n%s", len(listeners), config)
+	}
+	protocols := map[string]string{}
+	for _, listener := range listeners {
+		address := listener.GetAddress().GetSocketAddress()
+		if address.GetPortValue() != 53 {
+			t.Errorf("listener %s port = %d, want 53", listener.Name, address.GetPortValue())
+		}
+		if !listener.GetEnableReusePort().GetValue() {
+			t.Errorf("listener %s does not reuse the port", listener.Name)
+		}
+		protocols[listener.Name] = address.GetProtocol().String()
+	}
+	want := map[string]string{"listener_IPv4_53_TCP": "TCP", "listener_IPv4_53_UDP": "UDP"}
+	if diff := cmp.Diff(want, protocols); diff != "" {
+		t.Errorf("bootstrapConfig() listeners mismatch (-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,98 @@
+package loadbalancer
+
+import (
+	"net"
+	"strconv"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+
+	"sigs.k8s.io/cloud-provider-kind/pkg/constants"
+)
+
+// The external backends front hosts outside the cluster with a loadbalancer, ex. a database
+// of the host or of another network in hybrid test environments, like the ExternalName
+// Services do for the DNS names. The traffic of the ports with external backends is not sent
+// to the nodes, the hostnames are resolved by the proxy.
+
+// parseExternalBackends returns the backends of the annotation by port, the empty selector
+// is the one of all the Service ports, or nil if the Service is not annotated. The invalid
+// entries are ignored.
+func parseExternalBackends(service *v1.Service) map[string][]endpoint {
+	value, ok := service.Annotations[constants.ExternalBackendsAnnotation]
+	if !ok {
+		return nil
+	}
+	backends := map[string][]endpoint{}
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		selector, hostPort, found := strings.Cut(entry, "=")
+		if !found {
+			selector, hostPort = "", entry
+		}
+		backend, err := parseHostPort(strings.TrimSpace(hostPort))
+		if err != nil {
+			klog.InfoS("Ignoring invalid external backend", "service", klog.KObj(service), "annotation", constants.ExternalBackendsAnnotation, "value", entry, "err", err)
+			continue
+		}
+		selector = strings.TrimSpace(selector)
+		backends[selector] = append(backends[selector], backend)
+	}
+	return backends
+}
+
+// parseHostPort parses HOST:PORT, the IPv6 addresses are in brackets
+func parseHostPort(value string) (endpoint, error) {
+	host, port, err := net.SplitHostPort(value)
+	if err != nil {
+		return endpoint{}, err
+	}
+	address, err := parseAddress(host)
+	if err != nil {
+		return endpoint{}, err
+	}
+	number, err := strconv.Atoi(port)
+	if err != nil || number <= 0 || number > 65535 {
+		return endpoint{}, &net.AddrError{Err: "invalid port", Addr: value}
+	}
+	return endpoint{Address: address, Port: number}, nil
+}
+
+// portExternalBackends returns the external backends of the Service port, by number or
+// name, preferring the entries of the port over the ones of all the ports, or false if
+// the port is forwarded to the nodes
+func portExternalBackends(backends map[string][]endpoint, port v1.ServicePort) ([]endpoint, bool) {
+	selectors := []string{strconv.Itoa(int(port.Port)), ""}
+	if port.Name != "" {
+		selectors = append([]string{port.Name}, selectors...)
+	}
+	for _, selector := range selectors {
+		if endpoints, ok := backends[selector]; ok {
+			result := make([]endpoint, 0, len(endpoints))
+			for _, e := range endpoints {
+				e.Protocol = string(port.Protocol)
+				result = append(result, e)
+			}
+			return result, true
+		}
+	}
+	return nil, false
+}
+
+// externalHealthCheck returns the health check of the external backends, they do not serve
+// the kube-proxy health port: the TCP ports are checked with a connection to the backend
+// port unless the annotation sets another check, the UDP ports are not checked
+func externalHealthCheck(port v1.ServicePort, healthCheck string) string {
+	switch {
+	case port.Protocol != v1.ProtocolTCP:
+		return healthCheckNone
+	case healthCheck == "":
+		return healthCheckTCP
+	default:
+		return healthCheck
+	}
+}
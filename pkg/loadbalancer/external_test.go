@@ -0,0 +1,93 @@
+package loadbalancer
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"sigs.k8s.io/cloud-provider-kind/pkg/constants"
+)
+
+func Test_parseExternalBackends(t *testing.T) {
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		want        map[string][]endpoint
+	}{
+		{name: "not annotated"},
+		{
+			name:        "all the ports",
+			annotations: map[string]string{constants.ExternalBackendsAnnotation: "db.example.com:5432, 10.0.0.1:5432"},
+			want: map[string][]endpoint{"": {
+				{Address: mustParseAddress("db.example.com"), Port: 5432},
+				{Address: mustParseAddress("10.0.0.1"), Port: 5432},
+			}},
+		},
+		{
+			name:        "by port",
+			annotations: map[string]string{constants.ExternalBackendsAnnotation: "metrics=[2001:db8::1]:9090,443=host.docker.internal:8443"},
+			want: map[string][]endpoint{
+				"metrics": {{Address: mustParseAddress("2001:db8::1"), Port: 9090}},
+				"443":     {{Address: mustParseAddress("host.docker.internal"), Port: 8443}},
+			},
+		},
+		{
+			name:        "invalid entries",
+			annotations: map[string]string{constants.ExternalBackendsAnnotation: "db.example.com,10.0.0.1:70000,Bad_Host:80,443=10.0.0.2:443"},
+			want:        map[string][]endpoint{"443": {{Address: mustParseAddress("10.0.0.2"), Port: 443}}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service := &v1.Service{ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default", Annotations: tt.annotations}}
+			if diff := cmp.Diff(tt.want, parseExternalBackends(service)); diff != "" {
+				t.Errorf("parseExternalBackends() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func Test_generateConfigExternalBackends(t *testing.T) {
+	nodes := []*v1.Node{makeNode("a", "10.0.0.1")}
+	service := makeHTTP3Service(map[string]string{
+		constants.ExternalBackendsAnnotation: "db=db.example.com:5432,53=192.168.1.1:53",
+		constants.DNSResolversAnnotation:     "10.96.0.10",
+	},
+		v1.ServicePort{Name: "db", Port: 5432, Protocol: v1.ProtocolTCP},
+		v1.ServicePort{Name: "dns", Port: 53, Protocol: v1.ProtocolUDP},
+		v1.ServicePort{Name: "http", Port: 80, NodePort: 30080, Protocol: v1.ProtocolTCP},
+	)
+	data := generateConfig(service, nodes)
+
+	db := data.ServicePorts["IPv4_5432_TCP"]
+	if diff := cmp.Diff([]endpoint{{Address: mustParseAddress("db.example.com"), Port: 5432, Protocol: "TCP"}}, db.Cluster); diff != "" {
+		t.Errorf("generateConfig() TCP backends mismatch (-want +got):\n%s", diff)
+	}
+	if db.HealthCheck != healthCheckTCP || db.DNSLookupFamily != "V4_ONLY" || len(db.DNSResolvers) != 1 {
+		t.Errorf("generateConfig() TCP port = %+v, want TCP health checks and the hostname resolved with the resolvers", db)
+	}
+	dns := data.ServicePorts["IPv4_53_UDP"]
+	if diff := cmp.Diff([]endpoint{{Address: mustParseAddress("192.168.1.1"), Port: 53, Protocol: "UDP"}}, dns.Cluster); diff != "" {
+		t.Errorf("generateConfig() UDP backends mismatch (-want +got):\n%s", diff)
+	}
+	if dns.HealthCheck != healthCheckNone || dns.DNSLookupFamily != "" {
+		t.Errorf("generateConfig() UDP port = %+v, want no health checks and static backends", dns)
+	}
+	// the ports without entries keep the nodes
+	if diff := cmp.Diff([]endpoint{{Address: mustParseAddress("10.0.0.1"), Port: 30080, Protocol: "TCP"}}, data.ServicePorts["IPv4_80_TCP"].Cluster); diff != "" {
+		t.Errorf("generateConfig() node backends mismatch (-want +got):\n%s", diff)
+	}
+
+	files, err := proxyConfig(data)
+	if err != nil {
+		t.Fatalf("proxyConfig() error = %v", err)
+	}
+	for _, want := range []string{"address: db.example.com", "type: STRICT_DNS", "port_value: 5432"} {
+		if !strings.Contains(files.Clusters, want) {
+			t.Errorf("clusters config does not contain %q\n%s", want, files.Clusters)
+		}
+	}
+}
@@ -0,0 +1,271 @@
+package loadbalancer
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/klog/v2"
+
+	"sigs.k8s.io/cloud-provider-kind/pkg/constants"
+	"sigs.k8s.io/cloud-provider-kind/pkg/container"
+)
+
+// The fault injection simulates flaky cloud loadbalancers to test the resilience of the
+// applications. The Envoy fault filter only works for HTTP and the loadbalancer proxies
+// the connections at L4, so the faults are implemented in the network namespace of the
+// loadbalancer: the new connections are aborted with iptables and the traffic sent back
+// to the clients is delayed or dropped with netem. Like the firewall, the rules are
+// installed from an ephemeral container with the kind node image. The bandwidth of the
+// Service is capped the same way, in both directions, shaping the traffic to the backends
+// too.
+const faultChain = "KIND-LB-FAULTS"
+
+// proxyFaultsPath defines the path to the file with the fault script applied, the rules
+// are not visible from the envoy image
+const proxyFaultsPath = "/etc/envoy/faults.sh"
+
+// maxFaultClasses is the number of netem qdiscs that fit in the prio qdisc, it has a
+// maximum of 16 bands and the first 3 are used for the traffic without faults
+const maxFaultClasses = 13
+
+// fault is the fault injection configured on the ports of a Service
+type fault struct {
+	// Delay is added to the packets sent to the clients
+	Delay time.Duration
+	// PacketLoss is the percentage of packets sent to the clients that are dropped
+	PacketLoss float64
+	// Abort is the percentage of new connections rejected, or datagrams dropped for UDP
+	Abort float64
+	// Rate caps the throughput of the Service in bits per second, in each direction
+	Rate int64
+	// Service is the Service with the faults, the ports of a Service share the bandwidth
+	Service string
+}
+
+// parseFault returns the fault injection configured by the annotations of the Service,
+// or nil if there is none. The invalid values are ignored.
+func parseFault(service *v1.Service) *fault {
+	f := fault{}
+	if value, ok := service.Annotations[constants.FaultDelayAnnotation]; ok {
+		delay, err := time.ParseDuration(strings.TrimSpace(value))
+		if err != nil || delay < 0 {
+			klog.InfoS("Ignoring invalid fault delay", "service", klog.KObj(service), "annotation", constants.FaultDelayAnnotation, "value", value)
+		} else {
+			f.Delay = delay
+		}
+	}
+	f.PacketLoss = parsePercentage(service, constants.FaultPacketLossAnnotation)
+	f.Abort = parsePercentage(service, constants.FaultAbortAnnotation)
+	if value, ok := service.Annotations[constants.BandwidthAnnotation]; ok {
+		rate, err := resource.ParseQuantity(strings.TrimSpace(value))
+		if err != nil || rate.Sign() <= 0 {
+			klog.InfoS("Ignoring invalid bandwidth", "service", klog.KObj(service), "annotation", constants.BandwidthAnnotation, "value", value)
+		} else {
+			f.Rate = rate.Value()
+		}
+	}
+	if f == (fault{}) {
+		return nil
+	}
+	f.Service = klog.KObj(service).String()
+	return &f
+}
+
+func parsePercentage(service *v1.Service, annotation string) float64 {
+	value, ok := service.Annotations[annotation]
+	if !ok {
+		return 0
+	}
+	percentage, err := strconv.ParseFloat(strings.TrimSuffix(strings.TrimSpace(value), "%"), 64)
+	if err != nil || percentage < 0 || percentage > 100 {
+		klog.InfoS("Ignoring invalid fault percentage", "service", klog.KObj(service), "annotation", annotation, "value", value)
+		return 0
+	}
+	return percentage
+}
+
+// netem returns the netem parameters of the fault, empty if it does not shape the traffic
+func (f *fault) netem() string {
+	params := []string{}
+	if f.Delay > 0 {
+		params = append(params, fmt.Sprintf("delay %dus", f.Delay.Microseconds()))
+	}
+	if f.PacketLoss > 0 {
+		params = append(params, fmt.Sprintf("loss %s%%", strconv.FormatFloat(f.PacketLoss, 'f', -1, 64)))
+	}
+	if f.Rate > 0 {
+		params = append(params, fmt.Sprintf("rate %dbit", f.Rate))
+	}
+	return strings.Join(params, " ")
+}
+
+// faultClass is a netem qdisc of the loadbalancer
+type faultClass struct {
+	netem string
+	// service is set when the qdisc caps the bandwidth, that is not shared with other Services
+	service string
+	// upstream is true for the traffic to the backends
+	upstream bool
+}
+
+// faultScript returns the script that installs the fault injection rules of the config,
+// or empty if there are no faults. The rules are always replaced as a whole, the script
+// returned by clearFaultsScript removes them.
+func faultScript(config *proxyConfigData) (string, error) {
+	if config == nil {
+		return "", nil
+	}
+	keys := []string{}
+	for key, servicePort := range config.ServicePorts {
+		if servicePort.Fault != nil {
+			keys = append(keys, key)
+		}
+	}
+	if len(keys) == 0 {
+		return "", nil
+	}
+	sort.Strings(keys)
+
+	// the packets of the ports with the same netem parameters share the qdisc, unless they
+	// cap the bandwidth of different Services
+	classes := []faultClass{}
+	mark := func(class faultClass) (int, error) {
+		for i := range classes {
+			if classes[i] == class {
+				return i + 1, nil
+			}
+		}
+		if len(classes) == maxFaultClasses {
+			return 0, fmt.Errorf("too many different faults, the maximum is %d", maxFaultClasses)
+		}
+		classes = append(classes, class)
+		return len(classes), nil
+	}
+	marks := map[v1.IPFamily][]string{}
+	aborts := map[v1.IPFamily][]string{}
+	for _, key := range keys {
+		servicePort := config.ServicePorts[key]
+		family, _, _ := strings.Cut(key, "_")
+		protocol := strings.ToLower(servicePort.Listener.Protocol)
+		service := ""
+		if servicePort.Fault.Rate > 0 {
+			service = servicePort.Fault.Service
+		}
+		if netem := servicePort.Fault.netem(); netem != "" {
+			id, err := mark(faultClass{netem: netem, service: service})
+			if err != nil {
+				return "", err
+			}
+			marks[v1.IPFamily(family)] = append(marks[v1.IPFamily(family)],
+				fmt.Sprintf("-A %s -p %s --sport %d -j MARK --set-mark %d", faultChain, protocol, servicePort.Listener.Port, id))
+		}
+		if servicePort.Fault.Rate > 0 && len(servicePort.Cluster) > 0 {
+			// all the backends of the port listen on the same NodePort
+			id, err := mark(faultClass{netem: fmt.Sprintf("rate %dbit", servicePort.Fault.Rate), service: service, upstream: true})
+			if err != nil {
+				return "", err
+			}
+			marks[v1.IPFamily(family)] = append(marks[v1.IPFamily(family)],
+				fmt.Sprintf("-A %s -p %s --dport %d -j MARK --set-mark %d", faultChain, protocol, servicePort.Cluster[0].Port, id))
+		}
+		if servicePort.Fault.Abort > 0 {
+			probability := strconv.FormatFloat(servicePort.Fault.Abort/100, 'f', -1, 64)
+			action := "-m conntrack --ctstate NEW -m statistic --mode random --probability " + probability + " -j REJECT --reject-with tcp-reset"
+			if protocol == "udp" {
+				action = "-m statistic --mode random --probability " + probability + " -j DROP"
+			}
+			aborts[v1.IPFamily(family)] = append(aborts[v1.IPFamily(family)],
+				fmt.Sprintf("-A %s -p %s --dport %d %s", faultChain, protocol, servicePort.Listener.Port, action))
+		}
+	}
+
+	var script strings.Builder
+	script.WriteString(clearFaultsScript())
+	for _, family := range []v1.IPFamily{v1.IPv4Protocol, v1.IPv6Protocol} {
+		iptables := "iptables"
+		if family == v1.IPv6Protocol {
+			iptables = "ip6tables"
+		}
+		fmt.Fprintf(&script, "%s-restore --noflush <<EOF\n*mangle\n:%s - [0:0]\n", iptables, faultChain)
+		for _, rule := range marks[family] {
+			script.WriteString(rule + "\n")
+		}
+		fmt.Fprintf(&script, "COMMIT\n*filter\n:%s - [0:0]\n", faultChain)
+		for _, rule := range aborts[family] {
+			script.WriteString(rule + "\n")
+		}
+		script.WriteString("COMMIT\nEOF\n")
+		// the aborts are evaluated before the firewall, that accepts the declared ports
+		fmt.Fprintf(&script, "%s -t mangle -C OUTPUT -j %s 2>/dev/null || %s -t mangle -I OUTPUT -j %s\n", iptables, faultChain, iptables, faultChain)
+		fmt.Fprintf(&script, "%s -C INPUT -j %s 2>/dev/null || %s -I INPUT -j %s\n", iptables, faultChain, iptables, faultChain)
+	}
+	if len(classes) > 0 {
+		script.WriteString("for dev in $(ls /sys/class/net); do\n")
+		script.WriteString("  [ \"$dev\" = lo ] && continue\n")
+		fmt.Fprintf(&script, "  tc qdisc add dev $dev root handle 1: prio bands %d\n", len(classes)+3)
+		for i, class := range classes {
+			fmt.Fprintf(&script, "  tc qdisc add dev $dev parent 1:%x handle %x: netem %s\n", i+4, i+10, class.netem)
+			fmt.Fprintf(&script, "  tc filter add dev $dev parent 1: protocol all prio 1 handle %d fw flowid 1:%x\n", i+1, i+4)
+		}
+		script.WriteString("done\n")
+	}
+	return script.String(), nil
+}
+
+// clearFaultsScript returns the script that removes the fault injection rules
+func clearFaultsScript() string {
+	var script strings.Builder
+	for _, iptables := range []string{"iptables", "ip6tables"} {
+		fmt.Fprintf(&script, "%s -t mangle -F %s 2>/dev/null || true\n", iptables, faultChain)
+		fmt.Fprintf(&script, "%s -F %s 2>/dev/null || true\n", iptables, faultChain)
+	}
+	script.WriteString("for dev in $(ls /sys/class/net); do\n")
+	script.WriteString("  [ \"$dev\" = lo ] || tc qdisc del dev $dev root 2>/dev/null || true\n")
+	script.WriteString("done\n")
+	return script.String()
+}
+
+// ensureFaults installs the fault injection rules of the config on the loadbalancer if
+// they changed. The rules do not survive container restarts, the script applied is stored
+// in the container to know if the rules have to be replaced or removed.
+func ensureFaults(ctx context.Context, clusterName string, name string, config *proxyConfigData, restarted bool) error {
+	script, err := faultScript(config)
+	if err != nil {
+		return err
+	}
+	current := ""
+	if !restarted {
+		var stdout bytes.Buffer
+		if err := container.Exec(name, []string{"cat", proxyFaultsPath}, nil, &stdout, nil); err == nil {
+			current = stdout.String()
+		}
+	}
+	if script == current {
+		return nil
+	}
+	image, err := nodeImage(clusterName)
+	if err != nil {
+		return err
+	}
+	apply := script
+	if apply == "" {
+		apply = clearFaultsScript()
+	}
+	_, err = container.RunInNetNS(name, image, []string{"sh", "-c", apply})
+	if err != nil {
+		return fmt.Errorf("failed to install the fault injection rules on loadbalancer %s: %w", name, err)
+	}
+	err = container.Exec(name, []string{"cp", "/dev/stdin", proxyFaultsPath}, strings.NewReader(script), nil, nil)
+	if err != nil {
+		return err
+	}
+	klog.FromContext(ctx).V(2).Info("Updated fault injection rules", "enabled", script != "")
+	return nil
+}
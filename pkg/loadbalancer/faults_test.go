@@ -0,0 +1,151 @@
+package loadbalancer
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+
+	"sigs.k8s.io/cloud-provider-kind/pkg/constants"
+)
+
+func Test_parseFault(t *testing.T) {
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		want        *fault
+	}{
+		{
+			name: "no faults",
+		},
+		{
+			name: "all faults",
+			annotations: map[string]string{
+				constants.FaultDelayAnnotation:      "200ms",
+				constants.FaultPacketLossAnnotation: "5",
+				constants.FaultAbortAnnotation:      "12.5%",
+			},
+			want: &fault{Delay: 200 * time.Millisecond, PacketLoss: 5, Abort: 12.5, Service: "test"},
+		},
+		{
+			name: "invalid values are ignored",
+			annotations: map[string]string{
+				constants.FaultDelayAnnotation:      "soon",
+				constants.FaultPacketLossAnnotation: "150",
+				constants.FaultAbortAnnotation:      "10",
+				constants.BandwidthAnnotation:       "fast",
+			},
+			want: &fault{Abort: 10, Service: "test"},
+		},
+		{
+			name: "bandwidth",
+			annotations: map[string]string{
+				constants.BandwidthAnnotation: "10M",
+			},
+			want: &fault{Rate: 10000000, Service: "test"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service := makeService("test")
+			service.Annotations = tt.annotations
+			if got := parseFault(service); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseFault() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_faultScript(t *testing.T) {
+	delay := &fault{Delay: 200 * time.Millisecond}
+	config := &proxyConfigData{
+		ServicePorts: map[string]servicePort{
+			"IPv4_80_TCP":  {Listener: endpoint{Address: mustParseAddress("0.0.0.0"), Port: 80, Protocol: string(v1.ProtocolTCP)}, Fault: delay},
+			"IPv6_80_TCP":  {Listener: endpoint{Address: mustParseAddress("::"), Port: 80, Protocol: string(v1.ProtocolTCP)}, Fault: delay},
+			"IPv4_53_UDP":  {Listener: endpoint{Address: mustParseAddress("0.0.0.0"), Port: 53, Protocol: string(v1.ProtocolUDP)}, Fault: &fault{PacketLoss: 5, Abort: 10}},
+			"IPv4_443_TCP": {Listener: endpoint{Address: mustParseAddress("0.0.0.0"), Port: 443, Protocol: string(v1.ProtocolTCP)}},
+		},
+	}
+	got, err := faultScript(config)
+	if err != nil {
+		t.Fatalf("faultScript() unexpected error: %v", err)
+	}
+	for _, want := range []string{
+		"-A KIND-LB-FAULTS -p udp --sport 53 -j MARK --set-mark 1\n",
+		"-A KIND-LB-FAULTS -p tcp --sport 80 -j MARK --set-mark 2\n",
+		"-A KIND-LB-FAULTS -p udp --dport 53 -m statistic --mode random --probability 0.1 -j DROP\n",
+		"ip6tables -C INPUT -j KIND-LB-FAULTS 2>/dev/null || ip6tables -I INPUT -j KIND-LB-FAULTS\n",
+		"  tc qdisc add dev $dev root handle 1: prio bands 5\n",
+		"  tc qdisc add dev $dev parent 1:4 handle a: netem loss 5%\n",
+		"  tc qdisc add dev $dev parent 1:5 handle b: netem delay 200000us\n",
+		"  tc filter add dev $dev parent 1: protocol all prio 1 handle 2 fw flowid 1:5\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("faultScript() missing %q in:\n%s", want, got)
+		}
+	}
+	// the IPv6 port shares the netem qdisc with the IPv4 one
+	if strings.Contains(got, "handle c:") || strings.Contains(got, "--sport 443") {
+		t.Errorf("faultScript() unexpected rules:\n%s", got)
+	}
+
+	got, err = faultScript(&proxyConfigData{ServicePorts: map[string]servicePort{
+		"IPv4_443_TCP": {Listener: endpoint{Address: mustParseAddress("0.0.0.0"), Port: 443, Protocol: string(v1.ProtocolTCP)}},
+	}})
+	if err != nil || got != "" {
+		t.Errorf("faultScript() = %q, %v, want no script for the loadbalancers without faults", got, err)
+	}
+}
+
+func Test_faultScriptBandwidth(t *testing.T) {
+	backends := []endpoint{{Address: mustParseAddress("10.0.0.1"), Port: 30080, Protocol: string(v1.ProtocolTCP)}}
+	config := &proxyConfigData{
+		ServicePorts: map[string]servicePort{
+			"IPv4_80_TCP":  {Listener: endpoint{Address: mustParseAddress("0.0.0.0"), Port: 80, Protocol: string(v1.ProtocolTCP)}, Cluster: backends, Fault: &fault{Rate: 1000000, Service: "default/a"}},
+			"IPv4_443_TCP": {Listener: endpoint{Address: mustParseAddress("0.0.0.0"), Port: 443, Protocol: string(v1.ProtocolTCP)}, Fault: &fault{Rate: 1000000, Service: "default/b"}},
+		},
+	}
+	got, err := faultScript(config)
+	if err != nil {
+		t.Fatalf("faultScript() unexpected error: %v", err)
+	}
+	for _, want := range []string{
+		"-A KIND-LB-FAULTS -p tcp --sport 443 -j MARK --set-mark 1\n",
+		"-A KIND-LB-FAULTS -p tcp --sport 80 -j MARK --set-mark 2\n",
+		"-A KIND-LB-FAULTS -p tcp --dport 30080 -j MARK --set-mark 3\n",
+		"  tc qdisc add dev $dev root handle 1: prio bands 6\n",
+		"  tc qdisc add dev $dev parent 1:6 handle c: netem rate 1000000bit\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("faultScript() missing %q in:\n%s", want, got)
+		}
+	}
+}
+
+func Test_configHashFaults(t *testing.T) {
+	service := makeService("test")
+	service.Spec.Type = v1.ServiceTypeLoadBalancer
+	service.Spec.IPFamilies = []v1.IPFamily{v1.IPv4Protocol}
+	service.Spec.Ports[0].NodePort = 30000
+	service.Spec.Ports[0].Protocol = v1.ProtocolTCP
+	nodes := []*v1.Node{makeNode("a", "10.0.0.1")}
+
+	base, err := proxyConfig(generateConfig(service, nodes))
+	if err != nil {
+		t.Fatalf("proxyConfig() error = %v", err)
+	}
+	service.Annotations = map[string]string{constants.FaultDelayAnnotation: "1s"}
+	withFault, err := proxyConfig(generateConfig(service, nodes))
+	if err != nil {
+		t.Fatalf("proxyConfig() error = %v", err)
+	}
+	// the faults are applied without restarting or reloading the proxy
+	if base.Bootstrap != withFault.Bootstrap || base.Clusters != withFault.Clusters {
+		t.Errorf("the faults must not modify the envoy config")
+	}
+	if configHash(base) == configHash(withFault) {
+		t.Errorf("configHash() did not change when the faults changed")
+	}
+}
@@ -0,0 +1,76 @@
+package loadbalancer
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+
+	"sigs.k8s.io/cloud-provider-kind/pkg/container"
+)
+
+// The firewall simulates the cloud providers that drop the traffic to the loadbalancers
+// unless the port is declared in the Service, instead of rejecting the connections,
+// so the workloads relying on undeclared ports fail the same way they would on a cloud.
+// The envoy image does not ship iptables so, like the UDP checksum workaround, the rules
+// are installed from an ephemeral container with the kind node image sharing the
+// loadbalancer network namespace.
+const firewallChain = "KIND-LB-FIREWALL"
+
+// firewallScript returns the script that installs the deny-by-default rules allowing only
+// the listeners of the config. The chain is flushed and filled atomically by iptables-restore
+// so it can be applied again when the Service ports change.
+func firewallScript(config *proxyConfigData) string {
+	ports := map[v1.IPFamily][]string{}
+	if config != nil {
+		for key, servicePort := range config.ServicePorts {
+			family, _, _ := strings.Cut(key, "_")
+			rule := fmt.Sprintf("-A %s -p %s --dport %d -j ACCEPT", firewallChain, strings.ToLower(servicePort.Listener.Protocol), servicePort.Listener.Port)
+			ports[v1.IPFamily(family)] = append(ports[v1.IPFamily(family)], rule)
+		}
+	}
+
+	var script strings.Builder
+	for _, family := range []v1.IPFamily{v1.IPv4Protocol, v1.IPv6Protocol} {
+		iptables, icmp := "iptables", ""
+		if family == v1.IPv6Protocol {
+			// the neighbor discovery is required for the IPv6 connectivity
+			iptables, icmp = "ip6tables", fmt.Sprintf("-A %s -p ipv6-icmp -j ACCEPT\n", firewallChain)
+		}
+		rules := ports[family]
+		sort.Strings(rules)
+		fmt.Fprintf(&script, "%s-restore --noflush <<EOF\n*filter\n:%s - [0:0]\n", iptables, firewallChain)
+		fmt.Fprintf(&script, "-A %s -i lo -j ACCEPT\n", firewallChain)
+		// the replies to the health checks and the connections to the upstreams
+		fmt.Fprintf(&script, "-A %s -m conntrack --ctstate ESTABLISHED,RELATED -j ACCEPT\n", firewallChain)
+		script.WriteString(icmp)
+		for _, rule := range rules {
+			script.WriteString(rule + "\n")
+		}
+		fmt.Fprintf(&script, "-A %s -j DROP\nCOMMIT\nEOF\n", firewallChain)
+		fmt.Fprintf(&script, "%s -C INPUT -j %s 2>/dev/null || %s -I INPUT -j %s\n", iptables, firewallChain, iptables, firewallChain)
+	}
+	return script.String()
+}
+
+// ensureFirewall installs the deny-by-default rules on the loadbalancer if enabled. The
+// rules do not survive container restarts, so they have to be applied every time the
+// loadbalancer is restarted, that is also the case when the Service ports change.
+func ensureFirewall(ctx context.Context, enabled bool, clusterName string, name string, config *proxyConfigData) error {
+	if !enabled {
+		return nil
+	}
+	image, err := nodeImage(clusterName)
+	if err != nil {
+		return err
+	}
+	_, err = container.RunInNetNS(name, image, []string{"sh", "-c", firewallScript(config)})
+	if err != nil {
+		return fmt.Errorf("failed to install the firewall rules on loadbalancer %s: %w", name, err)
+	}
+	klog.FromContext(ctx).V(2).Info("Installed firewall rules")
+	return nil
+}
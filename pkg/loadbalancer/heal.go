@@ -0,0 +1,104 @@
+package loadbalancer
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+
+	"sigs.k8s.io/cloud-provider-kind/pkg/container"
+)
+
+// HealAction is the action taken to recover a loadbalancer whose proxy exited
+type HealAction string
+
+const (
+	// HealNone means the loadbalancer did not need to be recovered, it was deleted or it
+	// is running again
+	HealNone HealAction = ""
+	// HealRestarted means the loadbalancer container was restarted
+	HealRestarted HealAction = "Restarted"
+	// HealRecreated means the loadbalancer container was deleted to be created again on the
+	// next sync of the Service, because it keeps crashing or it cannot be restarted
+	HealRecreated HealAction = "Recreated"
+)
+
+const (
+	// maxCrashes is the number of crashes in crashWindow after which the loadbalancer
+	// container is recreated instead of restarted
+	maxCrashes  = 3
+	crashWindow = 5 * time.Minute
+)
+
+// Healer is implemented by the loadbalancer controllers that can recover the loadbalancers
+// whose proxy exited unexpectedly
+type Healer interface {
+	// HealLoadBalancer recovers the loadbalancer of the Service after its proxy exited, the
+	// Service has to be synced again to apply the settings lost with the container state
+	HealLoadBalancer(ctx context.Context, clusterName string, service *v1.Service) (HealAction, error)
+}
+
+var _ Healer = &Server{}
+
+func (s *Server) HealLoadBalancer(ctx context.Context, clusterName string, service *v1.Service) (HealAction, error) {
+	name := loadBalancerName(clusterName, service)
+	_, logger := withContainer(ctx, name)
+	unlock := s.locks.lock(name)
+	defer unlock()
+	// the container was deleted after it exited
+	if !container.Exist(name) {
+		s.crashes.forget(name)
+		return HealNone, nil
+	}
+	if s.crashes.add(name, time.Now()) > maxCrashes {
+		logger.Info("Loadbalancer keeps crashing, recreating it", "crashes", maxCrashes, "window", crashWindow)
+		s.crashes.forget(name)
+		return HealRecreated, s.deleteLoadBalancer(ctx, name, service)
+	}
+	// the restart policy of the container already restarted the proxy, the settings of
+	// the network namespace have to be applied again anyway
+	if container.IsRunning(name) {
+		s.healed.Store(name, true)
+		return HealRestarted, nil
+	}
+	logger.V(2).Info("Restarting crashed loadbalancer")
+	err := container.Restart(name)
+	if err != nil {
+		logger.Info("Failed to restart loadbalancer, recreating it", "err", err)
+		return HealRecreated, s.deleteLoadBalancer(ctx, name, service)
+	}
+	s.healed.Store(name, true)
+	return HealRestarted, nil
+}
+
+// crashLoops keeps the recent crashes of the loadbalancers to detect crash loops
+type crashLoops struct {
+	mu      sync.Mutex
+	crashes map[string][]time.Time // key is the loadbalancer container name
+}
+
+func newCrashLoops() *crashLoops {
+	return &crashLoops{crashes: map[string][]time.Time{}}
+}
+
+// add records a crash of the loadbalancer and returns its number of crashes in crashWindow
+func (c *crashLoops) add(name string, now time.Time) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	recent := []time.Time{}
+	for _, t := range c.crashes[name] {
+		if now.Sub(t) < crashWindow {
+			recent = append(recent, t)
+		}
+	}
+	recent = append(recent, now)
+	c.crashes[name] = recent
+	return len(recent)
+}
+
+func (c *crashLoops) forget(name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.crashes, name)
+}
@@ -0,0 +1,114 @@
+package loadbalancer
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/cloud-provider-kind/pkg/constants"
+	"sigs.k8s.io/cloud-provider-kind/pkg/container"
+)
+
+func TestHealLoadBalancerFake(t *testing.T) {
+	fake := container.NewFake()
+	defer container.SetRuntime(fake)()
+	fake.AddContainer(&container.FakeContainer{
+		Name:     "kind-control-plane",
+		Image:    "kindest/node:v1.30.0",
+		Labels:   map[string]string{constants.KindClusterLabelKey: "kind"},
+		Networks: []string{"kind"},
+		IPv4:     "172.18.0.2",
+		Running:  true,
+	})
+	fake.ExecHook = fakeListeners(80)
+
+	s := NewServer(nil, nil, nil).(*Server)
+	s.tunnelManager = nil
+	s.hostAddress = ""
+	s.publishUnready = true
+	service := &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default"},
+		Spec: v1.ServiceSpec{
+			Type:       v1.ServiceTypeLoadBalancer,
+			IPFamilies: []v1.IPFamily{v1.IPv4Protocol},
+			Ports:      []v1.ServicePort{{Port: 80, NodePort: 30080, Protocol: v1.ProtocolTCP}},
+		},
+	}
+	nodes := []*v1.Node{makeNode("kind-control-plane", "172.18.0.2")}
+	name := loadBalancerName("kind", service)
+	ctx := context.Background()
+
+	_, err := s.EnsureLoadBalancer(ctx, "kind", service, nodes)
+	if err != nil {
+		t.Fatalf("EnsureLoadBalancer() unexpected error: %v", err)
+	}
+
+	// the crashed loadbalancer is restarted and the next update applies the settings again
+	restarts := fake.Container(name).Restarts
+	if err := fake.Crash(name, 139, false); err != nil {
+		t.Fatalf("Crash() unexpected error: %v", err)
+	}
+	action, err := s.HealLoadBalancer(ctx, "kind", service)
+	if err != nil || action != HealRestarted {
+		t.Fatalf("HealLoadBalancer() = %q, %v, want %q", action, err, HealRestarted)
+	}
+	if lb := fake.Container(name); !lb.Running || lb.Restarts != restarts+1 {
+		t.Errorf("loadbalancer running %v with %d restarts, want running with %d", lb.Running, lb.Restarts, restarts+1)
+	}
+	if _, ok := s.healed.Load(name); !ok {
+		t.Errorf("loadbalancer not marked as healed")
+	}
+	_, err = s.EnsureLoadBalancer(ctx, "kind", service, nodes)
+	if err != nil {
+		t.Fatalf("EnsureLoadBalancer() unexpected error: %v", err)
+	}
+	if _, ok := s.healed.Load(name); ok {
+		t.Errorf("the settings of the healed loadbalancer were not applied again")
+	}
+
+	// a loadbalancer that keeps crashing is recreated
+	for i := 1; i < maxCrashes; i++ {
+		fake.Crash(name, 1, true) // nolint:errcheck
+		action, err = s.HealLoadBalancer(ctx, "kind", service)
+		if err != nil || action != HealRestarted {
+			t.Fatalf("HealLoadBalancer() = %q, %v, want %q", action, err, HealRestarted)
+		}
+	}
+	fake.Crash(name, 1, true) // nolint:errcheck
+	action, err = s.HealLoadBalancer(ctx, "kind", service)
+	if err != nil || action != HealRecreated {
+		t.Fatalf("HealLoadBalancer() = %q, %v, want %q", action, err, HealRecreated)
+	}
+	if fake.Container(name) != nil {
+		t.Errorf("loadbalancer container %s not deleted", name)
+	}
+
+	// nothing to heal once the container is deleted
+	action, err = s.HealLoadBalancer(ctx, "kind", service)
+	if err != nil || action != HealNone {
+		t.Fatalf("HealLoadBalancer() = %q, %v, want none", action, err)
+	}
+}
+
+func Test_crashLoops(t *testing.T) {
+	c := newCrashLoops()
+	now := time.Now()
+	if got := c.add("lb", now.Add(-2*crashWindow)); got != 1 {
+		t.Errorf("add() = %d, want 1", got)
+	}
+	if got := c.add("lb", now.Add(-time.Minute)); got != 1 {
+		t.Errorf("add() = %d, want 1, the crashes out of the window are dropped", got)
+	}
+	if got := c.add("lb", now); got != 2 {
+		t.Errorf("add() = %d, want 2", got)
+	}
+	if got := c.add("other", now); got != 1 {
+		t.Errorf("add() = %d, want 1", got)
+	}
+	c.forget("lb")
+	if got := c.add("lb", now); got != 1 {
+		t.Errorf("add() = %d, want 1 after forget", got)
+	}
+}
@@ -0,0 +1,85 @@
+package loadbalancer
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+
+	"sigs.k8s.io/cloud-provider-kind/pkg/constants"
+)
+
+// parseHealthCheckPorts returns the health check ports of the annotation by selector, the
+// empty selector is the port of all the Service ports. The annotation is ignored if any
+// entry is invalid.
+func parseHealthCheckPorts(service *v1.Service) map[string]int {
+	value, ok := service.Annotations[constants.HealthCheckPortAnnotation]
+	if !ok {
+		return nil
+	}
+	ports, err := healthCheckPorts(value)
+	if err != nil {
+		klog.InfoS("Ignoring invalid health check ports", "service", klog.KObj(service), "annotation", constants.HealthCheckPortAnnotation, "value", value, "err", err)
+		return nil
+	}
+	return ports
+}
+
+func healthCheckPorts(value string) (map[string]int, error) {
+	ports := map[string]int{}
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		selector, port, found := strings.Cut(entry, "=")
+		if !found {
+			selector, port = "", entry
+		}
+		selector = strings.TrimSpace(selector)
+		number, err := strconv.Atoi(strings.TrimSpace(port))
+		if err != nil || number <= 0 || number > 65535 {
+			return nil, fmt.Errorf("invalid port in %q", entry)
+		}
+		// a single selector is an IP family or a port, both are FAMILY/PORT
+		if family, servicePort, ok := strings.Cut(selector, "/"); ok && (!isIPFamily(family) || servicePort == "") {
+			return nil, fmt.Errorf("invalid selector in %q", entry)
+		}
+		if _, ok := ports[selector]; ok {
+			return nil, fmt.Errorf("duplicated selector in %q", entry)
+		}
+		ports[selector] = number
+	}
+	return ports, nil
+}
+
+func isIPFamily(value string) bool {
+	return value == string(v1.IPv4Protocol) || value == string(v1.IPv6Protocol)
+}
+
+// healthCheckPort returns the port of the HTTP health checks of the backends of the Service
+// port in the IP family, or 0 for the default port of the Service. The most specific entry
+// of the annotation is used: the IP family and the port, by number or name, then the port,
+// then the IP family.
+func healthCheckPort(ports map[string]int, port v1.ServicePort, ipFamily v1.IPFamily) int {
+	if len(ports) == 0 {
+		return 0
+	}
+	selectors := []string{fmt.Sprintf("%s/%d", ipFamily, port.Port)}
+	if port.Name != "" {
+		selectors = append(selectors, fmt.Sprintf("%s/%s", ipFamily, port.Name))
+	}
+	selectors = append(selectors, strconv.Itoa(int(port.Port)))
+	if port.Name != "" {
+		selectors = append(selectors, port.Name)
+	}
+	selectors = append(selectors, string(ipFamily), "")
+	for _, selector := range selectors {
+		if value, ok := ports[selector]; ok {
+			return value
+		}
+	}
+	return 0
+}
@@ -0,0 +1,88 @@
+package loadbalancer
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+
+	"sigs.k8s.io/cloud-provider-kind/pkg/constants"
+)
+
+func Test_healthCheckPorts(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    map[string]int
+		wantErr bool
+	}{
+		{value: "10257", want: map[string]int{"": 10257}},
+		{value: "10256, IPv6=10257, IPv4/80=8080, http=8081", want: map[string]int{"": 10256, "IPv6": 10257, "IPv4/80": 8080, "http": 8081}},
+		{value: "IPv6/http=8080", want: map[string]int{"IPv6/http": 8080}},
+		{value: "IPv7/80=8080", wantErr: true},
+		{value: "80=http", wantErr: true},
+		{value: "70000", wantErr: true},
+		{value: "IPv6=1,IPv6=2", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := healthCheckPorts(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("healthCheckPorts() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("healthCheckPorts() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_healthCheckPort(t *testing.T) {
+	ports := map[string]int{"IPv6": 10257, "IPv4/80": 8080, "dns": 8053, "IPv6/dns": 9053}
+	tests := []struct {
+		name   string
+		port   v1.ServicePort
+		family v1.IPFamily
+		want   int
+	}{
+		{name: "family and port", port: v1.ServicePort{Port: 80}, family: v1.IPv4Protocol, want: 8080},
+		{name: "family", port: v1.ServicePort{Port: 80}, family: v1.IPv6Protocol, want: 10257},
+		{name: "port name", port: v1.ServicePort{Name: "dns", Port: 53}, family: v1.IPv4Protocol, want: 8053},
+		{name: "family and port name", port: v1.ServicePort{Name: "dns", Port: 53}, family: v1.IPv6Protocol, want: 9053},
+		{name: "default", port: v1.ServicePort{Port: 443}, family: v1.IPv4Protocol, want: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := healthCheckPort(ports, tt.port, tt.family); got != tt.want {
+				t.Errorf("healthCheckPort() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_proxyConfigHealthCheckPorts(t *testing.T) {
+	service := makeService("test")
+	service.Annotations = map[string]string{constants.HealthCheckPortAnnotation: "IPv6=10257"}
+	service.Spec.Type = v1.ServiceTypeLoadBalancer
+	service.Spec.IPFamilies = []v1.IPFamily{v1.IPv4Protocol, v1.IPv6Protocol}
+	service.Spec.Ports[0].NodePort = 30000
+	service.Spec.Ports[0].Protocol = v1.ProtocolTCP
+	nodes := []*v1.Node{makeNode("a", "10.0.0.1"), makeNode("b", "fd00::1")}
+
+	data := generateConfig(service, nodes)
+	if got := data.ServicePorts["IPv4_80_TCP"].HealthCheckPort; got != 0 {
+		t.Errorf("IPv4 health check port = %d, want the default", got)
+	}
+	if got := data.ServicePorts["IPv6_80_TCP"].HealthCheckPort; got != 10257 {
+		t.Errorf("IPv6 health check port = %d, want 10257", got)
+	}
+	files, err := proxyConfig(data)
+	if err != nil {
+		t.Fatalf("proxyConfig() error = %v", err)
+	}
+	for _, want := range []string{"port_value: 10256", "port_value: 10257"} {
+		if !strings.Contains(files.Clusters, want) {
+			t.Errorf("clusters config missing %q:\n%s", want, files.Clusters)
+		}
+	}
+}
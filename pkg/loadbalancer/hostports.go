@@ -0,0 +1,67 @@
+package loadbalancer
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+
+	"sigs.k8s.io/cloud-provider-kind/pkg/constants"
+	"sigs.k8s.io/cloud-provider-kind/pkg/container"
+)
+
+// publishesSamePorts returns true if the loadbalancers publish the Service ports on the same
+// ports of the container runtime host, so two loadbalancers of any cluster can not use the
+// same port.
+func (s *Server) publishesSamePorts() bool {
+	return s.tunnelManager == nil && s.hostAddress != "" && !s.publishRandomPorts
+}
+
+// checkHostPorts refuses to create the loadbalancer if one of the ports of the Services is
+// already published on the host by another loadbalancer, the container runtime would fail
+// to start it.
+func (s *Server) checkHostPorts(services []*v1.Service) error {
+	if !s.publishesSamePorts() {
+		return nil
+	}
+	owners, err := container.ListLabelValues(constants.NodeCCMLabelKey, constants.LoadBalancerNameLabelKey)
+	if err != nil {
+		return err
+	}
+	published := map[string]map[string]int{}
+	for id, owner := range owners {
+		hostPorts, err := container.HostPorts(id)
+		if err != nil || len(hostPorts) == 0 {
+			continue
+		}
+		if owner == "" {
+			owner = id
+		}
+		published[owner] = hostPorts
+	}
+	return provisioningError(ReasonPortConflict, hostPortConflict(publishedPorts(services), published))
+}
+
+// hostPortConflict returns an error if one of the ports is already published on the host,
+// published has the host ports of each loadbalancer indexed by the container port and the
+// protocol, ex. 80/tcp.
+func hostPortConflict(ports []v1.ServicePort, published map[string]map[string]int) error {
+	owners := []string{}
+	for owner := range published {
+		owners = append(owners, owner)
+	}
+	sort.Strings(owners)
+	for _, port := range ports {
+		protocol := strings.ToLower(string(port.Protocol))
+		for _, owner := range owners {
+			for containerPort, hostPort := range published[owner] {
+				_, hostProtocol, _ := strings.Cut(containerPort, "/")
+				if hostPort == int(port.Port) && hostProtocol == protocol {
+					return fmt.Errorf("port %d/%s is already published on the host by loadbalancer %s", port.Port, port.Protocol, owner)
+				}
+			}
+		}
+	}
+	return nil
+}
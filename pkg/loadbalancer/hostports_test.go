@@ -0,0 +1,67 @@
+package loadbalancer
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+func Test_hostPortConflict(t *testing.T) {
+	published := map[string]map[string]int{
+		"kind/default/web": {"80/tcp": 80, "443/tcp": 443},
+		"other/dns/dns":    {"53/udp": 53},
+	}
+	tests := []struct {
+		name    string
+		ports   []v1.ServicePort
+		wantErr string
+	}{
+		{
+			name:  "free ports",
+			ports: []v1.ServicePort{{Port: 8080, Protocol: v1.ProtocolTCP}, {Port: 53, Protocol: v1.ProtocolTCP}},
+		},
+		{
+			name:    "port published by other loadbalancer",
+			ports:   []v1.ServicePort{{Port: 8080, Protocol: v1.ProtocolTCP}, {Port: 443, Protocol: v1.ProtocolTCP}},
+			wantErr: "port 443/TCP is already published on the host by loadbalancer kind/default/web",
+		},
+		{
+			name:    "port published by other cluster",
+			ports:   []v1.ServicePort{{Port: 53, Protocol: v1.ProtocolUDP}},
+			wantErr: "port 53/UDP is already published on the host by loadbalancer other/dns/dns",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := hostPortConflict(tt.ports, published)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("hostPortConflict() unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || err.Error() != tt.wantErr {
+				t.Fatalf("hostPortConflict() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_sharedConfigPortConflictReason(t *testing.T) {
+	http := makeSharedService("http", "web", time.Hour, v1.ServicePort{Port: 80, NodePort: 30080, Protocol: v1.ProtocolTCP})
+	conflict := makeSharedService("conflict", "web", time.Minute, v1.ServicePort{Port: 80, NodePort: 31080, Protocol: v1.ProtocolTCP})
+	_, err := sharedConfig(conflict, []*v1.Service{http, conflict}, nil)
+	// the reason is kept when the error is returned by a later provisioning step
+	err = provisioningError(ReasonConfigFailed, fmt.Errorf("update failed: %w", err))
+	var provisioningErr *ProvisioningError
+	if !errors.As(err, &provisioningErr) || provisioningErr.Reason != ReasonPortConflict {
+		t.Fatalf("sharedConfig() error = %v, want reason %s", err, ReasonPortConflict)
+	}
+	if !strings.Contains(err.Error(), "already used by Service default/http") {
+		t.Errorf("sharedConfig() error = %v", err)
+	}
+}
@@ -0,0 +1,175 @@
+package loadbalancer
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+
+	"sigs.k8s.io/cloud-provider-kind/pkg/constants"
+	"sigs.k8s.io/cloud-provider-kind/pkg/container"
+)
+
+const (
+	// HTTP3Passthrough forwards the QUIC traffic of the UDP ports to the backends, the
+	// sessions are hashed by client IP so a QUIC connection stays on the same backend when
+	// the client port changes, ex. after a NAT rebinding
+	HTTP3Passthrough = "passthrough"
+	// HTTP3Terminate terminates TLS and HTTP on the loadbalancer, HTTP/1.1 and HTTP/2 on the
+	// TCP ports and HTTP/3 on the same UDP ports, the backends receive plain HTTP requests
+	HTTP3Terminate = "terminate"
+)
+
+// parseHTTP3 returns the HTTP/3 mode of the Service and the TLS Secret of the terminated
+// listeners, in the format namespace/name
+func parseHTTP3(service *v1.Service) (string, string) {
+	value, ok := service.Annotations[constants.HTTP3Annotation]
+	if !ok {
+		return "", ""
+	}
+	switch value {
+	case HTTP3Passthrough:
+		return value, ""
+	case HTTP3Terminate:
+		secret := service.Annotations[constants.HTTP3TLSSecretAnnotation]
+		if secret == "" {
+			klog.InfoS("Ignoring HTTP/3 termination without certificate", "service", klog.KObj(service), "annotation", constants.HTTP3TLSSecretAnnotation)
+			return "", ""
+		}
+		return value, service.Namespace + "/" + secret
+	}
+	klog.InfoS("Ignoring invalid HTTP/3 mode", "service", klog.KObj(service), "annotation", constants.HTTP3Annotation, "value", value)
+	return "", ""
+}
+
+// withHTTP3Listeners adds the listeners terminating HTTP/3 on the UDP port of each TCP port
+// terminating TLS, they send the requests to the same backends and replace the UDP ports
+// of the Service with the same number
+func withHTTP3Listeners(servicePorts map[string]servicePort) {
+	quic := map[string]servicePort{}
+	for key, servicePort := range servicePorts {
+		if servicePort.HTTP3 != HTTP3Terminate || servicePort.Listener.Protocol != string(v1.ProtocolTCP) {
+			continue
+		}
+		servicePort.Listener.Protocol = string(v1.ProtocolUDP)
+		// the UDP listeners do not have network filters
+		servicePort.ListenerFilters, servicePort.AllowedSources = nil, nil
+		quic[strings.TrimSuffix(key, "_TCP")+"_UDP"] = servicePort
+	}
+	for key, servicePort := range quic {
+		servicePorts[key] = servicePort
+	}
+}
+
+// publishedPorts returns the ports of the Services published by the loadbalancer, with the
+// UDP ports of the HTTP/3 listeners that are not declared in the Services
+func publishedPorts(services []*v1.Service) []v1.ServicePort {
+	ports := []v1.ServicePort{}
+	for _, service := range services {
+		ports = append(ports, service.Spec.Ports...)
+		if mode, _ := parseHTTP3(service); mode != HTTP3Terminate {
+			continue
+		}
+		declared := map[int32]bool{}
+		for _, port := range service.Spec.Ports {
+			if port.Protocol == v1.ProtocolUDP {
+				declared[port.Port] = true
+			}
+		}
+		for _, port := range service.Spec.Ports {
+			if port.Protocol == v1.ProtocolTCP && !declared[port.Port] {
+				ports = append(ports, v1.ServicePort{Name: port.Name, Port: port.Port, Protocol: v1.ProtocolUDP})
+			}
+		}
+	}
+	return ports
+}
+
+// tlsSecretPath returns the path in the loadbalancer container, without extension, of the
+// files of the TLS Secret, in the format namespace/name
+func tlsSecretPath(secret string) string {
+	return path.Join(path.Dir(proxyConfigPath), "tls_"+strings.Replace(secret, "/", "_", 1))
+}
+
+// TLSCertificatePath returns the certificate file of the listener terminating TLS, kept
+// for the user templates
+func (s servicePort) TLSCertificatePath() string {
+	return tlsSecretPath(s.TLSSecret) + ".crt"
+}
+
+// TLSPrivateKeyPath returns the private key file of the listener terminating TLS, kept
+// for the user templates
+func (s servicePort) TLSPrivateKeyPath() string {
+	return tlsSecretPath(s.TLSSecret) + ".key"
+}
+
+// ensureTLSCertificates copies the certificates of the listeners terminating TLS from their
+// Secrets into the loadbalancer container. The proxy watches the config directory and
+// reloads the certificates when they are moved into place, so they can be rotated without
+// restarting it.
+func (s *Server) ensureTLSCertificates(ctx context.Context, name string, lbConfig *proxyConfigData) error {
+	if lbConfig == nil {
+		return nil
+	}
+	secrets := map[string]servicePort{}
+	for _, servicePort := range lbConfig.ServicePorts {
+		if servicePort.TLSSecret != "" {
+			secrets[servicePort.TLSSecret] = servicePort
+		}
+	}
+	refs := []string{}
+	for ref := range secrets {
+		refs = append(refs, ref)
+	}
+	sort.Strings(refs)
+	for _, ref := range refs {
+		if s.kubeClient == nil {
+			return fmt.Errorf("can not get the TLS Secret %s without a cluster client", ref)
+		}
+		namespace, secretName, _ := strings.Cut(ref, "/")
+		secret, err := s.kubeClient.CoreV1().Secrets(namespace).Get(ctx, secretName, metav1.GetOptions{})
+		if err != nil {
+			return fmt.Errorf("failed to get the TLS Secret of the HTTP/3 listeners: %w", err)
+		}
+		certificate, key := secret.Data[v1.TLSCertKey], secret.Data[v1.TLSPrivateKeyKey]
+		if len(certificate) == 0 || len(key) == 0 {
+			return fmt.Errorf("TLS Secret %s does not have the %s and %s keys", ref, v1.TLSCertKey, v1.TLSPrivateKeyKey)
+		}
+		servicePort := secrets[ref]
+		for file, content := range map[string][]byte{servicePort.TLSCertificatePath(): certificate, servicePort.TLSPrivateKeyPath(): key} {
+			updated, err := writeProxyFile(name, file, content)
+			if err != nil {
+				return err
+			}
+			if updated {
+				klog.FromContext(ctx).V(2).Info("Updated loadbalancer TLS certificate", "secret", ref, "file", file)
+			}
+		}
+	}
+	return nil
+}
+
+// writeProxyFile writes the file in the loadbalancer container if its content changed,
+// moving it into place so the proxy watching the directory reloads it
+func writeProxyFile(name string, file string, content []byte) (bool, error) {
+	var current bytes.Buffer
+	err := container.Exec(name, []string{"cat", file}, nil, &current, nil)
+	if err == nil && bytes.Equal(current.Bytes(), content) {
+		return false, nil
+	}
+	err = container.Exec(name, []string{"cp", "/dev/stdin", file + ".tmp"}, bytes.NewReader(content), nil, nil)
+	if err != nil {
+		return false, err
+	}
+	err = container.Exec(name, []string{"mv", file + ".tmp", file}, nil, nil, nil)
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
@@ -0,0 +1,199 @@
+package loadbalancer
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	hcmv3 "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/network/http_connection_manager/v3"
+	quicv3 "github.com/envoyproxy/go-control-plane/envoy/extensions/transport_sockets/quic/v3"
+	"github.com/google/go-cmp/cmp"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+
+	"sigs.k8s.io/cloud-provider-kind/pkg/constants"
+	"sigs.k8s.io/cloud-provider-kind/pkg/container"
+)
+
+func makeHTTP3Service(annotations map[string]string, ports ...v1.ServicePort) *v1.Service {
+	return &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default", Annotations: annotations},
+		Spec: v1.ServiceSpec{
+			Type:       v1.ServiceTypeLoadBalancer,
+			IPFamilies: []v1.IPFamily{v1.IPv4Protocol},
+			Ports:      ports,
+		},
+	}
+}
+
+func Test_parseHTTP3(t *testing.T) {
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		wantMode    string
+		wantSecret  string
+	}{
+		{name: "disabled"},
+		{name: "passthrough", annotations: map[string]string{constants.HTTP3Annotation: "passthrough"}, wantMode: HTTP3Passthrough},
+		{
+			name:        "terminate",
+			annotations: map[string]string{constants.HTTP3Annotation: "terminate", constants.HTTP3TLSSecretAnnotation: "web-tls"},
+			wantMode:    HTTP3Terminate,
+			wantSecret:  "default/web-tls",
+		},
+		{name: "terminate without certificate", annotations: map[string]string{constants.HTTP3Annotation: "terminate"}},
+		{name: "invalid", annotations: map[string]string{constants.HTTP3Annotation: "quic"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mode, secret := parseHTTP3(makeHTTP3Service(tt.annotations))
+			if mode != tt.wantMode || secret != tt.wantSecret {
+				t.Errorf("parseHTTP3() = %q, %q, want %q, %q", mode, secret, tt.wantMode, tt.wantSecret)
+			}
+		})
+	}
+}
+
+func Test_generateConfigHTTP3(t *testing.T) {
+	nodes := []*v1.Node{makeNode("a", "10.0.0.1")}
+	terminate := map[string]string{constants.HTTP3Annotation: "terminate", constants.HTTP3TLSSecretAnnotation: "web-tls"}
+	service := makeHTTP3Service(terminate,
+		v1.ServicePort{Name: "https", Port: 443, NodePort: 30443, Protocol: v1.ProtocolTCP},
+		// replaced by the HTTP/3 listener
+		v1.ServicePort{Name: "quic", Port: 443, NodePort: 31443, Protocol: v1.ProtocolUDP},
+		v1.ServicePort{Name: "dns", Port: 53, NodePort: 30053, Protocol: v1.ProtocolUDP},
+	)
+	got := generateConfig(service, nodes)
+	if diff := cmp.Diff([]string{"IPv4_443_TCP", "IPv4_443_UDP", "IPv4_53_UDP"}, servicePortKeys(got)); diff != "" {
+		t.Fatalf("generateConfig() listeners mismatch (-want +got):\n%s", diff)
+	}
+	quic := got.ServicePorts["IPv4_443_UDP"]
+	if quic.HTTP3 != HTTP3Terminate || quic.TLSSecret != "default/web-tls" || quic.Cluster[0].Port != 30443 || quic.Cluster[0].Protocol != "TCP" {
+		t.Errorf("generateConfig() HTTP/3 listener = %+v, want the TLS backends of the TCP port", quic)
+	}
+	if dns := got.ServicePorts["IPv4_53_UDP"]; dns.HTTP3 != "" {
+		t.Errorf("generateConfig() UDP port HTTP/3 mode = %q, want none", dns.HTTP3)
+	}
+
+	service = makeHTTP3Service(map[string]string{constants.HTTP3Annotation: "passthrough"},
+		v1.ServicePort{Name: "https", Port: 443, NodePort: 30443, Protocol: v1.ProtocolTCP},
+		v1.ServicePort{Name: "quic", Port: 443, NodePort: 31443, Protocol: v1.ProtocolUDP},
+	)
+	got = generateConfig(service, nodes)
+	if got.ServicePorts["IPv4_443_UDP"].HTTP3 != HTTP3Passthrough || got.ServicePorts["IPv4_443_TCP"].HTTP3 != "" {
+		t.Errorf("generateConfig() passthrough = %+v, want only the UDP port forwarding QUIC", got.ServicePorts)
+	}
+}
+
+func Test_httpListenerConfig(t *testing.T) {
+	data := sampleHTTP3ProxyConfigData("")
+	tcp, err := listenerConfig("IPv4_80_TCP", data.ServicePorts["IPv4_80_TCP"], data)
+	if err != nil {
+		t.Fatalf("listenerConfig() error = %v", err)
+	}
+	chain := tcp.FilterChains[0]
+	if chain.TransportSocket.Name != "envoy.transport_sockets.tls" {
+		t.Errorf("TCP listener transport socket = %s, want TLS", chain.TransportSocket.Name)
+	}
+	hcm := &hcmv3.HttpConnectionManager{}
+	if err := chain.Filters[0].GetTypedConfig().UnmarshalTo(hcm); err != nil {
+		t.Fatalf("TCP listener filter is not an HTTP connection manager: %v", err)
+	}
+	headers := hcm.GetRouteConfig().VirtualHosts[0].ResponseHeadersToAdd
+	if len(headers) != 1 || headers[0].Header.Key != "alt-svc" || headers[0].Header.Value != `h3=":80"; ma=86400` {
+		t.Errorf("TCP listener response headers = %v, want the HTTP/3 listener advertised", headers)
+	}
+
+	udp, err := listenerConfig("IPv4_80_UDP", data.ServicePorts["IPv4_80_UDP"], data)
+	if err != nil {
+		t.Fatalf("listenerConfig() error = %v", err)
+	}
+	if udp.UdpListenerConfig.GetQuicOptions() == nil {
+		t.Errorf("UDP listener is not a QUIC listener")
+	}
+	transport := &quicv3.QuicDownstreamTransport{}
+	if err := udp.FilterChains[0].TransportSocket.GetTypedConfig().UnmarshalTo(transport); err != nil {
+		t.Fatalf("UDP listener transport socket is not QUIC: %v", err)
+	}
+	certificate := transport.DownstreamTlsContext.CommonTlsContext.TlsCertificates[0]
+	if certificate.CertificateChain.GetFilename() != "/etc/envoy/tls_default_web-tls.crt" || certificate.PrivateKey.GetFilename() != "/etc/envoy/tls_default_web-tls.key" {
+		t.Errorf("UDP listener certificate = %v, want the files of the TLS Secret", certificate)
+	}
+	hcm = &hcmv3.HttpConnectionManager{}
+	if err := udp.FilterChains[0].Filters[0].GetTypedConfig().UnmarshalTo(hcm); err != nil || hcm.CodecType != hcmv3.HttpConnectionManager_HTTP3 {
+		t.Errorf("UDP listener HTTP connection manager codec = %v, want HTTP3: %v", hcm.CodecType, err)
+	}
+
+	passthrough, err := clusterConfig("IPv6_53_UDP", data.ServicePorts["IPv6_53_UDP"], data)
+	if err != nil {
+		t.Fatalf("clusterConfig() error = %v", err)
+	}
+	if passthrough.LbPolicy.String() != "RING_HASH" {
+		t.Errorf("QUIC passthrough cluster lb_policy = %v, want RING_HASH", passthrough.LbPolicy)
+	}
+}
+
+func Test_publishedPorts(t *testing.T) {
+	terminate := makeHTTP3Service(map[string]string{constants.HTTP3Annotation: "terminate", constants.HTTP3TLSSecretAnnotation: "web-tls"},
+		v1.ServicePort{Name: "http", Port: 80, Protocol: v1.ProtocolTCP},
+		v1.ServicePort{Name: "https", Port: 443, Protocol: v1.ProtocolTCP},
+		v1.ServicePort{Name: "quic", Port: 443, Protocol: v1.ProtocolUDP},
+	)
+	plain := makeHTTP3Service(nil, v1.ServicePort{Name: "dns", Port: 53, Protocol: v1.ProtocolTCP})
+	got := []string{}
+	for _, port := range publishedPorts([]*v1.Service{terminate, plain}) {
+		got = append(got, port.Name+"/"+string(port.Protocol))
+	}
+	want := []string{"http/TCP", "https/TCP", "quic/UDP", "http/UDP", "dns/TCP"}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("publishedPorts() mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func Test_ensureTLSCertificates(t *testing.T) {
+	fake := container.NewFake()
+	defer container.SetRuntime(fake)()
+	fake.AddContainer(&container.FakeContainer{Name: "lb", Running: true})
+
+	certificate := "-----BEGIN CERTIFICATE-----\n"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/namespaces/default/secrets/web-tls" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(&v1.Secret{ // nolint:errcheck
+			ObjectMeta: metav1.ObjectMeta{Name: "web-tls", Namespace: "default"},
+			Type:       v1.SecretTypeTLS,
+			Data:       map[string][]byte{v1.TLSCertKey: []byte(certificate), v1.TLSPrivateKeyKey: []byte("key")},
+		})
+	}))
+	defer server.Close()
+	client, err := kubernetes.NewForConfig(&rest.Config{Host: server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &Server{kubeClient: client}
+
+	data := sampleHTTP3ProxyConfigData("")
+	if err := s.ensureTLSCertificates(context.Background(), "lb", data); err != nil {
+		t.Fatalf("ensureTLSCertificates() error = %v", err)
+	}
+	files := fake.Container("lb").Files
+	if files["/etc/envoy/tls_default_web-tls.crt"] != certificate || files["/etc/envoy/tls_default_web-tls.key"] != "key" {
+		t.Errorf("certificate files = %v, want the TLS Secret", files)
+	}
+
+	tcp := data.ServicePorts["IPv4_80_TCP"]
+	tcp.TLSSecret = "default/missing"
+	data.ServicePorts["IPv4_80_TCP"] = tcp
+	err = s.ensureTLSCertificates(context.Background(), "lb", data)
+	if !apierrors.IsNotFound(err) {
+		t.Errorf("ensureTLSCertificates() error = %v, want the missing Secret", err)
+	}
+}
@@ -0,0 +1,201 @@
+package loadbalancer
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog/v2"
+
+	"sigs.k8s.io/cloud-provider-kind/pkg/config"
+	"sigs.k8s.io/cloud-provider-kind/pkg/constants"
+	"sigs.k8s.io/cloud-provider-kind/pkg/container"
+)
+
+// proxyImageArchitectures are the architectures the proxy image is published for
+var proxyImageArchitectures = []string{"amd64", "arm64"}
+
+// prepullBackoff retries the pull of the proxy image on startup, the slow connections
+// can take minutes to download it
+var prepullBackoff = wait.Backoff{Duration: 5 * time.Second, Factor: 2, Steps: 5, Cap: time.Minute}
+
+// proxyPlatform returns the platform of the proxy image variant for the container runtime
+// host, that may not be the one of the controller, empty to let the runtime choose if the
+// architecture can not be detected
+func proxyPlatform() (string, error) {
+	arch := container.Architecture()
+	if arch == "" {
+		return "", nil
+	}
+	if !slices.Contains(proxyImageArchitectures, arch) {
+		return "", fmt.Errorf("the proxy image %s is not available for the %s architecture, only for %s", proxyImage, arch, strings.Join(proxyImageArchitectures, ", "))
+	}
+	return "linux/" + arch, nil
+}
+
+// PrepullProxyImage pulls the proxy image for the architecture of the container runtime on
+// startup, so the first loadbalancer does not time out waiting for the download. The pull
+// is retried with backoff and its progress is logged.
+func PrepullProxyImage(ctx context.Context) error {
+	platform, err := proxyPlatform()
+	if err != nil {
+		return err
+	}
+	logger := klog.FromContext(ctx).WithValues("image", proxyImage, "platform", platform)
+	var pullErr error
+	err = wait.ExponentialBackoffWithContext(ctx, prepullBackoff, func(ctx context.Context) (bool, error) {
+		if offlineProxyImage() {
+			// the kind nodes may not be created yet
+			logger.Info("Loading proxy image")
+			pullErr = ensureProxyImage(proxyImage, platform)
+			if pullErr != nil {
+				logger.Info("Failed to load proxy image, retrying", "err", pullErr)
+				return false, nil
+			}
+			return true, nil
+		}
+		logger.Info("Pulling proxy image")
+		start := time.Now()
+		pullErr = container.PullImage(ctx, proxyImage, platform, &pullProgress{logger: logger})
+		if pullErr != nil {
+			logger.Info("Failed to pull proxy image, retrying", "err", pullErr)
+			return false, nil
+		}
+		logger.Info("Pulled proxy image", "duration", time.Since(start).Round(time.Millisecond))
+		return true, nil
+	})
+	if err != nil && pullErr != nil {
+		return pullErr
+	}
+	return err
+}
+
+// offlineProxyImage returns true if the proxy image is loaded from an archive or from the
+// kind nodes instead of being pulled from the registry
+func offlineProxyImage() bool {
+	return config.DefaultConfig.ProxyImageArchive != "" || config.DefaultConfig.ProxyImageFromNode
+}
+
+// ensureProxyImage makes the image available in the container runtime. Without registry
+// access it is loaded from the --proxy-image-archive tarball, or exported from the
+// containerd image store of a kind node with --proxy-image-from-node, ex. after kind load.
+func ensureProxyImage(image string, platform string) error {
+	if !offlineProxyImage() || container.ImageExists(image) {
+		return container.EnsureImage(image)
+	}
+	if path := config.DefaultConfig.ProxyImageArchive; path != "" {
+		err := loadImageArchive(path)
+		if err != nil {
+			return err
+		}
+		if container.ImageExists(image) {
+			return nil
+		}
+		if !config.DefaultConfig.ProxyImageFromNode {
+			return fmt.Errorf("the archive %s does not contain the image %s", path, image)
+		}
+	}
+	return loadImageFromNodes(image, platform)
+}
+
+func loadImageArchive(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return container.LoadImage(f)
+}
+
+// loadImageFromNodes exports the image from the first kind node that has it, the nodes of
+// all the clusters have the same containerd image store layout
+func loadImageFromNodes(image string, platform string) error {
+	nodes, err := container.ListByLabel(constants.KindClusterLabelKey)
+	if err != nil {
+		return err
+	}
+	if len(nodes) == 0 {
+		return fmt.Errorf("there are no kind nodes to export the image %s from", image)
+	}
+	command := []string{"ctr", "--namespace", "k8s.io", "images", "export"}
+	if platform != "" {
+		command = append(command, "--platform", platform)
+	}
+	command = append(command, "-", qualifiedImageName(image))
+	var errs []error
+	for _, node := range nodes {
+		pr, pw := io.Pipe()
+		go func() {
+			var stderr bytes.Buffer
+			err := container.Exec(node, command, nil, pw, &stderr)
+			if err != nil {
+				err = fmt.Errorf("%w: %s", err, strings.TrimSpace(stderr.String()))
+			}
+			pw.CloseWithError(err)
+		}()
+		err := container.LoadImage(pr)
+		// unblock the export if the load failed
+		pr.CloseWithError(io.ErrClosedPipe)
+		if err == nil && container.ImageExists(image) {
+			return nil
+		}
+		if err == nil {
+			err = errors.New("the image was not loaded")
+		}
+		errs = append(errs, fmt.Errorf("node %s: %w", node, err))
+	}
+	return fmt.Errorf("failed to export the image %s from the kind nodes, load it with kind load docker-image: %w", image, errors.Join(errs...))
+}
+
+// qualifiedImageName returns the image name with the registry and the repository, as
+// stored by containerd, ex. docker.io/envoyproxy/envoy:v1.30.1
+func qualifiedImageName(image string) string {
+	first, _, found := strings.Cut(image, "/")
+	if !found {
+		return "docker.io/library/" + image
+	}
+	if strings.ContainsAny(first, ".:") || first == "localhost" {
+		return image
+	}
+	return "docker.io/" + image
+}
+
+// pullProgress logs the progress reported by the pull command, the layers downloaded and
+// the rest of the lines with more verbosity
+type pullProgress struct {
+	logger klog.Logger
+	mu     sync.Mutex
+	buf    []byte
+	layers int
+}
+
+func (p *pullProgress) Write(data []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.buf = append(p.buf, data...)
+	for {
+		i := bytes.IndexByte(p.buf, '\n')
+		if i < 0 {
+			break
+		}
+		line := strings.TrimSpace(string(p.buf[:i]))
+		p.buf = p.buf[i+1:]
+		switch {
+		case line == "":
+		case strings.HasSuffix(line, ": Pull complete"):
+			p.layers++
+			p.logger.Info("Pulled proxy image layer", "layers", p.layers)
+		default:
+			p.logger.V(4).Info("Pulling proxy image", "progress", line)
+		}
+	}
+	return len(data), nil
+}
@@ -0,0 +1,168 @@
+package loadbalancer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog/v2"
+
+	"sigs.k8s.io/cloud-provider-kind/pkg/config"
+	"sigs.k8s.io/cloud-provider-kind/pkg/constants"
+	"sigs.k8s.io/cloud-provider-kind/pkg/container"
+)
+
+func Test_proxyPlatform(t *testing.T) {
+	tests := []struct {
+		arch    string
+		want    string
+		wantErr bool
+	}{
+		{arch: "amd64", want: "linux/amd64"},
+		{arch: "arm64", want: "linux/arm64"},
+		{arch: "", want: ""},
+		{arch: "s390x", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.arch, func(t *testing.T) {
+			fake := container.NewFake()
+			defer container.SetRuntime(fake)()
+			fake.Arch = tt.arch
+			got, err := proxyPlatform()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("proxyPlatform() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("proxyPlatform() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPrepullProxyImage(t *testing.T) {
+	fake := container.NewFake()
+	defer container.SetRuntime(fake)()
+	counter, restore := container.CountCalls()
+	defer restore()
+	defer func(backoff wait.Backoff) { prepullBackoff = backoff }(prepullBackoff)
+	prepullBackoff = wait.Backoff{Duration: time.Millisecond, Steps: 3}
+
+	fake.Arch = "arm64"
+	if err := PrepullProxyImage(context.Background()); err != nil {
+		t.Fatalf("PrepullProxyImage() error = %v", err)
+	}
+	if fake.Platforms[proxyImage] != "linux/arm64" {
+		t.Errorf("proxy image pulled for %q, want linux/arm64", fake.Platforms[proxyImage])
+	}
+
+	// the pull is retried until the backoff is exhausted
+	fake.PullError = errors.New("connection reset")
+	if err := PrepullProxyImage(context.Background()); err == nil {
+		t.Errorf("PrepullProxyImage() did not fail")
+	}
+	if got := counter.Calls()["PullImage"]; got != 4 {
+		t.Errorf("PullImage called %d times, want 4", got)
+	}
+}
+
+func Test_pullProgress(t *testing.T) {
+	p := &pullProgress{logger: klog.Background()}
+	for _, chunk := range []string{"v1.30.1: Pulling from envoyproxy/envoy\n4a2f: Pull", " complete\n", "5b3e: Pull complete\nDigest: sha256:1234\n"} {
+		if _, err := p.Write([]byte(chunk)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if p.layers != 2 || len(p.buf) != 0 {
+		t.Errorf("pullProgress counted %d layers, %q pending, want 2", p.layers, p.buf)
+	}
+}
+
+func Test_qualifiedImageName(t *testing.T) {
+	tests := map[string]string{
+		"envoyproxy/envoy:v1.30.1":          "docker.io/envoyproxy/envoy:v1.30.1",
+		"busybox":                           "docker.io/library/busybox",
+		"registry.k8s.io/pause:3.9":         "registry.k8s.io/pause:3.9",
+		"localhost/envoy:dev":               "localhost/envoy:dev",
+		"localhost:5000/envoyproxy/envoy:1": "localhost:5000/envoyproxy/envoy:1",
+	}
+	for image, want := range tests {
+		if got := qualifiedImageName(image); got != want {
+			t.Errorf("qualifiedImageName(%q) = %q, want %q", image, got, want)
+		}
+	}
+}
+
+func Test_ensureProxyImageArchive(t *testing.T) {
+	fake := container.NewFake()
+	defer container.SetRuntime(fake)()
+	defer func(archive string) { config.DefaultConfig.ProxyImageArchive = archive }(config.DefaultConfig.ProxyImageArchive)
+	fake.PullError = errors.New("no registry access")
+
+	archive := filepath.Join(t.TempDir(), "envoy.tar")
+	if err := os.WriteFile(archive, []byte("busybox\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	config.DefaultConfig.ProxyImageArchive = archive
+	if err := ensureProxyImage(proxyImage, "linux/amd64"); err == nil {
+		t.Errorf("ensureProxyImage() did not fail with an archive without the image")
+	}
+
+	if err := os.WriteFile(archive, []byte(proxyImage+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ensureProxyImage(proxyImage, "linux/amd64"); err != nil {
+		t.Fatalf("ensureProxyImage() error = %v", err)
+	}
+	if !fake.Images[proxyImage] {
+		t.Errorf("proxy image not loaded from the archive")
+	}
+}
+
+func Test_ensureProxyImageFromNode(t *testing.T) {
+	fake := container.NewFake()
+	defer container.SetRuntime(fake)()
+	defer func(fromNode bool) { config.DefaultConfig.ProxyImageFromNode = fromNode }(config.DefaultConfig.ProxyImageFromNode)
+	fake.PullError = errors.New("no registry access")
+	config.DefaultConfig.ProxyImageFromNode = true
+
+	if err := ensureProxyImage(proxyImage, "linux/amd64"); err == nil {
+		t.Errorf("ensureProxyImage() did not fail without kind nodes")
+	}
+
+	for _, name := range []string{"kind-control-plane", "kind-worker"} {
+		fake.AddContainer(&container.FakeContainer{
+			Name:    name,
+			Labels:  map[string]string{constants.KindClusterLabelKey: "kind"},
+			Running: true,
+		})
+	}
+	var exported []string
+	fake.ExecHook = func(name string, command []string, stdout io.Writer) (bool, error) {
+		if len(command) == 0 || command[0] != "ctr" {
+			return false, nil
+		}
+		exported = append(exported, name)
+		if !slices.Contains(command, "--platform") || command[len(command)-1] != "docker.io/"+proxyImage {
+			return true, fmt.Errorf("unexpected export command %v", command)
+		}
+		// only the worker has the image
+		if name != "kind-worker" {
+			return true, errors.New("image not found")
+		}
+		_, err := fmt.Fprintln(stdout, proxyImage)
+		return true, err
+	}
+	if err := ensureProxyImage(proxyImage, "linux/amd64"); err != nil {
+		t.Fatalf("ensureProxyImage() error = %v", err)
+	}
+	if !fake.Images[proxyImage] || len(exported) != 2 {
+		t.Errorf("proxy image loaded %v after exporting it from %v, want loaded from both nodes", fake.Images[proxyImage], exported)
+	}
+}
@@ -0,0 +1,322 @@
+package loadbalancer
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"strings"
+	"sync"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+
+	"sigs.k8s.io/cloud-provider-kind/pkg/config"
+	"sigs.k8s.io/cloud-provider-kind/pkg/constants"
+	"sigs.k8s.io/cloud-provider-kind/pkg/container"
+)
+
+// Allocator allocates the static addresses of the loadbalancers. The default allocator picks
+// free addresses of --loadbalancer-subnet on the container network, the programs embedding
+// this package can replace it with SetAllocator, ex. to reserve the addresses on a lab IPAM.
+// The calls are serialized, the allocator does not need to be safe for concurrent use.
+type Allocator interface {
+	// Allocate returns the addresses of a new loadbalancer on the container network, at most
+	// one per IP family, the families without address are assigned by the container runtime
+	Allocate(network string) (ipv4 string, ipv6 string, err error)
+	// Release frees the addresses of a deleted loadbalancer, or of a loadbalancer whose
+	// container could not be created
+	Release(ipv4 string, ipv6 string) error
+	// MarkAllocated records the addresses of an existing loadbalancer, it is called for each
+	// of them before the first allocation, ex. when the controller restarts
+	MarkAllocated(ipv4 string, ipv6 string) error
+}
+
+var (
+	// ipamLock serializes the allocations of the loadbalancer addresses of all the clusters,
+	// that share the container networks, until the containers are created
+	ipamLock sync.Mutex
+	// allocator allocates the loadbalancer addresses, allocatorSynced is false until the
+	// addresses of the existing loadbalancers are marked allocated on it
+	allocator       Allocator = subnetAllocator{}
+	allocatorSynced bool
+)
+
+// SetAllocator replaces the allocator of the loadbalancer addresses and returns a function
+// that restores the previous one, it must be called before provisioning the loadbalancers.
+func SetAllocator(a Allocator) func() {
+	ipamLock.Lock()
+	defer ipamLock.Unlock()
+	previous, previousSynced := allocator, allocatorSynced
+	allocator, allocatorSynced = a, false
+	return func() {
+		ipamLock.Lock()
+		defer ipamLock.Unlock()
+		allocator, allocatorSynced = previous, previousSynced
+	}
+}
+
+// subnetAllocator is the default allocator, it looks up the addresses in use on the container
+// network on every allocation so it does not keep any state
+type subnetAllocator struct{}
+
+func (subnetAllocator) Allocate(network string) (string, string, error) {
+	return allocateAddresses(network, "", "")
+}
+
+func (subnetAllocator) Release(ipv4 string, ipv6 string) error {
+	return nil
+}
+
+func (subnetAllocator) MarkAllocated(ipv4 string, ipv6 string) error {
+	return nil
+}
+
+// allocate returns the addresses of a new loadbalancer from the allocator, marking the
+// addresses of the existing loadbalancers allocated first. The default allocator returns
+// the previous addresses of the loadbalancer if they are still free. The caller must hold
+// the ipamLock until the container is created.
+func allocate(network string, previousIPv4 string, previousIPv6 string) (ipv4 string, ipv6 string, err error) {
+	if !allocatorSynced {
+		containers, err := container.ListLabelValues(constants.LoadBalancerAllocatedLabelKey, constants.LoadBalancerAllocatedLabelKey)
+		if err != nil {
+			return "", "", err
+		}
+		var errs []error
+		for _, value := range containers {
+			ipv4, ipv6, _ := strings.Cut(value, ",")
+			errs = append(errs, allocator.MarkAllocated(ipv4, ipv6))
+		}
+		if err := errors.Join(errs...); err != nil {
+			return "", "", fmt.Errorf("failed to mark the addresses of the existing loadbalancers allocated: %w", err)
+		}
+		allocatorSynced = true
+	}
+	if _, ok := allocator.(subnetAllocator); ok {
+		return allocateAddresses(network, previousIPv4, previousIPv6)
+	}
+	return allocator.Allocate(network)
+}
+
+// previousAddresses returns the IPv4 and IPv6 addresses published in the status of the
+// Services sharing the loadbalancer. They are the addresses of the previous container when
+// the loadbalancer is recreated, ex. after a host reboot the stopped containers are
+// recreated, and they are requested again so the clients and the /etc/hosts entries
+// configured with them keep working.
+func previousAddresses(services []*v1.Service) (ipv4 string, ipv6 string) {
+	for _, service := range services {
+		for _, ingress := range service.Status.LoadBalancer.Ingress {
+			ip := net.ParseIP(ingress.IP)
+			switch {
+			case ip == nil:
+			case ip.To4() != nil && ipv4 == "":
+				ipv4 = ip.String()
+			case ip.To4() == nil && ipv6 == "":
+				ipv6 = ip.String()
+			}
+		}
+	}
+	return ipv4, ipv6
+}
+
+// pinnedAddresses returns the previous addresses of the loadbalancer that are on the
+// network and not in use, to request them to the container runtime when it assigns the
+// addresses. The address of the host or of the tunnels published in the status are skipped.
+func pinnedAddresses(network string, previousIPv4 string, previousIPv6 string) (ipv4 string, ipv6 string) {
+	if previousIPv4 == "" && previousIPv6 == "" {
+		return "", ""
+	}
+	networkSubnets, err := container.NetworkSubnets(network)
+	if err != nil {
+		return "", ""
+	}
+	addresses, err := container.NetworkAddresses(network)
+	if err != nil {
+		return "", ""
+	}
+	used := map[string]bool{}
+	for _, address := range addresses {
+		if ip := net.ParseIP(address); ip != nil {
+			used[ip.String()] = true
+		}
+	}
+	free := func(address string) bool {
+		ip := net.ParseIP(address)
+		if ip == nil || used[ip.String()] {
+			return false
+		}
+		for _, cidr := range networkSubnets {
+			_, subnet, err := net.ParseCIDR(cidr)
+			if err == nil && subnet.Contains(ip) {
+				return true
+			}
+		}
+		return false
+	}
+	if free(previousIPv4) {
+		ipv4 = previousIPv4
+	}
+	if free(previousIPv6) {
+		ipv6 = previousIPv6
+	}
+	return ipv4, ipv6
+}
+
+// allocatedLabel returns the value of the label with the addresses allocated to the
+// loadbalancer, empty if the container runtime assigns them
+func allocatedLabel(ipv4, ipv6 string) string {
+	if ipv4 == "" && ipv6 == "" {
+		return ""
+	}
+	return ipv4 + "," + ipv6
+}
+
+// deleteContainer deletes the loadbalancer container and releases the addresses allocated
+// to it, and its config served with xDS
+func deleteContainer(name string) error {
+	// the containers without the label do not have allocated addresses
+	value, _ := container.GetLabelValue(name, constants.LoadBalancerAllocatedLabelKey)
+	err := container.Delete(name)
+	if err != nil {
+		return err
+	}
+	unpublishXDS(name)
+	if err := deleteCapture(name); err != nil {
+		klog.ErrorS(err, "Failed to delete the packet capture of the loadbalancer", "container", name)
+	}
+	if value == "" {
+		return nil
+	}
+	ipamLock.Lock()
+	defer ipamLock.Unlock()
+	ipv4, ipv6, _ := strings.Cut(value, ",")
+	return allocator.Release(ipv4, ipv6)
+}
+
+// maxAddressScan limits the addresses checked on large subnets, ex. IPv6 /64
+const maxAddressScan = 1 << 16
+
+// ParseSubnets parses the comma separated list of loadbalancer subnets, at most one subnet
+// per IP family.
+func ParseSubnets(value string) ([]*net.IPNet, error) {
+	subnets := []*net.IPNet{}
+	families := map[bool]bool{}
+	for _, cidr := range strings.Split(value, ",") {
+		cidr = strings.TrimSpace(cidr)
+		if cidr == "" {
+			continue
+		}
+		_, subnet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid subnet %q: %w", cidr, err)
+		}
+		ipv4 := subnet.IP.To4() != nil
+		if families[ipv4] {
+			return nil, fmt.Errorf("only one subnet per IP family is supported: %s", value)
+		}
+		families[ipv4] = true
+		subnets = append(subnets, subnet)
+	}
+	return subnets, nil
+}
+
+// allocateAddresses returns the static addresses of a new loadbalancer on the network, one
+// free address of each configured loadbalancer subnet, the previous one if it is free, so
+// the loadbalancer IPs are disjoint from the node IPs assigned by the container runtime.
+// The subnets must be part of the network subnets, the container runtimes only assign
+// static addresses inside them, and the aliases are only resolvable on the network if they
+// are on-link.
+func allocateAddresses(network string, previousIPv4 string, previousIPv6 string) (ipv4 string, ipv6 string, err error) {
+	subnets, err := ParseSubnets(config.Get().LoadBalancerSubnets)
+	if err != nil || len(subnets) == 0 {
+		return "", "", err
+	}
+	networkSubnets, err := container.NetworkSubnets(network)
+	if err != nil {
+		return "", "", err
+	}
+	addresses, err := container.NetworkAddresses(network)
+	if err != nil {
+		return "", "", err
+	}
+	used := map[string]bool{}
+	for _, address := range addresses {
+		if ip := net.ParseIP(address); ip != nil {
+			used[ip.String()] = true
+		}
+	}
+	if config.DefaultConfig.AdvertiseAddresses {
+		aliases, err := advertisedAddressesInUse()
+		if err != nil {
+			return "", "", err
+		}
+		for _, alias := range aliases {
+			used[alias] = true
+		}
+	}
+	for _, subnet := range subnets {
+		if !subnetContained(subnet, networkSubnets) {
+			return "", "", fmt.Errorf("loadbalancer subnet %s is not part of the subnets %v of network %s", subnet, networkSubnets, network)
+		}
+		previous := previousIPv6
+		if subnet.IP.To4() != nil {
+			previous = previousIPv4
+		}
+		var ip string
+		if address := net.ParseIP(previous); address != nil && subnet.Contains(address) && !used[address.String()] {
+			ip = address.String()
+		} else {
+			ip, err = freeAddress(subnet, used)
+			if err != nil {
+				return "", "", err
+			}
+		}
+		if subnet.IP.To4() != nil {
+			ipv4 = ip
+		} else {
+			ipv6 = ip
+		}
+	}
+	return ipv4, ipv6, nil
+}
+
+// subnetContained returns true if the subnet is inside one of the network subnets
+func subnetContained(subnet *net.IPNet, networkSubnets []string) bool {
+	ones, _ := subnet.Mask.Size()
+	for _, cidr := range networkSubnets {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			continue
+		}
+		networkOnes, _ := network.Mask.Size()
+		if network.Contains(subnet.IP) && networkOnes <= ones && len(network.IP) == len(subnet.IP) {
+			return true
+		}
+	}
+	return false
+}
+
+// freeAddress returns the first address of the subnet that is not used, skipping the
+// network address and the IPv4 broadcast address.
+func freeAddress(subnet *net.IPNet, used map[string]bool) (string, error) {
+	ones, bits := subnet.Mask.Size()
+	size := new(big.Int).Lsh(big.NewInt(1), uint(bits-ones))
+	last := new(big.Int).Sub(size, big.NewInt(1))
+	if bits == 32 {
+		// the broadcast address
+		last.Sub(last, big.NewInt(1))
+	}
+	if last.Cmp(big.NewInt(maxAddressScan)) > 0 {
+		last.SetInt64(maxAddressScan)
+	}
+	base := new(big.Int).SetBytes(subnet.IP)
+	for i := int64(1); i <= last.Int64(); i++ {
+		b := new(big.Int).Add(base, big.NewInt(i)).Bytes()
+		ip := make(net.IP, len(subnet.IP))
+		copy(ip[len(ip)-len(b):], b)
+		if !used[ip.String()] {
+			return ip.String(), nil
+		}
+	}
+	return "", fmt.Errorf("no free addresses in loadbalancer subnet %s", subnet)
+}
@@ -0,0 +1,273 @@
+package loadbalancer
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"sigs.k8s.io/cloud-provider-kind/pkg/config"
+	"sigs.k8s.io/cloud-provider-kind/pkg/constants"
+	"sigs.k8s.io/cloud-provider-kind/pkg/container"
+)
+
+func Test_ParseSubnets(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    int
+		wantErr bool
+	}{
+		{value: "", want: 0},
+		{value: "172.18.200.0/24", want: 1},
+		{value: "172.18.200.0/24, fc00:f853:ccd:e793:1::/80", want: 2},
+		{value: "172.18.200.0/24,172.18.201.0/24", wantErr: true},
+		{value: "172.18.200.0", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := ParseSubnets(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseSubnets() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if len(got) != tt.want {
+				t.Errorf("ParseSubnets() = %v, want %d subnets", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_subnetContained(t *testing.T) {
+	networkSubnets := []string{"fc00:f853:ccd:e793::/64", "172.18.0.0/16"}
+	tests := []struct {
+		subnet string
+		want   bool
+	}{
+		{subnet: "172.18.200.0/24", want: true},
+		{subnet: "172.18.0.0/16", want: true},
+		{subnet: "172.0.0.0/8", want: false},
+		{subnet: "10.0.0.0/24", want: false},
+		{subnet: "fc00:f853:ccd:e793:1::/80", want: true},
+		{subnet: "fd00:10:244::/64", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.subnet, func(t *testing.T) {
+			_, subnet, _ := net.ParseCIDR(tt.subnet)
+			if got := subnetContained(subnet, networkSubnets); got != tt.want {
+				t.Errorf("subnetContained() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_freeAddress(t *testing.T) {
+	tests := []struct {
+		name    string
+		subnet  string
+		used    []string
+		want    string
+		wantErr bool
+	}{
+		{name: "first address", subnet: "172.18.200.0/24", want: "172.18.200.1"},
+		{name: "used addresses", subnet: "172.18.200.0/24", used: []string{"172.18.200.1", "172.18.200.2"}, want: "172.18.200.3"},
+		{name: "broadcast is skipped", subnet: "172.18.200.0/30", used: []string{"172.18.200.1", "172.18.200.2"}, wantErr: true},
+		{name: "ipv6", subnet: "fc00:f853:ccd:e793:1::/80", used: []string{"fc00:f853:ccd:e793:1::1"}, want: "fc00:f853:ccd:e793:1::2"},
+		{name: "ipv6 ula", subnet: "fd00:10:244::/64", want: "fd00:10:244::1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, subnet, _ := net.ParseCIDR(tt.subnet)
+			used := map[string]bool{}
+			for _, ip := range tt.used {
+				used[ip] = true
+			}
+			got, err := freeAddress(subnet, used)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("freeAddress() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("freeAddress() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// recordingAllocator allocates the next address of 172.18.100.0/24 and records the calls
+type recordingAllocator struct {
+	next  int
+	calls []string
+}
+
+func (a *recordingAllocator) Allocate(network string) (string, string, error) {
+	a.next++
+	ip := fmt.Sprintf("172.18.100.%d", a.next)
+	a.calls = append(a.calls, "Allocate "+network+" "+ip)
+	return ip, "", nil
+}
+
+func (a *recordingAllocator) Release(ipv4 string, ipv6 string) error {
+	a.calls = append(a.calls, "Release "+ipv4+" "+ipv6)
+	return nil
+}
+
+func (a *recordingAllocator) MarkAllocated(ipv4 string, ipv6 string) error {
+	a.calls = append(a.calls, "MarkAllocated "+ipv4+" "+ipv6)
+	return nil
+}
+
+func TestSetAllocator(t *testing.T) {
+	fake := container.NewFake()
+	defer container.SetRuntime(fake)()
+	fake.AddContainer(&container.FakeContainer{
+		Name:     "kind-control-plane",
+		Image:    "kindest/node:v1.30.0",
+		Labels:   map[string]string{constants.KindClusterLabelKey: "kind"},
+		Networks: []string{"kind"},
+		IPv4:     "172.18.0.2",
+		Running:  true,
+	})
+	// a loadbalancer provisioned before the controller restarted
+	fake.AddContainer(&container.FakeContainer{
+		Name:    "kindccm-existing",
+		Labels:  map[string]string{constants.LoadBalancerAllocatedLabelKey: "172.18.100.9,"},
+		IPv4:    "172.18.100.9",
+		Running: true,
+	})
+	fake.ExecHook = fakeListeners(80)
+	allocator := &recordingAllocator{}
+	defer SetAllocator(allocator)()
+
+	s := NewServer(nil, nil, nil).(*Server)
+	s.tunnelManager = nil
+	s.hostAddress = ""
+	s.publishUnready = true
+	service := &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default"},
+		Spec: v1.ServiceSpec{
+			Type:       v1.ServiceTypeLoadBalancer,
+			IPFamilies: []v1.IPFamily{v1.IPv4Protocol},
+			Ports:      []v1.ServicePort{{Port: 80, NodePort: 30080, Protocol: v1.ProtocolTCP}},
+		},
+	}
+	nodes := []*v1.Node{makeNode("kind-control-plane", "172.18.0.2")}
+	name := loadBalancerName("kind", service)
+
+	_, err := s.EnsureLoadBalancer(context.Background(), "kind", service, nodes)
+	if err != nil {
+		t.Fatalf("EnsureLoadBalancer() unexpected error: %v", err)
+	}
+	if lb := fake.Container(name); lb.IPv4 != "172.18.100.1" || lb.Labels[constants.LoadBalancerAllocatedLabelKey] != "172.18.100.1," {
+		t.Errorf("loadbalancer created with IP %s and labels %v, want the allocated address", lb.IPv4, lb.Labels)
+	}
+	err = s.EnsureLoadBalancerDeleted(context.Background(), "kind", service)
+	if err != nil {
+		t.Fatalf("EnsureLoadBalancerDeleted() unexpected error: %v", err)
+	}
+
+	want := []string{
+		"MarkAllocated 172.18.100.9 ",
+		"Allocate kind 172.18.100.1",
+		"Release 172.18.100.1 ",
+	}
+	if diff := cmp.Diff(want, allocator.calls); diff != "" {
+		t.Errorf("allocator calls mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func Test_previousAddresses(t *testing.T) {
+	tests := []struct {
+		name     string
+		ingress  [][]v1.LoadBalancerIngress
+		wantIPv4 string
+		wantIPv6 string
+	}{
+		{name: "no status"},
+		{
+			name:     "dual stack",
+			ingress:  [][]v1.LoadBalancerIngress{{{IP: "fc00:f853:ccd:e793::5"}, {IP: "172.18.0.5"}, {IP: "172.18.0.6"}}},
+			wantIPv4: "172.18.0.5",
+			wantIPv6: "fc00:f853:ccd:e793::5",
+		},
+		{
+			name:     "shared ip",
+			ingress:  [][]v1.LoadBalancerIngress{{{Hostname: "web.kind.local"}}, {{IP: "172.18.0.5"}}},
+			wantIPv4: "172.18.0.5",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			services := []*v1.Service{}
+			for _, ingress := range tt.ingress {
+				services = append(services, &v1.Service{Status: v1.ServiceStatus{LoadBalancer: v1.LoadBalancerStatus{Ingress: ingress}}})
+			}
+			ipv4, ipv6 := previousAddresses(services)
+			if ipv4 != tt.wantIPv4 || ipv6 != tt.wantIPv6 {
+				t.Errorf("previousAddresses() = %q, %q, want %q, %q", ipv4, ipv6, tt.wantIPv4, tt.wantIPv6)
+			}
+		})
+	}
+}
+
+func TestEnsureLoadBalancerPreviousAddresses(t *testing.T) {
+	tests := []struct {
+		name    string
+		subnets string
+		status  string
+		used    string
+		want    string
+	}{
+		{name: "runtime addresses", status: "172.18.0.9", want: "172.18.0.9"},
+		{name: "runtime addresses in use", status: "172.18.0.9", used: "172.18.0.9", want: "172.18.0.3"},
+		{name: "host address", status: "192.168.1.10", want: "172.18.0.3"},
+		{name: "allocated addresses", subnets: "172.18.200.0/24", status: "172.18.200.7", want: "172.18.200.7"},
+		{name: "allocated addresses in use", subnets: "172.18.200.0/24", status: "172.18.200.7", used: "172.18.200.7", want: "172.18.200.1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			previous := config.DefaultConfig.LoadBalancerSubnets
+			config.DefaultConfig.LoadBalancerSubnets = tt.subnets
+			defer func() { config.DefaultConfig.LoadBalancerSubnets = previous }()
+			fake := container.NewFake()
+			defer container.SetRuntime(fake)()
+			fake.AddContainer(&container.FakeContainer{
+				Name:     "kind-control-plane",
+				Image:    "kindest/node:v1.30.0",
+				Labels:   map[string]string{constants.KindClusterLabelKey: "kind"},
+				Networks: []string{"kind"},
+				IPv4:     "172.18.0.2",
+				Running:  true,
+			})
+			if tt.used != "" {
+				fake.AddContainer(&container.FakeContainer{Name: "other", Networks: []string{"kind"}, IPv4: tt.used, Running: true})
+			}
+			fake.ExecHook = fakeListeners(80)
+
+			s := NewServer(nil, nil, nil).(*Server)
+			s.tunnelManager = nil
+			s.hostAddress = ""
+			s.publishUnready = true
+			service := &v1.Service{
+				ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default"},
+				Spec: v1.ServiceSpec{
+					Type:       v1.ServiceTypeLoadBalancer,
+					IPFamilies: []v1.IPFamily{v1.IPv4Protocol},
+					Ports:      []v1.ServicePort{{Port: 80, NodePort: 30080, Protocol: v1.ProtocolTCP}},
+				},
+				// the status published before the loadbalancer container was removed
+				Status: v1.ServiceStatus{LoadBalancer: v1.LoadBalancerStatus{Ingress: []v1.LoadBalancerIngress{{IP: tt.status}}}},
+			}
+			nodes := []*v1.Node{makeNode("kind-control-plane", "172.18.0.2")}
+
+			_, err := s.EnsureLoadBalancer(context.Background(), "kind", service, nodes)
+			if err != nil {
+				t.Fatalf("EnsureLoadBalancer() unexpected error: %v", err)
+			}
+			if lb := fake.Container(loadBalancerName("kind", service)); lb.IPv4 != tt.want {
+				t.Errorf("loadbalancer created with IP %s, want %s", lb.IPv4, tt.want)
+			}
+		})
+	}
+}
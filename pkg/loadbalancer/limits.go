@@ -0,0 +1,116 @@
+package loadbalancer
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"sigs.k8s.io/cloud-provider-kind/pkg/config"
+)
+
+// proxyMemoryRegexp matches the memory limits accepted by the container runtimes, a number of
+// bytes with an optional b, k, m or g unit
+var proxyMemoryRegexp = regexp.MustCompile(`^[0-9]+[bBkKmMgG]?$`)
+
+// ValidateProxyLimits returns an error if the resource limits of the proxy containers are
+// not valid, empty values do not set a limit
+func ValidateProxyLimits(memory string, cpus string) error {
+	if memory != "" && !proxyMemoryRegexp.MatchString(memory) {
+		return fmt.Errorf("invalid memory limit %q, expected a number of bytes with an optional b, k, m or g unit", memory)
+	}
+	if cpus != "" {
+		value, err := strconv.ParseFloat(cpus, 64)
+		if err != nil || value <= 0 {
+			return fmt.Errorf("invalid CPU limit %q, expected a positive number of CPUs", cpus)
+		}
+	}
+	return nil
+}
+
+// proxyHeapRatio is the part of the memory limit of the proxy container used as the heap
+// size of the overload manager, if it is not set, the rest is left for the other allocations
+const proxyHeapRatio = 0.8
+
+// ValidateProxyMemoryLimits returns an error if the heap size of the overload manager or
+// the per connection buffer limit of the proxies are not valid, empty values are not set
+func ValidateProxyMemoryLimits(maxHeap string, bufferLimit string) error {
+	if maxHeap != "" {
+		if _, err := parseMemory(maxHeap); err != nil {
+			return fmt.Errorf("invalid max heap size: %w", err)
+		}
+	}
+	if bufferLimit != "" {
+		limit, err := parseMemory(bufferLimit)
+		if err != nil {
+			return fmt.Errorf("invalid buffer limit: %w", err)
+		}
+		if limit == 0 || limit > math.MaxUint32 {
+			return fmt.Errorf("invalid buffer limit %q, it must be between 1 byte and 4g", bufferLimit)
+		}
+	}
+	return nil
+}
+
+// parseMemory parses a number of bytes with an optional b, k, m or g unit, the format of
+// the memory limits of the container runtimes
+func parseMemory(value string) (uint64, error) {
+	if !proxyMemoryRegexp.MatchString(value) {
+		return 0, fmt.Errorf("invalid memory size %q, expected a number of bytes with an optional b, k, m or g unit", value)
+	}
+	multiplier := uint64(1)
+	switch unit := strings.ToLower(value[len(value)-1:]); unit {
+	case "b":
+		value = value[:len(value)-1]
+	case "k", "m", "g":
+		multiplier = map[string]uint64{"k": 1 << 10, "m": 1 << 20, "g": 1 << 30}[unit]
+		value = value[:len(value)-1]
+	}
+	n, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid memory size %q: %w", value, err)
+	}
+	return n * multiplier, nil
+}
+
+// proxyMemoryLimits returns the heap size of the overload manager and the per connection
+// buffer limit of the proxies, 0 if they are not set. The heap size defaults to a part of
+// the memory limit of the container, so the proxy stops accepting connections before it is
+// killed.
+func proxyMemoryLimits() (maxHeapSize uint64, bufferLimit uint32) {
+	if config.DefaultConfig.ProxyMaxHeap != "" {
+		maxHeapSize, _ = parseMemory(config.DefaultConfig.ProxyMaxHeap)
+	} else if config.DefaultConfig.ProxyMemory != "" {
+		memory, _ := parseMemory(config.DefaultConfig.ProxyMemory)
+		maxHeapSize = uint64(float64(memory) * proxyHeapRatio)
+	}
+	if config.DefaultConfig.ProxyBufferLimit != "" {
+		limit, _ := parseMemory(config.DefaultConfig.ProxyBufferLimit)
+		bufferLimit = uint32(limit)
+	}
+	return maxHeapSize, bufferLimit
+}
+
+// proxyLimitArgs returns the arguments of the run command limiting the resources of the
+// proxy container
+func proxyLimitArgs() []string {
+	args := []string{}
+	if config.DefaultConfig.ProxyMemory != "" {
+		// the same limit for the memory and the swap, so the proxy does not swap
+		args = append(args, "--memory="+config.DefaultConfig.ProxyMemory, "--memory-swap="+config.DefaultConfig.ProxyMemory)
+	}
+	if config.DefaultConfig.ProxyCPUs != "" {
+		args = append(args, "--cpus="+config.DefaultConfig.ProxyCPUs)
+	}
+	return args
+}
+
+// proxyCommand returns the command of the proxy container, empty to use the command of the
+// image. The image entrypoint runs envoy when the first argument is a flag.
+func proxyCommand() []string {
+	if config.DefaultConfig.ProxyConcurrency <= 0 {
+		return nil
+	}
+	return []string{"-c", proxyConfigPath, "--concurrency", strconv.Itoa(config.DefaultConfig.ProxyConcurrency)}
+}
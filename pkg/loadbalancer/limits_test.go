@@ -0,0 +1,106 @@
+package loadbalancer
+
+import (
+	"reflect"
+	"testing"
+
+	"sigs.k8s.io/cloud-provider-kind/pkg/config"
+)
+
+func TestValidateProxyLimits(t *testing.T) {
+	tests := []struct {
+		memory  string
+		cpus    string
+		wantErr bool
+	}{
+		{},
+		{memory: "128m", cpus: "0.5"},
+		{memory: "1G", cpus: "2"},
+		{memory: "134217728"},
+		{memory: "128Mi", wantErr: true},
+		{memory: "-1", wantErr: true},
+		{cpus: "0", wantErr: true},
+		{cpus: "half", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.memory+"/"+tt.cpus, func(t *testing.T) {
+			if err := ValidateProxyLimits(tt.memory, tt.cpus); (err != nil) != tt.wantErr {
+				t.Errorf("ValidateProxyLimits() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_proxyLimits(t *testing.T) {
+	defaults := *config.DefaultConfig
+	defer func() { *config.DefaultConfig = defaults }()
+
+	if args, command := proxyLimitArgs(), proxyCommand(); len(args) != 0 || command != nil {
+		t.Errorf("unexpected proxy limits %v and command %v without configuration", args, command)
+	}
+
+	config.DefaultConfig.ProxyMemory = "128m"
+	config.DefaultConfig.ProxyCPUs = "0.5"
+	config.DefaultConfig.ProxyConcurrency = 2
+	wantArgs := []string{"--memory=128m", "--memory-swap=128m", "--cpus=0.5"}
+	if got := proxyLimitArgs(); !reflect.DeepEqual(got, wantArgs) {
+		t.Errorf("proxyLimitArgs() = %v, want %v", got, wantArgs)
+	}
+	wantCommand := []string{"-c", proxyConfigPath, "--concurrency", "2"}
+	if got := proxyCommand(); !reflect.DeepEqual(got, wantCommand) {
+		t.Errorf("proxyCommand() = %v, want %v", got, wantCommand)
+	}
+}
+
+func TestValidateProxyMemoryLimits(t *testing.T) {
+	tests := []struct {
+		maxHeap     string
+		bufferLimit string
+		wantErr     bool
+	}{
+		{},
+		{maxHeap: "96m", bufferLimit: "32k"},
+		{maxHeap: "1g", bufferLimit: "65536"},
+		{maxHeap: "96Mi", wantErr: true},
+		{bufferLimit: "0", wantErr: true},
+		{bufferLimit: "8g", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.maxHeap+"/"+tt.bufferLimit, func(t *testing.T) {
+			if err := ValidateProxyMemoryLimits(tt.maxHeap, tt.bufferLimit); (err != nil) != tt.wantErr {
+				t.Errorf("ValidateProxyMemoryLimits() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_proxyMemoryLimits(t *testing.T) {
+	defaults := *config.DefaultConfig
+	defer func() { *config.DefaultConfig = defaults }()
+
+	tests := []struct {
+		name            string
+		memory          string
+		maxHeap         string
+		bufferLimit     string
+		wantMaxHeapSize uint64
+		wantBufferLimit uint32
+	}{
+		{name: "not limited"},
+		{name: "memory limit", memory: "100m", wantMaxHeapSize: 80 << 20},
+		{name: "max heap", memory: "100m", maxHeap: "90m", wantMaxHeapSize: 90 << 20},
+		{name: "buffer limit", bufferLimit: "32k", wantBufferLimit: 32 << 10},
+		{name: "bytes", maxHeap: "1000b", bufferLimit: "1024", wantMaxHeapSize: 1000, wantBufferLimit: 1024},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config.DefaultConfig.ProxyMemory = tt.memory
+			config.DefaultConfig.ProxyMaxHeap = tt.maxHeap
+			config.DefaultConfig.ProxyBufferLimit = tt.bufferLimit
+			maxHeapSize, bufferLimit := proxyMemoryLimits()
+			if maxHeapSize != tt.wantMaxHeapSize || bufferLimit != tt.wantBufferLimit {
+				t.Errorf("proxyMemoryLimits() = %d, %d, want %d, %d", maxHeapSize, bufferLimit, tt.wantMaxHeapSize, tt.wantBufferLimit)
+			}
+		})
+	}
+}
@@ -0,0 +1,141 @@
+package loadbalancer
+
+import (
+	"math"
+	"strconv"
+	"strings"
+
+	clusterv3 "github.com/envoyproxy/go-control-plane/envoy/config/cluster/v3"
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	endpointv3 "github.com/envoyproxy/go-control-plane/envoy/config/endpoint/v3"
+	routev3 "github.com/envoyproxy/go-control-plane/envoy/config/route/v3"
+	typev3 "github.com/envoyproxy/go-control-plane/envoy/type/v3"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+
+	"sigs.k8s.io/cloud-provider-kind/pkg/constants"
+)
+
+// mirror copies the requests of a listener terminating HTTP to another NodePort of the
+// nodes, the shadow traffic of a canary. Envoy can only mirror the HTTP requests, the
+// connections proxied at L4 are not copied.
+type mirror struct {
+	// NodePort receives the copies of the requests
+	NodePort int
+	// Percent is the percentage of the requests copied
+	Percent float64
+}
+
+// parseMirror returns the NodePorts of the annotation by port, the empty selector is the
+// NodePort of all the Service ports, or nil if the Service is not annotated. The invalid
+// entries are ignored.
+func parseMirror(service *v1.Service) map[string]int {
+	value, ok := service.Annotations[constants.MirrorAnnotation]
+	if !ok {
+		return nil
+	}
+	nodePorts := map[string]int{}
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		selector, nodePort, found := strings.Cut(entry, "=")
+		if !found {
+			selector, nodePort = "", entry
+		}
+		port, err := strconv.Atoi(strings.TrimSpace(nodePort))
+		if err != nil || port <= 0 || port > 65535 {
+			klog.InfoS("Ignoring invalid mirror NodePort", "service", klog.KObj(service), "annotation", constants.MirrorAnnotation, "value", entry)
+			continue
+		}
+		nodePorts[strings.TrimSpace(selector)] = port
+	}
+	return nodePorts
+}
+
+// parseMirrorPercent returns the percentage of the requests mirrored, 100 if the annotation
+// is not set or invalid
+func parseMirrorPercent(service *v1.Service) float64 {
+	if _, ok := service.Annotations[constants.MirrorPercentAnnotation]; !ok {
+		return 100
+	}
+	percent := parsePercentage(service, constants.MirrorPercentAnnotation)
+	if percent == 0 {
+		return 100
+	}
+	return percent
+}
+
+// portMirror returns the mirror of the Service port, by number or name, preferring the
+// entry of the port over the one of all the ports, or nil if it is not mirrored
+func portMirror(nodePorts map[string]int, port v1.ServicePort, percent float64) *mirror {
+	selectors := []string{strconv.Itoa(int(port.Port)), ""}
+	if port.Name != "" {
+		selectors = append([]string{port.Name}, selectors...)
+	}
+	for _, selector := range selectors {
+		if nodePort, ok := nodePorts[selector]; ok {
+			return &mirror{NodePort: nodePort, Percent: percent}
+		}
+	}
+	return nil
+}
+
+// Numerator returns the fraction of the requests mirrored in ten thousandths, kept for the
+// user templates
+func (m *mirror) Numerator() uint32 {
+	return uint32(math.Round(m.Percent * 100))
+}
+
+// requestMirrorPolicies returns the mirror policy of the route to the mirror cluster
+func requestMirrorPolicies(clusterName string, m *mirror) []*routev3.RouteAction_RequestMirrorPolicy {
+	policy := &routev3.RouteAction_RequestMirrorPolicy{Cluster: clusterName}
+	if m.Percent < 100 {
+		policy.RuntimeFraction = &corev3.RuntimeFractionalPercent{
+			DefaultValue: &typev3.FractionalPercent{Numerator: m.Numerator(), Denominator: typev3.FractionalPercent_TEN_THOUSAND},
+		}
+	}
+	return []*routev3.RouteAction_RequestMirrorPolicy{policy}
+}
+
+// mirrorClusterConfig returns the cluster receiving the mirrored requests, the backends of
+// the Service port with the mirror NodePort. The requests are sent in plain HTTP/1.1.
+func mirrorClusterConfig(key string, servicePort servicePort) (*clusterv3.Cluster, error) {
+	clusterName := "mirror_" + key
+	timeout, err := duration(defaultConnectTimeout)
+	if err != nil {
+		return nil, err
+	}
+	cluster := &clusterv3.Cluster{
+		Name:                 clusterName,
+		ConnectTimeout:       timeout,
+		ClusterDiscoveryType: &clusterv3.Cluster_Type{Type: clusterv3.Cluster_STATIC},
+		LbPolicy:             clusterv3.Cluster_RANDOM,
+	}
+	if servicePort.DNSLookupFamily != "" {
+		cluster.ClusterDiscoveryType = &clusterv3.Cluster_Type{Type: clusterv3.Cluster_STRICT_DNS}
+		cluster.DnsLookupFamily = clusterv3.Cluster_DnsLookupFamily(clusterv3.Cluster_DnsLookupFamily_value[servicePort.DNSLookupFamily])
+		if len(servicePort.DNSResolvers) > 0 {
+			cluster.TypedDnsResolverConfig, err = dnsResolverConfig(servicePort.DNSResolvers)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	endpoints := []*endpointv3.LocalityLbEndpoints{}
+	for _, backend := range servicePort.Cluster {
+		endpoints = append(endpoints, &endpointv3.LocalityLbEndpoints{
+			LbEndpoints: []*endpointv3.LbEndpoint{{
+				HostIdentifier: &endpointv3.LbEndpoint_Endpoint{Endpoint: &endpointv3.Endpoint{
+					Address: socketAddress(backend.Address.String(), servicePort.Mirror.NodePort, corev3.SocketAddress_TCP),
+				}},
+			}},
+		})
+	}
+	cluster.LoadAssignment = &endpointv3.ClusterLoadAssignment{
+		ClusterName: clusterName,
+		Endpoints:   endpoints,
+	}
+	return cluster, nil
+}
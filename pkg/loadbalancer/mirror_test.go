@@ -0,0 +1,112 @@
+package loadbalancer
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"sigs.k8s.io/cloud-provider-kind/pkg/constants"
+)
+
+func Test_parseMirror(t *testing.T) {
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		want        map[string]int
+		wantPercent float64
+	}{
+		{name: "not annotated", wantPercent: 100},
+		{
+			name:        "all the ports",
+			annotations: map[string]string{constants.MirrorAnnotation: "30080"},
+			want:        map[string]int{"": 30080},
+			wantPercent: 100,
+		},
+		{
+			name:        "by port",
+			annotations: map[string]string{constants.MirrorAnnotation: "30080, https=30443,8443=31443", constants.MirrorPercentAnnotation: "12.5%"},
+			want:        map[string]int{"": 30080, "https": 30443, "8443": 31443},
+			wantPercent: 12.5,
+		},
+		{
+			name:        "invalid entries",
+			annotations: map[string]string{constants.MirrorAnnotation: "https=canary,70000,443=30443", constants.MirrorPercentAnnotation: "150"},
+			want:        map[string]int{"443": 30443},
+			wantPercent: 100,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service := &v1.Service{ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default", Annotations: tt.annotations}}
+			if diff := cmp.Diff(tt.want, parseMirror(service)); diff != "" {
+				t.Errorf("parseMirror() mismatch (-want +got):\n%s", diff)
+			}
+			if got := parseMirrorPercent(service); got != tt.wantPercent {
+				t.Errorf("parseMirrorPercent() = %v, want %v", got, tt.wantPercent)
+			}
+		})
+	}
+}
+
+func Test_portMirror(t *testing.T) {
+	nodePorts := map[string]int{"": 30080, "443": 30443, "admin": 31000}
+	tests := []struct {
+		name      string
+		nodePorts map[string]int
+		port      v1.ServicePort
+		want      int
+	}{
+		{name: "not annotated", port: v1.ServicePort{Port: 80}},
+		{name: "not mirrored", nodePorts: map[string]int{"443": 30443}, port: v1.ServicePort{Port: 80}},
+		{name: "all the ports", nodePorts: nodePorts, port: v1.ServicePort{Port: 80}, want: 30080},
+		{name: "by number", nodePorts: nodePorts, port: v1.ServicePort{Port: 443}, want: 30443},
+		{name: "by name", nodePorts: nodePorts, port: v1.ServicePort{Name: "admin", Port: 443}, want: 31000},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := portMirror(tt.nodePorts, tt.port, 50)
+			if (got == nil) != (tt.want == 0) || (got != nil && (got.NodePort != tt.want || got.Percent != 50)) {
+				t.Errorf("portMirror() = %+v, want NodePort %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_generateConfigMirror(t *testing.T) {
+	nodes := []*v1.Node{makeNode("a", "10.0.0.1")}
+	service := makeHTTP3Service(map[string]string{
+		constants.HTTP3Annotation:          "terminate",
+		constants.HTTP3TLSSecretAnnotation: "web-tls",
+		constants.MirrorAnnotation:         "31443",
+		constants.MirrorPercentAnnotation:  "10",
+	},
+		v1.ServicePort{Name: "https", Port: 443, NodePort: 30443, Protocol: v1.ProtocolTCP},
+		v1.ServicePort{Name: "dns", Port: 53, NodePort: 30053, Protocol: v1.ProtocolUDP},
+	)
+	data := generateConfig(service, nodes)
+	for _, key := range []string{"IPv4_443_TCP", "IPv4_443_UDP"} {
+		if got := data.ServicePorts[key].Mirror; got == nil || got.NodePort != 31443 || got.Numerator() != 1000 {
+			t.Errorf("generateConfig() %s mirror = %+v, want 10%% of the requests to 31443", key, got)
+		}
+	}
+	// the UDP port is proxied at L4
+	if got := data.ServicePorts["IPv4_53_UDP"].Mirror; got != nil {
+		t.Errorf("generateConfig() UDP port mirror = %+v, want none", got)
+	}
+
+	files, err := proxyConfig(data)
+	if err != nil {
+		t.Fatalf("proxyConfig() error = %v", err)
+	}
+	for _, want := range []string{"name: mirror_IPv4_443_TCP", "name: mirror_IPv4_443_UDP", "port_value: 31443"} {
+		if !strings.Contains(files.Clusters, want) {
+			t.Errorf("clusters config does not contain %q\n%s", want, files.Clusters)
+		}
+	}
+	if !strings.Contains(files.Bootstrap, "cluster: mirror_IPv4_443_TCP") {
+		t.Errorf("bootstrap config does not mirror the requests\n%s", files.Bootstrap)
+	}
+}
@@ -0,0 +1,94 @@
+package loadbalancer
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"sort"
+
+	"k8s.io/klog/v2"
+
+	"sigs.k8s.io/cloud-provider-kind/pkg/config"
+	"sigs.k8s.io/cloud-provider-kind/pkg/constants"
+	"sigs.k8s.io/cloud-provider-kind/pkg/container"
+)
+
+// NetworkName returns the name of the container network used by default by the kind clusters
+func NetworkName() string {
+	if config.DefaultConfig.Network != "" {
+		return config.DefaultConfig.Network
+	}
+	if n := os.Getenv("KIND_EXPERIMENTAL_DOCKER_NETWORK"); n != "" {
+		return n
+	}
+	return constants.FixedNetworkName
+}
+
+// ClusterNetworks returns the networks the loadbalancers of the cluster are attached to,
+// the first one is the network the addresses are published from. If the network is not
+// forced with --network, these are the networks of the cluster nodes, so clusters created
+// on other networks are reachable, and the backends of the nodes spanning multiple
+// networks too.
+func ClusterNetworks(clusterName string) []string {
+	if config.DefaultConfig.Network != "" {
+		return []string{config.DefaultConfig.Network}
+	}
+	nodes, err := container.NodeContainers(clusterName)
+	if err != nil {
+		klog.InfoS("Unable to list the cluster nodes, using the default network", "cluster", clusterName, "network", NetworkName(), "err", err)
+		return []string{NetworkName()}
+	}
+	counts := map[string]int{}
+	for _, node := range nodes {
+		networks, err := container.Networks(node)
+		if err != nil {
+			klog.InfoS("Unable to get the node networks", "cluster", clusterName, "node", node, "err", err)
+			continue
+		}
+		for _, network := range networks {
+			counts[network]++
+		}
+	}
+	if len(counts) == 0 {
+		return []string{NetworkName()}
+	}
+	return sortNetworks(counts, NetworkName())
+}
+
+// sortNetworks sorts the networks by the number of nodes attached, the preferred network
+// goes first among the networks with the same number of nodes.
+func sortNetworks(counts map[string]int, preferred string) []string {
+	networks := []string{}
+	for network := range counts {
+		networks = append(networks, network)
+	}
+	sort.Slice(networks, func(i, j int) bool {
+		if counts[networks[i]] != counts[networks[j]] {
+			return counts[networks[i]] > counts[networks[j]]
+		}
+		if networks[i] == preferred || networks[j] == preferred {
+			return networks[i] == preferred
+		}
+		return networks[i] < networks[j]
+	})
+	return networks
+}
+
+// ensureNetworks attaches the loadbalancer to the networks it is not attached to yet,
+// ex. when new nodes are added on other networks.
+func ensureNetworks(name string, networks []string) error {
+	attached, err := container.Networks(name)
+	if err != nil {
+		return err
+	}
+	for _, network := range networks {
+		if slices.Contains(attached, network) {
+			continue
+		}
+		klog.V(2).InfoS("Attaching loadbalancer to network", "container", name, "network", network)
+		if err := container.ConnectNetwork(name, network); err != nil {
+			return fmt.Errorf("failed to attach loadbalancer %s to network %s: %w", name, network, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,26 @@
+package loadbalancer
+
+import (
+	"reflect"
+	"testing"
+)
+
+func Test_sortNetworks(t *testing.T) {
+	tests := []struct {
+		name   string
+		counts map[string]int
+		want   []string
+	}{
+		{name: "single network", counts: map[string]int{"kind": 3}, want: []string{"kind"}},
+		{name: "most nodes first", counts: map[string]int{"kind": 1, "other": 2}, want: []string{"other", "kind"}},
+		{name: "preferred network on tie", counts: map[string]int{"a": 2, "kind": 2, "b": 1}, want: []string{"kind", "a", "b"}},
+		{name: "sorted by name", counts: map[string]int{"b": 1, "a": 1}, want: []string{"a", "b"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sortNetworks(tt.counts, "kind"); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("sortNetworks() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
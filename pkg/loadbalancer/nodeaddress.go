@@ -0,0 +1,93 @@
+package loadbalancer
+
+import (
+	"fmt"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+	netutils "k8s.io/utils/net"
+
+	"sigs.k8s.io/cloud-provider-kind/pkg/config"
+	"sigs.k8s.io/cloud-provider-kind/pkg/constants"
+)
+
+// supportedNodeAddressTypes are the node address types that can be used as backends, the
+// hostnames are resolved by the proxy
+var supportedNodeAddressTypes = []v1.NodeAddressType{v1.NodeInternalIP, v1.NodeExternalIP, v1.NodeHostName}
+
+// ParseNodeAddressTypes parses a comma separated list of node address types in order of
+// preference, ex. InternalIP,ExternalIP, the types are case insensitive
+func ParseNodeAddressTypes(value string) ([]v1.NodeAddressType, error) {
+	types := []v1.NodeAddressType{}
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		found := false
+		for _, addressType := range supportedNodeAddressTypes {
+			if strings.EqualFold(item, string(addressType)) {
+				types = append(types, addressType)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("invalid node address type %q, supported types are %v", item, supportedNodeAddressTypes)
+		}
+	}
+	return types, nil
+}
+
+// nodeAddressTypes returns the node address types of the backends of the Service in order
+// of preference, from the annotation or the --node-address-type flag, InternalIP by default
+func nodeAddressTypes(service *v1.Service) []v1.NodeAddressType {
+	if value, ok := service.Annotations[constants.NodeAddressTypeAnnotation]; ok {
+		types, err := ParseNodeAddressTypes(value)
+		if err == nil {
+			return types
+		}
+		klog.InfoS("Ignoring invalid node address type", "service", klog.KObj(service), "annotation", constants.NodeAddressTypeAnnotation, "value", value, "err", err)
+	}
+	if config.DefaultConfig.NodeAddressTypes != "" {
+		types, err := ParseNodeAddressTypes(config.DefaultConfig.NodeAddressTypes)
+		if err == nil {
+			return types
+		}
+	}
+	return []v1.NodeAddressType{v1.NodeInternalIP}
+}
+
+// nodeAddresses returns the addresses of the node of the first address type, in order of
+// preference, with addresses of the IP family. The hostnames are returned for any IP family,
+// hostname is true if they have to be resolved.
+func nodeAddresses(node *v1.Node, types []v1.NodeAddressType, ipFamily v1.IPFamily) (addresses []string, hostname bool) {
+	for _, addressType := range types {
+		for _, addr := range node.Status.Addresses {
+			if addr.Type != addressType {
+				continue
+			}
+			if addressType == v1.NodeHostName {
+				addresses = append(addresses, addr.Address)
+				continue
+			}
+			// only addresses that match the Service IP family
+			if (netutils.IsIPv4String(addr.Address) && ipFamily != v1.IPv4Protocol) ||
+				(netutils.IsIPv6String(addr.Address) && ipFamily != v1.IPv6Protocol) {
+				continue
+			}
+			addresses = append(addresses, addr.Address)
+		}
+		if len(addresses) > 0 {
+			return addresses, addressType == v1.NodeHostName
+		}
+	}
+	klog.V(2).InfoS("Node has no address of the address types", "node", klog.KObj(node), "types", types, "family", ipFamily)
+	return nil, false
+}
+
+// dnsLookupFamily returns the envoy DNS lookup family of the IP family
+func dnsLookupFamily(ipFamily v1.IPFamily) string {
+	if ipFamily == v1.IPv6Protocol {
+		return "V6_ONLY"
+	}
+	return "V4_ONLY"
+}
@@ -0,0 +1,109 @@
+package loadbalancer
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+
+	"sigs.k8s.io/cloud-provider-kind/pkg/config"
+	"sigs.k8s.io/cloud-provider-kind/pkg/constants"
+)
+
+func TestParseNodeAddressTypes(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    []v1.NodeAddressType
+		wantErr bool
+	}{
+		{value: "InternalIP", want: []v1.NodeAddressType{v1.NodeInternalIP}},
+		{value: "externalip, internalip,Hostname", want: []v1.NodeAddressType{v1.NodeExternalIP, v1.NodeInternalIP, v1.NodeHostName}},
+		{value: "", wantErr: true},
+		{value: "InternalIP,InternalDNS", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := ParseNodeAddressTypes(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseNodeAddressTypes() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseNodeAddressTypes() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_nodeAddresses(t *testing.T) {
+	node := makeNode("a", "10.0.0.1")
+	node.Status.Addresses = append(node.Status.Addresses,
+		v1.NodeAddress{Type: v1.NodeInternalIP, Address: "fd00::1"},
+		v1.NodeAddress{Type: v1.NodeExternalIP, Address: "192.168.0.1"},
+		v1.NodeAddress{Type: v1.NodeHostName, Address: "kind-worker"},
+	)
+	tests := []struct {
+		name         string
+		types        []v1.NodeAddressType
+		family       v1.IPFamily
+		want         []string
+		wantHostname bool
+	}{
+		{name: "internal IPv4", types: []v1.NodeAddressType{v1.NodeInternalIP}, family: v1.IPv4Protocol, want: []string{"10.0.0.1"}},
+		{name: "internal IPv6", types: []v1.NodeAddressType{v1.NodeInternalIP}, family: v1.IPv6Protocol, want: []string{"fd00::1"}},
+		{name: "external first", types: []v1.NodeAddressType{v1.NodeExternalIP, v1.NodeInternalIP}, family: v1.IPv4Protocol, want: []string{"192.168.0.1"}},
+		{name: "no external IPv6", types: []v1.NodeAddressType{v1.NodeExternalIP, v1.NodeInternalIP}, family: v1.IPv6Protocol, want: []string{"fd00::1"}},
+		{name: "hostname", types: []v1.NodeAddressType{v1.NodeHostName}, family: v1.IPv6Protocol, want: []string{"kind-worker"}, wantHostname: true},
+		{name: "none", types: []v1.NodeAddressType{v1.NodeExternalIP}, family: v1.IPv6Protocol},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, hostname := nodeAddresses(node, tt.types, tt.family)
+			if !reflect.DeepEqual(got, tt.want) || hostname != tt.wantHostname {
+				t.Errorf("nodeAddresses() = %v, %v, want %v, %v", got, hostname, tt.want, tt.wantHostname)
+			}
+		})
+	}
+}
+
+func Test_nodeAddressTypes(t *testing.T) {
+	defer func(value string) { config.DefaultConfig.NodeAddressTypes = value }(config.DefaultConfig.NodeAddressTypes)
+	config.DefaultConfig.NodeAddressTypes = "ExternalIP,InternalIP"
+
+	service := makeService("test")
+	if got, want := nodeAddressTypes(service), []v1.NodeAddressType{v1.NodeExternalIP, v1.NodeInternalIP}; !reflect.DeepEqual(got, want) {
+		t.Errorf("nodeAddressTypes() = %v, want the flag %v", got, want)
+	}
+	service.Annotations = map[string]string{constants.NodeAddressTypeAnnotation: "Hostname"}
+	if got, want := nodeAddressTypes(service), []v1.NodeAddressType{v1.NodeHostName}; !reflect.DeepEqual(got, want) {
+		t.Errorf("nodeAddressTypes() = %v, want the annotation %v", got, want)
+	}
+	service.Annotations = map[string]string{constants.NodeAddressTypeAnnotation: "Invalid"}
+	if got, want := nodeAddressTypes(service), []v1.NodeAddressType{v1.NodeExternalIP, v1.NodeInternalIP}; !reflect.DeepEqual(got, want) {
+		t.Errorf("nodeAddressTypes() = %v, want the flag %v with an invalid annotation", got, want)
+	}
+}
+
+func Test_proxyConfigHostnameBackends(t *testing.T) {
+	node := makeNode("a", "10.0.0.1")
+	node.Status.Addresses = []v1.NodeAddress{{Type: v1.NodeHostName, Address: "kind-worker"}}
+	service := makeService("test")
+	service.Annotations = map[string]string{constants.NodeAddressTypeAnnotation: "InternalIP,Hostname"}
+	service.Spec.Type = v1.ServiceTypeLoadBalancer
+	service.Spec.IPFamilies = []v1.IPFamily{v1.IPv6Protocol}
+	service.Spec.Ports[0].NodePort = 30000
+	service.Spec.Ports[0].Protocol = v1.ProtocolTCP
+
+	got, err := proxyConfig(generateConfig(service, []*v1.Node{node}))
+	if err != nil {
+		t.Fatalf("proxyConfig() error = %v", err)
+	}
+	for _, want := range []string{"type: STRICT_DNS", "dns_lookup_family: V6_ONLY", "address: kind-worker"} {
+		if !strings.Contains(got.Clusters, want) {
+			t.Errorf("clusters config missing %q:\n%s", want, got.Clusters)
+		}
+	}
+	if strings.Contains(got.Clusters, "type: STATIC") {
+		t.Errorf("clusters config has a static cluster:\n%s", got.Clusters)
+	}
+}
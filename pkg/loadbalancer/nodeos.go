@@ -0,0 +1,46 @@
+package loadbalancer
+
+import (
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+
+	"sigs.k8s.io/cloud-provider-kind/pkg/constants"
+)
+
+const windowsOS = "windows"
+
+// nodeOS returns the operating system of the node, from the label set by the kubelet or
+// from the node info if the label was removed
+func nodeOS(node *v1.Node) string {
+	if os, ok := node.Labels[v1.LabelOSStable]; ok {
+		return strings.ToLower(os)
+	}
+	return strings.ToLower(node.Status.NodeInfo.OperatingSystem)
+}
+
+// hasWindowsNodes returns true if any of the backend nodes runs Windows
+func hasWindowsNodes(nodes []*v1.Node) bool {
+	for _, node := range nodes {
+		if nodeOS(node) == windowsOS {
+			return true
+		}
+	}
+	return false
+}
+
+// windowsHealthCheck returns the health check of the port for the backends with Windows
+// nodes, they may not serve the kube-proxy health port, so the TCP ports are checked with
+// a connection to the backend port and the UDP ports are not checked. The health check
+// annotation, even with the http default, takes precedence.
+func windowsHealthCheck(service *v1.Service, port v1.ServicePort, healthCheck string) string {
+	if _, ok := service.Annotations[constants.HealthCheckProtocolAnnotation]; ok || healthCheck != "" {
+		return healthCheck
+	}
+	klog.V(4).InfoS("Windows backends, not using the kube-proxy health port", "service", klog.KObj(service), "port", port.Port)
+	if port.Protocol == v1.ProtocolTCP {
+		return healthCheckTCP
+	}
+	return healthCheckNone
+}
@@ -0,0 +1,54 @@
+package loadbalancer
+
+import (
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+
+	"sigs.k8s.io/cloud-provider-kind/pkg/constants"
+)
+
+func Test_windowsBackends(t *testing.T) {
+	linux := makeNode("linux", "10.0.0.1")
+	linux.Labels = map[string]string{v1.LabelOSStable: "linux"}
+	windowsLabel := makeNode("windows-label", "10.0.0.2")
+	windowsLabel.Labels = map[string]string{v1.LabelOSStable: "windows"}
+	windowsInfo := makeNode("windows-info", "10.0.0.3")
+	windowsInfo.Status.NodeInfo.OperatingSystem = "Windows"
+
+	tests := []struct {
+		name        string
+		nodes       []*v1.Node
+		annotations map[string]string
+		wantTCP     string
+		wantUDP     string
+	}{
+		{name: "linux", nodes: []*v1.Node{linux}, wantTCP: "", wantUDP: ""},
+		{name: "windows label", nodes: []*v1.Node{linux, windowsLabel}, wantTCP: healthCheckTCP, wantUDP: healthCheckNone},
+		{name: "windows node info", nodes: []*v1.Node{windowsInfo}, wantTCP: healthCheckTCP, wantUDP: healthCheckNone},
+		{name: "explicit http", nodes: []*v1.Node{windowsInfo}, annotations: map[string]string{constants.HealthCheckProtocolAnnotation: "http"}, wantTCP: "", wantUDP: ""},
+		{name: "explicit none", nodes: []*v1.Node{windowsInfo}, annotations: map[string]string{constants.HealthCheckProtocolAnnotation: "none"}, wantTCP: healthCheckNone, wantUDP: healthCheckNone},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service := makeService("test")
+			service.Annotations = tt.annotations
+			service.Spec.Type = v1.ServiceTypeLoadBalancer
+			service.Spec.IPFamilies = []v1.IPFamily{v1.IPv4Protocol}
+			service.Spec.Ports = []v1.ServicePort{
+				{Name: "tcp", Port: 80, NodePort: 30000, Protocol: v1.ProtocolTCP},
+				{Name: "udp", Port: 53, NodePort: 30001, Protocol: v1.ProtocolUDP},
+			}
+			got := generateConfig(service, tt.nodes)
+			if len(got.ServicePorts["IPv4_80_TCP"].Cluster) != len(tt.nodes) {
+				t.Errorf("got backends %v, want all the nodes", got.ServicePorts["IPv4_80_TCP"].Cluster)
+			}
+			if check := got.ServicePorts["IPv4_80_TCP"].HealthCheck; check != tt.wantTCP {
+				t.Errorf("TCP port health check = %q, want %q", check, tt.wantTCP)
+			}
+			if check := got.ServicePorts["IPv4_53_UDP"].HealthCheck; check != tt.wantUDP {
+				t.Errorf("UDP port health check = %q, want %q", check, tt.wantUDP)
+			}
+		})
+	}
+}
@@ -0,0 +1,109 @@
+package loadbalancer
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+
+	"sigs.k8s.io/cloud-provider-kind/pkg/container"
+)
+
+// Some kernels and drivers mangle the UDP checksums of the packets that cross the
+// veth and bridge paths when the tx checksum offload is enabled, the packets
+// proxied by envoy are silently dropped by the receivers.
+// The workaround disables the tx offload on the loadbalancer interfaces so the
+// checksums are computed in software. The envoy image does not ship ethtool so it
+// runs on an ephemeral container with the kind node image sharing the loadbalancer
+// network namespace.
+const (
+	// UDPChecksumWorkaroundAuto applies the workaround to loadbalancers with UDP ports if offload is enabled
+	UDPChecksumWorkaroundAuto = "auto"
+	// UDPChecksumWorkaroundAlways applies the workaround to all the loadbalancers
+	UDPChecksumWorkaroundAlways = "always"
+	// UDPChecksumWorkaroundNever never applies the workaround
+	UDPChecksumWorkaroundNever = "never"
+)
+
+// disableTxOffloadScript disables the tx offload on all the interfaces that have it
+// enabled and prints the interfaces modified.
+const disableTxOffloadScript = `for dev in $(ls /sys/class/net); do
+  [ "$dev" = lo ] && continue
+  if ethtool -k "$dev" | grep -q "^tx-checksumming: on"; then
+    ethtool -K "$dev" tx off >/dev/null && echo "$dev"
+  fi
+done`
+
+// needsUDPChecksumWorkaround returns true if the workaround has to be applied to the Service
+func needsUDPChecksumWorkaround(mode string, service *v1.Service) bool {
+	switch mode {
+	case UDPChecksumWorkaroundAlways:
+		return true
+	case UDPChecksumWorkaroundNever:
+		return false
+	}
+	for _, port := range service.Spec.Ports {
+		if port.Protocol == v1.ProtocolUDP {
+			return true
+		}
+	}
+	return false
+}
+
+// ensureUDPChecksumWorkaround disables the tx checksum offload on the loadbalancer
+// interfaces if required by any of its Services. The setting does not survive container
+// restarts, so it has to be applied every time the loadbalancer is restarted.
+func ensureUDPChecksumWorkaround(ctx context.Context, mode string, clusterName string, name string, services []*v1.Service) error {
+	needed := false
+	for _, service := range services {
+		needed = needed || needsUDPChecksumWorkaround(mode, service)
+	}
+	if !needed {
+		return nil
+	}
+	image, err := nodeImage(clusterName)
+	if err != nil {
+		return err
+	}
+	devices, err := container.RunInNetNS(name, image, []string{"sh", "-c", disableTxOffloadScript})
+	if err != nil {
+		return fmt.Errorf("failed to disable tx checksum offload on loadbalancer %s: %w", name, err)
+	}
+	if len(devices) > 0 {
+		klog.FromContext(ctx).V(2).Info("Disabled tx checksum offload", "interfaces", devices)
+	}
+	return nil
+}
+
+// nodeImages caches the image of the nodes of each cluster, it is looked up by every
+// loadbalancer that runs an ephemeral container.
+var nodeImages sync.Map
+
+// ForgetCluster drops the data cached for the cluster once it is deleted, a new cluster with
+// the same name can use another node image
+func ForgetCluster(clusterName string) {
+	nodeImages.Delete(clusterName)
+}
+
+// nodeImage returns the image of the cluster nodes, it is used to run the tools missing in
+// the envoy image from ephemeral containers sharing the loadbalancer network namespace.
+func nodeImage(clusterName string) (string, error) {
+	if image, ok := nodeImages.Load(clusterName); ok {
+		return image.(string), nil
+	}
+	nodes, err := container.NodeContainers(clusterName)
+	if err != nil {
+		return "", err
+	}
+	if len(nodes) == 0 {
+		return "", fmt.Errorf("no nodes found for cluster %s", clusterName)
+	}
+	image, err := container.Image(nodes[0])
+	if err != nil {
+		return "", err
+	}
+	nodeImages.Store(clusterName, image)
+	return image, nil
+}
@@ -0,0 +1,113 @@
+package loadbalancer
+
+import (
+	"fmt"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+
+	"sigs.k8s.io/cloud-provider-kind/pkg/constants"
+	"sigs.k8s.io/cloud-provider-kind/pkg/container"
+)
+
+// Action is the change that would be applied to a loadbalancer
+type Action string
+
+const (
+	ActionNone   Action = "None"
+	ActionCreate Action = "Create"
+	ActionUpdate Action = "Update"
+	ActionDelete Action = "Delete"
+)
+
+// Plan describes what would be done to reconcile the loadbalancer of a Service
+type Plan struct {
+	Cluster      string `json:"cluster"`
+	Namespace    string `json:"namespace"`
+	Service      string `json:"service"`
+	LoadBalancer string `json:"loadBalancer"`
+	Action       Action `json:"action"`
+	Reason       string `json:"reason,omitempty"`
+}
+
+// PlanLoadBalancer returns the action required to reconcile the loadbalancer of the Service
+// without modifying anything, services are all the Services of the cluster to find the ones
+// sharing the loadbalancer.
+func PlanLoadBalancer(clusterName string, service *v1.Service, services []*v1.Service, nodes []*v1.Node) Plan {
+	name := loadBalancerName(clusterName, service)
+	plan := Plan{
+		Cluster:      clusterName,
+		Namespace:    service.Namespace,
+		Service:      service.Name,
+		LoadBalancer: name,
+		Action:       ActionNone,
+	}
+
+	if !container.Exist(name) {
+		plan.Action = ActionCreate
+		plan.Reason = "loadbalancer container does not exist"
+		return plan
+	}
+	if !container.IsRunning(name) {
+		plan.Action = ActionCreate
+		plan.Reason = "loadbalancer container is not running"
+		return plan
+	}
+
+	data, err := sharedConfig(service, sharedIPGroup(service, services), nodes)
+	if err != nil {
+		plan.Action = ActionUpdate
+		plan.Reason = err.Error()
+		return plan
+	}
+	config, err := proxyConfig(data)
+	if err != nil {
+		plan.Action = ActionUpdate
+		plan.Reason = fmt.Sprintf("error generating config: %v", err)
+		return plan
+	}
+	current, err := readProxyConfig(name)
+	if err != nil {
+		plan.Action = ActionUpdate
+		plan.Reason = fmt.Sprintf("error reading current config: %v", err)
+		return plan
+	}
+	if current != config {
+		plan.Action = ActionUpdate
+		plan.Reason = "loadbalancer config is outdated"
+	}
+	return plan
+}
+
+// PlanOrphans returns the loadbalancers of the cluster that do not belong to any of
+// the LoadBalancer Services and would be deleted.
+func PlanOrphans(clusterName string, services []*v1.Service) ([]Plan, error) {
+	containers, err := container.ListLabelValues(fmt.Sprintf("%s=%s", constants.NodeCCMLabelKey, clusterName), constants.LoadBalancerNameLabelKey)
+	if err != nil {
+		return nil, err
+	}
+	expected := sets.New[string]()
+	for _, service := range services {
+		expected.Insert(loadBalancerSimpleName(clusterName, service))
+	}
+
+	plans := []Plan{}
+	for _, v := range containers {
+		if expected.Has(v) {
+			continue
+		}
+		_, service := ServiceFromLoadBalancerSimpleName(v)
+		if service == nil {
+			continue
+		}
+		plans = append(plans, Plan{
+			Cluster:      clusterName,
+			Namespace:    service.Namespace,
+			Service:      service.Name,
+			LoadBalancer: loadBalancerName(clusterName, service),
+			Action:       ActionDelete,
+			Reason:       "Service does not exist or is not of type LoadBalancer",
+		})
+	}
+	return plans, nil
+}
@@ -0,0 +1,70 @@
+package loadbalancer
+
+import (
+	"errors"
+	"time"
+)
+
+// The reasons of the provisioning failures, reported in the LoadBalancerReady condition
+// of the Services so the users can see why a Service is pending.
+const (
+	ReasonImagePullFailed    = "ImagePullFailed"
+	ReasonContainerFailed    = "ContainerCreateFailed"
+	ReasonIPAllocationFailed = "IPAllocationFailed"
+	ReasonConfigFailed       = "ConfigFailed"
+	ReasonInvalidConfig      = "InvalidProxyConfig"
+	ReasonNoNodes            = "NoNodes"
+	ReasonNoHealthyBackends  = "NoHealthyBackends"
+	ReasonNoEndpoints        = "NoEndpoints"
+	ReasonPortConflict       = "PortConflict"
+	ReasonQuotaExceeded      = "QuotaExceeded"
+	ReasonInjectedFailure    = "InjectedFailure"
+	ReasonAdoptionFailed     = "AdoptionFailed"
+	ReasonUnsupportedFeature = "UnsupportedFeature"
+)
+
+// The errors of the ports in the Service status, the ports are not reachable on the
+// loadbalancer addresses with them.
+const (
+	// PortErrorUnsupportedProtocol is the error of the ports with a protocol the proxy does
+	// not support, ex. SCTP
+	PortErrorUnsupportedProtocol = "kind.x-k8s.io/UnsupportedProtocol"
+	// PortErrorNotListening is the error of the ports the loadbalancer does not listen on for
+	// the IP family of the address, ex. restricted by the listen-addresses annotation
+	PortErrorNotListening = "kind.x-k8s.io/NotListening"
+	// PortErrorNotPublished is the error of the ports that are not published on the host, the
+	// ports of the Services sharing the loadbalancer added after it was created
+	PortErrorNotPublished = "kind.x-k8s.io/NotPublished"
+)
+
+// ProvisioningError is returned when the loadbalancer can not be provisioned, the Reason
+// identifies the step that failed.
+type ProvisioningError struct {
+	Reason string
+	Err    error
+	// RequeueAfter is set when the loadbalancer waits for a state that is not watched, ex.
+	// the health checks of the proxy, the Service is synced again after it instead of
+	// backing off
+	RequeueAfter time.Duration
+}
+
+func (e *ProvisioningError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *ProvisioningError) Unwrap() error {
+	return e.Err
+}
+
+// provisioningError returns a ProvisioningError with the reason, or nil if err is nil. The
+// errors that already have a reason keep it, it is more specific than the step that failed.
+func provisioningError(reason string, err error) error {
+	if err == nil {
+		return nil
+	}
+	var provisioningErr *ProvisioningError
+	if errors.As(err, &provisioningErr) {
+		return err
+	}
+	return &ProvisioningError{Reason: reason, Err: err}
+}
@@ -3,17 +3,25 @@ package loadbalancer
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"net/netip"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
 	"text/template"
 	"time"
 
 	"github.com/pkg/errors"
 	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
 	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/klog/v2"
-	netutils "k8s.io/utils/net"
 
+	"sigs.k8s.io/cloud-provider-kind/pkg/config"
+	"sigs.k8s.io/cloud-provider-kind/pkg/constants"
 	"sigs.k8s.io/cloud-provider-kind/pkg/container"
 )
 
@@ -23,11 +31,38 @@ const proxyImage = "envoyproxy/envoy:v1.30.1"
 // proxyConfigPath defines the path to the config file in the image
 const proxyConfigPath = "/etc/envoy/envoy.yaml"
 
+// proxyClustersPath defines the path to the file with the clusters config
+const proxyClustersPath = "/etc/envoy/cds.yaml"
+
+// proxyConfigHashPath defines the path to the file with the hash of the applied config
+const proxyConfigHashPath = "/etc/envoy/envoy.yaml.sha256"
+
+// labelNodeRoleControlPlane is set by kubeadm on the control plane nodes
+const labelNodeRoleControlPlane = "node-role.kubernetes.io/control-plane"
+
+// toBeDeletedTaint is added by the cluster-autoscaler to the nodes that are going to be removed
+const toBeDeletedTaint = "ToBeDeletedByClusterAutoscaler"
+
+// the protocols of the backends health checks, none disables them
+const (
+	healthCheckHTTP = "http"
+	healthCheckGRPC = "grpc"
+	healthCheckTCP  = "tcp"
+	healthCheckNone = "none"
+)
+
 // proxyConfigData is supplied to the loadbalancer config template
 type proxyConfigData struct {
-	HealthCheckPort int                    // is the same for all ServicePorts
+	HealthCheckPort int                    // default of the ServicePorts without their own
 	ServicePorts    map[string]servicePort // key is the IP family and Port and Protocol to support MultiPort services
 	SessionAffinity string
+	// MaxHeapSize enables the overload manager of the proxy with this heap size in bytes,
+	// BufferLimit limits the buffers of each connection in bytes, 0 for the envoy defaults
+	MaxHeapSize uint64
+	BufferLimit uint32
+	// DNSResolvers are the DNS servers resolving the hostname backends of all the clusters,
+	// the resolvers of the proxy container if empty
+	DNSResolvers []netip.AddrPort
 }
 
 type servicePort struct {
@@ -35,106 +70,87 @@ type servicePort struct {
 	Listener endpoint
 	// backend
 	Cluster []endpoint
+	// DNSLookupFamily is set if the backends are hostnames resolved by the proxy, the
+	// V4_ONLY or V6_ONLY lookup family of the IP family of the port
+	DNSLookupFamily string
+	// DNSResolvers are the DNS servers resolving the hostname backends of the Service,
+	// overriding the DNSResolvers of the config
+	DNSResolvers []netip.AddrPort
+	// fault injection, nil if disabled
+	Fault *fault
+	// HealthCheck is the protocol of the backends health checks, empty for the default
+	// HTTP check of the node health port and none to disable them
+	HealthCheck string
+	// HealthCheckPort is the port of the HTTP health checks, the HealthCheckPort of the
+	// config if 0, it can be different per IP family and per port
+	HealthCheckPort int
+	// ConnectTimeout is the timeout of the connections to the backends, empty for the default
+	ConnectTimeout string
+	// IdleTimeout closes the connections, or the UDP sessions, without traffic, empty for the
+	// envoy default and 0s to disable it
+	IdleTimeout string
+	// MaxConnections and MaxPendingRequests are the circuit breaker limits of the backends,
+	// 0 for the envoy defaults
+	MaxConnections     int
+	MaxPendingRequests int
+	// HealthyPanicThreshold is the percentage of healthy backends under which the traffic is
+	// sent to all the backends, nil for the envoy default
+	HealthyPanicThreshold *float64
+	// SlowStartWindow is the duration the traffic of the new backends is ramped up during,
+	// empty to send them their share of the traffic immediately
+	SlowStartWindow string
+	// ListenerFilters are the extra network filters of the TCP listener, each one encoded
+	// as JSON, and ClusterOptions the extra fields of the cluster with their JSON values
+	ListenerFilters []string
+	ClusterOptions  map[string]string
+	// HTTP3 is the HTTP/3 mode of the port, passthrough on the UDP ports forwarding QUIC and
+	// terminate on the listeners terminating TLS and HTTP, empty for the plain proxies
+	HTTP3 string
+	// TLSSecret is the Secret with the certificate of the listeners terminating TLS, in the
+	// format namespace/name
+	TLSSecret string
+	// AllowedSources are the source ranges of the clients of the TCP listener, nil allows
+	// all the clients and an empty list none
+	AllowedSources []netip.Prefix
+	// Mirror copies the requests of the listener terminating HTTP, nil if not mirrored
+	Mirror *mirror
+}
+
+// proxyFiles is the rendered loadbalancer config
+type proxyFiles struct {
+	// Bootstrap is the main config with the listeners, changing it requires a restart
+	Bootstrap string
+	// Clusters is the config of the clusters, reloaded by the proxy when it changes
+	Clusters string
+	// Faults is the script installing the fault injection rules, empty if there are none
+	Faults string
+}
+
+// proxyConfig returns the loadbalancer config generated from config data
+func proxyConfig(data *proxyConfigData) (proxyFiles, error) {
+	var bootstrap string
+	var err error
+	if config.DefaultConfig.ProxyConfigTemplate != "" {
+		bootstrap, err = renderTemplate(config.DefaultConfig.ProxyConfigTemplate, data)
+	} else {
+		bootstrap, err = bootstrapConfig(data)
+	}
+	if err != nil {
+		return proxyFiles{}, err
+	}
+	clusters, err := clustersConfig(data)
+	if err != nil {
+		return proxyFiles{}, err
+	}
+	faults, err := faultScript(data)
+	if err != nil {
+		return proxyFiles{}, err
+	}
+	return proxyFiles{Bootstrap: bootstrap, Clusters: clusters, Faults: faults}, nil
 }
 
-type endpoint struct {
-	Address  string
-	Port     int
-	Protocol string
-}
-
-// proxyDefaultConfigTemplate is the loadbalancer config template
-const proxyDefaultConfigTemplate = `
-admin:
-  address:
-    socket_address: { address: 127.0.0.1, port_value: 9901 }
-
-static_resources:
-  listeners:
-  {{- range $index, $servicePort := .ServicePorts }}
-  - name: listener_{{$index}}
-    address:
-      socket_address:
-        address: {{ $servicePort.Listener.Address }}
-        port_value: {{ $servicePort.Listener.Port }}
-        protocol: {{ $servicePort.Listener.Protocol }}
-    {{- if eq $servicePort.Listener.Protocol "UDP"}}
-    udp_listener_config:
-      downstream_socket_config:
-        max_rx_datagram_size: 9000
-    listener_filters:
-    - name: envoy.filters.udp_listener.udp_proxy
-      typed_config:
-        '@type': type.googleapis.com/envoy.extensions.filters.udp.udp_proxy.v3.UdpProxyConfig
-        stat_prefix: cluster_{{$index}}
-        matcher:
-          on_no_match:
-            action:
-              name: route
-              typed_config:
-                '@type': type.googleapis.com/envoy.extensions.filters.udp.udp_proxy.v3.Route
-                cluster: cluster_{{$index}}
-        {{- if eq $.SessionAffinity "ClientIP"}}
-        hash_policies:
-          source_ip: true
-        {{- end}}
-        upstream_socket_config:
-          max_rx_datagram_size: 9000
-    {{- else }}
-    filter_chains:
-      - filters:
-        - name: envoy.filters.network.tcp_proxy
-          typed_config:
-            "@type": type.googleapis.com/envoy.extensions.filters.network.tcp_proxy.v3.TcpProxy
-            stat_prefix: destination
-            cluster: cluster_{{$index}}
-            {{- if eq $.SessionAffinity "ClientIP"}}
-            hash_policy:
-              source_ip: {}
-            {{- end}}
-    {{- end}}
-  {{- end }}
-
-  clusters:
-  {{- range $index, $servicePort := .ServicePorts }}
-  - name: cluster_{{$index}}
-    connect_timeout: 5s
-    type: STATIC
-    {{- if eq $.SessionAffinity "ClientIP"}}
-    lb_policy: RING_HASH
-    {{- else}}
-    lb_policy: RANDOM
-    {{- end}}
-    health_checks:
-      - timeout: 5s
-        interval: 3s
-        unhealthy_threshold: 3
-        healthy_threshold: 1
-        always_log_health_check_failures: true
-        always_log_health_check_success: true
-        http_health_check:
-          path: /healthz
-    load_assignment:
-      cluster_name: cluster_{{$index}}
-      endpoints:
-      {{- range $address := $servicePort.Cluster }}
-        - lb_endpoints:
-          - endpoint:
-              health_check_config:
-                port_value: {{ $.HealthCheckPort  }}
-              address:
-                socket_address:
-                  address: {{ $address.Address }}
-                  port_value: {{ $address.Port }}
-                  protocol: {{ $address.Protocol }}
-      {{- end}}
-  {{- end }}
-`
-
-// proxyConfig returns a kubeadm config generated from config data, in particular
-// the kubernetes version
-func proxyConfig(data *proxyConfigData) (config string, err error) {
-	t, err := template.New("loadbalancer-config").Parse(proxyDefaultConfigTemplate)
+func renderTemplate(text string, data *proxyConfigData) (string, error) {
+	t, err := template.New("loadbalancer-config").Funcs(templateFuncs).Parse(text)
 	if err != nil {
 		return "", errors.Wrap(err, "failed to parse config template")
 	}
@@ -156,77 +172,530 @@ func generateConfig(service *v1.Service, nodes []*v1.Node) *proxyConfigData {
 		hcPort = int(service.Spec.HealthCheckNodePort)
 	}
 
+	maxHeapSize, bufferLimit := proxyMemoryLimits()
 	lbConfig := &proxyConfigData{
 		HealthCheckPort: hcPort,
 		SessionAffinity: string(service.Spec.SessionAffinity),
+		MaxHeapSize:     maxHeapSize,
+		BufferLimit:     bufferLimit,
+		DNSResolvers:    defaultDNSResolvers(),
 	}
 
+	nodes = backendNodes(nodes)
+	windows := hasWindowsNodes(nodes)
+	listenAddresses := parseListenAddresses(service)
+	healthCheckPorts := parseHealthCheckPorts(service)
+	fault := parseFault(service)
+	connectTimeout := parseTimeout(service, constants.ConnectTimeoutAnnotation, false)
+	idleTimeout := parseTimeout(service, constants.IdleTimeoutAnnotation, true)
+	maxConnections := parseLimit(service, constants.MaxConnectionsAnnotation)
+	maxPendingRequests := parseLimit(service, constants.MaxPendingRequestsAnnotation)
+	panicThreshold := parseHealthyPanicThreshold(service)
+	slowStartWindow := parseTimeout(service, constants.SlowStartWindowAnnotation, false)
+	hostNetwork := hostNetworkBackends(service)
+	addressTypes := nodeAddressTypes(service)
+	listenerFilters := parseListenerFilters(service)
+	clusterOptions := parseClusterOptions(service)
+	http3, tlsSecret := parseHTTP3(service)
+	allowed := parseAllowedSources(service)
+	mirrors, mirrorPercent := parseMirror(service), parseMirrorPercent(service)
+	resolvers := parseDNSResolvers(service)
+	externalBackends := parseExternalBackends(service)
 	servicePortConfig := map[string]servicePort{}
 	for _, ipFamily := range service.Spec.IPFamilies {
 		for _, port := range service.Spec.Ports {
 			if port.Protocol != v1.ProtocolTCP && port.Protocol != v1.ProtocolUDP {
-				klog.Infof("service port protocol %s not supported", port.Protocol)
+				klog.InfoS("Service port protocol not supported", "service", klog.KObj(service), "protocol", port.Protocol)
 				continue
 			}
 			key := fmt.Sprintf("%s_%d_%s", ipFamily, port.Port, port.Protocol)
-			bind := `0.0.0.0`
-			if ipFamily == v1.IPv6Protocol {
-				bind = `"::"`
+			bind, ok := listenAddress(listenAddresses, port, ipFamily)
+			if !ok {
+				klog.V(2).InfoS("Service port not listening on IP family", "service", klog.KObj(service), "port", port.Port, "family", ipFamily)
+				continue
+			}
+
+			backendPort := int(port.NodePort)
+			// the gRPC and TCP health checks probe the backend port of the application
+			healthCheck := healthCheckProtocol(service, port)
+			if hostNetwork {
+				if targetPort, ok := hostNetworkPort(service, port); ok {
+					backendPort = targetPort
+					// only the nodes running a Pod accept the connections on the port
+					if healthCheck == "" && port.Protocol == v1.ProtocolTCP {
+						healthCheck = healthCheckTCP
+					}
+				}
+			}
+
+			if windows {
+				healthCheck = windowsHealthCheck(service, port, healthCheck)
 			}
 
 			backends := []endpoint{}
+			dnsFamily := ""
 			for _, n := range nodes {
-				for _, addr := range n.Status.Addresses {
-					// only internal IPs supported
-					if addr.Type != v1.NodeInternalIP {
-						klog.V(2).Infof("address type %s, only %s supported", addr.Type, v1.NodeInternalIP)
+				addresses, hostname := nodeAddresses(n, addressTypes, ipFamily)
+				if hostname {
+					// the proxy resolves the hostnames of the nodes
+					dnsFamily = dnsLookupFamily(ipFamily)
+				}
+				for _, value := range addresses {
+					backend, err := parseAddress(value)
+					if err != nil {
+						klog.InfoS("Ignoring invalid node address", "service", klog.KObj(service), "node", klog.KObj(n), "err", err)
 						continue
 					}
-					// only addresses that match the Service IP family
-					if (netutils.IsIPv4String(addr.Address) && ipFamily != v1.IPv4Protocol) ||
-						(netutils.IsIPv6String(addr.Address) && ipFamily != v1.IPv6Protocol) {
-						continue
+					backends = append(backends, endpoint{Address: backend, Port: backendPort, Protocol: string(port.Protocol)})
+				}
+			}
+
+			if external, ok := portExternalBackends(externalBackends, port); ok {
+				backends, dnsFamily = external, ""
+				healthCheck = externalHealthCheck(port, healthCheck)
+				for _, backend := range backends {
+					if !backend.Address.IP().IsValid() {
+						// the proxy resolves the hostnames of the external backends
+						dnsFamily = dnsLookupFamily(ipFamily)
 					}
-					backends = append(backends, endpoint{Address: addr.Address, Port: int(port.NodePort), Protocol: string(port.Protocol)})
 				}
 			}
 
+			// the UDP listeners do not have network filters
+			filters, sources := listenerFilters, portAllowedSources(allowed, port, ipFamily)
+			if port.Protocol == v1.ProtocolUDP {
+				filters, sources = nil, nil
+			}
+
+			portHTTP3, portTLSSecret := "", ""
+			var portMirrorConfig *mirror
+			switch {
+			case http3 == HTTP3Passthrough && port.Protocol == v1.ProtocolUDP:
+				portHTTP3 = http3
+			case http3 == HTTP3Terminate && port.Protocol == v1.ProtocolTCP:
+				portHTTP3, portTLSSecret = http3, tlsSecret
+				// only the requests of the listeners terminating HTTP are mirrored
+				portMirrorConfig = portMirror(mirrors, port, mirrorPercent)
+			}
+
+			// the resolvers only apply to the backends resolved by the proxy
+			var portResolvers []netip.AddrPort
+			if dnsFamily != "" {
+				portResolvers = resolvers
+			}
+
 			servicePortConfig[key] = servicePort{
-				Listener: endpoint{Address: bind, Port: int(port.Port), Protocol: string(port.Protocol)},
-				Cluster:  backends,
+				Listener:        endpoint{Address: bind, Port: int(port.Port), Protocol: string(port.Protocol)},
+				Cluster:         backends,
+				DNSLookupFamily: dnsFamily,
+				DNSResolvers:    portResolvers,
+				Fault:           fault,
+				HealthCheck:     healthCheck,
+				HealthCheckPort: healthCheckPort(healthCheckPorts, port, ipFamily),
+				ConnectTimeout:  connectTimeout,
+				IdleTimeout:     idleTimeout,
+				// the limits apply to each port of the Service
+				MaxConnections:        maxConnections,
+				MaxPendingRequests:    maxPendingRequests,
+				HealthyPanicThreshold: panicThreshold,
+				SlowStartWindow:       slowStartWindow,
+				ListenerFilters:       filters,
+				ClusterOptions:        clusterOptions,
+				HTTP3:                 portHTTP3,
+				TLSSecret:             portTLSSecret,
+				AllowedSources:        sources,
+				Mirror:                portMirrorConfig,
 			}
 		}
 	}
+	withHTTP3Listeners(servicePortConfig)
 	lbConfig.ServicePorts = servicePortConfig
-	klog.V(2).Infof("haproxy config info: %+v", lbConfig)
+	klog.V(4).InfoS("Generated loadbalancer config", "service", klog.KObj(service), "config", lbConfig)
 	return lbConfig
 }
 
-func proxyUpdateLoadBalancer(ctx context.Context, clusterName string, service *v1.Service, nodes []*v1.Node) error {
-	if service == nil {
+// EligibleNodes returns the nodes that can be loadbalancer backends, the nodes with the
+// exclude-from-external-load-balancers label or that are going to be removed by the
+// cluster-autoscaler are never used, matching the upstream cloud-provider behavior.
+func EligibleNodes(nodes []*v1.Node) []*v1.Node {
+	result := []*v1.Node{}
+	for _, n := range nodes {
+		if _, ok := n.Labels[v1.LabelNodeExcludeBalancers]; ok {
+			klog.V(2).InfoS("Node excluded from the loadbalancers", "node", klog.KObj(n))
+			continue
+		}
+		if slices.ContainsFunc(n.Spec.Taints, func(taint v1.Taint) bool { return taint.Key == toBeDeletedTaint }) {
+			klog.V(2).InfoS("Node to be deleted, excluded from the loadbalancers", "node", klog.KObj(n))
+			continue
+		}
+		result = append(result, n)
+	}
+	return result
+}
+
+// backendNodes returns the nodes used as loadbalancer backends, out of the EligibleNodes.
+// The control plane nodes are only used if there are no workers, kind single node clusters
+// run the workloads in the control plane. The nodes that are not Ready or are
+// unschedulable are skipped unless IncludeUnreadyNodes is set.
+func backendNodes(nodes []*v1.Node) []*v1.Node {
+	workers := []*v1.Node{}
+	controlPlanes := []*v1.Node{}
+	for _, n := range EligibleNodes(nodes) {
+		if !config.DefaultConfig.IncludeUnreadyNodes && (!NodeReady(n) || n.Spec.Unschedulable) {
+			klog.V(2).InfoS("Node not ready or unschedulable, excluded from the loadbalancers", "node", klog.KObj(n))
+			continue
+		}
+		if _, ok := n.Labels[labelNodeRoleControlPlane]; ok {
+			controlPlanes = append(controlPlanes, n)
+			continue
+		}
+		workers = append(workers, n)
+	}
+	if len(workers) == 0 {
+		return controlPlanes
+	}
+	return workers
+}
+
+// parseTimeout returns the timeout of the annotation in the envoy duration format, or empty
+// if it is not set or invalid. The timeouts must be positive, zero disables the timeouts
+// that allow it.
+func parseTimeout(service *v1.Service, annotation string, allowZero bool) string {
+	value, ok := service.Annotations[annotation]
+	if !ok {
+		return ""
+	}
+	timeout, err := time.ParseDuration(strings.TrimSpace(value))
+	if err != nil || timeout < 0 || (timeout == 0 && !allowZero) {
+		klog.InfoS("Ignoring invalid timeout", "service", klog.KObj(service), "annotation", annotation, "value", value)
+		return ""
+	}
+	return strconv.FormatFloat(timeout.Seconds(), 'f', -1, 64) + "s"
+}
+
+// parseLimit returns the circuit breaker limit of the annotation, or 0 if it is not set
+// or it is not a positive integer.
+func parseLimit(service *v1.Service, annotation string) int {
+	value, ok := service.Annotations[annotation]
+	if !ok {
+		return 0
+	}
+	limit, err := strconv.Atoi(strings.TrimSpace(value))
+	if err != nil || limit <= 0 {
+		klog.InfoS("Ignoring invalid limit", "service", klog.KObj(service), "annotation", annotation, "value", value)
+		return 0
+	}
+	return limit
+}
+
+// parseHealthyPanicThreshold returns the percentage of healthy backends under which the
+// traffic is sent to all the backends, or nil if the annotation is not set or invalid
+func parseHealthyPanicThreshold(service *v1.Service) *float64 {
+	value, ok := service.Annotations[constants.HealthyPanicThresholdAnnotation]
+	if !ok {
+		return nil
+	}
+	threshold, err := strconv.ParseFloat(strings.TrimSuffix(strings.TrimSpace(value), "%"), 64)
+	if err != nil || threshold < 0 || threshold > 100 {
+		klog.InfoS("Ignoring invalid healthy panic threshold", "service", klog.KObj(service), "annotation", constants.HealthyPanicThresholdAnnotation, "value", value)
+		return nil
+	}
+	return &threshold
+}
+
+// healthCheckProtocol returns the protocol of the health checks of the backends of the
+// port, grpc for the TCP ports with the grpc appProtocol, or the protocol of the health
+// check annotation. The UDP ports keep the default check with grpc, and are not checked
+// with tcp because it is used when the nodes do not serve the health port.
+func healthCheckProtocol(service *v1.Service, port v1.ServicePort) string {
+	if port.Protocol == v1.ProtocolTCP && port.AppProtocol != nil && strings.EqualFold(*port.AppProtocol, healthCheckGRPC) {
+		return healthCheckGRPC
+	}
+	value, ok := service.Annotations[constants.HealthCheckProtocolAnnotation]
+	if !ok {
+		return ""
+	}
+	switch value = strings.ToLower(strings.TrimSpace(value)); value {
+	case healthCheckGRPC:
+		if port.Protocol != v1.ProtocolTCP {
+			return ""
+		}
+		return healthCheckGRPC
+	case healthCheckTCP:
+		if port.Protocol != v1.ProtocolTCP {
+			return healthCheckNone
+		}
+		return healthCheckTCP
+	case healthCheckNone:
+		return healthCheckNone
+	case healthCheckHTTP:
+		return ""
+	default:
+		klog.InfoS("Ignoring invalid health check protocol", "service", klog.KObj(service), "annotation", constants.HealthCheckProtocolAnnotation, "value", value)
+		return ""
+	}
+}
+
+// hostNetworkBackends returns true if the Service is annotated to send the traffic directly
+// to the hostNetwork Pods on the nodes, avoiding the NodePort hop and its conntrack entries.
+func hostNetworkBackends(service *v1.Service) bool {
+	value, ok := service.Annotations[constants.HostNetworkBackendsAnnotation]
+	if !ok {
+		return false
+	}
+	enabled, err := strconv.ParseBool(strings.TrimSpace(value))
+	if err != nil {
+		klog.InfoS("Ignoring invalid host network backends annotation", "service", klog.KObj(service), "annotation", constants.HostNetworkBackendsAnnotation, "value", value)
+		return false
+	}
+	return enabled
+}
+
+// hostNetworkPort returns the port the hostNetwork Pods listen on on the nodes, the numeric
+// targetPort or the Service port if it is not set. The named ports can not be resolved
+// without the Pods, they keep using the NodePort.
+func hostNetworkPort(service *v1.Service, port v1.ServicePort) (int, bool) {
+	switch {
+	case port.TargetPort.Type == intstr.String && port.TargetPort.StrVal != "":
+		klog.InfoS("Named targetPort not supported with host network backends, using the NodePort", "service", klog.KObj(service), "port", port.Port, "targetPort", port.TargetPort.StrVal)
+		return 0, false
+	case port.TargetPort.IntVal != 0:
+		return int(port.TargetPort.IntVal), true
+	default:
+		return int(port.Port), true
+	}
+}
+
+// NodeReady returns true if the node has the Ready condition, the nodes without
+// the condition have not reported their status yet.
+func NodeReady(node *v1.Node) bool {
+	for _, condition := range node.Status.Conditions {
+		if condition.Type == v1.NodeReady {
+			return condition.Status == v1.ConditionTrue
+		}
+	}
+	return false
+}
+
+// parseListenAddresses returns the addresses of the listen addresses annotation by port,
+// the addresses that apply to all the ports have an empty key.
+func parseListenAddresses(service *v1.Service) map[string][]netip.Addr {
+	value, ok := service.Annotations[constants.ListenAddressesAnnotation]
+	if !ok {
 		return nil
 	}
-	config := generateConfig(service, nodes)
+	addresses := map[string][]netip.Addr{}
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		port, address, found := strings.Cut(entry, "=")
+		if !found {
+			port, address = "", entry
+		}
+		ip, err := netip.ParseAddr(strings.TrimSpace(address))
+		if err != nil {
+			klog.InfoS("Ignoring invalid listen address", "service", klog.KObj(service), "annotation", constants.ListenAddressesAnnotation, "entry", entry)
+			continue
+		}
+		port = strings.TrimSpace(port)
+		addresses[port] = append(addresses[port], ip.Unmap())
+	}
+	return addresses
+}
+
+// listenAddress returns the address the listener of the port binds to for the IP family,
+// the port is matched by number or name. It returns false if the port must not listen
+// on the family because the annotation only has addresses of the other family.
+func listenAddress(addresses map[string][]netip.Addr, port v1.ServicePort, ipFamily v1.IPFamily) (address, bool) {
+	ips, ok := addresses[strconv.Itoa(int(port.Port))]
+	if !ok && port.Name != "" {
+		ips, ok = addresses[port.Name]
+	}
+	if !ok {
+		ips, ok = addresses[""]
+	}
+	if !ok {
+		if ipFamily == v1.IPv6Protocol {
+			return ipAddress(netip.IPv6Unspecified()), true
+		}
+		return ipAddress(netip.IPv4Unspecified()), true
+	}
+	for _, ip := range ips {
+		if ipFamily == v1.IPv4Protocol && ip.Is4() {
+			return ipAddress(ip), true
+		}
+		if ipFamily == v1.IPv6Protocol && ip.Is6() {
+			return ipAddress(ip), true
+		}
+	}
+	return address{}, false
+}
+
+// proxyUpdateLoadBalancer renders the config and applies it to the loadbalancer container.
+// It returns false if the config was already applied and the container was not modified,
+// and if the proxy was restarted: changes that only affect the clusters are reloaded in
+// place, keeping the container and its addresses.
+func proxyUpdateLoadBalancer(ctx context.Context, name string, data *proxyConfigData, hashes *configHashes) (updated bool, restarted bool, err error) {
+	if data == nil {
+		return false, false, nil
+	}
 	// create loadbalancer config data
-	loadbalancerConfig, err := proxyConfig(config)
+	loadbalancerConfig, err := proxyConfig(data)
+	if err != nil {
+		return false, false, errors.Wrap(err, "failed to generate loadbalancer config data")
+	}
+
+	logger := klog.FromContext(ctx)
+	hash := configHash(loadbalancerConfig)
+	if hashes.get(name) == hash {
+		logger.V(2).Info("Loadbalancer config did not change, skipping update")
+		// the config applied before a controller restart
+		publishXDS(name, loadbalancerConfig)
+		return false, false, nil
+	}
+
+	logger.V(4).Info("Loadbalancer config", "bootstrap", loadbalancerConfig.Bootstrap, "clusters", loadbalancerConfig.Clusters)
+	if config.DefaultConfig.ValidateProxyConfig {
+		err = validateProxyConfig(name, loadbalancerConfig)
+		if err != nil {
+			return false, false, err
+		}
+	}
+	current, err := readProxyConfig(name)
+	if err == nil && current.Bootstrap == loadbalancerConfig.Bootstrap {
+		logger.V(2).Info("Updating loadbalancer clusters", "hash", hash)
+		err = applyClustersConfig(name, loadbalancerConfig)
+	} else {
+		logger.V(2).Info("Updating loadbalancer config", "hash", hash)
+		err = applyProxyConfig(ctx, name, loadbalancerConfig)
+		restarted = true
+	}
+	if err != nil {
+		hashes.forget(name)
+		return false, restarted, err
+	}
+	hashes.set(name, hash)
+	publishXDS(name, loadbalancerConfig)
+	return true, restarted, nil
+}
+
+// configHash returns the hash of the config
+func configHash(config proxyFiles) string {
+	hash := sha256.New()
+	hash.Write([]byte(config.Bootstrap))
+	// avoid collisions moving content between the files
+	hash.Write([]byte{0})
+	hash.Write([]byte(config.Clusters))
+	// the hash of the loadbalancers without faults does not change
+	if config.Faults != "" {
+		hash.Write([]byte{0})
+		hash.Write([]byte(config.Faults))
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// configHashes caches the hash of the config applied to each loadbalancer container so
+// updates that do not change the rendered config do not touch the container at all.
+// The hash is also stored in the container, so the cache can be rebuilt after a restart.
+type configHashes struct {
+	mu     sync.Mutex
+	hashes map[string]string // key is the loadbalancer container name
+}
+
+func newConfigHashes() *configHashes {
+	return &configHashes{hashes: map[string]string{}}
+}
+
+// get returns the hash of the config applied to the container, or empty if unknown. The
+// lock is not held while reading the hash stored in the container, so the other
+// loadbalancers are not blocked by it.
+func (c *configHashes) get(name string) string {
+	c.mu.Lock()
+	hash, ok := c.hashes[name]
+	c.mu.Unlock()
+	if ok {
+		return hash
+	}
+	var stdout bytes.Buffer
+	err := container.Exec(name, []string{"cat", proxyConfigHashPath}, nil, &stdout, nil)
 	if err != nil {
-		return errors.Wrap(err, "failed to generate loadbalancer config data")
+		return ""
+	}
+	hash = strings.TrimSpace(stdout.String())
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	// the hash set meanwhile is the one of the config applied after
+	if current, ok := c.hashes[name]; ok {
+		return current
 	}
+	c.hashes[name] = hash
+	return hash
+}
 
-	klog.V(2).Infof("updating loadbalancer with config %s", loadbalancerConfig)
-	var stdout, stderr bytes.Buffer
-	name := loadBalancerName(clusterName, service)
-	err = container.Exec(name, []string{"cp", "/dev/stdin", proxyConfigPath}, strings.NewReader(loadbalancerConfig), &stdout, &stderr)
+func (c *configHashes) set(name, hash string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.hashes[name] = hash
+}
+
+// invalidate removes the hash cached and stored in the container, so the config is
+// applied again on the next update
+func (c *configHashes) invalidate(name string) {
+	c.forget(name)
+	_ = container.Exec(name, []string{"rm", "-f", proxyConfigHashPath}, nil, nil, nil)
+}
+
+// forget removes the cached hash, it must be called every time the container is
+// recreated or its config modified out of band.
+func (c *configHashes) forget(name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.hashes, name)
+}
+
+// applyClustersConfig replaces the clusters config of the running loadbalancer, the
+// proxy only reloads the file when it is moved into place so it is written in two steps.
+func applyClustersConfig(name string, config proxyFiles) error {
+	err := container.Exec(name, []string{"cp", "/dev/stdin", proxyClustersPath + ".tmp"}, strings.NewReader(config.Clusters), nil, nil)
+	if err != nil {
+		return err
+	}
+	err = container.Exec(name, []string{"mv", proxyClustersPath + ".tmp", proxyClustersPath}, nil, nil, nil)
+	if err != nil {
+		return err
+	}
+	return writeConfigHash(name, config)
+}
+
+func writeConfigHash(name string, config proxyFiles) error {
+	return container.Exec(name, []string{"cp", "/dev/stdin", proxyConfigHashPath}, strings.NewReader(configHash(config)), nil, nil)
+}
+
+// applyProxyConfig copies the config into the loadbalancer container and restarts it,
+// waiting until it is running and stable.
+func applyProxyConfig(ctx context.Context, name string, config proxyFiles) error {
+	err := container.Exec(name, []string{"cp", "/dev/stdin", proxyClustersPath}, strings.NewReader(config.Clusters), nil, nil)
+	if err != nil {
+		return err
+	}
+	err = container.Exec(name, []string{"cp", "/dev/stdin", proxyConfigPath}, strings.NewReader(config.Bootstrap), nil, nil)
+	if err != nil {
+		return err
+	}
+	err = writeConfigHash(name, config)
 	if err != nil {
 		return err
 	}
 
-	klog.V(2).Infof("restarting loadbalancer")
+	logger := klog.FromContext(ctx)
+	logger.V(2).Info("Restarting loadbalancer")
 	err = container.Restart(name)
 	if err != nil {
 		return err
 	}
-	klog.V(2).Infof("loadbalancer restarted")
+	logger.V(2).Info("Loadbalancer restarted")
 	// Wait until is running and stable, it can happen that the configuration is wrong
 	// and the container dies or restarts, giving the impression the loadbalancer is working
 	// when is not even running.
@@ -244,7 +713,7 @@ func proxyUpdateLoadBalancer(ctx context.Context, clusterName string, service *v
 			checks++
 			return false, nil
 		}
-		klog.V(2).Infof("loadbalancer ready and running")
+		logger.V(2).Info("Loadbalancer ready and running")
 		return true, nil
 	})
 
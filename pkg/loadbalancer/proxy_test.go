@@ -1,13 +1,20 @@
 package loadbalancer
 
 import (
+	"io"
 	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/google/go-cmp/cmp"
 	v1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/utils/ptr"
+
+	"sigs.k8s.io/cloud-provider-kind/pkg/config"
+	"sigs.k8s.io/cloud-provider-kind/pkg/constants"
+	"sigs.k8s.io/cloud-provider-kind/pkg/container"
 )
 
 func makeNode(name string, ip string) *v1.Node {
@@ -20,6 +27,9 @@ func makeNode(name string, ip string) *v1.Node {
 			Addresses: []v1.NodeAddress{
 				{Type: v1.NodeInternalIP, Address: ip},
 			},
+			Conditions: []v1.NodeCondition{
+				{Type: v1.NodeReady, Status: v1.ConditionTrue},
+			},
 		},
 	}
 }
@@ -38,6 +48,81 @@ func makeService(name string) *v1.Service {
 	}
 }
 
+func makeLabeledNode(node *v1.Node, labels ...string) *v1.Node {
+	node.Labels = map[string]string{}
+	for _, label := range labels {
+		node.Labels[label] = ""
+	}
+	return node
+}
+
+func Test_backendNodes(t *testing.T) {
+	controlPlane := makeLabeledNode(makeNode("control-plane", "10.0.0.1"), labelNodeRoleControlPlane)
+	excludedControlPlane := makeLabeledNode(makeNode("excluded-control-plane", "10.0.0.2"), labelNodeRoleControlPlane, v1.LabelNodeExcludeBalancers)
+	excluded := makeLabeledNode(makeNode("excluded", "10.0.0.3"), v1.LabelNodeExcludeBalancers)
+	worker := makeNode("worker", "10.0.0.4")
+	notReady := makeNode("not-ready", "10.0.0.5")
+	notReady.Status.Conditions[0].Status = v1.ConditionFalse
+	unschedulable := makeNode("unschedulable", "10.0.0.6")
+	unschedulable.Spec.Unschedulable = true
+	unknown := makeNode("unknown", "10.0.0.7")
+	unknown.Status.Conditions = nil
+	toBeDeleted := makeNode("to-be-deleted", "10.0.0.8")
+	toBeDeleted.Spec.Taints = []v1.Taint{{Key: toBeDeletedTaint, Effect: v1.TaintEffectNoSchedule}}
+	tests := []struct {
+		name                string
+		nodes               []*v1.Node
+		includeUnreadyNodes bool
+		want                []*v1.Node
+	}{
+		{
+			name:  "single node cluster",
+			nodes: []*v1.Node{controlPlane},
+			want:  []*v1.Node{controlPlane},
+		},
+		{
+			name:  "control plane with workers",
+			nodes: []*v1.Node{controlPlane, worker},
+			want:  []*v1.Node{worker},
+		},
+		{
+			name:  "excluded nodes",
+			nodes: []*v1.Node{excludedControlPlane, excluded, worker},
+			want:  []*v1.Node{worker},
+		},
+		{
+			name:  "nodes to be deleted by the cluster-autoscaler",
+			nodes: []*v1.Node{toBeDeleted, worker},
+			want:  []*v1.Node{worker},
+		},
+		{
+			name:  "all nodes excluded",
+			nodes: []*v1.Node{excludedControlPlane, excluded},
+			want:  []*v1.Node{},
+		},
+		{
+			name:  "not ready and unschedulable nodes",
+			nodes: []*v1.Node{notReady, unschedulable, unknown, worker},
+			want:  []*v1.Node{worker},
+		},
+		{
+			name:                "include unready nodes",
+			nodes:               []*v1.Node{notReady, unschedulable, unknown, worker},
+			includeUnreadyNodes: true,
+			want:                []*v1.Node{notReady, unschedulable, unknown, worker},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer func(include bool) { config.DefaultConfig.IncludeUnreadyNodes = include }(config.DefaultConfig.IncludeUnreadyNodes)
+			config.DefaultConfig.IncludeUnreadyNodes = tt.includeUnreadyNodes
+			if got := backendNodes(tt.nodes); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("backendNodes() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
 func Test_generateConfig(t *testing.T) {
 	tests := []struct {
 		name    string
@@ -77,8 +162,8 @@ func Test_generateConfig(t *testing.T) {
 				HealthCheckPort: 32000,
 				ServicePorts: map[string]servicePort{
 					"IPv4_80_TCP": servicePort{
-						Listener: endpoint{Address: "0.0.0.0", Port: 80, Protocol: string(v1.ProtocolTCP)},
-						Cluster:  []endpoint{{"10.0.0.1", 30000, string(v1.ProtocolTCP)}, {"10.0.0.2", 30000, string(v1.ProtocolTCP)}},
+						Listener: endpoint{Address: mustParseAddress("0.0.0.0"), Port: 80, Protocol: string(v1.ProtocolTCP)},
+						Cluster:  []endpoint{{mustParseAddress("10.0.0.1"), 30000, string(v1.ProtocolTCP)}, {mustParseAddress("10.0.0.2"), 30000, string(v1.ProtocolTCP)}},
 					},
 				},
 			},
@@ -118,12 +203,12 @@ func Test_generateConfig(t *testing.T) {
 				HealthCheckPort: 32000,
 				ServicePorts: map[string]servicePort{
 					"IPv4_80_TCP": servicePort{
-						Listener: endpoint{Address: "0.0.0.0", Port: 80, Protocol: string(v1.ProtocolTCP)},
-						Cluster:  []endpoint{{"10.0.0.1", 30000, string(v1.ProtocolTCP)}, {"10.0.0.2", 30000, string(v1.ProtocolTCP)}},
+						Listener: endpoint{Address: mustParseAddress("0.0.0.0"), Port: 80, Protocol: string(v1.ProtocolTCP)},
+						Cluster:  []endpoint{{mustParseAddress("10.0.0.1"), 30000, string(v1.ProtocolTCP)}, {mustParseAddress("10.0.0.2"), 30000, string(v1.ProtocolTCP)}},
 					},
 					"IPv4_443_TCP": servicePort{
-						Listener: endpoint{Address: "0.0.0.0", Port: 443, Protocol: string(v1.ProtocolTCP)},
-						Cluster:  []endpoint{{"10.0.0.1", 31000, string(v1.ProtocolTCP)}, {"10.0.0.2", 31000, string(v1.ProtocolTCP)}},
+						Listener: endpoint{Address: mustParseAddress("0.0.0.0"), Port: 443, Protocol: string(v1.ProtocolTCP)},
+						Cluster:  []endpoint{{mustParseAddress("10.0.0.1"), 31000, string(v1.ProtocolTCP)}, {mustParseAddress("10.0.0.2"), 31000, string(v1.ProtocolTCP)}},
 					},
 				},
 			},
@@ -163,12 +248,12 @@ func Test_generateConfig(t *testing.T) {
 				HealthCheckPort: 32000,
 				ServicePorts: map[string]servicePort{
 					"IPv4_80_TCP": servicePort{
-						Listener: endpoint{Address: "0.0.0.0", Port: 80, Protocol: string(v1.ProtocolTCP)},
-						Cluster:  []endpoint{{"10.0.0.1", 30000, string(v1.ProtocolTCP)}, {"10.0.0.2", 30000, string(v1.ProtocolTCP)}},
+						Listener: endpoint{Address: mustParseAddress("0.0.0.0"), Port: 80, Protocol: string(v1.ProtocolTCP)},
+						Cluster:  []endpoint{{mustParseAddress("10.0.0.1"), 30000, string(v1.ProtocolTCP)}, {mustParseAddress("10.0.0.2"), 30000, string(v1.ProtocolTCP)}},
 					},
 					"IPv4_80_UDP": servicePort{
-						Listener: endpoint{Address: "0.0.0.0", Port: 80, Protocol: string(v1.ProtocolUDP)},
-						Cluster:  []endpoint{{"10.0.0.1", 31000, string(v1.ProtocolUDP)}, {"10.0.0.2", 31000, string(v1.ProtocolUDP)}},
+						Listener: endpoint{Address: mustParseAddress("0.0.0.0"), Port: 80, Protocol: string(v1.ProtocolUDP)},
+						Cluster:  []endpoint{{mustParseAddress("10.0.0.1"), 31000, string(v1.ProtocolUDP)}, {mustParseAddress("10.0.0.2"), 31000, string(v1.ProtocolUDP)}},
 					},
 				},
 			},
@@ -208,12 +293,101 @@ func Test_generateConfig(t *testing.T) {
 				HealthCheckPort: 32000,
 				ServicePorts: map[string]servicePort{
 					"IPv6_80_TCP": servicePort{
-						Listener: endpoint{Address: `"::"`, Port: 80, Protocol: string(v1.ProtocolTCP)},
-						Cluster:  []endpoint{{"2001:db2::3", 30000, string(v1.ProtocolTCP)}, {"2001:db2::4", 30000, string(v1.ProtocolTCP)}},
+						Listener: endpoint{Address: mustParseAddress("::"), Port: 80, Protocol: string(v1.ProtocolTCP)},
+						Cluster:  []endpoint{{mustParseAddress("2001:db2::3"), 30000, string(v1.ProtocolTCP)}, {mustParseAddress("2001:db2::4"), 30000, string(v1.ProtocolTCP)}},
 					},
 					"IPv6_443_TCP": servicePort{
-						Listener: endpoint{Address: `"::"`, Port: 443, Protocol: string(v1.ProtocolTCP)},
-						Cluster:  []endpoint{{"2001:db2::3", 31000, string(v1.ProtocolTCP)}, {"2001:db2::4", 31000, string(v1.ProtocolTCP)}},
+						Listener: endpoint{Address: mustParseAddress("::"), Port: 443, Protocol: string(v1.ProtocolTCP)},
+						Cluster:  []endpoint{{mustParseAddress("2001:db2::3"), 31000, string(v1.ProtocolTCP)}, {mustParseAddress("2001:db2::4"), 31000, string(v1.ProtocolTCP)}},
+					},
+				},
+			},
+		},
+		{
+			name: "dual stack service with listen addresses",
+			service: &v1.Service{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:        "test",
+					Annotations: map[string]string{constants.ListenAddressesAnnotation: "127.0.0.1, http=::1, 443=0.0.0.0, bad"},
+				},
+				Spec: v1.ServiceSpec{
+					Type:                  v1.ServiceTypeLoadBalancer,
+					ExternalTrafficPolicy: v1.ServiceExternalTrafficPolicyCluster,
+					IPFamilies:            []v1.IPFamily{v1.IPv4Protocol, v1.IPv6Protocol},
+					Ports: []v1.ServicePort{
+						{
+							Name:     "http",
+							Port:     80,
+							NodePort: 30000,
+							Protocol: v1.ProtocolTCP,
+						},
+						{
+							Name:     "https",
+							Port:     443,
+							NodePort: 31000,
+							Protocol: v1.ProtocolTCP,
+						},
+						{
+							Name:     "dns",
+							Port:     53,
+							NodePort: 32000,
+							Protocol: v1.ProtocolUDP,
+						},
+					},
+				},
+			},
+			nodes: []*v1.Node{
+				makeNode("a", "10.0.0.1"),
+				makeNode("b", "2001:db2::3"),
+			},
+			want: &proxyConfigData{
+				HealthCheckPort: 10256,
+				ServicePorts: map[string]servicePort{
+					"IPv6_80_TCP": servicePort{
+						Listener: endpoint{Address: mustParseAddress("::1"), Port: 80, Protocol: string(v1.ProtocolTCP)},
+						Cluster:  []endpoint{{mustParseAddress("2001:db2::3"), 30000, string(v1.ProtocolTCP)}},
+					},
+					"IPv4_443_TCP": servicePort{
+						Listener: endpoint{Address: mustParseAddress("0.0.0.0"), Port: 443, Protocol: string(v1.ProtocolTCP)},
+						Cluster:  []endpoint{{mustParseAddress("10.0.0.1"), 31000, string(v1.ProtocolTCP)}},
+					},
+					"IPv4_53_UDP": servicePort{
+						Listener: endpoint{Address: mustParseAddress("127.0.0.1"), Port: 53, Protocol: string(v1.ProtocolUDP)},
+						Cluster:  []endpoint{{mustParseAddress("10.0.0.1"), 32000, string(v1.ProtocolUDP)}},
+					},
+				},
+			},
+		},
+		{
+			name: "excluded and control plane nodes",
+			service: &v1.Service{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "test",
+				},
+				Spec: v1.ServiceSpec{
+					Type:                  v1.ServiceTypeLoadBalancer,
+					ExternalTrafficPolicy: v1.ServiceExternalTrafficPolicyCluster,
+					IPFamilies:            []v1.IPFamily{v1.IPv4Protocol},
+					Ports: []v1.ServicePort{
+						{
+							Port:     80,
+							NodePort: 30000,
+							Protocol: v1.ProtocolTCP,
+						},
+					},
+				},
+			},
+			nodes: []*v1.Node{
+				makeLabeledNode(makeNode("control-plane", "10.0.0.1"), labelNodeRoleControlPlane),
+				makeLabeledNode(makeNode("excluded", "10.0.0.2"), v1.LabelNodeExcludeBalancers),
+				makeNode("worker", "10.0.0.3"),
+			},
+			want: &proxyConfigData{
+				HealthCheckPort: 10256,
+				ServicePorts: map[string]servicePort{
+					"IPv4_80_TCP": servicePort{
+						Listener: endpoint{Address: mustParseAddress("0.0.0.0"), Port: 80, Protocol: string(v1.ProtocolTCP)},
+						Cluster:  []endpoint{{mustParseAddress("10.0.0.3"), 30000, string(v1.ProtocolTCP)}},
 					},
 				},
 			},
@@ -231,9 +405,10 @@ func Test_generateConfig(t *testing.T) {
 
 func Test_proxyConfig(t *testing.T) {
 	tests := []struct {
-		name       string
-		data       *proxyConfigData
-		wantConfig string
+		name         string
+		data         *proxyConfigData
+		wantConfig   string
+		wantClusters string
 	}{
 		{
 			name: "ipv4",
@@ -241,118 +416,192 @@ func Test_proxyConfig(t *testing.T) {
 				HealthCheckPort: 32764,
 				ServicePorts: map[string]servicePort{
 					"IPv4_80": servicePort{
-						Listener: endpoint{Address: "0.0.0.0", Port: 80, Protocol: string(v1.ProtocolTCP)},
-						Cluster:  []endpoint{{"192.168.8.2", 30497, string(v1.ProtocolTCP)}, {"192.168.8.3", 30497, string(v1.ProtocolTCP)}},
+						Listener: endpoint{Address: mustParseAddress("0.0.0.0"), Port: 80, Protocol: string(v1.ProtocolTCP)},
+						Cluster:  []endpoint{{mustParseAddress("192.168.8.2"), 30497, string(v1.ProtocolTCP)}, {mustParseAddress("192.168.8.3"), 30497, string(v1.ProtocolTCP)}},
 					},
 					"IPv4_443": servicePort{
-						Listener: endpoint{Address: "0.0.0.0", Port: 443, Protocol: string(v1.ProtocolTCP)},
-						Cluster:  []endpoint{{"192.168.8.2", 31497, string(v1.ProtocolTCP)}, {"192.168.8.3", 31497, string(v1.ProtocolTCP)}},
+						Listener: endpoint{Address: mustParseAddress("0.0.0.0"), Port: 443, Protocol: string(v1.ProtocolTCP)},
+						Cluster:  []endpoint{{mustParseAddress("192.168.8.2"), 31497, string(v1.ProtocolTCP)}, {mustParseAddress("192.168.8.3"), 31497, string(v1.ProtocolTCP)}},
 					},
 				},
 			},
-			wantConfig: `
-admin:
+			wantConfig: `admin:
   address:
-    socket_address: { address: 127.0.0.1, port_value: 9901 }
-
+    socket_address:
+      address: 127.0.0.1
+      port_value: 9901
+dynamic_resources:
+  cds_config:
+    path_config_source:
+      path: /etc/envoy/cds.yaml
+    resource_api_version: V3
+node:
+  cluster: cloud-provider-kind
+  id: cloud-provider-kind
 static_resources:
   listeners:
-  - name: listener_IPv4_443
-    address:
+  - address:
       socket_address:
         address: 0.0.0.0
         port_value: 443
-        protocol: TCP
+    enable_reuse_port: true
     filter_chains:
-      - filters:
-        - name: envoy.filters.network.tcp_proxy
-          typed_config:
-            "@type": type.googleapis.com/envoy.extensions.filters.network.tcp_proxy.v3.TcpProxy
-            stat_prefix: destination
-            cluster: cluster_IPv4_443
-  - name: listener_IPv4_80
-    address:
+    - filters:
+      - name: envoy.filters.network.tcp_proxy
+        typed_config:
+          '@type': type.googleapis.com/envoy.extensions.filters.network.tcp_proxy.v3.TcpProxy
+          cluster: cluster_IPv4_443
+          stat_prefix: destination
+    name: listener_IPv4_443
+  - address:
       socket_address:
         address: 0.0.0.0
         port_value: 80
-        protocol: TCP
+    enable_reuse_port: true
+    filter_chains:
+    - filters:
+      - name: envoy.filters.network.tcp_proxy
+        typed_config:
+          '@type': type.googleapis.com/envoy.extensions.filters.network.tcp_proxy.v3.TcpProxy
+          cluster: cluster_IPv4_80
+          stat_prefix: destination
+    name: listener_IPv4_80
+`,
+			wantClusters: `resources:
+- '@type': type.googleapis.com/envoy.config.cluster.v3.Cluster
+  connect_timeout: 5s
+  health_checks:
+  - always_log_health_check_failures: true
+    always_log_health_check_success: true
+    healthy_threshold: 1
+    http_health_check:
+      path: /healthz
+    interval: 3s
+    timeout: 5s
+    unhealthy_threshold: 3
+  lb_policy: RANDOM
+  load_assignment:
+    cluster_name: cluster_IPv4_443
+    endpoints:
+    - lb_endpoints:
+      - endpoint:
+          address:
+            socket_address:
+              address: 192.168.8.2
+              port_value: 31497
+          health_check_config:
+            port_value: 32764
+    - lb_endpoints:
+      - endpoint:
+          address:
+            socket_address:
+              address: 192.168.8.3
+              port_value: 31497
+          health_check_config:
+            port_value: 32764
+  name: cluster_IPv4_443
+  type: STATIC
+- '@type': type.googleapis.com/envoy.config.cluster.v3.Cluster
+  connect_timeout: 5s
+  health_checks:
+  - always_log_health_check_failures: true
+    always_log_health_check_success: true
+    healthy_threshold: 1
+    http_health_check:
+      path: /healthz
+    interval: 3s
+    timeout: 5s
+    unhealthy_threshold: 3
+  lb_policy: RANDOM
+  load_assignment:
+    cluster_name: cluster_IPv4_80
+    endpoints:
+    - lb_endpoints:
+      - endpoint:
+          address:
+            socket_address:
+              address: 192.168.8.2
+              port_value: 30497
+          health_check_config:
+            port_value: 32764
+    - lb_endpoints:
+      - endpoint:
+          address:
+            socket_address:
+              address: 192.168.8.3
+              port_value: 30497
+          health_check_config:
+            port_value: 32764
+  name: cluster_IPv4_80
+  type: STATIC
+`,
+		},
+		{
+			name: "ipv6",
+			data: &proxyConfigData{
+				HealthCheckPort: 32764,
+				ServicePorts: map[string]servicePort{
+					"IPv6_80": servicePort{
+						Listener: endpoint{Address: mustParseAddress("::"), Port: 80, Protocol: string(v1.ProtocolTCP)},
+						Cluster:  []endpoint{{mustParseAddress("fd00:10:244::2"), 30497, string(v1.ProtocolTCP)}},
+					},
+				},
+			},
+			wantConfig: `admin:
+  address:
+    socket_address:
+      address: 127.0.0.1
+      port_value: 9901
+dynamic_resources:
+  cds_config:
+    path_config_source:
+      path: /etc/envoy/cds.yaml
+    resource_api_version: V3
+node:
+  cluster: cloud-provider-kind
+  id: cloud-provider-kind
+static_resources:
+  listeners:
+  - address:
+      socket_address:
+        address: '::'
+        port_value: 80
+    enable_reuse_port: true
     filter_chains:
-      - filters:
-        - name: envoy.filters.network.tcp_proxy
-          typed_config:
-            "@type": type.googleapis.com/envoy.extensions.filters.network.tcp_proxy.v3.TcpProxy
-            stat_prefix: destination
-            cluster: cluster_IPv4_80
-
-  clusters:
-  - name: cluster_IPv4_443
-    connect_timeout: 5s
-    type: STATIC
-    lb_policy: RANDOM
-    health_checks:
-      - timeout: 5s
-        interval: 3s
-        unhealthy_threshold: 3
-        healthy_threshold: 1
-        always_log_health_check_failures: true
-        always_log_health_check_success: true
-        http_health_check:
-          path: /healthz
-    load_assignment:
-      cluster_name: cluster_IPv4_443
-      endpoints:
-        - lb_endpoints:
-          - endpoint:
-              health_check_config:
-                port_value: 32764
-              address:
-                socket_address:
-                  address: 192.168.8.2
-                  port_value: 31497
-                  protocol: TCP
-        - lb_endpoints:
-          - endpoint:
-              health_check_config:
-                port_value: 32764
-              address:
-                socket_address:
-                  address: 192.168.8.3
-                  port_value: 31497
-                  protocol: TCP
-  - name: cluster_IPv4_80
-    connect_timeout: 5s
-    type: STATIC
-    lb_policy: RANDOM
-    health_checks:
-      - timeout: 5s
-        interval: 3s
-        unhealthy_threshold: 3
-        healthy_threshold: 1
-        always_log_health_check_failures: true
-        always_log_health_check_success: true
-        http_health_check:
-          path: /healthz
-    load_assignment:
-      cluster_name: cluster_IPv4_80
-      endpoints:
-        - lb_endpoints:
-          - endpoint:
-              health_check_config:
-                port_value: 32764
-              address:
-                socket_address:
-                  address: 192.168.8.2
-                  port_value: 30497
-                  protocol: TCP
-        - lb_endpoints:
-          - endpoint:
-              health_check_config:
-                port_value: 32764
-              address:
-                socket_address:
-                  address: 192.168.8.3
-                  port_value: 30497
-                  protocol: TCP
+    - filters:
+      - name: envoy.filters.network.tcp_proxy
+        typed_config:
+          '@type': type.googleapis.com/envoy.extensions.filters.network.tcp_proxy.v3.TcpProxy
+          cluster: cluster_IPv6_80
+          stat_prefix: destination
+    name: listener_IPv6_80
+`,
+			wantClusters: `resources:
+- '@type': type.googleapis.com/envoy.config.cluster.v3.Cluster
+  connect_timeout: 5s
+  health_checks:
+  - always_log_health_check_failures: true
+    always_log_health_check_success: true
+    healthy_threshold: 1
+    http_health_check:
+      path: /healthz
+    interval: 3s
+    timeout: 5s
+    unhealthy_threshold: 3
+  lb_policy: RANDOM
+  load_assignment:
+    cluster_name: cluster_IPv6_80
+    endpoints:
+    - lb_endpoints:
+      - endpoint:
+          address:
+            socket_address:
+              address: fd00:10:244::2
+              port_value: 30497
+          health_check_config:
+            port_value: 32764
+  name: cluster_IPv6_80
+  type: STATIC
 `,
 		},
 	}
@@ -363,9 +612,493 @@ static_resources:
 				t.Errorf("proxyConfig() error = %v", err)
 				return
 			}
-			if gotConfig != tt.wantConfig {
-				t.Errorf("proxyConfig() not expected\n%v", cmp.Diff(gotConfig, tt.wantConfig))
+			if gotConfig.Bootstrap != tt.wantConfig {
+				t.Errorf("proxyConfig() not expected\n%v", cmp.Diff(gotConfig.Bootstrap, tt.wantConfig))
+			}
+			if gotConfig.Clusters != tt.wantClusters {
+				t.Errorf("proxyConfig() clusters not expected\n%v", cmp.Diff(gotConfig.Clusters, tt.wantClusters))
+			}
+		})
+	}
+}
+
+func Test_configHash(t *testing.T) {
+	service := makeService("test")
+	service.Spec.Type = v1.ServiceTypeLoadBalancer
+	service.Spec.IPFamilies = []v1.IPFamily{v1.IPv4Protocol}
+	service.Spec.Ports[0].NodePort = 30000
+	service.Spec.Ports[0].Protocol = v1.ProtocolTCP
+
+	render := func(nodes []*v1.Node) string {
+		config, err := proxyConfig(generateConfig(service, nodes))
+		if err != nil {
+			t.Fatalf("proxyConfig() error = %v", err)
+		}
+		return configHash(config)
+	}
+
+	nodeA := makeNode("a", "10.0.0.1")
+	nodeB := makeNode("b", "10.0.0.2")
+	base := render([]*v1.Node{nodeA, nodeB})
+
+	// heartbeats only modify the node conditions
+	heartbeat := nodeA.DeepCopy()
+	heartbeat.Status.Conditions = []v1.NodeCondition{
+		{Type: v1.NodeReady, Status: v1.ConditionTrue, LastHeartbeatTime: metav1.Now()},
+	}
+	if got := render([]*v1.Node{heartbeat, nodeB}); got != base {
+		t.Errorf("configHash() changed on node heartbeat: %s != %s", got, base)
+	}
+
+	if got := render([]*v1.Node{nodeA, makeNode("b", "10.0.0.3")}); got == base {
+		t.Errorf("configHash() did not change when the node address changed")
+	}
+}
+
+// blockingRuntime blocks the commands executed in the container until release is closed
+type blockingRuntime struct {
+	*container.Fake
+	name    string
+	release chan struct{}
+}
+
+func (r *blockingRuntime) Exec(name string, command []string, stdin io.Reader, stdout io.Writer, stderr io.Writer) error {
+	if name == r.name {
+		<-r.release
+	}
+	return r.Fake.Exec(name, command, stdin, stdout, stderr)
+}
+
+func Test_configHashesGet(t *testing.T) {
+	fake := container.NewFake()
+	runtime := &blockingRuntime{Fake: fake, name: "slow", release: make(chan struct{})}
+	defer container.SetRuntime(runtime)()
+	fake.AddContainer(&container.FakeContainer{Name: "slow", Running: true, Files: map[string]string{proxyConfigHashPath: "slow-hash\n"}})
+	fake.AddContainer(&container.FakeContainer{Name: "lb", Running: true, Files: map[string]string{proxyConfigHashPath: "lb-hash\n"}})
+	hashes := newConfigHashes()
+
+	done := make(chan string)
+	go func() { done <- hashes.get("slow") }()
+	// the other loadbalancers are not blocked by the container runtime
+	if got := hashes.get("lb"); got != "lb-hash" {
+		t.Errorf("get() = %q, want the hash stored in the container", got)
+	}
+	// the hash of the config applied meanwhile is not replaced by the stored one
+	hashes.set("slow", "applied-hash")
+	close(runtime.release)
+	if got := <-done; got != "applied-hash" {
+		t.Errorf("get() = %q, want the hash set while reading the stored one", got)
+	}
+	if got := hashes.get("slow"); got != "applied-hash" {
+		t.Errorf("get() = %q, want the cached hash", got)
+	}
+}
+
+func Test_proxyConfigExternalTrafficPolicy(t *testing.T) {
+	service := makeService("test")
+	service.Spec.Type = v1.ServiceTypeLoadBalancer
+	service.Spec.IPFamilies = []v1.IPFamily{v1.IPv4Protocol}
+	service.Spec.Ports[0].NodePort = 30000
+	service.Spec.Ports[0].Protocol = v1.ProtocolTCP
+	nodes := []*v1.Node{makeNode("a", "10.0.0.1"), makeNode("b", "10.0.0.2")}
+
+	cluster, err := proxyConfig(generateConfig(service, nodes))
+	if err != nil {
+		t.Fatalf("proxyConfig() error = %v", err)
+	}
+	local := service.DeepCopy()
+	local.Spec.ExternalTrafficPolicy = v1.ServiceExternalTrafficPolicyLocal
+	local.Spec.HealthCheckNodePort = 32000
+	got, err := proxyConfig(generateConfig(local, nodes))
+	if err != nil {
+		t.Fatalf("proxyConfig() error = %v", err)
+	}
+
+	// switching the policy must be applied without restarting the proxy
+	if got.Bootstrap != cluster.Bootstrap {
+		t.Errorf("bootstrap config changed with the ExternalTrafficPolicy\n%v", cmp.Diff(cluster.Bootstrap, got.Bootstrap))
+	}
+	if got.Clusters == cluster.Clusters {
+		t.Errorf("clusters config did not change with the ExternalTrafficPolicy")
+	}
+}
+
+func Test_healthCheckProtocol(t *testing.T) {
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		port        v1.ServicePort
+		want        string
+	}{
+		{
+			name: "default",
+			port: v1.ServicePort{Port: 80, Protocol: v1.ProtocolTCP},
+		},
+		{
+			name: "grpc appProtocol",
+			port: v1.ServicePort{Port: 9090, Protocol: v1.ProtocolTCP, AppProtocol: ptr.To("grpc")},
+			want: healthCheckGRPC,
+		},
+		{
+			name:        "grpc annotation",
+			annotations: map[string]string{constants.HealthCheckProtocolAnnotation: "gRPC"},
+			port:        v1.ServicePort{Port: 9090, Protocol: v1.ProtocolTCP},
+			want:        healthCheckGRPC,
+		},
+		{
+			name:        "grpc annotation on UDP port",
+			annotations: map[string]string{constants.HealthCheckProtocolAnnotation: "grpc"},
+			port:        v1.ServicePort{Port: 53, Protocol: v1.ProtocolUDP},
+		},
+		{
+			name:        "tcp annotation",
+			annotations: map[string]string{constants.HealthCheckProtocolAnnotation: "TCP"},
+			port:        v1.ServicePort{Port: 80, Protocol: v1.ProtocolTCP},
+			want:        healthCheckTCP,
+		},
+		{
+			name:        "tcp annotation on UDP port",
+			annotations: map[string]string{constants.HealthCheckProtocolAnnotation: "tcp"},
+			port:        v1.ServicePort{Port: 53, Protocol: v1.ProtocolUDP},
+			want:        healthCheckNone,
+		},
+		{
+			name:        "none annotation",
+			annotations: map[string]string{constants.HealthCheckProtocolAnnotation: "none"},
+			port:        v1.ServicePort{Port: 53, Protocol: v1.ProtocolUDP},
+			want:        healthCheckNone,
+		},
+		{
+			name:        "none annotation with grpc appProtocol",
+			annotations: map[string]string{constants.HealthCheckProtocolAnnotation: "none"},
+			port:        v1.ServicePort{Port: 9090, Protocol: v1.ProtocolTCP, AppProtocol: ptr.To("grpc")},
+			want:        healthCheckGRPC,
+		},
+		{
+			name:        "invalid annotation",
+			annotations: map[string]string{constants.HealthCheckProtocolAnnotation: "udp"},
+			port:        v1.ServicePort{Port: 80, Protocol: v1.ProtocolTCP},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service := makeService("test")
+			service.Annotations = tt.annotations
+			if got := healthCheckProtocol(service, tt.port); got != tt.want {
+				t.Errorf("healthCheckProtocol() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_proxyConfigGRPCHealthCheck(t *testing.T) {
+	service := makeService("test")
+	service.Spec.Type = v1.ServiceTypeLoadBalancer
+	service.Spec.IPFamilies = []v1.IPFamily{v1.IPv4Protocol}
+	service.Spec.Ports[0].NodePort = 30000
+	service.Spec.Ports[0].Protocol = v1.ProtocolTCP
+	service.Spec.Ports[0].AppProtocol = ptr.To("grpc")
+	nodes := []*v1.Node{makeNode("a", "10.0.0.1")}
+
+	got, err := proxyConfig(generateConfig(service, nodes))
+	if err != nil {
+		t.Fatalf("proxyConfig() error = %v", err)
+	}
+	for _, want := range []string{"grpc_health_check: {}", "http2_protocol_options: {}", "port_value: 30000"} {
+		if !strings.Contains(got.Clusters, want) {
+			t.Errorf("clusters config missing %q:\n%s", want, got.Clusters)
+		}
+	}
+	if strings.Contains(got.Clusters, "http_health_check") || strings.Contains(got.Clusters, "port_value: 10256") {
+		t.Errorf("clusters config has the HTTP health check:\n%s", got.Clusters)
+	}
+}
+
+func Test_proxyConfigHealthCheckAnnotation(t *testing.T) {
+	nodes := []*v1.Node{makeNode("a", "10.0.0.1")}
+	tests := []struct {
+		value   string
+		want    []string
+		notWant []string
+	}{
+		{
+			value:   "tcp",
+			want:    []string{"tcp_health_check: {}", "health_check_config:\n            port_value: 30000"},
+			notWant: []string{"http_health_check", "port_value: 10256"},
+		},
+		{
+			value:   "none",
+			notWant: []string{"health_checks:", "health_check_config:", "port_value: 10256"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			service := makeService("test")
+			service.Annotations = map[string]string{constants.HealthCheckProtocolAnnotation: tt.value}
+			service.Spec.Type = v1.ServiceTypeLoadBalancer
+			service.Spec.IPFamilies = []v1.IPFamily{v1.IPv4Protocol}
+			service.Spec.Ports[0].NodePort = 30000
+			service.Spec.Ports[0].Protocol = v1.ProtocolTCP
+
+			got, err := proxyConfig(generateConfig(service, nodes))
+			if err != nil {
+				t.Fatalf("proxyConfig() error = %v", err)
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(got.Clusters, want) {
+					t.Errorf("clusters config missing %q:\n%s", want, got.Clusters)
+				}
+			}
+			for _, notWant := range tt.notWant {
+				if strings.Contains(got.Clusters, notWant) {
+					t.Errorf("clusters config has %q:\n%s", notWant, got.Clusters)
+				}
+			}
+		})
+	}
+}
+
+func Test_parseTimeout(t *testing.T) {
+	tests := []struct {
+		value     string
+		allowZero bool
+		want      string
+	}{
+		{value: "10s", want: "10s"},
+		{value: "250ms", want: "0.25s"},
+		{value: "1h30m", want: "5400s"},
+		{value: "0s", allowZero: true, want: "0s"},
+		{value: "0s"},
+		{value: "-1s"},
+		{value: "10"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			service := makeService("test")
+			service.Annotations = map[string]string{constants.IdleTimeoutAnnotation: tt.value}
+			if got := parseTimeout(service, constants.IdleTimeoutAnnotation, tt.allowZero); got != tt.want {
+				t.Errorf("parseTimeout() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_proxyConfigTimeouts(t *testing.T) {
+	service := makeService("test")
+	service.Spec.Type = v1.ServiceTypeLoadBalancer
+	service.Spec.IPFamilies = []v1.IPFamily{v1.IPv4Protocol}
+	service.Spec.Ports = []v1.ServicePort{
+		{Port: 80, NodePort: 30080, Protocol: v1.ProtocolTCP},
+		{Port: 53, NodePort: 30053, Protocol: v1.ProtocolUDP},
+	}
+	nodes := []*v1.Node{makeNode("a", "10.0.0.1")}
+
+	defaults, err := proxyConfig(generateConfig(service, nodes))
+	if err != nil {
+		t.Fatalf("proxyConfig() error = %v", err)
+	}
+	if !strings.Contains(defaults.Clusters, "connect_timeout: 5s") || strings.Contains(defaults.Bootstrap, "idle_timeout") {
+		t.Errorf("unexpected default timeouts:\n%s\n%s", defaults.Bootstrap, defaults.Clusters)
+	}
+
+	service.Annotations = map[string]string{
+		constants.ConnectTimeoutAnnotation: "500ms",
+		constants.IdleTimeoutAnnotation:    "30s",
+	}
+	got, err := proxyConfig(generateConfig(service, nodes))
+	if err != nil {
+		t.Fatalf("proxyConfig() error = %v", err)
+	}
+	if n := strings.Count(got.Clusters, "connect_timeout: 0.500s"); n != 2 {
+		t.Errorf("clusters config has %d connect timeouts, want 2:\n%s", n, got.Clusters)
+	}
+	// the tcp_proxy and the udp_proxy timeouts
+	if n := strings.Count(got.Bootstrap, "idle_timeout: 30s"); n != 2 {
+		t.Errorf("bootstrap config has %d idle timeouts, want 2:\n%s", n, got.Bootstrap)
+	}
+}
+
+func Test_proxyConfigCircuitBreakers(t *testing.T) {
+	service := makeService("test")
+	service.Spec.Type = v1.ServiceTypeLoadBalancer
+	service.Spec.IPFamilies = []v1.IPFamily{v1.IPv4Protocol}
+	service.Spec.Ports[0].NodePort = 30000
+	service.Spec.Ports[0].Protocol = v1.ProtocolTCP
+	nodes := []*v1.Node{makeNode("a", "10.0.0.1")}
+
+	defaults, err := proxyConfig(generateConfig(service, nodes))
+	if err != nil {
+		t.Fatalf("proxyConfig() error = %v", err)
+	}
+	if strings.Contains(defaults.Clusters, "circuit_breakers") {
+		t.Errorf("unexpected circuit breakers:\n%s", defaults.Clusters)
+	}
+
+	service.Annotations = map[string]string{
+		constants.MaxConnectionsAnnotation:     "100",
+		constants.MaxPendingRequestsAnnotation: "none",
+	}
+	got, err := proxyConfig(generateConfig(service, nodes))
+	if err != nil {
+		t.Fatalf("proxyConfig() error = %v", err)
+	}
+	want := `
+  circuit_breakers:
+    thresholds:
+    - max_connections: 100
+  connect_timeout: 5s`
+	if !strings.Contains(got.Clusters, want) {
+		t.Errorf("clusters config missing %s in:\n%s", want, got.Clusters)
+	}
+	// the limits are applied without restarting the proxy
+	if got.Bootstrap != defaults.Bootstrap {
+		t.Errorf("bootstrap config changed with the circuit breakers")
+	}
+}
+
+func Test_proxyConfigHealthyPanicThreshold(t *testing.T) {
+	service := makeService("test")
+	service.Spec.Type = v1.ServiceTypeLoadBalancer
+	service.Spec.IPFamilies = []v1.IPFamily{v1.IPv4Protocol}
+	service.Spec.Ports[0].NodePort = 30000
+	service.Spec.Ports[0].Protocol = v1.ProtocolTCP
+	nodes := []*v1.Node{makeNode("a", "10.0.0.1")}
+
+	tests := []struct {
+		name  string
+		value string
+		want  string
+	}{
+		{name: "default"},
+		{name: "invalid", value: "150"},
+		{name: "disabled", value: "0", want: "healthy_panic_threshold: {}"},
+		{name: "percentage", value: "25%", want: "healthy_panic_threshold:\n      value: 25"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service.Annotations = map[string]string{}
+			if tt.value != "" {
+				service.Annotations[constants.HealthyPanicThresholdAnnotation] = tt.value
+			}
+			got, err := proxyConfig(generateConfig(service, nodes))
+			if err != nil {
+				t.Fatalf("proxyConfig() error = %v", err)
+			}
+			if tt.want == "" && strings.Contains(got.Clusters, "common_lb_config") {
+				t.Errorf("unexpected panic threshold:\n%s", got.Clusters)
+			}
+			if tt.want != "" && !strings.Contains(got.Clusters, tt.want) {
+				t.Errorf("clusters config missing %s in:\n%s", tt.want, got.Clusters)
 			}
 		})
 	}
 }
+
+func Test_proxyConfigSlowStart(t *testing.T) {
+	service := makeService("test")
+	service.Spec.Type = v1.ServiceTypeLoadBalancer
+	service.Spec.IPFamilies = []v1.IPFamily{v1.IPv4Protocol}
+	service.Spec.Ports[0].NodePort = 30000
+	service.Spec.Ports[0].Protocol = v1.ProtocolTCP
+	service.Annotations = map[string]string{constants.SlowStartWindowAnnotation: "30s"}
+	nodes := []*v1.Node{makeNode("a", "10.0.0.1")}
+
+	got, err := proxyConfig(generateConfig(service, nodes))
+	if err != nil {
+		t.Fatalf("proxyConfig() error = %v", err)
+	}
+	// ROUND_ROBIN is the default policy, it is not encoded
+	if !strings.Contains(got.Clusters, "slow_start_window: 30s") || strings.Contains(got.Clusters, "lb_policy") {
+		t.Errorf("clusters config missing the round robin slow start in:\n%s", got.Clusters)
+	}
+
+	// the clusters of the client IP affinity keep the ring hash
+	service.Spec.SessionAffinity = v1.ServiceAffinityClientIP
+	got, err = proxyConfig(generateConfig(service, nodes))
+	if err != nil {
+		t.Fatalf("proxyConfig() error = %v", err)
+	}
+	if !strings.Contains(got.Clusters, "lb_policy: RING_HASH") || strings.Contains(got.Clusters, "slow_start_config") {
+		t.Errorf("unexpected slow start with session affinity:\n%s", got.Clusters)
+	}
+}
+
+func Test_proxyConfigHostNetworkBackends(t *testing.T) {
+	service := makeService("test")
+	service.Spec.Type = v1.ServiceTypeLoadBalancer
+	service.Spec.IPFamilies = []v1.IPFamily{v1.IPv4Protocol}
+	service.Spec.Ports = []v1.ServicePort{
+		{Name: "http", Port: 80, NodePort: 30080, TargetPort: intstr.FromInt32(8080), Protocol: v1.ProtocolTCP},
+		{Name: "https", Port: 443, NodePort: 30443, Protocol: v1.ProtocolTCP},
+		{Name: "metrics", Port: 9100, NodePort: 30100, TargetPort: intstr.FromString("metrics"), Protocol: v1.ProtocolTCP},
+		{Name: "dns", Port: 53, NodePort: 30053, TargetPort: intstr.FromInt32(5353), Protocol: v1.ProtocolUDP},
+	}
+	service.Annotations = map[string]string{constants.HostNetworkBackendsAnnotation: "true"}
+	nodes := []*v1.Node{makeNode("a", "10.0.0.1")}
+
+	got := generateConfig(service, nodes)
+	tests := []struct {
+		key             string
+		wantPort        int
+		wantHealthCheck string
+	}{
+		{key: "IPv4_80_TCP", wantPort: 8080, wantHealthCheck: healthCheckTCP},
+		// the targetPort defaults to the Service port
+		{key: "IPv4_443_TCP", wantPort: 443, wantHealthCheck: healthCheckTCP},
+		// the named ports keep using the NodePort
+		{key: "IPv4_9100_TCP", wantPort: 30100},
+		{key: "IPv4_53_UDP", wantPort: 5353},
+	}
+	for _, tt := range tests {
+		servicePort := got.ServicePorts[tt.key]
+		if len(servicePort.Cluster) != 1 || servicePort.Cluster[0].Port != tt.wantPort {
+			t.Errorf("%s backends = %v, want port %d", tt.key, servicePort.Cluster, tt.wantPort)
+		}
+		if servicePort.HealthCheck != tt.wantHealthCheck {
+			t.Errorf("%s health check = %q, want %q", tt.key, servicePort.HealthCheck, tt.wantHealthCheck)
+		}
+	}
+
+	files, err := proxyConfig(got)
+	if err != nil {
+		t.Fatalf("proxyConfig() error = %v", err)
+	}
+	for _, want := range []string{"tcp_health_check: {}", "port_value: 8080"} {
+		if !strings.Contains(files.Clusters, want) {
+			t.Errorf("clusters config missing %q:\n%s", want, files.Clusters)
+		}
+	}
+
+	// an invalid value is ignored
+	service.Annotations[constants.HostNetworkBackendsAnnotation] = "yes"
+	if port := generateConfig(service, nodes).ServicePorts["IPv4_80_TCP"].Cluster[0].Port; port != 30080 {
+		t.Errorf("backend port with an invalid annotation = %d, want the NodePort 30080", port)
+	}
+}
+
+func Test_generateConfigSamePortTCPAndUDP(t *testing.T) {
+	service := &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "dns"},
+		Spec: v1.ServiceSpec{
+			Type:       v1.ServiceTypeLoadBalancer,
+			IPFamilies: []v1.IPFamily{v1.IPv4Protocol, v1.IPv6Protocol},
+			Ports: []v1.ServicePort{
+				{Name: "dns-tcp", Port: 53, NodePort: 30053, Protocol: v1.ProtocolTCP},
+				{Name: "dns-udp", Port: 53, NodePort: 30053, Protocol: v1.ProtocolUDP},
+			},
+		},
+	}
+	nodes := []*v1.Node{makeNode("a", "10.0.0.1")}
+	nodes[0].Status.Addresses = append(nodes[0].Status.Addresses, v1.NodeAddress{Type: v1.NodeInternalIP, Address: "fd00::1"})
+	got := generateConfig(service, nodes)
+	want := []string{"IPv4_53_TCP", "IPv4_53_UDP", "IPv6_53_TCP", "IPv6_53_UDP"}
+	if diff := cmp.Diff(want, servicePortKeys(got)); diff != "" {
+		t.Errorf("generateConfig() listeners mismatch (-want +got):\n%s", diff)
+	}
+	for _, key := range want {
+		servicePort := got.ServicePorts[key]
+		if !strings.HasSuffix(key, servicePort.Listener.Protocol) || len(servicePort.Cluster) != 1 || servicePort.Cluster[0].Protocol != servicePort.Listener.Protocol {
+			t.Errorf("generateConfig() port %s = %+v, want a %s listener and cluster", key, servicePort, servicePort.Listener.Protocol)
+		}
+	}
+}
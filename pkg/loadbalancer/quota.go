@@ -0,0 +1,29 @@
+package loadbalancer
+
+import (
+	"fmt"
+
+	"sigs.k8s.io/cloud-provider-kind/pkg/constants"
+	"sigs.k8s.io/cloud-provider-kind/pkg/container"
+)
+
+// reserveQuota checks the cluster has less loadbalancers than --max-load-balancers before
+// creating a new one, like the cloud quotas. The returned function must be called once the
+// container is created, the creations are serialized so the concurrent syncs can not exceed
+// the limit. The Services sharing a loadbalancer count once.
+func (s *Server) reserveQuota(clusterName string) (func(), error) {
+	if s.maxLoadBalancers <= 0 {
+		return func() {}, nil
+	}
+	s.quotaMu.Lock()
+	containers, err := container.ListByLabel(fmt.Sprintf("%s=%s", constants.NodeCCMLabelKey, clusterName))
+	if err != nil {
+		s.quotaMu.Unlock()
+		return nil, fmt.Errorf("failed to count the loadbalancers of cluster %s: %w", clusterName, err)
+	}
+	if len(containers) >= s.maxLoadBalancers {
+		s.quotaMu.Unlock()
+		return nil, provisioningError(ReasonQuotaExceeded, fmt.Errorf("quota exceeded, cluster %s already has %d loadbalancers of the maximum %d", clusterName, len(containers), s.maxLoadBalancers))
+	}
+	return s.quotaMu.Unlock, nil
+}
@@ -0,0 +1,74 @@
+package loadbalancer
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"sigs.k8s.io/cloud-provider-kind/pkg/constants"
+	"sigs.k8s.io/cloud-provider-kind/pkg/container"
+)
+
+func TestEnsureLoadBalancerFakeQuotaExceeded(t *testing.T) {
+	fake := container.NewFake()
+	defer container.SetRuntime(fake)()
+	fake.AddContainer(&container.FakeContainer{
+		Name:     "kind-control-plane",
+		Image:    "kindest/node:v1.30.0",
+		Labels:   map[string]string{constants.KindClusterLabelKey: "kind"},
+		Networks: []string{"kind"},
+		IPv4:     "172.18.0.2",
+		Running:  true,
+	})
+	// the loadbalancers of other clusters do not count
+	fake.AddContainer(&container.FakeContainer{
+		Name:    "other-lb",
+		Labels:  map[string]string{constants.NodeCCMLabelKey: "other"},
+		Running: true,
+	})
+	fake.ExecHook = fakeListeners(80)
+
+	s := NewServer(nil, nil, nil).(*Server)
+	s.tunnelManager = nil
+	s.hostAddress = ""
+	s.publishUnready = true
+	s.maxLoadBalancers = 1
+	makeLBService := func(name string) *v1.Service {
+		return &v1.Service{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+			Spec: v1.ServiceSpec{
+				Type:       v1.ServiceTypeLoadBalancer,
+				IPFamilies: []v1.IPFamily{v1.IPv4Protocol},
+				Ports:      []v1.ServicePort{{Port: 80, NodePort: 30080, Protocol: v1.ProtocolTCP}},
+			},
+		}
+	}
+	nodes := []*v1.Node{makeNode("kind-control-plane", "172.18.0.2")}
+	first, second := makeLBService("first"), makeLBService("second")
+
+	if _, err := s.EnsureLoadBalancer(context.Background(), "kind", first, nodes); err != nil {
+		t.Fatalf("EnsureLoadBalancer() unexpected error: %v", err)
+	}
+	// the existing loadbalancers are still updated
+	if _, err := s.EnsureLoadBalancer(context.Background(), "kind", first, nodes); err != nil {
+		t.Fatalf("EnsureLoadBalancer() of the existing loadbalancer unexpected error: %v", err)
+	}
+	_, err := s.EnsureLoadBalancer(context.Background(), "kind", second, nodes)
+	var provisioningErr *ProvisioningError
+	if !errors.As(err, &provisioningErr) || provisioningErr.Reason != ReasonQuotaExceeded {
+		t.Fatalf("EnsureLoadBalancer() error = %v, want reason %s", err, ReasonQuotaExceeded)
+	}
+	if fake.Container(loadBalancerName("kind", second)) != nil {
+		t.Errorf("loadbalancer container created over the quota")
+	}
+
+	if err := s.EnsureLoadBalancerDeleted(context.Background(), "kind", first); err != nil {
+		t.Fatalf("EnsureLoadBalancerDeleted() unexpected error: %v", err)
+	}
+	if _, err := s.EnsureLoadBalancer(context.Background(), "kind", second, nodes); err != nil {
+		t.Fatalf("EnsureLoadBalancer() after releasing the quota unexpected error: %v", err)
+	}
+}
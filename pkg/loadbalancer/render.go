@@ -0,0 +1,55 @@
+package loadbalancer
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+)
+
+// Render writes the envoy config that would be applied to the loadbalancer of each Service,
+// without touching the containers. The Services sharing a loadbalancer are rendered once, the
+// output is deterministic so it can be compared with golden files.
+func Render(w io.Writer, clusterName string, services []*v1.Service, nodes []*v1.Node) error {
+	services = append([]*v1.Service{}, services...)
+	sortServicesByName(services)
+	rendered := map[string]bool{}
+	for _, service := range services {
+		name := loadBalancerName(clusterName, service)
+		if rendered[name] {
+			continue
+		}
+		rendered[name] = true
+		group := sharedIPGroup(service, services)
+		data, err := sharedConfig(service, group, nodes)
+		if err != nil {
+			fmt.Fprintf(w, "# loadbalancer: %s\n# service: %s\n# error: %v\n", name, klog.KObj(service), err)
+			continue
+		}
+		config, err := proxyConfig(data)
+		if err != nil {
+			return fmt.Errorf("failed to render the config of loadbalancer %s: %w", name, err)
+		}
+		names := []string{}
+		for _, peer := range group {
+			names = append(names, klog.KObj(peer).String())
+		}
+		fmt.Fprintf(w, "# loadbalancer: %s\n# services: %s\n", name, strings.Join(names, ","))
+		fmt.Fprintf(w, "# file: %s\n%s\n---\n", proxyConfigPath, strings.TrimSpace(config.Bootstrap))
+		fmt.Fprintf(w, "# loadbalancer: %s\n# file: %s\n%s\n---\n", name, proxyClustersPath, strings.TrimSpace(config.Clusters))
+	}
+	return nil
+}
+
+// sortServicesByName sorts the Services by namespace and name
+func sortServicesByName(services []*v1.Service) {
+	sort.Slice(services, func(i, j int) bool {
+		if services[i].Namespace != services[j].Namespace {
+			return services[i].Namespace < services[j].Namespace
+		}
+		return services[i].Name < services[j].Name
+	})
+}
@@ -0,0 +1,45 @@
+package loadbalancer
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+func Test_Render(t *testing.T) {
+	nodes := []*v1.Node{makeNode("a", "10.0.0.1")}
+	http := makeSharedService("http", "web", time.Hour, v1.ServicePort{Port: 80, NodePort: 30080, Protocol: v1.ProtocolTCP})
+	https := makeSharedService("https", "web", time.Minute, v1.ServicePort{Port: 443, NodePort: 30443, Protocol: v1.ProtocolTCP})
+	dns := makeSharedService("dns", "", time.Minute, v1.ServicePort{Port: 53, NodePort: 30053, Protocol: v1.ProtocolUDP})
+
+	var out bytes.Buffer
+	if err := Render(&out, "kind", []*v1.Service{https, dns, http}, nodes); err != nil {
+		t.Fatalf("Render() unexpected error: %v", err)
+	}
+	got := out.String()
+	if n := strings.Count(got, "# file: "+proxyConfigPath); n != 2 {
+		t.Errorf("Render() rendered %d loadbalancers, want 2:\n%s", n, got)
+	}
+	// the Services are rendered in order, the shared loadbalancer once with all its Services
+	dnsIndex := strings.Index(got, "# services: default/dns\n")
+	sharedIndex := strings.Index(got, "# services: default/http,default/https\n")
+	if dnsIndex == -1 || sharedIndex == -1 || dnsIndex > sharedIndex {
+		t.Errorf("Render() unexpected loadbalancers:\n%s", got)
+	}
+	for _, want := range []string{"port_value: 443", "port_value: 30080", "protocol: UDP"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("Render() missing %q in:\n%s", want, got)
+		}
+	}
+
+	var again bytes.Buffer
+	if err := Render(&again, "kind", []*v1.Service{http, https, dns}, nodes); err != nil {
+		t.Fatalf("Render() unexpected error: %v", err)
+	}
+	if again.String() != got {
+		t.Errorf("Render() output depends on the order of the Services")
+	}
+}
@@ -0,0 +1,79 @@
+package loadbalancer
+
+import (
+	"fmt"
+	"net/netip"
+	"strings"
+
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	caresv3 "github.com/envoyproxy/go-control-plane/envoy/extensions/network/dns_resolver/cares/v3"
+	"google.golang.org/protobuf/types/known/anypb"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+
+	"sigs.k8s.io/cloud-provider-kind/pkg/config"
+	"sigs.k8s.io/cloud-provider-kind/pkg/constants"
+)
+
+// defaultDNSPort is the port of the DNS resolvers without one
+const defaultDNSPort = 53
+
+// ParseDNSResolvers parses a comma separated list of DNS servers, IP[:PORT] with the IPv6
+// addresses in brackets if they have a port, ex. 172.18.0.10,[fd00::10]:5353
+func ParseDNSResolvers(value string) ([]netip.AddrPort, error) {
+	resolvers := []netip.AddrPort{}
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if ip, err := netip.ParseAddr(entry); err == nil {
+			resolvers = append(resolvers, netip.AddrPortFrom(ip.Unmap(), defaultDNSPort))
+			continue
+		}
+		resolver, err := netip.ParseAddrPort(entry)
+		if err != nil || resolver.Port() == 0 {
+			return nil, fmt.Errorf("invalid DNS resolver %q, expected IP[:PORT]", entry)
+		}
+		resolvers = append(resolvers, netip.AddrPortFrom(resolver.Addr().Unmap(), resolver.Port()))
+	}
+	return resolvers, nil
+}
+
+// defaultDNSResolvers returns the DNS resolvers of --dns-resolvers, validated on startup
+func defaultDNSResolvers() []netip.AddrPort {
+	resolvers, _ := ParseDNSResolvers(config.DefaultConfig.DNSResolvers)
+	if len(resolvers) == 0 {
+		return nil
+	}
+	return resolvers
+}
+
+// parseDNSResolvers returns the DNS resolvers of the annotation of the Service, nil if it
+// is not set or invalid to use the resolvers of the proxy
+func parseDNSResolvers(service *v1.Service) []netip.AddrPort {
+	value, ok := service.Annotations[constants.DNSResolversAnnotation]
+	if !ok {
+		return nil
+	}
+	resolvers, err := ParseDNSResolvers(value)
+	if err != nil || len(resolvers) == 0 {
+		klog.InfoS("Ignoring invalid DNS resolvers", "service", klog.KObj(service), "annotation", constants.DNSResolversAnnotation, "value", value, "err", err)
+		return nil
+	}
+	return resolvers
+}
+
+// dnsResolverConfig returns the c-ares resolver querying the DNS servers, instead of the
+// ones of the resolv.conf of the proxy container, the embedded DNS of the container runtime
+func dnsResolverConfig(resolvers []netip.AddrPort) (*corev3.TypedExtensionConfig, error) {
+	cares := &caresv3.CaresDnsResolverConfig{}
+	for _, resolver := range resolvers {
+		cares.Resolvers = append(cares.Resolvers, socketAddress(resolver.Addr().String(), int(resolver.Port()), corev3.SocketAddress_UDP))
+	}
+	typedConfig, err := anypb.New(cares)
+	if err != nil {
+		return nil, err
+	}
+	return &corev3.TypedExtensionConfig{Name: "envoy.network.dns_resolver.cares", TypedConfig: typedConfig}, nil
+}
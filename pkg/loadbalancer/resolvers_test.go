@@ -0,0 +1,86 @@
+package loadbalancer
+
+import (
+	"net/netip"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	v1 "k8s.io/api/core/v1"
+
+	"sigs.k8s.io/cloud-provider-kind/pkg/config"
+	"sigs.k8s.io/cloud-provider-kind/pkg/constants"
+)
+
+func TestParseDNSResolvers(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    []string
+		wantErr bool
+	}{
+		{value: "", want: []string{}},
+		{value: "172.18.0.10", want: []string{"172.18.0.10:53"}},
+		{value: "172.18.0.10:5353, fd00::10,[fd00::11]:5353", want: []string{"172.18.0.10:5353", "[fd00::10]:53", "[fd00::11]:5353"}},
+		{value: "::ffff:172.18.0.10", want: []string{"172.18.0.10:53"}},
+		{value: "kube-dns.kube-system", wantErr: true},
+		{value: "172.18.0.10:0", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			resolvers, err := ParseDNSResolvers(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseDNSResolvers() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			got := []string{}
+			for _, resolver := range resolvers {
+				got = append(got, resolver.String())
+			}
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("ParseDNSResolvers() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func Test_proxyConfigDNSResolvers(t *testing.T) {
+	defer func(resolvers string) { config.DefaultConfig.DNSResolvers = resolvers }(config.DefaultConfig.DNSResolvers)
+	config.DefaultConfig.DNSResolvers = "172.18.0.10"
+	node := makeNode("a", "10.0.0.1")
+	node.Status.Addresses = append(node.Status.Addresses, v1.NodeAddress{Type: v1.NodeHostName, Address: "kind-worker"})
+	service := makeService("test")
+	service.Spec.Type = v1.ServiceTypeLoadBalancer
+	service.Spec.IPFamilies = []v1.IPFamily{v1.IPv4Protocol}
+	service.Spec.Ports[0].NodePort = 30000
+	service.Spec.Ports[0].Protocol = v1.ProtocolTCP
+	service.Annotations = map[string]string{constants.DNSResolversAnnotation: "172.18.0.20:5353"}
+
+	// the resolvers of the Service only apply to the hostname backends
+	data := generateConfig(service, []*v1.Node{node})
+	if diff := cmp.Diff([]netip.AddrPort{netip.MustParseAddrPort("172.18.0.10:53")}, data.DNSResolvers, cmp.Comparer(func(a, b netip.AddrPort) bool { return a == b })); diff != "" {
+		t.Errorf("generateConfig() default resolvers mismatch (-want +got):\n%s", diff)
+	}
+	for key, servicePort := range data.ServicePorts {
+		if servicePort.DNSResolvers != nil {
+			t.Errorf("generateConfig() port %s resolvers = %v, want none for the IP backends", key, servicePort.DNSResolvers)
+		}
+	}
+
+	service.Annotations[constants.NodeAddressTypeAnnotation] = "Hostname"
+	files, err := proxyConfig(generateConfig(service, []*v1.Node{node}))
+	if err != nil {
+		t.Fatalf("proxyConfig() error = %v", err)
+	}
+	for _, want := range []string{"type: STRICT_DNS", "name: envoy.network.dns_resolver.cares", "address: 172.18.0.20", "port_value: 5353"} {
+		if !strings.Contains(files.Clusters, want) {
+			t.Errorf("clusters config missing %q:\n%s", want, files.Clusters)
+		}
+	}
+	for _, want := range []string{"name: envoy.network.dns_resolver.cares", "address: 172.18.0.10"} {
+		if !strings.Contains(files.Bootstrap, want) {
+			t.Errorf("bootstrap config missing %q:\n%s", want, files.Bootstrap)
+		}
+	}
+}
@@ -6,140 +6,673 @@ import (
 	"encoding/base32"
 	"errors"
 	"fmt"
-	"os"
 	"runtime"
 	"strings"
+	"sync"
 
 	v1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	discoverylisters "k8s.io/client-go/listers/discovery/v1"
 	cloudprovider "k8s.io/cloud-provider"
 	"k8s.io/klog/v2"
+	netutils "k8s.io/utils/net"
+	"k8s.io/utils/ptr"
+
+	"sigs.k8s.io/cloud-provider-kind/pkg/config"
 	"sigs.k8s.io/cloud-provider-kind/pkg/constants"
 	"sigs.k8s.io/cloud-provider-kind/pkg/container"
 )
 
 type Server struct {
+	kubeClient    kubernetes.Interface
 	tunnelManager *tunnelManager
+	devDomain     *DevDomain
+	// endpointSliceLister looks up the endpoints of the Services, optional
+	endpointSliceLister discoverylisters.EndpointSliceLister
+	// hostAddress is set when the container IPs are not reachable, the ports
+	// are published on the container runtime host and its address is reported.
+	hostAddress string
+	// publishRandomPorts publishes the ports on free host ports, that are reported in the
+	// Service status, instead of the same ports
+	publishRandomPorts bool
+	// statusHostname defines if the dev domain name is reported in the Service status
+	statusHostname string
+	// publishUnready publishes the status without waiting for healthy upstreams
+	publishUnready bool
+	// configHashes caches the config applied to the loadbalancers
+	configHashes *configHashes
+	// locks serializes the operations on the loadbalancers shared by several Services
+	locks *containerLocks
+	// lastNodes keeps the nodes of the last update of the shared loadbalancers, to
+	// reconfigure them when one of their Services is deleted
+	lastNodes sync.Map
+	// healed keeps the loadbalancers restarted after a crash, the settings lost with the
+	// network namespace are applied again on their next update
+	healed sync.Map
+	// crashes keeps the recent crashes of the loadbalancers to recreate the crash loops
+	crashes *crashLoops
+	// maxLoadBalancers limits the loadbalancer containers of the cluster, 0 is unlimited,
+	// quotaMu serializes their creation to not exceed it
+	maxLoadBalancers int
+	quotaMu          sync.Mutex
+	// zones are the fake zones of the loadbalancers, each loadbalancer has a proxy per zone
+	// that sends the traffic to the nodes of its zone, empty for a single proxy
+	zones []string
 }
 
 var _ cloudprovider.LoadBalancer = &Server{}
 
-func NewServer() cloudprovider.LoadBalancer {
-	s := &Server{}
-	if runtime.GOOS == "darwin" || runtime.GOOS == "windows" {
+// NewServer returns a loadbalancer controller for the cluster, endpointSliceLister is
+// optional and checks that the Services have endpoints, devDomain is optional and
+// publishes the loadbalancers names and certificates.
+func NewServer(kubeClient kubernetes.Interface, endpointSliceLister discoverylisters.EndpointSliceLister, devDomain *DevDomain) cloudprovider.LoadBalancer {
+	s := &Server{
+		kubeClient:          kubeClient,
+		endpointSliceLister: endpointSliceLister,
+		devDomain:           devDomain,
+		hostAddress:         config.DefaultConfig.PublishHostAddress,
+		statusHostname:      config.DefaultConfig.StatusHostname,
+		publishUnready:      config.DefaultConfig.PublishUnready,
+		configHashes:        newConfigHashes(),
+		locks:               newContainerLocks(),
+		crashes:             newCrashLoops(),
+		maxLoadBalancers:    config.DefaultConfig.MaxLoadBalancers,
+	}
+	if config.DefaultConfig.PublishRandomPorts {
+		s.publishRandomPorts = true
+		if s.hostAddress == "" {
+			s.hostAddress = "127.0.0.1"
+		}
+	}
+	// the ports published on a remote host are reachable without tunnels
+	if (runtime.GOOS == "darwin" || runtime.GOOS == "windows") && s.hostAddress == "" {
 		s.tunnelManager = NewTunnelManager()
 	}
+	// the zones are validated on startup, the proxies of the other zones would publish the
+	// same ports on the host and they are not reachable through the tunnels
+	s.zones, _ = ParseLoadBalancerZones(config.DefaultConfig.LoadBalancerZones)
+	if len(s.zones) > 1 && (s.hostAddress != "" || s.tunnelManager != nil) {
+		klog.InfoS("Only the first zone has a loadbalancer proxy when the ports are published on the host", "zones", s.zones)
+		s.zones = s.zones[:1]
+	}
 	return s
 }
 
 func (s *Server) GetLoadBalancer(ctx context.Context, clusterName string, service *v1.Service) (*v1.LoadBalancerStatus, bool, error) {
+	status, ok, err := s.loadBalancerStatus(clusterName, service)
+	if err != nil || !ok {
+		return status, ok, err
+	}
+	if s.devDomain != nil {
+		status = hostnameStatus(status, s.devDomain.hostname(service), s.statusHostname)
+	}
+	return status, true, nil
+}
+
+// loadBalancerStatus returns the status with the loadbalancer addresses
+func (s *Server) loadBalancerStatus(clusterName string, service *v1.Service) (*v1.LoadBalancerStatus, bool, error) {
 	// report status
 	name := loadBalancerName(clusterName, service)
-	ipv4, ipv6, err := container.IPs(name)
+	ipv4, ipv6, err := loadBalancerAddresses(name)
 	if err != nil {
 		if strings.Contains(err.Error(), "failed to get container details") {
 			return nil, false, nil
 		}
 		return nil, false, err
 	}
+	if s.hostAddress != "" {
+		ipv4, ipv6 = "", ""
+		if netutils.IsIPv6String(s.hostAddress) {
+			ipv6 = s.hostAddress
+		} else {
+			ipv4 = s.hostAddress
+		}
+	}
 	status := &v1.LoadBalancerStatus{}
 
 	// process Ports
-	portStatus := []v1.PortStatus{}
-	for _, port := range service.Spec.Ports {
-		portStatus = append(portStatus, v1.PortStatus{
-			Port:     port.Port,
-			Protocol: port.Protocol,
-		})
-	}
-
-	// process IPs
-	svcIPv4 := false
-	svcIPv6 := false
-	for _, family := range service.Spec.IPFamilies {
-		if family == v1.IPv4Protocol {
-			svcIPv4 = true
+	var hostPorts map[string]int
+	if s.publishRandomPorts {
+		hostPorts, err = container.HostPorts(name)
+		if err != nil {
+			return nil, false, err
 		}
-		if family == v1.IPv6Protocol {
-			svcIPv6 = true
+	}
+	lbConfig := generateConfig(service, nil)
+	ingress := func(ip string, family v1.IPFamily) v1.LoadBalancerIngress {
+		// the proxy terminates the connections and opens new ones to the nodes, the
+		// traffic of the Pods to the loadbalancer IPs must not be short-circuited
+		return v1.LoadBalancerIngress{
+			IP:     ip,
+			IPMode: ptr.To(v1.LoadBalancerIPModeProxy),
+			Ports:  portStatuses(service, lbConfig, family, hostPorts),
 		}
 	}
+
+	// process IPs, only the families the loadbalancer listens on
+	svcIPv4, svcIPv6 := listenerIPFamilies(lbConfig)
 	if ipv4 != "" && svcIPv4 {
-		status.Ingress = append(status.Ingress, v1.LoadBalancerIngress{IP: ipv4, Ports: portStatus})
+		status.Ingress = append(status.Ingress, ingress(ipv4, v1.IPv4Protocol))
 	}
 	if ipv6 != "" && svcIPv6 {
-		status.Ingress = append(status.Ingress, v1.LoadBalancerIngress{IP: ipv6, Ports: portStatus})
+		status.Ingress = append(status.Ingress, ingress(ipv6, v1.IPv6Protocol))
+	}
+
+	// the proxies of the other zones have their own addresses
+	for _, replica := range s.zoneReplicas(name, service) {
+		ipv4, ipv6, err := loadBalancerAddresses(replica.name)
+		if err != nil {
+			// the replicas are created after the loadbalancer, they are published once ready
+			continue
+		}
+		if ipv4 != "" && svcIPv4 {
+			status.Ingress = append(status.Ingress, ingress(ipv4, v1.IPv4Protocol))
+		}
+		if ipv6 != "" && svcIPv6 {
+			status.Ingress = append(status.Ingress, ingress(ipv6, v1.IPv6Protocol))
+		}
 	}
 
 	return status, true, nil
 }
 
+// portStatuses returns the status of the Service ports on the loadbalancer addresses of the
+// IP family, with the error of the ports the loadbalancer does not serve on them. hostPorts
+// are the host ports published by the container runtime when they are random.
+func portStatuses(service *v1.Service, lbConfig *proxyConfigData, family v1.IPFamily, hostPorts map[string]int) []v1.PortStatus {
+	ports := []v1.PortStatus{}
+	for _, port := range service.Spec.Ports {
+		status := v1.PortStatus{Port: port.Port, Protocol: port.Protocol}
+		_, listening := lbConfig.ServicePorts[fmt.Sprintf("%s_%d_%s", family, port.Port, port.Protocol)]
+		switch {
+		case port.Protocol != v1.ProtocolTCP && port.Protocol != v1.ProtocolUDP:
+			status.Error = ptr.To(PortErrorUnsupportedProtocol)
+		case !listening:
+			status.Error = ptr.To(PortErrorNotListening)
+		case hostPorts != nil:
+			// the clients have to use the host port assigned by the container runtime
+			hostPort, ok := hostPorts[fmt.Sprintf("%d/%s", port.Port, strings.ToLower(string(port.Protocol)))]
+			if !ok {
+				status.Error = ptr.To(PortErrorNotPublished)
+				break
+			}
+			status.Port = int32(hostPort)
+		}
+		ports = append(ports, status)
+	}
+	return ports
+}
+
+// loadBalancerAddresses returns the addresses of the loadbalancer container, the aliases
+// announced on the network if it has them
+func loadBalancerAddresses(name string) (ipv4 string, ipv6 string, err error) {
+	ipv4, ipv6, err = container.IPs(name)
+	if err != nil {
+		return "", "", err
+	}
+	aliasIPv4, aliasIPv6, err := advertisedAddresses(name)
+	if err != nil {
+		return "", "", err
+	}
+	if aliasIPv4 != "" || aliasIPv6 != "" {
+		return aliasIPv4, aliasIPv6, nil
+	}
+	return ipv4, ipv6, nil
+}
+
 func (s *Server) GetLoadBalancerName(ctx context.Context, clusterName string, service *v1.Service) string {
 	return loadBalancerName(clusterName, service)
 }
 
+// EnsureLoadBalancer provisions the loadbalancer as a transaction: the container is created
+// and its IP reserved, the config applied, the listeners verified and only then the status
+// is returned to be published on the Service. If any of the steps fail the changes are
+// rolled back and the error is returned so the service controller retries later.
 func (s *Server) EnsureLoadBalancer(ctx context.Context, clusterName string, service *v1.Service, nodes []*v1.Node) (*v1.LoadBalancerStatus, error) {
 	name := loadBalancerName(clusterName, service)
-	if !container.IsRunning(name) {
-		if container.Exist(name) {
-			err := container.Delete(name)
-			if err != nil {
-				return nil, err
-			}
+	ctx, logger := withContainer(ctx, name)
+	networks := ClusterNetworks(clusterName)
+	err := checkIPFamilies(service, networks[0])
+	if err != nil {
+		return nil, err
+	}
+	err = validateSharedIPKey(service)
+	if err != nil {
+		return nil, err
+	}
+	err = validateAdoptedContainer(service)
+	if err != nil {
+		return nil, err
+	}
+	err = checkUnsupportedFeatures(service)
+	if err != nil {
+		return nil, err
+	}
+	unlock := s.locks.lock(name)
+	defer unlock()
+	adopted := adoptedContainer(service) != ""
+	if adopted {
+		err := adoptLoadBalancer(ctx, clusterName, service)
+		if err != nil {
+			return nil, err
 		}
 	}
-	if !container.Exist(name) {
-		klog.V(2).Infof("creating container for loadbalancer")
-		err := s.createLoadBalancer(clusterName, service, proxyImage)
+	// every call inspects the container, look up its state once
+	exists := container.Exist(name)
+	running := exists && container.IsRunning(name)
+	// the containers created by previous versions must be migrated before checking their state
+	if running {
+		err := migrateState(ctx, name)
 		if err != nil {
 			return nil, err
 		}
 	}
+	// a container that is not running or that was never committed is the leftover
+	// of a previous failed or interrupted provisioning, start from scratch. The adopted
+	// containers are configured again instead.
+	if exists && !adopted && (!running || !isCommitted(name)) {
+		logger.V(2).Info("Deleting stale loadbalancer container")
+		err := s.deleteLoadBalancer(ctx, name, service)
+		if err != nil {
+			return nil, err
+		}
+		exists = container.Exist(name)
+	}
+
+	tx := newTransaction(ctx, name)
+	created := false
+	if !exists {
+		logger.V(2).Info("Creating loadbalancer container")
+		services, err := s.sharedIPServices(ctx, service)
+		if err != nil {
+			return nil, tx.rollback(err)
+		}
+		err = s.checkHostPorts(services)
+		if err != nil {
+			return nil, tx.rollback(err)
+		}
+		release, err := s.reserveQuota(clusterName)
+		if err != nil {
+			return nil, tx.rollback(err)
+		}
+		err = s.createLoadBalancer(clusterName, name, s.primaryZone(service), service, services, networks[0], proxyImage)
+		release()
+		if err != nil {
+			return nil, tx.rollback(err)
+		}
+		created = true
+		s.configHashes.forget(name)
+		tx.onRollback(func() error {
+			return deleteContainer(name)
+		})
+	}
+	err = ensureNetworks(name, networks)
+	if err != nil {
+		return nil, tx.rollback(err)
+	}
+	// keep the current config of the existing container to restore it if the update fails,
+	// the container created by the transaction is deleted instead
+	if !created {
+		previousConfig, err := readProxyConfig(name)
+		if err == nil {
+			tx.onRollback(func() error {
+				// the config refused by the validation was never applied
+				if current, err := readProxyConfig(name); err == nil && current == previousConfig {
+					return nil
+				}
+				s.configHashes.forget(name)
+				return applyProxyConfig(klog.NewContext(context.Background(), logger), name, previousConfig)
+			})
+		}
+	}
+
+	// wait for the container runtime to reserve the IPs
+	svcIPv4, svcIPv6 := serviceIPFamilies(service)
+	err = waitForIPs(ctx, name, svcIPv4, svcIPv6)
+	if err != nil {
+		return nil, tx.rollback(provisioningError(ReasonIPAllocationFailed, fmt.Errorf("loadbalancer %s IPs not allocated: %w", name, err)))
+	}
+	if created {
+		err = ensureAdvertisement(ctx, clusterName, name)
+		if err != nil {
+			return nil, tx.rollback(provisioningError(ReasonIPAllocationFailed, err))
+		}
+	}
 
 	// update loadbalancer
-	klog.V(2).Infof("updating loadbalancer")
-	err := s.UpdateLoadBalancer(ctx, clusterName, service, nodes)
+	logger.V(2).Info("Updating loadbalancer")
+	err = s.updateLoadBalancer(ctx, clusterName, service, nodes)
 	if err != nil {
-		return nil, err
+		return nil, tx.rollback(provisioningError(ReasonConfigFailed, err))
 	}
 
 	// on some platforms that run containers in VMs forward from userspace
 	if s.tunnelManager != nil {
-		klog.V(2).Infof("updating loadbalancer tunnels on userspace")
-		err = s.tunnelManager.setupTunnels(loadBalancerName(clusterName, service))
+		logger.V(2).Info("Updating loadbalancer tunnels on userspace")
+		if created {
+			tx.onRollback(func() error {
+				return s.tunnelManager.removeTunnels(name)
+			})
+		}
+		services, err := s.sharedIPServices(ctx, service)
 		if err != nil {
-			return nil, err
+			return nil, tx.rollback(err)
+		}
+		err = s.tunnelManager.setupTunnels(loadBalancerName(clusterName, service), tunneledNodePorts(services))
+		if err != nil {
+			return nil, tx.rollback(err)
 		}
 	}
 
+	// verify the proxy is listening before publishing the addresses
+	err = waitForListeners(ctx, name, generateConfig(service, nodes))
+	if err != nil {
+		return nil, tx.rollback(provisioningError(ReasonConfigFailed, err))
+	}
+
 	// get loadbalancer Status
-	klog.V(2).Infof("get loadbalancer status")
-	status, ok, err := s.GetLoadBalancer(ctx, clusterName, service)
+	logger.V(2).Info("Getting loadbalancer status")
+	status, ok, err := s.loadBalancerStatus(clusterName, service)
+	if err != nil {
+		return nil, tx.rollback(err)
+	}
 	if !ok {
-		return nil, fmt.Errorf("loadbalancer %s not found", name)
+		return nil, tx.rollback(fmt.Errorf("loadbalancer %s not found", name))
+	}
+
+	err = commit(name)
+	if err != nil {
+		return nil, tx.rollback(err)
 	}
+
+	// the proxies of the other zones are provisioned once the first one is committed, the
+	// status is published again once they are ready
+	err = s.ensureZoneReplicas(ctx, clusterName, service, nodes)
 	if err != nil {
 		return nil, err
 	}
+	if len(s.zoneReplicas(name, service)) > 0 {
+		status, _, err = s.loadBalancerStatus(clusterName, service)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// the loadbalancer is completely provisioned but the status is not published until
+	// it can forward the traffic, it is retried later without recreating the container.
+	if !s.publishUnready {
+		// the health checks can not pass without endpoints, the reason is reported without
+		// waiting for them
+		err = s.checkEndpoints(ctx, service, nodes)
+		if err != nil {
+			return nil, err
+		}
+		err = checkUpstreams(name, generateConfig(service, nodes))
+		if err != nil && len(nodes) == 0 {
+			return nil, provisioningError(ReasonNoNodes, fmt.Errorf("there are no nodes to use as loadbalancer backends: %w", err))
+		}
+		if err != nil {
+			// the endpoints are ready so the health checks are expected to pass soon
+			return nil, &ProvisioningError{Reason: ReasonNoHealthyBackends, Err: err, RequeueAfter: healthCheckInterval}
+		}
+	}
+
+	if s.devDomain != nil {
+		err = s.devDomain.publish(ctx, s.kubeClient, service, status)
+		if err != nil {
+			return nil, err
+		}
+		status = hostnameStatus(status, s.devDomain.hostname(service), s.statusHostname)
+	}
 	return status, nil
 }
 
 func (s *Server) UpdateLoadBalancer(ctx context.Context, clusterName string, service *v1.Service, nodes []*v1.Node) error {
-	return proxyUpdateLoadBalancer(ctx, clusterName, service, nodes)
+	err := validateSharedIPKey(service)
+	if err != nil {
+		return err
+	}
+	err = validateAdoptedContainer(service)
+	if err != nil {
+		return err
+	}
+	unlock := s.locks.lock(loadBalancerName(clusterName, service))
+	defer unlock()
+	return s.updateLoadBalancer(ctx, clusterName, service, nodes)
+}
+
+// updateLoadBalancer applies the config of the Service, combined with the config of the
+// Services sharing its loadbalancer, the caller must hold the container lock.
+func (s *Server) updateLoadBalancer(ctx context.Context, clusterName string, service *v1.Service, nodes []*v1.Node) error {
+	ctx, _ = withContainer(ctx, loadBalancerName(clusterName, service))
+	services, err := s.sharedIPServices(ctx, service)
+	if err != nil {
+		return err
+	}
+	lbConfig, err := sharedConfig(service, services, zoneNodes(s.primaryZone(service), nodes))
+	if err != nil {
+		return err
+	}
+	if sharedIPKey(service) != "" {
+		s.lastNodes.Store(loadBalancerName(clusterName, service), nodes)
+	}
+	err = s.applyLoadBalancerConfig(ctx, clusterName, loadBalancerName(clusterName, service), services, lbConfig)
+	if err != nil {
+		return err
+	}
+	return s.updateZoneReplicas(ctx, clusterName, service, services, nodes)
+}
+
+// applyLoadBalancerConfig applies the config to the loadbalancer of the Services, and the
+// settings that have to be applied again if the loadbalancer is restarted
+func (s *Server) applyLoadBalancerConfig(ctx context.Context, clusterName string, name string, services []*v1.Service, lbConfig *proxyConfigData) error {
+	logger := klog.FromContext(ctx)
+	if s.tunnelManager != nil && config.DefaultConfig.TunnelNodePorts {
+		lbConfig = withNodePortListeners(lbConfig)
+	}
+	// the certificates must be in place before the config using them is validated
+	err := s.ensureTLSCertificates(ctx, name, lbConfig)
+	if err != nil {
+		return err
+	}
+	// the captures are a debugging aid, they do not block the loadbalancer
+	if err := ensureCapture(ctx, name, services); err != nil {
+		logger.Error(err, "Failed to capture the loadbalancer traffic")
+	}
+	_, healed := s.healed.LoadAndDelete(name)
+	updated, restarted, err := proxyUpdateLoadBalancer(ctx, name, lbConfig, s.configHashes)
+	if err != nil || (!updated && !healed) {
+		if healed {
+			s.healed.Store(name, true)
+		}
+		return err
+	}
+	restarted = restarted || healed
+	if restarted {
+		// the loadbalancer was restarted, the aliases and the workaround have to be applied again
+		err = ensureAdvertisement(ctx, clusterName, name)
+		if err != nil {
+			s.healed.Store(name, true)
+			return err
+		}
+		err = ensureUDPChecksumWorkaround(ctx, config.DefaultConfig.UDPChecksumWorkaround, clusterName, name, services)
+		if err != nil {
+			logger.Error(err, "UDP traffic may be dropped on the loadbalancer, use --udp-checksum-workaround=never to disable the workaround")
+		}
+		err = ensureFirewall(ctx, config.DefaultConfig.FirewallDenyByDefault, clusterName, name, lbConfig)
+		if err != nil {
+			s.healed.Store(name, true)
+			return err
+		}
+	}
+	// the faults are part of the config hash, they are only checked when the config changes
+	err = ensureFaults(ctx, clusterName, name, lbConfig, restarted)
+	if err != nil {
+		s.configHashes.invalidate(name)
+	}
+	return err
 }
 
 func (s *Server) EnsureLoadBalancerDeleted(ctx context.Context, clusterName string, service *v1.Service) error {
 	containerName := loadBalancerName(clusterName, service)
+	ctx, logger := withContainer(ctx, containerName)
+	unlock := s.locks.lock(containerName)
+	defer unlock()
+	if sharedIPKey(service) != "" && container.Exist(containerName) {
+		// the loadbalancer is kept while other Services share it, without the ports of the Service
+		services, err := s.sharedIPServices(ctx, service)
+		if err != nil {
+			return err
+		}
+		peers := []*v1.Service{}
+		for _, peer := range services {
+			if peer.Name != service.Name {
+				peers = append(peers, peer)
+			}
+		}
+		if len(peers) > 0 {
+			logger.V(2).Info("Removing Service from the shared loadbalancer", "remaining", len(peers))
+			nodes, err := s.sharedLoadBalancerNodes(ctx, containerName)
+			if err != nil {
+				return err
+			}
+			lbConfig, err := sharedConfig(nil, peers, nodes)
+			if err != nil {
+				return err
+			}
+			if s.devDomain != nil {
+				s.devDomain.unpublish(service)
+			}
+			return s.applyLoadBalancerConfig(ctx, clusterName, containerName, peers, lbConfig)
+		}
+	}
+	logger.V(2).Info("Deleting loadbalancer")
+	return s.deleteLoadBalancer(ctx, containerName, service)
+}
+
+// sharedLoadBalancerNodes returns the nodes of the last update of the shared loadbalancer,
+// or all the nodes of the cluster if it was not updated since the controller started, the
+// backends are fixed on the next update of the remaining Services.
+func (s *Server) sharedLoadBalancerNodes(ctx context.Context, containerName string) ([]*v1.Node, error) {
+	if nodes, ok := s.lastNodes.Load(containerName); ok {
+		return nodes.([]*v1.Node), nil
+	}
+	list, err := s.kubeClient.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	nodes := []*v1.Node{}
+	for i := range list.Items {
+		nodes = append(nodes, &list.Items[i])
+	}
+	return nodes, nil
+}
+
+// deleteLoadBalancer deletes the loadbalancer container, or releases it if it was adopted by
+// the Service, the caller must hold the container lock
+func (s *Server) deleteLoadBalancer(ctx context.Context, containerName string, service *v1.Service) error {
 	var err1, err2 error
+	if s.devDomain != nil {
+		s.devDomain.unpublish(service)
+	}
 	if s.tunnelManager != nil {
 		err1 = s.tunnelManager.removeTunnels(containerName)
 	}
-	err2 = container.Delete(containerName)
-	return errors.Join(err1, err2)
+	if adoptedContainer(service) == containerName {
+		err2 = releaseLoadBalancer(ctx, containerName)
+	} else {
+		err2 = deleteContainer(containerName)
+		if err2 == nil && container.Exist(containerName) {
+			err2 = fmt.Errorf("loadbalancer container %s still exists", containerName)
+		}
+	}
+	s.configHashes.forget(containerName)
+	s.lastNodes.Delete(containerName)
+	s.healed.Delete(containerName)
+	return errors.Join(err1, err2, s.deleteZoneReplicas(containerName, nil))
+}
+
+// serviceIPFamilies returns the IP families used by the Service
+func serviceIPFamilies(service *v1.Service) (ipv4 bool, ipv6 bool) {
+	for _, family := range service.Spec.IPFamilies {
+		if family == v1.IPv4Protocol {
+			ipv4 = true
+		}
+		if family == v1.IPv6Protocol {
+			ipv6 = true
+		}
+	}
+	return ipv4, ipv6
+}
+
+// IPFamilyError is returned when the Service requires IP families that are not
+// configured on the container network, the loadbalancer would not be able to listen
+// on the missing families.
+type IPFamilyError struct {
+	Network  string
+	Families []v1.IPFamily
+}
+
+func (e *IPFamilyError) Error() string {
+	return fmt.Sprintf("the Service requires the IP families %v that are not configured on the network %s", e.Families, e.Network)
+}
+
+// unsupportedIPFamilies returns the IP families of the Service that are not supported
+func unsupportedIPFamilies(service *v1.Service, ipv4 bool, ipv6 bool) []v1.IPFamily {
+	families := []v1.IPFamily{}
+	svcIPv4, svcIPv6 := serviceIPFamilies(service)
+	if svcIPv4 && !ipv4 {
+		families = append(families, v1.IPv4Protocol)
+	}
+	if svcIPv6 && !ipv6 {
+		families = append(families, v1.IPv6Protocol)
+	}
+	return families
+}
+
+// checkIPFamilies returns an IPFamilyError if the network does not support the Service IP families
+func checkIPFamilies(service *v1.Service, network string) error {
+	ipv4, ipv6, err := container.NetworkIPFamilies(network)
+	if err != nil {
+		// do not block the loadbalancer if the network can not be inspected
+		klog.InfoS("Unable to check the IP families of the network", "network", network, "err", err)
+		return nil
+	}
+	if families := unsupportedIPFamilies(service, ipv4, ipv6); len(families) > 0 {
+		return &IPFamilyError{Network: network, Families: families}
+	}
+	return nil
+}
+
+// listenerIPFamilies returns the IP families with listeners in the config
+func listenerIPFamilies(config *proxyConfigData) (ipv4 bool, ipv6 bool) {
+	if config == nil {
+		return false, false
+	}
+	for key := range config.ServicePorts {
+		if strings.HasPrefix(key, string(v1.IPv4Protocol)+"_") {
+			ipv4 = true
+		}
+		if strings.HasPrefix(key, string(v1.IPv6Protocol)+"_") {
+			ipv6 = true
+		}
+	}
+	return ipv4, ipv6
+}
+
+// withContainer returns the context and its logger with the loadbalancer container name
+func withContainer(ctx context.Context, name string) (context.Context, klog.Logger) {
+	logger := klog.FromContext(ctx).WithValues("container", name)
+	return klog.NewContext(ctx, logger), logger
 }
 
-// loadbalancer name is a unique name for the loadbalancer container
+// loadbalancer name is a unique name for the loadbalancer container, or the name of the
+// container adopted by the Service
 func loadBalancerName(clusterName string, service *v1.Service) string {
+	if adopted := adoptedContainer(service); adopted != "" && sharedIPKey(service) == "" {
+		return adopted
+	}
 	hash := sha256.Sum256([]byte(loadBalancerSimpleName(clusterName, service)))
 	encoded := base32.StdEncoding.EncodeToString(hash[:])
 	name := constants.ContainerPrefix + "-" + encoded[:40]
@@ -148,6 +681,9 @@ func loadBalancerName(clusterName string, service *v1.Service) string {
 }
 
 func loadBalancerSimpleName(clusterName string, service *v1.Service) string {
+	if key := sharedIPKey(service); key != "" {
+		return clusterName + "/" + service.Namespace + "/" + sharedIPNamePrefix + key
+	}
 	return clusterName + "/" + service.Namespace + "/" + service.Name
 }
 
@@ -161,15 +697,10 @@ func ServiceFromLoadBalancerSimpleName(s string) (clusterName string, service *v
 	return
 }
 
-// createLoadBalancer create a docker container with a loadbalancer
-func (s *Server) createLoadBalancer(clusterName string, service *v1.Service, image string) error {
-	name := loadBalancerName(clusterName, service)
-
-	networkName := constants.FixedNetworkName
-	if n := os.Getenv("KIND_EXPERIMENTAL_DOCKER_NETWORK"); n != "" {
-		networkName = n
-	}
-
+// createLoadBalancer create a docker container with a loadbalancer on the network, services
+// are the Services sharing the loadbalancer with the Service, including itself. The zone is
+// the zone of the proxy when the loadbalancer has one per zone.
+func (s *Server) createLoadBalancer(clusterName string, name string, zone string, service *v1.Service, services []*v1.Service, networkName string, image string) error {
 	args := []string{
 		"--detach", // run the container detached
 		"--tty",    // allocate a tty for entrypoint logs
@@ -177,41 +708,160 @@ func (s *Server) createLoadBalancer(clusterName string, service *v1.Service, ima
 		"--label", fmt.Sprintf("%s=%s", constants.NodeCCMLabelKey, clusterName),
 		// label the node with the load balancer name
 		"--label", fmt.Sprintf("%s=%s", constants.LoadBalancerNameLabelKey, loadBalancerSimpleName(clusterName, service)),
+		// label the node as provisioned with a transaction, only committed once verified
+		"--label", fmt.Sprintf("%s=true", constants.LoadBalancerTransactionLabelKey),
+		// label the node with the version of the state, so an interrupted provisioning is not
+		// mistaken for a container of a previous version
+		"--label", fmt.Sprintf("%s=%d", constants.LoadBalancerStateVersionLabelKey, stateVersion),
 		// user a user defined docker network so we get embedded DNS
 		"--net", networkName,
 		"--init=false",
 		"--hostname", name, // make hostname match container name
-		// label the node with the role ID
-		// running containers in a container requires privileged
-		// NOTE: we could try to replicate this with --cap-add, and use less
-		// privileges, but this flag also changes some mounts that are necessary
-		// including some ones docker would otherwise do by default.
-		// for now this is what we want. in the future we may revisit this.
-		"--privileged",
 		"--restart=on-failure:1",                    // to allow to change the configuration
 		"--sysctl=net.ipv4.ip_forward=1",            // allow ip forwarding
 		"--sysctl=net.ipv6.conf.all.disable_ipv6=0", // enable IPv6
 		"--sysctl=net.ipv6.conf.all.forwarding=1",   // allow ipv6 forwarding
 		"--sysctl=net.ipv4.conf.all.rp_filter=0",    // disable rp filter
 	}
+	if zone != "" {
+		// label the proxy with its zone
+		args = append(args, "--label", fmt.Sprintf("%s=%s", constants.LoadBalancerZoneLabelKey, zone))
+	}
+	// running containers in a container requires privileged
+	// NOTE: we could try to replicate this with --cap-add, and use less
+	// privileges, but this flag also changes some mounts that are necessary
+	// including some ones docker would otherwise do by default.
+	// for now this is what we want. in the future we may revisit this.
+	// The rootless runtimes do not allow privileged containers, envoy does not need it.
+	if !container.Rootless() {
+		args = append(args, "--privileged")
+	}
+	args = append(args, proxyLimitArgs()...)
 
+	ports := publishedPorts(services)
 	if s.tunnelManager != nil {
 		// Forward the Service Ports to the host so they are accessible on Mac and Windows
-		for _, port := range service.Spec.Ports {
+		for _, port := range ports {
 			if port.Protocol != v1.ProtocolTCP {
 				continue
 			}
 			args = append(args, fmt.Sprintf("--publish=%d/%s", port.Port, "TCP"))
 		}
+		for nodePort := range tunneledNodePorts(services) {
+			args = append(args, fmt.Sprintf("--publish=%s/%s", nodePort, "TCP"))
+		}
 		// Publish all ports in the host in random ports
 		args = append(args, "--publish-all")
+	} else if s.hostAddress != "" {
+		// Publish the Service Ports on the same ports of the container runtime host, or on
+		// free ports chosen by the container runtime
+		for _, port := range ports {
+			if port.Protocol != v1.ProtocolTCP && port.Protocol != v1.ProtocolUDP {
+				continue
+			}
+			if s.publishRandomPorts {
+				args = append(args, fmt.Sprintf("--publish=%d/%s", port.Port, strings.ToLower(string(port.Protocol))))
+				continue
+			}
+			args = append(args, fmt.Sprintf("--publish=%d:%d/%s", port.Port, port.Port, strings.ToLower(string(port.Protocol))))
+		}
+	}
+
+	// use the image variant of the runtime host architecture
+	platform, err := proxyPlatform()
+	if err != nil {
+		return provisioningError(ReasonImagePullFailed, err)
+	}
+	if platform != "" {
+		args = append(args, "--platform", platform)
+	}
+	err = ensureProxyImage(image, platform)
+	if err != nil {
+		return provisioningError(ReasonImagePullFailed, err)
 	}
 
+	ipamLock.Lock()
+	locked := true
+	defer func() {
+		if locked {
+			ipamLock.Unlock()
+		}
+	}()
+	// the recreated loadbalancers keep the addresses of the previous container, the replicas
+	// of the zonal loadbalancers have one address each and get new ones
+	var previousIPv4, previousIPv6 string
+	if zone == "" {
+		previousIPv4, previousIPv6 = previousAddresses(services)
+	}
+	ipv4, ipv6, err := allocate(networkName, previousIPv4, previousIPv6)
+	if err != nil {
+		return provisioningError(ReasonIPAllocationFailed, fmt.Errorf("failed to allocate the loadbalancer addresses: %w", err))
+	}
+	var pinnedArgs []string
+	if ipv4 == "" && ipv6 == "" {
+		// the container runtime assigns the addresses, the containers without allocated
+		// addresses are created in parallel, ex. on the initial sync
+		ipamLock.Unlock()
+		locked = false
+		pinnedIPv4, pinnedIPv6 := pinnedAddresses(networkName, previousIPv4, previousIPv6)
+		if pinnedIPv4 != "" {
+			pinnedArgs = append(pinnedArgs, "--ip", pinnedIPv4)
+		}
+		if pinnedIPv6 != "" {
+			pinnedArgs = append(pinnedArgs, "--ip6", pinnedIPv6)
+		}
+	}
+	created := false
+	defer func() {
+		if !created && (ipv4 != "" || ipv6 != "") {
+			if err := allocator.Release(ipv4, ipv6); err != nil {
+				klog.ErrorS(err, "Failed to release the loadbalancer addresses", "container", name, "ipv4", ipv4, "ipv6", ipv6)
+			}
+		}
+	}()
+	if label := allocatedLabel(ipv4, ipv6); label != "" {
+		args = append(args, "--label", fmt.Sprintf("%s=%s", constants.LoadBalancerAllocatedLabelKey, label))
+	}
+	if config.DefaultConfig.AdvertiseAddresses && (ipv4 != "" || ipv6 != "") {
+		// the addresses are added as aliases once the container is running
+		networkSubnets, err := container.NetworkSubnets(networkName)
+		if err != nil {
+			return provisioningError(ReasonIPAllocationFailed, err)
+		}
+		aliases, err := aliasAddresses(networkSubnets, ipv4, ipv6)
+		if err != nil {
+			return provisioningError(ReasonIPAllocationFailed, err)
+		}
+		args = append(args, "--label", fmt.Sprintf("%s=%s", constants.LoadBalancerAddressesLabelKey, strings.Join(aliases, ",")))
+		args = append(args, advertiseSysctls...)
+	} else {
+		if ipv4 != "" {
+			args = append(args, "--ip", ipv4)
+		}
+		if ipv6 != "" {
+			args = append(args, "--ip6", ipv6)
+		}
+	}
+
+	if len(pinnedArgs) > 0 {
+		err = container.Create(name, append(append(append([]string{}, args...), pinnedArgs...), image), proxyCommand()...)
+		if err == nil {
+			created = true
+			return nil
+		}
+		// the runtime only assigns static addresses on the networks with user configured
+		// subnets, the address may also have been taken in the meantime
+		klog.InfoS("Failed to create the loadbalancer with its previous addresses, the container runtime assigns new ones", "container", name, "addresses", pinnedArgs, "err", err)
+		if err := container.Delete(name); err != nil {
+			return provisioningError(ReasonContainerFailed, fmt.Errorf("failed to delete container %s: %w", name, err))
+		}
+	}
 	args = append(args, image)
-	err := container.Create(name, args)
+	err = container.Create(name, args, proxyCommand()...)
 	if err != nil {
-		return fmt.Errorf("failed to create continers %s %v: %w", name, args, err)
+		return provisioningError(ReasonContainerFailed, fmt.Errorf("failed to create continers %s %v: %w", name, args, err))
 	}
+	created = true
 
 	return nil
 }
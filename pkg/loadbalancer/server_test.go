@@ -1,11 +1,20 @@
 package loadbalancer
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"slices"
+	"strings"
 	"testing"
 
 	v1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/ptr"
 	"sigs.k8s.io/cloud-provider-kind/pkg/constants"
+	"sigs.k8s.io/cloud-provider-kind/pkg/container"
 )
 
 func TestLoadBalancerName(t *testing.T) {
@@ -37,3 +46,309 @@ func TestLoadBalancerName(t *testing.T) {
 		})
 	}
 }
+
+func Test_needsUDPChecksumWorkaround(t *testing.T) {
+	tcp := &v1.Service{Spec: v1.ServiceSpec{Ports: []v1.ServicePort{{Port: 80, Protocol: v1.ProtocolTCP}}}}
+	udp := &v1.Service{Spec: v1.ServiceSpec{Ports: []v1.ServicePort{{Port: 80, Protocol: v1.ProtocolTCP}, {Port: 53, Protocol: v1.ProtocolUDP}}}}
+	tests := []struct {
+		mode    string
+		service *v1.Service
+		want    bool
+	}{
+		{mode: UDPChecksumWorkaroundAuto, service: tcp, want: false},
+		{mode: UDPChecksumWorkaroundAuto, service: udp, want: true},
+		{mode: UDPChecksumWorkaroundAlways, service: tcp, want: true},
+		{mode: UDPChecksumWorkaroundNever, service: udp, want: false},
+	}
+	for _, tt := range tests {
+		if got := needsUDPChecksumWorkaround(tt.mode, tt.service); got != tt.want {
+			t.Errorf("needsUDPChecksumWorkaround(%s, %v) = %v, want %v", tt.mode, tt.service.Spec.Ports, got, tt.want)
+		}
+	}
+}
+
+func Test_firewallScript(t *testing.T) {
+	config := &proxyConfigData{
+		ServicePorts: map[string]servicePort{
+			"IPv4_80_TCP": {Listener: endpoint{Address: mustParseAddress("0.0.0.0"), Port: 80, Protocol: string(v1.ProtocolTCP)}},
+			"IPv4_53_UDP": {Listener: endpoint{Address: mustParseAddress("0.0.0.0"), Port: 53, Protocol: string(v1.ProtocolUDP)}},
+		},
+	}
+	want := `iptables-restore --noflush <<EOF
+*filter
+:KIND-LB-FIREWALL - [0:0]
+-A KIND-LB-FIREWALL -i lo -j ACCEPT
+-A KIND-LB-FIREWALL -m conntrack --ctstate ESTABLISHED,RELATED -j ACCEPT
+-A KIND-LB-FIREWALL -p tcp --dport 80 -j ACCEPT
+-A KIND-LB-FIREWALL -p udp --dport 53 -j ACCEPT
+-A KIND-LB-FIREWALL -j DROP
+COMMIT
+EOF
+iptables -C INPUT -j KIND-LB-FIREWALL 2>/dev/null || iptables -I INPUT -j KIND-LB-FIREWALL
+ip6tables-restore --noflush <<EOF
+*filter
+:KIND-LB-FIREWALL - [0:0]
+-A KIND-LB-FIREWALL -i lo -j ACCEPT
+-A KIND-LB-FIREWALL -m conntrack --ctstate ESTABLISHED,RELATED -j ACCEPT
+-A KIND-LB-FIREWALL -p ipv6-icmp -j ACCEPT
+-A KIND-LB-FIREWALL -j DROP
+COMMIT
+EOF
+ip6tables -C INPUT -j KIND-LB-FIREWALL 2>/dev/null || ip6tables -I INPUT -j KIND-LB-FIREWALL
+`
+	if got := firewallScript(config); got != want {
+		t.Errorf("firewallScript() = %s\nwant %s", got, want)
+	}
+}
+
+func Test_hostnameStatus(t *testing.T) {
+	ports := []v1.PortStatus{{Port: 80, Protocol: v1.ProtocolTCP}}
+	status := &v1.LoadBalancerStatus{Ingress: []v1.LoadBalancerIngress{
+		{IP: "172.18.0.5", Ports: ports},
+		{IP: "fc00:f853:ccd:e793::5", Ports: ports},
+	}}
+	hostname := "test.default.lb.kind.local"
+	tests := []struct {
+		mode string
+		want *v1.LoadBalancerStatus
+	}{
+		{mode: StatusHostnameNone, want: status},
+		{mode: StatusHostnameAlongside, want: &v1.LoadBalancerStatus{Ingress: []v1.LoadBalancerIngress{
+			{IP: "172.18.0.5", Ports: ports},
+			{IP: "fc00:f853:ccd:e793::5", Ports: ports},
+			{Hostname: hostname, Ports: ports},
+		}}},
+		{mode: StatusHostnameOnly, want: &v1.LoadBalancerStatus{Ingress: []v1.LoadBalancerIngress{
+			{Hostname: hostname, Ports: ports},
+		}}},
+	}
+	for _, tt := range tests {
+		if got := hostnameStatus(status, hostname, tt.mode); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("hostnameStatus(%s) = %v, want %v", tt.mode, got, tt.want)
+		}
+	}
+}
+
+func Test_portStatuses(t *testing.T) {
+	service := &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default", Annotations: map[string]string{
+			constants.ListenAddressesAnnotation: "53=0.0.0.0",
+		}},
+		Spec: v1.ServiceSpec{
+			Type:       v1.ServiceTypeLoadBalancer,
+			IPFamilies: []v1.IPFamily{v1.IPv4Protocol, v1.IPv6Protocol},
+			Ports: []v1.ServicePort{
+				{Name: "http", Port: 80, Protocol: v1.ProtocolTCP},
+				{Name: "dns", Port: 53, Protocol: v1.ProtocolUDP},
+				{Name: "sctp", Port: 9999, Protocol: v1.ProtocolSCTP},
+			},
+		},
+	}
+	lbConfig := generateConfig(service, nil)
+	tests := []struct {
+		name      string
+		family    v1.IPFamily
+		hostPorts map[string]int
+		want      []v1.PortStatus
+	}{
+		{
+			name:   "IPv4",
+			family: v1.IPv4Protocol,
+			want: []v1.PortStatus{
+				{Port: 80, Protocol: v1.ProtocolTCP},
+				{Port: 53, Protocol: v1.ProtocolUDP},
+				{Port: 9999, Protocol: v1.ProtocolSCTP, Error: ptr.To(PortErrorUnsupportedProtocol)},
+			},
+		},
+		{
+			name:   "IPv6 without the listener of the restricted port",
+			family: v1.IPv6Protocol,
+			want: []v1.PortStatus{
+				{Port: 80, Protocol: v1.ProtocolTCP},
+				{Port: 53, Protocol: v1.ProtocolUDP, Error: ptr.To(PortErrorNotListening)},
+				{Port: 9999, Protocol: v1.ProtocolSCTP, Error: ptr.To(PortErrorUnsupportedProtocol)},
+			},
+		},
+		{
+			name:      "random host ports",
+			family:    v1.IPv4Protocol,
+			hostPorts: map[string]int{"80/tcp": 32768},
+			want: []v1.PortStatus{
+				{Port: 32768, Protocol: v1.ProtocolTCP},
+				{Port: 53, Protocol: v1.ProtocolUDP, Error: ptr.To(PortErrorNotPublished)},
+				{Port: 9999, Protocol: v1.ProtocolSCTP, Error: ptr.To(PortErrorUnsupportedProtocol)},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := portStatuses(service, lbConfig, tt.family, tt.hostPorts); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("portStatuses() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_unsupportedIPFamilies(t *testing.T) {
+	makeFamilyService := func(families ...v1.IPFamily) *v1.Service {
+		return &v1.Service{Spec: v1.ServiceSpec{IPFamilies: families}}
+	}
+	tests := []struct {
+		name    string
+		service *v1.Service
+		ipv4    bool
+		ipv6    bool
+		want    []v1.IPFamily
+	}{
+		{name: "ipv4 on ipv4 network", service: makeFamilyService(v1.IPv4Protocol), ipv4: true, want: []v1.IPFamily{}},
+		{name: "ipv6 on ipv4 network", service: makeFamilyService(v1.IPv6Protocol), ipv4: true, want: []v1.IPFamily{v1.IPv6Protocol}},
+		{name: "dual stack on ipv4 network", service: makeFamilyService(v1.IPv4Protocol, v1.IPv6Protocol), ipv4: true, want: []v1.IPFamily{v1.IPv6Protocol}},
+		{name: "dual stack on dual stack network", service: makeFamilyService(v1.IPv6Protocol, v1.IPv4Protocol), ipv4: true, ipv6: true, want: []v1.IPFamily{}},
+		{name: "ipv4 on ipv6 network", service: makeFamilyService(v1.IPv4Protocol), ipv6: true, want: []v1.IPFamily{v1.IPv4Protocol}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := unsupportedIPFamilies(tt.service, tt.ipv4, tt.ipv6); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("unsupportedIPFamilies() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// fakeListeners reports the ports as listening in the /proc/net files of the loadbalancers
+func fakeListeners(ports ...int) func(name string, command []string, stdout io.Writer) (bool, error) {
+	return func(name string, command []string, stdout io.Writer) (bool, error) {
+		if len(command) != 2 || command[0] != "cat" || command[1] != "/proc/net/tcp" {
+			return true, fmt.Errorf("unsupported command %v", command)
+		}
+		fmt.Fprintln(stdout, "  sl  local_address rem_address   st")
+		for i, port := range ports {
+			fmt.Fprintf(stdout, "   %d: 00000000:%04X 00000000:0000 0A\n", i, port)
+		}
+		return true, nil
+	}
+}
+
+func TestEnsureLoadBalancerFake(t *testing.T) {
+	fake := container.NewFake()
+	defer container.SetRuntime(fake)()
+	fake.AddContainer(&container.FakeContainer{
+		Name:     "kind-control-plane",
+		Image:    "kindest/node:v1.30.0",
+		Labels:   map[string]string{constants.KindClusterLabelKey: "kind"},
+		Networks: []string{"kind"},
+		IPv4:     "172.18.0.2",
+		Running:  true,
+	})
+	fake.ExecHook = fakeListeners(80)
+
+	s := NewServer(nil, nil, nil).(*Server)
+	s.tunnelManager = nil
+	s.hostAddress = ""
+	s.publishUnready = true
+	service := &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default"},
+		Spec: v1.ServiceSpec{
+			Type:       v1.ServiceTypeLoadBalancer,
+			IPFamilies: []v1.IPFamily{v1.IPv4Protocol},
+			Ports:      []v1.ServicePort{{Port: 80, NodePort: 30080, Protocol: v1.ProtocolTCP}},
+		},
+	}
+	nodes := []*v1.Node{makeNode("kind-control-plane", "172.18.0.2")}
+	name := loadBalancerName("kind", service)
+
+	status, err := s.EnsureLoadBalancer(context.Background(), "kind", service, nodes)
+	if err != nil {
+		t.Fatalf("EnsureLoadBalancer() unexpected error: %v", err)
+	}
+	lb := fake.Container(name)
+	if lb == nil {
+		t.Fatalf("loadbalancer container %s not created", name)
+	}
+	if lb.Image != proxyImage || lb.Labels[constants.NodeCCMLabelKey] != "kind" {
+		t.Errorf("loadbalancer container created with image %s and labels %v", lb.Image, lb.Labels)
+	}
+	if !slices.Contains(lb.Args, "linux/amd64") {
+		t.Errorf("loadbalancer container created without the platform of the runtime: %v", lb.Args)
+	}
+	if !strings.Contains(lb.Files[proxyConfigPath], "listener_IPv4_80_TCP") || !strings.Contains(lb.Files[proxyClustersPath], "172.18.0.2") {
+		t.Errorf("loadbalancer config not applied:\n%s\n%s", lb.Files[proxyConfigPath], lb.Files[proxyClustersPath])
+	}
+	if _, ok := lb.Files[proxyCommitPath]; !ok {
+		t.Errorf("loadbalancer not committed")
+	}
+	want := []v1.LoadBalancerIngress{{IP: lb.IPv4, IPMode: ptr.To(v1.LoadBalancerIPModeProxy), Ports: []v1.PortStatus{{Port: 80, Protocol: v1.ProtocolTCP}}}}
+	if lb.IPv4 == "" || lb.IPv4 == "172.18.0.2" || !reflect.DeepEqual(status.Ingress, want) {
+		t.Errorf("EnsureLoadBalancer() status = %+v, want %+v", status.Ingress, want)
+	}
+
+	// the config did not change, the loadbalancer is not restarted
+	restarts := lb.Restarts
+	_, err = s.EnsureLoadBalancer(context.Background(), "kind", service, nodes)
+	if err != nil {
+		t.Fatalf("EnsureLoadBalancer() unexpected error: %v", err)
+	}
+	if got := fake.Container(name).Restarts; got != restarts {
+		t.Errorf("loadbalancer restarted %d times, want %d", got, restarts)
+	}
+
+	// a new node only updates the clusters, without restarting the loadbalancer
+	nodes = append(nodes, makeNode("kind-worker", "172.18.0.4"))
+	err = s.UpdateLoadBalancer(context.Background(), "kind", service, nodes)
+	if err != nil {
+		t.Fatalf("UpdateLoadBalancer() unexpected error: %v", err)
+	}
+	lb = fake.Container(name)
+	if lb.Restarts != restarts || !strings.Contains(lb.Files[proxyClustersPath], "172.18.0.4") {
+		t.Errorf("UpdateLoadBalancer() restarts = %d, clusters:\n%s", lb.Restarts, lb.Files[proxyClustersPath])
+	}
+
+	// the health check node port is only in the clusters, changing it does not restart the
+	// loadbalancer either
+	for _, hcPort := range []int32{32000, 32001} {
+		service.Spec.ExternalTrafficPolicy = v1.ServiceExternalTrafficPolicyLocal
+		service.Spec.HealthCheckNodePort = hcPort
+		err = s.UpdateLoadBalancer(context.Background(), "kind", service, nodes)
+		if err != nil {
+			t.Fatalf("UpdateLoadBalancer() unexpected error: %v", err)
+		}
+		lb = fake.Container(name)
+		if lb.Restarts != restarts || !strings.Contains(lb.Files[proxyClustersPath], fmt.Sprintf("port_value: %d", hcPort)) {
+			t.Errorf("UpdateLoadBalancer() with health check node port %d restarts = %d, clusters:\n%s", hcPort, lb.Restarts, lb.Files[proxyClustersPath])
+		}
+	}
+
+	err = s.EnsureLoadBalancerDeleted(context.Background(), "kind", service)
+	if err != nil {
+		t.Fatalf("EnsureLoadBalancerDeleted() unexpected error: %v", err)
+	}
+	if fake.Container(name) != nil {
+		t.Errorf("loadbalancer container %s not deleted", name)
+	}
+}
+
+func TestEnsureLoadBalancerFakeImagePullFailed(t *testing.T) {
+	fake := container.NewFake()
+	defer container.SetRuntime(fake)()
+	fake.PullError = fmt.Errorf("registry unavailable")
+
+	s := NewServer(nil, nil, nil).(*Server)
+	s.tunnelManager = nil
+	s.hostAddress = ""
+	service := &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default"},
+		Spec: v1.ServiceSpec{
+			Type:       v1.ServiceTypeLoadBalancer,
+			IPFamilies: []v1.IPFamily{v1.IPv4Protocol},
+			Ports:      []v1.ServicePort{{Port: 80, NodePort: 30080, Protocol: v1.ProtocolTCP}},
+		},
+	}
+	_, err := s.EnsureLoadBalancer(context.Background(), "kind", service, nil)
+	var provisioningErr *ProvisioningError
+	if !errors.As(err, &provisioningErr) || provisioningErr.Reason != ReasonImagePullFailed {
+		t.Fatalf("EnsureLoadBalancer() error = %v, want reason %s", err, ReasonImagePullFailed)
+	}
+	if fake.Container(loadBalancerName("kind", service)) != nil {
+		t.Errorf("loadbalancer container created")
+	}
+}
@@ -0,0 +1,178 @@
+package loadbalancer
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/klog/v2"
+
+	"sigs.k8s.io/cloud-provider-kind/pkg/constants"
+)
+
+// The Services of the same namespace annotated with the same shared IP key use a single
+// loadbalancer container, and so the same IP, as long as their ports do not overlap.
+// The loadbalancer is named after the key instead of the Service, using a name that is
+// not a valid Service name so it does not collide with the loadbalancers of the Services.
+const sharedIPNamePrefix = "shared-ip:"
+
+// sharedIPKey returns the key of the Services sharing the loadbalancer, or empty if the
+// Service has a dedicated loadbalancer
+func sharedIPKey(service *v1.Service) string {
+	return strings.TrimSpace(service.Annotations[constants.AllowSharedIPAnnotation])
+}
+
+// validateSharedIPKey returns an error if the key can not be used to name the loadbalancer
+func validateSharedIPKey(service *v1.Service) error {
+	key := sharedIPKey(service)
+	if key == "" {
+		return nil
+	}
+	if errs := validation.IsDNS1123Label(key); len(errs) > 0 {
+		return fmt.Errorf("invalid %s annotation %q: %s", constants.AllowSharedIPAnnotation, key, strings.Join(errs, ", "))
+	}
+	return nil
+}
+
+// wantsSharedIP returns true if the Service is one of the Services sharing the loadbalancer
+// with the key, it must match the Services the service controller reconciles.
+func wantsSharedIP(service *v1.Service, key string) bool {
+	return service.DeletionTimestamp == nil &&
+		service.Spec.Type == v1.ServiceTypeLoadBalancer &&
+		service.Spec.LoadBalancerClass == nil &&
+		sharedIPKey(service) == key
+}
+
+// sharedIPServices returns the Services sharing the loadbalancer of the Service, including
+// the Service itself unless it is being deleted, sorted by age.
+func (s *Server) sharedIPServices(ctx context.Context, service *v1.Service) ([]*v1.Service, error) {
+	if sharedIPKey(service) == "" {
+		return []*v1.Service{service}, nil
+	}
+	list, err := s.kubeClient.CoreV1().Services(service.Namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list the Services sharing the loadbalancer: %w", err)
+	}
+	services := []*v1.Service{}
+	for i := range list.Items {
+		services = append(services, &list.Items[i])
+	}
+	return sharedIPGroup(service, services), nil
+}
+
+// sharedIPGroup returns the Services of the list sharing the loadbalancer of the Service,
+// the Service is used instead of its copy in the list, that may be outdated.
+func sharedIPGroup(service *v1.Service, services []*v1.Service) []*v1.Service {
+	key := sharedIPKey(service)
+	if key == "" {
+		return []*v1.Service{service}
+	}
+	group := []*v1.Service{}
+	if wantsSharedIP(service, key) {
+		group = append(group, service)
+	}
+	for _, peer := range services {
+		if peer.Namespace != service.Namespace || peer.Name == service.Name || !wantsSharedIP(peer, key) {
+			continue
+		}
+		group = append(group, peer)
+	}
+	sortServicesByAge(group)
+	return group
+}
+
+// sortServicesByAge sorts the Services by creation timestamp and name, the older Services
+// win the ports in conflict.
+func sortServicesByAge(services []*v1.Service) {
+	sort.SliceStable(services, func(i, j int) bool {
+		if !services[i].CreationTimestamp.Equal(&services[j].CreationTimestamp) {
+			return services[i].CreationTimestamp.Before(&services[j].CreationTimestamp)
+		}
+		return services[i].Name < services[j].Name
+	})
+}
+
+// sharedConfig combines the config of the Services sharing the loadbalancer, the Services
+// must be sorted by age. A Service with ports already used by an older Service, or that
+// requires different health checks or session affinity, is left out of the config. It
+// returns an error if the Service left out is the one being reconciled.
+func sharedConfig(service *v1.Service, services []*v1.Service, nodes []*v1.Node) (*proxyConfigData, error) {
+	var combined *proxyConfigData
+	owners := map[string]*v1.Service{}
+	for _, peer := range services {
+		config := generateConfig(peer, nodes)
+		if combined == nil {
+			combined = &proxyConfigData{
+				HealthCheckPort: config.HealthCheckPort,
+				SessionAffinity: config.SessionAffinity,
+				MaxHeapSize:     config.MaxHeapSize,
+				BufferLimit:     config.BufferLimit,
+				ServicePorts:    map[string]servicePort{},
+			}
+		}
+		err := sharedConfigConflict(combined, owners, config)
+		if err != nil {
+			if service != nil && peer.Namespace == service.Namespace && peer.Name == service.Name {
+				return nil, fmt.Errorf("can not share the loadbalancer %s: %w", sharedIPKey(service), err)
+			}
+			klog.InfoS("Service left out of the shared loadbalancer", "service", klog.KObj(peer), "key", sharedIPKey(peer), "err", err)
+			continue
+		}
+		for key, servicePort := range config.ServicePorts {
+			combined.ServicePorts[key] = servicePort
+			owners[key] = peer
+		}
+	}
+	return combined, nil
+}
+
+// sharedConfigConflict returns an error if the config can not be added to the combined config
+func sharedConfigConflict(combined *proxyConfigData, owners map[string]*v1.Service, config *proxyConfigData) error {
+	if config.HealthCheckPort != combined.HealthCheckPort {
+		return fmt.Errorf("health check port %d does not match %d, the Services with externalTrafficPolicy Local can not share the loadbalancer", config.HealthCheckPort, combined.HealthCheckPort)
+	}
+	if config.SessionAffinity != combined.SessionAffinity {
+		return fmt.Errorf("session affinity %q does not match %q", config.SessionAffinity, combined.SessionAffinity)
+	}
+	keys := []string{}
+	for key := range config.ServicePorts {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if owner, ok := owners[key]; ok {
+			servicePort := config.ServicePorts[key]
+			return provisioningError(ReasonPortConflict, fmt.Errorf("port %d/%s is already used by Service %s", servicePort.Listener.Port, servicePort.Listener.Protocol, klog.KObj(owner)))
+		}
+	}
+	return nil
+}
+
+// containerLocks serializes the operations on the same loadbalancer container, the
+// Services sharing a loadbalancer are reconciled in parallel.
+type containerLocks struct {
+	mu    sync.Mutex
+	locks map[string]*sync.Mutex
+}
+
+func newContainerLocks() *containerLocks {
+	return &containerLocks{locks: map[string]*sync.Mutex{}}
+}
+
+// lock locks the container and returns the function to unlock it
+func (c *containerLocks) lock(name string) func() {
+	c.mu.Lock()
+	l, ok := c.locks[name]
+	if !ok {
+		l = &sync.Mutex{}
+		c.locks[name] = l
+	}
+	c.mu.Unlock()
+	l.Lock()
+	return l.Unlock
+}
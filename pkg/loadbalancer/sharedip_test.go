@@ -0,0 +1,165 @@
+package loadbalancer
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/ptr"
+
+	"sigs.k8s.io/cloud-provider-kind/pkg/constants"
+)
+
+func makeSharedService(name string, key string, age time.Duration, ports ...v1.ServicePort) *v1.Service {
+	return &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              name,
+			Namespace:         "default",
+			CreationTimestamp: metav1.NewTime(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC).Add(-age)),
+			Annotations:       map[string]string{constants.AllowSharedIPAnnotation: key},
+		},
+		Spec: v1.ServiceSpec{
+			Type:                  v1.ServiceTypeLoadBalancer,
+			ExternalTrafficPolicy: v1.ServiceExternalTrafficPolicyCluster,
+			IPFamilies:            []v1.IPFamily{v1.IPv4Protocol},
+			Ports:                 ports,
+		},
+	}
+}
+
+func Test_sharedIPGroup(t *testing.T) {
+	older := makeSharedService("older", "web", time.Hour, v1.ServicePort{Port: 80, Protocol: v1.ProtocolTCP})
+	newer := makeSharedService("newer", "web", time.Minute, v1.ServicePort{Port: 443, Protocol: v1.ProtocolTCP})
+	other := makeSharedService("other", "dns", time.Minute, v1.ServicePort{Port: 53, Protocol: v1.ProtocolUDP})
+	otherNamespace := makeSharedService("remote", "web", time.Minute, v1.ServicePort{Port: 8080, Protocol: v1.ProtocolTCP})
+	otherNamespace.Namespace = "remote"
+	withClass := makeSharedService("class", "web", time.Minute, v1.ServicePort{Port: 8081, Protocol: v1.ProtocolTCP})
+	withClass.Spec.LoadBalancerClass = ptr.To("other")
+	deleting := makeSharedService("deleting", "web", time.Minute, v1.ServicePort{Port: 8082, Protocol: v1.ProtocolTCP})
+	deleting.DeletionTimestamp = ptr.To(metav1.Now())
+	services := []*v1.Service{newer, other, older, otherNamespace, withClass, deleting}
+
+	got := sharedIPGroup(newer, services)
+	want := []*v1.Service{older, newer}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("sharedIPGroup() = %v, want %v", serviceNames(got), serviceNames(want))
+	}
+	// the Service being deleted is not part of the group anymore
+	got = sharedIPGroup(deleting, services)
+	want = []*v1.Service{older, newer}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("sharedIPGroup() = %v, want %v", serviceNames(got), serviceNames(want))
+	}
+	dedicated := makeService("dedicated")
+	got = sharedIPGroup(dedicated, services)
+	want = []*v1.Service{dedicated}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("sharedIPGroup() = %v, want %v", serviceNames(got), serviceNames(want))
+	}
+}
+
+func serviceNames(services []*v1.Service) []string {
+	names := []string{}
+	for _, service := range services {
+		names = append(names, service.Name)
+	}
+	return names
+}
+
+func Test_sharedConfig(t *testing.T) {
+	nodes := []*v1.Node{makeNode("a", "10.0.0.1")}
+	http := makeSharedService("http", "web", time.Hour, v1.ServicePort{Port: 80, NodePort: 30080, Protocol: v1.ProtocolTCP})
+	https := makeSharedService("https", "web", 2*time.Minute, v1.ServicePort{Port: 443, NodePort: 30443, Protocol: v1.ProtocolTCP})
+	conflict := makeSharedService("conflict", "web", time.Minute, v1.ServicePort{Port: 80, NodePort: 31080, Protocol: v1.ProtocolTCP})
+	quic := makeSharedService("quic", "web", time.Minute, v1.ServicePort{Port: 443, NodePort: 31443, Protocol: v1.ProtocolUDP})
+	local := makeSharedService("local", "web", time.Minute, v1.ServicePort{Port: 8080, NodePort: 31081, Protocol: v1.ProtocolTCP})
+	local.Spec.ExternalTrafficPolicy = v1.ServiceExternalTrafficPolicyLocal
+	local.Spec.HealthCheckNodePort = 32000
+
+	tests := []struct {
+		name     string
+		service  *v1.Service
+		services []*v1.Service
+		wantKeys []string
+		wantErr  string
+	}{
+		{
+			name:     "non overlapping ports",
+			service:  https,
+			services: []*v1.Service{http, https},
+			wantKeys: []string{"IPv4_443_TCP", "IPv4_80_TCP"},
+		},
+		{
+			name:     "newer Service with port in conflict",
+			service:  conflict,
+			services: []*v1.Service{http, https, conflict},
+			wantErr:  "port 80/TCP is already used by Service default/http",
+		},
+		{
+			name:     "older Service keeps the ports in conflict",
+			service:  http,
+			services: []*v1.Service{http, https, conflict},
+			wantKeys: []string{"IPv4_443_TCP", "IPv4_80_TCP"},
+		},
+		{
+			name:     "same port with another protocol",
+			service:  quic,
+			services: []*v1.Service{https, quic},
+			wantKeys: []string{"IPv4_443_TCP", "IPv4_443_UDP"},
+		},
+		{
+			name:     "different health checks",
+			service:  local,
+			services: []*v1.Service{http, local},
+			wantErr:  "externalTrafficPolicy Local",
+		},
+		{
+			name:     "Service removed",
+			service:  nil,
+			services: []*v1.Service{https},
+			wantKeys: []string{"IPv4_443_TCP"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := sharedConfig(tt.service, tt.services, nodes)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("sharedConfig() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("sharedConfig() unexpected error: %v", err)
+			}
+			keys := []string{}
+			for key := range got.ServicePorts {
+				keys = append(keys, key)
+			}
+			sort.Strings(keys)
+			if !reflect.DeepEqual(keys, tt.wantKeys) {
+				t.Errorf("sharedConfig() ports = %v, want %v", keys, tt.wantKeys)
+			}
+		})
+	}
+}
+
+func Test_loadBalancerSimpleNameSharedIP(t *testing.T) {
+	a := makeSharedService("a", "web", 0)
+	b := makeSharedService("b", "web", 0)
+	if loadBalancerName("kind", a) != loadBalancerName("kind", b) {
+		t.Errorf("Services with the same key must share the loadbalancer")
+	}
+	if loadBalancerName("kind", a) == loadBalancerName("kind", makeSharedService("a", "", 0)) {
+		t.Errorf("Services without key must have a dedicated loadbalancer")
+	}
+	// the loadbalancer placeholder recovered from the container label has the same name
+	clusterName, placeholder := ServiceFromLoadBalancerSimpleName(loadBalancerSimpleName("kind", a))
+	if loadBalancerName(clusterName, placeholder) != loadBalancerName("kind", a) {
+		t.Errorf("placeholder %s does not match the shared loadbalancer", placeholder.Name)
+	}
+}
@@ -0,0 +1,114 @@
+package loadbalancer
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/yaml"
+
+	"sigs.k8s.io/cloud-provider-kind/pkg/constants"
+)
+
+// clusterOptionRegexp matches the names of the envoy cluster fields
+var clusterOptionRegexp = regexp.MustCompile(`^[a-z][a-z0-9_]*$`)
+
+// generatedClusterOptions are the cluster fields set by clustersConfig, they are
+// configured with their own annotations
+var generatedClusterOptions = map[string]bool{
+	"name":                             true,
+	"connect_timeout":                  true,
+	"circuit_breakers":                 true,
+	"type":                             true,
+	"dns_lookup_family":                true,
+	"lb_policy":                        true,
+	"common_lb_config":                 true,
+	"round_robin_lb_config":            true,
+	"health_checks":                    true,
+	"typed_extension_protocol_options": true,
+	"load_assignment":                  true,
+}
+
+// parseListenerFilters returns the extra listener filters of the Service encoded as JSON,
+// so they are rendered in one line of the YAML config, or nil if the annotation is not set
+// or invalid. Each filter must have a name.
+func parseListenerFilters(service *v1.Service) []string {
+	value, ok := service.Annotations[constants.ListenerFiltersAnnotation]
+	if !ok {
+		return nil
+	}
+	filters, err := listenerFilters(value)
+	if err != nil {
+		klog.InfoS("Ignoring invalid listener filters", "service", klog.KObj(service), "annotation", constants.ListenerFiltersAnnotation, "err", err)
+		return nil
+	}
+	return filters
+}
+
+func listenerFilters(value string) ([]string, error) {
+	data, err := yaml.YAMLToJSON([]byte(value))
+	if err != nil {
+		return nil, err
+	}
+	filters := []map[string]interface{}{}
+	if err := json.Unmarshal(data, &filters); err != nil {
+		return nil, fmt.Errorf("expected a list of filters: %w", err)
+	}
+	encoded := []string{}
+	for i, filter := range filters {
+		if name, ok := filter["name"].(string); !ok || name == "" {
+			return nil, fmt.Errorf("filter %d does not have a name", i)
+		}
+		b, err := json.Marshal(filter)
+		if err != nil {
+			return nil, err
+		}
+		encoded = append(encoded, string(b))
+	}
+	return encoded, nil
+}
+
+// parseClusterOptions returns the extra cluster fields of the Service with their values
+// encoded as JSON, or nil if the annotation is not set or invalid
+func parseClusterOptions(service *v1.Service) map[string]string {
+	value, ok := service.Annotations[constants.ClusterOptionsAnnotation]
+	if !ok {
+		return nil
+	}
+	options, err := clusterOptions(value)
+	if err != nil {
+		klog.InfoS("Ignoring invalid cluster options", "service", klog.KObj(service), "annotation", constants.ClusterOptionsAnnotation, "err", err)
+		return nil
+	}
+	return options
+}
+
+func clusterOptions(value string) (map[string]string, error) {
+	data, err := yaml.YAMLToJSON([]byte(value))
+	if err != nil {
+		return nil, err
+	}
+	fields := map[string]json.RawMessage{}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, fmt.Errorf("expected a map of cluster fields: %w", err)
+	}
+	names := []string{}
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	options := map[string]string{}
+	for _, name := range names {
+		if !clusterOptionRegexp.MatchString(name) {
+			return nil, fmt.Errorf("invalid cluster field %q", name)
+		}
+		if generatedClusterOptions[name] {
+			return nil, fmt.Errorf("cluster field %q is generated by cloud-provider-kind", name)
+		}
+		options[name] = string(fields[name])
+	}
+	return options, nil
+}
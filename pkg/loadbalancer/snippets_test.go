@@ -0,0 +1,142 @@
+package loadbalancer
+
+import (
+	"reflect"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/yaml"
+
+	"sigs.k8s.io/cloud-provider-kind/pkg/constants"
+)
+
+func Test_listenerFilters(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		want    []string
+		wantErr bool
+	}{
+		{
+			name: "rate limit",
+			value: `
+- name: envoy.filters.network.local_ratelimit
+  typed_config:
+    "@type": type.googleapis.com/envoy.extensions.filters.network.local_ratelimit.v3.LocalRateLimit
+    stat_prefix: rate_limit
+    token_bucket: {max_tokens: 10, fill_interval: 1s}
+`,
+			want: []string{`{"name":"envoy.filters.network.local_ratelimit","typed_config":{"@type":"type.googleapis.com/envoy.extensions.filters.network.local_ratelimit.v3.LocalRateLimit","stat_prefix":"rate_limit","token_bucket":{"fill_interval":"1s","max_tokens":10}}}`},
+		},
+		{name: "empty list", value: "[]", want: []string{}},
+		{name: "not a list", value: "name: envoy.filters.network.rbac", wantErr: true},
+		{name: "no name", value: "- typed_config: {}", wantErr: true},
+		{name: "invalid yaml", value: "- name: [", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := listenerFilters(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("listenerFilters() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("listenerFilters() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_clusterOptions(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		want    map[string]string
+		wantErr bool
+	}{
+		{
+			name:  "outlier detection",
+			value: "outlier_detection:\n  consecutive_5xx: 3\nper_connection_buffer_limit_bytes: 32768",
+			want:  map[string]string{"outlier_detection": `{"consecutive_5xx":3}`, "per_connection_buffer_limit_bytes": "32768"},
+		},
+		{name: "generated field", value: "lb_policy: ROUND_ROBIN", wantErr: true},
+		{name: "invalid field", value: `"bad key": 1`, wantErr: true},
+		{name: "not a map", value: "- outlier_detection", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := clusterOptions(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("clusterOptions() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("clusterOptions() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_proxyConfigSnippets(t *testing.T) {
+	service := makeService("test")
+	service.Spec.Type = v1.ServiceTypeLoadBalancer
+	service.Spec.IPFamilies = []v1.IPFamily{v1.IPv4Protocol}
+	service.Spec.Ports = []v1.ServicePort{
+		{Name: "tcp", Port: 80, NodePort: 30000, Protocol: v1.ProtocolTCP},
+		{Name: "udp", Port: 53, NodePort: 30001, Protocol: v1.ProtocolUDP},
+	}
+	service.Annotations = map[string]string{
+		constants.ListenerFiltersAnnotation: "- name: envoy.filters.network.rbac\n  typed_config: {stat_prefix: rbac}",
+		constants.ClusterOptionsAnnotation:  "outlier_detection: {consecutive_5xx: 3}",
+	}
+	got, err := proxyConfig(generateConfig(service, []*v1.Node{makeNode("a", "10.0.0.1")}))
+	if err != nil {
+		t.Fatalf("proxyConfig() error = %v", err)
+	}
+
+	var bootstrap struct {
+		StaticResources struct {
+			Listeners []struct {
+				Name         string `json:"name"`
+				FilterChains []struct {
+					Filters []struct {
+						Name string `json:"name"`
+					} `json:"filters"`
+				} `json:"filter_chains"`
+			} `json:"listeners"`
+		} `json:"static_resources"`
+	}
+	if err := yaml.Unmarshal([]byte(got.Bootstrap), &bootstrap); err != nil {
+		t.Fatalf("invalid bootstrap config: %v\n%s", err, got.Bootstrap)
+	}
+	filters := map[string][]string{}
+	for _, listener := range bootstrap.StaticResources.Listeners {
+		filters[listener.Name] = []string{}
+		for _, chain := range listener.FilterChains {
+			for _, filter := range chain.Filters {
+				filters[listener.Name] = append(filters[listener.Name], filter.Name)
+			}
+		}
+	}
+	want := map[string][]string{
+		"listener_IPv4_80_TCP": {"envoy.filters.network.rbac", "envoy.filters.network.tcp_proxy"},
+		"listener_IPv4_53_UDP": {},
+	}
+	if !reflect.DeepEqual(filters, want) {
+		t.Errorf("listener filters = %v, want %v", filters, want)
+	}
+
+	var clusters struct {
+		Resources []map[string]interface{} `json:"resources"`
+	}
+	if err := yaml.Unmarshal([]byte(got.Clusters), &clusters); err != nil {
+		t.Fatalf("invalid clusters config: %v\n%s", err, got.Clusters)
+	}
+	if len(clusters.Resources) != 2 {
+		t.Fatalf("got %d clusters, want 2", len(clusters.Resources))
+	}
+	for _, cluster := range clusters.Resources {
+		want := map[string]interface{}{"consecutive_5xx": float64(3)}
+		if !reflect.DeepEqual(cluster["outlier_detection"], want) || cluster["lb_policy"] != "RANDOM" {
+			t.Errorf("cluster %v, want the outlier detection option", cluster)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package loadbalancer
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"k8s.io/klog/v2"
+
+	"sigs.k8s.io/cloud-provider-kind/pkg/constants"
+	"sigs.k8s.io/cloud-provider-kind/pkg/container"
+)
+
+// proxyStateVersionPath is the file in the loadbalancer container with the version of
+// the state persisted in the container, ex. the commit marker or the config hash. It is
+// written once the state is committed or migrated, until then the version is the one of
+// the state version label set when the container was created. Containers created before
+// the state was versioned have neither and are version 0.
+const proxyStateVersionPath = "/etc/envoy/state-version"
+
+// stateVersion is the version of the state persisted by this binary, it must be
+// increased, adding the corresponding migration, every time the persisted state changes
+// in a way the previous versions can not handle.
+const stateVersion = 1
+
+// migration updates the state persisted in a loadbalancer container to the next version,
+// they must be idempotent since the process can be interrupted before the new version
+// is recorded.
+type migration struct {
+	description string
+	migrate     func(name string) error
+}
+
+// migrations[i] migrates the state from version i to version i+1
+var migrations = []migration{
+	{
+		// the containers of the previous versions are serving traffic and would be
+		// considered an interrupted provisioning, recreating them changes their IPs.
+		description: "mark the running loadbalancer as committed",
+		migrate:     commitLegacy,
+	},
+}
+
+// pendingMigrations returns the migrations required to update the state from the version
+func pendingMigrations(version int) ([]migration, error) {
+	if version < 0 || version > len(migrations) {
+		return nil, fmt.Errorf("unsupported state version %d, this binary supports up to version %d", version, len(migrations))
+	}
+	return migrations[version:], nil
+}
+
+// commitLegacy commits the loadbalancer container created before the provisioning was
+// transactional, the ones provisioned with a transaction are only committed by it.
+func commitLegacy(name string) error {
+	value, err := container.GetLabelValue(name, constants.LoadBalancerTransactionLabelKey)
+	if err != nil {
+		return err
+	}
+	if value != "" {
+		return nil
+	}
+	return commit(name)
+}
+
+// readStateVersion returns the version of the state persisted in the container
+func readStateVersion(name string) (int, error) {
+	var stdout, stderr bytes.Buffer
+	err := container.Exec(name, []string{"cat", proxyStateVersionPath}, nil, &stdout, &stderr)
+	if err == nil {
+		return strconv.Atoi(strings.TrimSpace(stdout.String()))
+	}
+	if !strings.Contains(stderr.String(), "No such file or directory") {
+		return 0, err
+	}
+	value, err := container.GetLabelValue(name, constants.LoadBalancerStateVersionLabelKey)
+	if err != nil {
+		return 0, err
+	}
+	if value == "" {
+		return 0, nil
+	}
+	return strconv.Atoi(value)
+}
+
+// writeStateVersion records the version of the state persisted by this binary
+func writeStateVersion(name string) error {
+	return container.Exec(name, []string{"cp", "/dev/stdin", proxyStateVersionPath}, strings.NewReader(strconv.Itoa(stateVersion)), nil, nil)
+}
+
+// migrateState updates the state persisted in the loadbalancer container created by a
+// previous version of the binary, so upgrading does not recreate or orphan the existing
+// loadbalancers. Containers created by a newer version are not modified.
+func migrateState(ctx context.Context, name string) error {
+	version, err := readStateVersion(name)
+	if err != nil {
+		return fmt.Errorf("failed to read loadbalancer %s state version: %w", name, err)
+	}
+	if version == stateVersion {
+		return nil
+	}
+	pending, err := pendingMigrations(version)
+	if err != nil {
+		return fmt.Errorf("loadbalancer %s can not be managed: %w", name, err)
+	}
+	for i, m := range pending {
+		klog.FromContext(ctx).Info("Migrating loadbalancer state", "version", version+i+1, "migration", m.description)
+		err := m.migrate(name)
+		if err != nil {
+			return fmt.Errorf("failed to migrate loadbalancer %s state to version %d: %w", name, version+i+1, err)
+		}
+	}
+	return writeStateVersion(name)
+}
@@ -0,0 +1,96 @@
+package loadbalancer
+
+import (
+	"context"
+	"strconv"
+	"testing"
+
+	"sigs.k8s.io/cloud-provider-kind/pkg/constants"
+	"sigs.k8s.io/cloud-provider-kind/pkg/container"
+)
+
+func Test_pendingMigrations(t *testing.T) {
+	if len(migrations) != stateVersion {
+		t.Fatalf("stateVersion %d does not match the %d migrations", stateVersion, len(migrations))
+	}
+	tests := []struct {
+		version int
+		want    int
+		wantErr bool
+	}{
+		{version: 0, want: stateVersion},
+		{version: stateVersion, want: 0},
+		{version: stateVersion + 1, wantErr: true},
+		{version: -1, wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := pendingMigrations(tt.version)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("pendingMigrations(%d) error = %v, wantErr %v", tt.version, err, tt.wantErr)
+			continue
+		}
+		if len(got) != tt.want {
+			t.Errorf("pendingMigrations(%d) = %d migrations, want %d", tt.version, len(got), tt.want)
+		}
+	}
+}
+
+func Test_migrateState(t *testing.T) {
+	tests := []struct {
+		name          string
+		labels        map[string]string
+		files         map[string]string
+		stopped       bool
+		wantErr       bool
+		wantCommitted bool
+	}{
+		{
+			name:          "created before the state was versioned",
+			wantCommitted: true,
+		},
+		{
+			name:          "committed by a previous version",
+			files:         map[string]string{proxyCommitPath: "", proxyStateVersionPath: "1"},
+			wantCommitted: true,
+		},
+		{
+			name:   "interrupted provisioning of this version",
+			labels: map[string]string{constants.LoadBalancerTransactionLabelKey: "true", constants.LoadBalancerStateVersionLabelKey: strconv.Itoa(stateVersion)},
+		},
+		{
+			name:   "interrupted transaction created before the state version label",
+			labels: map[string]string{constants.LoadBalancerTransactionLabelKey: "true"},
+		},
+		{
+			name:    "newer version",
+			files:   map[string]string{proxyStateVersionPath: strconv.Itoa(stateVersion + 1)},
+			wantErr: true,
+		},
+		{
+			name:    "stopped container",
+			stopped: true,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := container.NewFake()
+			defer container.SetRuntime(fake)()
+			fake.AddContainer(&container.FakeContainer{Name: "lb", Labels: tt.labels, Files: tt.files, Running: !tt.stopped})
+
+			err := migrateState(context.Background(), "lb")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("migrateState() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if got := isCommitted("lb"); got != tt.wantCommitted {
+				t.Errorf("isCommitted() = %v, want %v", got, tt.wantCommitted)
+			}
+			if got, err := readStateVersion("lb"); err != nil || got != stateVersion {
+				t.Errorf("readStateVersion() = %d, %v, want %d", got, err, stateVersion)
+			}
+		})
+	}
+}
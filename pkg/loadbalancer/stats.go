@@ -0,0 +1,95 @@
+package loadbalancer
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"sigs.k8s.io/cloud-provider-kind/pkg/container"
+)
+
+// proxyAdminStatsScript dumps the stats of the clusters of the Service ports from the envoy
+// admin interface, the filter is a regex so the output is filtered again when parsed
+const proxyAdminStatsScript = `exec 3<>/dev/tcp/127.0.0.1/9901 && printf 'GET /stats?filter=cluster.cluster_ HTTP/1.0\r\n\r\n' >&3 && cat <&3`
+
+// Stats are the connection and backend counters of a loadbalancer, or of one of its ports.
+// The connections are the ones of the TCP ports, the UDP sessions are not counted.
+type Stats struct {
+	ActiveConnections int64 `json:"activeConnections"`
+	TotalConnections  int64 `json:"totalConnections"`
+	HealthyBackends   int64 `json:"healthyBackends"`
+	TotalBackends     int64 `json:"totalBackends"`
+}
+
+func (s *Stats) add(other Stats) {
+	s.ActiveConnections += other.ActiveConnections
+	s.TotalConnections += other.TotalConnections
+	s.HealthyBackends += other.HealthyBackends
+	s.TotalBackends += other.TotalBackends
+}
+
+// PortStats are the stats of a port of the proxy, ex. IPv4_80_TCP
+type PortStats struct {
+	Name  string `json:"name"`
+	Stats `json:",inline"`
+}
+
+// LoadBalancerStats returns the stats of each port of the loadbalancer sorted by name, and
+// their sum
+func LoadBalancerStats(name string) (Stats, []PortStats, error) {
+	var stdout bytes.Buffer
+	err := container.Exec(name, []string{"bash", "-c", proxyAdminStatsScript}, nil, &stdout, nil)
+	if err != nil {
+		return Stats{}, nil, fmt.Errorf("failed to get the stats of loadbalancer %s: %w", name, err)
+	}
+	ports := parseProxyStats(&stdout)
+	total := Stats{}
+	for _, port := range ports {
+		total.add(port.Stats)
+	}
+	return total, ports, nil
+}
+
+// parseProxyStats parses the envoy admin /stats output, the cluster stats lines have the
+// format: cluster.cluster_IPv4_80_TCP.upstream_cx_active: 2
+func parseProxyStats(r io.Reader) []PortStats {
+	stats := map[string]*Stats{}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		stat, value, ok := strings.Cut(strings.TrimSpace(scanner.Text()), ": ")
+		if !ok || !strings.HasPrefix(stat, "cluster.cluster_") {
+			continue
+		}
+		port, counter, ok := strings.Cut(strings.TrimPrefix(stat, "cluster.cluster_"), ".")
+		if !ok {
+			continue
+		}
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			continue
+		}
+		if stats[port] == nil {
+			stats[port] = &Stats{}
+		}
+		switch counter {
+		case "upstream_cx_active":
+			stats[port].ActiveConnections = n
+		case "upstream_cx_total":
+			stats[port].TotalConnections = n
+		case "membership_healthy":
+			stats[port].HealthyBackends = n
+		case "membership_total":
+			stats[port].TotalBackends = n
+		}
+	}
+	ports := []PortStats{}
+	for name, s := range stats {
+		ports = append(ports, PortStats{Name: name, Stats: *s})
+	}
+	sort.Slice(ports, func(i, j int) bool { return ports[i].Name < ports[j].Name })
+	return ports
+}
@@ -0,0 +1,33 @@
+package loadbalancer
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func Test_parseProxyStats(t *testing.T) {
+	output := `HTTP/1.0 200 OK
+content-type: text/plain; charset=UTF-8
+
+cluster.cluster_IPv4_80_TCP.membership_healthy: 2
+cluster.cluster_IPv4_80_TCP.membership_total: 3
+cluster.cluster_IPv4_80_TCP.upstream_cx_active: 4
+cluster.cluster_IPv4_80_TCP.upstream_cx_total: 120
+cluster.cluster_IPv4_80_TCP.upstream_cx_connect_ms: P0(nan,1) P25(nan,1.025)
+cluster.cluster_IPv6_53_UDP.membership_healthy: 1
+cluster.cluster_IPv6_53_UDP.membership_total: 1
+cluster_manager.active_clusters: 2
+`
+	want := []PortStats{
+		{Name: "IPv4_80_TCP", Stats: Stats{ActiveConnections: 4, TotalConnections: 120, HealthyBackends: 2, TotalBackends: 3}},
+		{Name: "IPv6_53_UDP", Stats: Stats{HealthyBackends: 1, TotalBackends: 1}},
+	}
+	if diff := cmp.Diff(want, parseProxyStats(strings.NewReader(output))); diff != "" {
+		t.Errorf("parseProxyStats() mismatch (-want +got):\n%s", diff)
+	}
+	if got := parseProxyStats(strings.NewReader("")); len(got) != 0 {
+		t.Errorf("parseProxyStats() without stats = %v", got)
+	}
+}
@@ -0,0 +1,87 @@
+package loadbalancer
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"text/template"
+)
+
+// templateFuncs are available to the config templates, the user templates must keep
+// loading the clusters from clustersPath
+var templateFuncs = template.FuncMap{
+	"clustersPath":     func() string { return proxyClustersPath },
+	"defaultBootstrap": defaultBootstrap,
+	"defaultListener":  defaultListener,
+}
+
+// DefaultProxyConfigTemplate returns the default template of the proxy bootstrap config,
+// the starting point of the user templates
+func DefaultProxyConfigTemplate() string {
+	return proxyDefaultConfigTemplate
+}
+
+// LoadProxyConfigTemplate reads a user template of the proxy bootstrap config and checks
+// it renders the config of a Service with TCP and UDP ports
+func LoadProxyConfigTemplate(path string) (string, error) {
+	text, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read the proxy config template: %w", err)
+	}
+	sample := &proxyConfigData{
+		HealthCheckPort: 10256,
+		ServicePorts: map[string]servicePort{
+			"IPv4_80_TCP": {
+				Listener: endpoint{Address: mustParseAddress("0.0.0.0"), Port: 80, Protocol: "TCP"},
+				Cluster:  []endpoint{{Address: mustParseAddress("10.0.0.1"), Port: 30000, Protocol: "TCP"}},
+			},
+			"IPv4_53_UDP": {
+				Listener: endpoint{Address: mustParseAddress("0.0.0.0"), Port: 53, Protocol: "UDP"},
+				Cluster:  []endpoint{{Address: mustParseAddress("10.0.0.1"), Port: 30001, Protocol: "UDP"}},
+			},
+		},
+	}
+	if _, err := renderTemplate(string(text), sample); err != nil {
+		return "", fmt.Errorf("invalid proxy config template %s: %w", path, err)
+	}
+	return string(text), nil
+}
+
+// proxyDefaultConfigTemplate is the starting point of the user templates of the bootstrap
+// config, set with --proxy-config-template. It renders the config built by bootstrapConfig,
+// the user templates can add listeners or replace the default ones, ex. to add filters.
+const proxyDefaultConfigTemplate = `# the config built by cloud-provider-kind, the clusters must be loaded from {{ clustersPath }}
+{{ defaultBootstrap . }}
+static_resources:
+  listeners:
+  {{- range $index, $servicePort := .ServicePorts }}
+  - {{ defaultListener $ $index }}
+  {{- end }}
+`
+
+// defaultBootstrap returns the bootstrap config built for the data without the listeners
+func defaultBootstrap(data *proxyConfigData) (string, error) {
+	out, err := bootstrapMap(data)
+	if err != nil {
+		return "", err
+	}
+	delete(out, "static_resources")
+	return marshalYAML(out)
+}
+
+// defaultListener returns the listener built for the Service port in JSON, that is valid in
+// a YAML list
+func defaultListener(data *proxyConfigData, index string) (string, error) {
+	out, err := bootstrapMap(data)
+	if err != nil {
+		return "", err
+	}
+	listeners, _ := out["static_resources"].(map[string]interface{})["listeners"].([]interface{})
+	for _, listener := range listeners {
+		if listener.(map[string]interface{})["name"] == "listener_"+index {
+			encoded, err := json.Marshal(listener)
+			return string(encoded), err
+		}
+	}
+	return "", fmt.Errorf("no listener for the Service port %s", index)
+}
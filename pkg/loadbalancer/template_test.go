@@ -0,0 +1,74 @@
+package loadbalancer
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+
+	"sigs.k8s.io/cloud-provider-kind/pkg/config"
+)
+
+func TestLoadProxyConfigTemplate(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+		wantErr  bool
+	}{
+		{name: "default", template: proxyDefaultConfigTemplate},
+		{name: "custom", template: "listeners:\n{{- range $index, $port := .ServicePorts }}\n- {{ $index }}: {{ $port.Listener.Port }}\n{{- end }}\ncds: {{ clustersPath }}\n"},
+		{name: "syntax error", template: "{{ range .ServicePorts }}", wantErr: true},
+		{name: "unknown field", template: "{{ .Listeners }}", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "envoy.yaml.tmpl")
+			if err := os.WriteFile(path, []byte(tt.template), 0644); err != nil {
+				t.Fatal(err)
+			}
+			got, err := LoadProxyConfigTemplate(path)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("LoadProxyConfigTemplate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && got != tt.template {
+				t.Errorf("LoadProxyConfigTemplate() = %q, want the file content", got)
+			}
+		})
+	}
+	if _, err := LoadProxyConfigTemplate(filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Errorf("LoadProxyConfigTemplate() of a missing file expected an error")
+	}
+}
+
+func Test_proxyConfigUserTemplate(t *testing.T) {
+	defer func(value string) { config.DefaultConfig.ProxyConfigTemplate = value }(config.DefaultConfig.ProxyConfigTemplate)
+	service := makeService("test")
+	service.Spec.Type = v1.ServiceTypeLoadBalancer
+	service.Spec.Ports[0].NodePort = 30000
+	service.Spec.Ports[0].Protocol = v1.ProtocolTCP
+	service.Spec.IPFamilies = []v1.IPFamily{v1.IPv4Protocol}
+	nodes := []*v1.Node{makeNode("a", "10.0.0.1")}
+
+	defaults, err := proxyConfig(generateConfig(service, nodes))
+	if err != nil {
+		t.Fatalf("proxyConfig() error = %v", err)
+	}
+	if !strings.Contains(defaults.Bootstrap, "path: "+proxyClustersPath) {
+		t.Errorf("default bootstrap config does not load the clusters from %s:\n%s", proxyClustersPath, defaults.Bootstrap)
+	}
+
+	config.DefaultConfig.ProxyConfigTemplate = "# custom\n{{- range $index, $port := .ServicePorts }}\n{{ $index }}: {{ $port.Listener.Port }}\n{{- end }}\n"
+	got, err := proxyConfig(generateConfig(service, nodes))
+	if err != nil {
+		t.Fatalf("proxyConfig() error = %v", err)
+	}
+	if !strings.HasPrefix(got.Bootstrap, "# custom\n") || !strings.Contains(got.Bootstrap, "_TCP: ") {
+		t.Errorf("bootstrap config not rendered from the user template:\n%s", got.Bootstrap)
+	}
+	// the clusters are still rendered from the default template
+	if got.Clusters != defaults.Clusters {
+		t.Errorf("clusters config changed with the user template")
+	}
+}
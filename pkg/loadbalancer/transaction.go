@@ -0,0 +1,257 @@
+package loadbalancer
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog/v2"
+
+	"sigs.k8s.io/cloud-provider-kind/pkg/constants"
+	"sigs.k8s.io/cloud-provider-kind/pkg/container"
+)
+
+// proxyCommitPath is the marker file written in the loadbalancer container once the
+// provisioning has been completed and verified. A container without this file is the
+// leftover of an interrupted provisioning and can not be trusted.
+const proxyCommitPath = "/etc/envoy/committed"
+
+// transaction keeps track of the actions done during the provisioning of a loadbalancer
+// so they can be reverted if any of the following steps fail.
+type transaction struct {
+	name      string
+	logger    klog.Logger
+	rollbacks []func() error
+}
+
+func newTransaction(ctx context.Context, name string) *transaction {
+	return &transaction{name: name, logger: klog.FromContext(ctx)}
+}
+
+// onRollback registers an action to be executed if the transaction is rolled back,
+// the actions are executed in reverse order.
+func (t *transaction) onRollback(fn func() error) {
+	t.rollbacks = append(t.rollbacks, fn)
+}
+
+// rollback undoes all the registered actions and returns the original error
+// joined with the errors found during the rollback.
+func (t *transaction) rollback(err error) error {
+	t.logger.Info("Rolling back loadbalancer provisioning", "err", err)
+	errs := []error{err}
+	for i := len(t.rollbacks) - 1; i >= 0; i-- {
+		if rerr := t.rollbacks[i](); rerr != nil {
+			errs = append(errs, fmt.Errorf("rollback failed: %w", rerr))
+		}
+	}
+	t.rollbacks = nil
+	return errors.Join(errs...)
+}
+
+// isCommitted returns true if the loadbalancer container was completely provisioned. The
+// running containers created before the provisioning was transactional do not have the
+// transaction label and are serving traffic, they are committed so upgrading does not
+// recreate them and change their IPs.
+func isCommitted(name string) bool {
+	value, err := container.GetLabelValue(name, constants.LoadBalancerTransactionLabelKey)
+	if err == nil && value == "" {
+		return true
+	}
+	err = container.Exec(name, []string{"test", "-f", proxyCommitPath}, nil, nil, nil)
+	return err == nil
+}
+
+// commit marks the loadbalancer container as completely provisioned.
+func commit(name string) error {
+	err := container.Exec(name, []string{"touch", proxyCommitPath}, nil, nil, nil)
+	if err != nil {
+		return err
+	}
+	return writeStateVersion(name)
+}
+
+// readProxyConfig returns the config currently applied to the loadbalancer container.
+func readProxyConfig(name string) (proxyFiles, error) {
+	var bootstrap, clusters, faults bytes.Buffer
+	err := container.Exec(name, []string{"cat", proxyConfigPath}, nil, &bootstrap, nil)
+	if err != nil {
+		return proxyFiles{}, err
+	}
+	// the containers configured by previous versions do not have the clusters file
+	container.Exec(name, []string{"cat", proxyClustersPath}, nil, &clusters, nil) // nolint:errcheck
+	// the faults file only exists if the faults were ever applied
+	container.Exec(name, []string{"cat", proxyFaultsPath}, nil, &faults, nil) // nolint:errcheck
+	return proxyFiles{Bootstrap: bootstrap.String(), Clusters: clusters.String(), Faults: faults.String()}, nil
+}
+
+// waitForIPs waits until the container runtime has reserved the addresses of the
+// families required by the Service.
+func waitForIPs(ctx context.Context, name string, wantIPv4, wantIPv6 bool) error {
+	return wait.PollUntilContextTimeout(ctx, 500*time.Millisecond, 10*time.Second, true, func(ctx context.Context) (bool, error) {
+		ipv4, ipv6, err := container.IPs(name)
+		if err != nil {
+			return false, nil
+		}
+		if (wantIPv4 && ipv4 == "") || (wantIPv6 && ipv6 == "") {
+			return false, nil
+		}
+		return true, nil
+	})
+}
+
+// waitForListeners waits until all the listeners of the config are bound inside the
+// loadbalancer container, so the addresses are not published before the proxy is
+// able to forward the traffic. The TCP and UDP listeners of the same port, and the IPv4
+// and IPv6 ones, are separate sockets checked on their own, so one of them failing to
+// bind is not hidden by the others.
+func waitForListeners(ctx context.Context, name string, config *proxyConfigData) error {
+	if config == nil || len(config.ServicePorts) == 0 {
+		return nil
+	}
+	var missing []string
+	err := wait.PollUntilContextTimeout(ctx, 500*time.Millisecond, 10*time.Second, true, func(ctx context.Context) (bool, error) {
+		listening := map[string]bool{}
+		for _, file := range []string{"/proc/net/tcp", "/proc/net/tcp6", "/proc/net/udp", "/proc/net/udp6"} {
+			var stdout bytes.Buffer
+			err := container.Exec(name, []string{"cat", file}, nil, &stdout, nil)
+			if err != nil {
+				// the file does not exist if the kernel does not support the family
+				continue
+			}
+			protocol := "TCP"
+			if strings.HasPrefix(file, "/proc/net/udp") {
+				protocol = "UDP"
+			}
+			family := v1.IPv4Protocol
+			if strings.HasSuffix(file, "6") {
+				family = v1.IPv6Protocol
+			}
+			ports, err := parseProcNetListeners(&stdout, protocol)
+			if err != nil {
+				return false, err
+			}
+			for _, port := range ports {
+				listening[listenerSocket(family, port, protocol)] = true
+			}
+		}
+		missing = nil
+		for _, servicePort := range config.ServicePorts {
+			family := v1.IPv4Protocol
+			if servicePort.Listener.Address.IP().Is6() {
+				family = v1.IPv6Protocol
+			}
+			key := listenerSocket(family, servicePort.Listener.Port, servicePort.Listener.Protocol)
+			if !listening[key] {
+				missing = append(missing, key)
+			}
+		}
+		return len(missing) == 0, nil
+	})
+	if err != nil {
+		return fmt.Errorf("loadbalancer %s listeners %v not ready: %w", name, missing, err)
+	}
+	return nil
+}
+
+// listenerSocket identifies a listener socket, in the format of the Service port keys
+func listenerSocket(family v1.IPFamily, port int, protocol string) string {
+	return fmt.Sprintf("%s_%d_%s", family, port, protocol)
+}
+
+// parseProcNetListeners parses the content of /proc/net/{tcp,udp}[6] and returns the
+// local ports that are listening (TCP) or bound (UDP).
+func parseProcNetListeners(r io.Reader, protocol string) ([]int, error) {
+	// TCP_LISTEN and TCP_CLOSE, the latter is the state used by bound UDP sockets
+	state := "0A"
+	if protocol == "UDP" {
+		state = "07"
+	}
+	ports := []int{}
+	scanner := bufio.NewScanner(r)
+	// skip header
+	scanner.Scan()
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 4 {
+			continue
+		}
+		if fields[3] != state {
+			continue
+		}
+		// local_address is in the format ADDRESS:PORT in hexadecimal
+		idx := strings.LastIndex(fields[1], ":")
+		if idx < 0 {
+			return nil, fmt.Errorf("invalid local address %q", fields[1])
+		}
+		port, err := strconv.ParseInt(fields[1][idx+1:], 16, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid local port %q: %w", fields[1], err)
+		}
+		ports = append(ports, int(port))
+	}
+	return ports, scanner.Err()
+}
+
+// proxyAdminClustersScript dumps the upstream hosts status from the envoy admin
+// interface, the image has no http client so it uses the bash builtin /dev/tcp.
+const proxyAdminClustersScript = `exec 3<>/dev/tcp/127.0.0.1/9901 && printf 'GET /clusters HTTP/1.0\r\n\r\n' >&3 && cat <&3`
+
+// checkUpstreams returns an error if a cluster of the config has no upstream passing the
+// health checks, so the addresses are not published while the proxy is only able to reject
+// the connections. It does not wait for them, the Service is synced again instead of
+// blocking the worker.
+func checkUpstreams(name string, config *proxyConfigData) error {
+	if config == nil || len(config.ServicePorts) == 0 {
+		return nil
+	}
+	var stdout bytes.Buffer
+	err := container.Exec(name, []string{"bash", "-c", proxyAdminClustersScript}, nil, &stdout, nil)
+	if err != nil {
+		return fmt.Errorf("failed to get loadbalancer %s upstreams status: %w", name, err)
+	}
+	healthy := parseHealthyClusters(&stdout)
+	var unready []string
+	for index := range config.ServicePorts {
+		if !healthy["cluster_"+index] {
+			unready = append(unready, index)
+		}
+	}
+	if len(unready) > 0 {
+		slices.Sort(unready)
+		return fmt.Errorf("loadbalancer %s has no healthy upstreams for %v", name, unready)
+	}
+	return nil
+}
+
+// parseHealthyClusters parses the envoy admin /clusters output and returns the clusters
+// with at least one healthy host, the host lines have the format:
+// cluster_name::address:port::health_flags::healthy
+func parseHealthyClusters(r io.Reader) map[string]bool {
+	healthy := map[string]bool{}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		// IPv6 addresses contain :: so split from both ends
+		idx := strings.LastIndex(line, "::health_flags::")
+		if idx < 0 {
+			continue
+		}
+		cluster, _, ok := strings.Cut(line, "::")
+		if !ok {
+			continue
+		}
+		if line[idx+len("::health_flags::"):] == "healthy" {
+			healthy[cluster] = true
+		}
+	}
+	return healthy
+}
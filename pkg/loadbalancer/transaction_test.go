@@ -0,0 +1,180 @@
+package loadbalancer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+
+	"sigs.k8s.io/cloud-provider-kind/pkg/container"
+)
+
+func Test_parseProcNetListeners(t *testing.T) {
+	tests := []struct {
+		name     string
+		protocol string
+		content  string
+		want     []int
+	}{
+		{
+			name:     "tcp",
+			protocol: "TCP",
+			content: `  sl  local_address rem_address   st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode
+   0: 0100007F:26AD 00000000:0000 0A 00000000:00000000 00:00000000 00000000     0        0 1234 1 0000000000000000 100 0 0 10 0
+   1: 00000000:0050 00000000:0000 0A 00000000:00000000 00:00000000 00000000     0        0 1235 1 0000000000000000 100 0 0 10 0
+   2: 0200A8C0:0050 0300A8C0:D431 01 00000000:00000000 00:00000000 00000000     0        0 1236 1 0000000000000000 100 0 0 10 0
+`,
+			want: []int{9901, 80},
+		},
+		{
+			name:     "udp6",
+			protocol: "UDP",
+			content: `  sl  local_address                         remote_address                        st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode ref pointer drops
+  10: 00000000000000000000000000000000:0035 00000000000000000000000000000000:0000 07 00000000:00000000 00:00000000 00000000     0        0 1237 2 0000000000000000 0
+`,
+			want: []int{53},
+		},
+		{
+			name:     "empty",
+			protocol: "TCP",
+			content:  `  sl  local_address rem_address   st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode`,
+			want:     []int{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseProcNetListeners(strings.NewReader(tt.content), tt.protocol)
+			if err != nil {
+				t.Fatalf("parseProcNetListeners() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseProcNetListeners() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_transactionRollback(t *testing.T) {
+	order := []int{}
+	tx := newTransaction(context.Background(), "test")
+	tx.onRollback(func() error { order = append(order, 1); return nil })
+	tx.onRollback(func() error { order = append(order, 2); return errors.New("failed") })
+	errOrig := errors.New("original")
+	err := tx.rollback(errOrig)
+	if !errors.Is(err, errOrig) {
+		t.Errorf("expected original error, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "rollback failed: failed") {
+		t.Errorf("expected rollback error, got %v", err)
+	}
+	if !reflect.DeepEqual(order, []int{2, 1}) {
+		t.Errorf("expected rollback in reverse order, got %v", order)
+	}
+}
+
+func Test_parseHealthyClusters(t *testing.T) {
+	content := `HTTP/1.0 200 OK
+content-type: text/plain
+
+cluster_IPv4_80_TCP::observability_name::cluster_IPv4_80_TCP
+cluster_IPv4_80_TCP::default_priority::max_connections::1024
+cluster_IPv4_80_TCP::172.18.0.2:30000::health_flags::/failed_active_hc
+cluster_IPv4_80_TCP::172.18.0.3:30000::health_flags::healthy
+cluster_IPv6_80_TCP::[fc00:f853:ccd:e793::2]:30000::health_flags::healthy
+cluster_IPv4_53_UDP::172.18.0.2:30053::health_flags::/failed_active_hc/pending_active_hc
+`
+	want := map[string]bool{
+		"cluster_IPv4_80_TCP": true,
+		"cluster_IPv6_80_TCP": true,
+	}
+	if got := parseHealthyClusters(strings.NewReader(content)); !reflect.DeepEqual(got, want) {
+		t.Errorf("parseHealthyClusters() = %v, want %v", got, want)
+	}
+}
+
+func Test_checkUpstreams(t *testing.T) {
+	fake := container.NewFake()
+	defer container.SetRuntime(fake)()
+	fake.AddContainer(&container.FakeContainer{Name: "lb", Running: true})
+	healthy := ""
+	fake.ExecHook = func(name string, command []string, stdout io.Writer) (bool, error) {
+		if len(command) != 3 || command[0] != "bash" {
+			return true, fmt.Errorf("unsupported command %v", command)
+		}
+		fmt.Fprintln(stdout, "cluster_IPv4_80_TCP::172.18.0.2:30080::health_flags::/failed_active_hc")
+		if healthy != "" {
+			fmt.Fprintf(stdout, "%s::172.18.0.3:30080::health_flags::healthy\n", healthy)
+		}
+		return true, nil
+	}
+	config := &proxyConfigData{
+		ServicePorts: map[string]servicePort{
+			"IPv4_80_TCP": {Listener: endpoint{Address: mustParseAddress("0.0.0.0"), Port: 80, Protocol: string(v1.ProtocolTCP)}},
+		},
+	}
+
+	err := checkUpstreams("lb", config)
+	if err == nil || !strings.Contains(err.Error(), "IPv4_80_TCP") {
+		t.Errorf("checkUpstreams() error = %v, want the cluster without healthy upstreams", err)
+	}
+	healthy = "cluster_IPv4_80_TCP"
+	if err := checkUpstreams("lb", config); err != nil {
+		t.Errorf("checkUpstreams() unexpected error: %v", err)
+	}
+	fake.Crash("lb", 1, false) // nolint:errcheck
+	if err := checkUpstreams("lb", config); err == nil {
+		t.Errorf("checkUpstreams() expected an error for the stopped loadbalancer")
+	}
+}
+
+func Test_waitForListenersSamePort(t *testing.T) {
+	fake := container.NewFake()
+	defer container.SetRuntime(fake)()
+	fake.AddContainer(&container.FakeContainer{Name: "lb", Running: true})
+	bound := map[string]string{}
+	fake.ExecHook = func(name string, command []string, stdout io.Writer) (bool, error) {
+		if len(command) != 2 || command[0] != "cat" {
+			return true, fmt.Errorf("unsupported command %v", command)
+		}
+		fmt.Fprintln(stdout, "  sl  local_address rem_address   st")
+		if state, ok := bound[command[1]]; ok {
+			fmt.Fprintf(stdout, "   0: 00000000:0035 00000000:0000 %s\n", state)
+		}
+		return true, nil
+	}
+	config := &proxyConfigData{
+		ServicePorts: map[string]servicePort{
+			"IPv4_53_TCP": {Listener: endpoint{Address: mustParseAddress("0.0.0.0"), Port: 53, Protocol: string(v1.ProtocolTCP)}},
+			"IPv4_53_UDP": {Listener: endpoint{Address: mustParseAddress("0.0.0.0"), Port: 53, Protocol: string(v1.ProtocolUDP)}},
+		},
+	}
+
+	// the UDP listener failing to bind is not hidden by the TCP one
+	bound["/proc/net/tcp"] = "0A"
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	err := waitForListeners(ctx, "lb", config)
+	if err == nil || !strings.Contains(err.Error(), "IPv4_53_UDP") || strings.Contains(err.Error(), "IPv4_53_TCP") {
+		t.Errorf("waitForListeners() error = %v, want the UDP listener missing", err)
+	}
+
+	// nor by the IPv6 one
+	bound["/proc/net/udp6"] = "07"
+	ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	err = waitForListeners(ctx, "lb", config)
+	if err == nil || !strings.Contains(err.Error(), "IPv4_53_UDP") {
+		t.Errorf("waitForListeners() error = %v, want the IPv4 UDP listener missing", err)
+	}
+
+	bound["/proc/net/udp"] = "07"
+	if err := waitForListeners(context.Background(), "lb", config); err != nil {
+		t.Errorf("waitForListeners() error = %v", err)
+	}
+}
@@ -4,15 +4,22 @@ import (
 	"fmt"
 	"io"
 	"net"
+	"strconv"
+	"strings"
 	"sync"
 
+	v1 "k8s.io/api/core/v1"
 	"k8s.io/klog/v2"
 
+	"sigs.k8s.io/cloud-provider-kind/pkg/config"
 	"sigs.k8s.io/cloud-provider-kind/pkg/container"
 )
 
 const (
 	ifaceName = "lo0"
+	// nodePortTunnelAddress is the address of the tunnels to the NodePorts, the NodePort
+	// range is exposed on localhost like in the Linux hosts running kind
+	nodePortTunnelAddress = "127.0.0.1"
 )
 
 type tunnelManager struct {
@@ -27,7 +34,9 @@ func NewTunnelManager() *tunnelManager {
 	return t
 }
 
-func (t *tunnelManager) setupTunnels(containerName string) error {
+// setupTunnels forwards the published ports of the container, the ports in nodePorts
+// are forwarded from localhost instead of the loadbalancer IP.
+func (t *tunnelManager) setupTunnels(containerName string, nodePorts map[string]bool) error {
 	// get the portmapping from the container and its internal IPs and forward them
 	// 1. Create the fake IP on the tunnel interface
 	// 2. Capture the traffic directed to that IP port and forward to the exposed port in the host
@@ -35,15 +44,23 @@ func (t *tunnelManager) setupTunnels(containerName string) error {
 	if err != nil {
 		return err
 	}
-	klog.V(0).Infof("found port maps %v associated to container %s", portmaps, containerName)
+	klog.InfoS("Found port maps", "container", containerName, "portmaps", portmaps)
 
-	ipv4, _, err := container.IPs(containerName)
+	ipv4, ipv6, err := container.IPs(containerName)
 	if err != nil {
 		return err
 	}
+	// the IPv6 address is only used on the IPv6 only networks
+	tunnelIP := ipv4
+	if tunnelIP == "" {
+		tunnelIP = ipv6
+	}
+	if tunnelIP == "" {
+		return fmt.Errorf("container %s has no addresses", containerName)
+	}
 
-	klog.V(0).Infof("setting IPv4 address %s associated to container %s", ipv4, containerName)
-	err = AddIPToInterface(ifaceName, ipv4)
+	klog.InfoS("Setting address on the tunnel interface", "container", containerName, "address", tunnelIP, "interface", ifaceName)
+	err = AddIPToInterface(ifaceName, tunnelIP)
 	if err != nil {
 		return err
 	}
@@ -53,7 +70,23 @@ func (t *tunnelManager) setupTunnels(containerName string) error {
 	defer t.mu.Unlock()
 	// There is one IP per Service and a tunnel per Service Port
 	for containerPort, hostPort := range portmaps {
-		tun := NewTunnel(ipv4, containerPort, "localhost", hostPort)
+		if _, ok := t.tunnels[containerName][containerPort]; ok {
+			continue
+		}
+		if nodePorts[containerPort] {
+			// the same NodePort can be used by other clusters, that is not fatal for the Service
+			tun := NewTunnel(nodePortTunnelAddress, containerPort, "localhost", hostPort)
+			if err := tun.Start(); err != nil {
+				klog.InfoS("Can not expose the NodePort on localhost", "container", containerName, "port", containerPort, "err", err)
+				continue
+			}
+			if _, ok := t.tunnels[containerName]; !ok {
+				t.tunnels[containerName] = map[string]*tunnel{}
+			}
+			t.tunnels[containerName][containerPort] = tun
+			continue
+		}
+		tun := NewTunnel(tunnelIP, containerPort, "localhost", hostPort)
 		// TODO check if we can leak tunnels
 		err = tun.Start()
 		if err != nil {
@@ -69,7 +102,7 @@ func (t *tunnelManager) setupTunnels(containerName string) error {
 }
 
 func (t *tunnelManager) removeTunnels(containerName string) error {
-	klog.V(0).Infof("stopping tunnels on containers %s", containerName)
+	klog.InfoS("Stopping tunnels", "container", containerName)
 	t.mu.Lock()
 	defer t.mu.Unlock()
 	tunnels, ok := t.tunnels[containerName]
@@ -80,20 +113,73 @@ func (t *tunnelManager) removeTunnels(containerName string) error {
 	// all tunnels in the same container share the same local IP on the host
 	var tunnelIP string
 	for _, tunnel := range tunnels {
-		if tunnelIP == "" {
+		if tunnelIP == "" && tunnel.localIP != nodePortTunnelAddress {
 			tunnelIP = tunnel.localIP
 		}
 		tunnel.Stop() // nolint: errcheck
 	}
 
-	klog.V(0).Infof("Removing IPv4 address %s associated to interface %s", tunnelIP, ifaceName)
-	err := RemoveIPToInterface(ifaceName, tunnelIP)
-	if err != nil {
-		return err
+	if tunnelIP != "" {
+		klog.InfoS("Removing address from the tunnel interface", "address", tunnelIP, "interface", ifaceName)
+		err := RemoveIPToInterface(ifaceName, tunnelIP)
+		if err != nil {
+			// keep the tunnels to retry the removal of the address
+			return err
+		}
 	}
+	delete(t.tunnels, containerName)
 	return nil
 }
 
+// tunneledNodePorts returns the TCP NodePorts of the Services exposed on localhost, none
+// unless TunnelNodePorts is enabled.
+func tunneledNodePorts(services []*v1.Service) map[string]bool {
+	nodePorts := map[string]bool{}
+	if !config.DefaultConfig.TunnelNodePorts {
+		return nodePorts
+	}
+	for _, service := range services {
+		for _, port := range service.Spec.Ports {
+			if port.Protocol == v1.ProtocolTCP && port.NodePort != 0 {
+				nodePorts[strconv.Itoa(int(port.NodePort))] = true
+			}
+		}
+	}
+	return nodePorts
+}
+
+// withNodePortListeners returns the config with a listener on the NodePort of each TCP
+// port, forwarding to the same backends, so the NodePorts published by the loadbalancer
+// reach the nodes. The listeners are IPv4, or IPv6 if the loadbalancer only listens on IPv6
+// on the IPv6 only networks.
+func withNodePortListeners(lbConfig *proxyConfigData) *proxyConfigData {
+	if lbConfig == nil {
+		return nil
+	}
+	result := *lbConfig
+	result.ServicePorts = map[string]servicePort{}
+	for key, servicePort := range lbConfig.ServicePorts {
+		result.ServicePorts[key] = servicePort
+	}
+	family := v1.IPv6Protocol
+	if ipv4, _ := listenerIPFamilies(lbConfig); ipv4 {
+		family = v1.IPv4Protocol
+	}
+	for key, servicePort := range lbConfig.ServicePorts {
+		if !strings.HasPrefix(key, string(family)+"_") || servicePort.Listener.Protocol != string(v1.ProtocolTCP) || len(servicePort.Cluster) == 0 {
+			continue
+		}
+		nodePort := servicePort.Cluster[0].Port
+		nodePortKey := fmt.Sprintf("%s_%d_%s", family, nodePort, v1.ProtocolTCP)
+		if _, ok := result.ServicePorts[nodePortKey]; ok {
+			continue
+		}
+		servicePort.Listener.Port = nodePort
+		result.ServicePorts[nodePortKey] = servicePort
+	}
+	return &result
+}
+
 // tunnel listens on localIP:localPort and proxies the connection to remoteIP:remotePort
 type tunnel struct {
 	listener   net.Listener
@@ -113,7 +199,7 @@ func NewTunnel(localIP, localPort, remoteIP, remotePort string) *tunnel {
 }
 
 func (t *tunnel) Start() error {
-	klog.Infof("Starting tunnel on %s", net.JoinHostPort(t.localIP, t.localPort))
+	klog.InfoS("Starting tunnel", "address", net.JoinHostPort(t.localIP, t.localPort))
 	ln, err := net.Listen("tcp", net.JoinHostPort(t.localIP, t.localPort))
 	if err != nil {
 		return err
@@ -125,13 +211,13 @@ func (t *tunnel) Start() error {
 		for {
 			conn, err := ln.Accept()
 			if err != nil {
-				klog.Infof("unexpected error listening: %v", err)
+				klog.InfoS("Unexpected error listening", "address", net.JoinHostPort(t.localIP, t.localPort), "err", err)
 				return
 			} else {
 				go func() {
 					err := t.handleConnection(conn)
 					if err != nil {
-						klog.Infof("unexpected error on connection: %v", err)
+						klog.InfoS("Unexpected error on connection", "address", net.JoinHostPort(t.localIP, t.localPort), "err", err)
 					}
 				}()
 			}
@@ -0,0 +1,85 @@
+package loadbalancer
+
+import (
+	"reflect"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+
+	"sigs.k8s.io/cloud-provider-kind/pkg/config"
+)
+
+func Test_withNodePortListeners(t *testing.T) {
+	lbConfig := &proxyConfigData{
+		HealthCheckPort: 10256,
+		ServicePorts: map[string]servicePort{
+			"IPv4_80_TCP": {
+				Listener: endpoint{Port: 80, Protocol: "TCP"},
+				Cluster:  []endpoint{{Address: mustParseAddress("10.0.0.1"), Port: 30080, Protocol: "TCP"}},
+			},
+			"IPv4_53_UDP": {
+				Listener: endpoint{Port: 53, Protocol: "UDP"},
+				Cluster:  []endpoint{{Address: mustParseAddress("10.0.0.1"), Port: 30053, Protocol: "UDP"}},
+			},
+			"IPv6_80_TCP": {
+				Listener: endpoint{Port: 80, Protocol: "TCP"},
+				Cluster:  []endpoint{{Address: mustParseAddress("fd00::1"), Port: 30080, Protocol: "TCP"}},
+			},
+			"IPv4_443_TCP": {
+				Listener: endpoint{Port: 443, Protocol: "TCP"},
+			},
+		},
+	}
+	got := withNodePortListeners(lbConfig)
+	want := servicePort{
+		Listener: endpoint{Port: 30080, Protocol: "TCP"},
+		Cluster:  []endpoint{{Address: mustParseAddress("10.0.0.1"), Port: 30080, Protocol: "TCP"}},
+	}
+	if !reflect.DeepEqual(got.ServicePorts["IPv4_30080_TCP"], want) {
+		t.Errorf("withNodePortListeners() NodePort listener = %+v, want %+v", got.ServicePorts["IPv4_30080_TCP"], want)
+	}
+	if len(got.ServicePorts) != len(lbConfig.ServicePorts)+1 {
+		t.Errorf("withNodePortListeners() = %d ports, want only the IPv4 TCP NodePort added", len(got.ServicePorts))
+	}
+	if _, ok := lbConfig.ServicePorts["IPv4_30080_TCP"]; ok {
+		t.Errorf("withNodePortListeners() modified the original config")
+	}
+}
+
+func Test_withNodePortListenersIPv6Only(t *testing.T) {
+	lbConfig := &proxyConfigData{
+		HealthCheckPort: 10256,
+		ServicePorts: map[string]servicePort{
+			"IPv6_80_TCP": {
+				Listener: endpoint{Address: mustParseAddress("::"), Port: 80, Protocol: "TCP"},
+				Cluster:  []endpoint{{Address: mustParseAddress("fd00::1"), Port: 30080, Protocol: "TCP"}},
+			},
+		},
+	}
+	got := withNodePortListeners(lbConfig)
+	want := servicePort{
+		Listener: endpoint{Address: mustParseAddress("::"), Port: 30080, Protocol: "TCP"},
+		Cluster:  []endpoint{{Address: mustParseAddress("fd00::1"), Port: 30080, Protocol: "TCP"}},
+	}
+	if !reflect.DeepEqual(got.ServicePorts["IPv6_30080_TCP"], want) {
+		t.Errorf("withNodePortListeners() NodePort listener = %+v, want %+v", got.ServicePorts["IPv6_30080_TCP"], want)
+	}
+}
+
+func Test_tunneledNodePorts(t *testing.T) {
+	service := makeService("test")
+	service.Spec.Ports = []v1.ServicePort{
+		{Port: 80, NodePort: 30080, Protocol: v1.ProtocolTCP},
+		{Port: 53, NodePort: 30053, Protocol: v1.ProtocolUDP},
+	}
+	if got := tunneledNodePorts([]*v1.Service{service}); len(got) != 0 {
+		t.Errorf("tunneledNodePorts() = %v, want none when disabled", got)
+	}
+	config.DefaultConfig.TunnelNodePorts = true
+	defer func() { config.DefaultConfig.TunnelNodePorts = false }()
+	got := tunneledNodePorts([]*v1.Service{service})
+	want := map[string]bool{"30080": true}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("tunneledNodePorts() = %v, want %v", got, want)
+	}
+}
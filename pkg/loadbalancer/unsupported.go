@@ -0,0 +1,93 @@
+package loadbalancer
+
+import (
+	"fmt"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+
+	"sigs.k8s.io/cloud-provider-kind/pkg/config"
+	"sigs.k8s.io/cloud-provider-kind/pkg/constants"
+)
+
+// unsupportedFeatures returns the features of the Service the loadbalancers can not honor,
+// they are provisioned without them:
+//   - the ports with a protocol other than TCP and UDP are not forwarded
+//   - the session affinity of the proxies does not expire, the timeout is ignored
+//   - the ports without NodePort, allocateLoadBalancerNodePorts false, have no backends
+//     unless the traffic is sent to the host network Pods or to external backends
+//   - the allowed sources do not restrict the clients of the UDP and HTTP/3 listeners
+//   - only the requests of the ports terminating HTTP are mirrored
+//   - the new backends of the ports balanced with a hash do not start slowly
+func unsupportedFeatures(service *v1.Service) []string {
+	features := []string{}
+	for _, port := range service.Spec.Ports {
+		if port.Protocol != v1.ProtocolTCP && port.Protocol != v1.ProtocolUDP {
+			features = append(features, fmt.Sprintf("port %d/%s: the protocol is not supported", port.Port, port.Protocol))
+		}
+	}
+	if service.Spec.SessionAffinity == v1.ServiceAffinityClientIP {
+		affinity := service.Spec.SessionAffinityConfig
+		if affinity != nil && affinity.ClientIP != nil && affinity.ClientIP.TimeoutSeconds != nil && *affinity.ClientIP.TimeoutSeconds != v1.DefaultClientIPServiceAffinitySeconds {
+			features = append(features, fmt.Sprintf("sessionAffinityConfig: the timeout of %d seconds is not supported, the client IP affinity does not expire", *affinity.ClientIP.TimeoutSeconds))
+		}
+	}
+	hostNetwork := hostNetworkBackends(service)
+	externalBackends := parseExternalBackends(service)
+	for _, port := range service.Spec.Ports {
+		if port.NodePort != 0 || (port.Protocol != v1.ProtocolTCP && port.Protocol != v1.ProtocolUDP) {
+			continue
+		}
+		if _, ok := portExternalBackends(externalBackends, port); ok {
+			continue
+		}
+		if hostNetwork && (port.TargetPort.Type != intstr.String || port.TargetPort.StrVal == "") {
+			continue
+		}
+		features = append(features, fmt.Sprintf("port %d/%s: the port has no NodePort, allocateLoadBalancerNodePorts false requires the %s annotation and a numeric targetPort", port.Port, port.Protocol, constants.HostNetworkBackendsAnnotation))
+	}
+	if _, ok := service.Annotations[constants.AllowedSourcesAnnotation]; ok {
+		http3, _ := parseHTTP3(service)
+		for _, port := range service.Spec.Ports {
+			switch {
+			case port.Protocol == v1.ProtocolUDP:
+				features = append(features, fmt.Sprintf("port %d/%s: the %s annotation does not restrict the clients of the UDP ports", port.Port, port.Protocol, constants.AllowedSourcesAnnotation))
+			case port.Protocol == v1.ProtocolTCP && http3 == HTTP3Terminate:
+				features = append(features, fmt.Sprintf("port %d/%s: the %s annotation does not restrict the clients of the HTTP/3 listener", port.Port, port.Protocol, constants.AllowedSourcesAnnotation))
+			}
+		}
+	}
+	if nodePorts := parseMirror(service); len(nodePorts) > 0 {
+		http3, _ := parseHTTP3(service)
+		for _, port := range service.Spec.Ports {
+			if (port.Protocol == v1.ProtocolTCP && http3 == HTTP3Terminate) || portMirror(nodePorts, port, 0) == nil {
+				continue
+			}
+			features = append(features, fmt.Sprintf("port %d/%s: the %s annotation only mirrors the requests of the ports terminating HTTP, the connections are not copied", port.Port, port.Protocol, constants.MirrorAnnotation))
+		}
+	}
+	if _, ok := service.Annotations[constants.SlowStartWindowAnnotation]; ok {
+		http3, _ := parseHTTP3(service)
+		for _, port := range service.Spec.Ports {
+			if service.Spec.SessionAffinity == v1.ServiceAffinityClientIP || (port.Protocol == v1.ProtocolUDP && http3 == HTTP3Passthrough) {
+				features = append(features, fmt.Sprintf("port %d/%s: the %s annotation does not apply to the ports keeping the clients on the same backend", port.Port, port.Protocol, constants.SlowStartWindowAnnotation))
+			}
+		}
+	}
+	return features
+}
+
+// checkUnsupportedFeatures returns an error with the features of the Service the
+// loadbalancers can not honor with --strict, instead of provisioning a loadbalancer that
+// silently behaves differently than the Service requests
+func checkUnsupportedFeatures(service *v1.Service) error {
+	if !config.DefaultConfig.Strict {
+		return nil
+	}
+	features := unsupportedFeatures(service)
+	if len(features) == 0 {
+		return nil
+	}
+	return provisioningError(ReasonUnsupportedFeature, fmt.Errorf("the Service uses features the loadbalancers do not support: %s", strings.Join(features, "; ")))
+}
@@ -0,0 +1,136 @@
+package loadbalancer
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/utils/ptr"
+
+	"sigs.k8s.io/cloud-provider-kind/pkg/config"
+	"sigs.k8s.io/cloud-provider-kind/pkg/constants"
+)
+
+func Test_unsupportedFeatures(t *testing.T) {
+	hostNetwork := map[string]string{constants.HostNetworkBackendsAnnotation: "true"}
+	affinity := func(timeout int32) *v1.SessionAffinityConfig {
+		return &v1.SessionAffinityConfig{ClientIP: &v1.ClientIPConfig{TimeoutSeconds: ptr.To(timeout)}}
+	}
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		spec        v1.ServiceSpec
+		want        []string
+	}{
+		{
+			name: "supported",
+			spec: v1.ServiceSpec{
+				Ports:                 []v1.ServicePort{{Port: 80, NodePort: 30080, Protocol: v1.ProtocolTCP}, {Port: 53, NodePort: 30053, Protocol: v1.ProtocolUDP}},
+				SessionAffinity:       v1.ServiceAffinityClientIP,
+				SessionAffinityConfig: affinity(v1.DefaultClientIPServiceAffinitySeconds),
+			},
+			want: []string{},
+		},
+		{
+			name: "SCTP",
+			spec: v1.ServiceSpec{Ports: []v1.ServicePort{{Port: 9999, NodePort: 30999, Protocol: v1.ProtocolSCTP}}},
+			want: []string{"port 9999/SCTP: the protocol is not supported"},
+		},
+		{
+			name: "session affinity timeout",
+			spec: v1.ServiceSpec{
+				Ports:                 []v1.ServicePort{{Port: 80, NodePort: 30080, Protocol: v1.ProtocolTCP}},
+				SessionAffinity:       v1.ServiceAffinityClientIP,
+				SessionAffinityConfig: affinity(60),
+			},
+			want: []string{"sessionAffinityConfig: the timeout of 60 seconds is not supported, the client IP affinity does not expire"},
+		},
+		{
+			name: "without NodePorts",
+			spec: v1.ServiceSpec{
+				Ports:                         []v1.ServicePort{{Port: 80, Protocol: v1.ProtocolTCP}},
+				AllocateLoadBalancerNodePorts: ptr.To(false),
+			},
+			want: []string{"port 80/TCP: the port has no NodePort, allocateLoadBalancerNodePorts false requires the kind.x-k8s.io/host-network-backends annotation and a numeric targetPort"},
+		},
+		{
+			name:        "without NodePorts to the host network Pods",
+			annotations: hostNetwork,
+			spec: v1.ServiceSpec{
+				Ports:                         []v1.ServicePort{{Port: 80, TargetPort: intstr.FromInt32(8080), Protocol: v1.ProtocolTCP}},
+				AllocateLoadBalancerNodePorts: ptr.To(false),
+			},
+			want: []string{},
+		},
+		{
+			name:        "without NodePorts to a named targetPort",
+			annotations: hostNetwork,
+			spec: v1.ServiceSpec{
+				Ports:                         []v1.ServicePort{{Port: 80, TargetPort: intstr.FromString("http"), Protocol: v1.ProtocolTCP}},
+				AllocateLoadBalancerNodePorts: ptr.To(false),
+			},
+			want: []string{"port 80/TCP: the port has no NodePort, allocateLoadBalancerNodePorts false requires the kind.x-k8s.io/host-network-backends annotation and a numeric targetPort"},
+		},
+		{
+			name:        "without NodePorts to external backends",
+			annotations: map[string]string{constants.ExternalBackendsAnnotation: "80=db.example.com:5432"},
+			spec: v1.ServiceSpec{
+				Ports:                         []v1.ServicePort{{Port: 80, Protocol: v1.ProtocolTCP}, {Port: 443, Protocol: v1.ProtocolTCP}},
+				AllocateLoadBalancerNodePorts: ptr.To(false),
+			},
+			want: []string{"port 443/TCP: the port has no NodePort, allocateLoadBalancerNodePorts false requires the kind.x-k8s.io/host-network-backends annotation and a numeric targetPort"},
+		},
+		{
+			name:        "allowed sources of UDP ports",
+			annotations: map[string]string{constants.AllowedSourcesAnnotation: "10.0.0.0/8"},
+			spec:        v1.ServiceSpec{Ports: []v1.ServicePort{{Port: 80, NodePort: 30080, Protocol: v1.ProtocolTCP}, {Port: 53, NodePort: 30053, Protocol: v1.ProtocolUDP}}},
+			want:        []string{"port 53/UDP: the kind.x-k8s.io/allowed-sources annotation does not restrict the clients of the UDP ports"},
+		},
+		{
+			name:        "mirror of the ports proxied at L4",
+			annotations: map[string]string{constants.MirrorAnnotation: "80=31080"},
+			spec:        v1.ServiceSpec{Ports: []v1.ServicePort{{Port: 80, NodePort: 30080, Protocol: v1.ProtocolTCP}, {Port: 53, NodePort: 30053, Protocol: v1.ProtocolUDP}}},
+			want:        []string{"port 80/TCP: the kind.x-k8s.io/mirror annotation only mirrors the requests of the ports terminating HTTP, the connections are not copied"},
+		},
+		{
+			name:        "slow start with session affinity",
+			annotations: map[string]string{constants.SlowStartWindowAnnotation: "30s"},
+			spec: v1.ServiceSpec{
+				Ports:           []v1.ServicePort{{Port: 80, NodePort: 30080, Protocol: v1.ProtocolTCP}},
+				SessionAffinity: v1.ServiceAffinityClientIP,
+			},
+			want: []string{"port 80/TCP: the kind.x-k8s.io/slow-start-window annotation does not apply to the ports keeping the clients on the same backend"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service := &v1.Service{ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default", Annotations: tt.annotations}, Spec: tt.spec}
+			if diff := cmp.Diff(tt.want, unsupportedFeatures(service)); diff != "" {
+				t.Errorf("unsupportedFeatures() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func Test_checkUnsupportedFeatures(t *testing.T) {
+	defer func(strict bool) { config.DefaultConfig.Strict = strict }(config.DefaultConfig.Strict)
+	service := &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default"},
+		Spec:       v1.ServiceSpec{Ports: []v1.ServicePort{{Port: 9999, NodePort: 30999, Protocol: v1.ProtocolSCTP}}},
+	}
+
+	config.DefaultConfig.Strict = false
+	if err := checkUnsupportedFeatures(service); err != nil {
+		t.Errorf("checkUnsupportedFeatures() without --strict error = %v", err)
+	}
+
+	config.DefaultConfig.Strict = true
+	err := checkUnsupportedFeatures(service)
+	var provisioningErr *ProvisioningError
+	if !errors.As(err, &provisioningErr) || provisioningErr.Reason != ReasonUnsupportedFeature {
+		t.Errorf("checkUnsupportedFeatures() with --strict error = %v, want the %s reason", err, ReasonUnsupportedFeature)
+	}
+}
@@ -0,0 +1,79 @@
+package loadbalancer
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"sigs.k8s.io/yaml"
+
+	"sigs.k8s.io/cloud-provider-kind/pkg/container"
+)
+
+// proxyValidatePath is the file with the config validated in the loadbalancer container,
+// envoy detects the format from the extension
+const proxyValidatePath = "/etc/envoy/validate.yaml"
+
+// validateProxyConfig checks the config with the envoy of the loadbalancer container
+// before applying it, so an invalid config is refused and the proxy keeps the last good
+// one. The clusters are loaded dynamically and not checked by the validation mode, they
+// are validated as static clusters of the bootstrap config.
+func validateProxyConfig(name string, config proxyFiles) error {
+	bootstrap, err := validationBootstrap(config)
+	if err != nil {
+		return provisioningError(ReasonInvalidConfig, err)
+	}
+	err = container.Exec(name, []string{"cp", "/dev/stdin", proxyValidatePath}, strings.NewReader(bootstrap), nil, nil)
+	if err != nil {
+		return err
+	}
+	defer container.Exec(name, []string{"rm", "-f", proxyValidatePath}, nil, nil, nil) // nolint:errcheck
+
+	var output bytes.Buffer
+	err = container.Exec(name, []string{"envoy", "--mode", "validate", "--log-level", "error", "-c", proxyValidatePath}, nil, &output, &output)
+	if err != nil {
+		return provisioningError(ReasonInvalidConfig, fmt.Errorf("invalid loadbalancer config: %w: %s", err, strings.TrimSpace(output.String())))
+	}
+	return nil
+}
+
+// validationBootstrap returns the bootstrap config with the clusters as static resources
+// instead of loading them from the clusters file
+func validationBootstrap(config proxyFiles) (string, error) {
+	bootstrap := map[string]interface{}{}
+	if err := yaml.Unmarshal([]byte(config.Bootstrap), &bootstrap); err != nil {
+		return "", fmt.Errorf("invalid bootstrap config: %w", err)
+	}
+	var clusters struct {
+		Resources []map[string]interface{} `json:"resources"`
+	}
+	if err := yaml.Unmarshal([]byte(config.Clusters), &clusters); err != nil {
+		return "", fmt.Errorf("invalid clusters config: %w", err)
+	}
+
+	staticResources, _ := bootstrap["static_resources"].(map[string]interface{})
+	if staticResources == nil {
+		staticResources = map[string]interface{}{}
+	}
+	static, _ := staticResources["clusters"].([]interface{})
+	for _, cluster := range clusters.Resources {
+		// the static clusters are not typed resources
+		delete(cluster, "@type")
+		static = append(static, cluster)
+	}
+	if len(static) > 0 {
+		staticResources["clusters"] = static
+	}
+	bootstrap["static_resources"] = staticResources
+	if dynamicResources, ok := bootstrap["dynamic_resources"].(map[string]interface{}); ok {
+		delete(dynamicResources, "cds_config")
+		if len(dynamicResources) == 0 {
+			delete(bootstrap, "dynamic_resources")
+		}
+	}
+	out, err := yaml.Marshal(bootstrap)
+	if err != nil {
+		return "", err
+	}
+	return string(out), nil
+}
@@ -0,0 +1,112 @@
+package loadbalancer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/yaml"
+
+	"sigs.k8s.io/cloud-provider-kind/pkg/config"
+	"sigs.k8s.io/cloud-provider-kind/pkg/container"
+)
+
+func validateTestConfig() *proxyConfigData {
+	service := makeService("test")
+	service.Spec.Type = v1.ServiceTypeLoadBalancer
+	service.Spec.IPFamilies = []v1.IPFamily{v1.IPv4Protocol}
+	service.Spec.Ports[0].NodePort = 30000
+	service.Spec.Ports[0].Protocol = v1.ProtocolTCP
+	return generateConfig(service, []*v1.Node{makeNode("a", "10.0.0.1")})
+}
+
+func Test_validationBootstrap(t *testing.T) {
+	files, err := proxyConfig(validateTestConfig())
+	if err != nil {
+		t.Fatalf("proxyConfig() error = %v", err)
+	}
+	got, err := validationBootstrap(files)
+	if err != nil {
+		t.Fatalf("validationBootstrap() error = %v", err)
+	}
+	var bootstrap struct {
+		DynamicResources map[string]interface{} `json:"dynamic_resources"`
+		StaticResources  struct {
+			Listeners []map[string]interface{} `json:"listeners"`
+			Clusters  []map[string]interface{} `json:"clusters"`
+		} `json:"static_resources"`
+	}
+	if err := yaml.Unmarshal([]byte(got), &bootstrap); err != nil {
+		t.Fatalf("invalid validation config: %v\n%s", err, got)
+	}
+	if bootstrap.DynamicResources != nil {
+		t.Errorf("validation config loads the dynamic resources: %v", bootstrap.DynamicResources)
+	}
+	if len(bootstrap.StaticResources.Listeners) != 1 || len(bootstrap.StaticResources.Clusters) != 1 {
+		t.Fatalf("validation config has %d listeners and %d clusters, want 1 and 1", len(bootstrap.StaticResources.Listeners), len(bootstrap.StaticResources.Clusters))
+	}
+	cluster := bootstrap.StaticResources.Clusters[0]
+	if _, ok := cluster["@type"]; ok || !strings.HasPrefix(cluster["name"].(string), "cluster_") {
+		t.Errorf("unexpected static cluster %v", cluster)
+	}
+}
+
+func Test_proxyUpdateLoadBalancerValidation(t *testing.T) {
+	defer func(value bool) { config.DefaultConfig.ValidateProxyConfig = value }(config.DefaultConfig.ValidateProxyConfig)
+	config.DefaultConfig.ValidateProxyConfig = true
+	data := validateTestConfig()
+	files, err := proxyConfig(data)
+	if err != nil {
+		t.Fatalf("proxyConfig() error = %v", err)
+	}
+
+	fake := container.NewFake()
+	defer container.SetRuntime(fake)()
+	// the listeners did not change, only the clusters are updated
+	fake.AddContainer(&container.FakeContainer{Name: "lb", Running: true, Files: map[string]string{
+		proxyConfigPath:   files.Bootstrap,
+		proxyClustersPath: "resources: []",
+	}})
+	valid := false
+	validated := ""
+	fake.ExecHook = func(name string, command []string, stdout io.Writer) (bool, error) {
+		if len(command) == 0 || command[0] != "envoy" {
+			return false, nil
+		}
+		validated = fake.Container("lb").Files[command[len(command)-1]]
+		if !valid {
+			fmt.Fprint(stdout, "error initializing configuration: unknown field")
+			return true, errors.New("exit status 1")
+		}
+		return true, nil
+	}
+
+	updated, restarted, err := proxyUpdateLoadBalancer(context.Background(), "lb", data, newConfigHashes())
+	var provisioningErr *ProvisioningError
+	if !errors.As(err, &provisioningErr) || provisioningErr.Reason != ReasonInvalidConfig || !strings.Contains(err.Error(), "unknown field") {
+		t.Fatalf("proxyUpdateLoadBalancer() error = %v, want the validation error", err)
+	}
+	if updated || restarted {
+		t.Errorf("proxyUpdateLoadBalancer() updated = %v, restarted = %v, want the invalid config refused", updated, restarted)
+	}
+	lb := fake.Container("lb")
+	if lb.Files[proxyClustersPath] != "resources: []" {
+		t.Errorf("invalid config applied:\n%s", lb.Files[proxyClustersPath])
+	}
+	if _, ok := lb.Files[proxyValidatePath]; ok || !strings.Contains(validated, "cluster_") {
+		t.Errorf("validation config not removed or without the clusters:\n%s", validated)
+	}
+
+	valid = true
+	updated, restarted, err = proxyUpdateLoadBalancer(context.Background(), "lb", data, newConfigHashes())
+	if err != nil || !updated || restarted {
+		t.Fatalf("proxyUpdateLoadBalancer() = %v, %v, %v, want the clusters updated", updated, restarted, err)
+	}
+	if fake.Container("lb").Files[proxyClustersPath] != files.Clusters {
+		t.Errorf("valid config not applied")
+	}
+}
@@ -0,0 +1,252 @@
+package loadbalancer
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+
+	bootstrapv3 "github.com/envoyproxy/go-control-plane/envoy/config/bootstrap/v3"
+	discoveryv3 "github.com/envoyproxy/go-control-plane/envoy/service/discovery/v3"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/types/known/anypb"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/yaml"
+
+	"sigs.k8s.io/cloud-provider-kind/pkg/config"
+)
+
+// the resources served by the xDS server, the listeners of the bootstrap config and the
+// clusters of the clusters file
+const (
+	listenerTypeURL = "type.googleapis.com/envoy.config.listener.v3.Listener"
+	clusterTypeURL  = "type.googleapis.com/envoy.config.cluster.v3.Cluster"
+)
+
+// xdsConfig is the config of a loadbalancer served to the external envoys
+type xdsConfig struct {
+	// version is the hash of the config files
+	version   string
+	listeners []*anypb.Any
+	clusters  []*anypb.Any
+}
+
+// xdsServer serves the config of the loadbalancers with the envoy aggregated discovery
+// service, so envoys outside of the container network, ex. on other machines of the LAN,
+// proxy the Services like the loadbalancer containers. The node id of the envoys is the
+// name of the loadbalancer container, each one gets the listeners and clusters applied to
+// it, state of the world, and the updates as soon as they are applied.
+type xdsServer struct {
+	discoveryv3.UnimplementedAggregatedDiscoveryServiceServer
+
+	mu       sync.Mutex
+	configs  map[string]*xdsConfig // key is the loadbalancer container name
+	watchers map[chan struct{}]bool
+}
+
+func newXDSServer() *xdsServer {
+	return &xdsServer{configs: map[string]*xdsConfig{}, watchers: map[chan struct{}]bool{}}
+}
+
+// xds is the server of all the loadbalancers of the clusters
+var xds = newXDSServer()
+
+// ServeXDS serves the config of the loadbalancers on the address until the context is
+// cancelled. It is not authenticated, like the config in the loadbalancer containers.
+func ServeXDS(ctx context.Context, address string) error {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return err
+	}
+	server := grpc.NewServer()
+	discoveryv3.RegisterAggregatedDiscoveryServiceServer(server, xds)
+	go func() {
+		<-ctx.Done()
+		server.Stop()
+	}()
+	klog.InfoS("Serving the loadbalancers config with xDS", "address", address)
+	return server.Serve(listener)
+}
+
+// publishXDS publishes the config applied to the loadbalancer container, if the xDS
+// server is enabled
+func publishXDS(name string, files proxyFiles) {
+	if config.DefaultConfig.XDSAddress == "" {
+		return
+	}
+	cfg, err := newXDSConfig(files)
+	if err != nil {
+		// ex. the filters of a user template not known by the controller
+		klog.ErrorS(err, "Failed to publish the loadbalancer config with xDS", "loadbalancer", name)
+		return
+	}
+	xds.set(name, cfg)
+}
+
+// unpublishXDS removes the config of the deleted loadbalancer container
+func unpublishXDS(name string) {
+	xds.set(name, nil)
+}
+
+// newXDSConfig parses the rendered config files with the envoy API types
+func newXDSConfig(files proxyFiles) (*xdsConfig, error) {
+	data, err := yaml.YAMLToJSON([]byte(files.Bootstrap))
+	if err != nil {
+		return nil, err
+	}
+	bootstrap := &bootstrapv3.Bootstrap{}
+	if err := protojson.Unmarshal(data, bootstrap); err != nil {
+		return nil, err
+	}
+	cfg := &xdsConfig{version: configHash(files)}
+	for _, listener := range bootstrap.GetStaticResources().GetListeners() {
+		resource, err := anypb.New(listener)
+		if err != nil {
+			return nil, err
+		}
+		cfg.listeners = append(cfg.listeners, resource)
+	}
+	// the clusters file has the format of a discovery response
+	data, err = yaml.YAMLToJSON([]byte(files.Clusters))
+	if err != nil {
+		return nil, err
+	}
+	clusters := &discoveryv3.DiscoveryResponse{}
+	if err := protojson.Unmarshal(data, clusters); err != nil {
+		return nil, err
+	}
+	cfg.clusters = clusters.Resources
+	return cfg, nil
+}
+
+// set replaces the config of the loadbalancer, nil removes it, and notifies the streams
+func (s *xdsServer) set(name string, cfg *xdsConfig) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if cfg == nil {
+		if _, ok := s.configs[name]; !ok {
+			return
+		}
+		delete(s.configs, name)
+	} else {
+		s.configs[name] = cfg
+	}
+	for watcher := range s.watchers {
+		select {
+		case watcher <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// get returns the config of the loadbalancer, empty if it does not exist
+func (s *xdsServer) get(name string) *xdsConfig {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if cfg, ok := s.configs[name]; ok {
+		return cfg
+	}
+	return &xdsConfig{}
+}
+
+func (s *xdsServer) watch() chan struct{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	watcher := make(chan struct{}, 1)
+	s.watchers[watcher] = true
+	return watcher
+}
+
+func (s *xdsServer) unwatch(watcher chan struct{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.watchers, watcher)
+}
+
+// StreamAggregatedResources sends the listeners and the clusters requested by the envoy
+// every time the config of its loadbalancer changes. The responses are not resent on the
+// ACKs and NACKs, a rejected config is only replaced by the next update.
+func (s *xdsServer) StreamAggregatedResources(stream discoveryv3.AggregatedDiscoveryService_StreamAggregatedResourcesServer) error {
+	ctx := stream.Context()
+	requests := make(chan *discoveryv3.DiscoveryRequest)
+	errs := make(chan error, 1)
+	go func() {
+		for {
+			request, err := stream.Recv()
+			if err != nil {
+				errs <- err
+				return
+			}
+			select {
+			case requests <- request:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	changed := s.watch()
+	defer s.unwatch(changed)
+
+	node := ""
+	nonce := 0
+	// the versions sent of each type of resources requested
+	sent := map[string]string{}
+	send := func(typeURL string) error {
+		cfg := s.get(node)
+		if version, ok := sent[typeURL]; ok && version == cfg.version {
+			return nil
+		}
+		resources := cfg.listeners
+		if typeURL == clusterTypeURL {
+			resources = cfg.clusters
+		}
+		nonce++
+		err := stream.Send(&discoveryv3.DiscoveryResponse{
+			VersionInfo: cfg.version,
+			Resources:   resources,
+			TypeUrl:     typeURL,
+			Nonce:       strconv.Itoa(nonce),
+		})
+		if err != nil {
+			return err
+		}
+		sent[typeURL] = cfg.version
+		return nil
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case err := <-errs:
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		case request := <-requests:
+			if node == "" {
+				node = request.GetNode().GetId()
+				klog.V(2).InfoS("xDS client connected", "loadbalancer", node)
+			}
+			if request.ErrorDetail != nil {
+				klog.InfoS("xDS client rejected the loadbalancer config", "loadbalancer", node, "type", request.TypeUrl, "version", request.VersionInfo, "err", request.ErrorDetail.Message)
+			}
+			if request.TypeUrl != listenerTypeURL && request.TypeUrl != clusterTypeURL {
+				klog.V(2).InfoS("Ignoring the xDS request of an unsupported type", "loadbalancer", node, "type", request.TypeUrl)
+				continue
+			}
+			if err := send(request.TypeUrl); err != nil {
+				return err
+			}
+		case <-changed:
+			for typeURL := range sent {
+				if err := send(typeURL); err != nil {
+					return err
+				}
+			}
+		}
+	}
+}
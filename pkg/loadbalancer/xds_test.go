@@ -0,0 +1,107 @@
+package loadbalancer
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	discoveryv3 "github.com/envoyproxy/go-control-plane/envoy/service/discovery/v3"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+func TestXDSServer(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	xdsServer := newXDSServer()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := grpc.NewServer()
+	discoveryv3.RegisterAggregatedDiscoveryServiceServer(server, xdsServer)
+	go server.Serve(listener) // nolint:errcheck
+	defer server.Stop()
+
+	conn, err := grpc.NewClient(listener.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	stream, err := discoveryv3.NewAggregatedDiscoveryServiceClient(conn).StreamAggregatedResources(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	node := &corev3.Node{Id: "kindccm-test"}
+
+	publish := func(data *proxyConfigData) string {
+		t.Helper()
+		files, err := proxyConfig(data)
+		if err != nil {
+			t.Fatalf("proxyConfig() error = %v", err)
+		}
+		cfg, err := newXDSConfig(files)
+		if err != nil {
+			t.Fatalf("newXDSConfig() error = %v", err)
+		}
+		xdsServer.set("kindccm-test", cfg)
+		return cfg.version
+	}
+	// receive returns the responses by type
+	receive := func(count int) map[string]*discoveryv3.DiscoveryResponse {
+		t.Helper()
+		responses := map[string]*discoveryv3.DiscoveryResponse{}
+		for i := 0; i < count; i++ {
+			response, err := stream.Recv()
+			if err != nil {
+				t.Fatalf("Recv() error = %v", err)
+			}
+			responses[response.TypeUrl] = response
+		}
+		return responses
+	}
+	request := func(typeURL string, ack *discoveryv3.DiscoveryResponse) {
+		t.Helper()
+		req := &discoveryv3.DiscoveryRequest{Node: node, TypeUrl: typeURL}
+		if ack != nil {
+			req.VersionInfo, req.ResponseNonce = ack.VersionInfo, ack.Nonce
+		}
+		if err := stream.Send(req); err != nil {
+			t.Fatalf("Send() error = %v", err)
+		}
+	}
+
+	version := publish(sampleProxyConfigData(""))
+	request(clusterTypeURL, nil)
+	clusters := receive(1)[clusterTypeURL]
+	if clusters == nil || clusters.VersionInfo != version || len(clusters.Resources) != 2 {
+		t.Fatalf("clusters response = %v, want the 2 clusters of version %s", clusters, version)
+	}
+	request(clusterTypeURL, clusters)
+	request(listenerTypeURL, nil)
+	listeners := receive(1)[listenerTypeURL]
+	if listeners == nil || listeners.VersionInfo != version || len(listeners.Resources) != 2 {
+		t.Fatalf("listeners response = %v, want the 2 listeners of version %s", listeners, version)
+	}
+	request(listenerTypeURL, listeners)
+
+	// the updates are pushed for all the types requested
+	version = publish(sampleProxyConfigData("ClientIP"))
+	responses := receive(2)
+	for _, typeURL := range []string{clusterTypeURL, listenerTypeURL} {
+		if response := responses[typeURL]; response == nil || response.VersionInfo != version || len(response.Resources) != 2 {
+			t.Errorf("%s response = %v, want version %s", typeURL, response, version)
+		}
+	}
+
+	// the deleted loadbalancers have no resources
+	xdsServer.set("kindccm-test", nil)
+	responses = receive(2)
+	for _, typeURL := range []string{clusterTypeURL, listenerTypeURL} {
+		if response := responses[typeURL]; response == nil || len(response.Resources) != 0 {
+			t.Errorf("%s response = %v, want no resources", typeURL, response)
+		}
+	}
+}
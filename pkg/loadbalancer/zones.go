@@ -0,0 +1,229 @@
+package loadbalancer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+
+	"sigs.k8s.io/cloud-provider-kind/pkg/constants"
+	"sigs.k8s.io/cloud-provider-kind/pkg/container"
+)
+
+// ParseLoadBalancerZones parses the comma separated list of zones of --loadbalancer-zones,
+// they are part of the names of the proxy containers so they must be DNS labels
+func ParseLoadBalancerZones(value string) ([]string, error) {
+	if value == "" {
+		return nil, nil
+	}
+	zones := []string{}
+	seen := map[string]bool{}
+	for _, zone := range strings.Split(value, ",") {
+		zone = strings.TrimSpace(zone)
+		if errs := validation.IsDNS1123Label(zone); len(errs) > 0 {
+			return nil, fmt.Errorf("invalid zone %q: %s", zone, strings.Join(errs, ", "))
+		}
+		if seen[zone] {
+			return nil, fmt.Errorf("duplicate zone %q", zone)
+		}
+		seen[zone] = true
+		zones = append(zones, zone)
+	}
+	return zones, nil
+}
+
+// zoneReplica is the proxy of a loadbalancer in one of the other zones
+type zoneReplica struct {
+	zone string
+	name string
+}
+
+// loadBalancerZones returns the zones of the proxies of the Service, empty for a single
+// proxy. The shared and the adopted loadbalancers are not replicated, their containers are
+// managed by the other Services or by the users.
+func (s *Server) loadBalancerZones(service *v1.Service) []string {
+	if service == nil || sharedIPKey(service) != "" || adoptedContainer(service) != "" {
+		return nil
+	}
+	return s.zones
+}
+
+// primaryZone returns the zone of the loadbalancer container of the Service, the first zone
+func (s *Server) primaryZone(service *v1.Service) string {
+	zones := s.loadBalancerZones(service)
+	if len(zones) == 0 {
+		return ""
+	}
+	return zones[0]
+}
+
+// zoneReplicas returns the proxies of the other zones of the loadbalancer, the first zone
+// is served by the loadbalancer container so the operations on it keep working unchanged
+func (s *Server) zoneReplicas(name string, service *v1.Service) []zoneReplica {
+	zones := s.loadBalancerZones(service)
+	if len(zones) < 2 {
+		return nil
+	}
+	replicas := []zoneReplica{}
+	for _, zone := range zones[1:] {
+		replicas = append(replicas, zoneReplica{zone: zone, name: name + "-" + zone})
+	}
+	return replicas
+}
+
+// zoneNodes returns the nodes of the zone, the proxy of each zone only sends the traffic to
+// its zone like the cloud loadbalancers without cross-zone loadbalancing. The zones without
+// nodes use all of them to not drop the traffic.
+func zoneNodes(zone string, nodes []*v1.Node) []*v1.Node {
+	if zone == "" {
+		return nodes
+	}
+	filtered := []*v1.Node{}
+	for _, node := range nodes {
+		if node.Labels[v1.LabelTopologyZone] == zone {
+			filtered = append(filtered, node)
+		}
+	}
+	if len(filtered) == 0 {
+		return nodes
+	}
+	return filtered
+}
+
+// ensureZoneReplicas provisions the missing proxies of the other zones of the loadbalancer,
+// each one as its own transaction, and deletes the proxies of the zones no longer
+// configured. The caller must hold the loadbalancer lock.
+func (s *Server) ensureZoneReplicas(ctx context.Context, clusterName string, service *v1.Service, nodes []*v1.Node) error {
+	name := loadBalancerName(clusterName, service)
+	replicas := s.zoneReplicas(name, service)
+	zones := []string{}
+	for _, replica := range replicas {
+		zones = append(zones, replica.zone)
+	}
+	err := s.deleteZoneReplicas(name, zones)
+	if err != nil {
+		return err
+	}
+	networks := ClusterNetworks(clusterName)
+	for _, replica := range replicas {
+		err := s.ensureZoneReplica(ctx, clusterName, replica, service, nodes, networks)
+		if err != nil {
+			return fmt.Errorf("failed to provision the loadbalancer of zone %s: %w", replica.zone, err)
+		}
+	}
+	return nil
+}
+
+// ensureZoneReplica provisions the proxy of the zone like the loadbalancer container
+func (s *Server) ensureZoneReplica(ctx context.Context, clusterName string, replica zoneReplica, service *v1.Service, nodes []*v1.Node, networks []string) error {
+	ctx, logger := withContainer(ctx, replica.name)
+	exists := container.Exist(replica.name)
+	if exists && (!container.IsRunning(replica.name) || !isCommitted(replica.name)) {
+		logger.V(2).Info("Deleting stale loadbalancer zone container")
+		err := deleteContainer(replica.name)
+		if err != nil {
+			return err
+		}
+		s.configHashes.forget(replica.name)
+		exists = false
+	}
+	if exists {
+		return nil
+	}
+
+	tx := newTransaction(ctx, replica.name)
+	logger.V(2).Info("Creating loadbalancer zone container", "zone", replica.zone)
+	services := []*v1.Service{service}
+	release, err := s.reserveQuota(clusterName)
+	if err != nil {
+		return tx.rollback(err)
+	}
+	err = s.createLoadBalancer(clusterName, replica.name, replica.zone, service, services, networks[0], proxyImage)
+	release()
+	if err != nil {
+		return tx.rollback(err)
+	}
+	s.configHashes.forget(replica.name)
+	tx.onRollback(func() error {
+		return deleteContainer(replica.name)
+	})
+	err = ensureNetworks(replica.name, networks)
+	if err != nil {
+		return tx.rollback(err)
+	}
+	svcIPv4, svcIPv6 := serviceIPFamilies(service)
+	err = waitForIPs(ctx, replica.name, svcIPv4, svcIPv6)
+	if err != nil {
+		return tx.rollback(provisioningError(ReasonIPAllocationFailed, fmt.Errorf("loadbalancer %s IPs not allocated: %w", replica.name, err)))
+	}
+	err = ensureAdvertisement(ctx, clusterName, replica.name)
+	if err != nil {
+		return tx.rollback(provisioningError(ReasonIPAllocationFailed, err))
+	}
+	lbConfig, err := sharedConfig(service, services, zoneNodes(replica.zone, nodes))
+	if err != nil {
+		return tx.rollback(err)
+	}
+	err = s.applyLoadBalancerConfig(ctx, clusterName, replica.name, services, lbConfig)
+	if err != nil {
+		return tx.rollback(provisioningError(ReasonConfigFailed, err))
+	}
+	err = waitForListeners(ctx, replica.name, lbConfig)
+	if err != nil {
+		return tx.rollback(provisioningError(ReasonConfigFailed, err))
+	}
+	err = commit(replica.name)
+	if err != nil {
+		return tx.rollback(err)
+	}
+	return nil
+}
+
+// updateZoneReplicas applies the config of the Service to the existing proxies of the other
+// zones, with the nodes of their zone, the missing ones are created by EnsureLoadBalancer
+func (s *Server) updateZoneReplicas(ctx context.Context, clusterName string, service *v1.Service, services []*v1.Service, nodes []*v1.Node) error {
+	var errs []error
+	for _, replica := range s.zoneReplicas(loadBalancerName(clusterName, service), service) {
+		if !container.Exist(replica.name) {
+			continue
+		}
+		lbConfig, err := sharedConfig(service, services, zoneNodes(replica.zone, nodes))
+		if err != nil {
+			return err
+		}
+		replicaCtx, _ := withContainer(ctx, replica.name)
+		errs = append(errs, s.applyLoadBalancerConfig(replicaCtx, clusterName, replica.name, services, lbConfig))
+	}
+	return errors.Join(errs...)
+}
+
+// deleteZoneReplicas deletes the proxies of the other zones of the loadbalancer, except the
+// proxies of the zones to keep. The zones are looked up on the labels of all the proxies,
+// the zones removed from the configuration still have to be deleted.
+func (s *Server) deleteZoneReplicas(name string, keep []string) error {
+	containers, err := container.ListLabelValues(constants.LoadBalancerZoneLabelKey, constants.LoadBalancerZoneLabelKey)
+	if err != nil {
+		return err
+	}
+	zones := map[string]bool{}
+	for _, zone := range containers {
+		zones[zone] = true
+	}
+	for _, zone := range keep {
+		delete(zones, zone)
+	}
+	var errs []error
+	for zone := range zones {
+		replica := name + "-" + zone
+		if zone == "" || !container.Exist(replica) {
+			continue
+		}
+		errs = append(errs, deleteContainer(replica))
+		s.configHashes.forget(replica)
+		s.healed.Delete(replica)
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,170 @@
+package loadbalancer
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/ptr"
+
+	"sigs.k8s.io/cloud-provider-kind/pkg/constants"
+	"sigs.k8s.io/cloud-provider-kind/pkg/container"
+)
+
+func TestParseLoadBalancerZones(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    []string
+		wantErr bool
+	}{
+		{value: ""},
+		{value: "kind-region-a", want: []string{"kind-region-a"}},
+		{value: "us-east-1a, us-east-1b", want: []string{"us-east-1a", "us-east-1b"}},
+		{value: "a,a", wantErr: true},
+		{value: "a,,b", wantErr: true},
+		{value: "Zone_A", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := ParseLoadBalancerZones(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseLoadBalancerZones() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("ParseLoadBalancerZones() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func Test_zoneNodes(t *testing.T) {
+	zoneNode := func(name string, zone string) *v1.Node {
+		node := makeNode(name, "10.0.0.1")
+		node.Labels = map[string]string{v1.LabelTopologyZone: zone}
+		return node
+	}
+	nodes := []*v1.Node{zoneNode("a1", "a"), zoneNode("b1", "b"), zoneNode("a2", "a")}
+	names := func(nodes []*v1.Node) []string {
+		got := []string{}
+		for _, node := range nodes {
+			got = append(got, node.Name)
+		}
+		return got
+	}
+	tests := []struct {
+		zone string
+		want []string
+	}{
+		{zone: "", want: []string{"a1", "b1", "a2"}},
+		{zone: "a", want: []string{"a1", "a2"}},
+		{zone: "b", want: []string{"b1"}},
+		// the zones without nodes send the traffic to all of them
+		{zone: "c", want: []string{"a1", "b1", "a2"}},
+	}
+	for _, tt := range tests {
+		if diff := cmp.Diff(tt.want, names(zoneNodes(tt.zone, nodes))); diff != "" {
+			t.Errorf("zoneNodes(%q) mismatch (-want +got):\n%s", tt.zone, diff)
+		}
+	}
+}
+
+func TestEnsureLoadBalancerZones(t *testing.T) {
+	fake := container.NewFake()
+	defer container.SetRuntime(fake)()
+	fake.AddContainer(&container.FakeContainer{
+		Name:     "kind-control-plane",
+		Image:    "kindest/node:v1.30.0",
+		Labels:   map[string]string{constants.KindClusterLabelKey: "kind"},
+		Networks: []string{"kind"},
+		IPv4:     "172.18.0.2",
+		Running:  true,
+	})
+	fake.ExecHook = fakeListeners(80)
+
+	s := NewServer(nil, nil, nil).(*Server)
+	s.tunnelManager = nil
+	s.hostAddress = ""
+	s.publishUnready = true
+	s.zones = []string{"zone-a", "zone-b"}
+	service := &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default"},
+		Spec: v1.ServiceSpec{
+			Type:       v1.ServiceTypeLoadBalancer,
+			IPFamilies: []v1.IPFamily{v1.IPv4Protocol},
+			Ports:      []v1.ServicePort{{Port: 80, NodePort: 30080, Protocol: v1.ProtocolTCP}},
+		},
+	}
+	nodeA, nodeB := makeNode("kind-worker", "172.18.0.3"), makeNode("kind-worker2", "172.18.0.4")
+	nodeA.Labels = map[string]string{v1.LabelTopologyZone: "zone-a"}
+	nodeB.Labels = map[string]string{v1.LabelTopologyZone: "zone-b"}
+	nodes := []*v1.Node{nodeA, nodeB}
+	name := loadBalancerName("kind", service)
+
+	status, err := s.EnsureLoadBalancer(context.Background(), "kind", service, nodes)
+	if err != nil {
+		t.Fatalf("EnsureLoadBalancer() unexpected error: %v", err)
+	}
+	lbA, lbB := fake.Container(name), fake.Container(name+"-zone-b")
+	if lbA == nil || lbB == nil {
+		t.Fatalf("loadbalancer containers of the zones not created")
+	}
+	if lbA.Labels[constants.LoadBalancerZoneLabelKey] != "zone-a" || lbB.Labels[constants.LoadBalancerZoneLabelKey] != "zone-b" {
+		t.Errorf("loadbalancer containers zones = %q, %q", lbA.Labels[constants.LoadBalancerZoneLabelKey], lbB.Labels[constants.LoadBalancerZoneLabelKey])
+	}
+	if _, ok := lbB.Files[proxyCommitPath]; !ok {
+		t.Errorf("loadbalancer of zone-b not committed")
+	}
+	// each proxy only sends the traffic to the nodes of its zone
+	if clusters := lbA.Files[proxyClustersPath]; !strings.Contains(clusters, "172.18.0.3") || strings.Contains(clusters, "172.18.0.4") {
+		t.Errorf("loadbalancer of zone-a clusters:\n%s", clusters)
+	}
+	if clusters := lbB.Files[proxyClustersPath]; !strings.Contains(clusters, "172.18.0.4") || strings.Contains(clusters, "172.18.0.3") {
+		t.Errorf("loadbalancer of zone-b clusters:\n%s", clusters)
+	}
+	ports := []v1.PortStatus{{Port: 80, Protocol: v1.ProtocolTCP}}
+	proxy := ptr.To(v1.LoadBalancerIPModeProxy)
+	want := []v1.LoadBalancerIngress{{IP: lbA.IPv4, IPMode: proxy, Ports: ports}, {IP: lbB.IPv4, IPMode: proxy, Ports: ports}}
+	if lbA.IPv4 == lbB.IPv4 {
+		t.Errorf("loadbalancers of the zones have the same IP %s", lbA.IPv4)
+	}
+	if diff := cmp.Diff(want, status.Ingress); diff != "" {
+		t.Errorf("EnsureLoadBalancer() status mismatch (-want +got):\n%s", diff)
+	}
+
+	// the nodes of the zones are updated on every proxy
+	nodes = append(nodes, makeNode("kind-worker3", "172.18.0.5"))
+	nodes[2].Labels = map[string]string{v1.LabelTopologyZone: "zone-b"}
+	err = s.UpdateLoadBalancer(context.Background(), "kind", service, nodes)
+	if err != nil {
+		t.Fatalf("UpdateLoadBalancer() unexpected error: %v", err)
+	}
+	if clusters := fake.Container(name + "-zone-b").Files[proxyClustersPath]; !strings.Contains(clusters, "172.18.0.5") {
+		t.Errorf("loadbalancer of zone-b not updated:\n%s", clusters)
+	}
+
+	// the proxies of the zones no longer configured are deleted
+	s.zones = []string{"zone-a"}
+	status, err = s.EnsureLoadBalancer(context.Background(), "kind", service, nodes)
+	if err != nil {
+		t.Fatalf("EnsureLoadBalancer() unexpected error: %v", err)
+	}
+	if fake.Container(name+"-zone-b") != nil || len(status.Ingress) != 1 {
+		t.Errorf("loadbalancer of zone-b not deleted, status %+v", status.Ingress)
+	}
+
+	s.zones = []string{"zone-a", "zone-b"}
+	_, err = s.EnsureLoadBalancer(context.Background(), "kind", service, nodes)
+	if err != nil {
+		t.Fatalf("EnsureLoadBalancer() unexpected error: %v", err)
+	}
+	err = s.EnsureLoadBalancerDeleted(context.Background(), "kind", service)
+	if err != nil {
+		t.Fatalf("EnsureLoadBalancerDeleted() unexpected error: %v", err)
+	}
+	if fake.Container(name) != nil || fake.Container(name+"-zone-b") != nil {
+		t.Errorf("loadbalancer containers of the zones not deleted")
+	}
+}
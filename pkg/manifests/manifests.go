@@ -0,0 +1,194 @@
+// Package manifests generates the Kubernetes manifests required to run
+// cloud-provider-kind as a Pod inside the kind cluster it manages.
+//
+// The Pod talks to the container runtime of the host through its socket, so the
+// kind nodes must have the socket mounted, ex:
+//
+//	kind: Cluster
+//	apiVersion: kind.x-k8s.io/v1alpha4
+//	nodes:
+//	- role: control-plane
+//	  extraMounts:
+//	  - hostPath: /var/run/docker.sock
+//	    containerPath: /var/run/docker.sock
+package manifests
+
+import (
+	"fmt"
+	"io"
+	"text/template"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// DefaultNamespace is the namespace where cloud-provider-kind is deployed
+	DefaultNamespace = "kube-system"
+	// DefaultImage is the cloud-provider-kind image
+	DefaultImage = "registry.k8s.io/cloud-provider-kind/cloud-controller-manager:latest"
+	// DefaultSocketPath is the path of the container runtime socket on the nodes
+	DefaultSocketPath = "/var/run/docker.sock"
+	// ClusterNameEnv is the environment variable used to pass the kind cluster name to the Pod
+	ClusterNameEnv = "KIND_CLUSTER_NAME"
+	// HealthPort is the port used by the Pod to serve the health probes
+	HealthPort = 10298
+)
+
+// Options are the parameters used to generate the manifests
+type Options struct {
+	ClusterName string
+	Namespace   string
+	Image       string
+	SocketPath  string
+	// Verbosity is the log level of the controller
+	Verbosity int
+}
+
+const manifestsTemplate = `---
+apiVersion: v1
+kind: ServiceAccount
+metadata:
+  name: cloud-provider-kind
+  namespace: {{ .Namespace }}
+---
+apiVersion: rbac.authorization.k8s.io/v1
+kind: ClusterRole
+metadata:
+  name: cloud-provider-kind
+rules:
+- apiGroups: [""]
+  resources: ["nodes"]
+  verbs: ["get", "list", "watch", "update", "patch"]
+- apiGroups: [""]
+  resources: ["nodes/status"]
+  verbs: ["patch", "update"]
+- apiGroups: [""]
+  resources: ["services"]
+  verbs: ["get", "list", "watch", "update", "patch"]
+- apiGroups: [""]
+  resources: ["services/status"]
+  verbs: ["patch", "update"]
+- apiGroups: [""]
+  resources: ["secrets"]
+  verbs: ["get", "create", "update"]
+- apiGroups: [""]
+  resources: ["events"]
+  verbs: ["create", "patch", "update"]
+- apiGroups: ["discovery.k8s.io"]
+  resources: ["endpointslices"]
+  verbs: ["get", "list", "watch"]
+- apiGroups: ["coordination.k8s.io"]
+  resources: ["leases"]
+  verbs: ["get", "list", "watch", "create", "update", "patch"]
+- apiGroups: ["apiextensions.k8s.io"]
+  resources: ["customresourcedefinitions"]
+  verbs: ["get", "create"]
+- apiGroups: ["kind.x-k8s.io"]
+  resources: ["loadbalancerstatuses"]
+  verbs: ["get", "list", "create", "patch", "delete"]
+---
+apiVersion: rbac.authorization.k8s.io/v1
+kind: ClusterRoleBinding
+metadata:
+  name: cloud-provider-kind
+roleRef:
+  apiGroup: rbac.authorization.k8s.io
+  kind: ClusterRole
+  name: cloud-provider-kind
+subjects:
+- kind: ServiceAccount
+  name: cloud-provider-kind
+  namespace: {{ .Namespace }}
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: cloud-provider-kind
+  namespace: {{ .Namespace }}
+  labels:
+    app: cloud-provider-kind
+spec:
+  replicas: 1
+  strategy:
+    type: Recreate
+  selector:
+    matchLabels:
+      app: cloud-provider-kind
+  template:
+    metadata:
+      labels:
+        app: cloud-provider-kind
+    spec:
+      serviceAccountName: cloud-provider-kind
+      nodeSelector:
+        node-role.kubernetes.io/control-plane: ""
+      tolerations:
+      - key: node-role.kubernetes.io/control-plane
+        operator: Exists
+        effect: NoSchedule
+      - key: node.cloudprovider.kubernetes.io/uninitialized
+        operator: Exists
+        effect: NoSchedule
+      containers:
+      - name: cloud-provider-kind
+        image: {{ .Image }}
+        args:
+        - --v={{ .Verbosity }}
+        - --health-address=:{{ .HealthPort }}
+        env:
+        - name: {{ .ClusterNameEnv }}
+          value: {{ .ClusterName }}
+        livenessProbe:
+          httpGet:
+            path: /healthz
+            port: {{ .HealthPort }}
+          periodSeconds: 10
+          failureThreshold: 6
+        readinessProbe:
+          httpGet:
+            path: /readyz
+            port: {{ .HealthPort }}
+          periodSeconds: 10
+        volumeMounts:
+        - name: runtime-socket
+          mountPath: /var/run/docker.sock
+      volumes:
+      - name: runtime-socket
+        hostPath:
+          path: {{ .SocketPath }}
+          type: Socket
+`
+
+// Generate writes the manifests to deploy cloud-provider-kind in-cluster
+func Generate(w io.Writer, opts Options) error {
+	if opts.ClusterName == "" {
+		return fmt.Errorf("cluster name can not be empty")
+	}
+	if opts.Namespace == "" {
+		opts.Namespace = DefaultNamespace
+	}
+	if opts.Image == "" {
+		opts.Image = DefaultImage
+	}
+	if opts.SocketPath == "" {
+		opts.SocketPath = DefaultSocketPath
+	}
+	t, err := template.New("manifests").Parse(manifestsTemplate)
+	if err != nil {
+		return errors.Wrap(err, "failed to parse manifests template")
+	}
+	data := struct {
+		Options
+		ClusterNameEnv string
+		HealthPort     int
+	}{
+		Options:        opts,
+		ClusterNameEnv: ClusterNameEnv,
+		HealthPort:     HealthPort,
+	}
+	err = t.Execute(w, data)
+	if err != nil {
+		return errors.Wrap(err, "error executing manifests template")
+	}
+	return nil
+}
@@ -0,0 +1,100 @@
+package manifests
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	rbacv1 "k8s.io/api/rbac/v1"
+	"sigs.k8s.io/yaml"
+)
+
+func TestGenerate(t *testing.T) {
+	var buf bytes.Buffer
+	err := Generate(&buf, Options{ClusterName: "test", Verbosity: 2})
+	if err != nil {
+		t.Fatalf("Generate() error = %v", err)
+	}
+
+	kinds := []string{}
+	for _, doc := range strings.Split(buf.String(), "---\n") {
+		if strings.TrimSpace(doc) == "" {
+			continue
+		}
+		obj := map[string]interface{}{}
+		if err := yaml.Unmarshal([]byte(doc), &obj); err != nil {
+			t.Fatalf("invalid manifest %s: %v", doc, err)
+		}
+		kinds = append(kinds, obj["kind"].(string))
+	}
+	want := []string{"ServiceAccount", "ClusterRole", "ClusterRoleBinding", "Deployment"}
+	if strings.Join(kinds, ",") != strings.Join(want, ",") {
+		t.Errorf("expected kinds %v, got %v", want, kinds)
+	}
+	if !strings.Contains(buf.String(), "value: test") {
+		t.Errorf("cluster name not found in the manifests:\n%s", buf.String())
+	}
+	if !strings.Contains(buf.String(), "path: /readyz") {
+		t.Errorf("readiness probe not found in the manifests:\n%s", buf.String())
+	}
+	if !strings.Contains(buf.String(), "namespace: "+DefaultNamespace) {
+		t.Errorf("default namespace not found in the manifests:\n%s", buf.String())
+	}
+}
+
+// TestGenerateClusterRole checks the resources the controller has informers for can be
+// listed and watched
+func TestGenerateClusterRole(t *testing.T) {
+	var buf bytes.Buffer
+	if err := Generate(&buf, Options{ClusterName: "test"}); err != nil {
+		t.Fatalf("Generate() error = %v", err)
+	}
+	var role *rbacv1.ClusterRole
+	for _, doc := range strings.Split(buf.String(), "---\n") {
+		obj := &rbacv1.ClusterRole{}
+		if err := yaml.Unmarshal([]byte(doc), obj); err != nil {
+			t.Fatalf("invalid manifest %s: %v", doc, err)
+		}
+		if obj.Kind == "ClusterRole" {
+			role = obj
+		}
+	}
+	if role == nil {
+		t.Fatalf("ClusterRole not found in the manifests:\n%s", buf.String())
+	}
+
+	tests := []struct {
+		apiGroup string
+		resource string
+	}{
+		{apiGroup: "", resource: "nodes"},
+		{apiGroup: "", resource: "services"},
+		{apiGroup: "discovery.k8s.io", resource: "endpointslices"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.resource, func(t *testing.T) {
+			verbs := map[string]bool{}
+			for _, rule := range role.Rules {
+				for _, resource := range rule.Resources {
+					if resource == tt.resource && len(rule.APIGroups) == 1 && rule.APIGroups[0] == tt.apiGroup {
+						for _, verb := range rule.Verbs {
+							verbs[verb] = true
+						}
+					}
+				}
+			}
+			for _, verb := range []string{"get", "list", "watch"} {
+				if !verbs[verb] {
+					t.Errorf("the ClusterRole does not allow to %s %s", verb, tt.resource)
+				}
+			}
+		})
+	}
+}
+
+func TestGenerateNoClusterName(t *testing.T) {
+	var buf bytes.Buffer
+	if err := Generate(&buf, Options{}); err == nil {
+		t.Errorf("expected error with empty cluster name")
+	}
+}
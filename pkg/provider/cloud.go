@@ -1,19 +1,73 @@
+// Package provider implements the cloud provider interface for a kind cluster, the programs
+// embedding it, ex. testing frameworks and kind wrappers, create it with New and run it with
+// the cloud-provider controllers, or run all the clusters with the controller package.
 package provider
 
 import (
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+	discoverylisters "k8s.io/client-go/listers/discovery/v1"
+	cloudprovider "k8s.io/cloud-provider"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/kind/pkg/cluster"
+
+	"sigs.k8s.io/cloud-provider-kind/pkg/config"
 	"sigs.k8s.io/cloud-provider-kind/pkg/constants"
 	"sigs.k8s.io/cloud-provider-kind/pkg/loadbalancer"
+)
 
-	cloudprovider "k8s.io/cloud-provider"
+// Config is the configuration of the cloud provider of a kind cluster, the options shared by
+// all the clusters are in config.DefaultConfig
+type Config struct {
+	// ClusterName is the name of the kind cluster
+	ClusterName string
+	// KindClient looks up the nodes of the cluster, a client of the default container runtime
+	// if nil
+	KindClient *cluster.Provider
+	// KubeClient is the client of the apiserver of the cluster
+	KubeClient kubernetes.Interface
+	// EndpointSliceLister looks up the endpoints of the Services, the Services are not
+	// checked for endpoints if nil
+	EndpointSliceLister discoverylisters.EndpointSliceLister
+	// DevDomain publishes the loadbalancers on the local development domain, optional
+	DevDomain *loadbalancer.DevDomain
+	// Hooks are notified of the lifecycle of the loadbalancers, optional
+	Hooks Hooks
+}
 
-	"sigs.k8s.io/kind/pkg/cluster"
-)
+// Hooks are called after the operations on the loadbalancers, so the programs embedding the
+// provider can follow them without watching the Services, ex. to wait for a loadbalancer in
+// a test. They are called synchronously by the service controller and must not block. The
+// nil hooks are skipped.
+type Hooks struct {
+	// LoadBalancerEnsured is called after the loadbalancer of the Service is provisioned or
+	// updated, with its status
+	LoadBalancerEnsured func(clusterName string, service *v1.Service, status *v1.LoadBalancerStatus)
+	// LoadBalancerDeleted is called after the loadbalancer of the Service is deleted
+	LoadBalancerDeleted func(clusterName string, service *v1.Service)
+	// LoadBalancerFailed is called when an operation on the loadbalancer of the Service fails,
+	// it is retried by the service controller
+	LoadBalancerFailed func(clusterName string, service *v1.Service, err error)
+}
 
-func New(clusterName string, kindClient *cluster.Provider) cloudprovider.Interface {
+// New returns the cloud provider of the kind cluster
+func New(cfg Config) cloudprovider.Interface {
+	// the node metadata is validated at startup
+	metadata, err := parseNodeMetadata(config.DefaultConfig.NodeMetadata)
+	if err != nil {
+		klog.Errorf("Ignoring the node metadata: %v", err)
+		metadata = &nodeMetadata{}
+	}
+	kindClient := cfg.KindClient
+	if kindClient == nil {
+		kindClient = cluster.NewProvider()
+	}
 	return &cloud{
-		clusterName:  clusterName,
+		clusterName:  cfg.ClusterName,
 		kindClient:   kindClient,
-		lbController: loadbalancer.NewServer(),
+		lbController: loadbalancer.NewServer(cfg.KubeClient, cfg.EndpointSliceLister, cfg.DevDomain),
+		metadata:     metadata,
+		hooks:        cfg.Hooks,
 	}
 }
 
@@ -24,6 +78,8 @@ type cloud struct {
 	clusterName  string // name of the kind cluster
 	kindClient   *cluster.Provider
 	lbController cloudprovider.LoadBalancer
+	metadata     *nodeMetadata // synthetic instance types, topology and labels of the nodes
+	hooks        Hooks
 }
 
 // Initialize passes a Kubernetes clientBuilder interface to the cloud provider
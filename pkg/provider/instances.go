@@ -8,7 +8,9 @@ import (
 	v1 "k8s.io/api/core/v1"
 	cloudprovider "k8s.io/cloud-provider"
 	"k8s.io/klog/v2"
-	"sigs.k8s.io/kind/pkg/cluster/nodes"
+
+	"sigs.k8s.io/cloud-provider-kind/pkg/config"
+	"sigs.k8s.io/cloud-provider-kind/pkg/container"
 )
 
 var _ cloudprovider.InstancesV2 = (*cloud)(nil)
@@ -18,7 +20,7 @@ var errNodeNotFound = errors.New("node not found")
 // InstanceExists returns true if the instance for the given node exists according to the cloud provider.
 func (c *cloud) InstanceExists(ctx context.Context, node *v1.Node) (bool, error) {
 	klog.V(2).Infof("Check if instance %s exists", node.Name)
-	_, err := c.findNodeByName(node.Name)
+	_, err := c.findNodeContainer(node.Name)
 	if err == nil {
 		return true, nil
 	}
@@ -31,7 +33,7 @@ func (c *cloud) InstanceExists(ctx context.Context, node *v1.Node) (bool, error)
 // InstanceShutdown returns true of the container doesn't exist
 func (c *cloud) InstanceShutdown(ctx context.Context, node *v1.Node) (bool, error) {
 	klog.V(2).Infof("Check if instance %s is shutdown", node.Name)
-	_, err := c.findNodeByName(node.Name)
+	_, err := c.findNodeContainer(node.Name)
 	if err == nil {
 		return false, nil
 	}
@@ -45,24 +47,26 @@ func (c *cloud) InstanceShutdown(ctx context.Context, node *v1.Node) (bool, erro
 // translated into specific fields and labels in the Node object on registration.
 func (c *cloud) InstanceMetadata(ctx context.Context, node *v1.Node) (*cloudprovider.InstanceMetadata, error) {
 	klog.V(2).Infof("Check instance metadata for %s", node.Name)
-	n, err := c.findNodeByName(node.Name)
+	name, err := c.findNodeContainer(node.Name)
 	if err != nil {
 		return nil, err
 	}
+	metadata := c.metadata.forNode(name)
 	m := &cloudprovider.InstanceMetadata{
 		// TODO: podman support
-		ProviderID:   fmt.Sprintf("kind://%s/kind/%s", c.clusterName, n.String()), // providerID: kind://<cluster-name>/kind/<node-name>
-		InstanceType: "kind-node",
+		ProviderID:   fmt.Sprintf("kind://%s/kind/%s", c.clusterName, name), // providerID: kind://<cluster-name>/kind/<node-name>
+		InstanceType: metadata.InstanceType,
 		NodeAddresses: []v1.NodeAddress{
 			{
 				Type:    v1.NodeHostName,
-				Address: n.String(),
+				Address: name,
 			},
 		},
-		Zone:   "",
-		Region: "",
+		Zone:             metadata.Zone,
+		Region:           metadata.Region,
+		AdditionalLabels: metadata.Labels,
 	}
-	ipv4, ipv6, err := n.IP()
+	ipv4, ipv6, err := container.IPs(name)
 	if err != nil {
 		return nil, err
 	}
@@ -72,19 +76,42 @@ func (c *cloud) InstanceMetadata(ctx context.Context, node *v1.Node) (*cloudprov
 	if ipv6 != "" {
 		m.NodeAddresses = append(m.NodeAddresses, v1.NodeAddress{Type: v1.NodeInternalIP, Address: ipv6})
 	}
+	m.NodeAddresses = append(m.NodeAddresses, externalAddresses(metadata, ipv4, ipv6)...)
 	klog.V(2).Infof("instance metadata for %s: %#v", node.Name, m)
 	return m, nil
 }
 
-func (c *cloud) findNodeByName(name string) (nodes.Node, error) {
-	nodes, err := c.kindClient.ListNodes(c.clusterName)
-	if err != nil {
-		return nil, fmt.Errorf("no nodes founds")
+// externalAddresses returns the ExternalIP addresses of the node, the addresses of the node
+// metadata or, with --node-external-ip, the container network addresses like the clouds
+// that publish the same address as internal and external
+func externalAddresses(metadata instanceMetadata, ipv4, ipv6 string) []v1.NodeAddress {
+	addresses := []v1.NodeAddress{}
+	if len(metadata.ExternalIPs) > 0 {
+		for _, ip := range metadata.ExternalIPs {
+			addresses = append(addresses, v1.NodeAddress{Type: v1.NodeExternalIP, Address: ip})
+		}
+		return addresses
 	}
-	for _, n := range nodes {
-		if n.String() == name {
-			return n, nil
+	if !config.DefaultConfig.NodeExternalIP {
+		return addresses
+	}
+	for _, ip := range []string{ipv4, ipv6} {
+		if ip != "" {
+			addresses = append(addresses, v1.NodeAddress{Type: v1.NodeExternalIP, Address: ip})
 		}
 	}
-	return nil, fmt.Errorf("node with name %s does not exist on cluster %s", name, c.clusterName)
+	return addresses
+}
+
+// findNodeContainer returns the container of the node, resolved with the node resolver of
+// the cluster, the kind node containers have the names of the nodes
+func (c *cloud) findNodeContainer(name string) (string, error) {
+	node, err := container.NodeContainer(c.clusterName, name)
+	if err != nil {
+		return "", fmt.Errorf("no nodes founds: %w", err)
+	}
+	if node == "" {
+		return "", fmt.Errorf("node with name %s does not exist on cluster %s", name, c.clusterName)
+	}
+	return node, nil
 }
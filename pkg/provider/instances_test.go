@@ -0,0 +1,57 @@
+package provider
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	v1 "k8s.io/api/core/v1"
+
+	"sigs.k8s.io/cloud-provider-kind/pkg/config"
+)
+
+func Test_externalAddresses(t *testing.T) {
+	tests := []struct {
+		name           string
+		nodeExternalIP bool
+		metadata       instanceMetadata
+		want           []v1.NodeAddress
+	}{
+		{
+			name: "disabled",
+			want: []v1.NodeAddress{},
+		},
+		{
+			name:           "container addresses",
+			nodeExternalIP: true,
+			want: []v1.NodeAddress{
+				{Type: v1.NodeExternalIP, Address: "172.18.0.2"},
+				{Type: v1.NodeExternalIP, Address: "fc00:f853:ccd:e793::2"},
+			},
+		},
+		{
+			name:           "node metadata",
+			nodeExternalIP: true,
+			metadata:       instanceMetadata{ExternalIPs: []string{"203.0.113.10"}},
+			want: []v1.NodeAddress{
+				{Type: v1.NodeExternalIP, Address: "203.0.113.10"},
+			},
+		},
+		{
+			name:     "node metadata without the flag",
+			metadata: instanceMetadata{ExternalIPs: []string{"203.0.113.10"}},
+			want: []v1.NodeAddress{
+				{Type: v1.NodeExternalIP, Address: "203.0.113.10"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer func(value bool) { config.DefaultConfig.NodeExternalIP = value }(config.DefaultConfig.NodeExternalIP)
+			config.DefaultConfig.NodeExternalIP = tt.nodeExternalIP
+			got := externalAddresses(tt.metadata, "172.18.0.2", "fc00:f853:ccd:e793::2")
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("externalAddresses() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
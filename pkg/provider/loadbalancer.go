@@ -2,43 +2,105 @@ package provider
 
 import (
 	"context"
+	"fmt"
 
 	v1 "k8s.io/api/core/v1"
 	cloudprovider "k8s.io/cloud-provider"
 	"k8s.io/klog/v2"
+
+	"sigs.k8s.io/cloud-provider-kind/pkg/loadbalancer"
 )
 
 var _ cloudprovider.LoadBalancer = &cloud{}
+var _ loadbalancer.Healer = &cloud{}
+var _ loadbalancer.Recreator = &cloud{}
 
 // GetLoadBalancer returns whether the specified load balancer exists, and if so, what its status is.
 // Parameter 'clusterName' is the name of the cluster as presented to kube-controller-manager
 func (c *cloud) GetLoadBalancer(ctx context.Context, clusterName string, service *v1.Service) (status *v1.LoadBalancerStatus, exists bool, err error) {
-	klog.V(2).Infof("Get LoadBalancer cluster: %s service: %s", clusterName, service.Name)
+	klog.V(2).InfoS("Get LoadBalancer", "cluster", clusterName, "service", klog.KObj(service))
 	return c.lbController.GetLoadBalancer(ctx, clusterName, service)
 }
 
 // GetLoadBalancerName returns the name of the load balancer.
 func (c *cloud) GetLoadBalancerName(ctx context.Context, clusterName string, service *v1.Service) string {
-	klog.V(2).Infof("Get LoadBalancerNmae cluster: %s service: %s", clusterName, service.Name)
+	klog.V(2).InfoS("Get LoadBalancerName", "cluster", clusterName, "service", klog.KObj(service))
 	return c.lbController.GetLoadBalancerName(ctx, clusterName, service)
 }
 
 // EnsureLoadBalancer creates a new load balancer 'name', or updates the existing one. Returns the status of the balancer
 func (c *cloud) EnsureLoadBalancer(ctx context.Context, clusterName string, service *v1.Service, nodes []*v1.Node) (*v1.LoadBalancerStatus, error) {
-	klog.V(2).Infof("Ensure LoadBalancer cluster: %s service: %s", clusterName, service.Name)
-	return c.lbController.EnsureLoadBalancer(ctx, clusterName, service, nodes)
+	klog.V(2).InfoS("Ensure LoadBalancer", "cluster", clusterName, "service", klog.KObj(service))
+	if err := simulateCloudAPI(ctx, "EnsureLoadBalancer"); err != nil {
+		c.loadBalancerFailed(clusterName, service, err)
+		return nil, err
+	}
+	status, err := c.lbController.EnsureLoadBalancer(ctx, clusterName, service, nodes)
+	if err != nil {
+		c.loadBalancerFailed(clusterName, service, err)
+		return nil, err
+	}
+	if c.hooks.LoadBalancerEnsured != nil {
+		c.hooks.LoadBalancerEnsured(clusterName, service, status)
+	}
+	return status, nil
 }
 
 // UpdateLoadBalancer updates hosts under the specified load balancer.
 func (c *cloud) UpdateLoadBalancer(ctx context.Context, clusterName string, service *v1.Service, nodes []*v1.Node) error {
-	klog.V(2).Infof("Update LoadBalancer cluster: %s service: %s", clusterName, service.Name)
-	return c.lbController.UpdateLoadBalancer(ctx, clusterName, service, nodes)
+	klog.V(2).InfoS("Update LoadBalancer", "cluster", clusterName, "service", klog.KObj(service))
+	err := c.lbController.UpdateLoadBalancer(ctx, clusterName, service, nodes)
+	if err != nil {
+		c.loadBalancerFailed(clusterName, service, err)
+	}
+	return err
 }
 
 // EnsureLoadBalancerDeleted deletes the specified load balancer if it
 // exists, returning nil if the load balancer specified either didn't exist or
 // was successfully deleted.
 func (c *cloud) EnsureLoadBalancerDeleted(ctx context.Context, clusterName string, service *v1.Service) error {
-	klog.V(2).Infof("Ensure LoadBalancer deleted cluster: %s service: %s", clusterName, service.Name)
-	return c.lbController.EnsureLoadBalancerDeleted(ctx, clusterName, service)
+	klog.V(2).InfoS("Ensure LoadBalancer deleted", "cluster", clusterName, "service", klog.KObj(service))
+	if err := simulateCloudAPI(ctx, "EnsureLoadBalancerDeleted"); err != nil {
+		c.loadBalancerFailed(clusterName, service, err)
+		return err
+	}
+	err := c.lbController.EnsureLoadBalancerDeleted(ctx, clusterName, service)
+	if err != nil {
+		c.loadBalancerFailed(clusterName, service, err)
+		return err
+	}
+	if c.hooks.LoadBalancerDeleted != nil {
+		c.hooks.LoadBalancerDeleted(clusterName, service)
+	}
+	return nil
+}
+
+// loadBalancerFailed calls the hook of the failed operations, if any
+func (c *cloud) loadBalancerFailed(clusterName string, service *v1.Service, err error) {
+	if c.hooks.LoadBalancerFailed != nil {
+		c.hooks.LoadBalancerFailed(clusterName, service, err)
+	}
+}
+
+// HealLoadBalancer recovers the load balancer of the Service after its proxy exited, if the
+// load balancer controller supports it.
+func (c *cloud) HealLoadBalancer(ctx context.Context, clusterName string, service *v1.Service) (loadbalancer.HealAction, error) {
+	healer, ok := c.lbController.(loadbalancer.Healer)
+	if !ok {
+		return loadbalancer.HealNone, nil
+	}
+	klog.V(2).InfoS("Heal LoadBalancer", "cluster", clusterName, "service", klog.KObj(service))
+	return healer.HealLoadBalancer(ctx, clusterName, service)
+}
+
+// RecreateLoadBalancer deletes the load balancer of the Service to create it again on the
+// next sync, if the load balancer controller supports it.
+func (c *cloud) RecreateLoadBalancer(ctx context.Context, clusterName string, service *v1.Service) error {
+	recreator, ok := c.lbController.(loadbalancer.Recreator)
+	if !ok {
+		return fmt.Errorf("the load balancer controller can not recreate load balancers")
+	}
+	klog.V(2).InfoS("Recreate LoadBalancer", "cluster", clusterName, "service", klog.KObj(service))
+	return recreator.RecreateLoadBalancer(ctx, clusterName, service)
 }
@@ -0,0 +1,80 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// fakeLoadBalancer provisions the loadbalancers with the status, or fails with err
+type fakeLoadBalancer struct {
+	status *v1.LoadBalancerStatus
+	err    error
+}
+
+func (f *fakeLoadBalancer) GetLoadBalancer(ctx context.Context, clusterName string, service *v1.Service) (*v1.LoadBalancerStatus, bool, error) {
+	return f.status, f.status != nil, f.err
+}
+
+func (f *fakeLoadBalancer) GetLoadBalancerName(ctx context.Context, clusterName string, service *v1.Service) string {
+	return service.Name
+}
+
+func (f *fakeLoadBalancer) EnsureLoadBalancer(ctx context.Context, clusterName string, service *v1.Service, nodes []*v1.Node) (*v1.LoadBalancerStatus, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	return f.status, nil
+}
+
+func (f *fakeLoadBalancer) UpdateLoadBalancer(ctx context.Context, clusterName string, service *v1.Service, nodes []*v1.Node) error {
+	return f.err
+}
+
+func (f *fakeLoadBalancer) EnsureLoadBalancerDeleted(ctx context.Context, clusterName string, service *v1.Service) error {
+	return f.err
+}
+
+func TestHooks(t *testing.T) {
+	calls := []string{}
+	hooks := Hooks{
+		LoadBalancerEnsured: func(clusterName string, service *v1.Service, status *v1.LoadBalancerStatus) {
+			calls = append(calls, "ensured "+clusterName+"/"+service.Name+" "+status.Ingress[0].IP)
+		},
+		LoadBalancerDeleted: func(clusterName string, service *v1.Service) {
+			calls = append(calls, "deleted "+clusterName+"/"+service.Name)
+		},
+		LoadBalancerFailed: func(clusterName string, service *v1.Service, err error) {
+			calls = append(calls, "failed "+clusterName+"/"+service.Name+": "+err.Error())
+		},
+	}
+	lb := &fakeLoadBalancer{status: &v1.LoadBalancerStatus{Ingress: []v1.LoadBalancerIngress{{IP: "172.18.0.5"}}}}
+	c := &cloud{clusterName: "kind", lbController: lb, hooks: hooks}
+	service := &v1.Service{ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default"}}
+
+	_, _ = c.EnsureLoadBalancer(context.Background(), "kind", service, nil)
+	_ = c.EnsureLoadBalancerDeleted(context.Background(), "kind", service)
+	lb.err = errors.New("container runtime unavailable")
+	_, _ = c.EnsureLoadBalancer(context.Background(), "kind", service, nil)
+	_ = c.UpdateLoadBalancer(context.Background(), "kind", service, nil)
+
+	want := []string{
+		"ensured kind/web 172.18.0.5",
+		"deleted kind/web",
+		"failed kind/web: container runtime unavailable",
+		"failed kind/web: container runtime unavailable",
+	}
+	if diff := cmp.Diff(want, calls); diff != "" {
+		t.Errorf("hooks calls mismatch (-want +got):\n%s", diff)
+	}
+
+	// the hooks are optional
+	c.hooks = Hooks{}
+	if _, err := c.EnsureLoadBalancer(context.Background(), "kind", service, nil); err == nil {
+		t.Errorf("EnsureLoadBalancer() did not fail")
+	}
+}
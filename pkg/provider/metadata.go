@@ -0,0 +1,165 @@
+package provider
+
+import (
+	"fmt"
+	"net/netip"
+	"os"
+	"path"
+
+	"k8s.io/apimachinery/pkg/util/validation"
+	"sigs.k8s.io/yaml"
+)
+
+// defaultInstanceType is the instance type of the nodes without a node metadata rule
+const defaultInstanceType = "kind-node"
+
+// nodeMetadata is the file of synthetic instance types, topology and labels assigned to the
+// nodes through the InstancesV2 metadata, to test the schedulers, the autoscaler simulators
+// and the cost tools against kind, ex.
+//
+//	instanceTypes:
+//	  kind.medium:
+//	    labels:
+//	      kind.x-k8s.io/cpu: "2"
+//	      kind.x-k8s.io/memory: 4Gi
+//	nodes:
+//	- name: "*"
+//	  region: kind-region
+//	- name: "*-worker"
+//	  instanceType: kind.medium
+//	  zone: kind-region-a
+//	- name: kind-worker2
+//	  externalIPs: ["203.0.113.10"]
+type nodeMetadata struct {
+	// InstanceTypes are the labels of each instance type, ex. the capacity
+	InstanceTypes map[string]instanceType `json:"instanceTypes,omitempty"`
+	// Nodes are the rules applied in order to the nodes with a matching name, the later
+	// rules override the values of the previous ones and the labels are merged
+	Nodes []nodeRule `json:"nodes,omitempty"`
+}
+
+type instanceType struct {
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+type nodeRule struct {
+	// Name is a shell pattern of the node names, ex. kind-worker*
+	Name         string            `json:"name"`
+	InstanceType string            `json:"instanceType,omitempty"`
+	Region       string            `json:"region,omitempty"`
+	Zone         string            `json:"zone,omitempty"`
+	Labels       map[string]string `json:"labels,omitempty"`
+	// ExternalIPs are the ExternalIP addresses of the nodes, instead of the container
+	// network addresses published with --node-external-ip
+	ExternalIPs []string `json:"externalIPs,omitempty"`
+}
+
+// instanceMetadata is the metadata of a node after applying the rules
+type instanceMetadata struct {
+	InstanceType string
+	Region       string
+	Zone         string
+	Labels       map[string]string
+	ExternalIPs  []string
+}
+
+// LoadNodeMetadata reads a node metadata file and checks it is valid, it returns the text
+// of the file that is parsed again by each cluster provider
+func LoadNodeMetadata(path string) (string, error) {
+	text, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read the node metadata: %w", err)
+	}
+	if _, err := parseNodeMetadata(string(text)); err != nil {
+		return "", err
+	}
+	return string(text), nil
+}
+
+// parseNodeMetadata parses the text of a node metadata file, an empty text has no rules
+func parseNodeMetadata(text string) (*nodeMetadata, error) {
+	metadata := &nodeMetadata{}
+	if err := yaml.UnmarshalStrict([]byte(text), metadata); err != nil {
+		return nil, fmt.Errorf("invalid node metadata: %w", err)
+	}
+	for name, instanceType := range metadata.InstanceTypes {
+		if errs := validation.IsValidLabelValue(name); len(errs) > 0 || name == "" {
+			return nil, fmt.Errorf("invalid instance type %q: %v", name, errs)
+		}
+		if err := validateLabels(instanceType.Labels); err != nil {
+			return nil, fmt.Errorf("invalid labels of instance type %q: %w", name, err)
+		}
+	}
+	for i, rule := range metadata.Nodes {
+		if rule.Name == "" {
+			return nil, fmt.Errorf("node rule %d without name", i)
+		}
+		if _, err := path.Match(rule.Name, ""); err != nil {
+			return nil, fmt.Errorf("invalid name pattern %q of node rule %d: %w", rule.Name, i, err)
+		}
+		for _, value := range []string{rule.InstanceType, rule.Region, rule.Zone} {
+			if errs := validation.IsValidLabelValue(value); len(errs) > 0 {
+				return nil, fmt.Errorf("invalid value %q of node rule %d: %v", value, i, errs)
+			}
+		}
+		if err := validateLabels(rule.Labels); err != nil {
+			return nil, fmt.Errorf("invalid labels of node rule %d: %w", i, err)
+		}
+		for _, ip := range rule.ExternalIPs {
+			if _, err := netip.ParseAddr(ip); err != nil {
+				return nil, fmt.Errorf("invalid external IP %q of node rule %d: %w", ip, i, err)
+			}
+		}
+	}
+	return metadata, nil
+}
+
+func validateLabels(labels map[string]string) error {
+	for key, value := range labels {
+		if errs := validation.IsQualifiedName(key); len(errs) > 0 {
+			return fmt.Errorf("invalid label key %q: %v", key, errs)
+		}
+		if errs := validation.IsValidLabelValue(value); len(errs) > 0 {
+			return fmt.Errorf("invalid value %q of label %q: %v", value, key, errs)
+		}
+	}
+	return nil
+}
+
+// forNode applies the rules matching the node name, the labels of the instance type are
+// added before the labels of the rules
+func (m *nodeMetadata) forNode(name string) instanceMetadata {
+	result := instanceMetadata{InstanceType: defaultInstanceType}
+	ruleLabels := map[string]string{}
+	for _, rule := range m.Nodes {
+		if matched, _ := path.Match(rule.Name, name); !matched {
+			continue
+		}
+		if rule.InstanceType != "" {
+			result.InstanceType = rule.InstanceType
+		}
+		if rule.Region != "" {
+			result.Region = rule.Region
+		}
+		if rule.Zone != "" {
+			result.Zone = rule.Zone
+		}
+		for key, value := range rule.Labels {
+			ruleLabels[key] = value
+		}
+		if len(rule.ExternalIPs) > 0 {
+			result.ExternalIPs = rule.ExternalIPs
+		}
+	}
+	labels := map[string]string{}
+	for key, value := range m.InstanceTypes[result.InstanceType].Labels {
+		labels[key] = value
+	}
+	for key, value := range ruleLabels {
+		labels[key] = value
+	}
+	if len(labels) > 0 {
+		result.Labels = labels
+	}
+	return result
+}
@@ -0,0 +1,98 @@
+package provider
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+const sampleNodeMetadata = `
+instanceTypes:
+  kind.medium:
+    labels:
+      kind.x-k8s.io/cpu: "2"
+      kind.x-k8s.io/memory: 4Gi
+nodes:
+- name: "*"
+  region: kind-region
+  zone: kind-region-a
+- name: "kind-worker*"
+  instanceType: kind.medium
+- name: kind-worker2
+  zone: kind-region-b
+  labels:
+    kind.x-k8s.io/memory: 8Gi
+    team: blue
+- name: kind-worker3
+  externalIPs: ["203.0.113.10", "2001:db8::10"]
+`
+
+func Test_parseNodeMetadata(t *testing.T) {
+	tests := []struct {
+		name    string
+		text    string
+		wantErr bool
+	}{
+		{name: "empty", text: ""},
+		{name: "sample", text: sampleNodeMetadata},
+		{name: "unknown field", text: "nodes:\n- name: a\n  instance: kind.small\n", wantErr: true},
+		{name: "rule without name", text: "nodes:\n- zone: a\n", wantErr: true},
+		{name: "invalid pattern", text: "nodes:\n- name: \"[\"\n", wantErr: true},
+		{name: "invalid zone", text: "nodes:\n- name: a\n  zone: not a zone\n", wantErr: true},
+		{name: "invalid label key", text: "nodes:\n- name: a\n  labels:\n    /cpu: \"2\"\n", wantErr: true},
+		{name: "invalid external IP", text: "nodes:\n- name: a\n  externalIPs: [\"203.0.113\"]\n", wantErr: true},
+		{name: "invalid instance type label", text: "instanceTypes:\n  kind.small:\n    labels:\n      cpu: two cpus\n", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseNodeMetadata(tt.text)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("parseNodeMetadata() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_nodeMetadata_forNode(t *testing.T) {
+	metadata, err := parseNodeMetadata(sampleNodeMetadata)
+	if err != nil {
+		t.Fatalf("parseNodeMetadata() error = %v", err)
+	}
+	tests := []struct {
+		node string
+		want instanceMetadata
+	}{
+		{
+			node: "kind-control-plane",
+			want: instanceMetadata{InstanceType: defaultInstanceType, Region: "kind-region", Zone: "kind-region-a"},
+		},
+		{
+			node: "kind-worker",
+			want: instanceMetadata{InstanceType: "kind.medium", Region: "kind-region", Zone: "kind-region-a",
+				Labels: map[string]string{"kind.x-k8s.io/cpu": "2", "kind.x-k8s.io/memory": "4Gi"}},
+		},
+		{
+			node: "kind-worker2",
+			want: instanceMetadata{InstanceType: "kind.medium", Region: "kind-region", Zone: "kind-region-b",
+				Labels: map[string]string{"kind.x-k8s.io/cpu": "2", "kind.x-k8s.io/memory": "8Gi", "team": "blue"}},
+		},
+		{
+			node: "kind-worker3",
+			want: instanceMetadata{InstanceType: "kind.medium", Region: "kind-region", Zone: "kind-region-a",
+				Labels:      map[string]string{"kind.x-k8s.io/cpu": "2", "kind.x-k8s.io/memory": "4Gi"},
+				ExternalIPs: []string{"203.0.113.10", "2001:db8::10"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.node, func(t *testing.T) {
+			if diff := cmp.Diff(tt.want, metadata.forNode(tt.node)); diff != "" {
+				t.Errorf("forNode() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+
+	empty := (&nodeMetadata{}).forNode("kind-worker")
+	if diff := cmp.Diff(instanceMetadata{InstanceType: defaultInstanceType}, empty); diff != "" {
+		t.Errorf("forNode() without rules mismatch (-want +got):\n%s", diff)
+	}
+}
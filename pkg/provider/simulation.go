@@ -0,0 +1,39 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"time"
+
+	"k8s.io/klog/v2"
+
+	"sigs.k8s.io/cloud-provider-kind/pkg/config"
+	"sigs.k8s.io/cloud-provider-kind/pkg/loadbalancer"
+)
+
+// randFloat returns a random number in [0.0, 1.0), replaced in the tests
+var randFloat = rand.Float64
+
+// simulateCloudAPI injects the --provisioning-latency and --provisioning-failure-rate of
+// the slow and flaky cloud APIs before the loadbalancer operations. The latency varies
+// randomly between half and one and a half times the configured one.
+func simulateCloudAPI(ctx context.Context, operation string) error {
+	latency := config.DefaultConfig.ProvisioningLatency
+	if latency > 0 {
+		delay := time.Duration(float64(latency) * (0.5 + randFloat()))
+		klog.FromContext(ctx).V(4).Info("Simulating cloud API latency", "operation", operation, "delay", delay)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+	}
+	if rate := config.DefaultConfig.ProvisioningFailureRate; rate > 0 && randFloat() < rate {
+		return &loadbalancer.ProvisioningError{
+			Reason: loadbalancer.ReasonInjectedFailure,
+			Err:    fmt.Errorf("simulated cloud API failure of %s, --provisioning-failure-rate is %g", operation, rate),
+		}
+	}
+	return nil
+}
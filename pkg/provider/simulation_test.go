@@ -0,0 +1,59 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"sigs.k8s.io/cloud-provider-kind/pkg/config"
+	"sigs.k8s.io/cloud-provider-kind/pkg/loadbalancer"
+)
+
+func Test_simulateCloudAPI(t *testing.T) {
+	defer func(latency time.Duration, rate float64, random func() float64) {
+		config.DefaultConfig.ProvisioningLatency = latency
+		config.DefaultConfig.ProvisioningFailureRate = rate
+		randFloat = random
+	}(config.DefaultConfig.ProvisioningLatency, config.DefaultConfig.ProvisioningFailureRate, randFloat)
+
+	tests := []struct {
+		name      string
+		latency   time.Duration
+		rate      float64
+		random    float64
+		wantDelay time.Duration
+		wantErr   bool
+	}{
+		{name: "disabled", random: 0},
+		{name: "latency", latency: 40 * time.Millisecond, random: 0.5, wantDelay: 40 * time.Millisecond},
+		{name: "failure", rate: 0.3, random: 0.2, wantErr: true},
+		{name: "success", rate: 0.3, random: 0.5},
+		{name: "always failing", rate: 1, random: 0.99, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config.DefaultConfig.ProvisioningLatency = tt.latency
+			config.DefaultConfig.ProvisioningFailureRate = tt.rate
+			randFloat = func() float64 { return tt.random }
+			start := time.Now()
+			err := simulateCloudAPI(context.Background(), "EnsureLoadBalancer")
+			if elapsed := time.Since(start); elapsed < tt.wantDelay {
+				t.Errorf("simulateCloudAPI() took %v, want at least %v", elapsed, tt.wantDelay)
+			}
+			var provisioningErr *loadbalancer.ProvisioningError
+			if (err != nil) != tt.wantErr || (err != nil && (!errors.As(err, &provisioningErr) || provisioningErr.Reason != loadbalancer.ReasonInjectedFailure)) {
+				t.Errorf("simulateCloudAPI() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	// the delay is interrupted when the sync is cancelled
+	config.DefaultConfig.ProvisioningLatency = time.Hour
+	config.DefaultConfig.ProvisioningFailureRate = 0
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := simulateCloudAPI(ctx, "EnsureLoadBalancerDeleted"); !errors.Is(err, context.Canceled) {
+		t.Errorf("simulateCloudAPI() with a cancelled context error = %v", err)
+	}
+}
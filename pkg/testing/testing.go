@@ -0,0 +1,205 @@
+// Package testing starts cloud-provider-kind from the Go tests of other projects, ex. the
+// e2e suites of ingress controllers and Gateway API implementations running on a kind
+// cluster, so they do not have to build and run the binary. It is usually imported with an
+// alias to not shadow the standard testing package:
+//
+//	import cpktesting "sigs.k8s.io/cloud-provider-kind/pkg/testing"
+//
+//	func TestMain(m *testing.M) {
+//		cpk, err := cpktesting.Start(context.Background(), cpktesting.Options{ClusterName: "kind"})
+//		...
+//		code := m.Run()
+//		cpk.Stop()
+//		os.Exit(code)
+//	}
+package testing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/kind/pkg/log"
+
+	"sigs.k8s.io/cloud-provider-kind/pkg/config"
+	"sigs.k8s.io/cloud-provider-kind/pkg/constants"
+	"sigs.k8s.io/cloud-provider-kind/pkg/controller"
+	"sigs.k8s.io/cloud-provider-kind/pkg/provider"
+)
+
+// DefaultClusterName is the cluster managed if Options.ClusterName is empty, the default
+// name of the kind clusters
+const DefaultClusterName = "kind"
+
+// Options configures the cloud provider started by Start
+type Options struct {
+	// ClusterName is the kind cluster to manage, DefaultClusterName if empty. The other
+	// clusters are not touched.
+	ClusterName string
+	// Config replaces config.DefaultConfig while the cloud provider runs, DefaultConfig()
+	// if nil
+	Config *config.Config
+	// Hooks are notified of the lifecycle of the loadbalancers, optional
+	Hooks provider.Hooks
+	// Logger is the logger of the kind client, a no-op logger if nil
+	Logger log.Logger
+}
+
+// DefaultConfig returns the configuration of the binary run without flags, config.Defaults,
+// except that the loadbalancers are deleted on Stop so the tests do not leak containers
+func DefaultConfig() config.Config {
+	cfg := config.Defaults()
+	cfg.ShutdownPolicy = controller.ShutdownPolicyDelete
+	return cfg
+}
+
+// running is held while a cloud provider runs, the configuration is global so only one can
+// run in the process at a time
+var running sync.Mutex
+
+// CloudProvider is a cloud provider started by Start
+type CloudProvider struct {
+	clusterName string
+	kubeClient  kubernetes.Interface
+	cancel      context.CancelFunc
+	done        chan struct{}
+	restore     func()
+	stopOnce    sync.Once
+}
+
+// Start runs the controllers of the cloud provider for the cluster until Stop is called or
+// the context is cancelled. It fails if the apiserver of the cluster is not reachable or if
+// another cloud provider started by this package is running.
+func Start(ctx context.Context, opts Options) (*CloudProvider, error) {
+	if !running.TryLock() {
+		return nil, errors.New("a cloud provider is already running in this process")
+	}
+	clusterName := opts.ClusterName
+	if clusterName == "" {
+		clusterName = DefaultClusterName
+	}
+	cfg := DefaultConfig()
+	if opts.Config != nil {
+		cfg = *opts.Config
+	}
+	previous := *config.DefaultConfig
+	*config.DefaultConfig = cfg
+	restore := func() {
+		*config.DefaultConfig = previous
+		running.Unlock()
+	}
+
+	logger := opts.Logger
+	if logger == nil {
+		logger = log.NoopLogger{}
+	}
+	c := controller.New(logger)
+	c.SetCluster(clusterName)
+	c.SetHooks(opts.Hooks)
+	kubeClient, err := c.KubeClient(ctx, clusterName)
+	if err != nil {
+		restore()
+		return nil, fmt.Errorf("failed to create kubeClient for cluster %s: %w", clusterName, err)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	p := &CloudProvider{
+		clusterName: clusterName,
+		kubeClient:  kubeClient,
+		cancel:      cancel,
+		done:        make(chan struct{}),
+		restore:     restore,
+	}
+	go func() {
+		defer close(p.done)
+		c.Run(ctx)
+	}()
+	klog.InfoS("Started cloud provider", "cluster", clusterName)
+	return p, nil
+}
+
+// TB is the subset of testing.TB used by StartT
+type TB interface {
+	Helper()
+	Cleanup(func())
+	Fatalf(format string, args ...any)
+}
+
+// StartT starts the cloud provider for the test and stops it when the test and its subtests
+// complete, the test fails if it can not be started
+func StartT(t TB, opts Options) *CloudProvider {
+	t.Helper()
+	p, err := Start(context.Background(), opts)
+	if err != nil {
+		t.Fatalf("failed to start cloud-provider-kind: %v", err)
+	}
+	t.Cleanup(p.Stop)
+	return p
+}
+
+// ClusterName returns the name of the cluster managed by the cloud provider
+func (p *CloudProvider) ClusterName() string {
+	return p.clusterName
+}
+
+// KubeClient returns a client of the apiserver of the cluster
+func (p *CloudProvider) KubeClient() kubernetes.Interface {
+	return p.kubeClient
+}
+
+// WaitForLoadBalancerIP waits until the Service has a loadbalancer IP in its status and
+// returns the first one, the error has the reason of the LoadBalancerReady condition if the
+// loadbalancer could not be provisioned
+func (p *CloudProvider) WaitForLoadBalancerIP(ctx context.Context, namespace, name string, timeout time.Duration) (string, error) {
+	return waitForLoadBalancerIP(ctx, p.kubeClient, namespace, name, timeout)
+}
+
+func waitForLoadBalancerIP(ctx context.Context, kubeClient kubernetes.Interface, namespace, name string, timeout time.Duration) (string, error) {
+	var ip string
+	var service *v1.Service
+	err := wait.PollUntilContextTimeout(ctx, 500*time.Millisecond, timeout, true, func(ctx context.Context) (bool, error) {
+		s, err := kubeClient.CoreV1().Services(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			klog.V(2).InfoS("Failed to get the Service", "service", klog.KRef(namespace, name), "err", err)
+			return false, nil
+		}
+		service = s
+		for _, ingress := range service.Status.LoadBalancer.Ingress {
+			if ingress.IP != "" {
+				ip = ingress.IP
+				return true, nil
+			}
+		}
+		return false, nil
+	})
+	if err != nil {
+		if service != nil {
+			for _, condition := range service.Status.Conditions {
+				if condition.Type == constants.LoadBalancerReadyConditionType && condition.Status == metav1.ConditionFalse {
+					return "", fmt.Errorf("the Service %s/%s has no loadbalancer IP, %s: %s: %w", namespace, name, condition.Reason, condition.Message, err)
+				}
+			}
+		}
+		return "", fmt.Errorf("the Service %s/%s has no loadbalancer IP: %w", namespace, name, err)
+	}
+	return ip, nil
+}
+
+// Stop stops the controllers and waits for the loadbalancers to be deleted, or left
+// running if the configuration preserves them, and restores config.DefaultConfig. It can
+// be called more than once.
+func (p *CloudProvider) Stop() {
+	p.stopOnce.Do(func() {
+		p.cancel()
+		<-p.done
+		p.restore()
+		klog.InfoS("Stopped cloud provider", "cluster", p.clusterName)
+	})
+}
@@ -0,0 +1,118 @@
+package testing
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+
+	"sigs.k8s.io/cloud-provider-kind/pkg/config"
+	"sigs.k8s.io/cloud-provider-kind/pkg/constants"
+	"sigs.k8s.io/cloud-provider-kind/pkg/controller"
+	"sigs.k8s.io/cloud-provider-kind/pkg/loadbalancer"
+)
+
+func Test_waitForLoadBalancerIP(t *testing.T) {
+	var status v1.ServiceStatus
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/namespaces/default/services/web" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(&v1.Service{ // nolint:errcheck
+			ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default"},
+			Status:     status,
+		})
+	}))
+	defer server.Close()
+	client, err := kubernetes.NewForConfig(&rest.Config{Host: server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		service string
+		status  v1.ServiceStatus
+		wantIP  string
+		wantErr string
+	}{
+		{
+			name:    "ready",
+			service: "web",
+			status:  v1.ServiceStatus{LoadBalancer: v1.LoadBalancerStatus{Ingress: []v1.LoadBalancerIngress{{Hostname: "web.kind.local"}, {IP: "172.18.0.5"}}}},
+			wantIP:  "172.18.0.5",
+		},
+		{
+			name:    "not provisioned",
+			service: "web",
+			status: v1.ServiceStatus{Conditions: []metav1.Condition{{
+				Type:    constants.LoadBalancerReadyConditionType,
+				Status:  metav1.ConditionFalse,
+				Reason:  "NoEndpoints",
+				Message: "the Service has no ready endpoints",
+			}}},
+			wantErr: "NoEndpoints",
+		},
+		{
+			name:    "not found",
+			service: "missing",
+			wantErr: "has no loadbalancer IP",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status = tt.status
+			ip, err := waitForLoadBalancerIP(context.Background(), client, "default", tt.service, time.Second)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("waitForLoadBalancerIP() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("waitForLoadBalancerIP() unexpected error: %v", err)
+			}
+			if ip != tt.wantIP {
+				t.Errorf("waitForLoadBalancerIP() = %q, want %q", ip, tt.wantIP)
+			}
+		})
+	}
+}
+
+func TestDefaultConfig(t *testing.T) {
+	cfg := DefaultConfig()
+	if cfg.Concurrency <= 0 || cfg.NodeSyncWindow <= 0 || cfg.ShutdownTimeout <= 0 {
+		t.Errorf("DefaultConfig() does not sync the Services: %+v", cfg)
+	}
+	if cfg.ShutdownPolicy != "delete" {
+		t.Errorf("DefaultConfig() shutdown policy = %q, want delete", cfg.ShutdownPolicy)
+	}
+	if cfg.CaptureImage == "" || cfg.DNSAddress == "" || cfg.ObserveAddress == "" || cfg.CADir == "" {
+		t.Errorf("DefaultConfig() does not have the defaults of the flags: %+v", cfg)
+	}
+}
+
+// TestDefaults checks the enum values of config.Defaults, that can not import the packages
+// that use them, are the constants of those packages
+func TestDefaults(t *testing.T) {
+	cfg := config.Defaults()
+	if cfg.StatusHostname != loadbalancer.StatusHostnameNone {
+		t.Errorf("config.Defaults() status hostname = %q, want %q", cfg.StatusHostname, loadbalancer.StatusHostnameNone)
+	}
+	if cfg.UDPChecksumWorkaround != loadbalancer.UDPChecksumWorkaroundAuto {
+		t.Errorf("config.Defaults() UDP checksum workaround = %q, want %q", cfg.UDPChecksumWorkaround, loadbalancer.UDPChecksumWorkaroundAuto)
+	}
+	if cfg.ShutdownPolicy != controller.ShutdownPolicyPreserve {
+		t.Errorf("config.Defaults() shutdown policy = %q, want %q", cfg.ShutdownPolicy, controller.ShutdownPolicyPreserve)
+	}
+}
@@ -0,0 +1,151 @@
+//go:build e2e
+
+// Package e2e runs the loadbalancers end to end: it creates a kind cluster, runs the
+// cloud-provider-kind binary against it and checks the connectivity of the LoadBalancer
+// Services through the addresses reported in their status. It requires docker, the kind
+// node image is pulled on the first run. The tests are configured with the environment:
+//
+//   - E2E_IP_FAMILY: the ipFamily of the cluster, ipv4 (default), ipv6 or dual.
+//   - E2E_CLUSTER_NAME: the name of the cluster, kccm-e2e by default. If the cluster
+//     already exists it is used and kept after the tests.
+//   - E2E_BINARY: the cloud-provider-kind binary, built from the repository if not set.
+//   - E2E_ARTIFACTS: the directory to store the controller logs, a temporary one by default.
+package e2e
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+	"sigs.k8s.io/kind/pkg/cluster"
+	kindcmd "sigs.k8s.io/kind/pkg/cmd"
+)
+
+var (
+	// clientset of the cluster under test
+	clientset kubernetes.Interface
+	// ipFamily of the cluster under test
+	ipFamily string
+)
+
+func TestMain(m *testing.M) {
+	os.Exit(run(m))
+}
+
+func run(m *testing.M) int {
+	ipFamily = envOrDefault("E2E_IP_FAMILY", "ipv4")
+	if !slices.Contains([]string{"ipv4", "ipv6", "dual"}, ipFamily) {
+		fmt.Fprintf(os.Stderr, "invalid E2E_IP_FAMILY %q\n", ipFamily)
+		return 1
+	}
+	clusterName := envOrDefault("E2E_CLUSTER_NAME", "kccm-e2e")
+	tmpDir, err := os.MkdirTemp("", "cloud-provider-kind-e2e")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to create the temporary directory: %v\n", err)
+		return 1
+	}
+	defer os.RemoveAll(tmpDir)
+	artifacts := envOrDefault("E2E_ARTIFACTS", tmpDir)
+
+	provider := cluster.NewProvider(cluster.ProviderWithLogger(kindcmd.NewLogger()))
+	clusters, err := provider.List()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to list the kind clusters: %v\n", err)
+		return 1
+	}
+	if !slices.Contains(clusters, clusterName) {
+		err = provider.Create(clusterName,
+			cluster.CreateWithRawConfig([]byte(clusterConfig(ipFamily))),
+			cluster.CreateWithKubeconfigPath(filepath.Join(tmpDir, "kubeconfig")),
+			cluster.CreateWithWaitForReady(3*time.Minute),
+		)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to create cluster %s: %v\n", clusterName, err)
+			return 1
+		}
+		defer func() {
+			if err := provider.Delete(clusterName, filepath.Join(tmpDir, "kubeconfig")); err != nil {
+				fmt.Fprintf(os.Stderr, "failed to delete cluster %s: %v\n", clusterName, err)
+			}
+		}()
+	}
+
+	kubeconfig, err := provider.KubeConfig(clusterName, false)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to get the kubeconfig of cluster %s: %v\n", clusterName, err)
+		return 1
+	}
+	config, err := clientcmd.RESTConfigFromKubeConfig([]byte(kubeconfig))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid kubeconfig of cluster %s: %v\n", clusterName, err)
+		return 1
+	}
+	clientset, err = kubernetes.NewForConfig(config)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to create the client of cluster %s: %v\n", clusterName, err)
+		return 1
+	}
+
+	binary := os.Getenv("E2E_BINARY")
+	if binary == "" {
+		binary = filepath.Join(tmpDir, "cloud-provider-kind")
+		build := exec.Command("go", "build", "-o", binary, "sigs.k8s.io/cloud-provider-kind")
+		build.Stdout, build.Stderr = os.Stdout, os.Stderr
+		if err := build.Run(); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to build cloud-provider-kind: %v\n", err)
+			return 1
+		}
+	}
+	logFile, err := os.Create(filepath.Join(artifacts, "cloud-provider-kind.log"))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to create the controller log: %v\n", err)
+		return 1
+	}
+	defer logFile.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	controller := exec.CommandContext(ctx, binary, "-v", "2")
+	controller.Stdout, controller.Stderr = logFile, logFile
+	if err := controller.Start(); err != nil {
+		cancel()
+		fmt.Fprintf(os.Stderr, "failed to run cloud-provider-kind: %v\n", err)
+		return 1
+	}
+	defer func() {
+		cancel()
+		controller.Wait() // nolint:errcheck
+	}()
+
+	code := m.Run()
+	if code != 0 {
+		fmt.Fprintf(os.Stderr, "cloud-provider-kind logs: %s\n", logFile.Name())
+	}
+	return code
+}
+
+// clusterConfig returns the kind config of the cluster, with workers so the control plane
+// node is not used as a loadbalancer backend
+func clusterConfig(ipFamily string) string {
+	return fmt.Sprintf(`kind: Cluster
+apiVersion: kind.x-k8s.io/v1alpha4
+networking:
+  ipFamily: %s
+nodes:
+- role: control-plane
+- role: worker
+- role: worker
+`, ipFamily)
+}
+
+func envOrDefault(key string, value string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return value
+}
@@ -0,0 +1,284 @@
+//go:build e2e
+
+package e2e
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/utils/ptr"
+)
+
+const (
+	agnhostImage = "registry.k8s.io/e2e-test-images/agnhost:2.40"
+	// netexec serves the hostname over http on /hostname and answers the hostname
+	// command over udp
+	netexecPort = 8080
+	// timeout to provision the loadbalancers and to get an answer through them
+	loadBalancerTimeout = 2 * time.Minute
+)
+
+func TestLoadBalancerTCP(t *testing.T) {
+	ctx := context.Background()
+	namespace := createNamespace(ctx, t)
+	pods := createBackends(ctx, t, namespace, 2)
+	service := createService(ctx, t, namespace, func(service *v1.Service) {
+		service.Spec.Ports = []v1.ServicePort{{Name: "http", Protocol: v1.ProtocolTCP, Port: 80, TargetPort: intstr.FromInt32(netexecPort)}}
+	})
+	for _, ip := range waitForIngress(ctx, t, service, 1) {
+		checkTCP(ctx, t, ip, 80, pods)
+	}
+}
+
+func TestLoadBalancerUDP(t *testing.T) {
+	ctx := context.Background()
+	namespace := createNamespace(ctx, t)
+	pods := createBackends(ctx, t, namespace, 2)
+	service := createService(ctx, t, namespace, func(service *v1.Service) {
+		service.Spec.Ports = []v1.ServicePort{
+			{Name: "udp", Protocol: v1.ProtocolUDP, Port: 53, TargetPort: intstr.FromInt32(netexecPort)},
+			{Name: "tcp", Protocol: v1.ProtocolTCP, Port: 80, TargetPort: intstr.FromInt32(netexecPort)},
+		}
+	})
+	for _, ip := range waitForIngress(ctx, t, service, 1) {
+		checkUDP(ctx, t, ip, 53, pods)
+		checkTCP(ctx, t, ip, 80, pods)
+	}
+}
+
+func TestLoadBalancerExternalTrafficPolicyLocal(t *testing.T) {
+	ctx := context.Background()
+	namespace := createNamespace(ctx, t)
+	// a single backend, so only one of the nodes passes the health checks
+	pods := createBackends(ctx, t, namespace, 1)
+	service := createService(ctx, t, namespace, func(service *v1.Service) {
+		service.Spec.ExternalTrafficPolicy = v1.ServiceExternalTrafficPolicyLocal
+		service.Spec.Ports = []v1.ServicePort{{Name: "http", Protocol: v1.ProtocolTCP, Port: 80, TargetPort: intstr.FromInt32(netexecPort)}}
+	})
+	if service.Spec.HealthCheckNodePort == 0 {
+		t.Fatalf("Service %s/%s has no healthCheckNodePort", service.Namespace, service.Name)
+	}
+	for _, ip := range waitForIngress(ctx, t, service, 1) {
+		// the node without backends drops the traffic, every request must get an answer
+		for i := 0; i < 10; i++ {
+			checkTCP(ctx, t, ip, 80, pods)
+		}
+	}
+}
+
+func TestLoadBalancerDualStack(t *testing.T) {
+	if ipFamily != "dual" {
+		t.Skipf("cluster ipFamily is %s", ipFamily)
+	}
+	ctx := context.Background()
+	namespace := createNamespace(ctx, t)
+	pods := createBackends(ctx, t, namespace, 2)
+	service := createService(ctx, t, namespace, func(service *v1.Service) {
+		service.Spec.IPFamilyPolicy = ptr.To(v1.IPFamilyPolicyRequireDualStack)
+		service.Spec.Ports = []v1.ServicePort{{Name: "http", Protocol: v1.ProtocolTCP, Port: 80, TargetPort: intstr.FromInt32(netexecPort)}}
+	})
+	ips := waitForIngress(ctx, t, service, 2)
+	families := sets.New[bool]()
+	for _, ip := range ips {
+		families.Insert(net.ParseIP(ip).To4() != nil)
+		checkTCP(ctx, t, ip, 80, pods)
+	}
+	if families.Len() != 2 {
+		t.Fatalf("expected an IPv4 and an IPv6 ingress address, got %v", ips)
+	}
+}
+
+// createNamespace creates a namespace for the test, it is deleted with the test
+func createNamespace(ctx context.Context, t *testing.T) string {
+	t.Helper()
+	namespace, err := clientset.CoreV1().Namespaces().Create(ctx, &v1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{GenerateName: "e2e-" + strings.ToLower(t.Name()) + "-"},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		t.Fatalf("failed to create the namespace: %v", err)
+	}
+	t.Cleanup(func() {
+		err := clientset.CoreV1().Namespaces().Delete(context.Background(), namespace.Name, metav1.DeleteOptions{})
+		if err != nil {
+			t.Logf("failed to delete namespace %s: %v", namespace.Name, err)
+		}
+	})
+	return namespace.Name
+}
+
+// createBackends runs the netexec pods and returns their names once they are ready
+func createBackends(ctx context.Context, t *testing.T, namespace string, replicas int32) sets.Set[string] {
+	t.Helper()
+	labels := map[string]string{"app": "netexec"}
+	deployment := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: "netexec"},
+		Spec: appsv1.DeploymentSpec{
+			Replicas: ptr.To(replicas),
+			Selector: &metav1.LabelSelector{MatchLabels: labels},
+			Template: v1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: labels},
+				Spec: v1.PodSpec{
+					Containers: []v1.Container{{
+						Name:  "netexec",
+						Image: agnhostImage,
+						Args:  []string{"netexec", fmt.Sprintf("--http-port=%d", netexecPort), fmt.Sprintf("--udp-port=%d", netexecPort)},
+						ReadinessProbe: &v1.Probe{
+							ProbeHandler: v1.ProbeHandler{
+								HTTPGet: &v1.HTTPGetAction{Path: "/healthz", Port: intstr.FromInt32(netexecPort)},
+							},
+						},
+					}},
+				},
+			},
+		},
+	}
+	if _, err := clientset.AppsV1().Deployments(namespace).Create(ctx, deployment, metav1.CreateOptions{}); err != nil {
+		t.Fatalf("failed to create the backends: %v", err)
+	}
+	pods := sets.New[string]()
+	err := wait.PollUntilContextTimeout(ctx, time.Second, loadBalancerTimeout, true, func(ctx context.Context) (bool, error) {
+		list, err := clientset.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{LabelSelector: "app=netexec"})
+		if err != nil {
+			return false, nil
+		}
+		pods = sets.New[string]()
+		for _, pod := range list.Items {
+			for _, condition := range pod.Status.Conditions {
+				if condition.Type == v1.PodReady && condition.Status == v1.ConditionTrue {
+					pods.Insert(pod.Name)
+				}
+			}
+		}
+		return pods.Len() == int(replicas), nil
+	})
+	if err != nil {
+		t.Fatalf("the backends are not ready, ready pods %v: %v", sets.List(pods), err)
+	}
+	return pods
+}
+
+// createService creates a LoadBalancer Service selecting the backends, mutate sets the ports
+// and the other fields specific to the test
+func createService(ctx context.Context, t *testing.T, namespace string, mutate func(*v1.Service)) *v1.Service {
+	t.Helper()
+	service := &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "lb"},
+		Spec: v1.ServiceSpec{
+			Type:     v1.ServiceTypeLoadBalancer,
+			Selector: map[string]string{"app": "netexec"},
+		},
+	}
+	mutate(service)
+	service, err := clientset.CoreV1().Services(namespace).Create(ctx, service, metav1.CreateOptions{})
+	if err != nil {
+		t.Fatalf("failed to create the Service: %v", err)
+	}
+	return service
+}
+
+// waitForIngress waits until the loadbalancer of the Service reports at least count ingress
+// IPs and returns them
+func waitForIngress(ctx context.Context, t *testing.T, service *v1.Service, count int) []string {
+	t.Helper()
+	ips := []string{}
+	err := wait.PollUntilContextTimeout(ctx, time.Second, loadBalancerTimeout, true, func(ctx context.Context) (bool, error) {
+		current, err := clientset.CoreV1().Services(service.Namespace).Get(ctx, service.Name, metav1.GetOptions{})
+		if err != nil {
+			return false, nil
+		}
+		ips = []string{}
+		for _, ingress := range current.Status.LoadBalancer.Ingress {
+			if ingress.IP != "" {
+				ips = append(ips, ingress.IP)
+			}
+		}
+		return len(ips) >= count, nil
+	})
+	if err != nil {
+		t.Fatalf("Service %s/%s has ingress IPs %v, expected %d: %v", service.Namespace, service.Name, ips, count, err)
+	}
+	return ips
+}
+
+// checkTCP gets the hostname from the netexec http server through the loadbalancer, it
+// retries until the loadbalancer is ready and the answer comes from one of the pods
+func checkTCP(ctx context.Context, t *testing.T, ip string, port int, pods sets.Set[string]) {
+	t.Helper()
+	url := "http://" + net.JoinHostPort(ip, strconv.Itoa(port)) + "/hostname"
+	client := &http.Client{Timeout: 5 * time.Second}
+	check(ctx, t, url, pods, func() (string, error) {
+		resp, err := client.Get(url)
+		if err != nil {
+			return "", err
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return "", err
+		}
+		if resp.StatusCode != http.StatusOK {
+			return "", fmt.Errorf("unexpected status %s: %s", resp.Status, body)
+		}
+		return string(body), nil
+	})
+}
+
+// checkUDP sends the hostname command to the netexec udp server through the loadbalancer,
+// it retries until the loadbalancer is ready and the answer comes from one of the pods
+func checkUDP(ctx context.Context, t *testing.T, ip string, port int, pods sets.Set[string]) {
+	t.Helper()
+	address := net.JoinHostPort(ip, strconv.Itoa(port))
+	check(ctx, t, "udp://"+address, pods, func() (string, error) {
+		conn, err := net.DialTimeout("udp", address, 5*time.Second)
+		if err != nil {
+			return "", err
+		}
+		defer conn.Close()
+		if err := conn.SetDeadline(time.Now().Add(5 * time.Second)); err != nil {
+			return "", err
+		}
+		if _, err := conn.Write([]byte("hostname")); err != nil {
+			return "", err
+		}
+		buf := make([]byte, 1024)
+		n, err := conn.Read(buf)
+		if err != nil {
+			return "", err
+		}
+		return string(buf[:n]), nil
+	})
+}
+
+func check(ctx context.Context, t *testing.T, target string, pods sets.Set[string], get func() (string, error)) {
+	t.Helper()
+	var lastErr error
+	err := wait.PollUntilContextTimeout(ctx, time.Second, loadBalancerTimeout, true, func(ctx context.Context) (bool, error) {
+		hostname, err := get()
+		if err != nil {
+			lastErr = err
+			return false, nil
+		}
+		hostname = strings.TrimSpace(hostname)
+		if !pods.Has(hostname) {
+			lastErr = fmt.Errorf("answer %q does not come from the backends %v", hostname, sets.List(pods))
+			return false, nil
+		}
+		return true, nil
+	})
+	if err != nil {
+		t.Fatalf("no answer from %s: %v (last error: %v)", target, err, lastErr)
+	}
+}